@@ -0,0 +1,61 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package appc implements client-side support for app connectors: peers
+// that route traffic for SaaS or other destinations on behalf of the
+// tailnet.
+//
+// When more than one connector offers a route for the same destination,
+// the client spreads destinations across them with rendezvous (highest
+// random weight) hashing rather than sending everything to one connector.
+// Every client independently arrives at the same assignment, and when a
+// connector goes offline only the destinations it was carrying move to
+// other connectors.
+package appc
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net/netip"
+
+	"tailscale.com/tailcfg"
+)
+
+// weight returns the rendezvous hashing weight of (dst, connector).
+func weight(dst netip.Prefix, connector tailcfg.StableNodeID) uint64 {
+	h := sha256.New()
+	b, _ := dst.MarshalBinary()
+	h.Write(b)
+	h.Write([]byte(connector))
+	var sum [sha256.Size]byte
+	return binary.BigEndian.Uint64(h.Sum(sum[:0]))
+}
+
+// isOffline reports whether control has told us that n is offline.
+// Nodes whose online status is unknown are assumed to be online.
+func isOffline(n tailcfg.NodeView) bool {
+	online := n.Online()
+	return online != nil && !*online
+}
+
+// PickConnector returns the index in candidates of the connector that should
+// carry traffic for dst, or -1 if candidates is empty.
+//
+// Connectors that are known to be offline are only picked if no candidate
+// is online, so that traffic fails over to the remaining connectors.
+func PickConnector(dst netip.Prefix, candidates []tailcfg.NodeView) int {
+	best := -1
+	var bestWeight uint64
+	bestOffline := true
+	for i, n := range candidates {
+		w := weight(dst, n.StableID())
+		off := isOffline(n)
+		switch {
+		case best == -1,
+			bestOffline && !off,
+			bestOffline == off && w > bestWeight:
+			best, bestWeight, bestOffline = i, w, off
+		}
+	}
+	return best
+}
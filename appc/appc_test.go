@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package appc
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+func connectors(n int) []tailcfg.NodeView {
+	var ret []tailcfg.NodeView
+	for i := 0; i < n; i++ {
+		ret = append(ret, (&tailcfg.Node{
+			StableID: tailcfg.StableNodeID(fmt.Sprintf("connector%d", i)),
+			Online:   ptr.To(true),
+		}).View())
+	}
+	return ret
+}
+
+func dsts(n int) []netip.Prefix {
+	var ret []netip.Prefix
+	for i := 0; i < n; i++ {
+		ret = append(ret, netip.PrefixFrom(netip.AddrFrom4([4]byte{10, 0, byte(i >> 8), byte(i)}), 32))
+	}
+	return ret
+}
+
+func TestPickConnectorEmpty(t *testing.T) {
+	if got := PickConnector(netip.MustParsePrefix("10.0.0.1/32"), nil); got != -1 {
+		t.Errorf("PickConnector(nil) = %d; want -1", got)
+	}
+}
+
+func TestPickConnectorSpreads(t *testing.T) {
+	cs := connectors(4)
+	count := make([]int, len(cs))
+	const n = 1000
+	for _, dst := range dsts(n) {
+		i := PickConnector(dst, cs)
+		if i2 := PickConnector(dst, cs); i2 != i {
+			t.Fatalf("PickConnector(%v) not deterministic: %d, then %d", dst, i, i2)
+		}
+		count[i]++
+	}
+	for i, c := range count {
+		// Expect roughly n/4 each; allow generous slack.
+		if c < n/8 {
+			t.Errorf("connector %d got %d of %d destinations; distribution too skewed: %v", i, c, n, count)
+		}
+	}
+}
+
+func TestPickConnectorFailover(t *testing.T) {
+	cs := connectors(3)
+	before := map[netip.Prefix]tailcfg.StableNodeID{}
+	for _, dst := range dsts(300) {
+		before[dst] = cs[PickConnector(dst, cs)].StableID()
+	}
+
+	// Take one connector offline. Only its destinations should move.
+	down := cs[1].AsStruct()
+	down.Online = ptr.To(false)
+	cs[1] = down.View()
+	for dst, was := range before {
+		now := cs[PickConnector(dst, cs)].StableID()
+		if now == down.StableID {
+			t.Errorf("%v still assigned to offline connector", dst)
+		}
+		if was != down.StableID && now != was {
+			t.Errorf("%v moved from %v to %v, but %v is still online", dst, was, now, was)
+		}
+	}
+
+	// With all connectors offline, something is still picked.
+	for i := range cs {
+		n := cs[i].AsStruct()
+		n.Online = ptr.To(false)
+		cs[i] = n.View()
+	}
+	for dst := range before {
+		if got := PickConnector(dst, cs); got == -1 {
+			t.Fatalf("PickConnector(%v) = -1 with all connectors offline", dst)
+		}
+	}
+}
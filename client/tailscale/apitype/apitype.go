@@ -20,6 +20,21 @@ type WhoIsResponse struct {
 	CapMap tailcfg.PeerCapMap
 }
 
+// WhoIsBatchRequest is the JSON body POSTed to the LocalAPI whois-batch
+// endpoint.
+type WhoIsBatchRequest struct {
+	// Addrs are the IP:port or IP addresses to look up.
+	Addrs []string
+}
+
+// WhoIsBatchResponse is the JSON type returned by the LocalAPI whois-batch
+// endpoint.
+type WhoIsBatchResponse struct {
+	// Results maps each requested address that matched a node to its
+	// WhoIsResponse. Addresses with no match are absent.
+	Results map[string]*WhoIsResponse
+}
+
 // FileTarget is a node to which files can be sent, and the PeerAPI
 // URL base to do so via.
 type FileTarget struct {
@@ -40,3 +55,15 @@ type SetPushDeviceTokenRequest struct {
 	// PushDeviceToken is the iOS/macOS APNs device token (and any future Android equivalent).
 	PushDeviceToken string
 }
+
+// BugReportPreview is the JSON type returned by the LocalAPI bugreport
+// endpoint in preview mode: the diagnostic payload that would be logged,
+// which can then be submitted unchanged by ID.
+type BugReportPreview struct {
+	// ID identifies the payload to submit. It expires after a few minutes.
+	ID string
+
+	// Lines are the lines that will be logged after the bug report's
+	// marker, already redacted as requested.
+	Lines []string
+}
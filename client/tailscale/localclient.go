@@ -28,9 +28,13 @@
 	"go4.org/mem"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/relaystats"
+	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
@@ -68,6 +72,22 @@ type LocalClient struct {
 	// connecting to the GUI client variants.
 	UseSocketOnly bool
 
+	// APIToken optionally specifies a LocalAPI token, as created by
+	// "tailscale api-token create", to authenticate requests with. If set,
+	// requests are limited to those the token's scopes permit.
+	APIToken string
+
+	// PrefSource optionally says what kind of client this is, such as
+	// ipn.PrefSourceCLI, so that tailscaled attributes the prefs it
+	// changes to it. See ipn.PrefSourceFromHeader for the recognized
+	// values.
+	PrefSource ipn.PrefSource
+
+	// Locale optionally specifies the language, as a BCP 47 tag such as
+	// "de", of the human-readable messages that tailscaled returns, such
+	// as health warnings. It's sent as the Accept-Language header.
+	Locale string
+
 	// tsClient does HTTP requests to the local Tailscale daemon.
 	// It's lazily initialized on first use.
 	tsClient     *http.Client
@@ -127,6 +147,15 @@ func (lc *LocalClient) DoLocalRequest(req *http.Request) (*http.Response, error)
 	if _, token, err := safesocket.LocalTCPPortAndToken(); err == nil {
 		req.SetBasicAuth("", token)
 	}
+	if lc.APIToken != "" {
+		req.Header.Set(ipn.LocalAPITokenHeader, lc.APIToken)
+	}
+	if lc.PrefSource != "" {
+		req.Header.Set(ipn.PrefSourceHeader, string(lc.PrefSource))
+	}
+	if lc.Locale != "" && req.Header.Get("Accept-Language") == "" {
+		req.Header.Set("Accept-Language", lc.Locale)
+	}
 	return lc.tsClient.Do(req)
 }
 
@@ -225,6 +254,39 @@ func (lc *LocalClient) get200(ctx context.Context, path string) ([]byte, error)
 	return lc.send(ctx, "GET", path, 200, nil)
 }
 
+// getIfChanged does a conditional GET of path, returning its body and
+// ETag. If etag is non-empty and still matches, it first waits up to wait
+// for the resource to change, returning a nil body and etag if it doesn't.
+func (lc *LocalClient) getIfChanged(ctx context.Context, path, etag string, wait time.Duration) (body []byte, newETag string, err error) {
+	if etag != "" && wait > 0 {
+		path += "?waitsec=" + fmt.Sprint(int(wait.Seconds()))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+path, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+	slurp, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		return slurp, res.Header.Get("ETag"), nil
+	case http.StatusNotModified:
+		return nil, etag, nil
+	}
+	err = fmt.Errorf("%v: %s", res.Status, bytes.TrimSpace(slurp))
+	return nil, "", bestError(err, slurp)
+}
+
 // WhoIs returns the owner of the remoteAddr, which must be an IP or IP:port.
 //
 // Deprecated: use LocalClient.WhoIs.
@@ -249,6 +311,37 @@ func (lc *LocalClient) WhoIs(ctx context.Context, remoteAddr string) (*apitype.W
 	return decodeJSON[*apitype.WhoIsResponse](body)
 }
 
+// WhoIsBatch returns the owners of many remote addresses, all looked up in
+// the same netmap, keyed by address. Each of addrs is an IP:port or, for a
+// Tailscale IP, just the IP. Addresses that match no node are absent from
+// the result.
+func (lc *LocalClient) WhoIsBatch(ctx context.Context, addrs []string) (map[string]*apitype.WhoIsResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/whois-batch", 200, jsonBody(apitype.WhoIsBatchRequest{Addrs: addrs}))
+	if err != nil {
+		return nil, err
+	}
+	res, err := decodeJSON[*apitype.WhoIsBatchResponse](body)
+	if err != nil {
+		return nil, err
+	}
+	return res.Results, nil
+}
+
+// Wait blocks until all of the conditions conds hold on the Tailscale
+// daemon, or until timeout (if non-zero) or ctx expires. See 'tailscale
+// wait' for the conditions; none means waiting for the Running state.
+func (lc *LocalClient) Wait(ctx context.Context, conds []string, timeout time.Duration) error {
+	v := url.Values{}
+	if len(conds) > 0 {
+		v.Set("for", strings.Join(conds, ","))
+	}
+	if timeout > 0 {
+		v.Set("timeout", timeout.String())
+	}
+	_, err := lc.get200(ctx, "/localapi/v0/wait?"+v.Encode())
+	return err
+}
+
 // Goroutines returns a dump of the Tailscale daemon's current goroutines.
 func (lc *LocalClient) Goroutines(ctx context.Context) ([]byte, error) {
 	return lc.get200(ctx, "/localapi/v0/goroutines")
@@ -326,14 +419,20 @@ type BugReportOpts struct {
 	// this channel to be closed before finishing the request, which
 	// generates another log marker.
 	Record <-chan struct{}
+
+	// RedactHostnames and RedactIPs specify whether to replace the
+	// hostnames of this node and its peers, and IP addresses, with
+	// placeholders in the logged diagnostic information.
+	RedactHostnames bool
+	RedactIPs       bool
+
+	// PreviewID, if non-empty, is the ID of a payload returned by
+	// BugReportPreview to log as previewed, instead of assembling a new
+	// one. Note, Diagnose and the Redact fields are then ignored.
+	PreviewID string
 }
 
-// BugReportWithOpts logs and returns a log marker that can be shared by the
-// user with support.
-//
-// The opts type specifies options to pass to the Tailscale daemon when
-// generating this bug report.
-func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts) (string, error) {
+func (opts BugReportOpts) queryParams() url.Values {
 	qparams := make(url.Values)
 	if opts.Note != "" {
 		qparams.Set("note", opts.Note)
@@ -344,6 +443,29 @@ func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts
 	if opts.Record != nil {
 		qparams.Set("record", "true")
 	}
+	var redact []string
+	if opts.RedactHostnames {
+		redact = append(redact, "hostnames")
+	}
+	if opts.RedactIPs {
+		redact = append(redact, "ips")
+	}
+	if len(redact) > 0 {
+		qparams.Set("redact", strings.Join(redact, ","))
+	}
+	if opts.PreviewID != "" {
+		qparams.Set("preview-id", opts.PreviewID)
+	}
+	return qparams
+}
+
+// BugReportWithOpts logs and returns a log marker that can be shared by the
+// user with support.
+//
+// The opts type specifies options to pass to the Tailscale daemon when
+// generating this bug report.
+func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts) (string, error) {
+	qparams := opts.queryParams()
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -374,6 +496,21 @@ func (lc *LocalClient) BugReportWithOpts(ctx context.Context, opts BugReportOpts
 	return strings.TrimSpace(string(body)), nil
 }
 
+// BugReportPreview assembles the diagnostic information that BugReportWithOpts
+// would log with opts, without logging anything, so that the user can review
+// it. The returned preview's ID can then be passed as opts.PreviewID to log
+// exactly that information.
+func (lc *LocalClient) BugReportPreview(ctx context.Context, opts BugReportOpts) (*apitype.BugReportPreview, error) {
+	qparams := opts.queryParams()
+	qparams.Set("preview", "true")
+	qparams.Del("record")
+	body, err := lc.send(ctx, "POST", "/localapi/v0/bugreport?"+qparams.Encode(), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.BugReportPreview](body)
+}
+
 // BugReport logs and returns a log marker that can be shared by the user with support.
 //
 // This is the same as calling BugReportWithOpts and only specifying the Note
@@ -511,6 +648,20 @@ func (lc *LocalClient) StatusWithoutPeers(ctx context.Context) (*ipnstate.Status
 	return lc.status(ctx, "?peers=false")
 }
 
+// StatusIfChanged returns the Tailscale daemon's status, and its ETag, like
+// Status, for pollers: if etag, from a previous call, is non-empty and
+// still current, it waits up to wait for the status to change, returning a
+// nil status and etag if it doesn't. This avoids tailscaled serializing
+// the status of large tailnets when nothing changed.
+func (lc *LocalClient) StatusIfChanged(ctx context.Context, etag string, wait time.Duration) (*ipnstate.Status, string, error) {
+	body, etag, err := lc.getIfChanged(ctx, "/localapi/v0/status", etag, wait)
+	if err != nil || body == nil {
+		return nil, etag, err
+	}
+	st, err := decodeJSON[*ipnstate.Status](body)
+	return st, etag, err
+}
+
 func (lc *LocalClient) status(ctx context.Context, queryString string) (*ipnstate.Status, error) {
 	body, err := lc.get200(ctx, "/localapi/v0/status"+queryString)
 	if err != nil {
@@ -651,6 +802,19 @@ func (lc *LocalClient) GetPrefs(ctx context.Context) (*ipn.Prefs, error) {
 	return &p, nil
 }
 
+// GetPrefsIfChanged returns the current prefs, and their ETag, like
+// GetPrefs, for pollers: if etag, from a previous call, is non-empty and
+// still current, it waits up to wait for the prefs to change, returning
+// nil prefs and etag if they don't.
+func (lc *LocalClient) GetPrefsIfChanged(ctx context.Context, etag string, wait time.Duration) (*ipn.Prefs, string, error) {
+	body, etag, err := lc.getIfChanged(ctx, "/localapi/v0/prefs", etag, wait)
+	if err != nil || body == nil {
+		return nil, etag, err
+	}
+	p, err := decodeJSON[*ipn.Prefs](body)
+	return p, etag, err
+}
+
 func (lc *LocalClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
 	body, err := lc.send(ctx, "PATCH", "/localapi/v0/prefs", http.StatusOK, jsonBody(mp))
 	if err != nil {
@@ -707,6 +871,18 @@ func (lc *LocalClient) SetDNS(ctx context.Context, name, value string) error {
 //
 // The ctx is only used for the duration of the call, not the lifetime of the net.Conn.
 func (lc *LocalClient) DialTCP(ctx context.Context, host string, port uint16) (net.Conn, error) {
+	return lc.UserDial(ctx, "tcp", host, port)
+}
+
+// UserDial connects to the host's port via Tailscale, over network "tcp"
+// or "udp". Over UDP, each Write of the returned net.Conn sends one
+// datagram and each Read returns one.
+//
+// The host may be a base DNS name (resolved from the netmap inside
+// tailscaled), a FQDN, or an IP address.
+//
+// The ctx is only used for the duration of the call, not the lifetime of the net.Conn.
+func (lc *LocalClient) UserDial(ctx context.Context, network, host string, port uint16) (net.Conn, error) {
 	connCh := make(chan net.Conn, 1)
 	trace := httptrace.ClientTrace{
 		GotConn: func(info httptrace.GotConnInfo) {
@@ -724,6 +900,9 @@ func (lc *LocalClient) DialTCP(ctx context.Context, host string, port uint16) (n
 		"Dial-Host":  []string{host},
 		"Dial-Port":  []string{fmt.Sprint(port)},
 	}
+	if network != "tcp" {
+		req.Header.Set("Dial-Network", network)
+	}
 	res, err := lc.DoLocalRequest(req)
 	if err != nil {
 		return nil, err
@@ -751,7 +930,11 @@ func (lc *LocalClient) DialTCP(ctx context.Context, host string, port uint16) (n
 		res.Body.Close()
 		return nil, errors.New("http Transport did not provide a writable body")
 	}
-	return netutil.NewAltReadWriteCloserConn(rwc, switchedConn), nil
+	c := netutil.NewAltReadWriteCloserConn(rwc, switchedConn)
+	if network == "udp" {
+		c = netutil.NewPacketStreamConn(c)
+	}
+	return c, nil
 }
 
 // CurrentDERPMap returns the current DERPMap that is being used by the local tailscaled.
@@ -991,6 +1174,25 @@ type signRequest struct {
 	return nil
 }
 
+// NetworkLockSubmitSignature submits a node-key signature made by the
+// caller, such as with a signing key held outside tailscaled, to the
+// control plane.
+func (lc *LocalClient) NetworkLockSubmitSignature(ctx context.Context, sig tkatype.MarshaledSignature) error {
+	var b bytes.Buffer
+	type submitRequest struct {
+		Signature tkatype.MarshaledSignature
+	}
+
+	if err := json.NewEncoder(&b).Encode(submitRequest{Signature: sig}); err != nil {
+		return err
+	}
+
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-signature", 200, &b); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
 // NetworkLockAffectedSigs returns all signatures signed by the specified keyID.
 func (lc *LocalClient) NetworkLockAffectedSigs(ctx context.Context, keyID tkatype.KeyID) ([]tkatype.MarshaledSignature, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/affected-sigs", 200, bytes.NewReader(keyID))
@@ -1066,6 +1268,17 @@ func (lc *LocalClient) NetworkLockCosignRecoveryAUM(ctx context.Context, aum tka
 	return body, nil
 }
 
+// NetworkLockInspectRecoveryAUM reports which keys a recovery AUM revokes
+// and how many of the required signatures it carries.
+func (lc *LocalClient) NetworkLockInspectRecoveryAUM(ctx context.Context, aum tka.AUM) (*ipnstate.NetworkLockRecovery, error) {
+	r := bytes.NewReader(aum.Serialize())
+	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/inspect-recovery-aum", 200, r)
+	if err != nil {
+		return nil, fmt.Errorf("sending inspect-recovery-aum: %w", err)
+	}
+	return decodeJSON[*ipnstate.NetworkLockRecovery](body)
+}
+
 // NetworkLockSubmitRecoveryAUM submits a recovery AUM to the control plane.
 func (lc *LocalClient) NetworkLockSubmitRecoveryAUM(ctx context.Context, aum tka.AUM) error {
 	r := bytes.NewReader(aum.Serialize())
@@ -1105,6 +1318,309 @@ func (lc *LocalClient) GetServeConfig(ctx context.Context) (*ipn.ServeConfig, er
 	return getServeConfigFromJSON(body)
 }
 
+// AccessSummary returns who the node's packet filter, as derived from the
+// tailnet's ACLs, lets reach the node, and on which ports.
+func (lc *LocalClient) AccessSummary(ctx context.Context) (*ipnstate.AccessSummary, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/access-summary")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.AccessSummary](body)
+}
+
+// BandwidthUsage returns the traffic the node has exchanged over Tailscale
+// in the current usage period of its bandwidth quota.
+func (lc *LocalClient) BandwidthUsage(ctx context.Context) (*ipn.BandwidthUsage, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/bandwidth-usage")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.BandwidthUsage](body)
+}
+
+// NetcheckHistory returns the trends of the netcheck samples the node
+// recorded in the last hours hours, or in all retained samples if hours is
+// zero. Samples are only recorded while the NetcheckHistoryHours pref is
+// set.
+func (lc *LocalClient) NetcheckHistory(ctx context.Context, hours int) (*ipnstate.NetcheckHistory, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/netcheck-history?hours="+strconv.Itoa(hours))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.NetcheckHistory](body)
+}
+
+// RelayStats returns the traffic the node has forwarded on behalf of each
+// peer as a subnet router or exit node, for up to the last days days, most
+// recent first. If days is zero, all retained days are returned.
+func (lc *LocalClient) RelayStats(ctx context.Context, days int) ([]relaystats.DayUsage, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/relay-stats?days="+strconv.Itoa(days))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]relaystats.DayUsage](body)
+}
+
+// ReloadConfig makes tailscaled re-read the config file it was started with
+// and apply it if it changed. It reports whether the config changed.
+func (lc *LocalClient) ReloadConfig(ctx context.Context) (changed bool, err error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/reload-config", 200, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := decodeJSON[struct{ Changed bool }](body)
+	if err != nil {
+		return false, err
+	}
+	return res.Changed, nil
+}
+
+// HealthState returns the node's current health problems.
+func (lc *LocalClient) HealthState(ctx context.Context) (*health.State, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/health")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*health.State](body)
+}
+
+// PeerTraffic returns the traffic the node has exchanged with each of its
+// peers over the last hour, in one-minute buckets.
+func (lc *LocalClient) PeerTraffic(ctx context.Context) ([]ipnstate.PeerTraffic, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-traffic")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipnstate.PeerTraffic](body)
+}
+
+// WatchConnStats subscribes to the per-connection statistics of the node's
+// traffic, per opts.
+//
+// The returned ConnStatsWatcher's Close method must be called when done to
+// release resources.
+func (lc *LocalClient) WatchConnStats(ctx context.Context, opts connstats.SubscribeOptions) (*ConnStatsWatcher, error) {
+	v := url.Values{}
+	if opts.Period != 0 {
+		v.Set("period", opts.Period.String())
+	}
+	if opts.MaxConns != 0 {
+		v.Set("max-conns", fmt.Sprint(opts.MaxConns))
+	}
+	if opts.SampleRate != 0 {
+		v.Set("sample", fmt.Sprint(opts.SampleRate))
+	}
+	if opts.Physical {
+		v.Set("physical", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		"http://"+apitype.LocalAPIHost+"/localapi/v0/conn-stats?"+v.Encode(),
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return nil, errors.New(strings.TrimSpace(res.Status + ": " + string(body)))
+	}
+	return &ConnStatsWatcher{
+		ctx:     ctx,
+		httpRes: res,
+		dec:     json.NewDecoder(res.Body),
+	}, nil
+}
+
+// ConnStatsWatcher is an active subscription to the per-connection
+// statistics of the local tailscaled. It's returned by
+// LocalClient.WatchConnStats.
+//
+// It must be closed when done.
+type ConnStatsWatcher struct {
+	ctx     context.Context // from original WatchConnStats call
+	httpRes *http.Response
+	dec     *json.Decoder
+}
+
+// Close stops the watcher and releases its resources.
+func (w *ConnStatsWatcher) Close() error {
+	return w.httpRes.Body.Close()
+}
+
+// Next returns the next connstats.Snapshot from the stream, blocking until
+// one is available. If the context from LocalClient.WatchConnStats is done,
+// that error is returned.
+func (w *ConnStatsWatcher) Next() (connstats.Snapshot, error) {
+	var snap connstats.Snapshot
+	if err := w.dec.Decode(&snap); err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return connstats.Snapshot{}, err
+	}
+	return snap, nil
+}
+
+// PrefProvenance returns where the current prefs came from, keyed by pref
+// name. Prefs without an entry haven't been changed since tailscaled
+// started tracking their provenance.
+func (lc *LocalClient) PrefProvenance(ctx context.Context) (map[string]ipn.PrefProvenance, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/prefs/provenance")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[map[string]ipn.PrefProvenance](body)
+}
+
+// AutoUpdateStatus returns the state of automatic updates: the available
+// version, whether the update policy is deferring it, and the version most
+// recently applied.
+func (lc *LocalClient) AutoUpdateStatus(ctx context.Context) (*ipn.AutoUpdateStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/update/status")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.AutoUpdateStatus](body)
+}
+
+// StalePeers returns the peers that hiding peers not seen for the given
+// number of days (see ipn.Prefs.HideStalePeersDays) would hide, least
+// recently seen first.
+func (lc *LocalClient) StalePeers(ctx context.Context, days int) ([]*ipnstate.PeerStatus, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/stale-peers?days="+strconv.Itoa(days))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]*ipnstate.PeerStatus](body)
+}
+
+// PeerNotes returns the nicknames and notes the user has assigned to peers,
+// keyed by the peers' stable node IDs.
+func (lc *LocalClient) PeerNotes(ctx context.Context) (map[tailcfg.StableNodeID]ipn.PeerNote, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/peer-notes")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[map[tailcfg.StableNodeID]ipn.PeerNote](body)
+}
+
+// SetPeerNotes updates the nicknames and notes assigned to peers.
+//
+// If replace is false, notes is merged into the existing notes and a zero
+// PeerNote removes a peer's notes. If replace is true, notes replaces all
+// existing notes, as when importing notes previously returned by PeerNotes.
+func (lc *LocalClient) SetPeerNotes(ctx context.Context, notes map[tailcfg.StableNodeID]ipn.PeerNote, replace bool) error {
+	v := url.Values{"replace": {strconv.FormatBool(replace)}}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/peer-notes?"+v.Encode(), 200, jsonBody(notes))
+	return err
+}
+
+// ExitNodeConsents returns the notices of the exit node changes made by
+// policy rather than the user, oldest first, with whether the user
+// acknowledged them.
+func (lc *LocalClient) ExitNodeConsents(ctx context.Context) ([]ipn.ExitNodeConsent, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/exit-node-consent")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.ExitNodeConsent](body)
+}
+
+// AcknowledgeExitNodeConsent records that the user acknowledged the exit
+// node consent notice with the given ID, as sent in ipn.Notify, or all
+// pending notices if id is empty.
+func (lc *LocalClient) AcknowledgeExitNodeConsent(ctx context.Context, id string) error {
+	v := url.Values{"id": {id}}
+	_, err := lc.send(ctx, "POST", "/localapi/v0/exit-node-consent?"+v.Encode(), 200, nil)
+	return err
+}
+
+// LocalAPITokens returns the LocalAPI tokens that exist, without their
+// secrets.
+func (lc *LocalClient) LocalAPITokens(ctx context.Context) ([]ipn.LocalAPIToken, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/api-tokens")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.LocalAPIToken](body)
+}
+
+// CreateLocalAPIToken creates a LocalAPI token granting scopes (see
+// ipn.LocalAPIScopes). The returned token can't be retrieved again.
+func (lc *LocalClient) CreateLocalAPIToken(ctx context.Context, name string, scopes []string) (*ipn.CreateLocalAPITokenResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/api-tokens", 200, jsonBody(ipn.CreateLocalAPITokenRequest{Name: name, Scopes: scopes}))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.CreateLocalAPITokenResponse](body)
+}
+
+// RevokeLocalAPIToken deletes the LocalAPI token with the given ID.
+func (lc *LocalClient) RevokeLocalAPIToken(ctx context.Context, id string) error {
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/api-tokens?id="+url.QueryEscape(id), 200, nil)
+	return err
+}
+
+// WantRunningSchedule returns the pending timed change of the WantRunning
+// pref, as made by "tailscale up" and "tailscale down" with --after or
+// --until, or nil if there is none.
+func (lc *LocalClient) WantRunningSchedule(ctx context.Context) (*ipn.WantRunningSchedule, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/want-running-schedule")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipn.WantRunningSchedule](body)
+}
+
+// SetWantRunningSchedule replaces the pending timed change of the
+// WantRunning pref with s, or cancels it if s is nil. Changing WantRunning
+// by other means, such as EditPrefs, also cancels it.
+func (lc *LocalClient) SetWantRunningSchedule(ctx context.Context, s *ipn.WantRunningSchedule) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/want-running-schedule", 200, jsonBody(s))
+	return err
+}
+
+// GetProxyConfig returns the HTTP proxy configuration set via
+// SetProxyConfig, or nil if tailscaled is using the proxy settings of its
+// environment.
+func (lc *LocalClient) GetProxyConfig(ctx context.Context) (*tshttpproxy.Config, error) {
+	body, err := lc.send(ctx, "GET", "/localapi/v0/proxy-config", 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("getting proxy config: %w", err)
+	}
+	var c *tshttpproxy.Config
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetProxyConfig replaces the HTTP proxy configuration tailscaled uses for
+// connections to control, DERP and log servers, without a restart.
+// Existing connections are re-established through the new proxy.
+//
+// A nil config reverts to the proxy settings of tailscaled's environment.
+func (lc *LocalClient) SetProxyConfig(ctx context.Context, c *tshttpproxy.Config) error {
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/proxy-config", 200, jsonBody(c)); err != nil {
+		return fmt.Errorf("setting proxy config: %w", err)
+	}
+	return nil
+}
+
+// SetProxyCredentials sets the "user:password" credentials tailscaled uses
+// with the proxy in its Proxy pref, or clears them if creds is empty. They
+// are stored apart from the prefs and can't be read back.
+func (lc *LocalClient) SetProxyCredentials(ctx context.Context, creds string) error {
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/proxy-credentials", 200, strings.NewReader(creds)); err != nil {
+		return fmt.Errorf("setting proxy credentials: %w", err)
+	}
+	return nil
+}
+
 func getServeConfigFromJSON(body []byte) (sc *ipn.ServeConfig, err error) {
 	if err := json.Unmarshal(body, &sc); err != nil {
 		return nil, err
@@ -1233,6 +1749,172 @@ func (lc *LocalClient) DebugDERPRegion(ctx context.Context, regionIDOrCode strin
 	return decodeJSON[*ipnstate.DebugDERPRegionReport](body)
 }
 
+// NetworkState returns the current state of the device's network, with
+// the result of the last captive portal check, if any.
+func (lc *LocalClient) NetworkState(ctx context.Context) (*ipnstate.NetworkEvent, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-netmon")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.NetworkEvent](body)
+}
+
+// WatchNetworkEvents subscribes to changes to the device's network and to
+// captive portal check results. The first event is the current state, as
+// returned by NetworkState.
+//
+// The returned NetworkEventWatcher's Close method must be called when done.
+func (lc *LocalClient) WatchNetworkEvents(ctx context.Context) (*NetworkEventWatcher, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET",
+		"http://"+apitype.LocalAPIHost+"/localapi/v0/debug-netmon?follow=true",
+		nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := lc.doLocalRequestNiceError(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, errors.New(res.Status)
+	}
+	return &NetworkEventWatcher{
+		ctx:     ctx,
+		httpRes: res,
+		dec:     json.NewDecoder(res.Body),
+	}, nil
+}
+
+// NetworkEventWatcher is an active subscription to network events. It's
+// returned by LocalClient.WatchNetworkEvents.
+//
+// It must be closed when done.
+type NetworkEventWatcher struct {
+	ctx     context.Context // from original WatchNetworkEvents call
+	httpRes *http.Response
+	dec     *json.Decoder
+}
+
+// Close stops the watcher and releases its resources.
+func (w *NetworkEventWatcher) Close() error {
+	return w.httpRes.Body.Close()
+}
+
+// Next returns the next network event from the stream.
+// If the context from LocalClient.WatchNetworkEvents is done, that error is
+// returned.
+func (w *NetworkEventWatcher) Next() (ipnstate.NetworkEvent, error) {
+	var ev ipnstate.NetworkEvent
+	if err := w.dec.Decode(&ev); err != nil {
+		if cerr := w.ctx.Err(); cerr != nil {
+			err = cerr
+		}
+		return ipnstate.NetworkEvent{}, err
+	}
+	return ev, nil
+}
+
+// DebugPacketFilterLatency times lookups in the packet filter's rules and
+// reports their latency percentiles. A zero lookups uses the default
+// number of lookups.
+func (lc *LocalClient) DebugPacketFilterLatency(ctx context.Context, lookups int) (*ipnstate.DebugPacketFilterLatencyReport, error) {
+	v := url.Values{}
+	if lookups > 0 {
+		v.Set("lookups", fmt.Sprint(lookups))
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/debug-packet-filter-latency?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.DebugPacketFilterLatencyReport](body)
+}
+
+// DebugDERPBandwidth measures the download bandwidth from a DERP region,
+// identified by ID or code. If regionIDOrCode is empty, the node's home
+// region is used. A zero size or duration uses the netcheck defaults.
+func (lc *LocalClient) DebugDERPBandwidth(ctx context.Context, regionIDOrCode string, size int64, d time.Duration) (*ipnstate.DebugDERPBandwidthReport, error) {
+	v := url.Values{"region": {regionIDOrCode}}
+	if size > 0 {
+		v.Set("size", fmt.Sprint(size))
+	}
+	if d > 0 {
+		v.Set("duration", d.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-derp-bandwidth?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.DebugDERPBandwidthReport](body)
+}
+
+// DebugEndpoints returns the endpoint candidates of this node and of the
+// peer with the given Tailscale IP, with the state of path discovery for
+// each of the latter.
+func (lc *LocalClient) DebugEndpoints(ctx context.Context, ip netip.Addr) (*ipnstate.EndpointCandidates, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-endpoints?ip="+url.QueryEscape(ip.String()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.EndpointCandidates](body)
+}
+
+// SetDNSQueryLogConfig configures the query log of tailscaled's DNS
+// resolver. A zero Size disables it.
+func (lc *LocalClient) SetDNSQueryLogConfig(ctx context.Context, cfg ipnstate.DNSQueryLogConfig) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/dns-query-log", http.StatusNoContent, jsonBody(cfg))
+	return err
+}
+
+// DNSQueryLog returns the queries logged by tailscaled's DNS resolver after
+// the one numbered since (zero for all of them), with statistics on its
+// upstream resolvers. If wait is positive and there are no such queries
+// yet, it waits up to that long for one.
+func (lc *LocalClient) DNSQueryLog(ctx context.Context, since uint64, wait time.Duration) (*ipnstate.DNSQueryLog, error) {
+	v := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if wait > 0 {
+		v.Set("waitsec", fmt.Sprint(int(wait.Seconds())))
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/dns-query-log?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.DNSQueryLog](body)
+}
+
+// SetFlowLogConfig configures tailscaled's audit log of inbound flows. A
+// zero Size disables it.
+func (lc *LocalClient) SetFlowLogConfig(ctx context.Context, cfg ipnstate.FlowLogConfig) error {
+	_, err := lc.send(ctx, "POST", "/localapi/v0/flow-log", http.StatusNoContent, jsonBody(cfg))
+	return err
+}
+
+// FlowLog returns the inbound flows logged by tailscaled after the one
+// numbered since (zero for all of them). If wait is positive and there are
+// no such flows yet, it waits up to that long for one.
+func (lc *LocalClient) FlowLog(ctx context.Context, since uint64, wait time.Duration) (*ipnstate.FlowLog, error) {
+	v := url.Values{"since": {strconv.FormatUint(since, 10)}}
+	if wait > 0 {
+		v.Set("waitsec", fmt.Sprint(int(wait.Seconds())))
+	}
+	body, err := lc.get200(ctx, "/localapi/v0/flow-log?"+v.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.FlowLog](body)
+}
+
+// FirewallRules returns the firewall rules tailscaled wants installed when
+// the host's firewall is managed externally (TS_DEBUG_FIREWALL_MODE=external
+// on Linux), in format: "nftables", "iptables" or "ip6tables".
+func (lc *LocalClient) FirewallRules(ctx context.Context, format string) (string, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/firewall-rules?format="+url.QueryEscape(format))
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/httpm"
+)
+
+// accessData answers "who can access this node?" in the web client: the
+// peers and other sources the packet filter lets in, and on which ports.
+type accessData struct {
+	Peers   []accessPeer
+	Sources []ipnstate.SourceAccess
+}
+
+// accessPeer is a peer allowed to reach this node.
+type accessPeer struct {
+	ipnstate.PeerAccess
+	Name     string // first label of DNSName
+	Nickname string `json:",omitempty"`
+}
+
+func (s *Server) serveGetAccess(w http.ResponseWriter, r *http.Request) {
+	sum, err := s.lc.AccessSummary(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	notes, err := s.lc.PeerNotes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := accessData{
+		Peers:   make([]accessPeer, 0, len(sum.Peers)),
+		Sources: sum.Sources,
+	}
+	for _, pa := range sum.Peers {
+		data.Peers = append(data.Peers, accessPeer{
+			PeerAccess: pa,
+			Name:       strings.Split(pa.DNSName, ".")[0],
+			Nickname:   notes[pa.ID].Nickname,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// tagsData is this node's ACL tags, for the web client's tag manager.
+type tagsData struct {
+	// Advertised are the tags this node requests, with the
+	// AdvertiseTags pref.
+	Advertised []string
+	// Granted are the tags the coordination server applied to this
+	// node.
+	Granted []string
+	// Pending are the advertised tags not granted yet, which need an
+	// admin's approval or the node's reauthentication by a tag owner.
+	Pending []string
+	// Removing are the granted tags no longer advertised, which the node
+	// keeps until it's reauthenticated.
+	Removing []string
+}
+
+// tagsDataOf returns the tagsData of a node that advertises advertised
+// and was granted granted.
+func tagsDataOf(advertised, granted []string) tagsData {
+	td := tagsData{
+		Advertised: slices.Clone(advertised),
+		Granted:    slices.Clone(granted),
+	}
+	for _, t := range advertised {
+		if !slices.Contains(granted, t) {
+			td.Pending = append(td.Pending, t)
+		}
+	}
+	for _, t := range granted {
+		if !slices.Contains(advertised, t) {
+			td.Removing = append(td.Removing, t)
+		}
+	}
+	return td
+}
+
+// tagsUpdate is the body of a POST to /api/tags.
+type tagsUpdate struct {
+	Tags []string // the tags to advertise, replacing the current ones
+}
+
+// serveTags serves this node's tags (GET) and sets the tags it advertises
+// (POST), returning the resulting tagsData.
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	switch r.Method {
+	case httpm.GET:
+	case httpm.POST:
+		var u tagsUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, t := range u.Tags {
+			if err := tailcfg.CheckTag(t); err != nil {
+				http.Error(w, fmt.Sprintf("tag %q: %v", t, err), http.StatusBadRequest)
+				return
+			}
+		}
+		slices.Sort(u.Tags)
+		mp := &ipn.MaskedPrefs{
+			Prefs:            ipn.Prefs{AdvertiseTags: slices.Compact(u.Tags)},
+			AdvertiseTagsSet: true,
+		}
+		if _, err := s.lc.EditPrefs(ctx, mp); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	prefs, err := s.lc.GetPrefs(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	st, err := s.lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var granted []string
+	if st.Self != nil && st.Self.Tags != nil {
+		granted = st.Self.Tags.AsSlice()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tagsDataOf(prefs.AdvertiseTags, granted))
+}
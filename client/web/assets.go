@@ -5,6 +5,7 @@
 
 import (
 	"embed"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
@@ -38,19 +39,25 @@ func init() {
 	staticfiles = http.FileServer(http.FS(buildFiles))
 }
 
-func assetsHandler(devMode bool) (_ http.Handler, cleanup func()) {
+func assetsHandler(devMode bool) (_ http.Handler, cleanup func(), _ error) {
 	if devMode {
 		// When in dev mode, proxy asset requests to the Vite dev server.
-		cleanup := startDevServer()
-		return devServerProxy(), cleanup
+		cleanup, err := startDevServer()
+		if err != nil {
+			return nil, nil, err
+		}
+		return devServerProxy(), cleanup, nil
 	}
-	return staticfiles, nil
+	return staticfiles, func() {}, nil
 }
 
 // startDevServer starts the JS dev server that does on-demand rebuilding
 // and serving of web client JS and CSS resources.
-func startDevServer() (cleanup func()) {
-	root := gitRootDir()
+func startDevServer() (cleanup func(), _ error) {
+	root, err := gitRootDir()
+	if err != nil {
+		return nil, err
+	}
 	webClientPath := filepath.Join(root, "client", "web")
 
 	yarn := filepath.Join(root, "tool", "yarn")
@@ -60,7 +67,7 @@ func startDevServer() (cleanup func()) {
 	log.Printf("installing JavaScript deps using %s... (might take ~30s)", yarn)
 	out, err := exec.Command(yarn, "--non-interactive", "-s", "--cwd", webClientPath, "install").CombinedOutput()
 	if err != nil {
-		log.Fatalf("error running tailscale web's yarn install: %v, %s", err, out)
+		return nil, fmt.Errorf("error running tailscale web's yarn install: %v, %s", err, out)
 	}
 	log.Printf("starting JavaScript dev server...")
 	cmd := exec.Command(node, vite)
@@ -68,14 +75,14 @@ func startDevServer() (cleanup func()) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
-		log.Fatalf("Starting JS dev server: %v", err)
+		return nil, fmt.Errorf("starting JS dev server: %w", err)
 	}
 	log.Printf("JavaScript dev server running as pid %d", cmd.Process.Pid)
 	return func() {
 		cmd.Process.Signal(os.Interrupt)
 		err := cmd.Wait()
 		log.Printf("JavaScript dev server exited: %v", err)
-	}
+	}, nil
 }
 
 // devServerProxy returns a reverse proxy to the vite dev server.
@@ -98,10 +105,10 @@ func devServerProxy() *httputil.ReverseProxy {
 	return devProxy
 }
 
-func gitRootDir() string {
+func gitRootDir() (string, error) {
 	top, err := exec.Command("git", "rev-parse", "--show-toplevel").Output()
 	if err != nil {
-		log.Fatalf("failed to find git top level (not in corp git?): %v", err)
+		return "", fmt.Errorf("failed to find git top level (not in corp git?): %w", err)
 	}
-	return strings.TrimSpace(string(top))
+	return strings.TrimSpace(string(top)), nil
 }
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+)
+
+// peerDiagnostics are the details of the connection to a peer, for the web
+// client's connection details panel. They're what "tailscale ping
+// --verbose" and "tailscale debug endpoints" show on the command line.
+type peerDiagnostics struct {
+	ID        tailcfg.StableNodeID
+	Name      string
+	IP        string
+	Online    bool
+	PublicKey key.NodePublic // the peer's WireGuard key
+
+	// Path is how packets currently reach the peer: "direct", "derp",
+	// or "none" if there's no connection to it.
+	Path          string
+	CurAddr       string    `json:",omitempty"` // UDP endpoint used, if direct
+	DERPRegion    string    `json:",omitempty"` // home DERP region of the peer
+	LastHandshake time.Time `json:",omitempty"` // most recent WireGuard handshake
+	RxBytes       int64
+	TxBytes       int64
+
+	// Endpoints are the peer's endpoint candidates, best first, with the
+	// outcome of the most recent disco pings to each. SelfEndpoints are
+	// this node's, as advertised to the peer.
+	Endpoints     []ipnstate.EndpointCandidate
+	SelfEndpoints []ipnstate.EndpointCandidate
+}
+
+// serveGetPeerDiagnostics serves the peerDiagnostics of the peer with the
+// stable node ID at the end of the request's path.
+func (s *Server) serveGetPeerDiagnostics(w http.ResponseWriter, r *http.Request) {
+	id := tailcfg.StableNodeID(strings.TrimPrefix(r.URL.Path, "/api/diagnostics/peer/"))
+	if id == "" || strings.Contains(string(id), "/") {
+		http.Error(w, "invalid peer ID", http.StatusBadRequest)
+		return
+	}
+	st, err := s.lc.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var ps *ipnstate.PeerStatus
+	for _, p := range st.Peer {
+		if p.ID == id {
+			ps = p
+			break
+		}
+	}
+	if ps == nil {
+		http.Error(w, "no such peer", http.StatusNotFound)
+		return
+	}
+	d := peerDiagnostics{
+		ID:            ps.ID,
+		Name:          peerName(ps),
+		Online:        ps.Online,
+		PublicKey:     ps.PublicKey,
+		Path:          peerPath(ps),
+		CurAddr:       ps.CurAddr,
+		DERPRegion:    ps.Relay,
+		LastHandshake: ps.LastHandshake,
+		RxBytes:       ps.RxBytes,
+		TxBytes:       ps.TxBytes,
+	}
+	if len(ps.TailscaleIPs) != 0 {
+		d.IP = ps.TailscaleIPs[0].String()
+		ec, err := s.lc.DebugEndpoints(r.Context(), ps.TailscaleIPs[0])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		d.Endpoints, d.SelfEndpoints = ec.Peer, ec.Self
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// peerPath returns the peerDiagnostics.Path of ps.
+func peerPath(ps *ipnstate.PeerStatus) string {
+	switch {
+	case ps.CurAddr != "":
+		return "direct"
+	case ps.Relay != "" && !ps.LastHandshake.IsZero():
+		return "derp"
+	}
+	return "none"
+}
@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+)
+
+// fleetNode is a node's row in the fleet dashboard.
+type fleetNode struct {
+	ID     tailcfg.StableNodeID
+	Name   string
+	IP     string
+	Online bool
+	IsSelf bool
+
+	// Status is the node's summary of itself, or nil if it couldn't be
+	// fetched, in which case Error says why.
+	Status *ipnstate.FleetNodeStatus `json:",omitempty"`
+	Error  string                    `json:",omitempty"`
+}
+
+// fleetFetchTimeout is how long to wait for each peer's status.
+const fleetFetchTimeout = 5 * time.Second
+
+// maxFleetFetches is the number of peers whose status is fetched at once.
+const maxFleetFetches = 8
+
+// serveGetFleet serves the status of this node and each of its peers, as
+// reported by their peerapi, for the fleet dashboard. Peers only report it
+// to nodes granted tailcfg.PeerCapabilityFleetStatus.
+func (s *Server) serveGetFleet(w http.ResponseWriter, r *http.Request) {
+	if !s.fleetMode {
+		http.Error(w, "fleet dashboard not enabled", http.StatusNotFound)
+		return
+	}
+	st, err := s.lc.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nodes := fleetStatus(r.Context(), st, s.fetchFleetStatus)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nodes)
+}
+
+// fleetStatus returns the fleet dashboard's rows for the nodes in st,
+// sorted by name with this node first, calling fetch for each online peer.
+func fleetStatus(ctx context.Context, st *ipnstate.Status, fetch func(ctx context.Context, peerAPIURL string) (*ipnstate.FleetNodeStatus, error)) []fleetNode {
+	node := func(ps *ipnstate.PeerStatus) fleetNode {
+		n := fleetNode{
+			ID:     ps.ID,
+			Name:   peerName(ps),
+			Online: ps.Online,
+		}
+		if len(ps.TailscaleIPs) != 0 {
+			n.IP = ps.TailscaleIPs[0].String()
+		}
+		return n
+	}
+
+	var self []fleetNode
+	if st.Self != nil {
+		n := node(st.Self)
+		n.IsSelf, n.Online = true, true
+		fs := st.FleetNodeStatus()
+		n.Status = &fs
+		self = append(self, n)
+	}
+
+	peers := make([]fleetNode, 0, len(st.Peer))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxFleetFetches)
+	for _, ps := range st.Peer {
+		peers = append(peers, node(ps))
+		n := &peers[len(peers)-1]
+		switch {
+		case !ps.Online:
+			n.Error = "offline"
+			continue
+		case len(ps.PeerAPIURL) == 0:
+			n.Error = "no peerapi"
+			continue
+		}
+		wg.Add(1)
+		go func(n *fleetNode, url string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ctx, cancel := context.WithTimeout(ctx, fleetFetchTimeout)
+			defer cancel()
+			fs, err := fetch(ctx, url)
+			if err != nil {
+				n.Error = err.Error()
+				return
+			}
+			n.Status = fs
+		}(n, ps.PeerAPIURL[0])
+	}
+	wg.Wait()
+	slices.SortFunc(peers, func(a, b fleetNode) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return append(self, peers...)
+}
+
+// fetchFleetStatus fetches a peer's status from its peerapi at
+// peerAPIURL, connecting through tailscaled.
+func (s *Server) fetchFleetStatus(ctx context.Context, peerAPIURL string) (*ipnstate.FleetNodeStatus, error) {
+	hc := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				host, portStr, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				port, err := strconv.ParseUint(portStr, 10, 16)
+				if err != nil {
+					return nil, err
+				}
+				return s.lc.DialTCP(ctx, host, uint16(port))
+			},
+			DisableKeepAlives: true,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", peerAPIURL+"/v0/fleet-status", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("no access; grant this node %s", tailcfg.PeerCapabilityFleetStatus)
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return nil, fmt.Errorf("%v: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	fs := new(ipnstate.FleetNodeStatus)
+	if err := json.NewDecoder(res.Body).Decode(fs); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
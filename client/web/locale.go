@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tailscale.com/util/httpm"
+	"tailscale.com/util/must"
+)
+
+// The web client's message catalogs, one per locale, each mapping message
+// IDs to that locale's translation. locales/en.json has every message; the
+// others may lack some, which are then shown in English.
+//
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// defaultLocale is the locale of the web client when the browser asks for
+// none that has a catalog.
+const defaultLocale = "en"
+
+// rtlLanguages are the languages written right to left.
+var rtlLanguages = map[string]bool{
+	"ar": true,
+	"fa": true,
+	"he": true,
+	"ur": true,
+}
+
+// localeData is the web client's catalog for a locale, as served by
+// /api/locale.
+type localeData struct {
+	Locale    string            // such as "en" or "ja"
+	Dir       string            // text direction: "ltr" or "rtl"
+	Messages  map[string]string // message ID => text, with English for any untranslated
+	Available []string          // all locales with catalogs, sorted
+}
+
+var (
+	catalogsOnce sync.Once
+	catalogs     map[string]map[string]string // locale => message ID => text
+)
+
+// loadCatalogs returns the embedded message catalogs.
+func loadCatalogs() map[string]map[string]string {
+	catalogsOnce.Do(func() {
+		catalogs = map[string]map[string]string{}
+		files := must.Get(localeFS.ReadDir("locales"))
+		for _, f := range files {
+			var msgs map[string]string
+			must.Do(json.Unmarshal(must.Get(localeFS.ReadFile("locales/"+f.Name())), &msgs))
+			catalogs[strings.TrimSuffix(f.Name(), path.Ext(f.Name()))] = msgs
+		}
+	})
+	return catalogs
+}
+
+// getLocaleData returns the catalog for locale, which must have one.
+func getLocaleData(locale string) *localeData {
+	cats := loadCatalogs()
+	d := &localeData{
+		Locale:   locale,
+		Dir:      "ltr",
+		Messages: map[string]string{},
+	}
+	if rtlLanguages[locale] {
+		d.Dir = "rtl"
+	}
+	for id, msg := range cats[defaultLocale] {
+		d.Messages[id] = msg
+	}
+	for id, msg := range cats[locale] {
+		d.Messages[id] = msg
+	}
+	for l := range cats {
+		d.Available = append(d.Available, l)
+	}
+	sort.Strings(d.Available)
+	return d
+}
+
+// matchLocale returns the locale with a catalog that best matches the
+// "lang" query parameter, if any, or else the request's Accept-Language
+// header, falling back to defaultLocale.
+func matchLocale(r *http.Request) string {
+	cats := loadCatalogs()
+	match := func(tag string) (string, bool) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if _, ok := cats[tag]; ok {
+			return tag, true
+		}
+		// Fall back to the base language, so "de-AT" gets "de".
+		base, _, _ := strings.Cut(tag, "-")
+		if _, ok := cats[base]; ok {
+			return base, true
+		}
+		return "", false
+	}
+	if l, ok := match(r.FormValue("lang")); ok {
+		return l
+	}
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		if l, ok := match(tag); ok {
+			return l
+		}
+	}
+	return defaultLocale
+}
+
+// parseAcceptLanguage returns the language tags of an Accept-Language
+// header value, most preferred first, skipping "*" and those with q=0.
+func parseAcceptLanguage(v string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ws []weighted
+	for _, part := range strings.Split(v, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ws = append(ws, weighted{tag, q})
+	}
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].q > ws[j].q })
+	tags := make([]string, len(ws))
+	for i, w := range ws {
+		tags[i] = w.tag
+	}
+	return tags
+}
+
+// serveLocale serves the message catalog for the locale the request asks
+// for, in its "lang" query parameter or Accept-Language header.
+func (s *Server) serveLocale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Language")
+	json.NewEncoder(w).Encode(getLocaleData(matchLocale(r)))
+}
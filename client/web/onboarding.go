@@ -0,0 +1,322 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/util/httpm"
+)
+
+// onboardingStep is a step of the first-run onboarding wizard, which walks
+// the owner of a new device, such as a NAS or router shipped with Tailscale
+// preinstalled, through logging in and the common setup choices.
+type onboardingStep string
+
+// The onboarding steps, in order.
+const (
+	stepLogin    onboardingStep = "login"    // log in to a tailnet
+	stepHostname onboardingStep = "hostname" // confirm or change the device's name
+	stepFeatures onboardingStep = "features" // choose whether to run Tailscale SSH and share a local port
+	stepRoutes   onboardingStep = "routes"   // choose which detected LANs to advertise
+	stepSummary  onboardingStep = "summary"  // review and apply the choices
+	stepDone     onboardingStep = "done"
+)
+
+var onboardingSteps = []onboardingStep{stepLogin, stepHostname, stepFeatures, stepRoutes, stepSummary, stepDone}
+
+// onboardingState is the onboarding wizard's progress and the choices made
+// so far. The choices are only applied when the summary is confirmed.
+type onboardingState struct {
+	Step      onboardingStep
+	Hostname  string         `json:",omitempty"`
+	RunSSH    bool           `json:",omitempty"`
+	ServePort uint16         `json:",omitempty"` // local TCP port to share with the tailnet; 0 for none
+	Routes    []netip.Prefix `json:",omitempty"` // subnet routes to advertise
+}
+
+// onboardingUpdate is a request from the frontend to complete the current
+// onboarding step, with the choices made in it, or to go back a step.
+type onboardingUpdate struct {
+	Step onboardingStep // the step being completed; must be the current one
+	Back bool           // go back to the previous step instead
+
+	Hostname  string         // for stepHostname
+	RunSSH    bool           // for stepFeatures
+	ServePort uint16         // for stepFeatures
+	Routes    []netip.Prefix // for stepRoutes
+}
+
+// onboardingData is the onboarding API's response.
+type onboardingData struct {
+	onboardingState
+
+	// AuthURL is the URL to log in at, in the login step, once the
+	// frontend has asked to log in.
+	AuthURL string `json:",omitempty"`
+
+	// DetectedRoutes are the LANs this device is on, suggested in the
+	// routes step.
+	DetectedRoutes []netip.Prefix `json:",omitempty"`
+}
+
+// next returns the state after u is applied to st. It doesn't complete
+// the login step, which is done by loggedIn.
+func (st onboardingState) next(u onboardingUpdate) (onboardingState, error) {
+	if u.Step != st.Step {
+		return st, fmt.Errorf("onboarding is at step %q, not %q", st.Step, u.Step)
+	}
+	i := slices.Index(onboardingSteps, st.Step)
+	if u.Back {
+		if i <= slices.Index(onboardingSteps, stepHostname) || st.Step == stepDone {
+			return st, fmt.Errorf("can't go back from step %q", st.Step)
+		}
+		st.Step = onboardingSteps[i-1]
+		return st, nil
+	}
+	switch st.Step {
+	case stepLogin:
+		return st, errors.New("log in to continue")
+	case stepHostname:
+		if err := dnsname.ValidLabel(u.Hostname); err != nil {
+			return st, fmt.Errorf("invalid device name: %w", err)
+		}
+		st.Hostname = u.Hostname
+	case stepFeatures:
+		st.RunSSH = u.RunSSH
+		st.ServePort = u.ServePort
+	case stepRoutes:
+		for _, r := range u.Routes {
+			if !r.IsValid() || r.Bits() == 0 || tsaddr.IsTailscaleIP(r.Addr()) {
+				return st, fmt.Errorf("invalid route %v", r)
+			}
+		}
+		st.Routes = u.Routes
+	case stepDone:
+		return st, errors.New("onboarding already done")
+	}
+	st.Step = onboardingSteps[i+1]
+	return st, nil
+}
+
+// loggedIn returns st advanced past the login step, suggesting hostname as
+// the device's name, if st is at the login step.
+func (st onboardingState) loggedIn(hostname string) onboardingState {
+	if st.Step == stepLogin {
+		st.Step = stepHostname
+		st.Hostname = hostname
+	}
+	return st
+}
+
+// detectLANRoutes returns the private IPv4 and IPv6 networks, other than
+// Tailscale's, that the up interfaces in ifst are on, sorted.
+func detectLANRoutes(ifst *interfaces.State) []netip.Prefix {
+	var routes []netip.Prefix
+	for name, pfxs := range ifst.InterfaceIPs {
+		iface, ok := ifst.Interface[name]
+		if !ok || iface.Interface == nil || !iface.IsUp() {
+			continue
+		}
+		for _, pfx := range pfxs {
+			a := pfx.Addr()
+			if !a.IsPrivate() || tsaddr.IsTailscaleIP(a) || pfx.IsSingleIP() {
+				continue
+			}
+			if r := pfx.Masked(); !slices.Contains(routes, r) {
+				routes = append(routes, r)
+			}
+		}
+	}
+	slices.SortFunc(routes, func(a, b netip.Prefix) int {
+		if c := a.Addr().Compare(b.Addr()); c != 0 {
+			return c
+		}
+		return a.Bits() - b.Bits()
+	})
+	return routes
+}
+
+// onboardingFile is where the onboarding state is kept in CGI mode, when
+// each request is served by a new process.
+func onboardingFile() string {
+	return filepath.Join(os.TempDir(), "tailscale-web-onboarding.json")
+}
+
+// loadOnboardingLocked returns the onboarding state. If there's none yet,
+// the device is considered new, and so in need of onboarding, if it isn't
+// logged in.
+//
+// s.onboardingMu must be held.
+func (s *Server) loadOnboardingLocked(st *ipnstate.Status) onboardingState {
+	if s.onboarding != nil {
+		return *s.onboarding
+	}
+	if s.cgiMode {
+		var ob onboardingState
+		if b, err := os.ReadFile(onboardingFile()); err == nil && json.Unmarshal(b, &ob) == nil && slices.Contains(onboardingSteps, ob.Step) {
+			return ob
+		}
+	}
+	if needsLogin(st) {
+		return onboardingState{Step: stepLogin}
+	}
+	return onboardingState{Step: stepDone}
+}
+
+// saveOnboardingLocked records ob as the onboarding state.
+//
+// s.onboardingMu must be held.
+func (s *Server) saveOnboardingLocked(ob onboardingState) error {
+	s.onboarding = &ob
+	if !s.cgiMode {
+		return nil
+	}
+	b, err := json.Marshal(ob)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(onboardingFile(), b, 0600)
+}
+
+func needsLogin(st *ipnstate.Status) bool {
+	return st.BackendState == ipn.NeedsLogin.String() || st.BackendState == ipn.NoState.String()
+}
+
+// serveOnboarding serves the onboarding wizard API. GET returns the current
+// step and choices; POST completes the current step with an
+// onboardingUpdate, applying all the choices when the summary step is
+// completed. In the login step, POST starts logging in and returns the URL
+// to log in at; the wizard moves on to the next step by itself once the
+// device is logged in.
+func (s *Server) serveOnboarding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET && r.Method != httpm.POST {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	st, err := s.lc.Status(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.onboardingMu.Lock()
+	defer s.onboardingMu.Unlock()
+	loaded := s.loadOnboardingLocked(st)
+	cur := loaded
+	if !needsLogin(st) && st.Self != nil {
+		cur = cur.loggedIn(dnsname.FirstLabel(st.Self.DNSName))
+	}
+	data := onboardingData{}
+	switch r.Method {
+	case httpm.GET:
+		if cur.Step == stepLogin {
+			data.AuthURL = st.AuthURL
+		}
+	case httpm.POST:
+		var u onboardingUpdate
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cur.Step == stepLogin && u.Step == stepLogin && !u.Back {
+			data.AuthURL, err = s.tailscaleUp(ctx, st, nodeUpdate{})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			break
+		}
+		next, err := cur.next(u)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if next.Step == stepDone {
+			if err := s.applyOnboarding(ctx, next); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		cur = next
+	}
+	if s.onboarding == nil || !reflect.DeepEqual(cur, loaded) {
+		if err := s.saveOnboardingLocked(cur); err != nil {
+			log.Printf("saving onboarding state: %v", err)
+		}
+	}
+	data.onboardingState = cur
+	if cur.Step == stepRoutes {
+		if ifst, err := interfaces.GetState(); err == nil {
+			data.DetectedRoutes = detectLANRoutes(ifst)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// applyOnboarding applies the choices made in the onboarding wizard.
+func (s *Server) applyOnboarding(ctx context.Context, ob onboardingState) error {
+	prefs, err := s.lc.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	routes := slices.Clone(prefs.AdvertiseRoutes)
+	for _, r := range ob.Routes {
+		if !slices.Contains(routes, r) {
+			routes = append(routes, r)
+		}
+	}
+	mp := &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			Hostname:        ob.Hostname,
+			RunSSH:          ob.RunSSH,
+			AdvertiseRoutes: routes,
+		},
+		HostnameSet:        ob.Hostname != "",
+		RunSSHSet:          true,
+		AdvertiseRoutesSet: true,
+	}
+	log.Printf("Applying onboarding choices: %v", mp.Pretty())
+	if _, err := s.lc.EditPrefs(ctx, mp); err != nil {
+		return err
+	}
+	if ob.ServePort == 0 {
+		return nil
+	}
+	sc, err := s.lc.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	target := fmt.Sprintf("127.0.0.1:%d", ob.ServePort)
+	if h := sc.GetTCPPortHandler(ob.ServePort); h != nil {
+		if h.TCPForward == target {
+			return nil
+		}
+		return fmt.Errorf("port %d is already being served", ob.ServePort)
+	}
+	if sc.TCP == nil {
+		sc.TCP = make(map[uint16]*ipn.TCPPortHandler)
+	}
+	sc.TCP[ob.ServePort] = &ipn.TCPPortHandler{TCPForward: target}
+	return s.lc.SetServeConfig(ctx, sc)
+}
@@ -1,6 +1,7 @@
 import cx from "classnames"
 import React from "react"
 import { apiFetch } from "src/api"
+import { useT, useTx } from "src/hooks/locale"
 import { NodeData, NodeUpdate } from "src/hooks/node-data"
 
 // TODO(tailscale/corp#13775): legacy.tsx contains a set of components
@@ -17,6 +18,8 @@ export function Header({
   refreshData: () => void
   updateNode: (update: NodeUpdate) => void
 }) {
+  const t = useT()
+
   return (
     <header className="flex justify-between items-center min-width-0 py-2 mb-8">
       <svg
@@ -25,7 +28,7 @@ export function Header({
         viewBox="0 0 23 23"
         fill="none"
         xmlns="http://www.w3.org/2000/svg"
-        className="flex-shrink-0 mr-4"
+        className="flex-shrink-0 me-4"
       >
         <circle
           opacity="0.2"
@@ -67,39 +70,43 @@ export function Header({
           fill="currentColor"
         ></circle>
       </svg>
-      <div className="flex items-center justify-end space-x-2 w-2/3">
+      <div className="flex items-center justify-end space-x-2 rtl:space-x-reverse w-2/3">
         {data.Profile &&
           data.Status !== "NoState" &&
           data.Status !== "NeedsLogin" && (
             <>
-              <div className="text-right w-full leading-4">
+              <div className="text-end w-full leading-4">
                 <h4 className="truncate leading-normal">
                   {data.Profile.LoginName}
                 </h4>
-                <div className="text-xs text-gray-500 text-right">
+                <div className="text-xs text-gray-500 text-end">
                   <button
                     onClick={() => updateNode({ Reauthenticate: true })}
                     className="hover:text-gray-700"
                   >
-                    Switch account
+                    {t("header.switchAccount")}
                   </button>{" "}
                   |{" "}
                   <button
                     onClick={() => updateNode({ Reauthenticate: true })}
                     className="hover:text-gray-700"
                   >
-                    Reauthenticate
+                    {t("header.reauthenticate")}
                   </button>{" "}
                   |{" "}
                   <button
                     onClick={() =>
                       apiFetch("/local/v0/logout", "POST")
                         .then(refreshData)
-                        .catch((err) => alert("Logout failed: " + err.message))
+                        .catch((err) =>
+                          alert(
+                            t("header.logoutFailed", { error: err.message })
+                          )
+                        )
                     }
                     className="hover:text-gray-700"
                   >
-                    Logout
+                    {t("header.logout")}
                   </button>
                 </div>
               </div>
@@ -125,6 +132,7 @@ export function Header({
 
 export function IP(props: { data: NodeData }) {
   const { data } = props
+  const t = useT()
 
   if (!data.IP) {
     return null
@@ -135,7 +143,7 @@ export function IP(props: { data: NodeData }) {
       <div className="border border-gray-200 bg-gray-50 rounded-md p-2 pl-3 pr-3 width-full flex items-center justify-between">
         <div className="flex items-center min-width-0">
           <svg
-            className="flex-shrink-0 text-gray-600 mr-3 ml-1"
+            className="flex-shrink-0 text-gray-600 me-3 ms-1"
             xmlns="http://www.w3.org/2000/svg"
             width="20"
             height="20"
@@ -151,14 +159,17 @@ export function IP(props: { data: NodeData }) {
             <line x1="6" y1="6" x2="6.01" y2="6"></line>
             <line x1="6" y1="18" x2="6.01" y2="18"></line>
           </svg>
-          <h4 className="font-semibold truncate mr-2">
-            {data.DeviceName || "Your device"}
+          <h4 className="font-semibold truncate me-2">
+            {data.DeviceName || t("ip.yourDevice")}
           </h4>
         </div>
         <h5>{data.IP}</h5>
       </div>
-      <p className="mt-1 ml-1 mb-6 text-xs text-gray-600">
-        Debug info: Tailscale {data.IPNVersion}, tun={data.TUNMode.toString()}
+      <p className="mt-1 ms-1 mb-6 text-xs text-gray-600">
+        {t("ip.debugInfo", {
+          version: data.IPNVersion,
+          tun: data.TUNMode.toString(),
+        })}
         {data.IsSynology && (
           <>
             , DSM{data.DSMVersion}
@@ -170,10 +181,10 @@ export function IP(props: { data: NodeData }) {
                   href="https://tailscale.com/kb/1152/synology-outbound/"
                   className="link-underline text-gray-600"
                   target="_blank"
-                  aria-label="Configure outbound synology traffic"
+                  aria-label={t("ip.synologyOutboundLabel")}
                   rel="noopener noreferrer"
                 >
-                  outgoing access not configured
+                  {t("ip.synologyOutbound")}
                 </a>
                 )
               </>
@@ -192,6 +203,9 @@ export function State({
   data: NodeData
   updateNode: (update: NodeUpdate) => void
 }) {
+  const t = useT()
+  const tx = useTx()
+
   switch (data.Status) {
     case "NeedsLogin":
     case "NoState":
@@ -200,23 +214,24 @@ export function State({
           <>
             <div className="mb-6">
               <p className="text-gray-700">
-                Your device's key has expired. Reauthenticate this device by
-                logging in again, or{" "}
-                <a
-                  href="https://tailscale.com/kb/1028/key-expiry"
-                  className="link"
-                  target="_blank"
-                >
-                  learn more
-                </a>
-                .
+                {tx("state.keyExpired", {
+                  link: (
+                    <a
+                      href="https://tailscale.com/kb/1028/key-expiry"
+                      className="link"
+                      target="_blank"
+                    >
+                      {t("state.learnMore")}
+                    </a>
+                  ),
+                })}
               </p>
             </div>
             <button
               onClick={() => updateNode({ Reauthenticate: true })}
               className="button button-blue w-full mb-4"
             >
-              Reauthenticate
+              {t("state.reauthenticate")}
             </button>
           </>
         )
@@ -224,44 +239,41 @@ export function State({
         return (
           <>
             <div className="mb-6">
-              <h3 className="text-3xl font-semibold mb-3">Log in</h3>
+              <h3 className="text-3xl font-semibold mb-3">
+                {t("state.logInTitle")}
+              </h3>
               <p className="text-gray-700">
-                Get started by logging in to your Tailscale network.
-                Or,&nbsp;learn&nbsp;more at{" "}
-                <a
-                  href="https://tailscale.com/"
-                  className="link"
-                  target="_blank"
-                >
-                  tailscale.com
-                </a>
-                .
+                {tx("state.getStarted", {
+                  link: (
+                    <a
+                      href="https://tailscale.com/"
+                      className="link"
+                      target="_blank"
+                    >
+                      tailscale.com
+                    </a>
+                  ),
+                })}
               </p>
             </div>
             <button
               onClick={() => updateNode({ Reauthenticate: true })}
               className="button button-blue w-full mb-4"
             >
-              Log In
+              {t("state.logIn")}
             </button>
           </>
         )
       }
     case "NeedsMachineAuth":
       return (
-        <div className="mb-4">
-          This device is authorized, but needs approval from a network admin
-          before it can connect to the network.
-        </div>
+        <div className="mb-4">{t("state.needsMachineAuth")}</div>
       )
     default:
       return (
         <>
           <div className="mb-4">
-            <p>
-              You are connected! Access this device over Tailscale using the
-              device name or IP address above.
-            </p>
+            <p>{t("state.connected")}</p>
           </div>
           <button
             className={cx("button button-medium mb-4", {
@@ -274,16 +286,87 @@ export function State({
             }
           >
             {data.AdvertiseExitNode
-              ? "Stop advertising Exit Node"
-              : "Advertise as Exit Node"}
+              ? t("state.stopAdvertisingExitNode")
+              : t("state.advertiseExitNode")}
           </button>
+          <Approvals data={data} />
         </>
       )
   }
 }
 
+// Approvals shows whether the tailnet admin approved the exit node and
+// subnet routes this node advertises, which don't work until they are.
+function Approvals(props: { data: NodeData }) {
+  const { data } = props
+  const t = useT()
+  const routes = data.RouteApprovals ?? []
+  const pending =
+    (data.AdvertiseExitNode && !data.ExitNodeApproved) ||
+    routes.some((r) => !r.Approved)
+
+  if (!data.AdvertiseExitNode && routes.length === 0) {
+    return null
+  }
+
+  return (
+    <div className="mb-4">
+      <ul className="text-sm">
+        {data.AdvertiseExitNode && (
+          <ApprovalRow
+            label={t("approvals.exitNode")}
+            approved={data.ExitNodeApproved}
+          />
+        )}
+        {routes.map((r) => (
+          <ApprovalRow key={r.Route} label={r.Route} approved={r.Approved} />
+        ))}
+      </ul>
+      {pending && data.AdminURL && (
+        <div className="mt-2 text-sm text-gray-700">
+          <p className="mb-1">{t("approvals.pendingHelp")}</p>
+          <div className="flex items-center">
+            <a
+              href={data.AdminURL}
+              className="link truncate me-2"
+              target="_blank"
+              rel="noopener noreferrer"
+            >
+              {data.AdminURL}
+            </a>
+            <button
+              className="button button-medium"
+              onClick={() => navigator.clipboard.writeText(data.AdminURL)}
+            >
+              {t("approvals.copyLink")}
+            </button>
+          </div>
+        </div>
+      )}
+    </div>
+  )
+}
+
+function ApprovalRow(props: { label: string; approved: boolean }) {
+  const t = useT()
+  return (
+    <li className="flex justify-between py-1 border-b border-gray-200">
+      <span className="font-medium">{props.label}</span>
+      <span
+        className={cx({
+          "text-green-600": props.approved,
+          "text-orange-600": !props.approved,
+        })}
+      >
+        {props.approved ? t("approvals.approved") : t("approvals.pending")}
+      </span>
+    </li>
+  )
+}
+
 export function Footer(props: { data: NodeData }) {
   const { data } = props
+  const t = useT()
 
   return (
     <footer className="container max-w-lg mx-auto text-center">
@@ -291,7 +374,7 @@ export function Footer(props: { data: NodeData }) {
         className="text-xs text-gray-500 hover:text-gray-600"
         href={data.LicensesURL}
       >
-        Open Source Licenses
+        {t("footer.licenses")}
       </a>
     </footer>
   )
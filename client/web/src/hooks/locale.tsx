@@ -0,0 +1,72 @@
+import React, {
+  createContext,
+  ReactNode,
+  useContext,
+  useEffect,
+  useState,
+} from "react"
+import { apiFetch } from "src/api"
+
+export type LocaleData = {
+  Locale: string
+  Dir: "ltr" | "rtl"
+  Messages: Record<string, string>
+  Available: string[]
+}
+
+// useLocaleData returns the message catalog for the locale picked by the
+// server from the "lang" URL parameter or the browser's languages.
+// It also sets the page's language and text direction to match.
+export default function useLocaleData() {
+  const [data, setData] = useState<LocaleData>()
+
+  useEffect(
+    () => {
+      const lang = new URLSearchParams(window.location.search).get("lang")
+      apiFetch("/locale", "GET", undefined, lang ? { lang } : undefined)
+        .then((r) => r.json())
+        .then((d: LocaleData) => {
+          document.documentElement.lang = d.Locale
+          document.documentElement.dir = d.Dir
+          setData(d)
+        })
+        .catch((error) => console.error(error))
+    },
+    // Run once.
+    []
+  )
+
+  return data
+}
+
+export const LocaleContext = createContext<LocaleData | undefined>(undefined)
+
+// useT returns a function that looks up a message by ID in the current
+// locale, replacing its {placeholders} with vars.
+export function useT() {
+  const locale = useContext(LocaleContext)
+  return (id: string, vars?: Record<string, string>) => {
+    let msg = locale?.Messages[id] ?? id
+    for (const [name, value] of Object.entries(vars ?? {})) {
+      msg = msg.split(`{${name}}`).join(value)
+    }
+    return msg
+  }
+}
+
+// useTx is like useT, but replaces placeholders with React nodes, such as
+// links, so that translations can place them as their grammar needs.
+export function useTx() {
+  const locale = useContext(LocaleContext)
+  return (id: string, nodes: Record<string, ReactNode>) => {
+    const msg = locale?.Messages[id] ?? id
+    return msg.split(/(\{\w+\})/).map((part, i) => {
+      const name = part.match(/^\{(\w+)\}$/)?.[1]
+      return (
+        <React.Fragment key={i}>
+          {name !== undefined && name in nodes ? nodes[name] : part}
+        </React.Fragment>
+      )
+    })
+  }
+}
@@ -15,6 +15,14 @@ export type NodeData = {
   IsUnraid: boolean
   UnraidToken: string
   IPNVersion: string
+  ExitNodeApproved: boolean
+  RouteApprovals: RouteApproval[] | null
+  AdminURL: string
+}
+
+export type RouteApproval = {
+  Route: string
+  Approved: boolean
 }
 
 export type UserProfile = {
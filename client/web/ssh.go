@@ -0,0 +1,287 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tailscale/golang-x-crypto/ssh"
+	"nhooyr.io/websocket"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/httpm"
+)
+
+// sshTarget is a node that the web client can open an SSH session to.
+type sshTarget struct {
+	ip       netip.Addr
+	dnsName  string   // MagicDNS name, without the trailing dot
+	hostKeys []string // in authorized_keys format
+}
+
+// findSSHTarget returns the node in st named by host, which may be a
+// Tailscale IP, a full MagicDNS name or its first label. An empty host
+// means this node.
+func findSSHTarget(st *ipnstate.Status, host string) (sshTarget, error) {
+	matches := func(ps *ipnstate.PeerStatus) bool {
+		if ip, err := netip.ParseAddr(host); err == nil {
+			return slices.Contains(ps.TailscaleIPs, ip)
+		}
+		dnsName := strings.TrimSuffix(ps.DNSName, ".")
+		base, _, _ := strings.Cut(dnsName, ".")
+		return strings.EqualFold(strings.TrimSuffix(host, "."), dnsName) || strings.EqualFold(host, base)
+	}
+	target := func(ps *ipnstate.PeerStatus) (sshTarget, error) {
+		if len(ps.TailscaleIPs) == 0 {
+			return sshTarget{}, fmt.Errorf("%s has no Tailscale IP", host)
+		}
+		return sshTarget{
+			ip:       ps.TailscaleIPs[0],
+			dnsName:  strings.TrimSuffix(ps.DNSName, "."),
+			hostKeys: ps.SSH_HostKeys,
+		}, nil
+	}
+	if st.Self != nil && (host == "" || matches(st.Self)) {
+		return target(st.Self)
+	}
+	for _, ps := range st.Peer {
+		if matches(ps) {
+			return target(ps)
+		}
+	}
+	return sshTarget{}, fmt.Errorf("no node %q in tailnet", host)
+}
+
+// hostKeyCallback returns the callback that verifies t's SSH host key
+// against the keys it advertises to the tailnet, or a certificate signed by
+// one of the tailnet's SSH host CAs.
+func (t sshTarget) hostKeyCallback(st *ipnstate.Status) ssh.HostKeyCallback {
+	var cas []ssh.PublicKey
+	if ct := st.CurrentTailnet; ct != nil {
+		for _, s := range ct.SSHHostCAs {
+			if k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s)); err == nil {
+				cas = append(cas, k)
+			}
+		}
+	}
+	cc := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			for _, ca := range cas {
+				if bytes.Equal(ca.Marshal(), auth.Marshal()) {
+					return true
+				}
+			}
+			return false
+		},
+		HostKeyFallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			for _, s := range t.hostKeys {
+				if k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(s)); err == nil && bytes.Equal(k.Marshal(), key.Marshal()) {
+					return nil
+				}
+			}
+			return fmt.Errorf("unknown SSH host key for %s", t.dnsName)
+		},
+	}
+	return cc.CheckHostKey
+}
+
+// authorizeSSHUser reports whether who, the tailnet identity of a browser,
+// may open web SSH sessions from the node whose status is st. As those
+// sessions authenticate as this node, that's only when the node isn't
+// tagged and belongs to who's user, so the SSH policy the target applies
+// is the one it would apply to who.
+func authorizeSSHUser(st *ipnstate.Status, who *apitype.WhoIsResponse) error {
+	if st.Self == nil || st.Self.Tags != nil && st.Self.Tags.Len() > 0 {
+		return errors.New("web SSH is not available on tagged nodes")
+	}
+	if who.Node == nil || who.Node.IsTagged() || who.UserProfile == nil {
+		return errors.New("web SSH is not available from tagged nodes")
+	}
+	if who.UserProfile.ID != st.Self.UserID {
+		return errors.New("web SSH is only available to the user that owns this node")
+	}
+	return nil
+}
+
+// sshControlMsg is a text WebSocket message of a web SSH session. The
+// terminal's input and output are sent as binary messages.
+type sshControlMsg struct {
+	// From the browser:
+	Cols int `json:"cols,omitempty"` // terminal resized
+	Rows int `json:"rows,omitempty"`
+
+	// From the server:
+	Banner string `json:"banner,omitempty"` // such as a check mode URL to visit
+	Error  string `json:"error,omitempty"`
+	Exit   *int   `json:"exit,omitempty"` // the session ended
+}
+
+// serveSSH serves a browser terminal's WebSocket connection, relaying it to
+// a Tailscale SSH session on this node or a peer.
+//
+// The query parameters are "host", the node to connect to (this node if
+// empty); "user", the user to log in as; and "cols" and "rows", the
+// terminal's initial size. Whether the session is allowed, and whether the
+// user must first re-authenticate in check mode, is up to the tailnet's SSH
+// policy, enforced by the node's Tailscale SSH server. Check mode prompts
+// are sent to the browser as banners.
+//
+// The session is dialed from this node, so the SSH server applies the
+// policy to this node's identity. The browser must therefore be on a
+// node of the same user that owns this one; see authorizeSSHUser.
+func (s *Server) serveSSH(w http.ResponseWriter, r *http.Request) {
+	if r.Method != httpm.GET {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	user := q.Get("user")
+	if user == "" {
+		http.Error(w, "missing user", http.StatusBadRequest)
+		return
+	}
+	cols, _ := strconv.Atoi(q.Get("cols"))
+	rows, _ := strconv.Atoi(q.Get("rows"))
+	if cols <= 0 || rows <= 0 {
+		cols, rows = 80, 24
+	}
+	st, err := s.lc.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	who, err := s.lc.WhoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "web SSH is only available from browsers on the tailnet", http.StatusForbidden)
+		return
+	}
+	if err := authorizeSSHUser(st, who); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	t, err := findSSHTarget(st, q.Get("host"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Accept checks that the Origin matches the Host, so other sites
+	// can't open sessions with the user's cookies.
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer c.Close(websocket.StatusInternalError, "")
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	sendCtl := func(m sshControlMsg) error {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		return c.Write(ctx, websocket.MessageText, b)
+	}
+
+	code, err := s.runSSHSession(ctx, c, st, t, user, cols, rows, sendCtl)
+	if err != nil {
+		sendCtl(sshControlMsg{Error: err.Error()})
+		c.Close(websocket.StatusNormalClosure, "")
+		return
+	}
+	sendCtl(sshControlMsg{Exit: &code})
+	c.Close(websocket.StatusNormalClosure, "")
+}
+
+// runSSHSession runs an interactive shell as user on t, relaying its
+// terminal to and from c until the shell exits, and returns its exit code.
+func (s *Server) runSSHSession(ctx context.Context, c *websocket.Conn, st *ipnstate.Status, t sshTarget, user string, cols, rows int, sendCtl func(sshControlMsg) error) (exitCode int, _ error) {
+	conn, err := s.lc.DialTCP(ctx, t.ip.String(), 22)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	sc, chans, reqs, err := ssh.NewClientConn(conn, net.JoinHostPort(t.dnsName, "22"), &ssh.ClientConfig{
+		User:            user,
+		HostKeyCallback: t.hostKeyCallback(st),
+		BannerCallback: func(msg string) error {
+			return sendCtl(sshControlMsg{Banner: msg})
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	client := ssh.NewClient(sc, chans, reqs)
+	defer client.Close()
+	sess, err := client.NewSession()
+	if err != nil {
+		return 0, err
+	}
+	defer sess.Close()
+	out := &wsWriter{ctx: ctx, c: c}
+	sess.Stdout = out
+	sess.Stderr = out
+	stdin, err := sess.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := sess.RequestPty("xterm-256color", rows, cols, ssh.TerminalModes{}); err != nil {
+		return 0, err
+	}
+	if err := sess.Shell(); err != nil {
+		return 0, err
+	}
+
+	go func() {
+		defer stdin.Close()
+		for {
+			typ, b, err := c.Read(ctx)
+			if err != nil {
+				// The browser went away; end the session.
+				client.Close()
+				return
+			}
+			if typ == websocket.MessageBinary {
+				if _, err := stdin.Write(b); err != nil {
+					return
+				}
+				continue
+			}
+			var m sshControlMsg
+			if json.Unmarshal(b, &m) == nil && m.Cols > 0 && m.Rows > 0 {
+				sess.WindowChange(m.Rows, m.Cols)
+			}
+		}
+	}()
+
+	err = sess.Wait()
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		return ee.ExitStatus(), nil
+	}
+	return 0, err
+}
+
+// wsWriter is an io.Writer that sends each write as a binary WebSocket
+// message.
+type wsWriter struct {
+	ctx context.Context
+	c   *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	if err := w.c.Write(w.ctx, websocket.MessageBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -17,6 +17,7 @@
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 
 	"github.com/gorilla/csrf"
 	"tailscale.com/client/tailscale"
@@ -26,7 +27,9 @@
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/licenses"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/views"
 	"tailscale.com/util/httpm"
 	"tailscale.com/version/distro"
 )
@@ -39,6 +42,10 @@ type Server struct {
 
 	cgiMode    bool
 	pathPrefix string
+	fleetMode  bool // see ServerOpts.FleetMode
+
+	onboardingMu sync.Mutex
+	onboarding   *onboardingState // or nil if not loaded yet
 
 	assetsHandler http.Handler // serves frontend assets
 	apiHandler    http.Handler // serves api endpoints; csrf-protected
@@ -57,21 +64,31 @@ type ServerOpts struct {
 	// LocalClient is the tailscale.LocalClient to use for this web server.
 	// If nil, a new one will be created.
 	LocalClient *tailscale.LocalClient
+
+	// FleetMode enables the fleet dashboard, which shows the versions,
+	// health warnings, key expiries and pending updates of the tailnet's
+	// nodes. Peers only report them to a node granted the
+	// tailcfg.PeerCapabilityFleetStatus capability.
+	FleetMode bool
 }
 
 // NewServer constructs a new Tailscale web client server.
 // The provided context should live for the duration of the Server's lifetime.
-func NewServer(ctx context.Context, opts ServerOpts) (s *Server, cleanup func()) {
+func NewServer(ctx context.Context, opts ServerOpts) (s *Server, cleanup func(), err error) {
 	if opts.LocalClient == nil {
-		opts.LocalClient = &tailscale.LocalClient{}
+		opts.LocalClient = &tailscale.LocalClient{PrefSource: ipn.PrefSourceWeb}
 	}
 	s = &Server{
 		devMode:    opts.DevMode,
 		lc:         opts.LocalClient,
 		cgiMode:    opts.CGIMode,
 		pathPrefix: opts.PathPrefix,
+		fleetMode:  opts.FleetMode,
+	}
+	s.assetsHandler, cleanup, err = assetsHandler(opts.DevMode)
+	if err != nil {
+		return nil, nil, err
 	}
-	s.assetsHandler, cleanup = assetsHandler(opts.DevMode)
 
 	// Create handler for "/api" requests with CSRF protection.
 	// We don't require secure cookies, since the web client is regularly used
@@ -82,7 +99,7 @@ func NewServer(ctx context.Context, opts ServerOpts) (s *Server, cleanup func())
 	s.apiHandler = csrfProtect(http.HandlerFunc(s.serveAPI))
 
 	s.lc.IncrementCounter(context.Background(), "web_client_initialization", 1)
-	return s, cleanup
+	return s, cleanup, nil
 }
 
 // ServeHTTP processes all requests for the Tailscale web client.
@@ -150,6 +167,56 @@ func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		}
 		return
+	case path == "/peers":
+		if r.Method != httpm.GET {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveGetPeers(w, r)
+		return
+	case path == "/peer-notes":
+		s.servePeerNotes(w, r)
+		return
+	case path == "/peer-traffic":
+		if r.Method != httpm.GET {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveGetPeerTraffic(w, r)
+		return
+	case path == "/access":
+		if r.Method != httpm.GET {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveGetAccess(w, r)
+		return
+	case path == "/tags":
+		s.serveTags(w, r)
+		return
+	case path == "/fleet":
+		if r.Method != httpm.GET {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveGetFleet(w, r)
+		return
+	case strings.HasPrefix(path, "/diagnostics/peer/"):
+		if r.Method != httpm.GET {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s.serveGetPeerDiagnostics(w, r)
+		return
+	case path == "/ssh":
+		s.serveSSH(w, r)
+		return
+	case path == "/onboarding":
+		s.serveOnboarding(w, r)
+		return
+	case path == "/locale":
+		s.serveLocale(w, r)
+		return
 	case strings.HasPrefix(path, "/local/"):
 		s.proxyRequestToLocalAPI(w, r)
 		return
@@ -171,6 +238,16 @@ type nodeData struct {
 	IsUnraid          bool
 	UnraidToken       string
 	IPNVersion        string
+
+	// ExitNodeApproved is whether the tailnet admin approved this node
+	// as an exit node, if it advertises itself as one.
+	ExitNodeApproved bool
+	// RouteApprovals are the subnet routes this node advertises, with
+	// whether the tailnet admin approved each.
+	RouteApprovals []routeApproval
+	// AdminURL is this node's page in the admin console, where its
+	// routes are approved.
+	AdminURL string
 }
 
 func (s *Server) getNodeData(ctx context.Context) (*nodeData, error) {
@@ -211,10 +288,38 @@ func (s *Server) getNodeData(ctx context.Context) (*nodeData, error) {
 	}
 	if len(st.TailscaleIPs) != 0 {
 		data.IP = st.TailscaleIPs[0].String()
+		data.AdminURL = prefs.AdminPageURL() + "/" + data.IP
+	}
+	var allowed views.Slice[netip.Prefix]
+	if st.Self.AllowedIPs != nil {
+		allowed = *st.Self.AllowedIPs
 	}
+	data.RouteApprovals, data.ExitNodeApproved = routeApprovals(prefs.AdvertiseRoutes, allowed)
 	return data, nil
 }
 
+// routeApproval is an advertised subnet route and whether it's approved.
+type routeApproval struct {
+	Route    string
+	Approved bool
+}
+
+// routeApprovals reports which of the advertised subnet routes are
+// approved, in that they're among the node's allowed IPs, and whether
+// advertising an exit node is approved.
+func routeApprovals(advertised []netip.Prefix, allowed views.Slice[netip.Prefix]) (routes []routeApproval, exitNodeApproved bool) {
+	for _, r := range advertised {
+		if r.Bits() == 0 {
+			continue // an exit node route
+		}
+		routes = append(routes, routeApproval{
+			Route:    r.String(),
+			Approved: views.SliceContains(allowed, r),
+		})
+	}
+	return routes, tsaddr.ContainsExitRoutes(allowed)
+}
+
 func (s *Server) serveGetNodeDataJSON(w http.ResponseWriter, r *http.Request) {
 	data, err := s.getNodeData(r.Context())
 	if err != nil {
@@ -228,6 +333,132 @@ func (s *Server) serveGetNodeDataJSON(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 }
 
+// peerData is a peer as displayed in the web client, along with the
+// nickname and notes the user has assigned to it.
+type peerData struct {
+	ID       tailcfg.StableNodeID
+	Name     string // first label of DNSName, or HostName
+	DNSName  string
+	IP       string
+	OS       string
+	Online   bool
+	Nickname string `json:",omitempty"`
+	Notes    string `json:",omitempty"`
+}
+
+func (s *Server) serveGetPeers(w http.ResponseWriter, r *http.Request) {
+	st, err := s.lc.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	notes, err := s.lc.PeerNotes(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	peers := make([]peerData, 0, len(st.Peer))
+	for _, ps := range st.Peer {
+		p := peerData{
+			ID:       ps.ID,
+			Name:     peerName(ps),
+			DNSName:  ps.DNSName,
+			OS:       ps.OS,
+			Online:   ps.Online,
+			Nickname: notes[ps.ID].Nickname,
+			Notes:    notes[ps.ID].Notes,
+		}
+		if len(ps.TailscaleIPs) != 0 {
+			p.IP = ps.TailscaleIPs[0].String()
+		}
+		peers = append(peers, p)
+	}
+	slices.SortFunc(peers, func(a, b peerData) int {
+		return strings.Compare(a.DNSName, b.DNSName)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// peerName returns the name of ps shown in the web client: the first label
+// of its DNS name, or its hostname.
+func peerName(ps *ipnstate.PeerStatus) string {
+	if name := strings.Split(ps.DNSName, ".")[0]; name != "" {
+		return name
+	}
+	return ps.HostName
+}
+
+// peerTrafficData is the recent traffic exchanged with a peer, for the web
+// client's usage graphs.
+type peerTrafficData struct {
+	ID      tailcfg.StableNodeID
+	Name    string
+	Buckets []ipnstate.TrafficBucket // oldest first, one per minute
+}
+
+func (s *Server) serveGetPeerTraffic(w http.ResponseWriter, r *http.Request) {
+	st, err := s.lc.Status(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	traffic, err := s.lc.PeerTraffic(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	peers := make([]peerTrafficData, 0, len(traffic))
+	for _, pt := range traffic {
+		ps, ok := st.Peer[pt.NodeKey]
+		if !ok {
+			continue
+		}
+		peers = append(peers, peerTrafficData{
+			ID:      ps.ID,
+			Name:    peerName(ps),
+			Buckets: pt.Buckets,
+		})
+	}
+	slices.SortFunc(peers, func(a, b peerTrafficData) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(peers)
+}
+
+// servePeerNotes exports (GET), updates (POST) or imports (PUT) the
+// nicknames and notes assigned to peers, keyed by stable node ID.
+//
+// A POST merges the notes into the existing ones, with an empty note
+// removing a peer's notes. A PUT replaces all existing notes.
+func (s *Server) servePeerNotes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case httpm.GET:
+		notes, err := s.lc.PeerNotes(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notes)
+	case httpm.POST, httpm.PUT:
+		var notes map[tailcfg.StableNodeID]ipn.PeerNote
+		if err := json.NewDecoder(r.Body).Decode(&notes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.lc.SetPeerNotes(r.Context(), notes, r.Method == httpm.PUT); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, "{}")
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 type nodeUpdate struct {
 	AdvertiseRoutes   string
 	AdvertiseExitNode bool
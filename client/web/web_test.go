@@ -4,16 +4,30 @@
 package web
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/interfaces"
 	"tailscale.com/net/memnet"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
 )
 
 func TestQnapAuthnURL(t *testing.T) {
@@ -129,3 +143,628 @@ func TestServeAPI(t *testing.T) {
 		})
 	}
 }
+
+func TestServePeers(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	notes := map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n1": {Nickname: "nas", Notes: "in the closet"},
+	}
+	// Serve a fake localapi with a status and peer notes.
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(&ipnstate.Status{
+				Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+					key.NewNode().Public(): {ID: "n1", DNSName: "nas-1234.example.ts.net.", Online: true},
+					key.NewNode().Public(): {ID: "n2", DNSName: "laptop.example.ts.net."},
+				},
+			})
+		case "/localapi/v0/peer-notes":
+			if r.Method == "POST" {
+				var update map[tailcfg.StableNodeID]ipn.PeerNote
+				json.NewDecoder(r.Body).Decode(&update)
+				if r.FormValue("replace") == "true" {
+					notes = update
+				} else {
+					for id, n := range update {
+						notes[id] = n
+					}
+				}
+				return
+			}
+			json.NewEncoder(w).Encode(notes)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	do := func(method, path, body string) string {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.serveAPI(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %v: %s", method, path, w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+
+	do("POST", "/peer-notes", `{"n2":{"Nickname":"work laptop"}}`)
+	var got []peerData
+	if err := json.Unmarshal([]byte(do("GET", "/peers", "")), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []peerData{
+		{ID: "n2", Name: "laptop", DNSName: "laptop.example.ts.net.", Nickname: "work laptop"},
+		{ID: "n1", Name: "nas-1234", DNSName: "nas-1234.example.ts.net.", Online: true, Nickname: "nas", Notes: "in the closet"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("peers = %+v; want %+v", got, want)
+	}
+
+	do("PUT", "/peer-notes", `{"n1":{"Nickname":"storage"}}`)
+	wantExport := `{"n1":{"Nickname":"storage"}}`
+	if got := strings.TrimSpace(do("GET", "/peer-notes", "")); got != wantExport {
+		t.Errorf("export = %s; want %s", got, wantExport)
+	}
+}
+
+func TestServePeerTraffic(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	k1, k2, gone := key.NewNode().Public(), key.NewNode().Public(), key.NewNode().Public()
+	start := time.Unix(1700000000, 0).UTC()
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(&ipnstate.Status{
+				Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+					k1: {ID: "n1", DNSName: "nas.example.ts.net."},
+					k2: {ID: "n2", HostName: "laptop"},
+				},
+			})
+		case "/localapi/v0/peer-traffic":
+			json.NewEncoder(w).Encode([]ipnstate.PeerTraffic{
+				{NodeKey: k1, Buckets: []ipnstate.TrafficBucket{{Start: start, RxBytes: 10, TxBytes: 20}}},
+				{NodeKey: k2},
+				{NodeKey: gone, Buckets: []ipnstate.TrafficBucket{{Start: start, RxBytes: 1}}},
+			})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	r := httptest.NewRequest("GET", "/api/peer-traffic", nil)
+	w := httptest.NewRecorder()
+	s.serveAPI(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %s", w.Code, w.Body)
+	}
+	var got []peerTrafficData
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []peerTrafficData{
+		{ID: "n2", Name: "laptop"},
+		{ID: "n1", Name: "nas", Buckets: []ipnstate.TrafficBucket{{Start: start, RxBytes: 10, TxBytes: 20}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestServePeerDiagnostics(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	handshake := time.Unix(1700000000, 0).UTC()
+	endpoints := &ipnstate.EndpointCandidates{
+		Self: []ipnstate.EndpointCandidate{{Addr: netip.MustParseAddrPort("192.168.1.2:41641"), Source: "local"}},
+		Peer: []ipnstate.EndpointCandidate{{Addr: netip.MustParseAddrPort("203.0.113.5:41641"), Source: "netmap", Pongs: 3, Best: true}},
+	}
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(&ipnstate.Status{
+				Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+					k1: {ID: "n1", PublicKey: k1, DNSName: "nas.example.ts.net.", TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")}, CurAddr: "203.0.113.5:41641", Relay: "nyc", LastHandshake: handshake, Online: true},
+					k2: {ID: "n2", PublicKey: k2, HostName: "laptop"},
+				},
+			})
+		case "/localapi/v0/debug-endpoints":
+			if ip := r.FormValue("ip"); ip != "100.64.0.1" {
+				http.Error(w, "unexpected ip "+ip, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(endpoints)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		s.serveAPI(w, r)
+		return w
+	}
+	w := get("/api/diagnostics/peer/n1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %s", w.Code, w.Body)
+	}
+	var got peerDiagnostics
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := peerDiagnostics{
+		ID:            "n1",
+		Name:          "nas",
+		IP:            "100.64.0.1",
+		Online:        true,
+		PublicKey:     k1,
+		Path:          "direct",
+		CurAddr:       "203.0.113.5:41641",
+		DERPRegion:    "nyc",
+		LastHandshake: handshake,
+		Endpoints:     endpoints.Peer,
+		SelfEndpoints: endpoints.Self,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	w = get("/api/diagnostics/peer/n2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %v: %s", w.Code, w.Body)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "none" || got.Endpoints != nil {
+		t.Errorf("peer without IPs: Path = %q, Endpoints = %v; want none, nil", got.Path, got.Endpoints)
+	}
+
+	if w := get("/api/diagnostics/peer/n3"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown peer: status %v; want 404", w.Code)
+	}
+}
+
+func TestFindSSHTarget(t *testing.T) {
+	st := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{
+			DNSName:      "self.example.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				DNSName:      "peer.example.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.2")},
+				SSH_HostKeys: []string{"ssh-ed25519 AAAA"},
+			},
+		},
+	}
+	tests := []struct {
+		host    string
+		wantIP  string
+		wantErr bool
+	}{
+		{host: "", wantIP: "100.64.0.1"},
+		{host: "self", wantIP: "100.64.0.1"},
+		{host: "peer", wantIP: "100.64.0.2"},
+		{host: "PEER.example.ts.net.", wantIP: "100.64.0.2"},
+		{host: "100.64.0.2", wantIP: "100.64.0.2"},
+		{host: "100.64.0.3", wantErr: true},
+		{host: "other", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := findSSHTarget(st, tt.host)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("findSSHTarget(%q) = %v; want error", tt.host, got.ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("findSSHTarget(%q): %v", tt.host, err)
+			continue
+		}
+		if got.ip.String() != tt.wantIP {
+			t.Errorf("findSSHTarget(%q) = %v; want %v", tt.host, got.ip, tt.wantIP)
+		}
+	}
+}
+
+func TestAuthorizeSSHUser(t *testing.T) {
+	tags := views.SliceOf([]string{"tag:server"})
+	self := &ipnstate.PeerStatus{UserID: 1}
+	taggedSelf := &ipnstate.PeerStatus{UserID: 1, Tags: &tags}
+	who := func(uid tailcfg.UserID, tags ...string) *apitype.WhoIsResponse {
+		return &apitype.WhoIsResponse{
+			Node:        &tailcfg.Node{User: uid, Tags: tags},
+			UserProfile: &tailcfg.UserProfile{ID: uid},
+		}
+	}
+	tests := []struct {
+		name   string
+		self   *ipnstate.PeerStatus
+		who    *apitype.WhoIsResponse
+		wantOK bool
+	}{
+		{"owner", self, who(1), true},
+		{"other-user", self, who(2), false},
+		{"tagged-browser", self, who(1, "tag:ci"), false},
+		{"tagged-node", taggedSelf, who(1), false},
+	}
+	for _, tt := range tests {
+		err := authorizeSSHUser(&ipnstate.Status{Self: tt.self}, tt.who)
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: authorizeSSHUser = %v; want ok=%v", tt.name, err, tt.wantOK)
+		}
+	}
+}
+
+func TestFleetStatus(t *testing.T) {
+	st := &ipnstate.Status{
+		Version: "1.50.0",
+		Self:    &ipnstate.PeerStatus{ID: "self", DNSName: "admin.tailnet.ts.net.", HostName: "admin"},
+		ClientVersion: &tailcfg.ClientVersion{
+			LatestVersion: "1.52.0",
+		},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {ID: "b", DNSName: "b.tailnet.ts.net.", Online: true, PeerAPIURL: []string{"http://100.64.0.2:1234"}},
+			key.NewNode().Public(): {ID: "a", DNSName: "a.tailnet.ts.net.", Online: true, PeerAPIURL: []string{"http://100.64.0.1:1234"}},
+			key.NewNode().Public(): {ID: "c", DNSName: "c.tailnet.ts.net.", Online: false},
+		},
+	}
+	fetch := func(ctx context.Context, url string) (*ipnstate.FleetNodeStatus, error) {
+		if url == "http://100.64.0.2:1234" {
+			return nil, errors.New("no access")
+		}
+		return &ipnstate.FleetNodeStatus{Version: "1.48.0"}, nil
+	}
+	got := fleetStatus(context.Background(), st, fetch)
+
+	var summary []string
+	for _, n := range got {
+		s := n.Name + ":"
+		if n.Status != nil {
+			s += n.Status.Version + "," + n.Status.UpdateAvailable
+		} else {
+			s += "error=" + n.Error
+		}
+		summary = append(summary, s)
+	}
+	want := []string{
+		"admin:1.50.0,1.52.0",
+		"a:1.48.0,",
+		"b:error=no access",
+		"c:error=offline",
+	}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("got %q, want %q", summary, want)
+	}
+	if !got[0].IsSelf {
+		t.Error("first node isn't self")
+	}
+}
+
+func TestOnboarding(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	var (
+		edited *ipn.MaskedPrefs
+		served *ipn.ServeConfig
+	)
+	// Serve a fake localapi for a logged in node with an exit node route.
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(&ipnstate.Status{
+				BackendState: ipn.Running.String(),
+				Self:         &ipnstate.PeerStatus{DNSName: "nas-1.example.ts.net."},
+			})
+		case "/localapi/v0/prefs":
+			if r.Method == "PATCH" {
+				edited = new(ipn.MaskedPrefs)
+				json.NewDecoder(r.Body).Decode(edited)
+			}
+			json.NewEncoder(w).Encode(&ipn.Prefs{
+				AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")},
+			})
+		case "/localapi/v0/serve-config":
+			if r.Method == "POST" {
+				served = new(ipn.ServeConfig)
+				json.NewDecoder(r.Body).Decode(served)
+				return
+			}
+			io.WriteString(w, "{}")
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	do := func(method, body string, wantCode int) onboardingData {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api/onboarding", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.serveAPI(w, r)
+		if w.Code != wantCode {
+			t.Fatalf("%s %s: status %v: %s", method, body, w.Code, w.Body)
+		}
+		var d onboardingData
+		if wantCode == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return d
+	}
+
+	// A node that's already logged in has nothing to onboard.
+	if d := do("GET", "", 200); d.Step != stepDone {
+		t.Fatalf("step = %q; want done", d.Step)
+	}
+
+	// A new node that just logged in is asked to confirm its name.
+	s.onboarding = &onboardingState{Step: stepLogin}
+	if d := do("GET", "", 200); d.Step != stepHostname || d.Hostname != "nas-1" {
+		t.Fatalf("got %+v; want hostname step suggesting nas-1", d.onboardingState)
+	}
+	do("POST", `{"Step":"features"}`, http.StatusBadRequest)
+	do("POST", `{"Step":"hostname","Hostname":"bad name"}`, http.StatusBadRequest)
+	do("POST", `{"Step":"hostname","Back":true}`, http.StatusBadRequest)
+	do("POST", `{"Step":"hostname","Hostname":"nas"}`, 200)
+	do("POST", `{"Step":"features","RunSSH":true}`, 200)
+	if d := do("POST", `{"Step":"routes","Back":true}`, 200); d.Step != stepFeatures {
+		t.Fatalf("step after back = %q; want features", d.Step)
+	}
+	do("POST", `{"Step":"features","RunSSH":true,"ServePort":5000}`, 200)
+	do("POST", `{"Step":"routes","Routes":["100.64.0.0/24"]}`, http.StatusBadRequest)
+	d := do("POST", `{"Step":"routes","Routes":["192.168.1.0/24"]}`, 200)
+	want := onboardingState{
+		Step:      stepSummary,
+		Hostname:  "nas",
+		RunSSH:    true,
+		ServePort: 5000,
+		Routes:    []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24")},
+	}
+	if !reflect.DeepEqual(d.onboardingState, want) {
+		t.Fatalf("got %+v; want %+v", d.onboardingState, want)
+	}
+	if edited != nil {
+		t.Fatal("prefs edited before the summary was confirmed")
+	}
+
+	if d := do("POST", `{"Step":"summary"}`, 200); d.Step != stepDone {
+		t.Fatalf("step = %q; want done", d.Step)
+	}
+	if edited == nil || !edited.HostnameSet || edited.Hostname != "nas" || !edited.RunSSH {
+		t.Errorf("edited prefs = %+v", edited)
+	}
+	wantRoutes := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("192.168.1.0/24")}
+	if edited != nil && !reflect.DeepEqual(edited.AdvertiseRoutes, wantRoutes) {
+		t.Errorf("routes = %v; want %v", edited.AdvertiseRoutes, wantRoutes)
+	}
+	if h := served.GetTCPPortHandler(5000); h == nil || h.TCPForward != "127.0.0.1:5000" {
+		t.Errorf("serve config = %+v", served)
+	}
+	do("POST", `{"Step":"done"}`, http.StatusBadRequest)
+}
+
+func TestDetectLANRoutes(t *testing.T) {
+	up := func(name string) interfaces.Interface {
+		return interfaces.Interface{Interface: &net.Interface{Name: name, Flags: net.FlagUp}}
+	}
+	ifst := &interfaces.State{
+		Interface: map[string]interfaces.Interface{
+			"eth0":       up("eth0"),
+			"eth1":       up("eth1"),
+			"tailscale0": up("tailscale0"),
+			"down0":      {Interface: &net.Interface{Name: "down0"}},
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0":       {netip.MustParsePrefix("192.168.1.20/24"), netip.MustParsePrefix("fd12:3456::20/64")},
+			"eth1":       {netip.MustParsePrefix("10.0.5.3/16"), netip.MustParsePrefix("203.0.113.7/24")},
+			"tailscale0": {netip.MustParsePrefix("100.101.102.103/32"), netip.MustParsePrefix("fd7a:115c:a1e0::1/48")},
+			"down0":      {netip.MustParsePrefix("172.16.0.1/24")},
+		},
+	}
+	got := detectLANRoutes(ifst)
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/16"),
+		netip.MustParsePrefix("192.168.1.0/24"),
+		netip.MustParsePrefix("fd12:3456::/64"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestRouteApprovals(t *testing.T) {
+	pfxs := func(ss ...string) []netip.Prefix {
+		var ret []netip.Prefix
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	advertised := pfxs("10.0.0.0/16", "192.168.1.0/24", "0.0.0.0/0", "::/0")
+
+	routes, exitNode := routeApprovals(advertised, views.SliceOf(pfxs("100.64.0.1/32", "192.168.1.0/24")))
+	want := []routeApproval{
+		{Route: "10.0.0.0/16", Approved: false},
+		{Route: "192.168.1.0/24", Approved: true},
+	}
+	if !reflect.DeepEqual(routes, want) || exitNode {
+		t.Errorf("got %v, exit node %v; want %v, false", routes, exitNode, want)
+	}
+
+	routes, exitNode = routeApprovals(advertised, views.SliceOf(pfxs("100.64.0.1/32", "10.0.0.0/16", "192.168.1.0/24", "0.0.0.0/0", "::/0")))
+	if routes[0].Approved != true || routes[1].Approved != true || !exitNode {
+		t.Errorf("with all approved, got %v, exit node %v", routes, exitNode)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	tests := []struct {
+		query          string
+		acceptLanguage string
+		wantLocale     string
+		wantDir        string
+	}{
+		{"", "", "en", "ltr"},
+		{"", "fr-FR, de;q=0.8, en;q=0.5", "de", "ltr"},
+		{"", "en;q=0.1, ja-JP", "ja", "ltr"},
+		{"", "zh-CN,zh;q=0.9", "zh", "ltr"},
+		{"", "ar-EG", "ar", "rtl"},
+		{"", "de;q=0, xx", "en", "ltr"},
+		{"?lang=ar", "de", "ar", "rtl"},
+		{"?lang=xx", "ja", "ja", "ltr"},
+	}
+	s := &Server{}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/locale"+tt.query, nil)
+		if tt.acceptLanguage != "" {
+			r.Header.Set("Accept-Language", tt.acceptLanguage)
+		}
+		w := httptest.NewRecorder()
+		s.serveLocale(w, r)
+		var got localeData
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Locale != tt.wantLocale || got.Dir != tt.wantDir {
+			t.Errorf("%q, Accept-Language %q: got %s (%s); want %s (%s)", tt.query, tt.acceptLanguage, got.Locale, got.Dir, tt.wantLocale, tt.wantDir)
+		}
+	}
+
+	// Every catalog translates only messages in the English one, keeping
+	// their placeholders.
+	placeholders := func(msg string) []string {
+		var ps []string
+		for _, part := range strings.Split(msg, "{")[1:] {
+			p, _, _ := strings.Cut(part, "}")
+			ps = append(ps, p)
+		}
+		return ps
+	}
+	cats := loadCatalogs()
+	for _, l := range []string{"en", "de", "ja", "zh", "ar"} {
+		if _, ok := cats[l]; !ok {
+			t.Errorf("no %s catalog", l)
+		}
+	}
+	for l, msgs := range cats {
+		for id, msg := range msgs {
+			en, ok := cats[defaultLocale][id]
+			if !ok {
+				t.Errorf("%s: message %q isn't in the %s catalog", l, id, defaultLocale)
+				continue
+			}
+			if got, want := placeholders(msg), placeholders(en); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: message %q has placeholders %q; want %q", l, id, got, want)
+			}
+		}
+	}
+}
+
+func TestServeAccessAndTags(t *testing.T) {
+	lal := memnet.Listen("local-tailscaled.sock:80")
+	defer lal.Close()
+	prefs := &ipn.Prefs{AdvertiseTags: []string{"tag:server", "tag:web"}}
+	granted := views.SliceOf([]string{"tag:db", "tag:server"})
+	localapi := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/localapi/v0/status":
+			json.NewEncoder(w).Encode(&ipnstate.Status{
+				Self: &ipnstate.PeerStatus{ID: "self", Tags: &granted},
+			})
+		case "/localapi/v0/prefs":
+			if r.Method == "PATCH" {
+				var mp ipn.MaskedPrefs
+				json.NewDecoder(r.Body).Decode(&mp)
+				prefs.ApplyEdits(&mp)
+			}
+			json.NewEncoder(w).Encode(prefs)
+		case "/localapi/v0/peer-notes":
+			json.NewEncoder(w).Encode(map[tailcfg.StableNodeID]ipn.PeerNote{
+				"n1": {Nickname: "nas"},
+			})
+		case "/localapi/v0/access-summary":
+			json.NewEncoder(w).Encode(&ipnstate.AccessSummary{
+				Peers: []ipnstate.PeerAccess{
+					{ID: "n1", DNSName: "nas.example.ts.net.", Ports: []string{"tcp:22"}},
+				},
+			})
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})}
+	defer localapi.Close()
+	go localapi.Serve(lal)
+	s := &Server{lc: &tailscale.LocalClient{Dial: lal.Dial}}
+
+	do := func(method, path, body string, wantCode int) []byte {
+		t.Helper()
+		r := httptest.NewRequest(method, "/api"+path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		s.serveAPI(w, r)
+		if w.Code != wantCode {
+			t.Fatalf("%s %s: status %v, want %v: %s", method, path, w.Code, wantCode, w.Body)
+		}
+		return w.Body.Bytes()
+	}
+
+	var access accessData
+	if err := json.Unmarshal(do("GET", "/access", "", http.StatusOK), &access); err != nil {
+		t.Fatal(err)
+	}
+	wantAccess := accessData{Peers: []accessPeer{{
+		PeerAccess: ipnstate.PeerAccess{ID: "n1", DNSName: "nas.example.ts.net.", Ports: []string{"tcp:22"}},
+		Name:       "nas",
+		Nickname:   "nas",
+	}}}
+	if !reflect.DeepEqual(access, wantAccess) {
+		t.Errorf("access = %+v; want %+v", access, wantAccess)
+	}
+
+	var tags tagsData
+	if err := json.Unmarshal(do("GET", "/tags", "", http.StatusOK), &tags); err != nil {
+		t.Fatal(err)
+	}
+	wantTags := tagsData{
+		Advertised: []string{"tag:server", "tag:web"},
+		Granted:    []string{"tag:db", "tag:server"},
+		Pending:    []string{"tag:web"},
+		Removing:   []string{"tag:db"},
+	}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("tags = %+v; want %+v", tags, wantTags)
+	}
+
+	do("POST", "/tags", `{"Tags":["bogus"]}`, http.StatusBadRequest)
+	tags = tagsData{}
+	if err := json.Unmarshal(do("POST", "/tags", `{"Tags":["tag:server","tag:db","tag:db"]}`, http.StatusOK), &tags); err != nil {
+		t.Fatal(err)
+	}
+	wantTags = tagsData{
+		Advertised: []string{"tag:db", "tag:server"},
+		Granted:    []string{"tag:db", "tag:server"},
+	}
+	if !reflect.DeepEqual(tags, wantTags) {
+		t.Errorf("after POST, tags = %+v; want %+v", tags, wantTags)
+	}
+}
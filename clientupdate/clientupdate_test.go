@@ -13,6 +13,7 @@
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestUpdateDebianAptSourcesListBytes(t *testing.T) {
@@ -761,3 +762,47 @@ func TestWriteFileSymlink(t *testing.T) {
 		}
 	}
 }
+
+func TestPolicy(t *testing.T) {
+	seen := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2023, 9, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name    string
+		p       Policy
+		now     time.Time
+		ver     string
+		wantErr string
+	}{
+		{name: "zero", now: at(1, 12, 0), ver: "1.50.0"},
+		{name: "zero-unknown-version", now: at(1, 12, 0)},
+		{name: "in-window", p: Policy{Window: "02:00-04:30"}, now: at(2, 3, 0), ver: "1.50.0"},
+		{name: "window-start", p: Policy{Window: "02:00-04:30"}, now: at(2, 2, 0), ver: "1.50.0"},
+		{name: "window-end", p: Policy{Window: "02:00-04:30"}, now: at(2, 4, 30), ver: "1.50.0", wantErr: "outside update window 02:00-04:30"},
+		{name: "before-window", p: Policy{Window: "02:00-04:30"}, now: at(2, 1, 59), ver: "1.50.0", wantErr: "outside update window 02:00-04:30"},
+		{name: "wrapped-window-late", p: Policy{Window: "22:00-04:00"}, now: at(2, 23, 0), ver: "1.50.0"},
+		{name: "wrapped-window-early", p: Policy{Window: "22:00-04:00"}, now: at(2, 1, 0), ver: "1.50.0"},
+		{name: "wrapped-window-outside", p: Policy{Window: "22:00-04:00"}, now: at(2, 12, 0), ver: "1.50.0", wantErr: "outside update window 22:00-04:00"},
+		{name: "train", p: Policy{Train: "1.50"}, now: at(2, 12, 0), ver: "1.50.2"},
+		{name: "other-train", p: Policy{Train: "1.50"}, now: at(2, 12, 0), ver: "1.52.0", wantErr: "version 1.52.0 is not on release train 1.50"},
+		{name: "train-unknown-version", p: Policy{Train: "1.50"}, now: at(2, 12, 0), wantErr: "latest version unknown"},
+		{name: "delay-elapsed", p: Policy{Delay: 48 * time.Hour}, now: at(3, 12, 0), ver: "1.50.0"},
+		{name: "delay-pending", p: Policy{Delay: 48 * time.Hour}, now: at(3, 11, 59), ver: "1.50.0", wantErr: "version 1.50.0 is held until 2023-09-03T12:00:00Z"},
+		{name: "bad-window", p: Policy{Window: "2:00-4:00"}, now: at(2, 3, 0), ver: "1.50.0", wantErr: `invalid update window "2:00-4:00"; want HH:MM-HH:MM`},
+		{name: "empty-window", p: Policy{Window: "02:00-02:00"}, now: at(2, 3, 0), ver: "1.50.0", wantErr: `update window "02:00-02:00" is empty`},
+		{name: "bad-train", p: Policy{Train: "1.50.1"}, now: at(2, 3, 0), ver: "1.50.1", wantErr: `invalid release train "1.50.1"; want major.minor, like 1.50`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.p.Allow(tt.now, tt.ver, seen)
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.wantErr {
+				t.Errorf("Allow = %q, want %q", got, tt.wantErr)
+			}
+		})
+	}
+}
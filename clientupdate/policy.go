@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package clientupdate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy restricts which available updates are applied automatically, and
+// when. The zero value allows any update at any time.
+type Policy struct {
+	// Window, if non-empty, is the daily window of local time during which
+	// updates may be applied, as "HH:MM-HH:MM". The window may wrap past
+	// midnight, as in "22:00-04:00".
+	Window string
+
+	// Train, if non-empty, is the release train, such as "1.50", that
+	// updates are limited to. Versions of other trains aren't applied.
+	Train string
+
+	// Delay is how long a version must have been available before it's
+	// applied.
+	Delay time.Duration
+}
+
+// Check reports whether p is valid.
+func (p Policy) Check() error {
+	if p.Window != "" {
+		if _, _, err := parseWindow(p.Window); err != nil {
+			return err
+		}
+	}
+	if p.Train != "" {
+		if t, ok := versionTrain(p.Train + ".0"); !ok || t != p.Train {
+			return fmt.Errorf("invalid release train %q; want major.minor, like 1.50", p.Train)
+		}
+	}
+	if p.Delay < 0 {
+		return errors.New("update delay must not be negative")
+	}
+	return nil
+}
+
+// Allow reports whether an update to version ver, first seen available at
+// seen, may be applied at now. It returns nil if so, or else an error saying
+// why the update is deferred. The version is only needed when p has a Train
+// or Delay.
+func (p Policy) Allow(now time.Time, ver string, seen time.Time) error {
+	if err := p.Check(); err != nil {
+		return err
+	}
+	if p.Train != "" || p.Delay > 0 {
+		if ver == "" {
+			return errors.New("latest version unknown")
+		}
+	}
+	if p.Train != "" {
+		if t, _ := versionTrain(ver); t != p.Train {
+			return fmt.Errorf("version %v is not on release train %v", ver, p.Train)
+		}
+	}
+	if p.Delay > 0 {
+		if seen.IsZero() {
+			return errors.New("release time of latest version unknown")
+		}
+		if at := seen.Add(p.Delay); now.Before(at) {
+			return fmt.Errorf("version %v is held until %v", ver, at.Format(time.RFC3339))
+		}
+	}
+	if p.Window != "" {
+		start, end, _ := parseWindow(p.Window)
+		m := now.Hour()*60 + now.Minute()
+		in := start <= m && m < end
+		if start > end {
+			in = m >= start || m < end
+		}
+		if !in {
+			return fmt.Errorf("outside update window %v", p.Window)
+		}
+	}
+	return nil
+}
+
+// parseWindow parses a daily "HH:MM-HH:MM" window, returning its start and
+// end as minutes after midnight.
+func parseWindow(w string) (start, end int, err error) {
+	s, e, ok := strings.Cut(w, "-")
+	if ok {
+		start, ok = parseClock(s)
+	}
+	if ok {
+		end, ok = parseClock(e)
+	}
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid update window %q; want HH:MM-HH:MM", w)
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("update window %q is empty", w)
+	}
+	return start, end, nil
+}
+
+// parseClock parses a "HH:MM" time of day into minutes after midnight.
+func parseClock(s string) (int, bool) {
+	hs, ms, ok := strings.Cut(s, ":")
+	if !ok || len(hs) != 2 || len(ms) != 2 {
+		return 0, false
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil || h < 0 || h > 23 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(ms)
+	if err != nil || m < 0 || m > 59 {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// versionTrain returns the "major.minor" release train of version v, such
+// as "1.50" for "1.50.1".
+func versionTrain(v string) (string, bool) {
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) != 3 {
+		return "", false
+	}
+	for _, p := range parts[:2] {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || strconv.Itoa(n) != p {
+			return "", false
+		}
+	}
+	return parts[0] + "." + parts[1], true
+}
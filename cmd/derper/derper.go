@@ -21,6 +21,7 @@
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -30,6 +31,7 @@
 	"tailscale.com/derp"
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/metrics"
+	"tailscale.com/net/netaddr"
 	"tailscale.com/net/stun"
 	"tailscale.com/tsweb"
 	"tailscale.com/types/key"
@@ -48,16 +50,29 @@
 	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
 
+	stunAltPort = flag.Int("stun-alt-port", 0, "optional second UDP port on which to serve STUN, so clients can run the RFC 5780 NAT behavior discovery tests that need responses from another port")
+	stunAltIP   = flag.String("stun-alt-ip", "", "with --stun-alt-port, an optional second IP address of this server on which to also serve STUN, so clients can run the RFC 5780 tests that need responses from another IP address; -a must then specify the primary IP")
+
 	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith       = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
 	bootstrapDNS   = flag.String("bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns")
 	unpublishedDNS = flag.String("unpublished-bootstrap-dns-names", "", "optional comma-separated list of hostnames to make available at /bootstrap-dns and not publish in the list")
 	verifyClients  = flag.Bool("verify-clients", false, "verify clients to this DERP server through a local tailscaled instance.")
+	disabledFeats  = flag.String("disable-features", "", "optional comma-separated list of DERP protocol features not to negotiate with clients, for staged rollouts")
 
 	acceptConnLimit = flag.Float64("accept-connection-limit", math.Inf(+1), "rate limit for accepting new connection")
 	acceptConnBurst = flag.Int("accept-connection-burst", math.MaxInt, "burst limit for accepting new connection")
+
+	bandwidthCheckMax = flag.Int64("bandwidth-check-max", 0, "if non-zero, serve /derp/bandwidth-check for client bandwidth probes, capping each response at this many bytes (clients ask for 4 MiB); off by default, as anyone can fetch it")
+
+	geoIPDB     = flag.String("geoip-db", "", `optional path to a CSV file of "prefix,location" lines mapping client IPs to locations; enables geo steering hints and per-location metrics`)
+	geoHints    = flag.String("geo-hints", "", `with --geoip-db, comma-separated "location=regionID" DERP region suggestions for clients in each location`)
+	geoRegionID = flag.Int("geo-region-id", 0, "with --geoip-db, this server's DERP region ID, to count connecting clients whose location suggests other regions")
 )
 
+// geo is non-nil if geo steering is enabled with --geoip-db.
+var geo *geoSteering
+
 var (
 	stats             = new(metrics.Set)
 	stunDisposition   = &metrics.LabelMap{Label: "disposition"}
@@ -84,6 +99,10 @@ func init() {
 
 type config struct {
 	PrivateKey key.NodePrivate
+
+	// RateLimits optionally limits what each client may use the server
+	// for.
+	RateLimits derp.RateLimits `json:",omitempty"`
 }
 
 func loadConfig() config {
@@ -148,10 +167,26 @@ func main() {
 
 	cfg := loadConfig()
 
+	if *geoIPDB != "" {
+		db, err := loadGeoDB(*geoIPDB, *geoHints)
+		if err != nil {
+			log.Fatalf("geoip: %v", err)
+		}
+		geo = &geoSteering{db: db, selfRegion: *geoRegionID}
+	}
+
 	serveTLS := tsweb.IsProd443(*addr) || *certMode == "manual"
 
 	s := derp.NewServer(cfg.PrivateKey, log.Printf)
 	s.SetVerifyClient(*verifyClients)
+	s.SetRateLimits(cfg.RateLimits)
+	if *disabledFeats != "" {
+		var fs []derp.Feature
+		for _, f := range strings.Split(*disabledFeats, ",") {
+			fs = append(fs, derp.Feature(strings.TrimSpace(f)))
+		}
+		s.SetDisabledFeatures(fs)
+	}
 
 	if *meshPSKFile != "" {
 		b, err := os.ReadFile(*meshPSKFile)
@@ -174,6 +209,9 @@ func main() {
 	if *runDERP {
 		derpHandler := derphttp.Handler(s)
 		derpHandler = addWebSocketSupport(s, derpHandler)
+		if geo != nil {
+			derpHandler = geo.countDERP(derpHandler)
+		}
 		mux.Handle("/derp", derpHandler)
 	} else {
 		mux.Handle("/derp", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -181,6 +219,8 @@ func main() {
 		}))
 	}
 	mux.HandleFunc("/derp/probe", probeHandler)
+	mux.HandleFunc("/derp/latency-check", probeHandler)
+	mux.HandleFunc("/derp/bandwidth-check", bandwidthCheckHandler(*bandwidthCheckMax))
 	go refreshBootstrapDNSLoop()
 	mux.HandleFunc("/bootstrap-dns", tsweb.BrowserHeaderHandlerFunc(handleBootstrapDNS))
 	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -346,21 +386,109 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "HEAD", "GET":
 		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if geo != nil {
+			geo.addHeaders(w, r)
+		}
 	default:
 		http.Error(w, "bogus probe method", http.StatusMethodNotAllowed)
 	}
 }
 
+// bandwidthCheckHandler returns the endpoint that clients hit to estimate
+// their download bandwidth from this DERP server. It serves the number of
+// zero bytes given in the "size" query parameter, capped at max.
+func bandwidthCheckHandler(max int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if max <= 0 {
+			http.Error(w, "bandwidth check disabled", http.StatusNotFound)
+			return
+		}
+		if r.Method != "GET" {
+			http.Error(w, "bogus bandwidth check method", http.StatusMethodNotAllowed)
+			return
+		}
+		size, err := strconv.ParseInt(r.FormValue("size"), 10, 64)
+		if err != nil || size <= 0 {
+			http.Error(w, "bad size", http.StatusBadRequest)
+			return
+		}
+		size = min(size, max)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		var buf [32 << 10]byte
+		for size > 0 {
+			n := min(size, int64(len(buf)))
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			size -= n
+		}
+	}
+}
+
+// stunConns are the sockets the STUN server answers on, indexed by
+// whether they're bound to the --stun-alt-ip and to the --stun-alt-port.
+// Only [0][0] is required; with the others, the server also answers the
+// change requests of RFC 5780 NAT behavior discovery.
+type stunConns [2][2]*net.UDPConn
+
+// otherAddr returns the OTHER-ADDRESS to advertise in responses to
+// requests received on sc[ip][port]: the address differing from it in both
+// IP and port, or only in port if there's no alternate IP. It returns the
+// zero value if there's no alternate address.
+func (sc *stunConns) otherAddr(ip, port int) netip.AddrPort {
+	other := sc[ip^1][port^1]
+	if other == nil {
+		other = sc[ip][port^1]
+	}
+	if other == nil {
+		return netip.AddrPort{}
+	}
+	ap := netaddr.Unmap(other.LocalAddr().(*net.UDPAddr).AddrPort())
+	if ap.Addr().IsUnspecified() {
+		return netip.AddrPort{}
+	}
+	return ap
+}
+
 func serveSTUN(host string, port int) {
-	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, fmt.Sprint(port)))
-	if err != nil {
-		log.Fatalf("failed to open STUN listener: %v", err)
+	var conns stunConns
+	listen := func(host string, port int) *net.UDPConn {
+		pc, err := net.ListenPacket("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+		if err != nil {
+			log.Fatalf("failed to open STUN listener: %v", err)
+		}
+		log.Printf("running STUN server on %v", pc.LocalAddr())
+		return pc.(*net.UDPConn)
 	}
-	log.Printf("running STUN server on %v", pc.LocalAddr())
-	serverSTUNListener(context.Background(), pc.(*net.UDPConn))
+	conns[0][0] = listen(host, port)
+	if *stunAltPort != 0 {
+		conns[0][1] = listen(host, *stunAltPort)
+		if *stunAltIP != "" {
+			if host == "" {
+				log.Fatalf("--stun-alt-ip requires -a to specify the primary IP address")
+			}
+			conns[1][0] = listen(*stunAltIP, port)
+			conns[1][1] = listen(*stunAltIP, *stunAltPort)
+		}
+	}
+	ctx := context.Background()
+	for ip := range conns {
+		for port, pc := range conns[ip] {
+			if pc != nil && (ip != 0 || port != 0) {
+				go serverSTUNListener(ctx, &conns, ip, port)
+			}
+		}
+	}
+	serverSTUNListener(ctx, &conns, 0, 0)
 }
 
-func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
+// serverSTUNListener serves STUN requests received on conns[ip][port].
+func serverSTUNListener(ctx context.Context, conns *stunConns, ip, port int) {
+	pc := conns[ip][port]
+	other := conns.otherAddr(ip, port)
 	var buf [64 << 10]byte
 	var (
 		n   int
@@ -395,7 +523,21 @@ func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
 		}
 		addr, _ := netip.AddrFromSlice(ua.IP)
 		res := stun.Response(txid, netip.AddrPortFrom(addr, uint16(ua.Port)))
-		_, err = pc.WriteTo(res, ua)
+		if geo != nil {
+			res = stun.AppendRegionHint(res, geo.noteSTUN(addr))
+		}
+		out := pc
+		if other.IsValid() {
+			res = stun.AppendOtherAddress(res, other)
+			changeIP, changePort := stun.ParseChangeRequest(pkt)
+			if changeIP && conns[1][0] == nil {
+				// Without an alternate IP, the client shouldn't
+				// have asked; don't pretend to have changed it.
+				continue
+			}
+			out = conns[ip^b2i(changeIP)][port^b2i(changePort)]
+		}
+		_, err = out.WriteTo(res, ua)
 		if err != nil {
 			stunWriteError.Add(1)
 		} else {
@@ -404,6 +546,13 @@ func serverSTUNListener(ctx context.Context, pc *net.UDPConn) {
 	}
 }
 
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 var validProdHostname = regexp.MustCompile(`^derp([^.]*)\.tailscale\.com\.?$`)
 
 func prodAutocertHostPolicy(_ context.Context, host string) error {
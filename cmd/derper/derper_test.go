@@ -8,6 +8,8 @@
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -47,7 +49,7 @@ func BenchmarkServerSTUN(b *testing.B) {
 	defer pc.Close()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	go serverSTUNListener(ctx, pc.(*net.UDPConn))
+	go serverSTUNListener(ctx, &stunConns{{pc.(*net.UDPConn)}}, 0, 0)
 	addr := pc.LocalAddr().(*net.UDPAddr)
 
 	var resBuf [1500]byte
@@ -128,3 +130,84 @@ func TestNoContent(t *testing.T) {
 		})
 	}
 }
+
+func TestBandwidthCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int64
+		query    string
+		wantCode int
+		wantLen  int
+	}{
+		{name: "ok", max: 1 << 20, query: "size=100000", wantCode: 200, wantLen: 100000},
+		{name: "capped", max: 1000, query: "size=100000", wantCode: 200, wantLen: 1000},
+		{name: "disabled", max: 0, query: "size=100", wantCode: 404},
+		{name: "missing size", max: 1000, query: "", wantCode: 400},
+		{name: "bad size", max: 1000, query: "size=-1", wantCode: 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/derp/bandwidth-check?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			bandwidthCheckHandler(tt.max)(w, req)
+			if w.Code != tt.wantCode {
+				t.Fatalf("code = %d; want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == 200 && w.Body.Len() != tt.wantLen {
+				t.Errorf("body len = %d; want %d", w.Body.Len(), tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestGeoSteering(t *testing.T) {
+	db, err := parseGeoDB(strings.NewReader(`
+# prefix,location
+192.0.2.0/24,DE
+192.0.2.128/25,FR
+2001:db8::/32,US
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.hints, err = parseGeoHints("DE=4,US=1,US=2"); err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"192.0.2.1":           "DE",
+		"192.0.2.200":         "FR",
+		"::ffff:192.0.2.1":    "DE",
+		"2001:db8::1":         "US",
+		"198.51.100.1":        "",
+		"2001:db9::1":         "",
+		"::ffff:198.51.100.1": "",
+	} {
+		if got := db.location(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("location(%v) = %q; want %q", ip, got, want)
+		}
+	}
+
+	g := &geoSteering{db: db}
+	req := httptest.NewRequest("GET", "/derp/latency-check", nil)
+	req.RemoteAddr = "[2001:db8::1]:1234"
+	rec := httptest.NewRecorder()
+	g.addHeaders(rec, req)
+	if got := rec.Header().Get("Derp-Geo-Location"); got != "US" {
+		t.Errorf("Derp-Geo-Location = %q; want US", got)
+	}
+	if got := rec.Header().Get("Derp-Region-Hints"); got != "1,2" {
+		t.Errorf("Derp-Region-Hints = %q; want 1,2", got)
+	}
+	if got := g.noteSTUN(netip.MustParseAddr("192.0.2.1")); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("noteSTUN = %v; want [4]", got)
+	}
+
+	for _, bad := range []string{"DE", "DE=x", "=4", "DE=0"} {
+		if _, err := parseGeoHints(bad); err == nil {
+			t.Errorf("parseGeoHints(%q) succeeded", bad)
+		}
+	}
+	if _, err := parseGeoDB(strings.NewReader("192.0.2.0/24\n")); err == nil {
+		t.Error("parseGeoDB of line without location succeeded")
+	}
+}
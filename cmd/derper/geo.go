@@ -0,0 +1,201 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"tailscale.com/metrics"
+)
+
+// geoDB maps client IP addresses to locations and locations to the DERP
+// regions suggested to clients there.
+//
+// Locations are arbitrary labels chosen by the operator, such as country
+// codes ("DE") or cloud regions ("us-west").
+type geoDB struct {
+	// byBits maps a prefix length to the prefixes of that length in the
+	// database and their locations. Lookups check the longest lengths
+	// first.
+	byBits map[int]map[netip.Prefix]string
+	bits   []int // keys of byBits, longest first
+
+	hints map[string][]int // location => suggested DERP region IDs
+}
+
+// loadGeoDB reads a geoDB from the GeoIP CSV file at path and the hints
+// flag value.
+//
+// Each non-empty, non-comment line of the file has the form
+// "prefix,location", e.g. "192.0.2.0/24,DE". The hints are a
+// comma-separated list of "location=regionID" pairs; a location may appear
+// more than once to suggest several regions.
+func loadGeoDB(path, hints string) (*geoDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	db, err := parseGeoDB(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if db.hints, err = parseGeoHints(hints); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func parseGeoDB(r io.Reader) (*geoDB, error) {
+	db := &geoDB{byBits: map[int]map[netip.Prefix]string{}}
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		s := strings.TrimSpace(sc.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		ps, loc, ok := strings.Cut(s, ",")
+		loc = strings.TrimSpace(loc)
+		if !ok || loc == "" {
+			return nil, fmt.Errorf("line %d: want \"prefix,location\"", line)
+		}
+		p, err := netip.ParsePrefix(strings.TrimSpace(ps))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		p = p.Masked()
+		m := db.byBits[p.Bits()]
+		if m == nil {
+			m = map[netip.Prefix]string{}
+			db.byBits[p.Bits()] = m
+			db.bits = append(db.bits, p.Bits())
+		}
+		m[p] = loc
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	// Longest prefixes first, so the most specific match wins.
+	slices.Sort(db.bits)
+	slices.Reverse(db.bits)
+	return db, nil
+}
+
+func parseGeoHints(s string) (map[string][]int, error) {
+	hints := map[string][]int{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		loc, v, ok := strings.Cut(kv, "=")
+		id, err := strconv.Atoi(v)
+		if !ok || loc == "" || err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid geo hint %q; want location=regionID", kv)
+		}
+		hints[loc] = append(hints[loc], id)
+	}
+	return hints, nil
+}
+
+// location returns the location of ip, or "" if it's not in the database.
+func (db *geoDB) location(ip netip.Addr) string {
+	ip = ip.Unmap()
+	for _, bits := range db.bits {
+		if bits > ip.BitLen() {
+			continue
+		}
+		p, err := ip.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if loc, ok := db.byBits[bits][p]; ok {
+			return loc
+		}
+	}
+	return ""
+}
+
+var (
+	geoStats           = new(metrics.Set)
+	geoSTUNRequests    = &metrics.LabelMap{Label: "location"}
+	geoDERPConnections = &metrics.LabelMap{Label: "location"}
+	geoDERPUnhinted    = &metrics.LabelMap{Label: "location"}
+)
+
+func init() {
+	geoStats.Set("counter_stun_requests", geoSTUNRequests)
+	geoStats.Set("counter_derp_connections", geoDERPConnections)
+	geoStats.Set("counter_derp_connections_unhinted", geoDERPUnhinted)
+	expvar.Publish("derper_geo", geoStats)
+}
+
+// geoSteering adds location-based DERP region hints to derper's responses
+// and counts clients by location.
+type geoSteering struct {
+	db         *geoDB
+	selfRegion int // this server's DERP region ID, or 0 if unknown
+}
+
+// hintsFor returns the location of ip (or "unknown") and the DERP regions
+// suggested for it.
+func (g *geoSteering) hintsFor(ip netip.Addr) (loc string, regionIDs []int) {
+	loc = g.db.location(ip)
+	if loc == "" {
+		return "unknown", nil
+	}
+	return loc, g.db.hints[loc]
+}
+
+// noteSTUN counts a STUN request from ip and returns the DERP regions to
+// suggest in the response.
+func (g *geoSteering) noteSTUN(ip netip.Addr) []int {
+	loc, ids := g.hintsFor(ip)
+	geoSTUNRequests.Add(loc, 1)
+	return ids
+}
+
+// addHeaders sets headers on a latency probe response telling the client
+// its location and the DERP regions suggested for it.
+func (g *geoSteering) addHeaders(w http.ResponseWriter, r *http.Request) {
+	ap, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+	loc, ids := g.hintsFor(ap.Addr())
+	h := w.Header()
+	h.Set("Derp-Geo-Location", loc)
+	if len(ids) > 0 {
+		strs := make([]string, len(ids))
+		for i, id := range ids {
+			strs[i] = strconv.Itoa(id)
+		}
+		h.Set("Derp-Region-Hints", strings.Join(strs, ","))
+	}
+	h.Set("Access-Control-Expose-Headers", "Derp-Geo-Location, Derp-Region-Hints")
+}
+
+// countDERP wraps a DERP connection handler to count connecting clients
+// by location, and those whose location isn't hinted to this region.
+func (g *geoSteering) countDERP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			loc, ids := g.hintsFor(ap.Addr())
+			geoDERPConnections.Add(loc, 1)
+			if g.selfRegion != 0 && len(ids) > 0 && !slices.Contains(ids, g.selfRegion) {
+				geoDERPUnhinted.Add(loc, 1)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -185,8 +185,17 @@ func initTSNet(zlog *zap.SugaredLogger) (*tsnet.Server, *tailscale.Client) {
 func startReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace string, restConfig *rest.Config, tsClient *tailscale.Client, image, priorityClassName, tags string) {
 	var (
 		isDefaultLoadBalancer = defaultBool("OPERATOR_DEFAULT_LOAD_BALANCER", false)
+		proxyClassPath        = defaultEnv("PROXY_CLASS_FILE", "")
 	)
 	startlog := zlog.Named("startReconcilers")
+	var pc *proxyClass
+	if proxyClassPath != "" {
+		var err error
+		pc, err = readProxyClass(proxyClassPath)
+		if err != nil {
+			startlog.Fatalf("reading proxy class: %v", err)
+		}
+	}
 	// For secrets and statefulsets, we only get permission to touch the objects
 	// in the controller's own namespace. This cannot be expressed by
 	// .Watches(...) below, instead you have to add a per-type field selector to
@@ -231,6 +240,7 @@ func startReconcilers(zlog *zap.SugaredLogger, s *tsnet.Server, tsNamespace stri
 		operatorNamespace:      tsNamespace,
 		proxyImage:             image,
 		proxyPriorityClassName: priorityClassName,
+		proxyClass:             pc,
 	}
 	err = builder.
 		ControllerManagedBy(mgr).
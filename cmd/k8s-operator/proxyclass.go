@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+	"tailscale.com/util/mak"
+)
+
+// proxyClass customizes all the proxy StatefulSets that the operator
+// creates, for clusters with hardened-image or scheduling requirements. It's
+// read at startup from the YAML file named by the PROXY_CLASS_FILE
+// environment variable, typically mounted from a ConfigMap.
+type proxyClass struct {
+	// Image, if set, is the image of the proxy container, overriding
+	// PROXY_IMAGE.
+	Image string `json:"image,omitempty"`
+
+	// InitImage, if set, is the image of the privileged init container
+	// that sets sysctls, busybox by default. It must provide sh and sysctl.
+	InitImage string `json:"initImage,omitempty"`
+
+	// Sysctls are kernel parameters for the init container to set, in
+	// addition to enabling IP forwarding. Proxies for Ingresses, which run
+	// in userspace mode without an init container, don't set them.
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+
+	// NodeSelector and Tolerations constrain the nodes the proxy pods are
+	// scheduled on.
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// Sidecars are extra containers to run in each proxy pod, after the
+	// proxy container.
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+}
+
+// sysctlNameRx matches the sysctl names that proxyClass.Sysctls accepts.
+var sysctlNameRx = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_./-]*$`)
+
+// readProxyClass reads and validates the proxyClass in the YAML file path.
+func readProxyClass(path string) (*proxyClass, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pc := new(proxyClass)
+	if err := yaml.UnmarshalStrict(b, pc); err != nil {
+		return nil, fmt.Errorf("parsing proxy class %q: %w", path, err)
+	}
+	if err := pc.check(); err != nil {
+		return nil, fmt.Errorf("proxy class %q: %w", path, err)
+	}
+	return pc, nil
+}
+
+// check reports whether pc is valid.
+func (pc *proxyClass) check() error {
+	for name, val := range pc.Sysctls {
+		if !sysctlNameRx.MatchString(name) {
+			return fmt.Errorf("invalid sysctl name %q", name)
+		}
+		if val == "" || strings.ContainsAny(val, "\n\r") {
+			return fmt.Errorf("invalid value %q for sysctl %q", val, name)
+		}
+	}
+	for _, c := range pc.Sidecars {
+		if c.Name == "" || c.Image == "" {
+			return errors.New("sidecars must have a name and an image")
+		}
+		if c.Name == "tailscale" {
+			return errors.New(`sidecar name "tailscale" is reserved for the proxy container`)
+		}
+	}
+	return nil
+}
+
+// apply customizes the proxy StatefulSet ss by pc. It's a no-op if pc is nil.
+func (pc *proxyClass) apply(ss *appsv1.StatefulSet) {
+	if pc == nil {
+		return
+	}
+	spec := &ss.Spec.Template.Spec
+	if pc.Image != "" {
+		spec.Containers[0].Image = pc.Image
+	}
+	for i := range spec.InitContainers {
+		c := &spec.InitContainers[i]
+		if c.Name != "sysctler" {
+			continue
+		}
+		if pc.InitImage != "" {
+			c.Image = pc.InitImage
+		}
+		if len(pc.Sysctls) > 0 && len(c.Args) > 0 {
+			names := make([]string, 0, len(pc.Sysctls))
+			for name := range pc.Sysctls {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			script := &c.Args[len(c.Args)-1]
+			for _, name := range names {
+				*script += " " + shellQuote(name+"="+pc.Sysctls[name])
+			}
+		}
+	}
+	for k, v := range pc.NodeSelector {
+		mak.Set(&spec.NodeSelector, k, v)
+	}
+	spec.Tolerations = append(spec.Tolerations, pc.Tolerations...)
+	spec.Containers = append(spec.Containers, pc.Sidecars...)
+}
+
+// shellQuote returns s quoted as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
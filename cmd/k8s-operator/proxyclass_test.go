@@ -0,0 +1,106 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !plan9
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+func TestProxyClass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxyclass.yaml")
+	if err := os.WriteFile(path, []byte(`
+image: registry.example.com/tailscale:hardened
+initImage: registry.example.com/busybox:hardened
+sysctls:
+  net.core.rmem_max: "7500000"
+  net.ipv4.tcp_rmem: "4096 87380 6291456"
+nodeSelector:
+  pool: edge
+tolerations:
+  - key: dedicated
+    operator: Equal
+    value: edge
+    effect: NoSchedule
+sidecars:
+  - name: audit
+    image: registry.example.com/audit:1
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := readProxyClass(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ss appsv1.StatefulSet
+	if err := yaml.Unmarshal(proxyYaml, &ss); err != nil {
+		t.Fatal(err)
+	}
+	pc.apply(&ss)
+	spec := ss.Spec.Template.Spec
+	if got, want := spec.Containers[0].Image, "registry.example.com/tailscale:hardened"; got != want {
+		t.Errorf("proxy image = %q; want %q", got, want)
+	}
+	if got, want := spec.InitContainers[0].Image, "registry.example.com/busybox:hardened"; got != want {
+		t.Errorf("init image = %q; want %q", got, want)
+	}
+	wantScript := "sysctl -w net.ipv4.ip_forward=1 net.ipv6.conf.all.forwarding=1 'net.core.rmem_max=7500000' 'net.ipv4.tcp_rmem=4096 87380 6291456'"
+	if got := spec.InitContainers[0].Args[1]; got != wantScript {
+		t.Errorf("init script = %q; want %q", got, wantScript)
+	}
+	if got := spec.NodeSelector["pool"]; got != "edge" {
+		t.Errorf("node selector pool = %q; want edge", got)
+	}
+	if len(spec.Tolerations) != 1 || spec.Tolerations[0].Effect != corev1.TaintEffectNoSchedule {
+		t.Errorf("tolerations = %+v", spec.Tolerations)
+	}
+	if len(spec.Containers) != 2 || spec.Containers[1].Name != "audit" {
+		t.Errorf("containers = %+v; want proxy then audit sidecar", spec.Containers)
+	}
+
+	// A nil proxyClass changes nothing.
+	var ss2 appsv1.StatefulSet
+	if err := yaml.Unmarshal(proxyYaml, &ss2); err != nil {
+		t.Fatal(err)
+	}
+	(*proxyClass)(nil).apply(&ss2)
+	if len(ss2.Spec.Template.Spec.Containers) != 1 {
+		t.Errorf("nil proxyClass changed the StatefulSet")
+	}
+}
+
+func TestProxyClassErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"unknown_field", "images: foo", "unknown field"},
+		{"bad_sysctl_name", `sysctls: {"net.core; reboot": "1"}`, "invalid sysctl name"},
+		{"empty_sysctl_value", `sysctls: {"net.core.rmem_max": ""}`, "invalid value"},
+		{"sidecar_no_image", "sidecars: [{name: audit}]", "must have a name and an image"},
+		{"sidecar_reserved_name", "sidecars: [{name: tailscale, image: foo}]", "reserved"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "proxyclass.yaml")
+			if err := os.WriteFile(path, []byte(tt.yaml), 0600); err != nil {
+				t.Fatal(err)
+			}
+			_, err := readProxyClass(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
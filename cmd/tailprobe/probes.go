@@ -0,0 +1,226 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/prober"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+)
+
+// serveBody is the body of the page the server node serves for the serve
+// probe.
+const serveBody = "tailprobe ok\n"
+
+// directPingAttempts is how many disco pings the direct probe sends
+// before concluding there's no direct path, as the first ones usually go
+// over DERP while the path is being discovered.
+const directPingAttempts = 5
+
+// directPingTimeout is how long the direct probe waits for the reply to
+// each of its disco pings. A ping sent before the peers have exchanged
+// disco keys is never answered, so it's retried rather than allowed to
+// use up the whole probe's time.
+const directPingTimeout = 3 * time.Second
+
+// tailnetProber probes a tailnet end to end, with a client node probing a
+// server node.
+type tailnetProber struct {
+	client, server *tsnet.Server
+	servePort      uint16
+}
+
+// start brings up both nodes and starts serving the page of the serve
+// probe on the server node.
+func (tp *tailnetProber) start(ctx context.Context) error {
+	for _, s := range []*tsnet.Server{tp.client, tp.server} {
+		if _, err := s.Up(ctx); err != nil {
+			return fmt.Errorf("%s: %w", s.Hostname, err)
+		}
+	}
+	ln, err := tp.server.Listen("tcp", ":"+strconv.Itoa(int(tp.servePort)))
+	if err != nil {
+		return err
+	}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, serveBody)
+	}))
+	return nil
+}
+
+// probes returns the probes, keyed by name.
+func (tp *tailnetProber) probes() map[string]prober.ProbeFunc {
+	return map[string]prober.ProbeFunc{
+		"login":  tp.probeLogin,
+		"direct": tp.probeDirect,
+		"derp":   tp.probeDERP,
+		"dns":    tp.probeDNS,
+		"serve":  tp.probeServe,
+	}
+}
+
+// status returns the status of node s, failing if it isn't running.
+func status(ctx context.Context, s *tsnet.Server) (*ipnstate.Status, error) {
+	lc, err := s.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	st, err := lc.StatusWithoutPeers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st.BackendState != "Running" {
+		return nil, fmt.Errorf("%s is in state %s", s.Hostname, st.BackendState)
+	}
+	if st.Self == nil || len(st.TailscaleIPs) == 0 {
+		return nil, fmt.Errorf("%s has no Tailscale IPs", s.Hostname)
+	}
+	return st, nil
+}
+
+// serverIP returns the server node's Tailscale IPv4 address, or its IPv6
+// one if it has none.
+func serverIP(st *ipnstate.Status) netip.Addr {
+	if i := slices.IndexFunc(st.TailscaleIPs, netip.Addr.Is4); i >= 0 {
+		return st.TailscaleIPs[i]
+	}
+	return st.TailscaleIPs[0]
+}
+
+// probeLogin checks that both nodes are logged in and running.
+func (tp *tailnetProber) probeLogin(ctx context.Context) error {
+	for _, s := range []*tsnet.Server{tp.client, tp.server} {
+		if _, err := status(ctx, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probeDirect checks that the client reaches the server over a direct
+// UDP path.
+func (tp *tailnetProber) probeDirect(ctx context.Context) error {
+	st, err := status(ctx, tp.server)
+	if err != nil {
+		return err
+	}
+	lc, err := tp.client.LocalClient()
+	if err != nil {
+		return err
+	}
+	var lastDERP string
+	var timeouts int
+	for i := 0; i < directPingAttempts; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, directPingTimeout)
+		pr, err := lc.Ping(pingCtx, serverIP(st), tailcfg.PingDisco)
+		cancel()
+		if err != nil {
+			if pingCtx.Err() != nil && ctx.Err() == nil {
+				timeouts++
+				continue
+			}
+			return err
+		}
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		if pr.Endpoint != "" {
+			return nil
+		}
+		lastDERP = pr.DERPRegionCode
+	}
+	if lastDERP == "" {
+		return fmt.Errorf("no reply to %d pings", timeouts)
+	}
+	return fmt.Errorf("no direct path after %d pings (%d unanswered); still going through DERP region %q", directPingAttempts, timeouts, lastDERP)
+}
+
+// probeDERP checks that the client can use the server's home DERP region.
+func (tp *tailnetProber) probeDERP(ctx context.Context) error {
+	st, err := status(ctx, tp.server)
+	if err != nil {
+		return err
+	}
+	if st.Self.Relay == "" {
+		return fmt.Errorf("%s has no home DERP region", tp.server.Hostname)
+	}
+	lc, err := tp.client.LocalClient()
+	if err != nil {
+		return err
+	}
+	rep, err := lc.DebugDERPRegion(ctx, st.Self.Relay)
+	if err != nil {
+		return err
+	}
+	if len(rep.Errors) > 0 {
+		return fmt.Errorf("DERP region %s: %s", st.Self.Relay, strings.Join(rep.Errors, "; "))
+	}
+	return nil
+}
+
+// probeDNS checks that the client resolves the server's MagicDNS name to
+// the server's Tailscale IP.
+func (tp *tailnetProber) probeDNS(ctx context.Context) error {
+	st, err := status(ctx, tp.server)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(st.Self.DNSName, ".")
+	if name == "" {
+		return fmt.Errorf("%s has no MagicDNS name", tp.server.Hostname)
+	}
+	c, err := tp.client.Dial(ctx, "tcp", net.JoinHostPort(name, strconv.Itoa(int(tp.servePort))))
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	ap, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return err
+	}
+	if got, want := ap.Addr().Unmap(), serverIP(st); got != want {
+		return fmt.Errorf("%s resolved to %v; want %v", name, got, want)
+	}
+	return nil
+}
+
+// probeServe checks that the client can fetch the page the server node
+// serves.
+func (tp *tailnetProber) probeServe(ctx context.Context) error {
+	st, err := status(ctx, tp.server)
+	if err != nil {
+		return err
+	}
+	u := "http://" + net.JoinHostPort(serverIP(st).String(), strconv.Itoa(int(tp.servePort))) + "/"
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := tp.client.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK || string(body) != serveBody {
+		return fmt.Errorf("GET %s: %s %q", u, res.Status, body)
+	}
+	return nil
+}
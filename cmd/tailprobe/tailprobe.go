@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tailprobe binary runs synthetic end-to-end probes of a tailnet, so
+// that its operators can monitor it as their users experience it.
+//
+// It joins the tailnet as two ephemeral tsnet nodes, a client and a
+// server, and periodically checks that they're logged in, that the client
+// reaches the server over a direct path, that it can use the server's home
+// DERP region, that MagicDNS resolves the server's name, and that it can
+// fetch a page the server serves. The results are exported as Prometheus
+// metrics and summarized on its HTTP status page.
+//
+// The auth key must be reusable, and the tailnet's ACLs must let the
+// client reach the server's --serve-port.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/prober"
+	"tailscale.com/tsnet"
+	"tailscale.com/tsweb"
+	"tailscale.com/types/logger"
+)
+
+var (
+	listen     = flag.String("listen", ":8031", "HTTP listen address")
+	probeOnce  = flag.Bool("once", false, "probe once and print results, then exit; ignores the listen flag")
+	interval   = flag.Duration("interval", time.Minute, "probe interval")
+	controlURL = flag.String("control-url", "", "URL of the tailnet's coordination server; empty means the Tailscale default")
+	authKey    = flag.String("authkey", "", "reusable auth key for the probe nodes; defaults to $TS_AUTHKEY")
+	hostname   = flag.String("hostname", "tailprobe", "hostname prefix of the probe nodes, which are named <hostname>-client and <hostname>-server")
+	servePort  = flag.Uint("serve-port", 80, "TCP port the server node serves the serve probe's page on")
+	verbose    = flag.Bool("verbose", false, "log the probe nodes' logs")
+)
+
+func main() {
+	flag.Parse()
+	if *servePort == 0 || *servePort > 65535 {
+		log.Fatalf("invalid --serve-port %d", *servePort)
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run() error {
+	dir, err := os.MkdirTemp("", "tailprobe")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	newNode := func(role string) *tsnet.Server {
+		s := &tsnet.Server{
+			Dir:        filepath.Join(dir, role),
+			Store:      new(mem.Store),
+			Hostname:   *hostname + "-" + role,
+			Ephemeral:  true,
+			AuthKey:    *authKey,
+			ControlURL: *controlURL,
+			Logf:       logger.Discard,
+		}
+		if *verbose {
+			s.Logf = logger.WithPrefix(log.Printf, role+": ")
+		}
+		return s
+	}
+	tp := &tailnetProber{
+		client:    newNode("client"),
+		server:    newNode("server"),
+		servePort: uint16(*servePort),
+	}
+	defer tp.client.Close()
+	defer tp.server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	err = tp.start(ctx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("starting probe nodes: %w", err)
+	}
+
+	p := prober.New().WithOnce(*probeOnce).WithMetricNamespace("tailprobe")
+	for name, fn := range tp.probes() {
+		p.Run(name, *interval, nil, fn)
+	}
+
+	if *probeOnce {
+		p.Wait()
+		st := getOverallStatus(p)
+		for _, s := range st.good {
+			log.Printf("good: %s", s)
+		}
+		for _, s := range st.bad {
+			log.Printf("bad: %s", s)
+		}
+		if len(st.bad) > 0 {
+			return fmt.Errorf("%d probes failed", len(st.bad))
+		}
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	tsweb.Debugger(mux)
+	mux.HandleFunc("/", serveFunc(p))
+	return http.ListenAndServe(*listen, mux)
+}
+
+type overallStatus struct {
+	good, bad []string
+}
+
+func getOverallStatus(p *prober.Prober) (o overallStatus) {
+	for name, i := range p.ProbeInfo() {
+		if i.End.IsZero() {
+			// Do not show probes that have not finished yet.
+			continue
+		}
+		if i.Result {
+			o.good = append(o.good, fmt.Sprintf("%s: %s", name, i.Latency))
+		} else {
+			o.bad = append(o.bad, fmt.Sprintf("%s: %s", name, i.Error))
+		}
+	}
+	sort.Strings(o.bad)
+	sort.Strings(o.good)
+	return
+}
+
+func serveFunc(p *prober.Prober) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := getOverallStatus(p)
+		summary := "All good"
+		if len(st.bad) > 0 {
+			// Returning a 500 allows monitoring this server externally and
+			// configuring an alert on HTTP response code.
+			w.WriteHeader(500)
+			summary = fmt.Sprintf("%d problems", len(st.bad))
+		}
+
+		io.WriteString(w, "<html><head><style>.bad { font-weight: bold; color: #700; }</style></head>\n")
+		fmt.Fprintf(w, "<body><h1>tailnet probe</h1>\n%s:<ul>", summary)
+		for _, s := range st.bad {
+			fmt.Fprintf(w, "<li class=bad>%s</li>\n", html.EscapeString(s))
+		}
+		for _, s := range st.good {
+			fmt.Fprintf(w, "<li>%s</li>\n", html.EscapeString(s))
+		}
+		io.WriteString(w, "</ul></body></html>\n")
+	}
+}
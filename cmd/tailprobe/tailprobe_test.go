@@ -0,0 +1,46 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest/natlab/natlabtest"
+)
+
+func TestProbes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	lab := natlabtest.New(t)
+	tp := &tailnetProber{
+		client:    lab.AddNode("client", natlabtest.NodeConfig{}).Server,
+		server:    lab.AddNode("server", natlabtest.NodeConfig{}).Server,
+		servePort: 8080,
+	}
+	if err := tp.start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, fn := range tp.probes() {
+		if name == "derp" {
+			// The lab's DERP server has a self-signed certificate, which
+			// the DERP region check doesn't accept.
+			continue
+		}
+		// As under the prober, each probe gets its own time.
+		probeCtx, cancel := context.WithTimeout(ctx, 20*time.Second)
+		err := fn(probeCtx)
+		cancel()
+		if err != nil {
+			t.Errorf("%s probe: %v", name, err)
+		}
+	}
+
+	tp.server.Close()
+	if err := tp.probeLogin(ctx); err == nil {
+		t.Error("login probe succeeded with the server node closed")
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+)
+
+var apiTokenCmd = &ffcli.Command{
+	Name:       "api-token",
+	ShortUsage: "api-token <subcommand> [flags]",
+	ShortHelp:  "Manage tokens for scoped access to the local Tailscale API",
+	LongHelp: strings.TrimSpace(`
+'tailscale api-token' manages tokens that let programs such as monitoring
+agents and sidecars use tailscaled's local API without running as root.
+Each token grants only the access of its scopes:
+
+  read-status   read status, WhoIs results and health
+  manage-serve  read and change the serve config, except to serve local
+                files or forward TCP to local ports; proxying to loopback
+                ports, as with 'tailscale serve 3000', is allowed
+  manage-prefs  read preferences, and change only --shields-up,
+                --accept-routes, --accept-dns, --exit-node,
+                --exit-node-allow-lan-access, --hostname and whether
+                Tailscale is running
+
+Programs present a token in the Tailscale-API-Token HTTP header. Managing
+tokens requires root (or local admin) access; the operator can't.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "create",
+			ShortUsage: "api-token create --scopes=<scope>[,<scope>...] [--name=<name>]",
+			ShortHelp:  "Create a token and print it",
+			Exec:       runAPITokenCreate,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("create")
+				fs.StringVar(&apiTokenArgs.name, "name", "", "description of what the token is for")
+				fs.StringVar(&apiTokenArgs.scopes, "scopes", "", "comma-separated scopes to grant: "+strings.Join(ipn.LocalAPIScopes, ", "))
+				return fs
+			})(),
+		},
+		{
+			Name:       "list",
+			ShortUsage: "api-token list [--json]",
+			ShortHelp:  "List tokens",
+			Exec:       runAPITokenList,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("list")
+				fs.BoolVar(&apiTokenArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "revoke",
+			ShortUsage: "api-token revoke <id>",
+			ShortHelp:  "Revoke a token",
+			Exec:       runAPITokenRevoke,
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("api-token subcommand required; run 'tailscale api-token -h' for details")
+	},
+}
+
+var apiTokenArgs struct {
+	name   string
+	scopes string
+	json   bool
+}
+
+func runAPITokenCreate(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale api-token create'")
+	}
+	var scopes []string
+	for _, s := range strings.Split(apiTokenArgs.scopes, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			scopes = append(scopes, s)
+		}
+	}
+	if err := ipn.CheckLocalAPIScopes(scopes); err != nil {
+		return fmt.Errorf("invalid --scopes: %w", err)
+	}
+	res, err := localClient.CreateLocalAPIToken(ctx, apiTokenArgs.name, scopes)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	printf("Created token %s with scopes %s. It won't be shown again:\n\n", res.Info.ID, strings.Join(res.Info.Scopes, ","))
+	outln(res.Token)
+	return nil
+}
+
+func runAPITokenList(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale api-token list'")
+	}
+	toks, err := localClient.LocalAPITokens(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if apiTokenArgs.json {
+		j, err := json.MarshalIndent(toks, "", "\t")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+	if len(toks) == 0 {
+		outln("No API tokens.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", "ID", "NAME", "SCOPES", "CREATED")
+	for _, t := range toks {
+		name := t.Name
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", t.ID, name, strings.Join(t.Scopes, ","), t.Created.Local().Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+func runAPITokenRevoke(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale api-token revoke <id>")
+	}
+	if err := localClient.RevokeLocalAPIToken(ctx, args[0]); err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	printf("Revoked token %s.\n", args[0])
+	return nil
+}
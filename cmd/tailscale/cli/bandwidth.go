@@ -0,0 +1,103 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/tailcfg"
+)
+
+var bandwidthCmd = &ffcli.Command{
+	Name:       "bandwidth",
+	ShortUsage: "bandwidth [--json]",
+	ShortHelp:  "Show this node's traffic in the current bandwidth usage period",
+	LongHelp: strings.TrimSpace(`
+'tailscale bandwidth' shows how many bytes this node has exchanged over
+Tailscale since the start of the current monthly usage period, in total,
+with each exit node used, and for each Funnel port.
+
+Use 'tailscale set --bandwidth-quota-mb' to set a monthly quota, which
+raises a health warning when reached, and --bandwidth-reset-day and
+--bandwidth-warn-percent to pick the day usage resets and an earlier
+warning threshold.
+`),
+	Exec: runBandwidth,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("bandwidth")
+		fs.BoolVar(&bandwidthArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+}
+
+var bandwidthArgs struct {
+	json bool
+}
+
+func runBandwidth(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale bandwidth'")
+	}
+	u, err := localClient.BandwidthUsage(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if bandwidthArgs.json {
+		j, err := json.MarshalIndent(u, "", "\t")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+
+	printf("Period:   %s to %s\n", u.PeriodStart.Format(time.DateOnly), u.PeriodEnd.Format(time.DateOnly))
+	printf("Received: %s\n", formatByteCount(u.Total.RxBytes))
+	printf("Sent:     %s\n", formatByteCount(u.Total.TxBytes))
+	if u.QuotaBytes != 0 {
+		printf("Quota:    %s of %s used (%d%%)\n", formatByteCount(u.Total.Total()), formatByteCount(u.QuotaBytes), u.Total.Total()*100/u.QuotaBytes)
+	}
+	if len(u.ExitNodes) == 0 && len(u.Funnel) == 0 {
+		return nil
+	}
+
+	names := map[tailcfg.StableNodeID]string{}
+	if st, err := localClient.Status(ctx); err == nil {
+		for _, ps := range st.Peer {
+			names[ps.ID] = dnsOrQuoteHostname(st, ps)
+		}
+	}
+	outln()
+	w := tabwriter.NewWriter(os.Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t\n", "VIA", "RECEIVED", "SENT")
+	ids := make([]tailcfg.StableNodeID, 0, len(u.ExitNodes))
+	for id := range u.ExitNodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		name := names[id]
+		if name == "" {
+			name = string(id)
+		}
+		c := u.ExitNodes[id]
+		fmt.Fprintf(w, "exit node %s\t%s\t%s\t\n", name, formatByteCount(c.RxBytes), formatByteCount(c.TxBytes))
+	}
+	for _, hp := range sortedHostPorts(u.Funnel) {
+		c := u.Funnel[hp]
+		fmt.Fprintf(w, "funnel %s\t%s\t%s\t\n", hp, formatByteCount(c.RxBytes), formatByteCount(c.TxBytes))
+	}
+	return nil
+}
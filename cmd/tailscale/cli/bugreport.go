@@ -8,6 +8,7 @@
 	"errors"
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -22,13 +23,19 @@
 		fs := newFlagSet("bugreport")
 		fs.BoolVar(&bugReportArgs.diagnose, "diagnose", false, "run additional in-depth checks")
 		fs.BoolVar(&bugReportArgs.record, "record", false, "if true, pause and then write another bugreport")
+		fs.BoolVar(&bugReportArgs.preview, "preview", false, "show the diagnostic information that will be shared and ask for confirmation before sending it")
+		fs.BoolVar(&bugReportArgs.redactHostnames, "redact-hostnames", false, "replace the names of this machine and its peers with placeholders")
+		fs.BoolVar(&bugReportArgs.redactIPs, "redact-ips", false, "replace IP addresses with placeholders")
 		return fs
 	})(),
 }
 
 var bugReportArgs struct {
-	diagnose bool
-	record   bool
+	diagnose        bool
+	record          bool
+	preview         bool
+	redactHostnames bool
+	redactIPs       bool
 }
 
 func runBugReport(ctx context.Context, args []string) error {
@@ -41,8 +48,27 @@ func runBugReport(ctx context.Context, args []string) error {
 		return errors.New("unknown arguments")
 	}
 	opts := tailscale.BugReportOpts{
-		Note:     note,
-		Diagnose: bugReportArgs.diagnose,
+		Note:            note,
+		Diagnose:        bugReportArgs.diagnose,
+		RedactHostnames: bugReportArgs.redactHostnames,
+		RedactIPs:       bugReportArgs.redactIPs,
+	}
+	if bugReportArgs.preview {
+		p, err := localClient.BugReportPreview(ctx, opts)
+		if err != nil {
+			return err
+		}
+		outln("The following diagnostic information will be logged for the support team:")
+		outln()
+		for _, line := range p.Lines {
+			outln("  " + line)
+		}
+		outln()
+		if !confirmBugReport() {
+			outln("Bug report not sent.")
+			return nil
+		}
+		opts.PreviewID = p.ID
 	}
 	if !bugReportArgs.record {
 		// Simple, non-record case
@@ -81,3 +107,14 @@ type bugReportResp struct {
 	outln("Please provide both bugreport markers above to the support team or GitHub issue.")
 	return nil
 }
+
+func confirmBugReport() bool {
+	fmt.Printf("Send this bug report? [y/n] ")
+	var resp string
+	fmt.Scanln(&resp)
+	switch strings.ToLower(resp) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
@@ -22,7 +22,9 @@
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/envknob"
+	"tailscale.com/ipn"
 	"tailscale.com/paths"
+	"tailscale.com/util/i18n"
 	"tailscale.com/version/distro"
 )
 
@@ -78,6 +80,10 @@ func CleanUpArgs(args []string) []string {
 
 var localClient tailscale.LocalClient
 
+// msgs formats the CLI's human-readable messages. Run sets it to the locale
+// of the environment.
+var msgs = i18n.NewPrinter(i18n.DefaultLocale)
+
 // Run runs the CLI. The args do not include the binary name.
 func Run(args []string) (err error) {
 	args = CleanUpArgs(args)
@@ -117,6 +123,8 @@ func Run(args []string) (err error) {
 			netcheckCmd,
 			ipCmd,
 			statusCmd,
+			healthCmd,
+			dnsCmd,
 			pingCmd,
 			ncCmd,
 			sshCmd,
@@ -130,7 +138,15 @@ func Run(args []string) (err error) {
 			netlockCmd,
 			licensesCmd,
 			exitNodeCmd,
+			peersCmd,
+			waitCmd,
+			relayCmd,
+			bandwidthCmd,
+			flowLogCmd,
 			updateCmd,
+			apiTokenCmd,
+			schemaCmd,
+			completionCmd,
 		},
 		FlagSet:   rootfs,
 		Exec:      func(context.Context, []string) error { return flag.ErrHelp },
@@ -157,6 +173,10 @@ func Run(args []string) (err error) {
 		}
 	}
 
+	if len(args) > 0 && args[0] == completeCmdName {
+		return runComplete(context.Background(), rootCmd, args[1:])
+	}
+
 	if err := rootCmd.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
@@ -165,6 +185,11 @@ func Run(args []string) (err error) {
 	}
 
 	localClient.Socket = rootArgs.socket
+	localClient.PrefSource = ipn.PrefSourceCLI
+	msgs = i18n.NewPrinter(i18n.FromEnv())
+	if msgs.Locale() != i18n.DefaultLocale {
+		localClient.Locale = msgs.Locale()
+	}
 	rootfs.Visit(func(f *flag.Flag) {
 		if f.Name == "socket" {
 			localClient.UseSocketOnly = true
@@ -173,7 +198,7 @@ func Run(args []string) (err error) {
 
 	err = rootCmd.Run(context.Background())
 	if tailscale.IsAccessDeniedError(err) && os.Getuid() != 0 && runtime.GOOS != "windows" {
-		return fmt.Errorf("%v\n\nUse 'sudo tailscale %s' or 'tailscale up --operator=$USER' to not require root.", err, strings.Join(args, " "))
+		return fmt.Errorf("%v\n\n%s", err, msgs.Text("cli.accessDenied", "args", strings.Join(args, " ")))
 	}
 	if errors.Is(err, flag.ErrHelp) {
 		return nil
@@ -12,9 +12,11 @@
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
+	"tailscale.com/health"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
@@ -24,6 +26,7 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
+	"tailscale.com/types/views"
 	"tailscale.com/util/cmpx"
 	"tailscale.com/version/distro"
 )
@@ -810,6 +813,12 @@ func TestPrefFlagMapping(t *testing.T) {
 		case "Egg":
 			// Not applicable.
 			continue
+		case "ExitNodePolicy":
+			// Managed by "tailscale exit-node policy".
+			continue
+		case "HideStalePeersDays":
+			// Managed by "tailscale peers prune".
+			continue
 		}
 		t.Errorf("unexpected new ipn.Pref field %q is not handled by up.go (see addPrefFlagMapping and checkForAccidentalSettingReverts)", prefName)
 	}
@@ -1290,3 +1299,109 @@ func TestParseNLArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestWantRunningScheduleFromFlags(t *testing.T) {
+	now := time.Date(2023, 9, 1, 17, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		want    bool
+		after   time.Duration
+		until   string
+		wantS   *ipn.WantRunningSchedule
+		wantErr bool
+	}{
+		{name: "none"},
+		{
+			name:  "until-duration",
+			until: "2h",
+			wantS: &ipn.WantRunningSchedule{Until: now.Add(2 * time.Hour)},
+		},
+		{
+			name:  "until-time-today",
+			want:  true,
+			until: "18:00",
+			wantS: &ipn.WantRunningSchedule{WantRunning: true, Until: time.Date(2023, 9, 1, 18, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:  "until-time-tomorrow",
+			until: "09:15",
+			wantS: &ipn.WantRunningSchedule{Until: time.Date(2023, 9, 2, 9, 15, 0, 0, time.UTC)},
+		},
+		{
+			name:  "until-rfc3339",
+			until: "2023-09-03T10:00:00Z",
+			wantS: &ipn.WantRunningSchedule{Until: time.Date(2023, 9, 3, 10, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:  "after",
+			after: 30 * time.Minute,
+			wantS: &ipn.WantRunningSchedule{After: now.Add(30 * time.Minute)},
+		},
+		{
+			name:  "after-and-until",
+			after: 10 * time.Minute,
+			until: "1h",
+			wantS: &ipn.WantRunningSchedule{After: now.Add(10 * time.Minute), Until: now.Add(time.Hour)},
+		},
+		{name: "until-before-after", after: 2 * time.Hour, until: "1h", wantErr: true},
+		{name: "until-past", until: "2023-09-01T00:00:00Z", wantErr: true},
+		{name: "negative-after", after: -time.Minute, wantErr: true},
+		{name: "bad-until", until: "teatime", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := wantRunningScheduleFromFlags(tt.want, tt.after, tt.until, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v; wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.wantS) {
+				t.Errorf("got %+v; want %+v", got, tt.wantS)
+			}
+		})
+	}
+}
+
+func TestViaRouteLines(t *testing.T) {
+	routes := views.SliceOf([]netip.Prefix{
+		netip.MustParsePrefix("192.168.0.0/16"),
+		netip.MustParsePrefix("fd7a:115c:a1e0:b1a:0:7:a00:0/120"),
+	})
+	st := &ipnstate.Status{MagicDNSSuffix: "tailnet.ts.net"}
+	nodes := []*ipnstate.PeerStatus{
+		{DNSName: "router.tailnet.ts.net.", PrimaryRoutes: &routes},
+		{DNSName: "laptop.tailnet.ts.net."},
+	}
+	got := viaRouteLines(st, nodes)
+	want := []string{"router: site 7 10.0.0.0/24 at fd7a:115c:a1e0:b1a:0:7:a00:0/120"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHealthWarnings(t *testing.T) {
+	now := time.Now()
+	st := &health.State{Warnings: []health.UnhealthyState{
+		{Code: health.CodeLoginError, Severity: health.SeverityHigh, Subsystem: health.SysControl, BrokenSince: now.Add(-90 * time.Second)},
+		{Code: "no-such-code", Severity: health.SeverityLow},
+	}}
+	got := healthWarnings(st, now)
+	if len(got) != 2 {
+		t.Fatalf("got %d warnings; want 2", len(got))
+	}
+	if got[0].Duration != "1m30s" || !reflect.DeepEqual(got[0].Remediation, []string{"tailscale login"}) {
+		t.Errorf("login error: duration %q, remediation %q", got[0].Duration, got[0].Remediation)
+	}
+	if got[1].Duration != "" || got[1].Remediation != nil {
+		t.Errorf("unknown code: duration %q, remediation %q", got[1].Duration, got[1].Remediation)
+	}
+
+	j, err := json.Marshal(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Subsystem":"control"`, `"Duration":"1m30s"`, `"Remediation":["tailscale login"]`} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("JSON %s lacks %s", j, want)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var completionCmd = &ffcli.Command{
+	Name:       "completion",
+	ShortUsage: "completion <bash|zsh|fish|powershell>",
+	ShortHelp:  "Print a shell completion script",
+	LongHelp: strings.TrimSpace(`
+"tailscale completion" prints a script that sets up tab completion of
+tailscale's subcommands and flags for the given shell. It also completes
+the names of peers (for ping, ssh, nc, ip and file cp), exit nodes and
+profiles, by asking tailscaled as you type.
+
+To try it in the current shell:
+
+  bash:        source <(tailscale completion bash)
+  zsh:         source <(tailscale completion zsh)
+  fish:        tailscale completion fish | source
+  PowerShell:  tailscale completion powershell | Out-String | Invoke-Expression
+
+To load it in every new shell, add the same line to your shell's startup
+file, such as ~/.bashrc, ~/.zshrc, ~/.config/fish/config.fish or $PROFILE.
+`),
+	Exec: runCompletion,
+}
+
+func runCompletion(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale completion <bash|zsh|fish|powershell>")
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q; want bash, zsh, fish or powershell", args[0])
+	}
+	outln(strings.TrimSpace(script))
+	return nil
+}
+
+// completeCmdName is the hidden subcommand that the completion scripts
+// run, as "tailscale __complete -- <words...>", to get the candidates for
+// the last word, one per line. If there are none, the scripts fall back to
+// completing file names.
+const completeCmdName = "__complete"
+
+// runComplete implements the completeCmdName command. It's run before
+// root's flags are parsed, as the words being completed are usually
+// incomplete.
+func runComplete(ctx context.Context, root *ffcli.Command, args []string) error {
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		// PowerShell drops empty arguments to native commands, so
+		// its script passes a space for an empty word instead.
+		args[len(args)-1] = strings.TrimSpace(args[len(args)-1])
+	}
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	for _, c := range complete(ctx, root, args) {
+		outln(c)
+	}
+	return nil
+}
+
+// A completer returns the candidates for word, the word being completed,
+// which are then filtered by prefix. args are the command's positional
+// arguments before word.
+type completer func(ctx context.Context, args []string, word string) []string
+
+// argCompleters complete positional arguments, keyed by the command's path
+// below the root, like "file cp".
+var argCompleters = map[string]completer{
+	"ping":                 firstArg(completePeers),
+	"ip":                   firstArg(completePeers),
+	"nc":                   firstArg(completePeers),
+	"ssh":                  firstArg(completeSSHHost),
+	"file cp":              completeFileTarget,
+	"switch":               firstArg(completeProfiles),
+	"exit-node policy add": firstArg(completeExitNodes),
+}
+
+// flagCompleters complete flag values, keyed by flag name.
+var flagCompleters = map[string]completer{
+	"exit-node": completeExitNodes,
+}
+
+// firstArg returns a completer that runs c only for a command's first
+// positional argument.
+func firstArg(c completer) completer {
+	return func(ctx context.Context, args []string, word string) []string {
+		if len(args) > 0 {
+			return nil
+		}
+		return c(ctx, args, word)
+	}
+}
+
+// complete returns the candidates for the last of words, the command line
+// after "tailscale" up to the cursor.
+func complete(ctx context.Context, root *ffcli.Command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	word := words[len(words)-1]
+	cmd, path := root, ""
+	var args []string
+	var valueFor *flag.Flag // flag whose value word is
+	for _, w := range words[:len(words)-1] {
+		if valueFor != nil {
+			valueFor = nil
+			continue
+		}
+		if w == "--" || len(args) > 0 {
+			args = append(args, w)
+			continue
+		}
+		if strings.HasPrefix(w, "-") {
+			if f := lookupFlag(cmd, w); f != nil && !isBoolFlag(f) && !strings.Contains(w, "=") {
+				valueFor = f
+			}
+			continue
+		}
+		if sub := findSubcommand(cmd, w); sub != nil {
+			cmd = sub
+			path = strings.TrimPrefix(path+" "+sub.Name, " ")
+			continue
+		}
+		args = append(args, w)
+	}
+
+	var cands []string
+	switch {
+	case valueFor != nil:
+		if c := flagCompleters[valueFor.Name]; c != nil {
+			cands = c(ctx, nil, word)
+		}
+	case strings.HasPrefix(word, "-") && strings.Contains(word, "="):
+		name, val, _ := strings.Cut(word, "=")
+		if f := lookupFlag(cmd, name); f != nil {
+			if c := flagCompleters[f.Name]; c != nil {
+				for _, v := range c(ctx, nil, val) {
+					cands = append(cands, name+"="+v)
+				}
+			}
+		}
+	case strings.HasPrefix(word, "-") && len(args) == 0:
+		if cmd.FlagSet != nil {
+			cmd.FlagSet.VisitAll(func(f *flag.Flag) {
+				if !strings.HasPrefix(f.Usage, "HIDDEN: ") {
+					cands = append(cands, "--"+f.Name)
+				}
+			})
+		}
+	default:
+		if len(args) == 0 {
+			for _, sub := range cmd.Subcommands {
+				cands = append(cands, sub.Name)
+			}
+		}
+		if c := argCompleters[path]; c != nil {
+			cands = append(cands, c(ctx, args, word)...)
+		}
+	}
+
+	var ret []string
+	for _, c := range cands {
+		if strings.HasPrefix(c, word) && !slices.Contains(ret, c) {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// lookupFlag returns cmd's flag named by w, which is like "-name",
+// "--name" or "--name=value", or nil if there's no such flag.
+func lookupFlag(cmd *ffcli.Command, w string) *flag.Flag {
+	if cmd.FlagSet == nil {
+		return nil
+	}
+	name, _, _ := strings.Cut(strings.TrimLeft(w, "-"), "=")
+	return cmd.FlagSet.Lookup(name)
+}
+
+func findSubcommand(cmd *ffcli.Command, name string) *ffcli.Command {
+	for _, sub := range cmd.Subcommands {
+		if strings.EqualFold(sub.Name, name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// completionStatus returns the status that completers use. It's a var for
+// tests.
+var completionStatus = func(ctx context.Context) (*ipnstate.Status, error) {
+	return localClient.Status(ctx)
+}
+
+// completionProfiles returns the names of the login profiles. It's a var
+// for tests.
+var completionProfiles = func(ctx context.Context) ([]string, error) {
+	_, all, err := localClient.ProfileStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, p := range all {
+		names = append(names, p.Name)
+	}
+	return names, nil
+}
+
+// peerNames returns the short MagicDNS names of the peers in st for which
+// keep returns true.
+func peerNames(st *ipnstate.Status, keep func(*ipnstate.PeerStatus) bool) []string {
+	var names []string
+	for _, ps := range st.Peer {
+		if !keep(ps) {
+			continue
+		}
+		if name, _, _ := strings.Cut(ps.DNSName, "."); name != "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func completePeers(ctx context.Context, _ []string, _ string) []string {
+	st, err := completionStatus(ctx)
+	if err != nil {
+		return nil
+	}
+	return peerNames(st, func(*ipnstate.PeerStatus) bool { return true })
+}
+
+func completeExitNodes(ctx context.Context, _ []string, _ string) []string {
+	st, err := completionStatus(ctx)
+	if err != nil {
+		return nil
+	}
+	return peerNames(st, func(ps *ipnstate.PeerStatus) bool { return ps.ExitNodeOption })
+}
+
+// completeSSHHost completes the host of a [user@]host argument.
+func completeSSHHost(ctx context.Context, args []string, word string) []string {
+	user, _, ok := strings.Cut(word, "@")
+	peers := completePeers(ctx, args, word)
+	if !ok {
+		return peers
+	}
+	var ret []string
+	for _, p := range peers {
+		ret = append(ret, user+"@"+p)
+	}
+	return ret
+}
+
+// completeFileTarget completes the "<target>:" argument of "file cp",
+// leaving file names to the shell.
+func completeFileTarget(ctx context.Context, args []string, word string) []string {
+	if strings.ContainsAny(word, `/\`) || strings.HasPrefix(word, ".") {
+		return nil
+	}
+	var ret []string
+	for _, p := range completePeers(ctx, args, word) {
+		ret = append(ret, p+":")
+	}
+	return ret
+}
+
+func completeProfiles(ctx context.Context, _ []string, _ string) []string {
+	names, err := completionProfiles(ctx)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// completionScripts are the completion scripts by shell. They all run
+// "tailscale __complete" with the words up to the cursor, and complete
+// file names if it prints nothing.
+var completionScripts = map[string]string{
+	"bash": `
+_tailscale() {
+	local line="${COMP_LINE:0:COMP_POINT}"
+	local -a words
+	read -ra words <<< "$line"
+	[[ "$line" == *[[:space:]] ]] && words+=("")
+	local cur="${words[-1]}"
+	# Bash splits words at = and :, so only complete what's after them.
+	local pre="${cur%"${cur##*[=:]}"}"
+	local IFS=$'\n'
+	COMPREPLY=($(tailscale __complete -- "${words[@]:1}" 2>/dev/null))
+	COMPREPLY=("${COMPREPLY[@]#"$pre"}")
+	if [[ ${#COMPREPLY[@]} -eq 0 ]]; then
+		compopt -o default
+	elif [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *[=:] ]]; then
+		compopt -o nospace
+	fi
+}
+complete -F _tailscale tailscale
+`,
+	"zsh": `
+#compdef tailscale
+_tailscale() {
+	local -a cands nospace space
+	cands=("${(@f)$(tailscale __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -z "${cands[1]}" ]]; then
+		_files
+		return
+	fi
+	local c
+	for c in "${cands[@]}"; do
+		if [[ "$c" == *[=:] ]]; then nospace+=("$c"); else space+=("$c"); fi
+	done
+	compadd -Q -S '' -- "${nospace[@]}"
+	compadd -Q -- "${space[@]}"
+}
+compdef _tailscale tailscale
+`,
+	"fish": `
+function __tailscale_complete
+	set -l args (commandline -opc)[2..-1] (commandline -ct)
+	tailscale __complete -- $args 2>/dev/null
+end
+complete -c tailscale -a '(__tailscale_complete)'
+`,
+	"powershell": `
+Register-ArgumentCompleter -Native -CommandName tailscale -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$words = @($commandAst.CommandElements |
+		Select-Object -Skip 1 |
+		Where-Object { $_.Extent.EndOffset -le $cursorPosition } |
+		ForEach-Object { $_.ToString() })
+	if ($wordToComplete -eq '') { $words += ' ' }
+	tailscale __complete -- @words 2>$null | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`,
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"flag"
+	"reflect"
+	"testing"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+)
+
+func TestComplete(t *testing.T) {
+	tstest.Replace(t, &completionStatus, func(context.Context) (*ipnstate.Status, error) {
+		return &ipnstate.Status{
+			Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+				key.NewNode().Public(): {DNSName: "laptop.example.ts.net."},
+				key.NewNode().Public(): {DNSName: "exit-nyc.example.ts.net.", ExitNodeOption: true},
+				key.NewNode().Public(): {DNSName: "exit-lon.example.ts.net.", ExitNodeOption: true},
+			},
+		}, nil
+	})
+	tstest.Replace(t, &completionProfiles, func(context.Context) ([]string, error) {
+		return []string{"work", "home"}, nil
+	})
+
+	setFS := flag.NewFlagSet("set", flag.ContinueOnError)
+	setFS.String("exit-node", "", "")
+	setFS.Bool("shields-up", false, "")
+	setFS.Bool("secret", false, "HIDDEN: not shown")
+	rootFS := flag.NewFlagSet("tailscale", flag.ContinueOnError)
+	rootFS.String("socket", "", "")
+	root := &ffcli.Command{
+		Name:    "tailscale",
+		FlagSet: rootFS,
+		Subcommands: []*ffcli.Command{
+			{Name: "set", FlagSet: setFS},
+			{Name: "ssh"},
+			{Name: "ping"},
+			{Name: "switch"},
+			{Name: "file", Subcommands: []*ffcli.Command{{Name: "cp"}, {Name: "get"}}},
+		},
+	}
+
+	tests := []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{""}, []string{"set", "ssh", "ping", "switch", "file"}},
+		{[]string{"s"}, []string{"set", "ssh", "switch"}},
+		{[]string{"--socket", "/tmp/sock", "p"}, []string{"ping"}},
+		{[]string{"set", "--"}, []string{"--exit-node", "--shields-up"}},
+		{[]string{"set", "--exit-node", ""}, []string{"exit-lon", "exit-nyc"}},
+		{[]string{"set", "--exit-node=exit-n"}, []string{"--exit-node=exit-nyc"}},
+		{[]string{"ping", "l"}, []string{"laptop"}},
+		{[]string{"ping", "laptop", ""}, nil},
+		{[]string{"ssh", "root@lap"}, []string{"root@laptop"}},
+		{[]string{"file", ""}, []string{"cp", "get"}},
+		{[]string{"file", "cp", "a.txt", "lap"}, []string{"laptop:"}},
+		{[]string{"file", "cp", "./"}, nil},
+		{[]string{"switch", ""}, []string{"work", "home"}},
+	}
+	for _, tt := range tests {
+		got := complete(context.Background(), root, tt.words)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("complete(%q) = %q; want %q", tt.words, got, tt.want)
+		}
+	}
+}
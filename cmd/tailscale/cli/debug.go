@@ -7,7 +7,6 @@
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -22,11 +21,14 @@
 	"os"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	xmaps "golang.org/x/exp/maps"
 	"golang.org/x/net/http/httpproxy"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/client/tailscale/apitype"
@@ -41,6 +43,7 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/must"
+	"tailscale.com/wgengine/bench/benchreport"
 	"tailscale.com/wgengine/capture"
 )
 
@@ -145,6 +148,7 @@
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("prefs")
 				fs.BoolVar(&prefsArgs.pretty, "pretty", false, "If true, pretty-print output")
+				fs.BoolVar(&prefsArgs.provenance, "provenance", false, "If true, print where each pref's value came from (CLI, GUI, web client, config file, control, ...) instead of the prefs")
 				return fs
 			})(),
 		},
@@ -160,6 +164,24 @@
 				return fs
 			})(),
 		},
+		{
+			Name:      "netmon",
+			Exec:      runDebugNetmon,
+			ShortHelp: "print network state and captive portal results",
+			LongHelp: strings.TrimSpace(`
+"tailscale debug netmon" prints the current state of the device's network
+interfaces and default route, and the result of the last captive portal
+check, as JSON.
+
+With --follow, it then prints an event per line each time the network
+changes or a captive portal check completes.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("netmon")
+				fs.BoolVar(&netmonArgs.follow, "follow", false, "keep printing network events as they happen")
+				return fs
+			})(),
+		},
 		{
 			Name:      "via",
 			Exec:      runVia,
@@ -231,6 +253,69 @@
 			Exec:      runPeerEndpointChanges,
 			ShortHelp: "prints debug information about a peer's endpoint changes",
 		},
+		{
+			Name:       "endpoints",
+			Exec:       runDebugEndpoints,
+			ShortUsage: "tailscale debug endpoints [--json] <hostname-or-IP>",
+			ShortHelp:  "list a peer's endpoint candidates and how they score",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("endpoints")
+				fs.BoolVar(&debugEndpointsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "firewall-rules",
+			Exec:       runDebugFirewallRules,
+			ShortUsage: "tailscale debug firewall-rules [--format=nftables|iptables|ip6tables]",
+			ShortHelp:  "print the firewall rules to install when tailscaled doesn't manage the firewall",
+			LongHelp: strings.TrimSpace(`
+With TS_DEBUG_FIREWALL_MODE=external, tailscaled on Linux computes the
+firewall rules it needs but leaves installing them to whatever manages the
+host's firewall. This prints those rules, for "nft -f" or
+"iptables-restore --noflush".
+
+tailscaled can also run a program whenever they change, with the rules on its
+stdin: set TS_FIREWALL_HOOK to its path, and TS_FIREWALL_HOOK_FORMAT to the
+format it wants (nftables by default).
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("firewall-rules")
+				fs.StringVar(&debugFirewallRulesArgs.format, "format", "nftables", "format of the rules: nftables, iptables or ip6tables")
+				return fs
+			})(),
+		},
+		{
+			Name:      "filter-latency",
+			Exec:      runDebugFilterLatency,
+			ShortHelp: "time lookups in the packet filter's rules",
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("filter-latency")
+				fs.IntVar(&debugFilterLatencyArgs.lookups, "lookups", 10000, "number of lookups to time")
+				return fs
+			})(),
+		},
+		{
+			Name:       "bench",
+			Exec:       runDebugBench,
+			ShortUsage: "tailscale debug bench [--duration=<d>] [--json] <hostname-or-IP>",
+			ShortHelp:  "measure throughput and latency under load to a peer",
+			LongHelp: strings.TrimSpace(`
+Sends data to a peer over the tailnet, through tailscaled, while pinging
+it, and reports the throughput and latency under load. The peer must grant
+this node debug access.
+
+The --json output is meant for tracking performance over time. To measure
+the data plane on this machine alone, without a peer, run the
+tailscale.com/wgengine/bench program.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("bench")
+				fs.DurationVar(&debugBenchArgs.duration, "duration", 10*time.Second, "how long to send for")
+				fs.BoolVar(&debugBenchArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
 	},
 }
 
@@ -363,10 +448,14 @@ func runLocalAPIProxy() {
 }
 
 var prefsArgs struct {
-	pretty bool
+	pretty     bool
+	provenance bool
 }
 
 func runPrefs(ctx context.Context, args []string) error {
+	if prefsArgs.provenance {
+		return runPrefProvenance(ctx)
+	}
 	prefs, err := localClient.GetPrefs(ctx)
 	if err != nil {
 		return err
@@ -380,6 +469,32 @@ func runPrefs(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runPrefProvenance(ctx context.Context) error {
+	prov, err := localClient.PrefProvenance(ctx)
+	if err != nil {
+		return err
+	}
+	if !prefsArgs.pretty {
+		j, _ := json.MarshalIndent(prov, "", "\t")
+		outln(string(j))
+		return nil
+	}
+	if len(prov) == 0 {
+		outln("No pref changes recorded.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t\n", "PREF", "SOURCE", "CHANGED")
+	names := xmaps.Keys(prov)
+	slices.Sort(names)
+	for _, name := range names {
+		p := prov[name]
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n", name, p.Source, p.Changed.Local().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
 var watchIPNArgs struct {
 	netmap         bool
 	initial        bool
@@ -413,6 +528,39 @@ func runWatchIPN(ctx context.Context, args []string) error {
 	}
 }
 
+var netmonArgs struct {
+	follow bool
+}
+
+func runDebugNetmon(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	enc := json.NewEncoder(Stdout)
+	if !netmonArgs.follow {
+		ev, err := localClient.NetworkState(ctx)
+		if err != nil {
+			return err
+		}
+		enc.SetIndent("", "\t")
+		return enc.Encode(ev)
+	}
+	watcher, err := localClient.WatchNetworkEvents(ctx)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	for {
+		ev, err := watcher.Next()
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+}
+
 func runDERPMap(ctx context.Context, args []string) error {
 	dm, err := localClient.CurrentDERPMap(ctx)
 	if err != nil {
@@ -585,13 +733,11 @@ func runVia(ctx context.Context, args []string) error {
 		if !tsaddr.TailscaleViaRange().Contains(ipp.Addr()) {
 			return errors.New("not a via route")
 		}
-		if ipp.Bits() < 96 {
+		siteID, v4, ok := tsaddr.UnmapViaPrefix(ipp)
+		if !ok {
 			return errors.New("short length, want /96 or more")
 		}
-		v4 := tsaddr.UnmapVia(ipp.Addr())
-		a := ipp.Addr().As16()
-		siteID := binary.BigEndian.Uint32(a[8:12])
-		printf("site %v (0x%x), %v\n", siteID, siteID, netip.PrefixFrom(v4, ipp.Bits()-96))
+		printf("site %v (0x%x), %v\n", siteID, siteID, v4)
 	case 2:
 		siteID, err := strconv.ParseUint(args[0], 0, 32)
 		if err != nil {
@@ -765,6 +911,262 @@ func runDebugDERP(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugFilterLatencyArgs struct {
+	lookups int
+}
+
+func runDebugFilterLatency(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return errors.New("unexpected arguments")
+	}
+	rep, err := localClient.DebugPacketFilterLatency(ctx, debugFilterLatencyArgs.lookups)
+	if err != nil {
+		return err
+	}
+	printf("%d rules, %d source prefixes, %d rules indexed\n", rep.Rules, rep.SrcPrefixes, rep.IndexedRules)
+	if rep.Lookups == 0 {
+		return nil
+	}
+	printf("%d lookups: p50 %v, p90 %v, p99 %v, max %v\n", rep.Lookups, rep.P50, rep.P90, rep.P99, rep.Max)
+	return nil
+}
+
+var debugBenchArgs struct {
+	duration time.Duration
+	json     bool
+}
+
+func runDebugBench(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug bench [flags] <hostname-or-IP>")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	base, err := peerAPIBaseOf(ctx, ip)
+	if err != nil {
+		return err
+	}
+	rep, err := benchPeer(ctx, ip, base, debugBenchArgs.duration)
+	if err != nil {
+		return err
+	}
+	if debugBenchArgs.json {
+		j, _ := json.MarshalIndent(rep, "", "\t")
+		outln(string(j))
+		return nil
+	}
+	printf("%s to %s for %v: %d bytes\n", rep.Test, rep.Peer, rep.Duration.Round(time.Millisecond), rep.Bytes)
+	printf("throughput: %.1f Mbit/s\n", rep.Mbps)
+	if rep.LatencyP50 > 0 {
+		printf("latency under load: p50 %v, p99 %v\n", rep.LatencyP50, rep.LatencyP99)
+	}
+	return nil
+}
+
+// peerAPIBaseOf returns the base URL of the PeerAPI of the peer with
+// Tailscale IP ip, at that IP.
+func peerAPIBaseOf(ctx context.Context, ip netip.Addr) (string, error) {
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, ps := range st.Peer {
+		if !slices.Contains(ps.TailscaleIPs, ip) {
+			continue
+		}
+		for _, base := range ps.PeerAPIURL {
+			if u, err := url.Parse(base); err == nil && u.Hostname() == ip.String() {
+				return base, nil
+			}
+		}
+		return "", fmt.Errorf("peer %v has no PeerAPI at %v", ps.HostName, ip)
+	}
+	return "", fmt.Errorf("no peer with IP %v", ip)
+}
+
+// benchPingInterval is how often the peer is pinged during benchPeer.
+const benchPingInterval = 100 * time.Millisecond
+
+// benchPeer runs the "peer" benchmark: it sends data for d to the PeerAPI
+// at base of the peer with Tailscale IP ip, over a connection that
+// tailscaled dials, while pinging the peer to measure latency under load.
+func benchPeer(ctx context.Context, ip netip.Addr, base string, d time.Duration) (*benchreport.Report, error) {
+	hc := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			host, portStr, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			port, err := strconv.ParseUint(portStr, 10, 16)
+			if err != nil {
+				return nil, err
+			}
+			return localClient.DialTCP(ctx, host, uint16(port))
+		},
+	}}
+
+	pingCtx, cancelPings := context.WithCancel(ctx)
+	defer cancelPings()
+	pingsDone := make(chan []time.Duration, 1)
+	go func() {
+		var latencies []time.Duration
+		defer func() { pingsDone <- latencies }()
+		t := time.NewTicker(benchPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-pingCtx.Done():
+				return
+			case <-t.C:
+			}
+			ctx, cancel := context.WithTimeout(pingCtx, 5*time.Second)
+			pr, err := localClient.Ping(ctx, ip, tailcfg.PingTSMP)
+			cancel()
+			if err == nil && pr.Err == "" {
+				latencies = append(latencies, time.Duration(pr.LatencySeconds*float64(time.Second)))
+			}
+		}
+	}()
+
+	t0 := time.Now()
+	n, err := benchSend(ctx, hc, base, &zeroReader{until: t0.Add(d)})
+	elapsed := time.Since(t0)
+	cancelPings()
+	latencies := <-pingsDone
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, errors.New("no data received by peer")
+	}
+
+	rep := benchreport.New("peer")
+	rep.Peer = ip.String()
+	rep.Fill(n, elapsed, 0, latencies)
+	return rep, nil
+}
+
+// benchSend sends everything read from r to the PeerAPI bench sink at base,
+// returning the number of bytes the peer received.
+func benchSend(ctx context.Context, hc *http.Client, base string, r io.Reader) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/bench", r)
+	if err != nil {
+		return 0, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return 0, fmt.Errorf("HTTP status %v: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	var ret struct{ Bytes int64 }
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return 0, err
+	}
+	return ret.Bytes, nil
+}
+
+// zeroReader reads zeros until the time until.
+type zeroReader struct {
+	until time.Time
+}
+
+func (r *zeroReader) Read(p []byte) (int, error) {
+	if !time.Now().Before(r.until) {
+		return 0, io.EOF
+	}
+	clear(p)
+	return len(p), nil
+}
+
+var debugFirewallRulesArgs struct {
+	format string
+}
+
+func runDebugFirewallRules(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	rules, err := localClient.FirewallRules(ctx, debugFirewallRulesArgs.format)
+	if err != nil {
+		return err
+	}
+	Stdout.Write([]byte(rules))
+	return nil
+}
+
+var debugEndpointsArgs struct {
+	json bool
+}
+
+func runDebugEndpoints(ctx context.Context, args []string) error {
+	if len(args) != 1 || args[0] == "" {
+		return errors.New("usage: tailscale debug endpoints [--json] <hostname-or-IP>")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return fmt.Errorf("%v is local Tailscale IP", ipStr)
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	cs, err := localClient.DebugEndpoints(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if debugEndpointsArgs.json {
+		j, _ := json.MarshalIndent(cs, "", "\t")
+		outln(string(j))
+		return nil
+	}
+
+	outln("Self:")
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t\n", "ADDR", "SOURCE")
+	for _, c := range cs.Self {
+		fmt.Fprintf(w, "%s\t%s\t\n", c.Addr, c.Source)
+	}
+	w.Flush()
+
+	outln("\nPeer:")
+	w = tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", "ADDR", "SOURCE", "LAST PING", "LATENCY", "PONGS", "SCORE", "TRUST")
+	now := time.Now()
+	for _, c := range cs.Peer {
+		lastPing, latency, trust := "-", "-", "-"
+		if !c.LastPing.IsZero() {
+			lastPing = fmt.Sprintf("%v ago", now.Sub(c.LastPing).Round(time.Second))
+		}
+		if c.Latency > 0 {
+			latency = c.Latency.Round(time.Microsecond).String()
+		}
+		if c.Best {
+			trust = "best"
+			if now.Before(c.TrustedUntil) {
+				trust = fmt.Sprintf("best, trusted for %v", c.TrustedUntil.Sub(now).Round(time.Second))
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t\n", c.Addr, c.Source, lastPing, latency, c.Pongs, c.Score, trust)
+	}
+	return w.Flush()
+}
+
 var setExpireArgs struct {
 	in time.Duration
 }
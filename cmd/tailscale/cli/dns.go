@@ -0,0 +1,208 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var dnsCmd = &ffcli.Command{
+	Name:       "dns",
+	ShortUsage: "dns <subcommand> [command flags]",
+	ShortHelp:  "Diagnose tailscaled's DNS resolver",
+	Exec:       func(context.Context, []string) error { return flag.ErrHelp },
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "log",
+			ShortUsage: "dns log [--enable [--size=N] [--redact-names] [--redact-clients] | --disable] [--follow] [--json]",
+			ShortHelp:  "Show the DNS queries answered by tailscaled",
+			LongHelp: strings.TrimSpace(`
+'tailscale dns log' shows the most recent DNS queries answered by
+tailscaled's resolver (at 100.100.100.100): how each was answered, by which
+upstream resolver if forwarded, and how long it took.
+
+The query log is off by default. Turn it on with --enable, optionally
+redacting queried names and client addresses, and off again with --disable.
+It's kept in memory only, and is cleared when tailscaled restarts.
+`),
+			Exec: runDNSLog,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("log")
+				fs.BoolVar(&dnsLogArgs.enable, "enable", false, "turn on the query log")
+				fs.IntVar(&dnsLogArgs.size, "size", 1000, "with --enable, the number of most recent queries to keep")
+				fs.BoolVar(&dnsLogArgs.redactNames, "redact-names", false, `with --enable, log only the last two labels of names (e.g. "*.example.com.")`)
+				fs.BoolVar(&dnsLogArgs.redactClients, "redact-clients", false, "with --enable, don't log the addresses of clients")
+				fs.BoolVar(&dnsLogArgs.disable, "disable", false, "turn off the query log, discarding it")
+				fs.BoolVar(&dnsLogArgs.follow, "follow", false, "keep printing queries as they're answered")
+				fs.BoolVar(&dnsLogArgs.json, "json", false, "output in JSON format, one entry per line")
+				return fs
+			})(),
+		},
+		{
+			Name:       "upstreams",
+			ShortUsage: "dns upstreams [--json]",
+			ShortHelp:  "Show statistics on the upstream resolvers queries are forwarded to",
+			Exec:       runDNSUpstreams,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("upstreams")
+				fs.BoolVar(&dnsUpstreamsArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+}
+
+var dnsLogArgs struct {
+	enable        bool
+	size          int
+	redactNames   bool
+	redactClients bool
+	disable       bool
+	follow        bool
+	json          bool
+}
+
+// dnsLogPollWait is how long each poll of 'tailscale dns log --follow' waits
+// for new queries.
+const dnsLogPollWait = time.Minute
+
+func runDNSLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale dns log'")
+	}
+	if dnsLogArgs.enable && dnsLogArgs.disable {
+		return errors.New("--enable and --disable are mutually exclusive")
+	}
+	switch {
+	case dnsLogArgs.enable:
+		if dnsLogArgs.size <= 0 {
+			return errors.New("--size must be positive")
+		}
+		err := localClient.SetDNSQueryLogConfig(ctx, ipnstate.DNSQueryLogConfig{
+			Size:          dnsLogArgs.size,
+			RedactNames:   dnsLogArgs.redactNames,
+			RedactClients: dnsLogArgs.redactClients,
+		})
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+	case dnsLogArgs.disable:
+		if err := localClient.SetDNSQueryLogConfig(ctx, ipnstate.DNSQueryLogConfig{}); err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		outln("DNS query log disabled.")
+		return nil
+	}
+
+	ql, err := localClient.DNSQueryLog(ctx, 0, 0)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if ql.Config.Size == 0 {
+		return errors.New("the DNS query log is disabled; turn it on with 'tailscale dns log --enable'")
+	}
+	if len(ql.Entries) == 0 && !dnsLogArgs.follow {
+		outln("No DNS queries logged yet.")
+		return nil
+	}
+	var since uint64
+	for {
+		if err := printDNSLogEntries(ql.Entries); err != nil {
+			return err
+		}
+		if n := len(ql.Entries); n > 0 {
+			since = ql.Entries[n-1].Seq
+		}
+		if !dnsLogArgs.follow {
+			return nil
+		}
+		ql, err = localClient.DNSQueryLog(ctx, since, dnsLogPollWait)
+		if err != nil {
+			return err
+		}
+		if ql.Config.Size == 0 {
+			return errors.New("the DNS query log was disabled")
+		}
+	}
+}
+
+func printDNSLogEntries(ents []ipnstate.DNSQueryLogEntry) error {
+	if dnsLogArgs.json {
+		for _, e := range ents {
+			j, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			outln(string(j))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 10, 5, 2, ' ', 0)
+	for _, e := range ents {
+		answer := e.RCode
+		if e.Err != "" {
+			answer = "error: " + e.Err
+		}
+		via := e.Source
+		if e.Upstream != "" {
+			via += " via " + e.Upstream
+		}
+		client := e.Client
+		if client == "" {
+			client = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%v\t\n",
+			e.Time.Local().Format("15:04:05.000"), client, strings.TrimPrefix(e.Type, "Type"), e.Name,
+			strings.TrimPrefix(answer, "RCode"), via, e.Latency.Round(time.Microsecond))
+	}
+	return w.Flush()
+}
+
+var dnsUpstreamsArgs struct {
+	json bool
+}
+
+func runDNSUpstreams(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale dns upstreams'")
+	}
+	ql, err := localClient.DNSQueryLog(ctx, ^uint64(0), 0)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if dnsUpstreamsArgs.json {
+		j, err := json.MarshalIndent(ql.Upstreams, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	if len(ql.Upstreams) == 0 {
+		outln("No DNS queries forwarded yet.")
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t\n", "UPSTREAM", "QUERIES", "ERRORS", "AVG", "MAX", "LAST ERROR")
+	for _, u := range ql.Upstreams {
+		lastErr := u.LastError
+		if lastErr == "" {
+			lastErr = "-"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%v\t%v\t%s\t\n", u.Upstream, u.Queries, u.Errors,
+			u.LatencyAvg.Round(time.Microsecond), u.LatencyMax.Round(time.Microsecond), lastErr)
+	}
+	return nil
+}
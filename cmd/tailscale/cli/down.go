@@ -5,8 +5,11 @@
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
@@ -14,8 +17,18 @@
 
 var downCmd = &ffcli.Command{
 	Name:       "down",
-	ShortUsage: "down",
+	ShortUsage: "down [--after=<duration>] [--until=<time>]",
 	ShortHelp:  "Disconnect from Tailscale",
+	LongHelp: strings.TrimSpace(`
+"tailscale down" disconnects this machine from your Tailscale network
+until the next "tailscale up".
+
+With --after, it disconnects later instead of now. With --until, it
+reconnects automatically at the given time, which may be a time of day
+("18:00"), an RFC 3339 timestamp, or a duration from now ("2h").
+Scheduled changes survive restarts of tailscaled, and are canceled by
+the next "tailscale up" or "tailscale down".
+`),
 
 	Exec:    runDown,
 	FlagSet: newDownFlagSet(),
@@ -23,11 +36,15 @@
 
 var downArgs struct {
 	acceptedRisks string
+	after         time.Duration
+	until         string
 }
 
 func newDownFlagSet() *flag.FlagSet {
 	downf := newFlagSet("down")
 	registerAcceptRiskFlag(downf, &downArgs.acceptedRisks)
+	downf.DurationVar(&downArgs.after, "after", 0, "disconnect after this long (e.g. 30m) instead of now")
+	downf.StringVar(&downArgs.until, "until", "", `reconnect automatically at this time ("18:00", RFC 3339, or a duration like "2h")`)
 	return downf
 }
 
@@ -36,7 +53,12 @@ func runDown(ctx context.Context, args []string) error {
 		return fmt.Errorf("too many non-flag arguments: %q", args)
 	}
 
-	if isSSHOverTailscale() {
+	sched, err := wantRunningScheduleFromFlags(false, downArgs.after, downArgs.until, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if isSSHOverTailscale() && downArgs.after == 0 {
 		if err := presentRiskToUser(riskLoseSSH, `You are connected over Tailscale; this action will disable Tailscale and result in your session disconnecting.`, downArgs.acceptedRisks); err != nil {
 			return err
 		}
@@ -46,6 +68,13 @@ func runDown(ctx context.Context, args []string) error {
 	if err != nil {
 		return fmt.Errorf("error fetching current status: %w", err)
 	}
+	if sched != nil {
+		if err := localClient.SetWantRunningSchedule(ctx, sched); err != nil {
+			return err
+		}
+		printWantRunningSchedule(sched)
+		return nil
+	}
 	if st.BackendState == "Stopped" {
 		fmt.Fprintf(Stderr, "Tailscale was already stopped.\n")
 		return nil
@@ -58,3 +87,73 @@ func runDown(ctx context.Context, args []string) error {
 	})
 	return err
 }
+
+// wantRunningScheduleFromFlags returns the timed change of WantRunning to
+// want requested by the --after and --until flags of "tailscale up" or
+// "tailscale down", or nil if neither was given.
+func wantRunningScheduleFromFlags(want bool, after time.Duration, until string, now time.Time) (*ipn.WantRunningSchedule, error) {
+	if after == 0 && until == "" {
+		return nil, nil
+	}
+	if after < 0 {
+		return nil, errors.New("--after must not be negative")
+	}
+	s := &ipn.WantRunningSchedule{WantRunning: want}
+	if after > 0 {
+		s.After = now.Add(after)
+	}
+	if until != "" {
+		t, err := parseUntil(until, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --until: %w", err)
+		}
+		if !t.After(now.Add(after)) {
+			return nil, errors.New("--until must be later than --after")
+		}
+		s.Until = t
+	}
+	return s, nil
+}
+
+// parseUntil parses the value of an --until flag: a duration from now
+// ("2h"), a time of day ("18:00", meaning its next occurrence), or an RFC
+// 3339 timestamp.
+func parseUntil(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, errors.New("duration must be positive")
+		}
+		return now.Add(d), nil
+	}
+	if t, err := time.ParseInLocation("15:04", s, now.Location()); err == nil {
+		y, m, d := now.Date()
+		t = time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a duration, time of day (15:04) or RFC 3339 time", s)
+}
+
+// printWantRunningSchedule describes s to the user.
+func printWantRunningSchedule(s *ipn.WantRunningSchedule) {
+	verb, revert := "connect", "disconnect"
+	if !s.WantRunning {
+		verb, revert = "disconnect", "reconnect"
+	}
+	when := func(t time.Time) string {
+		return fmt.Sprintf("%s (in %v)", t.Format("Mon 15:04"), time.Until(t).Round(time.Minute))
+	}
+	var parts []string
+	if !s.After.IsZero() {
+		parts = append(parts, verb+" at "+when(s.After))
+	}
+	if !s.Until.IsZero() {
+		parts = append(parts, revert+" at "+when(s.Until))
+	}
+	outln("Tailscale will " + strings.Join(parts, " and ") + ".")
+}
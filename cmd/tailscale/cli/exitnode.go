@@ -35,6 +35,8 @@
 				return fs
 			})(),
 		},
+		exitNodePolicyCmd,
+		exitNodeConsentCmd,
 	},
 	Exec: func(context.Context, []string) error {
 		return errors.New("exit-node subcommand required; run 'tailscale exit-node -h' for details")
@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+)
+
+var exitNodeConsentCmd = &ffcli.Command{
+	Name:       "consent",
+	ShortUsage: "exit-node consent [ack [<id>]]",
+	ShortHelp:  "Show or acknowledge exit node changes made by policy",
+	LongHelp: strings.TrimSpace(`
+When the config file, the control server or the exit node policy, rather than
+you, starts, changes or stops routing this device's traffic through an exit
+node, a notice is recorded for you to acknowledge.
+
+"tailscale exit-node consent" shows the recent notices. "tailscale exit-node
+consent ack" acknowledges the notice with the given ID, or all pending
+notices.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "ack",
+			ShortUsage: "exit-node consent ack [<id>]",
+			ShortHelp:  "Acknowledge an exit node change, or all pending ones",
+			Exec:       runExitNodeConsentAck,
+		},
+	},
+	Exec: runExitNodeConsentShow,
+}
+
+func runExitNodeConsentShow(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale exit-node consent'")
+	}
+	cs, err := localClient.ExitNodeConsents(ctx)
+	if err != nil {
+		return err
+	}
+	if len(cs) == 0 {
+		outln("No exit node changes have been made by policy.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "\n %s\t%s\t%s\t%s\t%s\t", "ID", "TIME", "BY", "EXIT NODE", "ACKNOWLEDGED")
+	for _, c := range cs {
+		ack := "pending"
+		if !c.Pending() {
+			ack = c.Acknowledged.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "\n %s\t%s\t%s\t%s\t%s\t", c.ID, c.Time.Local().Format(time.DateTime), c.Source, exitNodeConsentTarget(c), ack)
+	}
+	fmt.Fprintln(w)
+	return nil
+}
+
+// exitNodeConsentTarget returns how to show the exit node that c is about.
+func exitNodeConsentTarget(c ipn.ExitNodeConsent) string {
+	switch {
+	case c.ExitNodeName != "":
+		return c.ExitNodeName
+	case !c.ExitNodeID.IsZero():
+		return string(c.ExitNodeID)
+	case c.ExitNodeIP.IsValid():
+		return c.ExitNodeIP.String()
+	}
+	return "none"
+}
+
+func runExitNodeConsentAck(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: tailscale exit-node consent ack [<id>]")
+	}
+	var id string
+	if len(args) == 1 {
+		id = args[0]
+	}
+	return localClient.AcknowledgeExitNodeConsent(ctx, id)
+}
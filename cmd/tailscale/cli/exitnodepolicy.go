@@ -0,0 +1,167 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+	"tailscale.com/net/interfaces"
+)
+
+var exitNodePolicyCmd = &ffcli.Command{
+	Name:       "policy",
+	ShortUsage: "exit-node policy <show|add|remove|clear> [flags]",
+	ShortHelp:  "Manage rules that pick the exit node by network or time of day",
+	LongHelp: strings.TrimSpace(`
+An exit node policy is an ordered list of rules. Whenever the network or the
+time of day changes, the first rule whose conditions match picks the exit
+node. If no rule matches, the exit node is left alone.
+
+The exit node of a rule is a Tailscale IP, MagicDNS name or stable node ID;
+"auto" for the exit node with the lowest latency; "auto:CC" for the one with
+the lowest latency in country CC; or "none" for no exit node.
+`),
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "show",
+			ShortUsage: "exit-node policy show",
+			ShortHelp:  "Show the exit node policy and the current networks",
+			Exec:       runExitNodePolicyShow,
+		},
+		{
+			Name:       "add",
+			ShortUsage: "exit-node policy add [--network=<ssid|router-ip>] [--hours=HH:MM-HH:MM] <exit-node|auto|auto:CC|none>",
+			ShortHelp:  "Add a rule to the exit node policy",
+			Exec:       runExitNodePolicyAdd,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("add")
+				fs.StringVar(&exitNodePolicyArgs.network, "network", "", "only apply the rule on this network: a Wi-Fi SSID or the LAN router's IP address")
+				fs.StringVar(&exitNodePolicyArgs.hours, "hours", "", "only apply the rule during these local hours, like 09:00-17:00")
+				fs.IntVar(&exitNodePolicyArgs.position, "position", 0, "1-based position to insert the rule at; by default it's added last")
+				return fs
+			})(),
+		},
+		{
+			Name:       "remove",
+			ShortUsage: "exit-node policy remove <rule-number>",
+			ShortHelp:  "Remove a rule from the exit node policy",
+			Exec:       runExitNodePolicyRemove,
+		},
+		{
+			Name:       "clear",
+			ShortUsage: "exit-node policy clear",
+			ShortHelp:  "Remove all rules from the exit node policy",
+			Exec:       runExitNodePolicyClear,
+		},
+	},
+	Exec: runExitNodePolicyShow,
+}
+
+var exitNodePolicyArgs struct {
+	network  string
+	hours    string
+	position int
+}
+
+func runExitNodePolicyShow(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale exit-node policy show'")
+	}
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if len(prefs.ExitNodePolicy) == 0 {
+		outln("No exit node policy.")
+	}
+	for i, r := range prefs.ExitNodePolicy {
+		printf("%d. %v\n", i+1, r)
+	}
+	var networks []string
+	if ssid := interfaces.WiFiSSID(); ssid != "" {
+		networks = append(networks, fmt.Sprintf("Wi-Fi %q", ssid))
+	}
+	if gw, _, ok := interfaces.LikelyHomeRouterIP(); ok {
+		networks = append(networks, "router "+gw.String())
+	}
+	if len(networks) > 0 {
+		printf("\nCurrent networks: %s\n", strings.Join(networks, ", "))
+	}
+	return nil
+}
+
+func runExitNodePolicyAdd(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale exit-node policy add [flags] <exit-node|auto|auto:CC|none>")
+	}
+	r := ipn.ExitNodeRule{
+		Network:  exitNodePolicyArgs.network,
+		Hours:    exitNodePolicyArgs.hours,
+		ExitNode: args[0],
+	}
+	if err := r.Check(); err != nil {
+		return err
+	}
+	return editExitNodePolicy(ctx, func(rules []ipn.ExitNodeRule) ([]ipn.ExitNodeRule, error) {
+		pos := exitNodePolicyArgs.position
+		if pos == 0 {
+			pos = len(rules) + 1
+		}
+		if pos < 1 || pos > len(rules)+1 {
+			return nil, fmt.Errorf("invalid --position %d; policy has %d rules", pos, len(rules))
+		}
+		return slices.Insert(rules, pos-1, r), nil
+	})
+}
+
+func runExitNodePolicyRemove(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale exit-node policy remove <rule-number>")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid rule number %q", args[0])
+	}
+	return editExitNodePolicy(ctx, func(rules []ipn.ExitNodeRule) ([]ipn.ExitNodeRule, error) {
+		if n < 1 || n > len(rules) {
+			return nil, fmt.Errorf("no rule %d; policy has %d rules", n, len(rules))
+		}
+		return slices.Delete(rules, n-1, n), nil
+	})
+}
+
+func runExitNodePolicyClear(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale exit-node policy clear'")
+	}
+	return editExitNodePolicy(ctx, func([]ipn.ExitNodeRule) ([]ipn.ExitNodeRule, error) {
+		return nil, nil
+	})
+}
+
+// editExitNodePolicy replaces the exit node policy with the result of
+// calling edit on the current one.
+func editExitNodePolicy(ctx context.Context, edit func([]ipn.ExitNodeRule) ([]ipn.ExitNodeRule, error)) error {
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	rules, err := edit(prefs.ExitNodePolicy)
+	if err != nil {
+		return err
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:             ipn.Prefs{ExitNodePolicy: rules},
+		ExitNodePolicySet: true,
+	})
+	return err
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var flowLogCmd = &ffcli.Command{
+	Name:       "flow-log",
+	ShortUsage: "flow-log [--enable [--size=N] | --disable] [--follow] [--json]",
+	ShortHelp:  "Show the connections peers made, or tried to make, to this node",
+	LongHelp: strings.TrimSpace(`
+'tailscale flow-log' shows the most recent flows peers started to this
+node: TCP connections, and the first packets of other protocols' flows,
+with the peer's node and user, the local port, and whether the packet
+filter accepted or rejected it.
+
+The flow log is off by default. Turn it on with --enable and off again with
+--disable. It's kept in memory only, and is cleared when tailscaled
+restarts; run tailscaled with --flow-log-file or --flow-log-syslog to also
+export the flows it logs.
+`),
+	Exec: runFlowLog,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("flow-log")
+		fs.BoolVar(&flowLogArgs.enable, "enable", false, "turn on the flow log")
+		fs.IntVar(&flowLogArgs.size, "size", 1000, "with --enable, the number of most recent flows to keep")
+		fs.BoolVar(&flowLogArgs.disable, "disable", false, "turn off the flow log, discarding it")
+		fs.BoolVar(&flowLogArgs.follow, "follow", false, "keep printing flows as they're logged")
+		fs.BoolVar(&flowLogArgs.json, "json", false, "output in JSON format, one entry per line")
+		return fs
+	})(),
+}
+
+var flowLogArgs struct {
+	enable  bool
+	size    int
+	disable bool
+	follow  bool
+	json    bool
+}
+
+// flowLogPollWait is how long each poll of 'tailscale flow-log --follow'
+// waits for new flows.
+const flowLogPollWait = time.Minute
+
+func runFlowLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale flow-log'")
+	}
+	if flowLogArgs.enable && flowLogArgs.disable {
+		return errors.New("--enable and --disable are mutually exclusive")
+	}
+	switch {
+	case flowLogArgs.enable:
+		if flowLogArgs.size <= 0 {
+			return errors.New("--size must be positive")
+		}
+		if err := localClient.SetFlowLogConfig(ctx, ipnstate.FlowLogConfig{Size: flowLogArgs.size}); err != nil {
+			return fixTailscaledConnectError(err)
+		}
+	case flowLogArgs.disable:
+		if err := localClient.SetFlowLogConfig(ctx, ipnstate.FlowLogConfig{}); err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		outln("Flow log disabled.")
+		return nil
+	}
+
+	fl, err := localClient.FlowLog(ctx, 0, 0)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if fl.Config.Size == 0 {
+		return errors.New("the flow log is disabled; turn it on with 'tailscale flow-log --enable'")
+	}
+	if len(fl.Entries) == 0 && !flowLogArgs.follow {
+		outln("No flows logged yet.")
+		return nil
+	}
+	var since uint64
+	for {
+		if err := printFlowLogEntries(fl.Entries); err != nil {
+			return err
+		}
+		if n := len(fl.Entries); n > 0 {
+			since = fl.Entries[n-1].Seq
+		}
+		if !flowLogArgs.follow {
+			return nil
+		}
+		fl, err = localClient.FlowLog(ctx, since, flowLogPollWait)
+		if err != nil {
+			return err
+		}
+		if fl.Config.Size == 0 {
+			return errors.New("the flow log was disabled")
+		}
+	}
+}
+
+func printFlowLogEntries(ents []ipnstate.FlowLogEntry) error {
+	if flowLogArgs.json {
+		for _, e := range ents {
+			j, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			outln(string(j))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(Stdout, 10, 5, 2, ' ', 0)
+	for _, e := range ents {
+		verdict := "rejected"
+		if e.Accepted {
+			verdict = "accepted"
+		}
+		node, user := e.Node, e.User
+		if node == "" {
+			node, user = "-", "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%v\t%v\t%s\t%s\t\n",
+			e.Time.Local().Format("15:04:05.000"), verdict, e.Proto, e.Src, e.Dst, node, user)
+	}
+	return w.Flush()
+}
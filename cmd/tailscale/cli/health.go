@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/health"
+	"tailscale.com/util/cmpx"
+)
+
+var healthCmd = &ffcli.Command{
+	Name:       "health",
+	ShortUsage: "health [--json]",
+	ShortHelp:  "Show current health problems",
+	LongHelp: strings.TrimSpace(`
+'tailscale health' shows the problems tailscaled currently detects, with
+their severity and a stable code identifying the kind of problem.
+
+Use --json for output that scripts and monitoring tools can act on.
+tailscaled can also notify a local webhook or run a program when a problem
+starts or ends; see its --health-webhook and --health-exec flags.
+`),
+	Exec: runHealth,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("health")
+		fs.BoolVar(&healthArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+}
+
+var healthArgs struct {
+	json bool
+}
+
+func runHealth(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale health'")
+	}
+	return printHealthView(ctx, healthArgs.json)
+}
+
+// healthWarning is a health problem as shown by 'tailscale health' and
+// 'tailscale status --health'.
+type healthWarning struct {
+	health.UnhealthyState
+	Duration    string   `json:",omitempty"` // how long it's been a problem, if known
+	Remediation []string `json:",omitempty"` // commands that may fix or diagnose it
+}
+
+// healthRemediations are the commands suggested for health problems, by
+// code.
+var healthRemediations = map[health.WarnableCode][]string{
+	health.CodeNotRunning:                      {"tailscale up"},
+	health.CodeLoginError:                      {"tailscale login"},
+	health.CodeNotInMapPoll:                    {"tailscale netcheck"},
+	health.CodeNoMapResponse:                   {"tailscale netcheck"},
+	health.CodeNoDERPHome:                      {"tailscale netcheck"},
+	health.CodeDERPHomeDisconnected:            {"tailscale netcheck"},
+	health.CodeDERPHomeSilent:                  {"tailscale netcheck"},
+	health.CodeDERPRegionProblem:               {"tailscale netcheck"},
+	health.CodeTLSConnectionError:              {"tailscale netcheck"},
+	health.CodeNoUDP4Bind:                      {"tailscale bugreport"},
+	health.CodeReceiveFuncNotRunning:           {"tailscale bugreport"},
+	health.CodeLogConfig:                       {"tailscale bugreport"},
+	health.SubsystemCode(health.SysDNS):        {"tailscale dns upstreams"},
+	health.SubsystemCode(health.SysDNSOS):      {"tailscale dns upstreams"},
+	health.SubsystemCode(health.SysDNSManager): {"tailscale dns upstreams"},
+	health.SubsystemCode(health.SysTKA):        {"tailscale lock status"},
+	health.SubsystemCode(health.SysRouter):     {"tailscale bugreport"},
+	"dns-resolv-conf-overwritten":              {"tailscale down", "tailscale up"},
+	"bandwidth-quota":                          {"tailscale bandwidth"},
+	"invalid-packet-filter":                    {"tailscale lock status"},
+	"static-endpoints-unreachable":             {"tailscale netcheck"},
+}
+
+// healthWarnings returns the problems of st with their durations, relative
+// to now, and suggested remediations.
+func healthWarnings(st *health.State, now time.Time) []healthWarning {
+	ws := make([]healthWarning, len(st.Warnings))
+	for i, p := range st.Warnings {
+		ws[i] = healthWarning{
+			UnhealthyState: p,
+			Remediation:    healthRemediations[p.Code],
+		}
+		if !p.BrokenSince.IsZero() {
+			ws[i].Duration = now.Sub(p.BrokenSince).Round(time.Second).String()
+		}
+	}
+	return ws
+}
+
+// printHealthView prints the node's current health problems with their
+// severity, affected subsystem, duration and suggested remediations, as a
+// table or, if asJSON, as JSON.
+func printHealthView(ctx context.Context, asJSON bool) error {
+	st, err := localClient.HealthState(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	ws := healthWarnings(st, time.Now())
+	if asJSON {
+		j, err := json.MarshalIndent(struct{ Warnings []healthWarning }{ws}, "", "\t")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+	if len(ws) == 0 {
+		outln("No health problems detected.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", "SEVERITY", "SUBSYSTEM", "CODE", "SINCE", "PROBLEM")
+	for _, p := range ws {
+		text := p.Text
+		if p.DocURL != "" && !strings.Contains(text, p.DocURL) {
+			text += " (see " + p.DocURL + ")"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", p.Severity, cmpx.Or(string(p.Subsystem), "-"), p.Code, cmpx.Or(p.Duration, "-"), text)
+	}
+	w.Flush()
+
+	var fixes []string
+	for _, p := range ws {
+		if len(p.Remediation) > 0 {
+			fixes = append(fixes, fmt.Sprintf("  %s: %s", p.Code, strings.Join(p.Remediation, "; ")))
+		}
+	}
+	if len(fixes) > 0 {
+		outln()
+		outln("Suggested commands:")
+		for _, f := range fixes {
+			outln(f)
+		}
+	}
+	return nil
+}
@@ -6,19 +6,43 @@
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 )
 
 var ncCmd = &ffcli.Command{
 	Name:       "nc",
-	ShortUsage: "nc <hostname-or-IP> <port>",
+	ShortUsage: "nc [-u] <hostname-or-IP> <port>",
 	ShortHelp:  "Connect to a port on a host, connected to stdin/stdout",
-	Exec:       runNC,
+	LongHelp: strings.TrimSpace(`
+The 'tailscale nc' command connects to a TCP or UDP port on a host via
+tailscaled, as it would dial for the SOCKS5 or HTTP proxies, including in
+userspace networking mode, and copies stdin to it and its replies to
+stdout.
+
+With -u, each read from stdin, such as a line typed at a terminal, is sent
+as one UDP datagram, and each datagram received is written to stdout. When
+stdin closes, replies are awaited for the duration given by -w.
+`),
+	Exec: runNC,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("nc")
+		fs.BoolVar(&ncArgs.udp, "u", false, "use UDP instead of TCP")
+		fs.DurationVar(&ncArgs.wait, "w", 2*time.Second, "with -u, how long to wait for replies after stdin closes")
+		return fs
+	})(),
+}
+
+var ncArgs struct {
+	udp  bool
+	wait time.Duration
 }
 
 func runNC(ctx context.Context, args []string) error {
@@ -33,7 +57,7 @@ func runNC(ctx context.Context, args []string) error {
 	}
 
 	if len(args) != 2 {
-		return errors.New("usage: nc <hostname-or-IP> <port>")
+		return errors.New("usage: nc [-u] <hostname-or-IP> <port>")
 	}
 
 	hostOrIP, portStr := args[0], args[1]
@@ -42,8 +66,11 @@ func runNC(ctx context.Context, args []string) error {
 		return fmt.Errorf("invalid port number %q", portStr)
 	}
 
-	// TODO(bradfitz): also add UDP too, via flag?
-	c, err := localClient.DialTCP(ctx, hostOrIP, uint16(port))
+	network := "tcp"
+	if ncArgs.udp {
+		network = "udp"
+	}
+	c, err := localClient.UserDial(ctx, network, hostOrIP, uint16(port))
 	if err != nil {
 		return fmt.Errorf("Dial(%q, %v): %w", hostOrIP, port, err)
 	}
@@ -53,9 +80,25 @@ func runNC(ctx context.Context, args []string) error {
 		_, err := io.Copy(os.Stdout, c)
 		errc <- err
 	}()
+	stdinc := make(chan error, 1)
 	go func() {
 		_, err := io.Copy(c, os.Stdin)
-		errc <- err
+		stdinc <- err
 	}()
-	return <-errc
+	select {
+	case err := <-errc:
+		return err
+	case err := <-stdinc:
+		if err != nil || !ncArgs.udp {
+			return err
+		}
+	}
+	// UDP has no end of stream for the remote side to see and respond
+	// to, so wait a bit for any replies to what was sent.
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(ncArgs.wait):
+		return nil
+	}
 }
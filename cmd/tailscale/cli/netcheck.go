@@ -6,6 +6,7 @@
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -24,6 +25,7 @@
 	"tailscale.com/net/tlsdial"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/cmpx"
 )
 
 var netcheckCmd = &ffcli.Command{
@@ -36,17 +38,30 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.BoolVar(&netcheckArgs.bandwidth, "bandwidth", false, "also measure download bandwidth from the nearest DERP region")
+		fs.Int64Var(&netcheckArgs.bandwidthSize, "bandwidth-size", netcheck.DefaultBandwidthProbeSize, "maximum number of bytes to download for --bandwidth")
+		fs.DurationVar(&netcheckArgs.bandwidthDuration, "bandwidth-duration", netcheck.DefaultBandwidthProbeDuration, "maximum duration of the --bandwidth download")
+		fs.BoolVar(&netcheckArgs.history, "history", false, "instead of running a netcheck, report the DERP latency trends and home region changes in the samples tailscaled recorded (see 'tailscale set --netcheck-history-hours')")
+		fs.IntVar(&netcheckArgs.historyHours, "history-hours", 0, "with --history, the number of most recent hours to report, or 0 for all recorded samples")
 		return fs
 	})(),
 }
 
 var netcheckArgs struct {
-	format  string
-	every   time.Duration
-	verbose bool
+	format            string
+	every             time.Duration
+	verbose           bool
+	bandwidth         bool
+	bandwidthSize     int64
+	bandwidthDuration time.Duration
+	history           bool
+	historyHours      int
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
+	if netcheckArgs.history {
+		return runNetcheckHistory(ctx)
+	}
 	logf := logger.WithPrefix(log.Printf, "portmap: ")
 	netMon, err := netmon.New(logf)
 	if err != nil {
@@ -93,6 +108,16 @@ func runNetcheck(ctx context.Context, args []string) error {
 		if err != nil {
 			return fmt.Errorf("netcheck: %w", err)
 		}
+		if netcheckArgs.bandwidth && report.PreferredDERP != 0 {
+			bw, err := c.MeasureDERPBandwidth(ctx, dm.Regions[report.PreferredDERP], netcheck.BandwidthProbe{
+				Size:     netcheckArgs.bandwidthSize,
+				Duration: netcheckArgs.bandwidthDuration,
+			})
+			if err != nil {
+				fmt.Fprintln(Stderr, "netcheck: bandwidth test failure:", err)
+			}
+			report.DERPBandwidth = bw
+		}
 		if err := printReport(dm, report); err != nil {
 			return err
 		}
@@ -142,6 +167,10 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 	}
 	printf("\t* MappingVariesByDestIP: %v\n", report.MappingVariesByDestIP)
 	printf("\t* HairPinning: %v\n", report.HairPinning)
+	if report.NATMapping != "" || report.NATFiltering != "" {
+		printf("\t* NAT mapping: %v\n", cmpx.Or(report.NATMapping, "unknown"))
+		printf("\t* NAT filtering: %v\n", cmpx.Or(report.NATFiltering, "unknown"))
+	}
 	printf("\t* PortMapping: %v\n", portMapping(report))
 	if report.CaptivePortal != "" {
 		printf("\t* CaptivePortal: %v\n", report.CaptivePortal)
@@ -184,9 +213,93 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 			printf("\t\t- %3s: %-7s (%s%s)\n", r.RegionCode, latency, derpNum, r.RegionName)
 		}
 	}
+	if report.DERPBandwidth > 0 {
+		printf("\t* DERP bandwidth: %s\n", formatBandwidth(report.DERPBandwidth))
+	}
 	return nil
 }
 
+func runNetcheckHistory(ctx context.Context) error {
+	if netcheckArgs.historyHours < 0 {
+		return errors.New("--history-hours must not be negative")
+	}
+	h, err := localClient.NetcheckHistory(ctx, netcheckArgs.historyHours)
+	if err != nil {
+		return err
+	}
+	var j []byte
+	switch netcheckArgs.format {
+	case "":
+	case "json":
+		j, err = json.MarshalIndent(h, "", "\t")
+	case "json-line":
+		j, err = json.Marshal(h)
+	default:
+		return fmt.Errorf("unknown output format %q", netcheckArgs.format)
+	}
+	if err != nil {
+		return err
+	}
+	if j != nil {
+		j = append(j, '\n')
+		Stdout.Write(j)
+		return nil
+	}
+
+	if h.Hours == 0 && h.Samples == 0 {
+		printf("No netcheck history recorded. Use 'tailscale set --netcheck-history-hours=N' to record N hours of it.\n")
+		return nil
+	}
+	printf("Netcheck history since %v (%d samples):\n", h.Since.Local().Format(time.DateTime), h.Samples)
+	if h.Samples == 0 {
+		return nil
+	}
+	codes := map[int]string{}
+	regionCode := func(id int) string {
+		if id == 0 {
+			return "none"
+		}
+		return cmpx.Or(codes[id], fmt.Sprintf("derp%d", id))
+	}
+	printf("\t* DERP latency (min/median/max, trend):\n")
+	for _, r := range h.Regions {
+		codes[r.RegionID] = r.RegionCode
+		ms := func(d time.Duration) string { return d.Round(time.Millisecond / 10).String() }
+		printf("\t\t- %3s: %s/%s/%s %s (%d samples)\n", regionCode(r.RegionID), ms(r.Min), ms(r.Median), ms(r.Max), latencyTrend(r.EarlyMedian, r.LateMedian), r.Samples)
+	}
+	if len(h.HomeChanges) == 0 {
+		printf("\t* Home DERP region changes: none\n")
+		return nil
+	}
+	printf("\t* Home DERP region changes:\n")
+	for _, c := range h.HomeChanges {
+		printf("\t\t- %v: %s -> %s\n", c.Time.Local().Format(time.DateTime), regionCode(c.From), regionCode(c.To))
+	}
+	return nil
+}
+
+// latencyTrend describes the change from the median latency early in a
+// netcheck history to the one late in it.
+func latencyTrend(early, late time.Duration) string {
+	if early == 0 || late == 0 {
+		return "n/a"
+	}
+	pct := (late - early) * 100 / early
+	switch {
+	case pct > 0:
+		return fmt.Sprintf("up %d%%", pct)
+	case pct < 0:
+		return fmt.Sprintf("down %d%%", -pct)
+	}
+	return "steady"
+}
+
+// formatBandwidth formats a bandwidth given in bytes per second as
+// megabits per second.
+func formatBandwidth(bytesPerSec int64) string {
+	return fmt.Sprintf("%.1f Mbit/s", float64(bytesPerSec)*8/1e6)
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !r.AnyPortMappingChecked() {
 		return "not checked"
@@ -20,8 +20,11 @@
 	"github.com/mattn/go-colorable"
 	"github.com/mattn/go-isatty"
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/envknob"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tka"
+	"tailscale.com/tka/extsigner"
+	"tailscale.com/tka/verify"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
 )
@@ -40,6 +43,7 @@
 		nlDisableCmd,
 		nlDisablementKDFCmd,
 		nlLogCmd,
+		nlExportLogCmd,
 		nlLocalDisableCmd,
 		nlRevokeKeysCmd,
 	},
@@ -277,6 +281,12 @@ func runNetworkLockStatus(ctx context.Context, args []string) error {
 		}
 	}
 
+	if st.Enabled && st.PendingRecovery != nil {
+		fmt.Println()
+		fmt.Println("This node has a recovery in progress which has not been submitted:")
+		printNetworkLockRecovery(st.PendingRecovery)
+	}
+
 	return nil
 }
 
@@ -435,18 +445,37 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 	return nil
 }
 
+var nlSignArgs struct {
+	signer    string
+	signerKey string
+}
+
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
 	ShortUsage: "sign <node-key> [<rotation-key>] or sign <auth-key>",
 	ShortHelp:  "Signs a node or pre-approved auth key",
 	LongHelp: `Either:
   - signs a node key and transmits the signature to the coordination server, or
-  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock`,
+  - signs a pre-approved auth key, printing it in a form that can be used to bring up nodes under tailnet lock
+
+Node keys are signed with this node's tailnet lock key, unless --signer
+names an external signer holding a trusted signing key, such as an HSM,
+a cloud KMS or an SSH agent. The signer can also be configured with the
+TS_TAILNET_LOCK_SIGNER environment variable.`,
 	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		fs.StringVar(&nlSignArgs.signer, "signer", envknob.String("TS_TAILNET_LOCK_SIGNER"), `external signer holding the signing key: "ssh-agent" or "ssh-agent:<socket>" for an SSH agent, or "exec:<command>" for a helper command that reads a hex digest on stdin and prints the hex ed25519 signature`)
+		fs.StringVar(&nlSignArgs.signerKey, "signer-key", "", "tailnet lock key (tlpub:...) of the external signer to sign with; required for exec signers and SSH agents holding several ed25519 keys")
+		return fs
+	})(),
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
 	if len(args) > 0 && strings.HasPrefix(args[0], "tskey-auth-") {
+		if nlSignArgs.signer != "" {
+			return errors.New("auth keys can only be signed with this node's tailnet lock key; use --signer= to not use an external signer")
+		}
 		return runTskeyWrapCmd(ctx, args)
 	}
 
@@ -467,7 +496,12 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 		}
 	}
 
-	err := localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
+	var err error
+	if nlSignArgs.signer != "" {
+		err = signWithExternalSigner(ctx, nodeKey, []byte(rotationKey.Verifier()))
+	} else {
+		err = localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
+	}
 	// Provide a better help message for when someone clicks through the signing flow
 	// on the wrong device.
 	if err != nil && strings.Contains(err.Error(), "this node is not trusted by network lock") {
@@ -479,6 +513,28 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 	return err
 }
 
+// signWithExternalSigner signs nodeKey with the key held by the external
+// signer given by --signer and submits the signature to the coordination
+// server.
+func signWithExternalSigner(ctx context.Context, nodeKey key.NodePublic, rotationPublic []byte) error {
+	var pub key.NLPublic
+	if nlSignArgs.signerKey != "" {
+		if err := pub.UnmarshalText([]byte(nlSignArgs.signerKey)); err != nil {
+			return fmt.Errorf("decoding --signer-key: %w", err)
+		}
+	}
+	k, err := extsigner.Open(nlSignArgs.signer, pub)
+	if err != nil {
+		return err
+	}
+	defer k.Close()
+	sig, err := tka.SignNodeKey(nodeKey, rotationPublic, tka.ExternalSigner{Key: k})
+	if err != nil {
+		return err
+	}
+	return localClient.NetworkLockSubmitSignature(ctx, sig.Serialize())
+}
+
 var nlDisableCmd = &ffcli.Command{
 	Name:       "disable",
 	ShortUsage: "disable <disablement-secret>",
@@ -661,6 +717,67 @@ func runNetworkLockLog(ctx context.Context, args []string) error {
 	return nil
 }
 
+var nlExportLogArgs struct {
+	out string
+}
+
+var nlExportLogCmd = &ffcli.Command{
+	Name:       "export-log",
+	ShortUsage: "export-log [--out FILE]",
+	ShortHelp:  "Export the tailnet lock history for offline verification",
+	LongHelp: strings.TrimSpace(`
+The 'tailscale lock export-log' command writes the full chain of changes
+applied to tailnet lock, as known to this node, in a portable JSON format.
+
+The export can be checked independently of any Tailscale client with the
+tka-verify command (go run tailscale.com/cmd/tka-verify), which replays
+and verifies the signature of every change.
+`),
+	Exec: runNetworkLockExportLog,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock export-log")
+		fs.StringVar(&nlExportLogArgs.out, "out", "", "file to write the export to; default is stdout")
+		return fs
+	})(),
+}
+
+// nlExportLogMaxEntries bounds the number of updates exported, to avoid
+// looping forever on a corrupt chain.
+const nlExportLogMaxEntries = 1 << 20
+
+func runNetworkLockExportLog(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	updates, err := localClient.NetworkLockLog(ctx, nlExportLogMaxEntries)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if len(updates) == 0 {
+		return errors.New("tailnet lock has no history to export")
+	}
+
+	// NetworkLockLog lists updates newest first; the export is oldest first.
+	l := verify.Log{
+		Version: verify.LogVersion,
+		Head:    tka.AUMHash(updates[0].Hash),
+		AUMs:    make([]tkatype.MarshaledAUM, len(updates)),
+	}
+	for i, u := range updates {
+		l.AUMs[len(updates)-1-i] = u.Raw
+	}
+	j, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	if nlExportLogArgs.out == "" {
+		_, err = Stdout.Write(j)
+		return err
+	}
+	return os.WriteFile(nlExportLogArgs.out, j, 0644)
+}
+
 func runTskeyWrapCmd(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("usage: lock tskey-wrap <tailscale pre-auth key>")
@@ -717,12 +834,13 @@ func wrapAuthKey(ctx context.Context, keyStr string, status *ipnstate.Status) er
 var nlRevokeKeysArgs struct {
 	cosign   bool
 	finish   bool
+	inspect  bool
 	forkFrom string
 }
 
 var nlRevokeKeysCmd = &ffcli.Command{
 	Name:       "revoke-keys",
-	ShortUsage: "revoke-keys <tailnet-lock-key>...\n  revoke-keys [--cosign] [--finish] <recovery-blob>",
+	ShortUsage: "revoke-keys <tailnet-lock-key>...\n  revoke-keys [--cosign] [--finish] <recovery-blob>\n  revoke-keys --inspect [<recovery-blob>]",
 	ShortHelp:  "Revoke compromised tailnet-lock keys",
 	LongHelp: `Retroactively revoke the specified tailnet lock keys (tlpub:abc).
 
@@ -735,18 +853,30 @@ func wrapAuthKey(ctx context.Context, keyStr string, status *ipnstate.Status) er
 2. Re-run the ` + "`--cosign`" + ` command output by ` + "`revoke-keys`" + ` on other signing nodes. Use the
    most recent command output on the next signing node in sequence.
 3. Once the number of ` + "`--cosign`" + `s is greater than the number of keys being revoked,
-   run the command one final time with ` + "`--finish`" + ` instead of ` + "`--cosign`" + `.`,
+   run the command one final time with ` + "`--finish`" + ` instead of ` + "`--cosign`" + `.
+
+At any point, ` + "`--inspect`" + ` shows which keys a recovery blob revokes and which signatures
+it still needs. Without a blob, it shows the recovery most recently started or co-signed
+on this device.`,
 	Exec: runNetworkLockRevokeKeys,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("lock revoke-keys")
 		fs.BoolVar(&nlRevokeKeysArgs.cosign, "cosign", false, "continue generating the recovery using the tailnet lock key on this device and the provided recovery blob")
 		fs.BoolVar(&nlRevokeKeysArgs.finish, "finish", false, "finish the recovery process by transmitting the revocation")
+		fs.BoolVar(&nlRevokeKeysArgs.inspect, "inspect", false, "show the keys revoked and the signatures collected by the recovery blob, without changing it")
 		fs.StringVar(&nlRevokeKeysArgs.forkFrom, "fork-from", "", "parent AUM hash to rewrite from (advanced users only)")
 		return fs
 	})(),
 }
 
 func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
+	if nlRevokeKeysArgs.inspect {
+		if nlRevokeKeysArgs.cosign || nlRevokeKeysArgs.finish {
+			return errors.New("--inspect cannot be combined with --cosign or --finish")
+		}
+		return runNetworkLockInspectRecovery(ctx, args)
+	}
+
 	// First step in the process
 	if !nlRevokeKeysArgs.cosign && !nlRevokeKeysArgs.finish {
 		removeKeys, _, err := parseNLArgs(args, true, false)
@@ -783,20 +913,21 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 			return fmt.Errorf("generation of recovery AUM failed: %w", err)
 		}
 
-		fmt.Printf(`Run the following command on another machine with a trusted tailnet lock key:
-	%s lock recover-compromised-key --cosign %X
+		printNetworkLockRecoveryProgress(ctx, aumBytes)
+		fmt.Printf(`
+Run the following command on another machine with a trusted tailnet lock key:
+	%s lock revoke-keys --cosign %X
 `, os.Args[0], aumBytes)
 		return nil
 	}
 
 	// If we got this far, we need to co-sign the AUM and/or transmit it for distribution.
-	b, err := hex.DecodeString(args[0])
-	if err != nil {
-		return fmt.Errorf("parsing hex: %v", err)
+	if len(args) != 1 {
+		return errors.New("expected a single recovery blob")
 	}
-	var recoveryAUM tka.AUM
-	if err := recoveryAUM.Unserialize(b); err != nil {
-		return fmt.Errorf("decoding recovery AUM: %v", err)
+	recoveryAUM, err := parseRecoveryBlob(args[0])
+	if err != nil {
+		return err
 	}
 
 	if nlRevokeKeysArgs.cosign {
@@ -805,13 +936,15 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 			return fmt.Errorf("co-signing recovery AUM failed: %w", err)
 		}
 
-		fmt.Printf(`Co-signing completed successfully.
-
+		fmt.Println("Co-signing completed successfully.")
+		fmt.Println()
+		printNetworkLockRecoveryProgress(ctx, aumBytes)
+		fmt.Printf(`
 To accumulate an additional signature, run the following command on another machine with a trusted tailnet lock key:
-	%s lock recover-compromised-key --cosign %X
+	%s lock revoke-keys --cosign %X
 
 Alternatively if you are done with co-signing, complete recovery by running the following command:
-	%s lock recover-compromised-key --finish %X
+	%s lock revoke-keys --finish %X
 `, os.Args[0], aumBytes, os.Args[0], aumBytes)
 	}
 
@@ -824,3 +957,84 @@ func runNetworkLockRevokeKeys(ctx context.Context, args []string) error {
 
 	return nil
 }
+
+// parseRecoveryBlob decodes a hex-encoded recovery AUM, as printed by
+// the revoke-keys command.
+func parseRecoveryBlob(blob string) (tka.AUM, error) {
+	b, err := hex.DecodeString(blob)
+	if err != nil {
+		return tka.AUM{}, fmt.Errorf("parsing hex: %v", err)
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(b); err != nil {
+		return tka.AUM{}, fmt.Errorf("decoding recovery AUM: %v", err)
+	}
+	return aum, nil
+}
+
+func runNetworkLockInspectRecovery(ctx context.Context, args []string) error {
+	var rec *ipnstate.NetworkLockRecovery
+	switch len(args) {
+	case 0:
+		st, err := localClient.NetworkLockStatus(ctx)
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		if st.PendingRecovery == nil {
+			return errors.New("no recovery is in progress on this device; pass a recovery blob to inspect it")
+		}
+		rec = st.PendingRecovery
+	case 1:
+		aum, err := parseRecoveryBlob(args[0])
+		if err != nil {
+			return err
+		}
+		rec, err = localClient.NetworkLockInspectRecoveryAUM(ctx, aum)
+		if err != nil {
+			return fmt.Errorf("inspecting recovery AUM: %w", err)
+		}
+	default:
+		return errors.New("expected at most one recovery blob")
+	}
+
+	printNetworkLockRecovery(rec)
+	if len(args) == 0 {
+		fmt.Printf("\nRecovery blob:\n\t%X\n", rec.Raw)
+	}
+	return nil
+}
+
+// printNetworkLockRecoveryProgress prints the signing progress of the
+// serialized recovery AUM aumBytes. Failures are not fatal, as the
+// recovery AUM itself has already been produced.
+func printNetworkLockRecoveryProgress(ctx context.Context, aumBytes []byte) {
+	var aum tka.AUM
+	if err := aum.Unserialize(aumBytes); err != nil {
+		return
+	}
+	rec, err := localClient.NetworkLockInspectRecoveryAUM(ctx, aum)
+	if err != nil {
+		return
+	}
+	printNetworkLockRecovery(rec)
+}
+
+func printNetworkLockRecovery(rec *ipnstate.NetworkLockRecovery) {
+	fmt.Println("Keys being revoked:")
+	for _, k := range rec.RevokedKeys {
+		fmt.Printf("\t%s\n", k.CLIString())
+	}
+	fmt.Println("Signed by:")
+	for _, k := range rec.Signers {
+		fmt.Printf("\t%s\n", k.CLIString())
+	}
+	if !rec.SignedBySelf {
+		fmt.Println("This device has not signed this recovery.")
+	}
+	fmt.Printf("Signature weight: %d of %d required", rec.Weight, rec.RequiredWeight)
+	if rec.Weight >= rec.RequiredWeight {
+		fmt.Println(" (ready to --finish)")
+	} else {
+		fmt.Println()
+	}
+}
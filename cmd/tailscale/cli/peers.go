@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var peersCmd = &ffcli.Command{
+	Name:       "peers",
+	ShortUsage: "peers <subcommand> [flags]",
+	ShortHelp:  "Manage which peers this device shows",
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "prune",
+			ShortUsage: "peers prune [--preview] <days|off>",
+			ShortHelp:  "Hide peers not seen for a number of days",
+			LongHelp: strings.TrimSpace(`
+"tailscale peers prune" hides peers that are offline and haven't been seen
+for the given number of days from "tailscale status", MagicDNS and the web
+client on this device, to keep the device list of a large tailnet usable.
+The current exit node is never hidden.
+
+Hidden peers stay in the tailnet and can still be reached by IP address.
+They show up again as soon as they come back online. Use "off" to stop
+hiding peers.
+`),
+			Exec: runPeersPrune,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("prune")
+				fs.BoolVar(&peersPruneArgs.preview, "preview", false, "only list the peers that would be hidden, without changing anything")
+				return fs
+			})(),
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("peers subcommand required; run 'tailscale peers -h' for details")
+	},
+}
+
+var peersPruneArgs struct {
+	preview bool
+}
+
+func runPeersPrune(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale peers prune [--preview] <days|off>")
+	}
+	days, err := parsePruneDays(args[0])
+	if err != nil {
+		return err
+	}
+	if days == 0 {
+		if peersPruneArgs.preview {
+			return errors.New("nothing to preview; --preview requires a number of days")
+		}
+		_, err := localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+			HideStalePeersDaysSet: true,
+		})
+		if err != nil {
+			return fixTailscaledConnectError(err)
+		}
+		outln("No longer hiding stale peers.")
+		return nil
+	}
+
+	stale, err := localClient.StalePeers(ctx, days)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if peersPruneArgs.preview {
+		if len(stale) == 0 {
+			printf("No peers have been offline for more than %d days.\n", days)
+			return nil
+		}
+		printf("%d peers not seen for more than %d days would be hidden:\n\n", len(stale), days)
+		printStalePeers(stale)
+		return nil
+	}
+	_, err = localClient.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:                 ipn.Prefs{HideStalePeersDays: days},
+		HideStalePeersDaysSet: true,
+	})
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	printf("Hiding peers not seen for more than %d days (%d currently).\n", days, len(stale))
+	return nil
+}
+
+// parsePruneDays parses the days argument of "tailscale peers prune",
+// returning 0 for "off".
+func parsePruneDays(s string) (int, error) {
+	if s == "off" {
+		return 0, nil
+	}
+	days, err := strconv.Atoi(s)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid number of days %q; want a number or \"off\"", s)
+	}
+	return days, nil
+}
+
+func printStalePeers(peers []*ipnstate.PeerStatus) {
+	tw := tabwriter.NewWriter(Stdout, 10, 5, 5, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintf(tw, " %s\t%s\t%s\t%s\n", "IP", "HOSTNAME", "OS", "LAST SEEN")
+	fmt.Fprintf(tw, " %s\t%s\t%s\t%s\n", "--", "--------", "--", "---------")
+	for _, ps := range peers {
+		name := strings.TrimSuffix(ps.DNSName, ".")
+		if name == "" {
+			name = ps.HostName
+		}
+		days := int(time.Since(ps.LastSeen) / (24 * time.Hour))
+		fmt.Fprintf(tw, " %s\t%s\t%s\t%s\n", firstIPString(ps.TailscaleIPs), name, ps.OS, fmt.Sprintf("%d days ago", days))
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+)
+
+var relayCmd = &ffcli.Command{
+	Name:       "relay",
+	ShortUsage: "relay <subcommand> [flags]",
+	ShortHelp:  "Show traffic forwarded for peers as a subnet router or exit node",
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "stats",
+			ShortUsage: "relay stats [flags]",
+			ShortHelp:  "Show daily traffic forwarded on behalf of each peer",
+			LongHelp: strings.TrimSpace(`
+'tailscale relay stats' shows how many bytes this node has forwarded on
+behalf of each peer while acting as a subnet router or exit node, per day
+(UTC), most recent day first.
+
+Use 'tailscale set --relay-daily-limit-mb' to limit how much traffic each
+peer may use per day.
+`),
+			Exec: runRelayStats,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("stats")
+				fs.IntVar(&relayArgs.days, "days", 7, "number of days to show, or 0 for all retained days")
+				fs.BoolVar(&relayArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+	},
+	Exec: func(context.Context, []string) error {
+		return errors.New("relay subcommand required; run 'tailscale relay -h' for details")
+	},
+}
+
+var relayArgs struct {
+	days int
+	json bool
+}
+
+func runRelayStats(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale relay stats'")
+	}
+	if relayArgs.days < 0 {
+		return errors.New("--days must not be negative")
+	}
+	days, err := localClient.RelayStats(ctx, relayArgs.days)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if relayArgs.json {
+		j, err := json.MarshalIndent(days, "", "\t")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+		return nil
+	}
+	if len(days) == 0 {
+		outln("No traffic has been forwarded for peers.")
+		return nil
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	names := peerNamesByIP(st)
+
+	w := tabwriter.NewWriter(os.Stdout, 10, 5, 5, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", "DATE", "PEER", "IP", "FROM PEER", "TO PEER")
+	for _, d := range days {
+		for _, p := range d.Peers {
+			name := names[p.Peer]
+			if name == "" {
+				name = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t\n", d.Date, name, p.Peer, formatByteCount(p.RxBytes), formatByteCount(p.TxBytes))
+		}
+	}
+	return nil
+}
+
+// peerNamesByIP returns the short names of the peers in st, keyed by their
+// Tailscale IPs.
+func peerNamesByIP(st *ipnstate.Status) map[netip.Addr]string {
+	names := map[netip.Addr]string{}
+	for _, ps := range st.Peer {
+		name := dnsOrQuoteHostname(st, ps)
+		for _, ip := range ps.TailscaleIPs {
+			names[ip] = name
+		}
+	}
+	return names
+}
+
+// formatByteCount returns n formatted with a binary unit suffix.
+func formatByteCount(n uint64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/util/jsonschema"
+)
+
+var schemaCmd = &ffcli.Command{
+	Name:       "schema",
+	ShortUsage: "schema [<name>]",
+	ShortHelp:  "Print the JSON Schema of a JSON format",
+	LongHelp: strings.TrimSpace(`
+"tailscale schema" prints the JSON Schema of one of the JSON formats that
+tailscale produces or consumes, generated from the Go types that define it,
+for external tools to validate and generate code against.
+
+Without a name, it lists the formats with a schema.
+`),
+	Exec: runSchema,
+}
+
+// jsonSchemas are the JSON formats "tailscale schema" describes, by name.
+var jsonSchemas = []struct {
+	name string
+	desc string
+	v    any // value of the Go type encoded in the format
+}{
+	{"status", "output of 'tailscale status --json'", (*ipnstate.Status)(nil)},
+	{"netcheck", "output of 'tailscale netcheck --format=json'", (*netcheck.Report)(nil)},
+	{"serve-config", "serve and funnel configuration ('tailscale serve set-raw')", (*ipn.ServeConfig)(nil)},
+	{"config-file", "tailscaled --config file", (*ipn.ConfigVAlpha)(nil)},
+}
+
+func runSchema(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		tw := tabwriter.NewWriter(Stdout, 0, 0, 2, ' ', 0)
+		for _, s := range jsonSchemas {
+			fmt.Fprintf(tw, "%s\t%s\n", s.name, s.desc)
+		}
+		return tw.Flush()
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many non-flag arguments: %q", args)
+	}
+	for _, s := range jsonSchemas {
+		if s.name != args[0] {
+			continue
+		}
+		sch := jsonschema.For(s.v)
+		sch.Title = s.desc
+		j, err := json.MarshalIndent(sch, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	return fmt.Errorf("unknown schema %q; see 'tailscale schema' for the list", args[0])
+}
@@ -22,6 +22,7 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -56,6 +57,7 @@ func newServeCommand(e *serveEnv) *ffcli.Command {
 			"serve tls-terminated-tcp:<port> tcp://localhost:<local-port> [off]",
 			"serve status [--json]",
 			"serve reset",
+			"serve apply --config=<file> [--dry-run]",
 		}, "\n  "),
 		LongHelp: strings.TrimSpace(`
 *** BETA; all of this is subject to change ***
@@ -116,6 +118,7 @@ func newServeCommand(e *serveEnv) *ffcli.Command {
 				FlagSet:   e.newFlags("serve-reset", nil),
 				UsageFunc: usageFunc,
 			},
+			e.newServeApplyCommand("serve"),
 		},
 	}
 }
@@ -161,6 +164,10 @@ type serveEnv struct {
 	// v1 flags
 	json bool // output JSON (status only for now)
 
+	// apply flags
+	configFile string // path of the serve config file to apply
+	dryRun     bool   // only check the config file
+
 	// v2 specific flags
 	bg               bool      // background mode
 	setPath          string    // serve path
@@ -170,6 +177,20 @@ type serveEnv struct {
 	tlsTerminatedTcp string    // a TLS terminated TCP port
 	subcmd           serveMode // subcommand
 
+	// v2 path serving flags
+	noDirList   bool          // don't list directories without an index.html
+	cacheMaxAge time.Duration // Cache-Control max-age of served files
+
+	// v2 web access flags
+	allowFrom       string // comma-separated users and tags allowed access
+	identityHeaders string // comma-separated identity headers to add, or "none"
+
+	// v2 proxy flags
+	proxyTimeout     time.Duration // how long to wait for backend response headers
+	proxyIdleTimeout time.Duration // how long to keep idle backend connections
+	proxyKeepAlive   time.Duration // TCP keep-alive interval to the backend
+	proxyBufferSize  int           // size of body copy buffers, in bytes
+
 	lc localServeClient // localClient interface, specific to serve
 
 	// optional stuff for tests:
@@ -405,7 +426,8 @@ func (e *serveEnv) handleWebServe(ctx context.Context, srvPort uint16, useTLS bo
 func isProxyTarget(source string) bool {
 	if strings.HasPrefix(source, "http://") ||
 		strings.HasPrefix(source, "https://") ||
-		strings.HasPrefix(source, "https+insecure://") {
+		strings.HasPrefix(source, "https+insecure://") ||
+		strings.HasPrefix(source, "h2c://") {
 		return true
 	}
 	// support "localhost:3000", for example
@@ -512,10 +534,10 @@ func expandProxyTarget(source string) (string, error) {
 		return "", fmt.Errorf("parsing url: %w", err)
 	}
 	switch u.Scheme {
-	case "http", "https", "https+insecure":
+	case "http", "https", "https+insecure", "h2c":
 		// ok
 	default:
-		return "", fmt.Errorf("must be a URL starting with http://, https://, or https+insecure://")
+		return "", fmt.Errorf("must be a URL starting with http://, https://, https+insecure://, or h2c://")
 	}
 
 	port, err := strconv.ParseUint(u.Port(), 10, 16)
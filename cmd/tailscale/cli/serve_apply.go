@@ -0,0 +1,321 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"github.com/tailscale/hujson"
+	"tailscale.com/ipn"
+	"tailscale.com/util/multierr"
+)
+
+// newServeApplyCommand returns the "apply" subcommand of the serve command
+// named name.
+func (e *serveEnv) newServeApplyCommand(name string) *ffcli.Command {
+	return &ffcli.Command{
+		Name:       "apply",
+		Exec:       e.runServeApply,
+		ShortUsage: name + " apply --config=<file> [--dry-run]",
+		ShortHelp:  "replace the serve/funnel config with one from a file",
+		LongHelp: strings.TrimSpace(`
+'tailscale ` + name + ` apply' replaces the whole serve config (every TCP
+port, web handler and Funnel setting) with the one in a HuJSON file, in the
+format printed by 'tailscale serve status --json'. Occurrences of
+${TS_CERT_DOMAIN} in the file are replaced with this node's DNS name.
+
+The whole config is checked before any of it is applied, and a config that
+fails to apply is rolled back, so the node is never left half configured.
+Foreground sessions ('tailscale serve' without --bg) are kept.
+`),
+		FlagSet: e.newFlags("serve-apply", func(fs *flag.FlagSet) {
+			fs.StringVar(&e.configFile, "config", "", "path to the HuJSON serve config file to apply")
+			fs.BoolVar(&e.dryRun, "dry-run", false, "check the config file without applying it")
+		}),
+		UsageFunc: usageFunc,
+	}
+}
+
+// runServeApply is the entry point for the "serve apply" subcommand. It
+// applies the serve config in the --config file in one operation, rolling
+// back to the previous config if that fails.
+//
+// Examples:
+//   - tailscale serve apply --config=serve.hujson
+//   - tailscale serve apply --config=serve.hujson --dry-run
+func (e *serveEnv) runServeApply(ctx context.Context, args []string) error {
+	if len(args) != 0 || e.configFile == "" {
+		return flag.ErrHelp
+	}
+	dnsName, err := e.getSelfDNSName(ctx)
+	if err != nil {
+		return err
+	}
+	sc, err := readServeConfigFile(e.configFile, dnsName)
+	if err != nil {
+		return err
+	}
+	if err := validateServeConfig(sc, dnsName); err != nil {
+		return fmt.Errorf("invalid serve config %s:\n%w", e.configFile, err)
+	}
+	if e.dryRun {
+		printf("Serve config %s is valid.\n", e.configFile)
+		return nil
+	}
+	if len(sc.AllowFunnel) > 0 {
+		st, err := e.getLocalClientStatusWithoutPeers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, hp := range sortedHostPorts(sc.AllowFunnel) {
+			port, _ := hp.Port() // checked by validateServeConfig
+			if err := e.verifyFunnelEnabled(ctx, st, port); err != nil {
+				return err
+			}
+		}
+	}
+
+	prev, err := e.lc.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if prev != nil {
+		// Foreground sessions belong to running "tailscale serve"
+		// commands, not to the file.
+		sc.Foreground = prev.Foreground
+	}
+	if reflect.DeepEqual(prev, sc) {
+		printf("Serve config already up to date.\n")
+		return nil
+	}
+	if err := e.applyServeConfig(ctx, sc); err != nil {
+		if prev == nil {
+			prev = new(ipn.ServeConfig)
+		}
+		if rerr := e.lc.SetServeConfig(ctx, prev); rerr != nil {
+			return fmt.Errorf("applying serve config: %w; rolling back to the previous config also failed: %v", err, rerr)
+		}
+		return fmt.Errorf("applying serve config: %w; rolled back to the previous config", err)
+	}
+	printf("Applied serve config %s.\n", e.configFile)
+	return nil
+}
+
+// applyServeConfig sets sc as the serve config and checks that tailscaled
+// kept all of it.
+func (e *serveEnv) applyServeConfig(ctx context.Context, sc *ipn.ServeConfig) error {
+	if err := e.lc.SetServeConfig(ctx, sc); err != nil {
+		return err
+	}
+	got, err := e.lc.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(got, sc) {
+		return errors.New("tailscaled didn't keep the config as given")
+	}
+	return nil
+}
+
+// readServeConfigFile reads the HuJSON serve config at path, replacing
+// ${TS_CERT_DOMAIN} with dnsName.
+func readServeConfigFile(path, dnsName string) (*ipn.ServeConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	b = bytes.ReplaceAll(b, []byte("${TS_CERT_DOMAIN}"), []byte(dnsName))
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	sc := new(ipn.ServeConfig)
+	if err := d.Decode(sc); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return sc, nil
+}
+
+// validateServeConfig checks the whole of sc, a serve config for the node
+// named dnsName, returning an error listing all of its problems, if any.
+func validateServeConfig(sc *ipn.ServeConfig, dnsName string) error {
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+	if len(sc.Foreground) > 0 {
+		bad("Foreground can't be set in a config file")
+	}
+
+	ports := make([]uint16, 0, len(sc.TCP))
+	for p := range sc.TCP {
+		ports = append(ports, p)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+	for _, p := range ports {
+		h := sc.TCP[p]
+		if p == 0 {
+			bad("TCP port 0 is invalid")
+			continue
+		}
+		if h == nil {
+			bad("TCP port %d: no handler", p)
+			continue
+		}
+		modes := 0
+		for _, set := range []bool{h.HTTPS, h.HTTP, h.TCPForward != ""} {
+			if set {
+				modes++
+			}
+		}
+		if modes != 1 {
+			bad("TCP port %d: exactly one of HTTPS, HTTP and TCPForward must be set", p)
+			continue
+		}
+		if h.TerminateTLS != "" && h.TCPForward == "" {
+			bad("TCP port %d: TerminateTLS requires TCPForward", p)
+		}
+		if h.TCPForward != "" {
+			if _, port, err := net.SplitHostPort(h.TCPForward); err != nil || !allNumeric(port) {
+				bad("TCP port %d: invalid TCPForward %q; want host:port", p, h.TCPForward)
+			}
+		}
+		if (h.HTTPS || h.HTTP) && !hasWebOnPort(sc, p) {
+			bad("TCP port %d: serves HTTP but has no Web handlers", p)
+		}
+	}
+
+	for _, hp := range sortedHostPorts(sc.Web) {
+		ws := sc.Web[hp]
+		host, portStr, err := net.SplitHostPort(string(hp))
+		port, perr := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || perr != nil || port == 0 {
+			bad("Web %q: want %s:<port>", hp, dnsName)
+			continue
+		}
+		if dnsName != "" && host != dnsName {
+			bad("Web %q: host must be this node's name, %s (or ${TS_CERT_DOMAIN})", hp, dnsName)
+		}
+		if th := sc.TCP[uint16(port)]; th == nil || !(th.HTTPS || th.HTTP) {
+			bad("Web %q: TCP port %d must be set to HTTPS or HTTP", hp, port)
+		}
+		if ws == nil || len(ws.Handlers) == 0 {
+			bad("Web %q: no handlers", hp)
+			continue
+		}
+		mounts := make([]string, 0, len(ws.Handlers))
+		for m := range ws.Handlers {
+			mounts = append(mounts, m)
+		}
+		sort.Strings(mounts)
+		for _, m := range mounts {
+			if err := validateHTTPHandler(m, ws.Handlers[m]); err != nil {
+				bad("Web %q mount %q: %w", hp, m, err)
+			}
+		}
+	}
+
+	for _, hp := range sortedHostPorts(sc.AllowFunnel) {
+		if !sc.AllowFunnel[hp] {
+			continue
+		}
+		if _, ok := sc.Web[hp]; !ok {
+			bad("AllowFunnel %q: no Web config to expose", hp)
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// validateHTTPHandler checks the handler h, mounted at mount.
+func validateHTTPHandler(mount string, h *ipn.HTTPHandler) error {
+	if c, err := cleanMountPoint(mount); err != nil || c != mount {
+		return errors.New("mount point must be a clean absolute path")
+	}
+	if h == nil {
+		return errors.New("no handler")
+	}
+	set := 0
+	for _, s := range []string{h.Path, h.Proxy, h.Text} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of Path, Proxy and Text must be set")
+	}
+	switch {
+	case h.Path != "":
+		if !filepath.IsAbs(h.Path) {
+			return fmt.Errorf("Path %q must be absolute", h.Path)
+		}
+		if _, err := os.Stat(h.Path); err != nil {
+			return err
+		}
+	case h.Proxy != "":
+		if _, err := expandProxyTarget(h.Proxy); err != nil {
+			return fmt.Errorf("Proxy %q: %w", h.Proxy, err)
+		}
+	}
+	for _, a := range h.AllowFrom {
+		if err := ipn.CheckServeAllowFrom(a); err != nil {
+			return err
+		}
+	}
+	if err := ipn.CheckServeIdentityHeaders(h.IdentityHeaders); err != nil {
+		return err
+	}
+	if h.CacheMaxAge < 0 || h.ProxyTimeout < 0 || h.ProxyIdleTimeout < 0 || h.ProxyBufferSize < 0 {
+		return errors.New("CacheMaxAge, ProxyTimeout, ProxyIdleTimeout and ProxyBufferSize can't be negative")
+	}
+	if h.Proxy == "" && (len(h.Fallbacks) > 0 || h.HealthCheck != "" || h.HealthCheckInterval != 0 || h.Maintenance != "") {
+		return errors.New("Fallbacks, HealthCheck, HealthCheckInterval and Maintenance only apply with Proxy")
+	}
+	for _, fb := range h.Fallbacks {
+		if _, err := expandProxyTarget(fb); err != nil {
+			return fmt.Errorf("Fallbacks %q: %w", fb, err)
+		}
+	}
+	if h.HealthCheck != "" && !strings.HasPrefix(h.HealthCheck, "/") {
+		return fmt.Errorf("HealthCheck %q must be an absolute path", h.HealthCheck)
+	}
+	if h.HealthCheckInterval < 0 {
+		return errors.New("HealthCheckInterval can't be negative")
+	}
+	return nil
+}
+
+// hasWebOnPort reports whether sc has Web handlers for port.
+func hasWebOnPort(sc *ipn.ServeConfig, port uint16) bool {
+	for hp := range sc.Web {
+		if p, err := hp.Port(); err == nil && p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedHostPorts returns the keys of m, sorted.
+func sortedHostPorts[V any](m map[ipn.HostPort]V) []ipn.HostPort {
+	ret := make([]ipn.HostPort, 0, len(m))
+	for hp := range m {
+		ret = append(ret, hp)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret
+}
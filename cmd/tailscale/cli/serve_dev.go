@@ -18,6 +18,7 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/tailscale"
@@ -38,7 +39,9 @@ type commandInfo struct {
 
 var serveHelpCommon = strings.TrimSpace(`
 <target> can be a port number (e.g., 3000), a partial URL (e.g., localhost:3000), or a
-full URL including a path (e.g., http://localhost:3000/foo, https+insecure://localhost:3000/foo).
+full URL including a path (e.g., http://localhost:3000/foo, https+insecure://localhost:3000/foo),
+a cleartext HTTP/2 URL for gRPC backends (e.g., h2c://localhost:50051),
+or the absolute path of a local file or directory to serve (e.g., /var/www).
 
 EXAMPLES
   - Mount a local web server at 127.0.0.1:3000 in the foreground:
@@ -47,6 +50,9 @@ type commandInfo struct {
   - Mount a local web server at 127.0.0.1:3000 in the background:
     $ tailscale %s --bg localhost:3000
 
+  - Serve the files in /var/www in the background, without listing directories:
+    $ tailscale %[1]s --bg --no-dir-list /var/www
+
 For more examples and use cases visit our docs site https://tailscale.com/kb/1247/funnel-serve-use-cases
 `)
 
@@ -99,6 +105,7 @@ func newServeDevCommand(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			fmt.Sprintf("%s <target>", info.Name),
 			fmt.Sprintf("%s status [--json]", info.Name),
 			fmt.Sprintf("%s reset", info.Name),
+			fmt.Sprintf("%s apply --config=<file> [--dry-run]", info.Name),
 		}, "\n  "),
 		LongHelp: info.LongHelp + fmt.Sprintf(strings.TrimSpace(serveHelpCommon), subcmd, subcmd),
 		Exec:     e.runServeCombined(subcmd),
@@ -110,7 +117,14 @@ func newServeDevCommand(e *serveEnv, subcmd serveMode) *ffcli.Command {
 			fs.StringVar(&e.http, "http", "", "HTTP listener")
 			fs.StringVar(&e.tcp, "tcp", "", "TCP listener")
 			fs.StringVar(&e.tlsTerminatedTcp, "tls-terminated-tcp", "", "TLS terminated TCP listener")
-
+			fs.BoolVar(&e.noDirList, "no-dir-list", false, "when serving a directory, don't list the contents of subdirectories without an index.html")
+			fs.StringVar(&e.allowFrom, "allow-from", "", "comma-separated login names (alice@example.com), domains (*@example.com) and tags (tag:server) to restrict access to; default is everyone who can reach the node")
+			fs.StringVar(&e.identityHeaders, "identity-headers", "", "when proxying, comma-separated Tailscale-User-* identity headers to add, or \"none\"; default is all")
+			fs.DurationVar(&e.cacheMaxAge, "cache-max-age", 0, "when serving a file or directory, how long clients may cache files (e.g. 1h); 0 disables the Cache-Control header")
+			fs.DurationVar(&e.proxyTimeout, "proxy-timeout", 0, "when proxying, how long to wait for the backend's response headers (e.g. 30s); default is no limit")
+			fs.DurationVar(&e.proxyIdleTimeout, "proxy-idle-timeout", 0, "when proxying, how long to keep idle connections to the backend for reuse; default 90s")
+			fs.DurationVar(&e.proxyKeepAlive, "proxy-keepalive", 0, "when proxying, the interval between TCP keep-alive probes to the backend; negative disables; default 15s")
+			fs.IntVar(&e.proxyBufferSize, "proxy-buffer-size", 0, "when proxying, the size in bytes of the buffers used to copy request and response bodies; default 32768")
 		}),
 		UsageFunc: usageFunc,
 		Subcommands: []*ffcli.Command{
@@ -130,6 +144,7 @@ func newServeDevCommand(e *serveEnv, subcmd serveMode) *ffcli.Command {
 				FlagSet:   e.newFlags("serve-reset", nil),
 				UsageFunc: usageFunc,
 			},
+			e.newServeApplyCommand(info.Name),
 		},
 	}
 }
@@ -430,11 +445,21 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			return err
 		}
 		h.Proxy = t
+		if e.proxyTimeout < 0 || e.proxyIdleTimeout < 0 || e.proxyBufferSize < 0 {
+			return errors.New("--proxy-timeout, --proxy-idle-timeout and --proxy-buffer-size must not be negative")
+		}
+		h.ProxyTimeout = int(e.proxyTimeout / time.Second)
+		h.ProxyIdleTimeout = int(e.proxyIdleTimeout / time.Second)
+		h.ProxyKeepAlive = int(e.proxyKeepAlive / time.Second)
+		h.ProxyBufferSize = e.proxyBufferSize
 	default: // assume path
 		if version.IsSandboxedMacOS() {
 			// don't allow path serving for now on macOS (2022-11-15)
 			return errors.New("path serving is not supported if sandboxed on macOS")
 		}
+		if e.cacheMaxAge < 0 {
+			return errors.New("--cache-max-age must not be negative")
+		}
 		if !filepath.IsAbs(target) {
 			return errors.New("path must be absolute")
 		}
@@ -451,6 +476,34 @@ func (e *serveEnv) applyWebServe(sc *ipn.ServeConfig, dnsName string, srvPort ui
 			mount += "/"
 		}
 		h.Path = target
+		h.NoDirList = e.noDirList
+		h.CacheMaxAge = int(e.cacheMaxAge / time.Second)
+	}
+	if h.Path == "" && (e.noDirList || e.cacheMaxAge != 0) {
+		return errors.New("--no-dir-list and --cache-max-age only apply when serving a file or directory")
+	}
+	if h.Proxy == "" && (e.proxyTimeout != 0 || e.proxyIdleTimeout != 0 || e.proxyKeepAlive != 0 || e.proxyBufferSize != 0) {
+		return errors.New("--proxy-timeout, --proxy-idle-timeout, --proxy-keepalive and --proxy-buffer-size only apply when proxying")
+	}
+	if e.allowFrom != "" {
+		for _, a := range strings.Split(e.allowFrom, ",") {
+			a = strings.TrimSpace(a)
+			if err := ipn.CheckServeAllowFrom(a); err != nil {
+				return fmt.Errorf("invalid --allow-from: %w", err)
+			}
+			h.AllowFrom = append(h.AllowFrom, a)
+		}
+	}
+	if e.identityHeaders != "" {
+		if h.Proxy == "" {
+			return errors.New("--identity-headers only applies when proxying")
+		}
+		for _, ih := range strings.Split(e.identityHeaders, ",") {
+			h.IdentityHeaders = append(h.IdentityHeaders, strings.TrimSpace(ih))
+		}
+		if err := ipn.CheckServeIdentityHeaders(h.IdentityHeaders); err != nil {
+			return fmt.Errorf("invalid --identity-headers: %w", err)
+		}
 	}
 
 	// TODO: validation needs to check nested foreground configs
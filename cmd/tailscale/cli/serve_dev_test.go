@@ -518,6 +518,80 @@ type step struct {
 		command: cmd("serve --https=443 off"),
 		want:    &ipn.ServeConfig{},
 	})
+	add(step{reset: true})
+	add(step{ // directory with listing and caching options
+		command: cmd("serve --https=443 --bg --no-dir-list --cache-max-age=1h " + filepath.Join(td, "subdir")),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Path: filepath.Join(td, "subdir/"), NoDirList: true, CacheMaxAge: 3600},
+				}},
+			},
+		},
+	})
+	add(step{ // path options with a proxy target
+		command: cmd("serve --https=443 --bg --no-dir-list localhost:3000"),
+		wantErr: anyErr(),
+	})
+	add(step{ // negative cache age
+		command: cmd("serve --https=443 --bg --cache-max-age=-1s " + filepath.Join(td, "subdir")),
+		wantErr: anyErr(),
+	})
+	add(step{reset: true})
+	add(step{ // access control and identity headers
+		command: cmd("serve --https=443 --bg --allow-from=alice@example.com,tag:ci --identity-headers=Tailscale-User-Login localhost:3000"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {
+						Proxy:           "http://127.0.0.1:3000",
+						AllowFrom:       []string{"alice@example.com", "tag:ci"},
+						IdentityHeaders: []string{"Tailscale-User-Login"},
+					},
+				}},
+			},
+		},
+	})
+	add(step{ // groups aren't supported
+		command: cmd("serve --https=443 --bg --allow-from=group:eng localhost:3000"),
+		wantErr: anyErr(),
+	})
+	add(step{ // identity headers with a non-proxy target
+		command: cmd("serve --https=443 --bg --identity-headers=none text:hi"),
+		wantErr: anyErr(),
+	})
+	add(step{ // unknown identity header
+		command: cmd("serve --https=443 --bg --identity-headers=X-Foo localhost:3000"),
+		wantErr: anyErr(),
+	})
+	add(step{reset: true})
+	add(step{ // proxy options and an h2c backend
+		command: cmd("serve --https=443 --bg --proxy-timeout=30s --proxy-idle-timeout=5m --proxy-keepalive=-1s --proxy-buffer-size=65536 h2c://localhost:50051"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {
+						Proxy:            "h2c://127.0.0.1:50051",
+						ProxyTimeout:     30,
+						ProxyIdleTimeout: 300,
+						ProxyKeepAlive:   -1,
+						ProxyBufferSize:  65536,
+					},
+				}},
+			},
+		},
+	})
+	add(step{ // proxy options with a non-proxy target
+		command: cmd("serve --https=443 --bg --proxy-timeout=30s text:hi"),
+		wantErr: anyErr(),
+	})
+	add(step{ // negative timeout
+		command: cmd("serve --https=443 --bg --proxy-timeout=-30s localhost:3000"),
+		wantErr: anyErr(),
+	})
 
 	// // combos
 	add(step{reset: true})
@@ -839,6 +839,120 @@ func TestVerifyFunnelEnabled(t *testing.T) {
 	}
 }
 
+func TestServeApply(t *testing.T) {
+	dir := t.TempDir()
+	writeConf := func(name, conf string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(conf), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	good := writeConf("good.hujson", `{
+		// Web on 443 and SSH on 2222.
+		"TCP": {
+			"443":  {"HTTPS": true},
+			"2222": {"TCPForward": "127.0.0.1:22"},
+		},
+		"Web": {
+			"${TS_CERT_DOMAIN}:443": {"Handlers": {
+				"/":    {"Proxy": "http://127.0.0.1:3000"},
+				"/hi/": {"Text": "hello"},
+			}},
+		},
+	}`)
+	bad := writeConf("bad.hujson", `{
+		"TCP": {
+			"443": {"HTTPS": true, "TCPForward": "127.0.0.1:22"},
+			"80":  {"HTTP": true},
+		},
+		"Web": {
+			"other.test.ts.net:443": {"Handlers": {
+				"foo":    {"Proxy": "http://example.com:3000"},
+				"/down/": {"Text": "hi", "Maintenance": "<h1>Back soon</h1>"},
+				"/fb/":   {"Proxy": "http://127.0.0.1:3000", "Fallbacks": ["http://127.0.0.1:3001"], "HealthCheck": "healthz"},
+			}},
+		},
+		"AllowFunnel": {"foo.test.ts.net:8443": true},
+	}`)
+	want := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			443:  {HTTPS: true},
+			2222: {TCPForward: "127.0.0.1:22"},
+		},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":    {Proxy: "http://127.0.0.1:3000"},
+				"/hi/": {Text: "hello"},
+			}},
+		},
+	}
+	prev := &ipn.ServeConfig{
+		TCP:        map[uint16]*ipn.TCPPortHandler{22: {TCPForward: "127.0.0.1:2222"}},
+		Foreground: map[string]*ipn.ServeConfig{"sess": {TCP: map[uint16]*ipn.TCPPortHandler{8080: {HTTP: true}}}},
+	}
+
+	run := func(lc *fakeLocalServeClient, args ...string) error {
+		var flagOut bytes.Buffer
+		e := &serveEnv{lc: lc, testFlagOut: &flagOut}
+		return newServeCommand(e).ParseAndRun(context.Background(), append([]string{"apply"}, args...))
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		lc := &fakeLocalServeClient{config: prev.Clone()}
+		if err := run(lc, "--config="+good); err != nil {
+			t.Fatal(err)
+		}
+		want := want.Clone()
+		want.Foreground = prev.Foreground
+		if !reflect.DeepEqual(lc.config, want) {
+			t.Errorf("got %v; want %v", logger.AsJSON(lc.config), logger.AsJSON(want))
+		}
+	})
+	t.Run("dry-run", func(t *testing.T) {
+		lc := &fakeLocalServeClient{config: prev.Clone()}
+		if err := run(lc, "--config="+good, "--dry-run"); err != nil {
+			t.Fatal(err)
+		}
+		if lc.setCount != 0 {
+			t.Errorf("dry run set config")
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		lc := &fakeLocalServeClient{config: prev.Clone()}
+		err := run(lc, "--config="+bad)
+		if err == nil {
+			t.Fatal("got success; want error")
+		}
+		for _, want := range []string{
+			"TCP port 80: serves HTTP but has no Web handlers",
+			"TCP port 443: exactly one of HTTPS, HTTP and TCPForward must be set",
+			`Web "other.test.ts.net:443": host must be this node's name`,
+			`mount "foo": mount point must be a clean absolute path`,
+			`mount "/down/": Fallbacks, HealthCheck, HealthCheckInterval and Maintenance only apply with Proxy`,
+			`mount "/fb/": HealthCheck "healthz" must be an absolute path`,
+			`AllowFunnel "foo.test.ts.net:8443": no Web config to expose`,
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q doesn't contain %q", err, want)
+			}
+		}
+		if lc.setCount != 0 {
+			t.Errorf("invalid config partially applied")
+		}
+	})
+	t.Run("rollback", func(t *testing.T) {
+		lc := &fakeLocalServeClient{config: prev.Clone(), setErr: errors.New("boom")}
+		err := run(lc, "--config="+good)
+		if err == nil || !strings.Contains(err.Error(), "rolled back") {
+			t.Fatalf("got error %v; want rollback", err)
+		}
+		if !reflect.DeepEqual(lc.config, prev) {
+			t.Errorf("after rollback got %v; want %v", logger.AsJSON(lc.config), logger.AsJSON(prev))
+		}
+	})
+}
+
 // fakeLocalServeClient is a fake tailscale.LocalClient for tests.
 // It's not a full implementation, just enough to test the serve command.
 //
@@ -847,6 +961,7 @@ func TestVerifyFunnelEnabled(t *testing.T) {
 type fakeLocalServeClient struct {
 	config               *ipn.ServeConfig
 	setCount             int                       // counts calls to SetServeConfig
+	setErr               error                     // if non-nil, returned by the next SetServeConfig call
 	queryFeatureResponse *mockQueryFeatureResponse // mock response to QueryFeature calls
 }
 
@@ -873,6 +988,10 @@ func (lc *fakeLocalServeClient) GetServeConfig(ctx context.Context) (*ipn.ServeC
 
 func (lc *fakeLocalServeClient) SetServeConfig(ctx context.Context, config *ipn.ServeConfig) error {
 	lc.setCount += 1
+	if err := lc.setErr; err != nil {
+		lc.setErr = nil
+		return err
+	}
 	lc.config = config.Clone()
 	return nil
 }
@@ -9,6 +9,12 @@
 	"flag"
 	"fmt"
 	"net/netip"
+	"net/url"
+	"os/user"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/clientupdate"
@@ -17,6 +23,7 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/safesocket"
 	"tailscale.com/types/views"
+	"tailscale.com/util/dnsname"
 )
 
 var setCmd = &ffcli.Command{
@@ -49,6 +56,34 @@ type setArgsT struct {
 	forceDaemon            bool
 	updateCheck            bool
 	updateApply            bool
+	qos                    bool
+	dnsRoutes              string
+	relayDailyLimitMB      int
+	metricsPort            int
+	metricsOnTailnet       bool
+	otherVPN               string
+	proxy                  string
+	proxyBypass            string
+	proxyPACURL            string
+	proxyAutoDetect        bool
+	proxyAuth              string
+	proxyUser              string
+	fixIPForwarding        bool
+	nat64                  bool
+	viaSiteID              uint
+	advertise4via6         string
+	updateWindow           string
+	updateTrain            string
+	updateDelayDays        int
+	advertiseEndpoints     string
+	exitNodeExclude        string
+	bandwidthQuotaMB       int
+	bandwidthResetDay      int
+	bandwidthWarnPercent   int
+	netcheckHistoryHours   int
+	keepHot                string
+	keepHotInterval        time.Duration
+	allowedPeers           string
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -59,6 +94,7 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
 	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
+	setf.StringVar(&setArgs.exitNodeExclude, "exit-node-exclude", "", "destinations to reach directly rather than via the exit node, such as backup servers (comma-separated IPs or CIDRs, e.g. \"192.0.2.0/24\"), and on Linux, local users whose traffic bypasses it (as user:<name or uid>), or empty string to exclude nothing")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	setf.StringVar(&setArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
@@ -66,10 +102,38 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 	setf.BoolVar(&setArgs.advertiseDefaultRoute, "advertise-exit-node", false, "offer to be an exit node for internet traffic for the tailnet")
 	setf.BoolVar(&setArgs.updateCheck, "update-check", true, "HIDDEN: notify about available Tailscale updates")
 	setf.BoolVar(&setArgs.updateApply, "auto-update", false, "HIDDEN: automatically update to the latest available version")
+	setf.StringVar(&setArgs.updateWindow, "auto-update-window", "", "HIDDEN: daily window of local time in which to apply automatic updates, as HH:MM-HH:MM (e.g. \"02:00-04:00\"), or empty string for any time")
+	setf.StringVar(&setArgs.updateTrain, "auto-update-train", "", "HIDDEN: release train to limit automatic updates to (e.g. \"1.50\"), or empty string for the latest version")
+	setf.IntVar(&setArgs.updateDelayDays, "auto-update-delay-days", 0, "HIDDEN: days a version must have been available before it's applied by automatic updates")
+	setf.BoolVar(&setArgs.qos, "qos", false, "prioritize interactive traffic (such as SSH) over bulk transfers (such as Taildrop) and set DSCP marks on tunneled packets")
+	setf.StringVar(&setArgs.dnsRoutes, "dns-routes", "", "local split DNS routes to merge with the tailnet's DNS settings (comma-separated domain=resolver pairs, e.g. \"corp.internal=10.0.0.53,corp.internal=10.0.0.54\") or empty string to remove them")
+	setf.IntVar(&setArgs.relayDailyLimitMB, "relay-daily-limit-mb", 0, "megabytes per day that this subnet router or exit node forwards for each peer, or 0 for no limit")
+	setf.IntVar(&setArgs.metricsPort, "metrics-port", 0, "TCP port on which to serve Prometheus metrics at http://localhost:<port>/metrics, or 0 to not serve them")
+	setf.BoolVar(&setArgs.metricsOnTailnet, "metrics-on-tailnet", false, "also serve Prometheus metrics on --metrics-port of this node's Tailscale IPs")
+	setf.StringVar(&setArgs.otherVPN, "other-vpn", ipn.OtherVPNOverride, "how to route while another VPN conflicting with Tailscale is active: \"override\" it, \"yield\" to it, or \"scope\" Tailscale's routes to its peers")
+	setf.StringVar(&setArgs.proxy, "proxy", "", "HTTP proxy URL for connections to the coordination, DERP and log servers (e.g. \"http://proxy.example.com:3128\"), or empty string to not use one")
+	setf.StringVar(&setArgs.proxyBypass, "proxy-bypass", "", "comma-separated hosts to connect to without a proxy, like NO_PROXY")
+	setf.StringVar(&setArgs.proxyPACURL, "proxy-pac-url", "", "URL of a proxy auto-config (PAC) file to choose the proxy with, or empty string to not use one (Windows and macOS only)")
+	setf.BoolVar(&setArgs.proxyAutoDetect, "proxy-auto-detect", false, "look for a proxy auto-config file with WPAD (Windows and macOS only)")
+	setf.StringVar(&setArgs.proxyAuth, "proxy-auth", "", "proxy authentication: \"ntlm\", or empty string for Basic (or Negotiate on Windows)")
+	setf.StringVar(&setArgs.proxyUser, "proxy-user", "", "proxy credentials as user:password (or DOMAIN\\user:password for NTLM), kept apart from the readable prefs; empty string to clear")
+	setf.StringVar(&setArgs.advertise4via6, "advertise-4via6", "", "IPv4 subnets to advertise as 4via6 routes, as comma-separated site-id:cidr pairs (e.g. \"7:10.0.0.0/24,8:10.0.0.0/24\"), replacing any 4via6 routes in --advertise-routes, or empty string to remove them")
+	setf.BoolVar(&setArgs.nat64, "nat64", false, "also advertise the IPv4 --advertise-routes in the NAT64 prefix 64:ff9b::/96, translating to IPv4 and answering peers' AAAA queries for names in them (DNS64), for IPv6-only clients")
+	setf.StringVar(&setArgs.advertiseEndpoints, "advertise-endpoints", "", "public endpoints at which peers can reach this node, such as ports forwarded to tailscaled's port, to advertise while reachable (comma-separated, e.g. \"203.0.113.7:41641\") or empty string to not advertise any")
+	setf.UintVar(&setArgs.viaSiteID, "4via6-site-id", 0, "also advertise the IPv4 --advertise-routes as 4via6 routes with this site ID (1-255), or 0 to not")
+	setf.IntVar(&setArgs.bandwidthQuotaMB, "bandwidth-quota-mb", 0, "megabytes of Tailscale traffic this node may use per month before a health warning, for metered connections, or 0 for no quota")
+	setf.IntVar(&setArgs.bandwidthResetDay, "bandwidth-reset-day", 1, fmt.Sprintf("day of the month (1-%d) on which bandwidth usage resets, such as the start of a billing cycle", ipn.MaxBandwidthResetDay))
+	setf.IntVar(&setArgs.bandwidthWarnPercent, "bandwidth-warn-percent", 0, "percentage of --bandwidth-quota-mb at which to warn ahead of reaching it (1-99), or 0 to only warn when reached")
+	setf.IntVar(&setArgs.netcheckHistoryHours, "netcheck-history-hours", 0, fmt.Sprintf("hours of periodic netcheck samples to record for 'tailscale netcheck --history' (up to %d), or 0 to not record them", ipn.MaxNetcheckHistoryHours))
+	setf.StringVar(&setArgs.keepHot, "keep-hot", "", "peers (comma-separated names or Tailscale IPs) to ping periodically even when idle, so that firewalls and NATs on the path never expire the connection, or empty string for none")
+	setf.StringVar(&setArgs.allowedPeers, "allowed-peers", "", "the only peers (comma-separated names, Tailscale IPs or tag:<name>) to allow any traffic with, whatever the tailnet's ACLs permit, or empty string for all peers")
+	setf.DurationVar(&setArgs.keepHotInterval, "keep-hot-interval", ipn.DefaultKeepHotInterval, fmt.Sprintf("interval between the pings to --keep-hot peers, from %ds to %ds", ipn.MinKeepHotIntervalSeconds, ipn.MaxKeepHotIntervalSeconds))
 	if safesocket.GOOSUsesPeerCreds(goos) {
 		setf.StringVar(&setArgs.opUser, "operator", "", "Unix username to allow to operate on tailscaled without sudo")
 	}
 	switch goos {
+	case "linux":
+		setf.BoolVar(&setArgs.fixIPForwarding, "fix-ip-forwarding", false, "when advertising routes, enable IP forwarding and loosen strict reverse path filtering as needed, persistently, instead of only warning (Linux-only)")
 	case "windows":
 		setf.BoolVar(&setArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
@@ -104,13 +168,80 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			Hostname:               setArgs.hostname,
 			OperatorUser:           setArgs.opUser,
 			ForceDaemon:            setArgs.forceDaemon,
-			AutoUpdate: ipn.AutoUpdatePrefs{
-				Check: setArgs.updateCheck,
-				Apply: setArgs.updateApply,
-			},
+			QoS:                    setArgs.qos,
+			RelayDailyLimitMB:      setArgs.relayDailyLimitMB,
+			MetricsPort:            setArgs.metricsPort,
+			MetricsOnTailnet:       setArgs.metricsOnTailnet,
+			OtherVPNPolicy:         setArgs.otherVPN,
+			FixIPForwarding:        setArgs.fixIPForwarding,
+			NetcheckHistoryHours:   setArgs.netcheckHistoryHours,
+			KeepHotIntervalSeconds: int(setArgs.keepHotInterval / time.Second),
 		},
 	}
 
+	if setArgs.relayDailyLimitMB < 0 {
+		return errors.New("--relay-daily-limit-mb must not be negative")
+	}
+	if setArgs.metricsPort < 0 || setArgs.metricsPort > 65535 {
+		return errors.New("--metrics-port must be between 0 and 65535")
+	}
+	if setArgs.updateDelayDays < 0 {
+		return errors.New("--auto-update-delay-days must not be negative")
+	}
+	switch setArgs.otherVPN {
+	case ipn.OtherVPNOverride, ipn.OtherVPNYield, ipn.OtherVPNScope:
+	default:
+		return fmt.Errorf("invalid --other-vpn value %q; want override, yield or scope", setArgs.otherVPN)
+	}
+	if setArgs.bandwidthQuotaMB < 0 {
+		return errors.New("--bandwidth-quota-mb must not be negative")
+	}
+	if setArgs.bandwidthResetDay < 1 || setArgs.bandwidthResetDay > ipn.MaxBandwidthResetDay {
+		return fmt.Errorf("--bandwidth-reset-day must be between 1 and %d", ipn.MaxBandwidthResetDay)
+	}
+	if setArgs.bandwidthWarnPercent < 0 || setArgs.bandwidthWarnPercent > 99 {
+		return errors.New("--bandwidth-warn-percent must be between 0 and 99")
+	}
+	if setArgs.netcheckHistoryHours < 0 || setArgs.netcheckHistoryHours > ipn.MaxNetcheckHistoryHours {
+		return fmt.Errorf("--netcheck-history-hours must be between 0 and %d", ipn.MaxNetcheckHistoryHours)
+	}
+	if secs := setArgs.keepHotInterval / time.Second; secs < ipn.MinKeepHotIntervalSeconds || secs > ipn.MaxKeepHotIntervalSeconds || setArgs.keepHotInterval%time.Second != 0 {
+		return fmt.Errorf("--keep-hot-interval must be a whole number of seconds between %ds and %ds", ipn.MinKeepHotIntervalSeconds, ipn.MaxKeepHotIntervalSeconds)
+	}
+	if setArgs.viaSiteID > ipn.MaxViaSiteID {
+		return fmt.Errorf("--4via6-site-id must be between 0 and %d", ipn.MaxViaSiteID)
+	}
+	switch setArgs.proxyAuth {
+	case "", "ntlm":
+	default:
+		return fmt.Errorf("invalid --proxy-auth value %q; want ntlm or empty", setArgs.proxyAuth)
+	}
+
+	if setArgs.dnsRoutes != "" {
+		maskedPrefs.DNSRoutes, err = parseDNSRoutes(setArgs.dnsRoutes)
+		if err != nil {
+			return err
+		}
+	}
+	if setArgs.advertiseEndpoints != "" {
+		maskedPrefs.AdvertiseEndpoints, err = parseAdvertiseEndpoints(setArgs.advertiseEndpoints)
+		if err != nil {
+			return err
+		}
+	}
+	if setArgs.keepHot != "" {
+		maskedPrefs.KeepHotPeers = parsePeerList(setArgs.keepHot)
+	}
+	if setArgs.allowedPeers != "" {
+		maskedPrefs.AllowedPeers = parsePeerList(setArgs.allowedPeers)
+	}
+	if setArgs.exitNodeExclude != "" {
+		maskedPrefs.ExitNodeExcludeRoutes, maskedPrefs.ExitNodeExcludeUIDs, err = parseExitNodeExclude(setArgs.exitNodeExclude, effectiveGOOS())
+		if err != nil {
+			return err
+		}
+	}
+
 	if setArgs.exitNodeIP != "" {
 		if err := maskedPrefs.Prefs.SetExitNodeIP(setArgs.exitNodeIP, st); err != nil {
 			var e ipn.ExitNodeLocalIPError
@@ -121,7 +252,8 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		}
 	}
 
-	var advertiseExitNodeSet, advertiseRoutesSet bool
+	var advertiseExitNodeSet, advertiseRoutesSet, advertise4via6Set, proxyUserSet bool
+	var proxyFlags, nat64Flags, updateFlags, bandwidthFlags []string
 	setFlagSet.Visit(func(f *flag.Flag) {
 		updateMaskedPrefsFromUpOrSetFlag(maskedPrefs, f.Name)
 		switch f.Name {
@@ -129,9 +261,35 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			advertiseExitNodeSet = true
 		case "advertise-routes":
 			advertiseRoutesSet = true
+		case "advertise-4via6":
+			advertise4via6Set = true
+		case "proxy-user":
+			proxyUserSet = true
+		}
+		if strings.HasPrefix(f.Name, "proxy") {
+			proxyFlags = append(proxyFlags, f.Name)
+		}
+		if f.Name == "nat64" || f.Name == "4via6-site-id" {
+			nat64Flags = append(nat64Flags, f.Name)
+		}
+		if f.Name == "update-check" || strings.HasPrefix(f.Name, "auto-update") {
+			updateFlags = append(updateFlags, f.Name)
+		}
+		if strings.HasPrefix(f.Name, "bandwidth-") {
+			bandwidthFlags = append(bandwidthFlags, f.Name)
 		}
 	})
+	if maskedPrefs.ProxySet && setArgs.proxy != "" {
+		var creds string
+		setArgs.proxy, creds = splitProxyURLCredentials(setArgs.proxy)
+		if creds != "" && !proxyUserSet {
+			setArgs.proxyUser, proxyUserSet = creds, true
+		}
+	}
 	if maskedPrefs.IsEmpty() {
+		if proxyUserSet {
+			return localClient.SetProxyCredentials(ctx, setArgs.proxyUser)
+		}
 		return flag.ErrHelp
 	}
 
@@ -140,12 +298,25 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 	if maskedPrefs.AdvertiseRoutesSet {
-		maskedPrefs.AdvertiseRoutes, err = calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet, curPrefs, setArgs)
+		maskedPrefs.AdvertiseRoutes, err = calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet, advertise4via6Set, curPrefs, setArgs)
 		if err != nil {
 			return err
 		}
 	}
 
+	if maskedPrefs.ProxySet {
+		maskedPrefs.Proxy = proxyPrefsForSet(curPrefs.Proxy, proxyFlags, setArgs)
+	}
+	if maskedPrefs.NAT64Set {
+		maskedPrefs.NAT64 = nat64PrefsForSet(curPrefs.NAT64, nat64Flags, setArgs)
+	}
+	if maskedPrefs.AutoUpdateSet {
+		maskedPrefs.AutoUpdate = autoUpdatePrefsForSet(curPrefs.AutoUpdate, updateFlags, setArgs)
+	}
+	if maskedPrefs.BandwidthQuotaSet {
+		maskedPrefs.BandwidthQuota = bandwidthQuotaPrefsForSet(curPrefs.BandwidthQuota, bandwidthFlags, setArgs)
+	}
+
 	if maskedPrefs.RunSSHSet {
 		wantSSH, haveSSH := maskedPrefs.RunSSH, curPrefs.RunSSH
 		if err := presentSSHToggleRisk(wantSSH, haveSSH, setArgs.acceptedRisks); err != nil {
@@ -163,18 +334,290 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	if err := localClient.CheckPrefs(ctx, checkPrefs); err != nil {
 		return err
 	}
+	if proxyUserSet {
+		if err := localClient.SetProxyCredentials(ctx, setArgs.proxyUser); err != nil {
+			return err
+		}
+	}
 
 	_, err = localClient.EditPrefs(ctx, maskedPrefs)
 	return err
 }
 
+// proxyPrefsForSet returns cur with the proxy settings of the given
+// --proxy* flags replaced, leaving the others as they were.
+func proxyPrefsForSet(cur ipn.ProxyPrefs, flags []string, setArgs setArgsT) ipn.ProxyPrefs {
+	p := cur
+	for _, f := range flags {
+		switch f {
+		case "proxy":
+			p.URL = setArgs.proxy
+		case "proxy-bypass":
+			p.NoProxy = setArgs.proxyBypass
+		case "proxy-pac-url":
+			p.PACURL = setArgs.proxyPACURL
+		case "proxy-auto-detect":
+			p.AutoDetect = setArgs.proxyAutoDetect
+		case "proxy-auth":
+			p.Auth = setArgs.proxyAuth
+		}
+	}
+	return p
+}
+
+// splitProxyURLCredentials returns the --proxy URL s without any
+// credentials, and the credentials as "user:password". Those aren't
+// stored in prefs, which any local user can read.
+func splitProxyURLCredentials(s string) (proxyURL, creds string) {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s, ""
+	}
+	pass, _ := u.User.Password()
+	creds = u.User.Username() + ":" + pass
+	u.User = nil
+	return u.String(), creds
+}
+
+// parse4via6Routes parses the --advertise-4via6 flag value, a
+// comma-separated list of site-id:cidr pairs, into 4via6 routes. Site IDs
+// are decimal or hex with a 0x prefix, as for "tailscale debug via".
+func parse4via6Routes(s string) ([]netip.Prefix, error) {
+	var routes []netip.Prefix
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		site, cidr, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid --advertise-4via6 entry %q; want site-id:cidr", pair)
+		}
+		siteID, err := strconv.ParseUint(site, 0, 32)
+		if err != nil || siteID > ipn.MaxViaSiteID {
+			return nil, fmt.Errorf("invalid site ID %q in --advertise-4via6; want 0 to %d", site, ipn.MaxViaSiteID)
+		}
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("%s in --advertise-4via6 is not an IPv4 CIDR", p)
+		}
+		if p != p.Masked() {
+			return nil, fmt.Errorf("%s has non-address bits set; expected %s", p, p.Masked())
+		}
+		via, err := tsaddr.MapVia(uint32(siteID), p)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, via)
+	}
+	return routes, nil
+}
+
+// nat64PrefsForSet returns cur with the settings of the given --nat64 and
+// --4via6-site-id flags replaced, leaving the others as they were.
+func nat64PrefsForSet(cur ipn.NAT64Prefs, flags []string, setArgs setArgsT) ipn.NAT64Prefs {
+	np := cur
+	for _, f := range flags {
+		switch f {
+		case "nat64":
+			np.Enabled = setArgs.nat64
+		case "4via6-site-id":
+			np.ViaSiteID = uint32(setArgs.viaSiteID)
+		}
+	}
+	return np
+}
+
+// bandwidthQuotaPrefsForSet returns cur with the settings of the given
+// --bandwidth-* flags replaced, leaving the others as they were.
+func bandwidthQuotaPrefsForSet(cur ipn.BandwidthQuotaPrefs, flags []string, setArgs setArgsT) ipn.BandwidthQuotaPrefs {
+	bq := cur
+	for _, f := range flags {
+		switch f {
+		case "bandwidth-quota-mb":
+			bq.MonthlyMB = setArgs.bandwidthQuotaMB
+		case "bandwidth-reset-day":
+			bq.ResetDay = setArgs.bandwidthResetDay
+		case "bandwidth-warn-percent":
+			bq.WarnPercent = setArgs.bandwidthWarnPercent
+		}
+	}
+	return bq
+}
+
+// autoUpdatePrefsForSet returns cur with the settings of the given
+// --update-check and --auto-update* flags replaced, leaving the others as
+// they were. As before the update policy flags existed, either of
+// --update-check and --auto-update sets both Check and Apply.
+func autoUpdatePrefsForSet(cur ipn.AutoUpdatePrefs, flags []string, setArgs setArgsT) ipn.AutoUpdatePrefs {
+	au := cur
+	for _, f := range flags {
+		switch f {
+		case "update-check", "auto-update":
+			au.Check = setArgs.updateCheck
+			au.Apply = setArgs.updateApply
+		case "auto-update-window":
+			au.Window = setArgs.updateWindow
+		case "auto-update-train":
+			au.Train = setArgs.updateTrain
+		case "auto-update-delay-days":
+			au.DelayDays = setArgs.updateDelayDays
+		}
+	}
+	return au
+}
+
+// parseAdvertiseEndpoints parses the --advertise-endpoints flag value: a
+// comma-separated list of public IP:port endpoints.
+func parseAdvertiseEndpoints(s string) ([]netip.AddrPort, error) {
+	var eps []netip.AddrPort
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		ep, err := netip.ParseAddrPort(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q in --advertise-endpoints; want ip:port", v)
+		}
+		if ep.Port() == 0 || ep.Addr().IsUnspecified() {
+			return nil, fmt.Errorf("invalid endpoint %v in --advertise-endpoints", ep)
+		}
+		if !slices.Contains(eps, ep) {
+			eps = append(eps, ep)
+		}
+	}
+	return eps, nil
+}
+
+// parsePeerList parses a comma-separated list of peers, such as the value
+// of --keep-hot or --allowed-peers, dropping empty and duplicate entries.
+func parsePeerList(s string) []string {
+	var peers []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" && !slices.Contains(peers, v) {
+			peers = append(peers, v)
+		}
+	}
+	return peers
+}
+
+// parseExitNodeExclude parses the --exit-node-exclude flag value: a
+// comma-separated list of IPs and CIDRs to reach directly rather than via
+// the exit node and, on Linux, of user:<name or uid> entries for local
+// users whose traffic should bypass it.
+func parseExitNodeExclude(s, goos string) (routes []netip.Prefix, uids []uint32, err error) {
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if name, ok := strings.CutPrefix(v, "user:"); ok {
+			if goos != "linux" {
+				return nil, nil, errors.New("excluding users from the exit node is only supported on Linux")
+			}
+			uid, err := lookupUID(name)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid user %q in --exit-node-exclude: %w", name, err)
+			}
+			if !slices.Contains(uids, uid) {
+				uids = append(uids, uid)
+			}
+			continue
+		}
+		var r netip.Prefix
+		if strings.Contains(v, "/") {
+			r, err = netip.ParsePrefix(v)
+		} else {
+			var ip netip.Addr
+			ip, err = netip.ParseAddr(v)
+			r = netip.PrefixFrom(ip, ip.BitLen())
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid entry %q in --exit-node-exclude; want an IP, CIDR or user:<name>", v)
+		}
+		r = r.Masked()
+		if r.Bits() == 0 {
+			return nil, nil, fmt.Errorf("can't exclude %v from the exit node; use --exit-node= to stop using it", r)
+		}
+		if r.Overlaps(tsaddr.CGNATRange()) || r.Overlaps(tsaddr.TailscaleULARange()) {
+			return nil, nil, fmt.Errorf("can't exclude %v from the exit node: it overlaps Tailscale's addresses", r)
+		}
+		if !slices.Contains(routes, r) {
+			routes = append(routes, r)
+		}
+	}
+	return routes, uids, nil
+}
+
+// lookupUID returns the uid of the local user with the given name or uid.
+func lookupUID(name string) (uint32, error) {
+	if uid, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(uid), nil
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(uid), nil
+}
+
+// parseDNSRoutes parses the --dns-routes flag value: a comma-separated list
+// of domain=resolver pairs. A domain may be repeated to give it multiple
+// resolvers, which are used in the order given.
+func parseDNSRoutes(s string) (map[string][]string, error) {
+	routes := map[string][]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		domain, resolver, ok := strings.Cut(pair, "=")
+		domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+		resolver = strings.TrimSpace(resolver)
+		if !ok || domain == "" || resolver == "" {
+			return nil, fmt.Errorf("invalid DNS route %q; want domain=resolver", pair)
+		}
+		if _, err := dnsname.ToFQDN(domain); err != nil {
+			return nil, fmt.Errorf("invalid DNS route domain %q: %w", domain, err)
+		}
+		routes[domain] = append(routes[domain], resolver)
+	}
+	return routes, nil
+}
+
 // calcAdvertiseRoutesForSet returns the new value for Prefs.AdvertiseRoutes based on the
 // current value, the flags passed to "tailscale set".
 // advertiseExitNodeSet is whether the --advertise-exit-node flag was set.
 // advertiseRoutesSet is whether the --advertise-routes flag was set.
 // curPrefs is the current Prefs.
 // setArgs is the parsed command-line arguments.
-func calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet bool, curPrefs *ipn.Prefs, setArgs setArgsT) (routes []netip.Prefix, err error) {
+func calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet, advertise4via6Set bool, curPrefs *ipn.Prefs, setArgs setArgsT) (routes []netip.Prefix, err error) {
+	if advertise4via6Set {
+		via, err := parse4via6Routes(setArgs.advertise4via6)
+		if err != nil {
+			return nil, err
+		}
+		routes := curPrefs.AdvertiseRoutes
+		if advertiseExitNodeSet || advertiseRoutesSet {
+			routes, err = calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet, false, curPrefs, setArgs)
+			if err != nil {
+				return nil, err
+			}
+		}
+		routes = tsaddr.FilterPrefixesCopy(views.SliceOf(routes), func(p netip.Prefix) bool {
+			return !tsaddr.IsViaPrefix(p)
+		})
+		return append(routes, via...), nil
+	}
 	if advertiseExitNodeSet && advertiseRoutesSet {
 		return netutil.CalcAdvertiseRoutes(setArgs.advertiseRoutes, setArgs.advertiseDefaultRoute)
 
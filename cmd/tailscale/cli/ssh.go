@@ -43,8 +43,13 @@
   system 'ssh' command that connects via a pipe through tailscaled.
 * It automatically checks the destination server's SSH host key against the
   node's SSH host key as advertised via the Tailscale coordination server.
+
+To get the same from the normal 'ssh' command, see 'tailscale ssh config'.
 `),
 	Exec: runSSH,
+	Subcommands: []*ffcli.Command{
+		sshConfigCmd,
+	},
 }
 
 func runSSH(ctx context.Context, args []string) error {
@@ -164,6 +169,18 @@ func writeKnownHosts(st *ipnstate.Status) (knownHostsFile string, err error) {
 
 func genKnownHosts(st *ipnstate.Status) []byte {
 	var buf bytes.Buffer
+	if ct := st.CurrentTailnet; ct != nil && ct.MagicDNSSuffix != "" {
+		// Trust host certificates signed by the tailnet's CAs for any
+		// MagicDNS name, so hosts with certificates never need an
+		// entry of their own.
+		for _, ca := range ct.SSHHostCAs {
+			ca = strings.TrimSpace(ca)
+			if strings.ContainsAny(ca, "\n\r") { // invalid
+				continue
+			}
+			fmt.Fprintf(&buf, "@cert-authority *.%s.,*.%s %s\n", ct.MagicDNSSuffix, ct.MagicDNSSuffix, ca)
+		}
+	}
 	for _, k := range st.Peers() {
 		ps := st.Peer[k]
 		for _, hk := range ps.SSH_HostKeys {
@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/paths"
+)
+
+var sshConfigCmd = &ffcli.Command{
+	Name:       "config",
+	ShortUsage: "ssh config [--users=<host>=<user>,...]",
+	ShortHelp:  "Print an OpenSSH config for Tailscale SSH hosts",
+	LongHelp: strings.TrimSpace(`
+"tailscale ssh config" prints an OpenSSH client config for the tailnet's SSH
+hosts, so the plain 'ssh', 'scp' and 'rsync' commands and IDE remote
+extensions can use them as 'tailscale ssh' does: by MagicDNS name (even with
+--accept-dns=false), through tailscaled (so they work in userspace
+networking mode), and checking host keys against those advertised via the
+Tailscale coordination server.
+
+Save the output to a file and include it from ~/.ssh/config:
+
+  tailscale ssh config > ~/.ssh/tailscale.conf
+  echo 'Include ~/.ssh/tailscale.conf' >> ~/.ssh/config
+
+Host keys are kept up to date automatically each time ssh connects to a
+Tailscale host. Run the command again when hosts are added to the tailnet.
+
+Which user to log in as on each host can be set with --users, as a
+comma-separated list of <host>=<user> pairs, where <host> may be "*" for
+all hosts.
+`),
+	Exec: runSSHConfig,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("config")
+		fs.StringVar(&sshConfigArgs.users, "users", "", `comma-separated <host>=<user> pairs of which user to log in to each host as, where <host> may be "*"`)
+		fs.BoolVar(&sshConfigArgs.updateKnownHosts, "update-known-hosts", false, "only update the known hosts file; run by ssh from the generated config")
+		return fs
+	})(),
+}
+
+var sshConfigArgs struct {
+	users            string
+	updateKnownHosts bool
+}
+
+func runSSHConfig(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	users, err := parseSSHUsers(sshConfigArgs.users)
+	if err != nil {
+		return err
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	knownHostsFile, err := writeKnownHosts(st)
+	if err != nil {
+		return err
+	}
+	if sshConfigArgs.updateKnownHosts {
+		return nil
+	}
+	tailscaleBin, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	socket := ""
+	if rootArgs.socket != paths.DefaultTailscaledSocket() {
+		socket = rootArgs.socket
+	}
+	Stdout.Write(genSSHConfig(st, sshConfigOpts{
+		tailscaleBin:   tailscaleBin,
+		socket:         socket,
+		knownHostsFile: knownHostsFile,
+		users:          users,
+		proxy:          runtime.GOOS != "darwin", // see runSSH
+	}))
+	return nil
+}
+
+// parseSSHUsers parses the --users flag of "tailscale ssh config", a
+// comma-separated list of <host>=<user> pairs.
+func parseSSHUsers(s string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		host, user, ok := strings.Cut(pair, "=")
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if !ok || host == "" || user == "" || strings.ContainsAny(user, " \t\"") {
+			return nil, fmt.Errorf("invalid --users entry %q; want <host>=<user>", pair)
+		}
+		users[host] = user
+	}
+	return users, nil
+}
+
+type sshConfigOpts struct {
+	tailscaleBin   string
+	socket         string // tailscaled socket path, if not the default
+	knownHostsFile string
+	users          map[string]string // from parseSSHUsers
+	proxy          bool              // whether to connect with "tailscale nc"
+}
+
+// genSSHConfig returns an OpenSSH client config for the peers in st that
+// advertise SSH host keys.
+//
+// Each host gets a Host block, matching its MagicDNS base name and full
+// name, that sets its full name (for "tailscale nc") and the known hosts
+// key it's listed under. A Match block then applies the options common to
+// all of them, running "tailscale ssh config --update-known-hosts" first to
+// keep the known hosts file current. If that fails, as when tailscaled
+// isn't running, the Match block doesn't apply.
+func genSSHConfig(st *ipnstate.Status, o sshConfigOpts) []byte {
+	var peers []*ipnstate.PeerStatus
+	for _, ps := range st.Peer {
+		if ps.DNSName != "" && len(ps.SSH_HostKeys) > 0 {
+			peers = append(peers, ps)
+		}
+	}
+	slices.SortFunc(peers, func(a, b *ipnstate.PeerStatus) int {
+		return strings.Compare(a.DNSName, b.DNSName)
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# Tailscale SSH hosts, generated by \"tailscale ssh config\".\n\n")
+	if len(peers) == 0 {
+		buf.WriteString("# No peers advertise SSH host keys.\n")
+		return buf.Bytes()
+	}
+	var names []string
+	for _, ps := range peers {
+		fqdn := strings.TrimSuffix(ps.DNSName, ".")
+		base, _, _ := strings.Cut(fqdn, ".")
+		fmt.Fprintf(&buf, "Host %s %s\n", base, fqdn)
+		fmt.Fprintf(&buf, "\tHostName %s\n", fqdn)
+		fmt.Fprintf(&buf, "\tHostKeyAlias %s\n", ps.DNSName) // as in the known hosts file
+		if u := sshUserFor(o.users, base, fqdn); u != "" {
+			fmt.Fprintf(&buf, "\tUser %s\n", u)
+		}
+		buf.WriteString("\n")
+		names = append(names, base, fqdn)
+	}
+
+	tailscaleCmd := sshShellQuote(o.tailscaleBin)
+	if o.socket != "" {
+		tailscaleCmd += " --socket=" + sshShellQuote(o.socket)
+	}
+	fmt.Fprintf(&buf, "Match host %s exec \"%s ssh config --update-known-hosts\"\n",
+		strings.Join(names, ","), tailscaleCmd)
+	if o.proxy {
+		fmt.Fprintf(&buf, "\tProxyCommand %s nc %%h %%p\n", tailscaleCmd)
+	}
+	fmt.Fprintf(&buf, "\tUserKnownHostsFile %q\n", o.knownHostsFile)
+	buf.WriteString("\tUpdateHostKeys no\n")
+	buf.WriteString("\tStrictHostKeyChecking yes\n")
+	return buf.Bytes()
+}
+
+// sshUserFor returns the user to log in to the host with the given MagicDNS
+// base and full names as, or the empty string to leave it to ssh.
+func sshUserFor(users map[string]string, base, fqdn string) string {
+	for _, k := range []string{strings.ToLower(fqdn), strings.ToLower(base), "*"} {
+		if u, ok := users[k]; ok {
+			return u
+		}
+	}
+	return ""
+}
+
+// sshShellQuote quotes s for the shell ssh runs Match exec commands and
+// ProxyCommands with, if needed.
+func sshShellQuote(s string) string {
+	if !strings.ContainsAny(s, " \t'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestGenSSHConfig(t *testing.T) {
+	st := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {DNSName: "web.foo.ts.net.", SSH_HostKeys: []string{"ssh-ed25519 AAAA"}},
+			key.NewNode().Public(): {DNSName: "db.foo.ts.net.", SSH_HostKeys: []string{"ssh-ed25519 BBBB"}},
+			key.NewNode().Public(): {DNSName: "phone.foo.ts.net."},
+		},
+	}
+	got := string(genSSHConfig(st, sshConfigOpts{
+		tailscaleBin:   "/usr/bin/tailscale",
+		socket:         "/tmp/my socket",
+		knownHostsFile: "/home/u/.config/tailscale/ssh_known_hosts",
+		users:          map[string]string{"db": "postgres", "*": "alice"},
+		proxy:          true,
+	}))
+	const want = `# Tailscale SSH hosts, generated by "tailscale ssh config".
+
+Host db db.foo.ts.net
+	HostName db.foo.ts.net
+	HostKeyAlias db.foo.ts.net.
+	User postgres
+
+Host web web.foo.ts.net
+	HostName web.foo.ts.net
+	HostKeyAlias web.foo.ts.net.
+	User alice
+
+Match host db,db.foo.ts.net,web,web.foo.ts.net exec "/usr/bin/tailscale --socket='/tmp/my socket' ssh config --update-known-hosts"
+	ProxyCommand /usr/bin/tailscale --socket='/tmp/my socket' nc %h %p
+	UserKnownHostsFile "/home/u/.config/tailscale/ssh_known_hosts"
+	UpdateHostKeys no
+	StrictHostKeyChecking yes
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestParseSSHUsers(t *testing.T) {
+	got, err := parseSSHUsers("web=root, DB.foo.ts.net.=postgres,*=alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"web": "root", "db.foo.ts.net": "postgres", "*": "alice"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	for _, bad := range []string{"web", "=root", "web=", "web=a b"} {
+		if _, err := parseSSHUsers(bad); err == nil {
+			t.Errorf("parseSSHUsers(%q) succeeded; want error", bad)
+		}
+	}
+}
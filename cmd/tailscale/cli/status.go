@@ -23,13 +23,14 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/util/cmpx"
 	"tailscale.com/util/dnsname"
 )
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "status [--active] [--web] [--json]",
+	ShortUsage: "status [--active] [--web] [--json] [--health]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
@@ -56,6 +57,7 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.BoolVar(&statusArgs.health, "health", false, "show only the current health problems, with their severity, subsystem, duration and suggested commands; combine with --json for JSON output")
 		return fs
 	})(),
 }
@@ -68,12 +70,19 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+	health  bool   // show the health view instead of status
 }
 
 func runStatus(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected non-flag arguments to 'tailscale status'")
 	}
+	if statusArgs.health {
+		if statusArgs.web {
+			return errors.New("--health and --web can't be used together")
+		}
+		return printHealthView(ctx, statusArgs.json)
+	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
@@ -131,10 +140,11 @@ func runStatus(ctx context.Context, args []string) error {
 	}
 
 	printHealth := func() {
-		printf("# Health check:\n")
+		printf("# %s:\n", msgs.Text("status.healthCheck"))
 		for _, m := range st.Health {
 			printf("#     - %s\n", m)
 		}
+		printf("# %s\n", msgs.Text("status.healthDetails"))
 	}
 
 	description, ok := isRunningOrStarting(st)
@@ -164,7 +174,9 @@ func runStatus(ctx context.Context, args []string) error {
 		if !ps.Online {
 			offline = "; offline"
 		}
-		if !ps.Active {
+		if ps.LocallyBlocked {
+			f("blocked by --allowed-peers")
+		} else if !ps.Active {
 			if ps.ExitNode {
 				f("idle; exit node" + offline)
 			} else if ps.ExitNodeOption {
@@ -198,8 +210,10 @@ func runStatus(ctx context.Context, args []string) error {
 		f("\n")
 	}
 
+	var shown []*ipnstate.PeerStatus // nodes printed, for their 4via6 routes
 	if statusArgs.self && st.Self != nil {
 		printPS(st.Self)
+		shown = append(shown, st.Self)
 	}
 
 	locBasedExitNode := false
@@ -224,9 +238,17 @@ func runStatus(ctx context.Context, args []string) error {
 				continue
 			}
 			printPS(ps)
+			shown = append(shown, ps)
 		}
 	}
 	Stdout.Write(buf.Bytes())
+	if lines := viaRouteLines(st, shown); len(lines) > 0 {
+		outln()
+		printf("# 4via6 routes:\n")
+		for _, l := range lines {
+			printf("#     - %s\n", l)
+		}
+	}
 	if locBasedExitNode {
 		println()
 		println("# To see the full list of exit nodes, including location-based exit nodes, run `tailscale exit-node list`  \n")
@@ -242,6 +264,27 @@ func runStatus(ctx context.Context, args []string) error {
 	return nil
 }
 
+// viaRouteLines describes the 4via6 routes that nodes are the primary
+// subnet routers for, one line per route with the IPv4 subnet and site ID
+// it maps.
+func viaRouteLines(st *ipnstate.Status, nodes []*ipnstate.PeerStatus) []string {
+	var lines []string
+	for _, ps := range nodes {
+		if ps.PrimaryRoutes == nil {
+			continue
+		}
+		for i := 0; i < ps.PrimaryRoutes.Len(); i++ {
+			r := ps.PrimaryRoutes.At(i)
+			siteID, v4, ok := tsaddr.UnmapViaPrefix(r)
+			if !ok {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s: site %d %v at %v", dnsOrQuoteHostname(st, ps), siteID, v4, r))
+		}
+	}
+	return lines
+}
+
 // printFunnelStatus prints the status of the funnel, if it's running.
 // It prints nothing if the funnel is not running.
 func printFunnelStatus(ctx context.Context) {
@@ -282,17 +325,17 @@ func printFunnelStatus(ctx context.Context) {
 func isRunningOrStarting(st *ipnstate.Status) (description string, ok bool) {
 	switch st.BackendState {
 	default:
-		return fmt.Sprintf("unexpected state: %s", st.BackendState), false
+		return msgs.Text("status.unexpectedState", "state", st.BackendState), false
 	case ipn.Stopped.String():
-		return "Tailscale is stopped.", false
+		return msgs.Text("status.stopped"), false
 	case ipn.NeedsLogin.String():
-		s := "Logged out."
+		s := msgs.Text("status.loggedOut")
 		if st.AuthURL != "" {
-			s += fmt.Sprintf("\nLog in at: %s", st.AuthURL)
+			s += "\n" + msgs.Text("status.logInAt", "url", st.AuthURL)
 		}
 		return s, false
 	case ipn.NeedsMachineAuth.String():
-		return "Machine is not yet approved by tailnet admin.", false
+		return msgs.Text("status.needsMachineAuth"), false
 	case ipn.Running.String(), ipn.Starting.String():
 		return st.BackendState, true
 	}
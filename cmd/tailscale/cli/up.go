@@ -61,6 +61,10 @@
 is also used. (The flags --auth-key, --force-reauth, and --qr are not
 considered settings that need to be re-specified when modifying
 settings.)
+
+With --until, "tailscale up" disconnects again automatically at the given
+time, which may be a time of day ("18:00"), an RFC 3339 timestamp, or a
+duration from now ("2h"). With --after, it connects later instead of now.
 `),
 	FlagSet: upFlagSet,
 	Exec: func(ctx context.Context, args []string) error {
@@ -126,6 +130,10 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	}
 	upf.DurationVar(&upArgs.timeout, "timeout", 0, "maximum amount of time to wait for tailscaled to enter a Running state; default (0s) blocks forever")
 
+	if cmd == "up" {
+		upf.DurationVar(&upArgs.after, "after", 0, "connect after this long (e.g. 30m) instead of now; can't be combined with settings flags")
+		upf.StringVar(&upArgs.until, "until", "", `disconnect automatically at this time ("18:00", RFC 3339, or a duration like "2h")`)
+	}
 	if cmd == "login" {
 		upf.StringVar(&upArgs.profileName, "nickname", "", "short name for the account")
 	}
@@ -173,6 +181,10 @@ type upArgsT struct {
 	timeout                time.Duration
 	acceptedRisks          string
 	profileName            string
+
+	// Scheduling flags, only for "up".
+	after time.Duration
+	until string
 }
 
 func (a upArgsT) getAuthKey() (string, error) {
@@ -218,7 +230,7 @@ type upOutputJSON struct {
 }
 
 func warnf(format string, args ...any) {
-	printf("Warning: "+format+"\n", args...)
+	printf(msgs.Text("up.warning")+": "+format+"\n", args...)
 }
 
 // prefsFromUpArgs returns the ipn.Prefs for the provided args.
@@ -345,7 +357,7 @@ func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, jus
 
 	tagsChanged := !reflect.DeepEqual(curPrefs.AdvertiseTags, prefs.AdvertiseTags)
 
-	simpleUp = env.flagSet.NFlag() == 0 &&
+	simpleUp = numSettingsFlags(env.flagSet) == 0 &&
 		curPrefs.Persist != nil &&
 		curPrefs.Persist.UserProfile.LoginName != "" &&
 		env.backendState != ipn.NeedsLogin.String()
@@ -391,6 +403,31 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 		}
 	}
 
+	sched, err := wantRunningScheduleFromFlags(true, upArgs.after, upArgs.until, time.Now())
+	if err != nil {
+		return err
+	}
+	if sched != nil && !sched.After.IsZero() {
+		// Connect later: just set the schedule.
+		if numSettingsFlags(upFlagSet) > 0 {
+			return errors.New("--after can't be combined with flags that change settings")
+		}
+		if err := localClient.SetWantRunningSchedule(ctx, sched); err != nil {
+			return err
+		}
+		printWantRunningSchedule(sched)
+		return nil
+	}
+	if sched != nil {
+		defer func() {
+			if retErr == nil {
+				if retErr = localClient.SetWantRunningSchedule(ctx, sched); retErr == nil {
+					printWantRunningSchedule(sched)
+				}
+			}
+		}()
+	}
+
 	st, err := localClient.Status(ctx)
 	if err != nil {
 		return fixTailscaledConnectError(err)
@@ -433,7 +470,11 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 
 	if len(prefs.AdvertiseRoutes) > 0 {
 		if err := localClient.CheckIPForwarding(context.Background()); err != nil {
-			warnf("%v", err)
+			if effectiveGOOS() == "linux" {
+				warnf("%v\nTo have tailscaled fix this, run: tailscale set --fix-ip-forwarding", err)
+			} else {
+				warnf("%v", err)
+			}
 		}
 	}
 
@@ -445,6 +486,21 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 		// "tailscale up" should not be able to change the
 		// profile name.
 		prefs.ProfileName = curPrefs.ProfileName
+		// Nor the exit node policy, which is managed by
+		// "tailscale exit-node policy", nor the hiding of stale
+		// peers, managed by "tailscale peers prune", nor the exit
+		// node exclusions, bandwidth quota, netcheck history,
+		// keep-hot peers and allowed peers, only set by "tailscale
+		// set".
+		prefs.ExitNodePolicy = curPrefs.ExitNodePolicy
+		prefs.HideStalePeersDays = curPrefs.HideStalePeersDays
+		prefs.ExitNodeExcludeRoutes = curPrefs.ExitNodeExcludeRoutes
+		prefs.ExitNodeExcludeUIDs = curPrefs.ExitNodeExcludeUIDs
+		prefs.BandwidthQuota = curPrefs.BandwidthQuota
+		prefs.NetcheckHistoryHours = curPrefs.NetcheckHistoryHours
+		prefs.KeepHotPeers = curPrefs.KeepHotPeers
+		prefs.KeepHotIntervalSeconds = curPrefs.KeepHotIntervalSeconds
+		prefs.AllowedPeers = curPrefs.AllowedPeers
 	}
 
 	env := upCheckEnv{
@@ -518,7 +574,7 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 					if env.upArgs.json {
 						printUpDoneJSON(ipn.NeedsMachineAuth, "")
 					} else {
-						fmt.Fprintf(Stderr, "\nTo approve your machine, visit (as admin):\n\n\t%s\n\n", prefs.AdminPageURL())
+						fmt.Fprintf(Stderr, "\n%s\n\n\t%s\n\n", msgs.Text("up.approveMachine"), prefs.AdminPageURL())
 					}
 				case ipn.Running:
 					// Done full authentication process
@@ -526,7 +582,7 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 						printUpDoneJSON(ipn.Running, "")
 					} else if printed {
 						// Only need to print an update if we printed the "please click" message earlier.
-						fmt.Fprintf(Stderr, "Success.\n")
+						fmt.Fprintf(Stderr, "%s\n", msgs.Text("up.success"))
 					}
 					select {
 					case running <- true:
@@ -555,7 +611,7 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 						outln(string(data))
 					}
 				} else {
-					fmt.Fprintf(Stderr, "\nTo authenticate, visit:\n\n\t%s\n\n", *url)
+					fmt.Fprintf(Stderr, "\n%s\n\n\t%s\n\n", msgs.Text("up.authenticate"), *url)
 					if upArgs.qr {
 						q, err := qrcode.New(*url, qrcode.Medium)
 						if err != nil {
@@ -647,6 +703,9 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 // TODO(bradfitz): change the server to send typed warnings with metadata about
 // the health check, rather than just a string.
 func upWorthyWarning(s string) bool {
+	if t, ok := msgs.Translate("health.accept-routes-off", nil); ok && strings.Contains(s, t) {
+		return true
+	}
 	return strings.Contains(s, healthmsg.TailscaleSSHOnBut) ||
 		strings.Contains(s, healthmsg.WarnAcceptRoutesOff) ||
 		strings.Contains(s, healthmsg.LockedOut)
@@ -671,7 +730,7 @@ func checkUpWarnings(ctx context.Context) {
 		printf("%s\n", warn[0])
 		return
 	}
-	printf("# Health check warnings:\n")
+	printf("# %s:\n", msgs.Text("up.healthWarnings"))
 	for _, m := range warn {
 		printf("#     - %s\n", m)
 	}
@@ -716,6 +775,33 @@ func init() {
 	addPrefFlagMapping("nickname", "ProfileName")
 	addPrefFlagMapping("update-check", "AutoUpdate")
 	addPrefFlagMapping("auto-update", "AutoUpdate")
+	addPrefFlagMapping("auto-update-window", "AutoUpdate")
+	addPrefFlagMapping("auto-update-train", "AutoUpdate")
+	addPrefFlagMapping("auto-update-delay-days", "AutoUpdate")
+	addPrefFlagMapping("qos", "QoS")
+	addPrefFlagMapping("relay-daily-limit-mb", "RelayDailyLimitMB")
+	addPrefFlagMapping("metrics-port", "MetricsPort")
+	addPrefFlagMapping("metrics-on-tailnet", "MetricsOnTailnet")
+	addPrefFlagMapping("other-vpn", "OtherVPNPolicy")
+	addPrefFlagMapping("fix-ip-forwarding", "FixIPForwarding")
+	addPrefFlagMapping("nat64", "NAT64")
+	addPrefFlagMapping("4via6-site-id", "NAT64")
+	addPrefFlagMapping("bandwidth-quota-mb", "BandwidthQuota")
+	addPrefFlagMapping("bandwidth-reset-day", "BandwidthQuota")
+	addPrefFlagMapping("bandwidth-warn-percent", "BandwidthQuota")
+	addPrefFlagMapping("netcheck-history-hours", "NetcheckHistoryHours")
+	addPrefFlagMapping("keep-hot", "KeepHotPeers")
+	addPrefFlagMapping("keep-hot-interval", "KeepHotIntervalSeconds")
+	addPrefFlagMapping("allowed-peers", "AllowedPeers")
+	addPrefFlagMapping("advertise-4via6", "AdvertiseRoutes")
+	addPrefFlagMapping("dns-routes", "DNSRoutes")
+	addPrefFlagMapping("advertise-endpoints", "AdvertiseEndpoints")
+	addPrefFlagMapping("exit-node-exclude", "ExitNodeExcludeRoutes", "ExitNodeExcludeUIDs")
+	addPrefFlagMapping("proxy", "Proxy")
+	addPrefFlagMapping("proxy-bypass", "Proxy")
+	addPrefFlagMapping("proxy-pac-url", "Proxy")
+	addPrefFlagMapping("proxy-auto-detect", "Proxy")
+	addPrefFlagMapping("proxy-auth", "Proxy")
 }
 
 func addPrefFlagMapping(flagName string, prefNames ...string) {
@@ -733,12 +819,24 @@ func addPrefFlagMapping(flagName string, prefNames ...string) {
 // correspond to an ipn.Pref.
 func preflessFlag(flagName string) bool {
 	switch flagName {
-	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk":
+	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "after", "until", "proxy-user":
 		return true
 	}
 	return false
 }
 
+// numSettingsFlags returns the number of flags set in fs other than the
+// scheduling flags --after and --until.
+func numSettingsFlags(fs *flag.FlagSet) int {
+	n := 0
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "after" && f.Name != "until" {
+			n++
+		}
+	})
+	return n
+}
+
 func updateMaskedPrefsFromUpOrSetFlag(mp *ipn.MaskedPrefs, flagName string) {
 	if preflessFlag(flagName) {
 		return
@@ -881,7 +979,7 @@ func applyImplicitPrefs(prefs, oldPrefs *ipn.Prefs, env upCheckEnv) {
 
 func flagAppliesToOS(flag, goos string) bool {
 	switch flag {
-	case "netfilter-mode", "snat-subnet-routes":
+	case "netfilter-mode", "snat-subnet-routes", "fix-ip-forwarding":
 		return goos == "linux"
 	case "unattended":
 		return goos == "windows"
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+)
+
+var waitCmd = &ffcli.Command{
+	Name:       "wait",
+	ShortUsage: "wait [--for=dns,routes,peer:<name>] [--timeout=<duration>]",
+	ShortHelp:  "Wait until Tailscale is ready for dependent services",
+	LongHelp: strings.TrimSpace(`
+'tailscale wait' blocks until the given conditions hold, for boot scripts
+to run before mounting tailnet filesystems or starting services that
+depend on the tailnet, and exits non-zero if they don't hold in time.
+
+The conditions, separated by commas, are:
+
+  running       Tailscale is up (implied by all of the others)
+  routes        the tailnet's addresses and routes are applied to the OS
+  dns           as routes, and the tailnet's DNS configuration is applied;
+                fails right away if it's disabled with --accept-dns=false
+  peer:<name>   as routes, and the peer with the given name or Tailscale IP
+                is in the network map, not known to be offline, and
+                answers a disco ping
+`),
+	Exec: runWait,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("wait")
+		fs.StringVar(&waitArgs.forConds, "for", "running", "comma-separated conditions to wait for")
+		fs.DurationVar(&waitArgs.timeout, "timeout", 0, "how long to wait before failing; 0 means forever")
+		return fs
+	})(),
+}
+
+var waitArgs struct {
+	forConds string
+	timeout  time.Duration
+}
+
+func runWait(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale wait'")
+	}
+	var conds []string
+	for _, c := range strings.Split(waitArgs.forConds, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			conds = append(conds, c)
+		}
+	}
+	return localClient.Wait(ctx, conds, waitArgs.timeout)
+}
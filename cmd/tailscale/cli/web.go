@@ -18,6 +18,7 @@
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/client/web"
+	"tailscale.com/ipn"
 	"tailscale.com/util/cmpx"
 )
 
@@ -40,6 +41,7 @@
 		webf.BoolVar(&webArgs.cgi, "cgi", false, "run as CGI script")
 		webf.BoolVar(&webArgs.dev, "dev", false, "run web client in developer mode [this flag is in development, use is unsupported]")
 		webf.StringVar(&webArgs.prefix, "prefix", "", "URL prefix added to requests (for cgi or reverse proxies)")
+		webf.BoolVar(&webArgs.fleet, "fleet", false, "serve a dashboard of the tailnet's nodes' versions, health and key expiries, for those that grant this node the fleet-status capability")
 		return webf
 	})(),
 	Exec: runWeb,
@@ -50,6 +52,7 @@
 	cgi    bool
 	dev    bool
 	prefix string
+	fleet  bool
 }
 
 func tlsConfigFromEnvironment() *tls.Config {
@@ -80,12 +83,18 @@ func runWeb(ctx context.Context, args []string) error {
 		return fmt.Errorf("too many non-flag arguments: %q", args)
 	}
 
-	webServer, cleanup := web.NewServer(ctx, web.ServerOpts{
+	// Attribute pref changes made in the web client to it, not the CLI.
+	localClient.PrefSource = ipn.PrefSourceWeb
+	webServer, cleanup, err := web.NewServer(ctx, web.ServerOpts{
 		DevMode:     webArgs.dev,
 		CGIMode:     webArgs.cgi,
 		PathPrefix:  webArgs.prefix,
+		FleetMode:   webArgs.fleet,
 		LocalClient: &localClient,
 	})
+	if err != nil {
+		return err
+	}
 	defer cleanup()
 
 	if webArgs.cgi {
@@ -13,6 +13,7 @@
 import (
 	"context"
 	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
@@ -32,8 +33,12 @@
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
+	"tailscale.com/health/healthhook"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnserver"
+	"tailscale.com/ipn/readyfile"
 	"tailscale.com/ipn/store"
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
@@ -56,8 +61,11 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/fatal"
+	"tailscale.com/util/memprofile"
 	"tailscale.com/util/multierr"
 	"tailscale.com/util/osshare"
+	"tailscale.com/util/systemd"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
@@ -129,15 +137,26 @@ func defaultPort() uint16 {
 
 	cleanup        bool
 	debug          string
+	debugPeerAPI   bool // serve the debug handlers over the PeerAPI too
 	port           uint16
 	statepath      string
 	statedir       string
+	encryptState   string // key store to encrypt the state file with; empty means none
 	socketpath     string
 	birdSocketPath string
 	verbose        int
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
 	disableLogs    bool
+	logSink        string // where to send logs instead of Tailscale; see logpolicy.SetLogSink
+	flowLogFile    string // file to append inbound flows to, as JSON lines
+	flowLogSyslog  bool   // whether to send inbound flows to syslog
+	confFile       string // path to declarative config file; empty means none
+	healthWebhook  string // local URL to POST health changes to; empty means none
+	healthExec     string // program to run on health changes; empty means none
+	fatalStatus    string // file to write a fatal.Report to before exiting on error; empty means none
+	readyFile      string // file to write a readyfile.Doc to once running; empty means none
+	memoryProfile  string // memprofile.Profile to apply; empty means default
 }
 
 var (
@@ -153,7 +172,58 @@ func defaultPort() uint16 {
 	"be-child":                &beChildFunc,
 }
 
-var beCLI func() // non-nil if CLI is linked in
+// Entrypoints other than tailscaled's own that can be linked into the
+// tailscaled binary, busybox-style, to save flash space on small devices
+// by sharing one copy of the runtime and common packages. They're selected
+// by the binary's name (typically a symlink to tailscaled) or by the first
+// argument, as in "tailscaled tailscale status".
+//
+// To build such a binary, as small as possible:
+//
+//	./build_dist.sh --extra-small --box ./cmd/tailscaled
+//
+// --box adds the ts_include_cli build tag, and --extra-small strips symbols
+// (-ldflags "-s -w") and drops optional features with the ts_omit_* tags,
+// leaving the linker's dead code elimination to remove them. For container
+// images, the ts_include_containerboot tag (Linux only) also links in
+// containerboot, which then finds tailscaled and tailscale as symlinks to
+// the same binary in $PATH.
+var (
+	beCLI           func(args []string) // non-nil if CLI is linked in
+	beContainerboot func()              // non-nil if containerboot is linked in
+)
+
+// entrypoint returns the linked-in entrypoint named name, which may have an
+// ".exe" suffix, or nil if there isn't one.
+func entrypoint(name string) func(args []string) {
+	switch strings.TrimSuffix(name, ".exe") {
+	case "tailscale":
+		return beCLI
+	case "containerboot":
+		if beContainerboot != nil {
+			return func([]string) { beContainerboot() }
+		}
+	}
+	return nil
+}
+
+// findEntrypoint returns the linked-in entrypoint that the command line
+// argv asks for, by the name the binary was run as or by its first
+// argument, along with the arguments to pass it. It returns nil if argv
+// is for tailscaled itself.
+func findEntrypoint(argv []string) (f func(args []string), args []string) {
+	if len(argv) > 0 {
+		if f := entrypoint(filepath.Base(argv[0])); f != nil {
+			return f, argv[1:]
+		}
+	}
+	if len(argv) > 1 {
+		if f := entrypoint(argv[1]); f != nil {
+			return f, argv[2:]
+		}
+	}
+	return nil, nil
+}
 
 func main() {
 	envknob.PanicIfAnyEnvCheckedInInit()
@@ -163,19 +233,30 @@ func main() {
 	flag.IntVar(&args.verbose, "verbose", 0, "log verbosity level; 0 is default, 1 or higher are increasingly verbose")
 	flag.BoolVar(&args.cleanup, "cleanup", false, "clean up system state and exit")
 	flag.StringVar(&args.debug, "debug", "", "listen address ([ip]:port) of optional debug server")
+	flag.BoolVar(&args.debugPeerAPI, "debug-peerapi", false, "serve the debug server's handlers (pprof, vars, metrics, magicsock) over the tailnet at the PeerAPI's /v0/debug/ to peers allowed to debug this node")
 	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
 	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
 	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
 	flag.StringVar(&args.statedir, "statedir", "", "path to directory for storage of config state, TLS certs, temporary incoming Taildrop files, etc. If empty, it's derived from --state when possible.")
+	flag.StringVar(&args.encryptState, "encrypt-state", "", `optional key store with which to encrypt the state file, including the node's private keys: "dpapi" (Windows), "keychain" (macOS) or "passphrase:/path/to/passphrase-file"; an unencrypted state file is encrypted in place`)
 	flag.StringVar(&args.socketpath, "socket", paths.DefaultTailscaledSocket(), "path of the service unix socket")
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
-
-	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
-		beCLI()
+	flag.StringVar(&args.logSink, "log-sink", "", `where to send logs instead of Tailscale: "file:/abs/path", "syslog" or "none"; any of these also implies --no-logs-no-support`)
+	flag.StringVar(&args.flowLogFile, "flow-log-file", "", "optional path of a file to append the flows peers start to this node to, as JSON lines (see 'tailscale flow-log')")
+	flag.BoolVar(&args.flowLogSyslog, "flow-log-syslog", false, "send the flows peers start to this node to syslog (see 'tailscale flow-log')")
+	flag.StringVar(&args.confFile, "config", "", "path to a declarative HuJSON config file; tailscaled applies it at startup and whenever it changes")
+	flag.StringVar(&args.healthWebhook, "health-webhook", "", `optional localhost URL (e.g. "http://localhost:9000/health") to POST JSON to whenever a health problem starts or ends`)
+	flag.StringVar(&args.healthExec, "health-exec", "", "optional path of a program to run, with the change as JSON on stdin, whenever a health problem starts or ends")
+	flag.StringVar(&args.fatalStatus, "fatal-status-file", "", "optional path of a file to write the cause of a fatal error to, as JSON, before exiting; it's removed at startup")
+	flag.StringVar(&args.memoryProfile, "memory-profile", "", `memory profile: "low" to use as little memory as possible (for devices with 64-128MB of RAM), "default", or "throughput" to trade memory for speed; sets GOGC and GOMEMLIMIT unless they're in the environment`)
+	flag.StringVar(&args.readyFile, "ready-file", "", "optional path of a file to write the backend state, hostname and Tailscale IPs to, as JSON, once running and whenever they change; it's removed at startup and shutdown")
+
+	if f, args := findEntrypoint(os.Args); f != nil {
+		f(args)
 		return
 	}
 
@@ -195,10 +276,16 @@ func main() {
 	}
 
 	flag.Parse()
+	if err := fatal.ClearStatusFile(args.fatalStatus); err != nil {
+		log.Printf("clearing fatal status file: %v", err)
+	}
+	if err := readyfile.Clear(args.readyFile); err != nil {
+		log.Printf("clearing ready file: %v", err)
+	}
 	if flag.NArg() > 0 {
 		// Windows subprocess is spawned with /subprocess, so we need to avoid this check there.
 		if runtime.GOOS != "windows" || (flag.Arg(0) != "/subproc" && flag.Arg(0) != "/firewall") {
-			log.Fatalf("tailscaled does not take non-flag arguments: %q", flag.Args())
+			exitFatal(fatal.Errorf(fatal.CauseUsage, "tailscaled does not take non-flag arguments: %q", flag.Args()))
 		}
 	}
 
@@ -212,18 +299,15 @@ func main() {
 	}
 
 	if runtime.GOOS == "darwin" && os.Getuid() != 0 && !strings.Contains(args.tunname, "userspace-networking") && !args.cleanup {
-		log.SetFlags(0)
-		log.Fatalf("tailscaled requires root; use sudo tailscaled (or use --tun=userspace-networking)")
+		exitFatal(fatal.Errorf(fatal.CausePermission, "tailscaled requires root; use sudo tailscaled (or use --tun=userspace-networking)"))
 	}
 
 	if args.socketpath == "" && runtime.GOOS != "windows" {
-		log.SetFlags(0)
-		log.Fatalf("--socket is required")
+		exitFatal(fatal.Errorf(fatal.CauseUsage, "--socket is required"))
 	}
 
 	if args.birdSocketPath != "" && createBIRDClient == nil {
-		log.SetFlags(0)
-		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
+		exitFatal(fatal.Errorf(fatal.CauseUsage, "--bird-socket is not supported on %s", runtime.GOOS))
 	}
 
 	// Only apply a default statepath when neither have been provided, so that a
@@ -235,6 +319,22 @@ func main() {
 	if args.disableLogs {
 		envknob.SetNoLogsNoSupport()
 	}
+	if args.logSink != "" && args.logSink != "tailscale" {
+		if err := logpolicy.SetLogSink(args.logSink); err != nil {
+			exitFatal(fatal.Errorf(fatal.CauseUsage, "--log-sink: %w", err))
+		}
+		// Logs that Tailscale can't see mean no support, as with
+		// --no-logs-no-support, which also turns off other uploads.
+		envknob.SetNoLogsNoSupport()
+	}
+
+	if args.memoryProfile != "" {
+		p, err := memprofile.Parse(args.memoryProfile)
+		if err != nil {
+			exitFatal(fatal.Errorf(fatal.CauseUsage, "--memory-profile: %w", err))
+		}
+		memprofile.Apply(p)
+	}
 
 	if beWindowsSubprocess() {
 		return
@@ -246,10 +346,16 @@ func main() {
 	osshare.SetFileSharingEnabled(false, logger.Discard)
 
 	if err != nil {
-		log.Fatal(err)
+		exitFatal(err)
 	}
 }
 
+// exitFatal reports err, with its fatal.Cause, and exits.
+func exitFatal(err error) {
+	log.SetFlags(0)
+	fatal.Exit(err, args.fatalStatus)
+}
+
 func trySynologyMigration(p string) error {
 	if runtime.GOOS != "linux" || distro.Get() != distro.Synology {
 		return nil
@@ -302,6 +408,34 @@ type serverOptions struct {
 	LoginFlags controlclient.LoginFlags
 }
 
+// newStateStore returns the state store for the --state and --encrypt-state
+// flags.
+func newStateStore(logf logger.Logf) (ipn.StateStore, error) {
+	path := statePathOrDefault()
+	if args.encryptState == "" {
+		st, err := store.New(logf, path)
+		if err != nil {
+			return nil, fmt.Errorf("store.New: %w", err)
+		}
+		return st, nil
+	}
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("--encrypt-state requires --state to be an absolute file path, not %q", path)
+	}
+	ks, err := store.ParseKeyStore(args.encryptState)
+	if err != nil {
+		return nil, fmt.Errorf("--encrypt-state: %w", err)
+	}
+	if runtime.GOOS == "windows" {
+		path = store.TryWindowsAppDataMigration(logf, path)
+	}
+	st, err := store.NewEncryptedFileStore(logf, path, ks)
+	if err != nil {
+		return nil, fmt.Errorf("store.NewEncryptedFileStore: %w", err)
+	}
+	return st, nil
+}
+
 func ipnServerOpts() (o serverOptions) {
 	goos := envknob.GOOS()
 
@@ -340,11 +474,19 @@ func run() error {
 
 	sys := new(tsd.System)
 
+	if args.confFile != "" {
+		conf, err := conffile.Load(args.confFile)
+		if err != nil {
+			return fatal.New(fatal.CauseConfig, err)
+		}
+		sys.InitialConfig = conf
+	}
+
 	netMon, err := netmon.New(func(format string, args ...any) {
 		logf(format, args...)
 	})
 	if err != nil {
-		return fmt.Errorf("netmon.New: %w", err)
+		return fatal.New(fatal.CauseNetwork, fmt.Errorf("netmon.New: %w", err))
 	}
 	sys.Set(netMon)
 
@@ -387,25 +529,44 @@ func run() error {
 	}
 
 	if args.statepath == "" && args.statedir == "" {
-		log.Fatalf("--statedir (or at least --state) is required")
+		return fatal.Errorf(fatal.CauseUsage, "--statedir (or at least --state) is required")
 	}
 	if err := trySynologyMigration(statePathOrDefault()); err != nil {
 		log.Printf("error in synology migration: %v", err)
 	}
 
-	if args.debug != "" {
+	if args.debug != "" || args.debugPeerAPI {
 		debugMux = newDebugMux()
 	}
 
+	if args.healthWebhook != "" || args.healthExec != "" {
+		stop, err := healthhook.Start(logf, healthhook.Config{
+			URL:  args.healthWebhook,
+			Exec: args.healthExec,
+		})
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
 	return startIPNServer(context.Background(), logf, pol.PublicID, sys)
 }
 
 var sigPipe os.Signal // set by sigpipe.go
 
 func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID, sys *tsd.System) error {
-	ln, err := safesocket.Listen(args.socketpath)
+	ln, err := systemd.Listener("tailscaled.sock")
 	if err != nil {
-		return fmt.Errorf("safesocket.Listen: %v", err)
+		return fatal.Errorf(fatal.CauseListen, "systemd socket activation: %v", err)
+	}
+	if ln != nil {
+		logf("using LocalAPI socket from systemd socket activation")
+	} else {
+		ln, err = safesocket.Listen(args.socketpath)
+		if err != nil {
+			return fatal.Errorf(fatal.CauseListen, "safesocket.Listen: %v", err)
+		}
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -452,6 +613,9 @@ func startIPNServer(ctx context.Context, logf logger.Logf, logID logid.PublicID,
 		if err == nil {
 			logf("got LocalBackend in %v", time.Since(t0).Round(time.Millisecond))
 			srv.SetLocalBackend(lb)
+			if args.readyFile != "" {
+				go readyfile.Run(ctx, logf, lb, args.readyFile)
+			}
 			return
 		}
 		lbErr.Store(err) // before the following cancel
@@ -477,20 +641,25 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		logPol.Logtail.SetNetMon(sys.NetMon.Get())
 	}
 
-	socksListener, httpProxyListener := mustStartProxyListeners(args.socksAddr, args.httpProxyAddr)
+	socksListener, httpProxyListener, err := startProxyListeners(args.socksAddr, args.httpProxyAddr)
+	if err != nil {
+		return nil, err
+	}
 
 	dialer := &tsdial.Dialer{Logf: logf} // mutated below (before used)
 	sys.Set(dialer)
 
 	onlyNetstack, err := createEngine(logf, sys)
 	if err != nil {
-		return nil, fmt.Errorf("createEngine: %w", err)
+		return nil, fatal.New(fatal.CauseNetwork, fmt.Errorf("createEngine: %w", err))
 	}
 	if debugMux != nil {
 		if ms, ok := sys.MagicSock.GetOK(); ok {
 			debugMux.HandleFunc("/debug/magicsock", ms.ServeHTTPDebug)
 		}
-		go runDebugServer(debugMux, args.debug)
+		if args.debug != "" {
+			go runDebugServer(debugMux, args.debug)
+		}
 	}
 
 	ns, err := newNetstack(logf, sys)
@@ -509,13 +678,16 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 			return ns.DialContextTCP(ctx, dst)
 		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			return ns.DialContextUDP(ctx, dst)
+		}
 	}
 	if socksListener != nil || httpProxyListener != nil {
 		var addrs []string
 		if httpProxyListener != nil {
 			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
 			go func() {
-				log.Fatalf("HTTP proxy exited: %v", hs.Serve(httpProxyListener))
+				exitFatal(fatal.Errorf(fatal.CauseListen, "HTTP proxy exited: %w", hs.Serve(httpProxyListener)))
 			}()
 			addrs = append(addrs, httpProxyListener.Addr().String())
 		}
@@ -525,7 +697,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 				Dialer: dialer.UserDial,
 			}
 			go func() {
-				log.Fatalf("SOCKS5 server exited: %v", ss.Serve(socksListener))
+				exitFatal(fatal.Errorf(fatal.CauseListen, "SOCKS5 server exited: %w", ss.Serve(socksListener)))
 			}()
 			addrs = append(addrs, socksListener.Addr().String())
 		}
@@ -534,9 +706,9 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 
 	opts := ipnServerOpts()
 
-	store, err := store.New(logf, statePathOrDefault())
+	store, err := newStateStore(logf)
 	if err != nil {
-		return nil, fmt.Errorf("store.New: %w", err)
+		return nil, fatal.New(fatal.CauseConfig, err)
 	}
 	sys.Set(store)
 
@@ -545,6 +717,14 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 		return nil, fmt.Errorf("ipnlocal.NewLocalBackend: %w", err)
 	}
 	lb.SetVarRoot(opts.VarRoot)
+	if args.flowLogFile != "" || args.flowLogSyslog {
+		if err := lb.SetFlowLogExport(args.flowLogFile, args.flowLogSyslog); err != nil {
+			return nil, fatal.Errorf(fatal.CauseConfig, "exporting flow log: %w", err)
+		}
+	}
+	if args.debugPeerAPI {
+		lb.SetPeerAPIDebugHandler(debugMux)
+	}
 	if logPol != nil {
 		lb.SetLogFlusher(logPol.Logtail.StartFlush)
 	}
@@ -556,7 +736,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logID logid.PublicID
 	})
 	configureTaildrop(logf, lb)
 	if err := ns.Start(lb); err != nil {
-		log.Fatalf("failed to start netstack: %v", err)
+		return nil, fatal.Errorf(fatal.CauseNetwork, "failed to start netstack: %w", err)
 	}
 	return lb, nil
 }
@@ -688,6 +868,7 @@ func tryEngine(logf logger.Logf, sys *tsd.System, name string) (onlyNetstack boo
 func newDebugMux() *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/debug/metrics", servePrometheusMetrics)
+	mux.Handle("/debug/vars", expvar.Handler())
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
 	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
 	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
@@ -708,7 +889,7 @@ func runDebugServer(mux *http.ServeMux, addr string) {
 		Handler: mux,
 	}
 	if err := srv.ListenAndServe(); err != nil {
-		log.Fatal(err)
+		exitFatal(fatal.Errorf(fatal.CauseListen, "debug server: %w", err))
 	}
 }
 
@@ -716,7 +897,7 @@ func newNetstack(logf logger.Logf, sys *tsd.System) (*netstack.Impl, error) {
 	return netstack.Create(logf, sys.Tun.Get(), sys.Engine.Get(), sys.MagicSock.Get(), sys.Dialer.Get(), sys.DNSManager.Get())
 }
 
-// mustStartProxyListeners creates listeners for local SOCKS and HTTP
+// startProxyListeners creates listeners for local SOCKS and HTTP
 // proxies, if the respective addresses are not empty. socksAddr and
 // httpAddr can be the same, in which case socksListener will receive
 // connections that look like they're speaking SOCKS and httpListener
@@ -724,20 +905,20 @@ func newNetstack(logf logger.Logf, sys *tsd.System) (*netstack.Impl, error) {
 //
 // socksListener and httpListener can be nil, if their respective
 // addrs are empty.
-func mustStartProxyListeners(socksAddr, httpAddr string) (socksListener, httpListener net.Listener) {
+func startProxyListeners(socksAddr, httpAddr string) (socksListener, httpListener net.Listener, err error) {
 	if socksAddr == httpAddr && socksAddr != "" && !strings.HasSuffix(socksAddr, ":0") {
 		ln, err := net.Listen("tcp", socksAddr)
 		if err != nil {
-			log.Fatalf("proxy listener: %v", err)
+			return nil, nil, fatal.Errorf(fatal.CauseListen, "proxy listener: %w", err)
 		}
-		return proxymux.SplitSOCKSAndHTTP(ln)
+		socksListener, httpListener = proxymux.SplitSOCKSAndHTTP(ln)
+		return socksListener, httpListener, nil
 	}
 
-	var err error
 	if socksAddr != "" {
 		socksListener, err = net.Listen("tcp", socksAddr)
 		if err != nil {
-			log.Fatalf("SOCKS5 listener: %v", err)
+			return nil, nil, fatal.Errorf(fatal.CauseListen, "SOCKS5 listener: %w", err)
 		}
 		if strings.HasSuffix(socksAddr, ":0") {
 			// Log kernel-selected port number so integration tests
@@ -748,7 +929,10 @@ func mustStartProxyListeners(socksAddr, httpAddr string) (socksListener, httpLis
 	if httpAddr != "" {
 		httpListener, err = net.Listen("tcp", httpAddr)
 		if err != nil {
-			log.Fatalf("HTTP proxy listener: %v", err)
+			if socksListener != nil {
+				socksListener.Close()
+			}
+			return nil, nil, fatal.Errorf(fatal.CauseListen, "HTTP proxy listener: %w", err)
 		}
 		if strings.HasSuffix(httpAddr, ":0") {
 			// Log kernel-selected port number so integration tests
@@ -757,7 +941,7 @@ func mustStartProxyListeners(socksAddr, httpAddr string) (socksListener, httpLis
 		}
 	}
 
-	return socksListener, httpListener
+	return socksListener, httpListener, nil
 }
 
 var beChildFunc = beChild
@@ -4,6 +4,8 @@
 package main // import "tailscale.com/cmd/tailscaled"
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 
 	"tailscale.com/tstest/deptest"
@@ -32,3 +34,56 @@ func TestDeps(t *testing.T) {
 		},
 	}.Check(t)
 }
+
+func TestFindEntrypoint(t *testing.T) {
+	defer func(cli func([]string), cb func()) {
+		beCLI, beContainerboot = cli, cb
+	}(beCLI, beContainerboot)
+
+	var ran string // name of the entrypoint that ran
+	linkCLI := func([]string) { ran = "tailscale" }
+	linkContainerboot := func() { ran = "containerboot" }
+
+	tests := []struct {
+		argv     string
+		noCLI    bool // CLI not linked in
+		noBoot   bool // containerboot not linked in
+		want     string
+		wantArgs []string
+	}{
+		{argv: "tailscaled", want: ""},
+		{argv: "/usr/sbin/tailscaled --state=mem:", want: ""},
+		{argv: "tailscaled be-child ssh", want: ""},
+		{argv: "/usr/bin/tailscale status --json", want: "tailscale", wantArgs: []string{"status", "--json"}},
+		{argv: "tailscale.exe up", want: "tailscale", wantArgs: []string{"up"}},
+		{argv: "tailscaled tailscale status", want: "tailscale", wantArgs: []string{"status"}},
+		{argv: "tailscaled.exe tailscale.exe version", want: "tailscale", wantArgs: []string{"version"}},
+		{argv: "/usr/local/bin/containerboot", want: "containerboot", wantArgs: []string{}},
+		{argv: "tailscaled containerboot", want: "containerboot", wantArgs: []string{}},
+		{argv: "tailscale status", noCLI: true, want: ""},
+		{argv: "tailscaled tailscale status", noCLI: true, want: ""},
+		{argv: "containerboot", noBoot: true, want: ""},
+		{argv: "tailscaled containerboot", noBoot: true, want: ""},
+	}
+	for _, tt := range tests {
+		beCLI, beContainerboot = linkCLI, linkContainerboot
+		if tt.noCLI {
+			beCLI = nil
+		}
+		if tt.noBoot {
+			beContainerboot = nil
+		}
+		ran = ""
+		f, args := findEntrypoint(strings.Fields(tt.argv))
+		if f != nil {
+			f(args)
+		}
+		if ran != tt.want {
+			t.Errorf("findEntrypoint(%q) ran %q; want %q", tt.argv, ran, tt.want)
+			continue
+		}
+		if tt.want != "" && !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("findEntrypoint(%q) args = %q; want %q", tt.argv, args, tt.wantArgs)
+		}
+	}
+}
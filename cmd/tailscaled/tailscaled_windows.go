@@ -50,6 +50,7 @@
 	"tailscale.com/tsd"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
+	"tailscale.com/util/fatal"
 	"tailscale.com/util/osdiag"
 	"tailscale.com/util/winutil"
 	"tailscale.com/version"
@@ -301,14 +302,14 @@ func beWindowsSubprocess() bool {
 	sys := new(tsd.System)
 	netMon, err := netmon.New(log.Printf)
 	if err != nil {
-		log.Fatalf("Could not create netMon: %v", err)
+		exitFatal(fatal.Errorf(fatal.CauseNetwork, "Could not create netMon: %w", err))
 	}
 	sys.Set(netMon)
 
 	publicLogID, _ := logid.ParsePublicID(logID)
 	err = startIPNServer(ctx, log.Printf, publicLogID, sys)
 	if err != nil {
-		log.Fatalf("ipnserver: %v", err)
+		exitFatal(fmt.Errorf("ipnserver: %w", err))
 	}
 	return true
 }
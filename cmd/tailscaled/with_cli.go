@@ -13,8 +13,7 @@
 )
 
 func init() {
-	beCLI = func() {
-		args := os.Args[1:]
+	beCLI = func(args []string) {
 		if err := cli.Run(args); err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -0,0 +1,12 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux && ts_include_containerboot
+
+package main
+
+import "tailscale.com/cmd/containerboot/boot"
+
+func init() {
+	beContainerboot = boot.Main
+}
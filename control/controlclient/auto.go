@@ -53,12 +53,15 @@ func (c *Auto) waitUnpause(routineLogName string) (keepRunning bool) {
 // our local state. It runs in its own goroutine.
 func (c *Auto) updateRoutine() {
 	defer close(c.updateDone)
-	bo := backoff.NewBackoff("updateRoutine", c.logf, 30*time.Second)
+	bo := backoff.NewComponentBackoff(backoff.Control, "updateRoutine", c.logf, 30*time.Second)
 
 	// lastUpdateGenInformed is the value of lastUpdateAt that we've successfully
 	// informed the server of.
 	var lastUpdateGenInformed updateGen
 
+	// lastSent is when we last sent an update, successfully or not.
+	var lastSent time.Time
+
 	for {
 		if !c.waitUnpause("updateRoutine") {
 			c.logf("updateRoutine: exiting")
@@ -80,7 +83,28 @@ func (c *Auto) updateRoutine() {
 			}
 		}
 
+		if d := updateDelay(lastSent, c.clock.Now(), c.minUpdateInterval); d > 0 {
+			// Wait out the minimum interval, batching any further
+			// changes made meanwhile into the same update.
+			metricLiteUpdateRateLimit.Add(1)
+			tc, ch := c.clock.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				tc.Stop()
+			case <-ch:
+			}
+			continue
+		}
+
+		c.mu.Lock()
+		if c.pendingChanges > 1 {
+			metricLiteUpdateBatched.Add(int64(c.pendingChanges - 1))
+		}
+		c.pendingChanges = 0
+		c.mu.Unlock()
+
 		t0 := c.clock.Now()
+		lastSent = t0
 		err := c.direct.SendUpdate(ctx)
 		d := time.Since(t0).Round(time.Millisecond)
 		if err != nil {
@@ -105,6 +129,16 @@ func nextUpdateGen() updateGen {
 	return updateGen(atomicGen.Add(1))
 }
 
+// updateDelay returns how long to wait, at now, before sending an update to
+// the server, given that the last one was sent at lastSent (or never, if
+// zero) and that updates must be at least minInterval apart.
+func updateDelay(lastSent, now time.Time, minInterval time.Duration) time.Duration {
+	if lastSent.IsZero() || minInterval <= 0 {
+		return 0
+	}
+	return max(0, lastSent.Add(minInterval).Sub(now))
+}
+
 // updateGen is a monotonically increasing number that represents a particular
 // update to the local state.
 type updateGen int64
@@ -122,6 +156,8 @@ type Auto struct {
 
 	unregisterHealthWatch func()
 
+	minUpdateInterval time.Duration // min time between updates; see Options.MinUpdateInterval
+
 	mu sync.Mutex // mutex guards the following fields
 
 	wantLoggedIn bool   // whether the user wants to be logged in per last method call
@@ -132,6 +168,10 @@ type Auto struct {
 	// the server.
 	lastUpdateGen updateGen
 
+	// pendingChanges is the number of changes made since the last update
+	// sent to the server.
+	pendingChanges int
+
 	paused         bool        // whether we should stop making HTTP requests
 	unpauseWaiters []chan bool // chans that gets sent true (once) on wake, or false on Shutdown
 	loggedIn       bool        // true if currently logged in
@@ -178,15 +218,19 @@ func NewNoStart(opts Options) (_ *Auto, err error) {
 	if opts.Clock == nil {
 		opts.Clock = tstime.StdClock{}
 	}
+	if opts.MinUpdateInterval == 0 {
+		opts.MinUpdateInterval = envMinUpdateInterval()
+	}
 	c := &Auto{
-		direct:     direct,
-		clock:      opts.Clock,
-		logf:       opts.Logf,
-		updateCh:   make(chan struct{}, 1),
-		authDone:   make(chan struct{}),
-		mapDone:    make(chan struct{}),
-		updateDone: make(chan struct{}),
-		observer:   opts.Observer,
+		direct:            direct,
+		clock:             opts.Clock,
+		logf:              opts.Logf,
+		updateCh:          make(chan struct{}, 1),
+		minUpdateInterval: opts.MinUpdateInterval,
+		authDone:          make(chan struct{}),
+		mapDone:           make(chan struct{}),
+		updateDone:        make(chan struct{}),
+		observer:          opts.Observer,
 	}
 	c.authCtx, c.authCancel = context.WithCancel(context.Background())
 	c.authCtx = sockstats.WithSockStats(c.authCtx, sockstats.LabelControlClientAuto, opts.Logf)
@@ -244,7 +288,9 @@ func (c *Auto) updateControl() {
 		return
 	}
 	c.lastUpdateGen = gen
+	c.pendingChanges++
 	c.mu.Unlock()
+	metricLiteUpdateChanges.Add(1)
 
 	select {
 	case c.updateCh <- struct{}{}:
@@ -304,7 +350,7 @@ func (c *Auto) restartMap() {
 
 func (c *Auto) authRoutine() {
 	defer close(c.authDone)
-	bo := backoff.NewBackoff("authRoutine", c.logf, 30*time.Second)
+	bo := backoff.NewComponentBackoff(backoff.Control, "authRoutine", c.logf, 30*time.Second)
 
 	for {
 		if !c.waitUnpause("authRoutine") {
@@ -459,7 +505,7 @@ func (c *Auto) mapRoutine() {
 	defer close(c.mapDone)
 	mrs := &mapRoutineState{
 		c:  c,
-		bo: backoff.NewBackoff("mapRoutine", c.logf, 30*time.Second),
+		bo: backoff.NewComponentBackoff(backoff.Control, "mapRoutine", c.logf, 30*time.Second),
 	}
 
 	for {
@@ -659,6 +705,20 @@ func (c *Auto) UpdateEndpoints(endpoints []tailcfg.Endpoint) {
 	}
 }
 
+// ResetConnections closes the connections to the control server and
+// restarts the map poll over a new one.
+func (c *Auto) ResetConnections() {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return
+	}
+	c.logf("resetting control connections")
+	c.direct.resetConnections()
+	c.restartMap()
+}
+
 func (c *Auto) Shutdown() {
 	c.logf("client.Shutdown()")
 
@@ -72,6 +72,10 @@ type Client interface {
 	// in a separate http request. It has nothing to do with the rest of
 	// the state machine.
 	UpdateEndpoints(endpoints []tailcfg.Endpoint)
+	// ResetConnections closes the client's connections to the control
+	// server so that subsequent requests dial new ones, picking up
+	// changes such as a new outbound proxy configuration.
+	ResetConnections()
 }
 
 // UserVisibleError is an error that should be shown to users.
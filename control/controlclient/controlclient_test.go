@@ -6,6 +6,7 @@
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func fieldsOf(t reflect.Type) (fields []string) {
@@ -62,3 +63,24 @@ func TestStatusEqual(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateDelay(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name     string
+		lastSent time.Time
+		min      time.Duration
+		want     time.Duration
+	}{
+		{"never_sent", time.Time{}, 2 * time.Second, 0},
+		{"no_min", now.Add(-time.Millisecond), -1, 0},
+		{"too_soon", now.Add(-500 * time.Millisecond), 2 * time.Second, 1500 * time.Millisecond},
+		{"just_now", now, 2 * time.Second, 2 * time.Second},
+		{"long_ago", now.Add(-time.Minute), 2 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		if got := updateDelay(tt.lastSent, now, tt.min); got != tt.want {
+			t.Errorf("%s: updateDelay = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -151,8 +151,20 @@ type Options struct {
 	// If we receive a new DialPlan from the server, this value will be
 	// updated.
 	DialPlan ControlDialPlanner
+
+	// MinUpdateInterval is the minimum time between the updates of
+	// endpoints, Hostinfo and NetInfo that Auto sends to the control
+	// server. Changes made sooner are batched into the next update, so
+	// that a flapping network interface can't send a storm of them.
+	// Zero means the TS_CONTROL_MIN_UPDATE_INTERVAL environment variable,
+	// if set, or else no minimum, so that updates such as the endpoints
+	// discovered at startup reach peers without delay. Negative means no
+	// minimum.
+	MinUpdateInterval time.Duration
 }
 
+var envMinUpdateInterval = envknob.RegisterDuration("TS_CONTROL_MIN_UPDATE_INTERVAL")
+
 // ControlDialPlanner is the interface optionally supplied when creating a
 // control client to control exactly how TCP connections to the control plane
 // are dialed.
@@ -240,6 +252,7 @@ func NewDirect(opts Options) (*Direct, error) {
 		tr.TLSClientConfig = tlsdial.Config(serverURL.Hostname(), tr.TLSClientConfig)
 		tr.DialContext = dnscache.Dialer(opts.Dialer.SystemDial, dnsCache)
 		tr.DialTLSContext = dnscache.TLSDialer(opts.Dialer.SystemDial, dnsCache, tr.TLSClientConfig)
+		tshttpproxy.SetTransportProxyDialer(tr)
 		tr.ForceAttemptHTTP2 = true
 		// Disable implicit gzip compression; the various
 		// handlers (register, map, set-dns, etc) do their own
@@ -300,6 +313,20 @@ func (c *Direct) Close() error {
 	return nil
 }
 
+// resetConnections closes the current Noise connections and any idle
+// HTTP connections to the control server. A new Noise client is created
+// on next use.
+func (c *Direct) resetConnections() {
+	c.mu.Lock()
+	nc := c.noiseClient
+	c.noiseClient = nil
+	c.mu.Unlock()
+	if nc != nil {
+		nc.Close()
+	}
+	c.httpc.CloseIdleConnections()
+}
+
 // SetHostinfo clones the provided Hostinfo and remembers it for the
 // next update. It reports whether the Hostinfo has changed.
 func (c *Direct) SetHostinfo(hi *tailcfg.Hostinfo) bool {
@@ -1826,7 +1853,11 @@ func decodeWrappedAuthkey(key string, logf logger.Logf) (authKey string, isWrapp
 
 	metricMapRequests     = clientmetric.NewCounter("controlclient_map_requests")
 	metricMapRequestsLite = clientmetric.NewCounter("controlclient_map_requests_lite")
-	metricMapRequestsPoll = clientmetric.NewCounter("controlclient_map_requests_poll")
+
+	metricLiteUpdateChanges   = clientmetric.NewCounter("controlclient_lite_update_changes")   // changes to endpoints, Hostinfo, etc to send
+	metricLiteUpdateBatched   = clientmetric.NewCounter("controlclient_lite_update_batched")   // changes sent in the same update as an earlier one
+	metricLiteUpdateRateLimit = clientmetric.NewCounter("controlclient_lite_update_ratelimit") // updates delayed by MinUpdateInterval
+	metricMapRequestsPoll     = clientmetric.NewCounter("controlclient_map_requests_poll")
 
 	metricMapResponseMessages   = clientmetric.NewCounter("controlclient_map_response_message") // any message type
 	metricMapResponsePings      = clientmetric.NewCounter("controlclient_map_response_ping")
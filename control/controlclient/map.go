@@ -79,6 +79,7 @@ type mapSession struct {
 	lastPacketFilterRules  views.Slice[tailcfg.FilterRule]
 	lastParsedPacketFilter []filter.Match
 	lastSSHPolicy          *tailcfg.SSHPolicy
+	lastSSHHostCerts       *tailcfg.SSHHostCerts
 	collectServices        bool
 	lastDomain             string
 	lastDomainAuditLogID   string
@@ -269,6 +270,9 @@ func (ms *mapSession) updateStateFromResponse(resp *tailcfg.MapResponse) {
 	if p := resp.SSHPolicy; p != nil {
 		ms.lastSSHPolicy = p
 	}
+	if c := resp.SSHHostCerts; c != nil {
+		ms.lastSSHHostCerts = c
+	}
 
 	if v, ok := resp.CollectServices.Get(); ok {
 		ms.collectServices = v
@@ -695,6 +699,7 @@ func (ms *mapSession) netmap() *netmap.NetworkMap {
 		PacketFilter:      ms.lastParsedPacketFilter,
 		PacketFilterRules: ms.lastPacketFilterRules,
 		SSHPolicy:         ms.lastSSHPolicy,
+		SSHHostCerts:      ms.lastSSHHostCerts,
 		CollectServices:   ms.collectServices,
 		DERPMap:           ms.lastDERPMap,
 		ControlHealth:     ms.lastHealth,
@@ -453,6 +453,7 @@ func (a *Dialer) tryURLUpgrade(ctx context.Context, u *url.URL, addr netip.Addr,
 	}
 
 	tr.DialTLSContext = dnscache.TLSDialer(dialer, dns, tr.TLSClientConfig)
+	tshttpproxy.SetTransportProxyDialer(tr)
 	tr.DisableCompression = true
 
 	// (mis)use httptrace to extract the underlying net.Conn from the
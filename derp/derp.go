@@ -18,6 +18,7 @@
 	"errors"
 	"fmt"
 	"io"
+	"slices"
 	"time"
 )
 
@@ -43,6 +44,31 @@
 //   - version 2: received packets have src addrs in frameRecvPacket at beginning
 const ProtocolVersion = 2
 
+// Feature is an optional DERP protocol feature. Clients list the features
+// they support in their frameClientInfo and the server replies in its
+// frameServerInfo with those it also supports and has enabled, which are
+// then in effect for the connection. Neither side may use a feature that
+// isn't in effect, so features can be added and rolled out without
+// bumping ProtocolVersion or breaking older clients and servers, which
+// don't send or understand the lists.
+type Feature string
+
+// supportedFeatures are the features that this package's Client and
+// Server implement. It's a var for tests.
+var supportedFeatures []Feature
+
+// negotiateFeatures returns the features in both ours and theirs, in the
+// order of ours.
+func negotiateFeatures(ours, theirs []Feature) []Feature {
+	var ret []Feature
+	for _, f := range ours {
+		if slices.Contains(theirs, f) && !slices.Contains(ret, f) {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
 // frameType is the one byte frame type at the beginning of the frame
 // header.  The second field is a big-endian uint32 describing the
 // length of the remaining frame (not including the initial 5 bytes).
@@ -11,6 +11,7 @@
 	"fmt"
 	"io"
 	"net/netip"
+	"slices"
 	"sync"
 	"time"
 
@@ -42,6 +43,8 @@ type Client struct {
 	peeked  int                      // bytes to discard on next Recv
 	readErr syncs.AtomicValue[error] // sticky (set by Recv)
 
+	features syncs.AtomicValue[[]Feature] // in effect, per the server's serverInfo (set by Recv)
+
 	clock tstime.Clock
 }
 
@@ -175,6 +178,9 @@ type clientInfo struct {
 
 	// IsProber is whether this client is a prober.
 	IsProber bool `json:",omitempty"`
+
+	// Features are the protocol features the client supports.
+	Features []Feature `json:",omitempty"`
 }
 
 func (c *Client) sendClientKey() error {
@@ -183,6 +189,7 @@ func (c *Client) sendClientKey() error {
 		MeshKey:     c.meshKey,
 		CanAckPings: c.canAckPings,
 		IsProber:    c.isProber,
+		Features:    supportedFeatures,
 	})
 	if err != nil {
 		return err
@@ -198,6 +205,13 @@ func (c *Client) sendClientKey() error {
 // ServerPublicKey returns the server's public key.
 func (c *Client) ServerPublicKey() key.NodePublic { return c.serverKey }
 
+// HasFeature reports whether the server agreed to use the protocol feature
+// f on this connection. It reports false until Recv has returned the
+// server's ServerInfoMessage.
+func (c *Client) HasFeature(f Feature) bool {
+	return slices.Contains(c.features.Load(), f)
+}
+
 // Send sends a packet to the Tailscale node identified by dstKey.
 //
 // It is an error if the packet is larger than 64KB.
@@ -388,6 +402,10 @@ type ServerInfoMessage struct {
 	// Zero means unspecified. There might be a limit, but the
 	// client need not try to respect it.
 	TokenBucketBytesBurst int
+
+	// Features are the protocol features in effect for the
+	// connection. See Client.HasFeature.
+	Features []Feature
 }
 
 func (ServerInfoMessage) msg() {}
@@ -520,9 +538,12 @@ func (c *Client) recvTimeout(timeout time.Duration) (m ReceivedMessage, err erro
 			if err != nil {
 				return nil, fmt.Errorf("invalid server info frame: %v", err)
 			}
+			features := negotiateFeatures(supportedFeatures, si.Features)
+			c.features.Store(features)
 			sm := ServerInfoMessage{
 				TokenBucketBytesPerSecond: si.TokenBucketBytesPerSecond,
 				TokenBucketBytesBurst:     si.TokenBucketBytesBurst,
+				Features:                  features,
 			}
 			c.setSendRateLimiter(sm)
 			return sm, nil
@@ -0,0 +1,162 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derp
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limits are limits on what the clients in one scope, such as all the
+// connections of one public key, may use a Server for. Zero values mean no
+// limit.
+type Limits struct {
+	// PacketsPerSec is how many packets per second the clients may send,
+	// with bursts of up to a second's worth.
+	PacketsPerSec float64 `json:",omitempty"`
+
+	// BytesPerSec is how many bytes of packets per second the clients
+	// may send, with bursts of up to a second's worth (or MaxPacketSize,
+	// if larger).
+	BytesPerSec float64 `json:",omitempty"`
+
+	// MaxConns is how many connections the clients may have open at
+	// once. Connections beyond it are closed as soon as the client's key
+	// is known.
+	MaxConns int `json:",omitempty"`
+}
+
+// RateLimits configures how much each client may use a Server, so public
+// servers can protect themselves from abusive or runaway clients. Packets
+// over the limits are dropped. Mesh peers are never limited.
+type RateLimits struct {
+	// PerKey limits all the connections of each client public key.
+	PerKey Limits `json:",omitempty"`
+
+	// PerIP limits all the connections from each client IP address.
+	PerIP Limits `json:",omitempty"`
+}
+
+// sendLimiter limits the packets sent by the connections of one scope.
+type sendLimiter struct {
+	conns int // guarded by Server.mu
+	pkts  *rate.Limiter
+	bytes *rate.Limiter
+}
+
+func newSendLimiter(l Limits) *sendLimiter {
+	return &sendLimiter{
+		pkts:  rate.NewLimiter(limiterRate(l.PacketsPerSec, 1)),
+		bytes: rate.NewLimiter(limiterRate(l.BytesPerSec, MaxPacketSize)),
+	}
+}
+
+// limiterRate returns the rate.Limiter limit and burst for perSec events
+// per second, with bursts of a second's worth or minBurst, whichever is
+// larger. A perSec of zero means no limit.
+func limiterRate(perSec float64, minBurst int) (rate.Limit, int) {
+	if perSec <= 0 {
+		return rate.Inf, 0
+	}
+	return rate.Limit(perSec), max(int(perSec), minBurst)
+}
+
+// setLimits updates sl's rates to those of l. It's safe to call
+// concurrently with allow.
+func (sl *sendLimiter) setLimits(l Limits) {
+	lim, burst := limiterRate(l.PacketsPerSec, 1)
+	sl.pkts.SetLimit(lim)
+	sl.pkts.SetBurst(burst)
+	lim, burst = limiterRate(l.BytesPerSec, MaxPacketSize)
+	sl.bytes.SetLimit(lim)
+	sl.bytes.SetBurst(burst)
+}
+
+// allow reports whether a packet of n bytes may be sent at now.
+func (sl *sendLimiter) allow(now time.Time, n int) bool {
+	return sl.pkts.AllowN(now, 1) && sl.bytes.AllowN(now, n)
+}
+
+// SetRateLimits sets the limits on what each client may use s for. It may
+// be called at any time; the new limits apply to existing connections as
+// well as new ones, but connections already over a new MaxConns are kept.
+func (s *Server) SetRateLimits(rl RateLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimits = rl
+	for _, sl := range s.keyLimiters {
+		sl.setLimits(rl.PerKey)
+	}
+	for _, sl := range s.ipLimiters {
+		sl.setLimits(rl.PerIP)
+	}
+}
+
+// acquireLimiters checks that c may connect under the connection limits
+// and, if so, attaches the send limiters for its key and IP to it. If it
+// returns nil, releaseLimiters must be called when c disconnects.
+func (s *Server) acquireLimiters(c *sclient) error {
+	ip := c.remoteIPPort.Addr()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rl := s.rateLimits
+	kl := s.keyLimiters[c.key]
+	if n := rl.PerKey.MaxConns; n > 0 && kl != nil && kl.conns >= n {
+		s.rateLimitedConns.Add("key", 1)
+		return fmt.Errorf("over limit of %d connections per key", n)
+	}
+	var il *sendLimiter
+	if ip.IsValid() {
+		il = s.ipLimiters[ip]
+		if n := rl.PerIP.MaxConns; n > 0 && il != nil && il.conns >= n {
+			s.rateLimitedConns.Add("ip", 1)
+			return fmt.Errorf("over limit of %d connections per IP", n)
+		}
+		if il == nil {
+			il = newSendLimiter(rl.PerIP)
+			s.ipLimiters[ip] = il
+		}
+		il.conns++
+	}
+	if kl == nil {
+		kl = newSendLimiter(rl.PerKey)
+		s.keyLimiters[c.key] = kl
+	}
+	kl.conns++
+	c.keyLim, c.ipLim = kl, il
+	return nil
+}
+
+// releaseLimiters undoes a successful acquireLimiters for c.
+func (s *Server) releaseLimiters(c *sclient) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if kl := c.keyLim; kl != nil {
+		if kl.conns--; kl.conns == 0 {
+			delete(s.keyLimiters, c.key)
+		}
+	}
+	if il := c.ipLim; il != nil {
+		if il.conns--; il.conns == 0 {
+			delete(s.ipLimiters, c.remoteIPPort.Addr())
+		}
+	}
+}
+
+// allowSend reports whether c may send a packet of n bytes under the rate
+// limits of its key and IP.
+func (c *sclient) allowSend(n int) bool {
+	now := c.s.clock.Now()
+	if kl := c.keyLim; kl != nil && !kl.allow(now, n) {
+		c.s.rateLimitedPackets.Add("key", 1)
+		return false
+	}
+	if il := c.ipLim; il != nil && !il.allow(now, n) {
+		c.s.rateLimitedPackets.Add("ip", 1)
+		return false
+	}
+	return true
+}
@@ -27,6 +27,7 @@
 	"net/netip"
 	"os/exec"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -108,6 +109,7 @@ type Server struct {
 	metaCert    []byte // the encoded x509 cert to send after LetsEncrypt cert+intermediate
 	dupPolicy   dupPolicy
 	debug       bool
+	features    []Feature // enabled protocol features
 
 	// Counters:
 	packetsSent, bytesSent       expvar.Int
@@ -143,6 +145,8 @@ type Server struct {
 	removePktForwardOther        expvar.Int
 	avgQueueDuration             *uint64          // In milliseconds; accessed atomically
 	tcpRtt                       metrics.LabelMap // histogram
+	rateLimitedConns             metrics.LabelMap // by scope ("key" or "ip")
+	rateLimitedPackets           metrics.LabelMap // by scope ("key" or "ip")
 
 	// verifyClients only accepts client connections to the DERP server if the clientKey is a
 	// known peer in the network, as specified by a running tailscaled's client's LocalAPI.
@@ -168,6 +172,13 @@ type Server struct {
 	// maps from netip.AddrPort to a client's public key
 	keyOfAddr map[netip.AddrPort]key.NodePublic
 
+	// rateLimits are the limits set by SetRateLimits, enforced by
+	// keyLimiters and ipLimiters for the connected clients' keys and
+	// IPs.
+	rateLimits  RateLimits
+	keyLimiters map[key.NodePublic]*sendLimiter
+	ipLimiters  map[netip.Addr]*sendLimiter
+
 	clock tstime.Clock
 }
 
@@ -321,8 +332,13 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		sentTo:               map[key.NodePublic]map[key.NodePublic]int64{},
 		avgQueueDuration:     new(uint64),
 		tcpRtt:               metrics.LabelMap{Label: "le"},
+		rateLimitedConns:     metrics.LabelMap{Label: "scope"},
+		rateLimitedPackets:   metrics.LabelMap{Label: "scope"},
+		keyLimiters:          map[key.NodePublic]*sendLimiter{},
+		ipLimiters:           map[netip.Addr]*sendLimiter{},
 		keyOfAddr:            map[netip.AddrPort]key.NodePublic{},
 		clock:                tstime.StdClock{},
+		features:             supportedFeatures,
 	}
 	s.initMetacert()
 	s.packetsRecvDisco = s.packetsRecvByKind.Get("disco")
@@ -335,6 +351,7 @@ func NewServer(privateKey key.NodePrivate, logf logger.Logf) *Server {
 		s.packetsDroppedReason.Get("queue_head"),
 		s.packetsDroppedReason.Get("queue_tail"),
 		s.packetsDroppedReason.Get("write_error"),
+		s.packetsDroppedReason.Get("rate_limited"),
 	}
 	s.packetsDroppedTypeDisco = s.packetsDroppedType.Get("disco")
 	s.packetsDroppedTypeOther = s.packetsDroppedType.Get("other")
@@ -356,6 +373,16 @@ func (s *Server) SetVerifyClient(v bool) {
 	s.verifyClients = v
 }
 
+// SetDisabledFeatures disables the named protocol features, so they're not
+// negotiated with clients. It's for rolling out new features gradually.
+//
+// It must be called before serving begins.
+func (s *Server) SetDisabledFeatures(fs []Feature) {
+	s.features = slices.DeleteFunc(slices.Clone(supportedFeatures), func(f Feature) bool {
+		return slices.Contains(fs, f)
+	})
+}
+
 // HasMeshKey reports whether the server is configured with a mesh key.
 func (s *Server) HasMeshKey() bool { return s.meshKey != "" }
 
@@ -728,6 +755,7 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 	}
 	if clientInfo != nil {
 		c.info = *clientInfo
+		c.features = negotiateFeatures(s.features, clientInfo.Features)
 		if envknob.Bool("DERP_PROBER_DEBUG_LOGS") && clientInfo.IsProber {
 			c.debug = true
 		}
@@ -736,10 +764,17 @@ func (s *Server) accept(ctx context.Context, nc Conn, brw *bufio.ReadWriter, rem
 		c.debug = true
 	}
 
+	if !c.canMesh {
+		if err := s.acquireLimiters(c); err != nil {
+			return fmt.Errorf("client %x rejected: %v", clientKey, err)
+		}
+		defer s.releaseLimiters(c)
+	}
+
 	s.registerClient(c)
 	defer s.unregisterClient(c)
 
-	err = s.sendServerInfo(c.bw, clientKey)
+	err = s.sendServerInfo(c.bw, clientKey, c.features)
 	if err != nil {
 		return fmt.Errorf("send server info: %v", err)
 	}
@@ -759,6 +794,11 @@ func (s *Server) debugLogf(format string, v ...any) {
 	timeNow   = time.Now
 )
 
+// hasFeature reports whether the protocol feature f is in effect for c.
+func (c *sclient) hasFeature(f Feature) bool {
+	return slices.Contains(c.features, f)
+}
+
 // run serves the client until there's an error.
 // If the client hangs up or the server is closed, run returns nil, otherwise run returns an error.
 func (c *sclient) run(ctx context.Context) error {
@@ -970,6 +1010,12 @@ func (c *sclient) handleFrameSendPacket(ft frameType, fl uint32) error {
 		return fmt.Errorf("client %x: recvPacket: %v", c.key, err)
 	}
 
+	if !c.allowSend(len(contents)) {
+		s.recordDrop(contents, c.key, dstKey, dropReasonRateLimited)
+		c.debugLogf("SendPacket for %s, dropping with reason=%s", dstKey.ShortString(), dropReasonRateLimited)
+		return nil
+	}
+
 	var fwd PacketForwarder
 	var dstLen int
 	var dst *sclient
@@ -1036,6 +1082,7 @@ func (c *sclient) debugLogf(format string, v ...any) {
 	dropReasonQueueTail                          // destination queue is full, dropped packet at queue tail
 	dropReasonWriteError                         // OS write() failed
 	dropReasonDupClient                          // the public key is connected 2+ times (active/active, fighting)
+	dropReasonRateLimited                        // the sender is over its rate limits
 )
 
 func (s *Server) recordDrop(packetBytes []byte, srcKey, dstKey key.NodePublic, reason dropReason) {
@@ -1206,10 +1253,15 @@ type serverInfo struct {
 
 	TokenBucketBytesPerSecond int `json:",omitempty"`
 	TokenBucketBytesBurst     int `json:",omitempty"`
+
+	// Features are the protocol features in effect for the connection:
+	// those listed in the client's clientInfo.Features that the server
+	// supports and has enabled.
+	Features []Feature `json:",omitempty"`
 }
 
-func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic) error {
-	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion})
+func (s *Server) sendServerInfo(bw *lazyBufioWriter, clientKey key.NodePublic, features []Feature) error {
+	msg, err := json.Marshal(serverInfo{Version: ProtocolVersion, Features: features})
 	if err != nil {
 		return err
 	}
@@ -1321,6 +1373,7 @@ type sclient struct {
 	nc             Conn
 	key            key.NodePublic
 	info           clientInfo
+	features       []Feature // protocol features in effect
 	logf           logger.Logf
 	done           <-chan struct{}  // closed when connection closes
 	remoteAddr     string           // usually ip:port from net.Conn.RemoteAddr().String()
@@ -1355,6 +1408,11 @@ type sclient struct {
 	// client that it's trying to establish a direct connection
 	// through us with a peer we have no record of.
 	peerGoneLim *rate.Limiter
+
+	// keyLim and ipLim, if non-nil, limit what this client and the
+	// others with its key or IP may send. They're nil for mesh peers.
+	keyLim *sendLimiter
+	ipLim  *sendLimiter
 }
 
 // peerConnState represents whether a peer is connected to the server
@@ -1840,6 +1898,8 @@ func (s *Server) ExpVar() expvar.Var {
 		return math.Float64frombits(atomic.LoadUint64(s.avgQueueDuration))
 	}))
 	m.Set("counter_tcp_rtt", &s.tcpRtt)
+	m.Set("counter_rate_limited_conns", &s.rateLimitedConns)
+	m.Set("counter_rate_limited_packets", &s.rateLimitedPackets)
 	var expvarVersion expvar.String
 	expvarVersion.Set(version.Long())
 	m.Set("version", &expvarVersion)
@@ -16,8 +16,10 @@
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"os"
 	"reflect"
+	"slices"
 	"strconv"
 	"sync"
 	"testing"
@@ -42,7 +44,7 @@ func TestClientInfoUnmarshal(t *testing.T) {
 			t.Fatalf("[%d]: %v", i, err)
 		}
 		want := clientInfo{Version: 5, MeshKey: "abc"}
-		if got != want {
+		if !reflect.DeepEqual(got, want) {
 			t.Errorf("[%d]: got %+v; want %+v", i, got, want)
 		}
 	}
@@ -1516,3 +1518,110 @@ func TestServerRepliesToPing(t *testing.T) {
 		}
 	}
 }
+
+func TestFeatureNegotiation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tstest.Replace(t, &supportedFeatures, []Feature{"test-a", "test-b", "test-c"})
+	ts := newTestServer(t, ctx)
+	defer ts.close(t)
+	ts.s.SetDisabledFeatures([]Feature{"test-b"})
+
+	check := func(c *Client, want ...Feature) {
+		t.Helper()
+		for _, f := range supportedFeatures {
+			if got := c.HasFeature(f); got != slices.Contains(want, f) {
+				t.Errorf("HasFeature(%q) = %v; want %v", f, got, !got)
+			}
+		}
+	}
+
+	// A client supporting a subset of the server's features.
+	supportedFeatures = []Feature{"test-a", "test-b", "test-d"}
+	check(newRegularClient(t, ts, "new").c, "test-a")
+
+	// A client from before feature negotiation.
+	supportedFeatures = nil
+	check(newRegularClient(t, ts, "old").c)
+
+	// A server from before feature negotiation.
+	supportedFeatures = nil
+	oldServer := newTestServer(t, ctx)
+	defer oldServer.close(t)
+	supportedFeatures = []Feature{"test-a"}
+	check(newRegularClient(t, oldServer, "new").c)
+}
+
+func TestNegotiateFeatures(t *testing.T) {
+	tests := []struct {
+		ours, theirs, want []Feature
+	}{
+		{nil, nil, nil},
+		{[]Feature{"a"}, nil, nil},
+		{nil, []Feature{"a"}, nil},
+		{[]Feature{"a", "b", "c"}, []Feature{"c", "x", "a", "a"}, []Feature{"a", "c"}},
+	}
+	for _, tt := range tests {
+		if got := negotiateFeatures(tt.ours, tt.theirs); !slices.Equal(got, tt.want) {
+			t.Errorf("negotiateFeatures(%q, %q) = %q; want %q", tt.ours, tt.theirs, got, tt.want)
+		}
+	}
+}
+
+func TestServerRateLimits(t *testing.T) {
+	s := NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	s.SetRateLimits(RateLimits{
+		PerKey: Limits{PacketsPerSec: 2, MaxConns: 2},
+		PerIP:  Limits{BytesPerSec: 1000, MaxConns: 3},
+	})
+
+	ipp := netip.MustParseAddrPort("192.0.2.1:1234")
+	k1, k2 := key.NewNode().Public(), key.NewNode().Public()
+	newClient := func(k key.NodePublic) *sclient {
+		return &sclient{s: s, key: k, remoteIPPort: ipp}
+	}
+	var conns []*sclient
+	for _, k := range []key.NodePublic{k1, k1, k2} {
+		c := newClient(k)
+		if err := s.acquireLimiters(c); err != nil {
+			t.Fatalf("acquireLimiters: %v", err)
+		}
+		conns = append(conns, c)
+	}
+	if err := s.acquireLimiters(newClient(k1)); err == nil {
+		t.Error("third connection of key allowed")
+	}
+	if err := s.acquireLimiters(newClient(key.NewNode().Public())); err == nil {
+		t.Error("fourth connection from IP allowed")
+	}
+
+	// The connections of k1 share its packet limit.
+	if !conns[0].allowSend(10) || !conns[1].allowSend(10) {
+		t.Fatal("packets within limit dropped")
+	}
+	if conns[0].allowSend(10) {
+		t.Error("packet over per-key limit allowed")
+	}
+	// k2 has its own packet limit, but shares the IP's byte limit, of
+	// which MaxPacketSize is available at once.
+	if conns[2].allowSend(MaxPacketSize) {
+		t.Error("packet over per-IP byte limit allowed")
+	}
+	if got := s.rateLimitedPackets.Get("key").String(); got != "1" {
+		t.Errorf("rate limited packets by key = %v; want 1", got)
+	}
+
+	s.SetRateLimits(RateLimits{})
+	if !conns[0].allowSend(10) {
+		t.Error("packet dropped after limits removed")
+	}
+
+	for _, c := range conns {
+		s.releaseLimiters(c)
+	}
+	if len(s.keyLimiters) != 0 || len(s.ipLimiters) != 0 {
+		t.Errorf("limiters left after release: %d keys, %d IPs", len(s.keyLimiters), len(s.ipLimiters))
+	}
+}
@@ -81,6 +81,8 @@ type Client struct {
 
 	mu           sync.Mutex
 	preferred    bool
+	pollFallback func() bool // or nil; see SetPollFallbackFunc
+	preferPoll   bool        // whether to connect with HTTPS polling
 	canAckPings  bool
 	closed       bool
 	netConn      io.Closer
@@ -271,6 +273,33 @@ func (c *Client) preferIPv6() bool {
 // dialWebsocketFunc is non-nil (set by websocket.go's init) when compiled in.
 var dialWebsocketFunc func(ctx context.Context, urlStr string) (net.Conn, error)
 
+// SetPollFallbackFunc sets a func that reports whether the Client may fall
+// back to DERP over HTTPS long-polling when it can't connect normally, as
+// when a network blocks UDP and also breaks the HTTP upgrade to DERP. Once
+// a connection attempt fails with fallback allowed, the Client connects
+// with polling until f reports false.
+//
+// f is called with the Client's mutex held, so it must not call back into
+// the Client.
+func (c *Client) SetPollFallbackFunc(f func() bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pollFallback = f
+}
+
+// usePollLocked reports whether to connect with HTTPS polling.
+//
+// c.mu must be held.
+func (c *Client) usePollLocked() bool {
+	if envknob.Bool("TS_DEBUG_DERP_POLL_CLIENT") {
+		return true
+	}
+	if c.pollFallback == nil || !c.pollFallback() {
+		c.preferPoll = false
+	}
+	return c.preferPoll
+}
+
 func useWebsockets() bool {
 	if runtime.GOOS == "js" {
 		return true
@@ -319,9 +348,14 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 	}
 
 	var tcpConn net.Conn
+	usePoll := c.usePollLocked()
 
 	defer func() {
 		if err != nil {
+			if !usePoll && c.pollFallback != nil && c.pollFallback() && c.ctx.Err() == nil {
+				c.logf("%s: will retry %v with HTTPS polling", caller, c.targetString(reg))
+				c.preferPoll = true
+			}
 			if ctx.Err() != nil {
 				err = fmt.Errorf("%v: %v", ctx.Err(), err)
 			}
@@ -334,18 +368,32 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 
 	var node *tailcfg.DERPNode // nil when using c.url to dial
 	switch {
-	case useWebsockets():
-		var urlStr string
-		if c.url != nil {
-			urlStr = c.url.String()
+	case useWebsockets() || usePoll:
+		var conn net.Conn
+		if usePoll {
+			c.logf("%s: connecting to %v with HTTPS polling", caller, c.targetString(reg))
+			conn, err = c.dialPoll(ctx, reg)
+			if err != nil {
+				return nil, 0, err
+			}
+			// Unlike a TCP connection, nothing closes conn if the
+			// DERP handshake doesn't finish before ctx is done.
+			if d, ok := ctx.Deadline(); ok {
+				conn.SetDeadline(d)
+			}
 		} else {
-			urlStr = c.urlString(reg.Nodes[0])
-		}
-		c.logf("%s: connecting websocket to %v", caller, urlStr)
-		conn, err := dialWebsocketFunc(ctx, urlStr)
-		if err != nil {
-			c.logf("%s: websocket to %v error: %v", caller, urlStr, err)
-			return nil, 0, err
+			var urlStr string
+			if c.url != nil {
+				urlStr = c.url.String()
+			} else {
+				urlStr = c.urlString(reg.Nodes[0])
+			}
+			c.logf("%s: connecting websocket to %v", caller, urlStr)
+			conn, err = dialWebsocketFunc(ctx, urlStr)
+			if err != nil {
+				c.logf("%s: websocket to %v error: %v", caller, urlStr, err)
+				return nil, 0, err
+			}
 		}
 		brw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
 		derpClient, err := derp.NewClient(c.privateKey, conn, brw, c.logf,
@@ -354,6 +402,7 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 			derp.IsProber(c.IsProber),
 		)
 		if err != nil {
+			go conn.Close()
 			return nil, 0, err
 		}
 		if c.preferred {
@@ -362,6 +411,9 @@ func (c *Client) connect(ctx context.Context, caller string) (client *derp.Clien
 				return nil, 0, err
 			}
 		}
+		if usePoll {
+			conn.SetDeadline(time.Time{})
+		}
 		c.serverPubKey = derpClient.ServerPublicKey()
 		c.client = derpClient
 		c.netConn = conn
@@ -763,34 +815,14 @@ func (c *Client) dialNodeUsingProxy(ctx context.Context, n *tailcfg.DERPNode, pr
 	}()
 
 	target := net.JoinHostPort(n.HostName, "443")
-
-	var authHeader string
-	if v, err := tshttpproxy.GetAuthHeader(pu); err != nil {
-		c.logf("derphttp: error getting proxy auth header for %v: %v", proxyURL, err)
-	} else if v != "" {
-		authHeader = fmt.Sprintf("Proxy-Authorization: %s\r\n", v)
-	}
-
-	if _, err := fmt.Fprintf(proxyConn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n%s\r\n", target, pu.Hostname(), authHeader); err != nil {
-		if ctx.Err() != nil {
-			return nil, ctx.Err()
-		}
-		return nil, err
-	}
-
-	br := bufio.NewReader(proxyConn)
-	res, err := http.ReadResponse(br, nil)
-	if err != nil {
+	if err := tshttpproxy.Connect(ctx, proxyConn, pu, target); err != nil {
 		if ctx.Err() != nil {
 			return nil, ctx.Err()
 		}
 		c.logf("derphttp: CONNECT dial to %s: %v", target, err)
 		return nil, err
 	}
-	c.logf("derphttp: CONNECT dial to %s: %v", target, res.Status)
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("invalid response status from HTTP proxy %s on CONNECT to %s: %v", pu, target, res.Status)
-	}
+	c.logf("derphttp: CONNECT dial to %s: ok", target)
 	return proxyConn, nil
 }
 
@@ -9,7 +9,9 @@
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -206,3 +208,85 @@ func TestPing(t *testing.T) {
 		t.Fatalf("Ping: %v", err)
 	}
 }
+
+func TestPollFallback(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+	h := Handler(s)
+	direct := httptest.NewServer(h)
+	defer direct.Close()
+	// A middlebox that only lets plain HTTP requests through.
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" {
+			http.Error(w, "no", http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+	defer broken.Close()
+
+	newClient := func(serverURL string) (*Client, key.NodePublic) {
+		k := key.NewNode()
+		c, err := NewClient(k, serverURL+"/derp", t.Logf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c, k.Public()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c1, k1 := newClient(direct.URL)
+	if err := c1.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	waitConnect(t, c1)
+
+	c2, k2 := newClient(broken.URL)
+	if err := c2.Connect(ctx); err == nil {
+		t.Fatal("Connect through broken middlebox succeeded without fallback")
+	}
+	var fallbackOK atomic.Bool
+	fallbackOK.Store(true)
+	c2.SetPollFallbackFunc(fallbackOK.Load)
+	if err := c2.Connect(ctx); err == nil {
+		t.Fatal("first Connect with fallback allowed succeeded; want failure before polling")
+	}
+	if err := c2.Connect(ctx); err != nil {
+		t.Fatalf("Connect with HTTPS polling: %v", err)
+	}
+	waitConnect(t, c2)
+
+	recv := func(c *Client, want string) {
+		t.Helper()
+		for {
+			m, err := c.Recv()
+			if err != nil {
+				t.Fatalf("Recv: %v", err)
+			}
+			if p, ok := m.(derp.ReceivedPacket); ok {
+				if string(p.Data) != want {
+					t.Errorf("Recv = %q; want %q", p.Data, want)
+				}
+				return
+			}
+		}
+	}
+	if err := c1.Send(k2, []byte("to poller")); err != nil {
+		t.Fatal(err)
+	}
+	recv(c2, "to poller")
+	if err := c2.Send(k1, []byte("from poller")); err != nil {
+		t.Fatal(err)
+	}
+	recv(c1, "from poller")
+
+	// Once fallback is no longer allowed, the client goes back to
+	// connecting normally.
+	fallbackOK.Store(false)
+	c2.closeForReconnect(c2.client)
+	if err := c2.Connect(ctx); err == nil {
+		t.Error("Connect succeeded after fallback was disallowed")
+	}
+}
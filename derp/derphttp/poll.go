@@ -0,0 +1,350 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package derphttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/syncs"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/rands"
+)
+
+// This file implements DERP over HTTPS long-polling, a last-resort
+// transport for networks where the HTTP upgrade to DERP (and WebSockets)
+// doesn't survive middleboxes but plain HTTPS requests do.
+//
+// A client opens a session with a POST of "?poll=open" to the DERP URL,
+// which returns a session ID. It then sends DERP protocol bytes as the
+// bodies of "?poll=send&sid=ID" POSTs and receives them as the bodies of
+// "?poll=recv&sid=ID" GETs, which the server holds open until it has data
+// or pollWait passes. "?poll=close&sid=ID" ends the session.
+
+const (
+	// pollWait is how long the server holds a recv request open without
+	// data before replying with 204 No Content.
+	pollWait = 25 * time.Second
+
+	// pollIdleTimeout is how long a session lives without any requests.
+	pollIdleTimeout = 60 * time.Second
+
+	// pollMaxBody is the maximum size of a send or recv body.
+	pollMaxBody = 1 << 20
+)
+
+// pollServer serves DERP polling sessions for Handler.
+type pollServer struct {
+	s *derp.Server
+
+	mu       sync.Mutex
+	sessions map[string]*pollSession // by ID
+}
+
+type pollSession struct {
+	conn net.Conn // our end of the pipe to the derp.Server
+	idle *time.Timer
+
+	recvMu sync.Mutex // serializes recv requests
+	sendMu sync.Mutex // serializes send requests
+}
+
+func (ps *pollServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	op := q.Get("poll")
+	if op == "open" {
+		if r.Method != "POST" {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		sid := ps.open(r.RemoteAddr)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, sid)
+		return
+	}
+
+	sid := q.Get("sid")
+	ps.mu.Lock()
+	sess := ps.sessions[sid]
+	ps.mu.Unlock()
+	if sess == nil {
+		http.Error(w, "unknown DERP poll session", http.StatusGone)
+		return
+	}
+	sess.idle.Reset(pollIdleTimeout)
+
+	switch op {
+	case "recv":
+		sess.recvMu.Lock()
+		defer sess.recvMu.Unlock()
+		buf := make([]byte, 64<<10)
+		sess.conn.SetReadDeadline(time.Now().Add(pollWait))
+		n, err := sess.conn.Read(buf)
+		if n > 0 {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(buf[:n])
+			return
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		ps.close(sid)
+		http.Error(w, "DERP poll session closed", http.StatusGone)
+	case "send":
+		if r.Method != "POST" {
+			http.Error(w, "POST required", http.StatusMethodNotAllowed)
+			return
+		}
+		sess.sendMu.Lock()
+		defer sess.sendMu.Unlock()
+		sess.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if _, err := io.Copy(sess.conn, io.LimitReader(r.Body, pollMaxBody)); err != nil {
+			ps.close(sid)
+			http.Error(w, "DERP poll session closed", http.StatusGone)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "close":
+		ps.close(sid)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unknown poll operation", http.StatusBadRequest)
+	}
+}
+
+// open starts a session, connected to ps.s, and returns its ID.
+func (ps *pollServer) open(remoteAddr string) string {
+	ours, theirs := net.Pipe()
+	sid := rands.HexString(32)
+	sess := &pollSession{
+		conn: ours,
+		idle: time.AfterFunc(pollIdleTimeout, func() { ps.close(sid) }),
+	}
+	ps.mu.Lock()
+	if ps.sessions == nil {
+		ps.sessions = make(map[string]*pollSession)
+	}
+	ps.sessions[sid] = sess
+	ps.mu.Unlock()
+
+	go func() {
+		brw := bufio.NewReadWriter(bufio.NewReader(theirs), bufio.NewWriter(theirs))
+		ps.s.Accept(context.Background(), theirs, brw, remoteAddr)
+		ps.close(sid)
+	}()
+	return sid
+}
+
+func (ps *pollServer) close(sid string) {
+	ps.mu.Lock()
+	sess := ps.sessions[sid]
+	delete(ps.sessions, sid)
+	ps.mu.Unlock()
+	if sess != nil {
+		sess.idle.Stop()
+		sess.conn.Close()
+	}
+}
+
+// dialPoll opens a DERP polling session with the server for c.url, or a
+// node of reg, and returns it as a net.Conn.
+func (c *Client) dialPoll(ctx context.Context, reg *tailcfg.DERPRegion) (net.Conn, error) {
+	// All of a session's requests must go to the same node, so only the
+	// first connection picks one.
+	var node *tailcfg.DERPNode
+	var firstConn net.Conn
+	if c.url == nil {
+		var err error
+		firstConn, node, err = c.dialRegion(ctx, reg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var firstMu sync.Mutex
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		firstMu.Lock()
+		nc := firstConn
+		firstConn = nil
+		firstMu.Unlock()
+		if nc == nil {
+			var err error
+			if node != nil {
+				nc, err = c.dialNode(ctx, node)
+			} else {
+				nc, err = c.dialURL(ctx)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !c.useHTTPS() {
+			return nc, nil
+		}
+		tc := c.tlsClient(nc, node)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			nc.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+	tr := &http.Transport{
+		DialContext:         dial,
+		DialTLSContext:      dial,
+		MaxIdleConnsPerHost: 2, // one recv and one send
+		IdleConnTimeout:     pollIdleTimeout,
+	}
+	pc := &pollConn{
+		hc:      &http.Client{Transport: tr},
+		baseURL: c.urlString(node),
+	}
+	pc.ctx, pc.cancel = context.WithCancel(c.ctx)
+
+	body, err := pc.do(ctx, "POST", "open", nil)
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	pc.sid = strings.TrimSpace(string(body))
+	return pc, nil
+}
+
+// pollConn is the client side of a DERP polling session.
+type pollConn struct {
+	hc      *http.Client
+	baseURL string
+	sid     string
+	ctx     context.Context // canceled by Close
+	cancel  context.CancelFunc
+
+	readDeadline  syncs.AtomicValue[time.Time]
+	writeDeadline syncs.AtomicValue[time.Time]
+
+	readMu  sync.Mutex
+	readBuf []byte // received but not yet read
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+// do makes a request for the poll operation op and returns the response
+// body. A 204 No Content response returns an empty body.
+func (pc *pollConn) do(ctx context.Context, method, op string, body []byte) ([]byte, error) {
+	u := fmt.Sprintf("%s?poll=%s", pc.baseURL, op)
+	if pc.sid != "" {
+		u += "&sid=" + pc.sid
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	res, err := pc.hc.Do(req)
+	if err != nil {
+		if pc.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, os.ErrDeadlineExceeded
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return io.ReadAll(io.LimitReader(res.Body, pollMaxBody))
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusGone:
+		return nil, io.EOF
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return nil, fmt.Errorf("DERP poll %s: %v: %s", op, res.Status, bytes.TrimSpace(msg))
+}
+
+// deadlineContext returns a context for a request, ending at deadline if
+// it's set.
+func (pc *pollConn) deadlineContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(pc.ctx)
+	}
+	return context.WithDeadline(pc.ctx, deadline)
+}
+
+func (pc *pollConn) Read(p []byte) (int, error) {
+	pc.readMu.Lock()
+	defer pc.readMu.Unlock()
+	for len(pc.readBuf) == 0 {
+		ctx, cancel := pc.deadlineContext(pc.readDeadline.Load())
+		b, err := pc.do(ctx, "GET", "recv", nil)
+		cancel()
+		if err != nil {
+			return 0, err
+		}
+		pc.readBuf = b
+	}
+	n := copy(p, pc.readBuf)
+	pc.readBuf = pc.readBuf[n:]
+	return n, nil
+}
+
+func (pc *pollConn) Write(p []byte) (int, error) {
+	pc.writeMu.Lock()
+	defer pc.writeMu.Unlock()
+	ctx, cancel := pc.deadlineContext(pc.writeDeadline.Load())
+	defer cancel()
+	if _, err := pc.do(ctx, "POST", "send", p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (pc *pollConn) Close() error {
+	pc.closeOnce.Do(func() {
+		if pc.sid != "" {
+			// Best effort; the server also expires idle sessions.
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			pc.do(ctx, "POST", "close", nil)
+			cancel()
+		}
+		pc.cancel()
+		pc.hc.CloseIdleConnections()
+	})
+	return nil
+}
+
+func (pc *pollConn) LocalAddr() net.Addr  { return pollAddr("local") }
+func (pc *pollConn) RemoteAddr() net.Addr { return pollAddr(pc.baseURL) }
+
+func (pc *pollConn) SetDeadline(t time.Time) error {
+	pc.readDeadline.Store(t)
+	pc.writeDeadline.Store(t)
+	return nil
+}
+
+func (pc *pollConn) SetReadDeadline(t time.Time) error {
+	pc.readDeadline.Store(t)
+	return nil
+}
+
+func (pc *pollConn) SetWriteDeadline(t time.Time) error {
+	pc.writeDeadline.Store(t)
+	return nil
+}
+
+// pollAddr is the net.Addr of a pollConn.
+type pollAddr string
+
+func (a pollAddr) Network() string { return "derp-poll" }
+func (a pollAddr) String() string  { return string(a) }
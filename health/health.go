@@ -11,6 +11,8 @@
 	"net/http"
 	"runtime"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -25,10 +27,12 @@
 	// mu guards everything in this var block.
 	mu sync.Mutex
 
-	sysErr    = map[Subsystem]error{}                   // error key => err (or nil for no error)
-	watchers  = set.HandleSet[func(Subsystem, error)]{} // opt func to run if error state changes
-	warnables = set.Set[*Warnable]{}
-	timer     *time.Timer
+	sysErr         = map[Subsystem]error{}                   // error key => err (or nil for no error)
+	watchers       = set.HandleSet[func(Subsystem, error)]{} // opt func to run if error state changes
+	changeWatchers = set.HandleSet[func([]Change)]{}         // opt func to run when problems start or end
+	warnables      = set.Set[*Warnable]{}
+	timer          *time.Timer
+	unhealthy      = map[string]UnhealthyState{} // UnhealthyState.key => state when first noticed
 
 	debugHandler = map[string]http.Handler{}
 
@@ -73,12 +77,116 @@
 
 	// SysTKA is the name of the tailnet key authority subsystem.
 	SysTKA = Subsystem("tailnet-lock")
+
+	// The following subsystems don't report errors with SetErr-style
+	// funcs, but name the parts of tailscaled that other problems affect,
+	// as reported in UnhealthyState.Subsystem.
+
+	// SysNetwork is the name of the machine's network connectivity.
+	SysNetwork = Subsystem("network")
+
+	// SysControl is the name of the connection to the coordination server.
+	SysControl = Subsystem("control")
+
+	// SysDERP is the name of the DERP relay connections.
+	SysDERP = Subsystem("derp")
+
+	// SysMagicsock is the name of the wgengine/magicsock subsystem.
+	SysMagicsock = Subsystem("magicsock")
+
+	// SysIPN is the name of the ipn backend subsystem.
+	SysIPN = Subsystem("ipn")
+
+	// SysLogging is the name of the logtail subsystem.
+	SysLogging = Subsystem("logging")
+
+	// SysConfig is the name of tailscaled's configuration.
+	SysConfig = Subsystem("config")
+
+	// SysTLS is the name of the TLS connections tailscaled makes.
+	SysTLS = Subsystem("tls")
+
+	// SysSSH is the name of the Tailscale SSH server subsystem.
+	SysSSH = Subsystem("ssh")
+)
+
+// WarnableCode is a stable, machine-readable identifier for a kind of
+// health problem, such as "dns-resolv-conf-overwritten".
+type WarnableCode string
+
+// Severity is how serious a health problem is.
+type Severity string
+
+const (
+	// SeverityHigh means connectivity is broken or very likely to be.
+	SeverityHigh = Severity("high")
+
+	// SeverityMedium means some functionality is degraded.
+	SeverityMedium = Severity("medium")
+
+	// SeverityLow means the problem is unlikely to affect connectivity.
+	SeverityLow = Severity("low")
 )
 
+// Args are structured values identifying an instance of a health problem,
+// such as the DERP region or the host it concerns.
+type Args map[string]string
+
+// UnhealthyState describes a current health problem.
+type UnhealthyState struct {
+	Code      WarnableCode
+	Severity  Severity
+	Subsystem Subsystem `json:",omitempty"` // the affected subsystem, if known
+	Text      string    // human-readable description
+	Args      Args      `json:",omitempty"`
+	DocURL    string    `json:",omitempty"` // optional page with more information
+
+	// BrokenSince is when the problem was first noticed.
+	BrokenSince time.Time
+
+	err error // the error reported by OverallError
+}
+
+// key returns a string identifying s's code and args, used to track s
+// across health checks.
+func (s *UnhealthyState) key() string {
+	if len(s.Args) == 0 {
+		return string(s.Code)
+	}
+	keys := make([]string, 0, len(s.Args))
+	for k := range s.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(string(s.Code))
+	for _, k := range keys {
+		fmt.Fprintf(&b, " %s=%q", k, s.Args[k])
+	}
+	return b.String()
+}
+
+// State is a machine-readable snapshot of the node's health.
+type State struct {
+	// Warnings are the current health problems, sorted by Text.
+	// It is empty if the node is healthy.
+	Warnings []UnhealthyState
+}
+
+// Change describes a health problem starting or ending.
+type Change struct {
+	Time    time.Time
+	Healthy bool // whether the problem ended
+	Warning UnhealthyState
+}
+
 // NewWarnable returns a new warnable item that the caller can mark
 // as health or in warning state.
 func NewWarnable(opts ...WarnableOpt) *Warnable {
-	w := new(Warnable)
+	w := &Warnable{
+		code:     "unknown",
+		severity: SeverityMedium,
+	}
 	for _, o := range opts {
 		o.mod(w)
 	}
@@ -102,6 +210,38 @@ func WithMapDebugFlag(name string) WarnableOpt {
 	})
 }
 
+// WithCode returns a WarnableOpt for NewWarnable that sets the code the
+// returned Warnable is reported with in State.
+func WithCode(code WarnableCode) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.code = code
+	})
+}
+
+// WithSeverity returns a WarnableOpt for NewWarnable that sets the severity
+// of the returned Warnable. The default is SeverityMedium.
+func WithSeverity(sev Severity) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.severity = sev
+	})
+}
+
+// WithSubsystem returns a WarnableOpt for NewWarnable that sets the
+// subsystem the problems the returned Warnable reports affect.
+func WithSubsystem(sys Subsystem) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.subsystem = sys
+	})
+}
+
+// WithDocURL returns a WarnableOpt for NewWarnable that sets a URL with more
+// information about the problem the returned Warnable reports.
+func WithDocURL(url string) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.docURL = url
+	})
+}
+
 type warnOptFunc func(*Warnable)
 
 func (f warnOptFunc) mod(w *Warnable) { f(w) }
@@ -109,29 +249,50 @@ func (f warnOptFunc) mod(w *Warnable) { f(w) }
 // Warnable is a health check item that may or may not be in a bad warning state.
 // The caller of NewWarnable is responsible for calling Set to update the state.
 type Warnable struct {
-	debugFlag string // optional MapRequest.DebugFlag to send when unhealthy
+	code      WarnableCode
+	severity  Severity
+	subsystem Subsystem // optional
+	docURL    string    // optional
+	debugFlag string    // optional MapRequest.DebugFlag to send when unhealthy
 
 	isSet atomic.Bool
 	mu    sync.Mutex
 	err   error
+	args  Args
 }
 
 // Set updates the Warnable's state.
 // If non-nil, it's considered unhealthy.
 func (w *Warnable) Set(err error) {
+	w.SetWithArgs(err, nil)
+}
+
+// SetWithArgs is like Set, but also records args describing the problem,
+// which are reported in State. They're ignored if err is nil.
+func (w *Warnable) SetWithArgs(err error, args Args) {
 	w.mu.Lock()
-	defer w.mu.Unlock()
 	w.err = err
+	w.args = args
 	w.isSet.Store(err != nil)
+	w.mu.Unlock()
+
+	mu.Lock()
+	defer mu.Unlock()
+	selfCheckLocked()
 }
 
 func (w *Warnable) get() error {
+	err, _ := w.getWithArgs()
+	return err
+}
+
+func (w *Warnable) getWithArgs() (error, Args) {
 	if !w.isSet.Load() {
-		return nil
+		return nil, nil
 	}
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.err
+	return w.err, w.args
 }
 
 // AppendWarnableDebugFlags appends to base any health items that are currently in failed
@@ -168,10 +329,33 @@ func RegisterWatcher(cb func(key Subsystem, err error)) (unregister func()) {
 		mu.Lock()
 		defer mu.Unlock()
 		delete(watchers, handle)
-		if len(watchers) == 0 && timer != nil {
-			timer.Stop()
-			timer = nil
-		}
+		stopTimerIfUnwatchedLocked()
+	}
+}
+
+// RegisterChangeWatcher adds a function that will be called with the health
+// problems that started or ended whenever the health state is checked and
+// has changed. It must be non-nil and is run in its own goroutine. The
+// returned func unregisters it.
+func RegisterChangeWatcher(cb func([]Change)) (unregister func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	handle := changeWatchers.Add(cb)
+	if timer == nil {
+		timer = time.AfterFunc(time.Minute, timerSelfCheck)
+	}
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(changeWatchers, handle)
+		stopTimerIfUnwatchedLocked()
+	}
+}
+
+func stopTimerIfUnwatchedLocked() {
+	if len(watchers) == 0 && len(changeWatchers) == 0 && timer != nil {
+		timer.Stop()
+		timer = nil
 	}
 }
 
@@ -234,6 +418,15 @@ func DebugHandler(typ string) http.Handler {
 	return debugHandler[typ]
 }
 
+// SubsystemReady reports whether the subsystem key, such as SysRouter or
+// SysDNS, has been configured at least once and has no current error.
+func SubsystemReady(key Subsystem) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	err, ok := sysErr[key]
+	return ok && err == nil
+}
+
 func get(key Subsystem) error {
 	mu.Lock()
 	defer mu.Unlock()
@@ -411,7 +604,39 @@ func selfCheckLocked() {
 		// Don't check yet.
 		return
 	}
-	setLocked(SysOverall, overallErrorLocked())
+	states := unhealthyStatesLocked()
+	noteChangesLocked(states)
+	setLocked(SysOverall, errorFromStates(states))
+}
+
+// noteChangesLocked records when each of the current problems in states
+// started, and tells the change watchers about any that started or ended
+// since the last check.
+func noteChangesLocked(states []UnhealthyState) {
+	now := time.Now()
+	var changes []Change
+	current := make(map[string]bool, len(states))
+	for _, s := range states {
+		k := s.key()
+		current[k] = true
+		if _, ok := unhealthy[k]; !ok {
+			s.BrokenSince = now
+			unhealthy[k] = s
+			changes = append(changes, Change{Time: now, Warning: s})
+		}
+	}
+	for k, s := range unhealthy {
+		if !current[k] {
+			delete(unhealthy, k)
+			changes = append(changes, Change{Time: now, Healthy: true, Warning: s})
+		}
+	}
+	if len(changes) == 0 {
+		return
+	}
+	for _, cb := range changeWatchers {
+		go cb(changes)
+	}
 }
 
 // OverallError returns a summary of the health state.
@@ -421,44 +646,130 @@ func selfCheckLocked() {
 func OverallError() error {
 	mu.Lock()
 	defer mu.Unlock()
-	return overallErrorLocked()
+	return errorFromStates(unhealthyStatesLocked())
+}
+
+// CurrentState returns a machine-readable snapshot of the health state.
+// It describes the same problems as OverallError.
+func CurrentState() *State {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	st := &State{Warnings: unhealthyStatesLocked()}
+	for i := range st.Warnings {
+		w := &st.Warnings[i]
+		if prev, ok := unhealthy[w.key()]; ok {
+			w.BrokenSince = prev.BrokenSince
+		} else {
+			w.BrokenSince = now
+		}
+	}
+	return st
+}
+
+func errorFromStates(states []UnhealthyState) error {
+	errs := make([]error, len(states))
+	for i, s := range states {
+		errs[i] = s.err
+	}
+	return multierr.New(errs...)
 }
 
 var fakeErrForTesting = envknob.RegisterString("TS_DEBUG_FAKE_HEALTH_ERROR")
 
-func overallErrorLocked() error {
+// Codes of the health problems detected by this package.
+const (
+	CodeNetworkDown           = WarnableCode("network-down")
+	CodeLogConfig             = WarnableCode("log-config")
+	CodeNotRunning            = WarnableCode("not-running")
+	CodeLoginError            = WarnableCode("login-error")
+	CodeNotInMapPoll          = WarnableCode("not-in-map-poll")
+	CodeNoMapResponse         = WarnableCode("no-map-response")
+	CodeNoDERPHome            = WarnableCode("no-derp-home")
+	CodeDERPHomeDisconnected  = WarnableCode("derp-home-disconnected")
+	CodeDERPHomeSilent        = WarnableCode("derp-home-silent")
+	CodeNoUDP4Bind            = WarnableCode("no-udp4-bind")
+	CodeReceiveFuncNotRunning = WarnableCode("receive-func-not-running")
+	CodeDERPRegionProblem     = WarnableCode("derp-region-problem")
+	CodeControlHealth         = WarnableCode("control-health")
+	CodeDiskConfigError       = WarnableCode("disk-config-error")
+	CodeTLSConnectionError    = WarnableCode("tls-connection-error")
+	CodeFakeErrorForTesting   = WarnableCode("fake-error-for-testing")
+)
+
+const tailnetLockDocURL = "https://tailscale.com/kb/1226/tailnet-lock"
+
+// codeSubsystems are the subsystems affected by the health problems
+// detected by this package, by code.
+var codeSubsystems = map[WarnableCode]Subsystem{
+	CodeNetworkDown:           SysNetwork,
+	CodeLogConfig:             SysLogging,
+	CodeNotRunning:            SysIPN,
+	CodeLoginError:            SysControl,
+	CodeNotInMapPoll:          SysControl,
+	CodeNoMapResponse:         SysControl,
+	CodeNoDERPHome:            SysDERP,
+	CodeDERPHomeDisconnected:  SysDERP,
+	CodeDERPHomeSilent:        SysDERP,
+	CodeNoUDP4Bind:            SysMagicsock,
+	CodeReceiveFuncNotRunning: SysMagicsock,
+	CodeDERPRegionProblem:     SysDERP,
+	CodeControlHealth:         SysControl,
+	CodeDiskConfigError:       SysConfig,
+	CodeTLSConnectionError:    SysTLS,
+}
+
+// SubsystemCode returns the code that problems with subsystem sys are
+// reported with, such as "subsystem-dns".
+func SubsystemCode(sys Subsystem) WarnableCode {
+	return WarnableCode("subsystem-" + string(sys))
+}
+
+// unhealthyStatesLocked returns the current health problems, sorted by
+// Text. Their BrokenSince fields are not set.
+func unhealthyStatesLocked() []UnhealthyState {
+	problem := func(code WarnableCode, sev Severity, err error, args Args) UnhealthyState {
+		return UnhealthyState{Code: code, Severity: sev, Subsystem: codeSubsystems[code], Text: err.Error(), Args: args, err: err}
+	}
+	one := func(code WarnableCode, sev Severity, err error, args Args) []UnhealthyState {
+		return []UnhealthyState{problem(code, sev, err, args)}
+	}
+
+	// The first of these problems found hides all others, as they
+	// make the others either meaningless or expected.
 	if !anyInterfaceUp {
-		return errors.New("network down")
+		return one(CodeNetworkDown, SeverityHigh, errors.New("network down"), nil)
 	}
 	if localLogConfigErr != nil {
-		return localLogConfigErr
+		return one(CodeLogConfig, SeverityHigh, localLogConfigErr, nil)
 	}
 	if !ipnWantRunning {
-		return fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning)
+		return one(CodeNotRunning, SeverityLow, fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning), Args{"state": ipnState})
 	}
 	if lastLoginErr != nil {
-		return fmt.Errorf("not logged in, last login error=%v", lastLoginErr)
+		return one(CodeLoginError, SeverityHigh, fmt.Errorf("not logged in, last login error=%v", lastLoginErr), nil)
 	}
 	now := time.Now()
 	if !inMapPoll && (lastMapPollEndedAt.IsZero() || now.Sub(lastMapPollEndedAt) > 10*time.Second) {
-		return errors.New("not in map poll")
+		return one(CodeNotInMapPoll, SeverityMedium, errors.New("not in map poll"), nil)
 	}
 	const tooIdle = 2*time.Minute + 5*time.Second
 	if d := now.Sub(lastStreamedMapResponse).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("no map response in %v", d)
+		return one(CodeNoMapResponse, SeverityMedium, fmt.Errorf("no map response in %v", d), nil)
 	}
 	rid := derpHomeRegion
 	if rid == 0 {
-		return errors.New("no DERP home")
+		return one(CodeNoDERPHome, SeverityHigh, errors.New("no DERP home"), nil)
 	}
+	regionArgs := Args{"region": strconv.Itoa(rid)}
 	if !derpRegionConnected[rid] {
-		return fmt.Errorf("not connected to home DERP region %v", rid)
+		return one(CodeDERPHomeDisconnected, SeverityHigh, fmt.Errorf("not connected to home DERP region %v", rid), regionArgs)
 	}
 	if d := now.Sub(derpRegionLastFrame[rid]).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d)
+		return one(CodeDERPHomeSilent, SeverityMedium, fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d), regionArgs)
 	}
 	if udp4Unbound {
-		return errors.New("no udp4 bind")
+		return one(CodeNoUDP4Bind, SeverityHigh, errors.New("no udp4 bind"), nil)
 	}
 
 	// TODO: use
@@ -467,43 +778,53 @@ func overallErrorLocked() error {
 	_ = lastStreamedMapResponse
 	_ = lastMapRequestHeard
 
-	var errs []error
+	var states []UnhealthyState
+	add := func(code WarnableCode, sev Severity, err error, args Args) {
+		states = append(states, problem(code, sev, err, args))
+	}
 	for _, recv := range receiveFuncs {
 		if recv.missing {
-			errs = append(errs, fmt.Errorf("%s is not running", recv.name))
+			add(CodeReceiveFuncNotRunning, SeverityHigh, fmt.Errorf("%s is not running", recv.name), Args{"func": recv.name})
 		}
 	}
 	for sys, err := range sysErr {
 		if err == nil || sys == SysOverall {
 			continue
 		}
-		errs = append(errs, fmt.Errorf("%v: %w", sys, err))
+		s := problem(SubsystemCode(sys), SeverityMedium, fmt.Errorf("%v: %w", sys, err), nil)
+		s.Subsystem = sys
+		if sys == SysTKA {
+			s.DocURL = tailnetLockDocURL
+		}
+		states = append(states, s)
 	}
 	for w := range warnables {
-		if err := w.get(); err != nil {
-			errs = append(errs, err)
+		if err, args := w.getWithArgs(); err != nil {
+			s := problem(w.code, w.severity, err, args)
+			s.Subsystem = w.subsystem
+			s.DocURL = w.docURL
+			states = append(states, s)
 		}
 	}
 	for regionID, problem := range derpRegionHealthProblem {
-		errs = append(errs, fmt.Errorf("derp%d: %v", regionID, problem))
+		add(CodeDERPRegionProblem, SeverityMedium, fmt.Errorf("derp%d: %v", regionID, problem), Args{"region": strconv.Itoa(regionID)})
 	}
 	for _, s := range controlHealth {
-		errs = append(errs, errors.New(s))
+		add(CodeControlHealth, SeverityMedium, errors.New(s), Args{"message": s})
 	}
 	if err := envknob.ApplyDiskConfigError(); err != nil {
-		errs = append(errs, err)
+		add(CodeDiskConfigError, SeverityMedium, err, nil)
 	}
 	for serverName, err := range tlsConnectionErrors {
-		errs = append(errs, fmt.Errorf("TLS connection error for %q: %w", serverName, err))
+		add(CodeTLSConnectionError, SeverityHigh, fmt.Errorf("TLS connection error for %q: %w", serverName, err), Args{"host": serverName})
 	}
-	if e := fakeErrForTesting(); len(errs) == 0 && e != "" {
-		return errors.New(e)
+	if e := fakeErrForTesting(); len(states) == 0 && e != "" {
+		add(CodeFakeErrorForTesting, SeverityLow, errors.New(e), nil)
 	}
-	sort.Slice(errs, func(i, j int) bool {
-		// Not super efficient (stringifying these in a sort), but probably max 2 or 3 items.
-		return errs[i].Error() < errs[j].Error()
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Text < states[j].Text
 	})
-	return multierr.New(errs...)
+	return states
 }
 
 var (
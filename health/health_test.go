@@ -8,6 +8,7 @@
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"tailscale.com/util/set"
 )
@@ -34,6 +35,76 @@ func TestAppendWarnableDebugFlags(t *testing.T) {
 	}
 }
 
+func TestCurrentState(t *testing.T) {
+	resetWarnables()
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		ipnState, ipnWantRunning = "", false
+		unhealthy = map[string]UnhealthyState{}
+	})
+	changes := make(chan []Change, 10)
+	unregister := RegisterChangeWatcher(func(c []Change) { changes <- c })
+	defer unregister()
+	nextChanges := func() []Change {
+		t.Helper()
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout waiting for health changes")
+			return nil
+		}
+	}
+
+	SetIPNState("Stopped", false)
+	c := nextChanges()
+	if len(c) != 1 || c[0].Healthy || c[0].Warning.Code != CodeNotRunning || c[0].Warning.Args["state"] != "Stopped" || c[0].Warning.Subsystem != SysIPN {
+		t.Fatalf("changes = %+v; want not-running starting", c)
+	}
+
+	// Get past the checks that hide all other problems.
+	SetIPNState("Running", true)
+	GotStreamedMapResponse()
+	SetMagicSockDERPHome(1)
+	SetDERPRegionConnectedState(1, true)
+	NoteDERPRegionReceivedFrame(1)
+
+	w := NewWarnable(WithCode("test-problem"), WithSeverity(SeverityHigh), WithSubsystem(SysDNS), WithDocURL("https://example.com/doc"))
+	w.SetWithArgs(errors.New("test problem"), Args{"thing": "foo"})
+
+	st := CurrentState()
+	if len(st.Warnings) != 1 {
+		t.Fatalf("warnings = %+v; want 1", st.Warnings)
+	}
+	got := st.Warnings[0]
+	if got.Code != "test-problem" || got.Severity != SeverityHigh || got.Text != "test problem" ||
+		got.Args["thing"] != "foo" || got.DocURL != "https://example.com/doc" || got.BrokenSince.IsZero() ||
+		got.Subsystem != SysDNS {
+		t.Errorf("warning = %+v", got)
+	}
+	if err := OverallError(); err == nil || err.Error() != "test problem" {
+		t.Errorf("OverallError = %v; want test problem", err)
+	}
+
+	var healed, started bool
+	for !healed || !started {
+		for _, c := range nextChanges() {
+			switch {
+			case c.Healthy && c.Warning.Code == CodeNotRunning:
+				healed = true
+			case !c.Healthy && c.Warning.Code == "test-problem":
+				started = true
+			}
+		}
+	}
+
+	w.Set(nil)
+	if st := CurrentState(); len(st.Warnings) != 0 {
+		t.Errorf("warnings after clearing = %+v; want none", st.Warnings)
+	}
+}
+
 func resetWarnables() {
 	mu.Lock()
 	defer mu.Unlock()
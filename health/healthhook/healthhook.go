@@ -0,0 +1,177 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package healthhook notifies a local webhook or runs a program whenever a
+// health problem starts or ends.
+package healthhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// Config configures the hooks run on health changes.
+type Config struct {
+	// URL, if non-empty, is an http or https URL on a loopback address
+	// to which each health.Change is POSTed as JSON.
+	URL string
+
+	// Exec, if non-empty, is the path of a program to run for each
+	// health.Change, with the change as JSON on its standard input. Its
+	// environment also has TS_HEALTH_CODE, TS_HEALTH_SEVERITY and
+	// TS_HEALTH_STATE ("unhealthy" or "healthy") set.
+	Exec string
+}
+
+// hookTimeout is how long a single webhook request or program may run.
+const hookTimeout = 30 * time.Second
+
+// Start validates c and starts delivering health changes to its hooks until
+// the returned stop func is called.
+func Start(logf logger.Logf, c Config) (stop func(), err error) {
+	if c.URL != "" {
+		if err := checkLocalURL(c.URL); err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &hook{
+		logf:  logger.WithPrefix(logf, "healthhook: "),
+		c:     c,
+		ctx:   ctx,
+		queue: make(chan health.Change, 64),
+		done:  make(chan struct{}),
+	}
+	go h.run()
+	unregister := health.RegisterChangeWatcher(h.enqueue)
+	return func() {
+		unregister()
+		cancel()
+		<-h.done
+	}, nil
+}
+
+// checkLocalURL returns an error if rawURL isn't an http or https URL whose
+// host is localhost or a loopback IP address.
+func checkLocalURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid health webhook URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("health webhook URL %q must be http or https", rawURL)
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("health webhook URL %q must be on localhost", rawURL)
+}
+
+type hook struct {
+	logf  logger.Logf
+	c     Config
+	ctx   context.Context
+	queue chan health.Change
+	done  chan struct{} // closed when run returns
+}
+
+// enqueue queues changes for delivery, dropping them if the hooks are too
+// far behind.
+func (h *hook) enqueue(changes []health.Change) {
+	for _, c := range changes {
+		select {
+		case h.queue <- c:
+		default:
+			h.logf("dropped change for %v; hooks are too slow", c.Warning.Code)
+		}
+	}
+}
+
+// run delivers queued changes, in order, until h.ctx is done.
+func (h *hook) run() {
+	defer close(h.done)
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case c := <-h.queue:
+			if err := h.deliver(c); err != nil {
+				h.logf("%v: %v", c.Warning.Code, err)
+			}
+		}
+	}
+}
+
+func (h *hook) deliver(c health.Change) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(h.ctx, hookTimeout)
+	defer cancel()
+
+	var errs []error
+	if h.c.URL != "" {
+		if err := h.post(ctx, body); err != nil {
+			errs = append(errs, fmt.Errorf("webhook: %w", err))
+		}
+	}
+	if h.c.Exec != "" {
+		if err := h.exec(ctx, c, body); err != nil {
+			errs = append(errs, fmt.Errorf("exec: %w", err))
+		}
+	}
+	return multierr.New(errs...)
+}
+
+func (h *hook) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", h.c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %v", res.Status)
+	}
+	return nil
+}
+
+func (h *hook) exec(ctx context.Context, c health.Change, body []byte) error {
+	state := "unhealthy"
+	if c.Healthy {
+		state = "healthy"
+	}
+	cmd := exec.CommandContext(ctx, h.c.Exec)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = append(os.Environ(),
+		"TS_HEALTH_CODE="+string(c.Warning.Code),
+		"TS_HEALTH_SEVERITY="+string(c.Warning.Severity),
+		"TS_HEALTH_STATE="+state,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w; output: %q", err, out)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package healthhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tailscale.com/health"
+)
+
+func TestCheckLocalURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"http://localhost:9000/health", true},
+		{"https://127.0.0.1/health", true},
+		{"http://[::1]:8080/", true},
+		{"http://example.com/health", false},
+		{"http://10.0.0.1/health", false},
+		{"ftp://localhost/health", false},
+		{"localhost:9000", false},
+	}
+	for _, tt := range tests {
+		err := checkLocalURL(tt.url)
+		if got := err == nil; got != tt.want {
+			t.Errorf("checkLocalURL(%q) = %v; want ok=%v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestDeliverWebhook(t *testing.T) {
+	got := make(chan health.Change, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var c health.Change
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		got <- c
+	}))
+	defer ts.Close()
+
+	h := &hook{
+		logf: t.Logf,
+		c:    Config{URL: ts.URL},
+		ctx:  context.Background(),
+	}
+	want := health.Change{
+		Healthy: true,
+		Warning: health.UnhealthyState{
+			Code:     "test-problem",
+			Severity: health.SeverityLow,
+			Args:     health.Args{"thing": "foo"},
+		},
+	}
+	if err := h.deliver(want); err != nil {
+		t.Fatal(err)
+	}
+	c := <-got
+	if !c.Healthy || c.Warning.Code != want.Warning.Code || c.Warning.Args["thing"] != "foo" {
+		t.Errorf("webhook got %+v; want %+v", c, want)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// LocalAPITokenHeader is the HTTP request header in which LocalAPI callers
+// present a LocalAPI token.
+const LocalAPITokenHeader = "Tailscale-API-Token"
+
+// LocalAPI token scopes. A token grants access to the LocalAPI methods of
+// its scopes, and to nothing else, regardless of the permissions the
+// caller would otherwise have.
+const (
+	// LocalAPIScopeReadStatus permits reading status, WhoIs and health,
+	// like OperatorPermStatusRead.
+	LocalAPIScopeReadStatus = "read-status"
+
+	// LocalAPIScopeManageServe permits reading and changing the serve
+	// config, but not adding handlers that serve local files or forward
+	// TCP to local ports (see ServeConfig.LocalTargets), like
+	// OperatorPermServeWrite.
+	LocalAPIScopeManageServe = "manage-serve"
+
+	// LocalAPIScopeManagePrefs permits reading prefs and changing the
+	// few that only affect the device's own connectivity, such as
+	// ShieldsUp and ExitNodeID, like OperatorPermPrefsWrite.
+	LocalAPIScopeManagePrefs = "manage-prefs"
+)
+
+// LocalAPIScopes are the valid LocalAPI token scopes.
+var LocalAPIScopes = []string{
+	LocalAPIScopeReadStatus,
+	LocalAPIScopeManageServe,
+	LocalAPIScopeManagePrefs,
+}
+
+// localAPIScopePermissions are the operator permissions that each LocalAPI
+// token scope grants. Tokens and operator permissions are enforced alike;
+// scopes are their names on tokens.
+var localAPIScopePermissions = map[string]string{
+	LocalAPIScopeReadStatus:  OperatorPermStatusRead,
+	LocalAPIScopeManageServe: OperatorPermServeWrite,
+	LocalAPIScopeManagePrefs: OperatorPermPrefsWrite,
+}
+
+// CheckLocalAPIScopes returns an error if scopes is empty or contains an
+// unknown scope.
+func CheckLocalAPIScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("no scopes given; want one or more of %q", LocalAPIScopes)
+	}
+	for _, s := range scopes {
+		if !slices.Contains(LocalAPIScopes, s) {
+			return fmt.Errorf("unknown scope %q; want one or more of %q", s, LocalAPIScopes)
+		}
+	}
+	return nil
+}
+
+// LocalAPIToken describes a LocalAPI token, without its secret.
+type LocalAPIToken struct {
+	// ID identifies the token, such as when revoking it. It's also a
+	// prefix of the token itself.
+	ID string
+
+	// Name is a description of what the token is for.
+	Name string `json:",omitempty"`
+
+	// Scopes are the scopes the token grants.
+	Scopes []string
+
+	// Created is when the token was created.
+	Created time.Time
+}
+
+// HasScope reports whether t grants scope.
+func (t LocalAPIToken) HasScope(scope string) bool {
+	return slices.Contains(t.Scopes, scope)
+}
+
+// Permissions returns the operator permissions that t's scopes grant.
+func (t LocalAPIToken) Permissions() []string {
+	var perms []string
+	for _, s := range t.Scopes {
+		if p, ok := localAPIScopePermissions[s]; ok {
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
+
+// CreateLocalAPITokenRequest is the body POSTed to the LocalAPI endpoint
+// /api-tokens to create a token.
+type CreateLocalAPITokenRequest struct {
+	Name   string
+	Scopes []string
+}
+
+// CreateLocalAPITokenResponse is the response to a
+// CreateLocalAPITokenRequest.
+type CreateLocalAPITokenResponse struct {
+	// Token is the new token. It's shown only once.
+	Token string
+
+	// Info describes the new token.
+	Info LocalAPIToken
+}
@@ -121,6 +121,24 @@ type Notify struct {
 	// is available.
 	ClientVersion *tailcfg.ClientVersion `json:",omitempty"`
 
+	// BandwidthAlert, if non-nil, reports that the node's traffic in the
+	// current usage period has crossed a threshold of its bandwidth quota.
+	BandwidthAlert *BandwidthAlert `json:",omitempty"`
+
+	// FilterSchedule, if non-nil, reports that the time windows of
+	// packet filter rules opened or closed, changing which rules apply.
+	FilterSchedule *FilterScheduleChange `json:",omitempty"`
+
+	// ExitNodeConsent, if non-nil, is a notice that a policy changed the
+	// exit node, which the user should be shown and asked to
+	// acknowledge with LocalClient.AcknowledgeExitNodeConsent.
+	ExitNodeConsent *ExitNodeConsent `json:",omitempty"`
+
+	// PeerSilent, if non-nil, reports that a peer stopped answering on
+	// its direct path mid-flow. Its traffic is relayed over DERP while
+	// its paths are revalidated.
+	PeerSilent *PeerSilent `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -160,10 +178,91 @@ func (n Notify) String() string {
 	if n.LocalTCPPort != nil {
 		fmt.Fprintf(&sb, "tcpport=%v ", n.LocalTCPPort)
 	}
+	if n.BandwidthAlert != nil {
+		fmt.Fprintf(&sb, "bandwidth=%d%% ", n.BandwidthAlert.Percent)
+	}
+	if n.FilterSchedule != nil {
+		fmt.Fprintf(&sb, "filtersched=%d/%d ", n.FilterSchedule.ActiveRules, n.FilterSchedule.ActiveRules+n.FilterSchedule.InactiveRules)
+	}
+	if n.ExitNodeConsent != nil {
+		fmt.Fprintf(&sb, "exitnodeconsent=%s ", n.ExitNodeConsent.ID)
+	}
+	if n.PeerSilent != nil {
+		fmt.Fprintf(&sb, "peersilent=%s ", n.PeerSilent.ID)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
 
+// ByteCounts are the bytes received and sent by a node.
+type ByteCounts struct {
+	RxBytes uint64
+	TxBytes uint64
+}
+
+// Total returns the bytes in both directions.
+func (c ByteCounts) Total() uint64 {
+	return c.RxBytes + c.TxBytes
+}
+
+// BandwidthUsage is the traffic a node has exchanged over Tailscale in a
+// usage period of its BandwidthQuotaPrefs.
+type BandwidthUsage struct {
+	// PeriodStart and PeriodEnd are the bounds of the usage period.
+	PeriodStart time.Time
+	PeriodEnd   time.Time
+
+	// Total is the node's traffic with all its peers.
+	Total ByteCounts
+
+	// ExitNodes is the part of Total exchanged with each peer while it
+	// was the node's exit node, keyed by the peer's stable node ID.
+	ExitNodes map[tailcfg.StableNodeID]ByteCounts `json:",omitempty"`
+
+	// Funnel is the part of Total carried by Funnel connections from the
+	// internet, keyed by the HostPort they were made to. RxBytes counts
+	// the requests and TxBytes the responses.
+	Funnel map[HostPort]ByteCounts `json:",omitempty"`
+
+	// QuotaBytes is the quota for the period, or zero if there is none.
+	QuotaBytes uint64 `json:",omitempty"`
+}
+
+// BandwidthAlert reports that a node's usage crossed a threshold of its
+// bandwidth quota.
+type BandwidthAlert struct {
+	// Percent is the threshold crossed, as a percentage of the quota:
+	// the quota's WarnPercent, or 100 when the quota is reached.
+	Percent int
+
+	// UsedBytes and QuotaBytes are the usage so far in the period and
+	// the period's quota.
+	UsedBytes  uint64
+	QuotaBytes uint64
+
+	// PeriodEnd is when the usage period ends and usage is reset.
+	PeriodEnd time.Time
+}
+
+// PeerSilent reports that a peer went silent on its direct path.
+type PeerSilent struct {
+	// ID and Name are the peer's stable node ID and MagicDNS name.
+	ID   tailcfg.StableNodeID
+	Name string
+}
+
+// FilterScheduleChange reports that packet filter rules limited to time
+// windows started or stopped applying.
+type FilterScheduleChange struct {
+	// ActiveRules and InactiveRules are the numbers of rules with time
+	// windows that now apply and don't.
+	ActiveRules   int
+	InactiveRules int
+
+	// NextChange is when the rules change next, or zero if they don't.
+	NextChange time.Time `json:",omitempty"`
+}
+
 // PartialFile represents an in-progress file transfer.
 type PartialFile struct {
 	Name         string    // e.g. "foo.jpg"
@@ -182,6 +281,18 @@ type PartialFile struct {
 	Done bool `json:",omitempty"`
 }
 
+// PeerNote is a user-assigned nickname and free-form notes for a peer,
+// stored locally and never sent to the control server.
+type PeerNote struct {
+	Nickname string `json:",omitempty"`
+	Notes    string `json:",omitempty"`
+}
+
+// IsZero reports whether n has neither a nickname nor notes.
+func (n PeerNote) IsZero() bool {
+	return n == PeerNote{}
+}
+
 // StateKey is an opaque identifier for a set of LocalBackend state
 // (preferences, private keys, etc.). It is also used as a key for
 // the various LoginProfiles that the instance may be signed into.
@@ -211,4 +322,49 @@ type Options struct {
 	// AuthKey is an optional node auth key used to authorize a
 	// new node key without user interaction.
 	AuthKey string
+	// PrefSource is recorded as the source of the prefs UpdatePrefs
+	// changes. It's set by the LocalAPI from the PrefSourceHeader, not
+	// sent by clients.
+	PrefSource PrefSource `json:"-"`
+}
+
+// WantRunningSchedule is a timed change of the WantRunning pref, as made by
+// "tailscale up" and "tailscale down" with --after or --until.
+type WantRunningSchedule struct {
+	// WantRunning is the value WantRunning is set to.
+	WantRunning bool
+
+	// After is when WantRunning is set. The zero value means now, or
+	// that it has already been set.
+	After time.Time
+
+	// Until, if non-zero, is when WantRunning is set back to its
+	// opposite, ending the window.
+	Until time.Time
+}
+
+// AutoUpdateStatus is the state of automatic updates of the node agent.
+type AutoUpdateStatus struct {
+	// Current is the running version.
+	Current string
+
+	// Pending is the newer version available, if any.
+	Pending string `json:",omitempty"`
+
+	// PendingSince is when Pending was first seen available by this
+	// run of tailscaled.
+	PendingSince time.Time
+
+	// Deferred, if non-empty, is why Pending isn't being applied now
+	// under the AutoUpdate prefs' policy.
+	Deferred string `json:",omitempty"`
+
+	// Applying is whether an update is being applied.
+	Applying bool `json:",omitempty"`
+
+	// Applied is the version most recently applied by an automatic
+	// update, if the node is running it, and AppliedAt is when that update
+	// was started.
+	Applied   string `json:",omitempty"`
+	AppliedAt time.Time
 }
@@ -0,0 +1,277 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/net/dns/publicdns"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
+	"tailscale.com/types/preftype"
+)
+
+// ConfigVAlpha is the config file format for the "alpha0" version.
+//
+// Each field left unset leaves the corresponding pref alone, so it can
+// still be changed with "tailscale set".
+type ConfigVAlpha struct {
+	Version string   // "alpha0" for now
+	Locked  opt.Bool `json:",omitempty"` // whether prefs set by the config may not be changed imperatively; defaults to true
+
+	ServerURL *string  `json:",omitempty"` // defaults to https://controlplane.tailscale.com
+	AuthKey   *string  `json:",omitempty"` // as needed if NeedsLogin; may be "file:<path>" or use ${ENV_VAR}, see package conffile
+	Enabled   opt.Bool `json:",omitempty"` // WantRunning; if empty, tailscaled starts running, but it's not locked
+
+	OperatorUser *string `json:",omitempty"` // local user name who is allowed to operate tailscaled without being root or using sudo
+	Hostname     *string `json:",omitempty"`
+
+	// Operators grants local users and groups some of the permissions
+	// of OperatorUser. See OperatorPermissions.
+	Operators []OperatorGrant `json:",omitempty"`
+
+	AcceptDNS    opt.Bool `json:"acceptDNS,omitempty"` // --accept-dns
+	AcceptRoutes opt.Bool `json:"acceptRoutes,omitempty"`
+
+	ExitNode                   *string  `json:"exitNode,omitempty"` // IP or StableID
+	AllowLANWhileUsingExitNode opt.Bool `json:"allowLANWhileUsingExitNode,omitempty"`
+
+	AdvertiseRoutes []netip.Prefix `json:",omitempty"`
+	DisableSNAT     opt.Bool       `json:",omitempty"`
+
+	NetfilterMode *string `json:",omitempty"` // "on", "off", "nodivert"
+
+	RunSSHServer opt.Bool `json:",omitempty"` // Tailscale SSH
+	ShieldsUp    opt.Bool `json:",omitempty"`
+
+	AutoUpdate *AutoUpdatePrefs `json:",omitempty"`
+
+	// ServeConfigTemp is the serve config to use. While set, it replaces
+	// any serve config set with "tailscale serve" or "tailscale funnel".
+	ServeConfigTemp *ServeConfig `json:",omitempty"`
+
+	// TaildropHook, if set, is run whenever a Taildrop file is received.
+	TaildropHook *TaildropHook `json:",omitempty"`
+
+	// DoHProviders are DNS providers to know in addition to the built-in
+	// public ones, so that DNS queries to their IPs are upgraded to
+	// DNS-over-HTTPS.
+	DoHProviders []publicdns.Provider `json:",omitempty"`
+
+	// Backoff configures how persistently failed connections to the
+	// coordination server, DERP servers and the log server are retried.
+	Backoff *BackoffConfig `json:",omitempty"`
+}
+
+// BackoffConfig configures the retry policies of connections, such as to
+// tune reconnection on flaky or metered links.
+type BackoffConfig struct {
+	// Preset is the set of policies to start from: "interactive", the
+	// default, or "server", which retries less aggressively.
+	Preset string `json:",omitempty"`
+
+	// Control, DERP and Log override settings of the preset's policies
+	// for connections to the coordination server, DERP servers and the
+	// log server, respectively.
+	Control *BackoffPolicy `json:",omitempty"`
+	DERP    *BackoffPolicy `json:",omitempty"`
+	Log     *BackoffPolicy `json:",omitempty"`
+}
+
+// BackoffPolicy overrides the settings of a retry policy that are set.
+// See backoff.Policy for what they mean.
+type BackoffPolicy struct {
+	MaxBackoff    string  `json:",omitempty"` // a Go duration, such as "2m"
+	Jitter        float64 `json:",omitempty"` // between 0 and 1
+	FailFastAfter int     `json:",omitempty"` // consecutive failures
+}
+
+// Policies returns the retry policies c configures, by component.
+func (c *BackoffConfig) Policies() (map[backoff.Component]backoff.Policy, error) {
+	var preset backoff.Preset
+	if c != nil {
+		var err error
+		if preset, err = backoff.ParsePreset(c.Preset); err != nil {
+			return nil, fmt.Errorf("Backoff: %w", err)
+		}
+	}
+	ret := make(map[backoff.Component]backoff.Policy)
+	for _, comp := range backoff.Components {
+		p := preset.Policy(comp)
+		var bp *BackoffPolicy
+		if c != nil {
+			switch comp {
+			case backoff.Control:
+				bp = c.Control
+			case backoff.DERP:
+				bp = c.DERP
+			case backoff.Log:
+				bp = c.Log
+			}
+		}
+		if bp != nil {
+			if bp.MaxBackoff != "" {
+				d, err := time.ParseDuration(bp.MaxBackoff)
+				if err != nil {
+					return nil, fmt.Errorf("Backoff.%s: %w", comp, err)
+				}
+				p.MaxBackoff = d
+			}
+			if bp.Jitter != 0 {
+				p.Jitter = bp.Jitter
+			}
+			if bp.FailFastAfter != 0 {
+				p.FailFastAfter = bp.FailFastAfter
+			}
+		}
+		if err := p.Check(); err != nil {
+			return nil, fmt.Errorf("Backoff.%s: %w", comp, err)
+		}
+		ret[comp] = p
+	}
+	return ret, nil
+}
+
+// TaildropHook is an action taken when a Taildrop file is received, so
+// headless nodes can process files without polling "tailscale file get".
+// Exactly one of Exec or URL must be set.
+type TaildropHook struct {
+	// Exec is a command and its arguments to run. It's run as the user
+	// tailscaled runs as, with the details of the file in its environment:
+	// TS_TAILDROP_PATH, TS_TAILDROP_NAME, TS_TAILDROP_SIZE,
+	// TS_TAILDROP_FROM_NODE, TS_TAILDROP_FROM_IP and TS_TAILDROP_FROM_USER.
+	Exec []string `json:",omitempty"`
+
+	// URL is an http or https URL on localhost to POST a
+	// TaildropHookEvent to, as JSON.
+	URL string `json:",omitempty"`
+}
+
+// Check reports whether h is valid.
+func (h *TaildropHook) Check() error {
+	if (len(h.Exec) == 0) == (h.URL == "") {
+		return errors.New("TaildropHook: exactly one of Exec or URL must be set")
+	}
+	if len(h.Exec) > 0 && h.Exec[0] == "" {
+		return errors.New("TaildropHook: empty Exec command")
+	}
+	if h.URL == "" {
+		return nil
+	}
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return fmt.Errorf("TaildropHook: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("TaildropHook: URL %q is not http or https", h.URL)
+	}
+	if host := u.Hostname(); host != "localhost" {
+		if ip, err := netip.ParseAddr(host); err != nil || !ip.IsLoopback() {
+			return fmt.Errorf("TaildropHook: URL %q is not on localhost", h.URL)
+		}
+	}
+	return nil
+}
+
+// TaildropHookEvent describes a received Taildrop file to a TaildropHook.
+type TaildropHookEvent struct {
+	Path     string     // where the file is on disk
+	Name     string     // the file's base name, as sent
+	Size     int64      // in bytes
+	FromNode string     // sender's node name
+	FromIP   netip.Addr // sender's Tailscale IP
+	FromUser string     // sender's login name
+}
+
+// IsLocked reports whether the prefs set by c may not be changed by
+// anything other than editing the config file.
+func (c *ConfigVAlpha) IsLocked() bool {
+	return c != nil && !c.Locked.EqualBool(false)
+}
+
+// ToPrefs returns the prefs set by c.
+func (c *ConfigVAlpha) ToPrefs() (MaskedPrefs, error) {
+	var mp MaskedPrefs
+	if c == nil {
+		return mp, nil
+	}
+	if c.Enabled != "" {
+		mp.WantRunning = c.Enabled.EqualBool(true)
+		mp.WantRunningSet = true
+	}
+	if c.ServerURL != nil {
+		mp.ControlURL = *c.ServerURL
+		mp.ControlURLSet = true
+	}
+	if c.OperatorUser != nil {
+		mp.OperatorUser = *c.OperatorUser
+		mp.OperatorUserSet = true
+	}
+	if c.Hostname != nil {
+		mp.Hostname = *c.Hostname
+		mp.HostnameSet = true
+	}
+	if c.AcceptDNS != "" {
+		mp.CorpDNS = c.AcceptDNS.EqualBool(true)
+		mp.CorpDNSSet = true
+	}
+	if c.AcceptRoutes != "" {
+		mp.RouteAll = c.AcceptRoutes.EqualBool(true)
+		mp.RouteAllSet = true
+	}
+	if c.ExitNode != nil {
+		if ip, err := netip.ParseAddr(*c.ExitNode); err == nil {
+			mp.ExitNodeIP = ip
+		} else {
+			mp.ExitNodeID = tailcfg.StableNodeID(*c.ExitNode)
+		}
+		// Set both, so that switching between an IP and an ID in the
+		// config file clears the other.
+		mp.ExitNodeIPSet = true
+		mp.ExitNodeIDSet = true
+	}
+	if c.AllowLANWhileUsingExitNode != "" {
+		mp.ExitNodeAllowLANAccess = c.AllowLANWhileUsingExitNode.EqualBool(true)
+		mp.ExitNodeAllowLANAccessSet = true
+	}
+	if c.AdvertiseRoutes != nil {
+		mp.AdvertiseRoutes = c.AdvertiseRoutes
+		mp.AdvertiseRoutesSet = true
+	}
+	if c.DisableSNAT != "" {
+		mp.NoSNAT = c.DisableSNAT.EqualBool(true)
+		mp.NoSNATSet = true
+	}
+	if c.NetfilterMode != nil {
+		switch *c.NetfilterMode {
+		case "on":
+			mp.NetfilterMode = preftype.NetfilterOn
+		case "nodivert":
+			mp.NetfilterMode = preftype.NetfilterNoDivert
+		case "off":
+			mp.NetfilterMode = preftype.NetfilterOff
+		default:
+			return MaskedPrefs{}, fmt.Errorf("invalid NetfilterMode %q; want one of on, nodivert, off", *c.NetfilterMode)
+		}
+		mp.NetfilterModeSet = true
+	}
+	if c.RunSSHServer != "" {
+		mp.RunSSH = c.RunSSHServer.EqualBool(true)
+		mp.RunSSHSet = true
+	}
+	if c.ShieldsUp != "" {
+		mp.ShieldsUp = c.ShieldsUp.EqualBool(true)
+		mp.ShieldsUpSet = true
+	}
+	if c.AutoUpdate != nil {
+		mp.AutoUpdate = *c.AutoUpdate
+		mp.AutoUpdateSet = true
+	}
+	return mp, nil
+}
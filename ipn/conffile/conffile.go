@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package conffile contains code to load, manipulate, and access config file
+// settings for tailscaled's declarative configuration mode.
+package conffile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/ipn"
+)
+
+// Config describes a config file.
+type Config struct {
+	Path    string // disk path of HuJSON
+	Raw     []byte // raw bytes from disk, in HuJSON form
+	Std     []byte // standardized JSON form
+	Version string // "alpha0" for now
+
+	// Parsed is the parsed config, converted from its on-disk version to the
+	// latest known format, with environment variable and secret file
+	// references in its values expanded.
+	//
+	// As of 2023-10-15 there exists only one format ("alpha0") so this is
+	// both the on-disk format and the in-memory upgraded format.
+	Parsed ipn.ConfigVAlpha
+}
+
+// WantRunning reports whether c is non-nil and it's configured to be running.
+func (c *Config) WantRunning() bool {
+	return c != nil && !c.Parsed.Enabled.EqualBool(false)
+}
+
+// Load reads and parses the config file at the provided path on disk.
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(path, raw)
+}
+
+// Parse parses the HuJSON config file contents raw, read from path.
+func Parse(path string, raw []byte) (*Config, error) {
+	c := &Config{
+		Path: path,
+		Raw:  raw,
+	}
+	var err error
+	c.Std, err = hujson.Standardize(bytes.Clone(raw))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing config file %s HuJSON/JSON: %w", path, err)
+	}
+	var ver struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(c.Std, &ver); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	switch ver.Version {
+	case "":
+		return nil, fmt.Errorf("error parsing config file %s: no \"version\" field defined", path)
+	case "alpha0":
+	default:
+		return nil, fmt.Errorf("error parsing config file %s: unsupported \"version\" value %q; want \"alpha0\" for now", path, ver.Version)
+	}
+	c.Version = ver.Version
+
+	dec := json.NewDecoder(bytes.NewReader(c.Std))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c.Parsed); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	if err := expandValues(&c.Parsed); err != nil {
+		return nil, fmt.Errorf("error in config file %s: %w", path, err)
+	}
+	if _, err := c.Parsed.ToPrefs(); err != nil {
+		return nil, fmt.Errorf("error in config file %s: %w", path, err)
+	}
+	if h := c.Parsed.TaildropHook; h != nil {
+		if err := h.Check(); err != nil {
+			return nil, fmt.Errorf("error in config file %s: %w", path, err)
+		}
+	}
+	for _, g := range c.Parsed.Operators {
+		if err := g.Check(); err != nil {
+			return nil, fmt.Errorf("error in config file %s: %w", path, err)
+		}
+	}
+	if _, err := c.Parsed.Backoff.Policies(); err != nil {
+		return nil, fmt.Errorf("error in config file %s: %w", path, err)
+	}
+	for _, p := range c.Parsed.DoHProviders {
+		if err := p.Check(); err != nil {
+			return nil, fmt.Errorf("error in config file %s: %w", path, err)
+		}
+	}
+	return c, nil
+}
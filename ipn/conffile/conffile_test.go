@@ -0,0 +1,155 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conffile
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/preftype"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse("test.conf", []byte(`{
+		// Comments and trailing commas are allowed.
+		"version": "alpha0",
+		"Hostname": "gw",
+		"exitNode": "100.64.1.2",
+		"AdvertiseRoutes": ["10.0.0.0/24"],
+		"NetfilterMode": "nodivert",
+		"RunSSHServer": true,
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.WantRunning() {
+		t.Error("WantRunning = false; want true by default")
+	}
+	if !c.Parsed.IsLocked() {
+		t.Error("IsLocked = false; want true by default")
+	}
+	mp, err := c.Parsed.ToPrefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mp.HostnameSet || mp.Hostname != "gw" {
+		t.Errorf("Hostname = %q (set=%v)", mp.Hostname, mp.HostnameSet)
+	}
+	if !mp.ExitNodeIPSet || mp.ExitNodeIP != netip.MustParseAddr("100.64.1.2") || !mp.ExitNodeIDSet || mp.ExitNodeID != "" {
+		t.Errorf("ExitNodeIP = %v, ExitNodeID = %q", mp.ExitNodeIP, mp.ExitNodeID)
+	}
+	if len(mp.AdvertiseRoutes) != 1 || mp.AdvertiseRoutes[0] != netip.MustParsePrefix("10.0.0.0/24") {
+		t.Errorf("AdvertiseRoutes = %v", mp.AdvertiseRoutes)
+	}
+	if mp.NetfilterMode != preftype.NetfilterNoDivert {
+		t.Errorf("NetfilterMode = %v", mp.NetfilterMode)
+	}
+	if !mp.RunSSHSet || !mp.RunSSH {
+		t.Errorf("RunSSH = %v (set=%v)", mp.RunSSH, mp.RunSSHSet)
+	}
+	if mp.ShieldsUpSet || mp.CorpDNSSet {
+		t.Errorf("unexpected prefs set: %v", mp.Pretty())
+	}
+
+	c, err = Parse("test.conf", []byte(`{"version": "alpha0", "Enabled": false, "Locked": false, "exitNode": "nXYZ"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.WantRunning() || c.Parsed.IsLocked() {
+		t.Errorf("WantRunning = %v, IsLocked = %v; want false, false", c.WantRunning(), c.Parsed.IsLocked())
+	}
+	if mp, _ := c.Parsed.ToPrefs(); mp.ExitNodeID != tailcfg.StableNodeID("nXYZ") || mp.ExitNodeIP.IsValid() {
+		t.Errorf("ExitNodeID = %q, ExitNodeIP = %v", mp.ExitNodeID, mp.ExitNodeIP)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr string
+	}{
+		{"no-version", `{"Hostname": "x"}`, `no "version" field`},
+		{"bad-version", `{"version": "beta9"}`, `unsupported "version"`},
+		{"unknown-field", `{"version": "alpha0", "Bogus": 1}`, `unknown field`},
+		{"bad-netfilter", `{"version": "alpha0", "NetfilterMode": "maybe"}`, `invalid NetfilterMode`},
+		{"bad-json", `{"version": `, `HuJSON/JSON`},
+		{"hook-empty", `{"version": "alpha0", "TaildropHook": {}}`, `exactly one of Exec or URL`},
+		{"hook-both", `{"version": "alpha0", "TaildropHook": {"Exec": ["true"], "URL": "http://localhost/"}}`, `exactly one of Exec or URL`},
+		{"hook-remote-url", `{"version": "alpha0", "TaildropHook": {"URL": "http://example.com/drop"}}`, `not on localhost`},
+		{"hook-bad-scheme", `{"version": "alpha0", "TaildropHook": {"URL": "ftp://localhost/x"}}`, `not http or https`},
+		{"doh-not-https", `{"version": "alpha0", "DoHProviders": [{"DoH": "http://doh.example/dns-query", "IPs": ["10.0.0.53"]}]}`, `not an https URL`},
+		{"operator-no-user", `{"version": "alpha0", "Operators": [{"Permissions": ["status:read"]}]}`, `exactly one of User or Group`},
+		{"operator-bad-perm", `{"version": "alpha0", "Operators": [{"Group": "web", "Permissions": ["serve:read"]}]}`, `unknown permission "serve:read"`},
+		{"backoff-preset", `{"version": "alpha0", "Backoff": {"Preset": "fast"}}`, `unknown backoff preset`},
+		{"backoff-duration", `{"version": "alpha0", "Backoff": {"Control": {"MaxBackoff": "soon"}}}`, `Backoff.control`},
+		{"backoff-jitter", `{"version": "alpha0", "Backoff": {"DERP": {"Jitter": 2}}}`, `not between 0 and 1`},
+		{"doh-no-ips", `{"version": "alpha0", "DoHProviders": [{"DoH": "https://doh.example/dns-query"}]}`, `has no IPs`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("test.conf", []byte(tt.in))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandValues(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "authkey")
+	if err := os.WriteFile(keyFile, []byte("tskey-auth-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "empty"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TS_TEST_SECRETS", dir)
+	t.Setenv("TS_TEST_HOST", "gw")
+
+	c, err := Parse("test.conf", []byte(`{
+		"version": "alpha0",
+		"AuthKey": "file:${TS_TEST_SECRETS}/authkey",
+		"Hostname": "${TS_TEST_HOST}-1",
+		"OperatorUser": "$$USER",
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := *c.Parsed.AuthKey; got != "tskey-auth-secret" {
+		t.Errorf("AuthKey = %q; want tskey-auth-secret", got)
+	}
+	if got := *c.Parsed.Hostname; got != "gw-1" {
+		t.Errorf("Hostname = %q; want gw-1", got)
+	}
+	if got := *c.Parsed.OperatorUser; got != "$USER" {
+		t.Errorf("OperatorUser = %q; want $USER", got)
+	}
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{"unset-env", "${TS_TEST_UNSET_VAR}", "AuthKey: environment variable TS_TEST_UNSET_VAR is not set"},
+		{"unterminated", "${TS_TEST_HOST", "unterminated"},
+		{"bad-name", "${1X}", "invalid environment variable name"},
+		{"missing-file", "file:" + filepath.Join(dir, "nope"), "no such file"},
+		{"empty-file", "file:${TS_TEST_SECRETS}/empty", "is empty"},
+		{"empty-path", "file:", "empty path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse("test.conf", []byte(`{"version": "alpha0", "AuthKey": "`+tt.value+`"}`))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package conffile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/util/multierr"
+)
+
+// expandValues replaces references to environment variables and secret
+// files in the string fields of c that may contain them, so that secrets
+// can be kept out of the config file itself.
+//
+// In each such field, "${NAME}" is replaced by the value of the
+// environment variable NAME and "$$" by a literal "$". Then, if the value
+// has the form "file:<path>", it's replaced by the contents of that file,
+// with leading and trailing whitespace removed.
+//
+// Unset environment variables, unreadable or empty files and malformed
+// references are all errors.
+func expandValues(c *ipn.ConfigVAlpha) error {
+	type field struct {
+		name string
+		v    *string
+	}
+	fields := []field{
+		{"ServerURL", c.ServerURL},
+		{"AuthKey", c.AuthKey},
+		{"OperatorUser", c.OperatorUser},
+		{"Hostname", c.Hostname},
+		{"exitNode", c.ExitNode},
+		{"NetfilterMode", c.NetfilterMode},
+	}
+	if c.TaildropHook != nil && c.TaildropHook.URL != "" {
+		fields = append(fields, field{"TaildropHook.URL", &c.TaildropHook.URL})
+	}
+	var errs []error
+	for _, f := range fields {
+		if f.v == nil {
+			continue
+		}
+		v, err := expandValue(*f.v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.name, err))
+			continue
+		}
+		*f.v = v
+	}
+	return multierr.New(errs...)
+}
+
+func expandValue(s string) (string, error) {
+	s, err := expandEnv(s)
+	if err != nil {
+		return "", err
+	}
+	path, ok := strings.CutPrefix(s, "file:")
+	if !ok {
+		return s, nil
+	}
+	if path == "" {
+		return "", errors.New(`empty path after "file:"`)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	v := strings.TrimSpace(string(b))
+	if v == "" {
+		return "", fmt.Errorf("file %s is empty", path)
+	}
+	return v, nil
+}
+
+// expandEnv replaces "${NAME}" in s with the value of environment variable
+// NAME and "$$" with "$". A "$" followed by anything else is left alone.
+func expandEnv(s string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 || i == len(s)-1 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		switch s[i+1] {
+		case '$':
+			b.WriteByte('$')
+			s = s[i+2:]
+		case '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated %q", s[i:])
+			}
+			name := s[i+2 : i+end]
+			if !validEnvName(name) {
+				return "", fmt.Errorf("invalid environment variable name %q", name)
+			}
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				return "", fmt.Errorf("environment variable %s is not set", name)
+			}
+			b.WriteString(v)
+			s = s[i+end+1:]
+		default:
+			b.WriteByte('$')
+			s = s[i+1:]
+		}
+	}
+}
+
+func validEnvName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
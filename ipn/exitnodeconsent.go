@@ -0,0 +1,44 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// ExitNodeConsent is a notice that a policy, rather than the user,
+// started, changed or stopped routing this node's traffic through an exit
+// node, for the user to acknowledge. Some jurisdictions require users to be
+// made aware of such redirection of their traffic.
+type ExitNodeConsent struct {
+	// ID identifies the notice, to acknowledge it.
+	ID string
+
+	// Time is when the policy changed the exit node.
+	Time time.Time
+
+	// Source is the policy that changed the exit node: the config file,
+	// the control server or the ExitNodePolicy pref.
+	Source PrefSource
+
+	// ExitNodeID and ExitNodeName are the exit node the node's traffic is
+	// now routed through. They're empty if it no longer uses one.
+	// ExitNodeIP is set instead of ExitNodeID if the exit node isn't
+	// known yet.
+	ExitNodeID   tailcfg.StableNodeID `json:",omitempty"`
+	ExitNodeIP   netip.Addr           `json:",omitempty"`
+	ExitNodeName string               `json:",omitempty"`
+
+	// Acknowledged is when the user acknowledged the notice, or zero if
+	// they haven't yet.
+	Acknowledged time.Time `json:",omitempty"`
+}
+
+// Pending reports whether c is waiting for the user to acknowledge it.
+func (c ExitNodeConsent) Pending() bool {
+	return c.Acknowledged.IsZero()
+}
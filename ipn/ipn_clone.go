@@ -22,8 +22,20 @@ func (src *Prefs) Clone() *Prefs {
 	}
 	dst := new(Prefs)
 	*dst = *src
+	dst.ExitNodeExcludeRoutes = append(src.ExitNodeExcludeRoutes[:0:0], src.ExitNodeExcludeRoutes...)
+	dst.ExitNodeExcludeUIDs = append(src.ExitNodeExcludeUIDs[:0:0], src.ExitNodeExcludeUIDs...)
+	if dst.DNSRoutes != nil {
+		dst.DNSRoutes = map[string][]string{}
+		for k := range src.DNSRoutes {
+			dst.DNSRoutes[k] = append([]string{}, src.DNSRoutes[k]...)
+		}
+	}
 	dst.AdvertiseTags = append(src.AdvertiseTags[:0:0], src.AdvertiseTags...)
 	dst.AdvertiseRoutes = append(src.AdvertiseRoutes[:0:0], src.AdvertiseRoutes...)
+	dst.ExitNodePolicy = append(src.ExitNodePolicy[:0:0], src.ExitNodePolicy...)
+	dst.AdvertiseEndpoints = append(src.AdvertiseEndpoints[:0:0], src.AdvertiseEndpoints...)
+	dst.KeepHotPeers = append(src.KeepHotPeers[:0:0], src.KeepHotPeers...)
+	dst.AllowedPeers = append(src.AllowedPeers[:0:0], src.AllowedPeers...)
 	dst.Persist = src.Persist.Clone()
 	return dst
 }
@@ -36,7 +48,10 @@ func (src *Prefs) Clone() *Prefs {
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
 	ExitNodeAllowLANAccess bool
+	ExitNodeExcludeRoutes  []netip.Prefix
+	ExitNodeExcludeUIDs    []uint32
 	CorpDNS                bool
+	DNSRoutes              map[string][]string
 	RunSSH                 bool
 	WantRunning            bool
 	LoggedOut              bool
@@ -52,6 +67,22 @@ func (src *Prefs) Clone() *Prefs {
 	OperatorUser           string
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
+	QoS                    bool
+	RelayDailyLimitMB      int
+	MetricsPort            int
+	MetricsOnTailnet       bool
+	OtherVPNPolicy         string
+	Proxy                  ProxyPrefs
+	ExitNodePolicy         []ExitNodeRule
+	FixIPForwarding        bool
+	NAT64                  NAT64Prefs
+	AdvertiseEndpoints     []netip.AddrPort
+	HideStalePeersDays     int
+	BandwidthQuota         BandwidthQuotaPrefs
+	NetcheckHistoryHours   int
+	KeepHotPeers           []string
+	KeepHotIntervalSeconds int
+	AllowedPeers           []string
 	Persist                *persist.Persist
 }{})
 
@@ -120,14 +151,29 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.AllowFrom = append(src.AllowFrom[:0:0], src.AllowFrom...)
+	dst.IdentityHeaders = append(src.IdentityHeaders[:0:0], src.IdentityHeaders...)
+	dst.Fallbacks = append(src.Fallbacks[:0:0], src.Fallbacks...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path                string
+	Proxy               string
+	Text                string
+	NoDirList           bool
+	CacheMaxAge         int
+	AllowFrom           []string
+	IdentityHeaders     []string
+	ProxyTimeout        int
+	ProxyIdleTimeout    int
+	ProxyKeepAlive      int
+	ProxyBufferSize     int
+	Fallbacks           []string
+	HealthCheck         string
+	HealthCheckInterval int
+	Maintenance         string
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
@@ -63,13 +63,25 @@ func (v *PrefsView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v PrefsView) ControlURL() string                 { return v.ж.ControlURL }
-func (v PrefsView) RouteAll() bool                     { return v.ж.RouteAll }
-func (v PrefsView) AllowSingleHosts() bool             { return v.ж.AllowSingleHosts }
-func (v PrefsView) ExitNodeID() tailcfg.StableNodeID   { return v.ж.ExitNodeID }
-func (v PrefsView) ExitNodeIP() netip.Addr             { return v.ж.ExitNodeIP }
-func (v PrefsView) ExitNodeAllowLANAccess() bool       { return v.ж.ExitNodeAllowLANAccess }
-func (v PrefsView) CorpDNS() bool                      { return v.ж.CorpDNS }
+func (v PrefsView) ControlURL() string               { return v.ж.ControlURL }
+func (v PrefsView) RouteAll() bool                   { return v.ж.RouteAll }
+func (v PrefsView) AllowSingleHosts() bool           { return v.ж.AllowSingleHosts }
+func (v PrefsView) ExitNodeID() tailcfg.StableNodeID { return v.ж.ExitNodeID }
+func (v PrefsView) ExitNodeIP() netip.Addr           { return v.ж.ExitNodeIP }
+func (v PrefsView) ExitNodeAllowLANAccess() bool     { return v.ж.ExitNodeAllowLANAccess }
+func (v PrefsView) ExitNodeExcludeRoutes() views.Slice[netip.Prefix] {
+	return views.SliceOf(v.ж.ExitNodeExcludeRoutes)
+}
+func (v PrefsView) ExitNodeExcludeUIDs() views.Slice[uint32] {
+	return views.SliceOf(v.ж.ExitNodeExcludeUIDs)
+}
+func (v PrefsView) CorpDNS() bool { return v.ж.CorpDNS }
+
+func (v PrefsView) DNSRoutes() views.MapFn[string, []string, views.Slice[string]] {
+	return views.MapFnOf(v.ж.DNSRoutes, func(t []string) views.Slice[string] {
+		return views.SliceOf(t)
+	})
+}
 func (v PrefsView) RunSSH() bool                       { return v.ж.RunSSH }
 func (v PrefsView) WantRunning() bool                  { return v.ж.WantRunning }
 func (v PrefsView) LoggedOut() bool                    { return v.ж.LoggedOut }
@@ -87,7 +99,27 @@ func (v PrefsView) NetfilterMode() preftype.NetfilterMode { return v.ж.Netfilte
 func (v PrefsView) OperatorUser() string                  { return v.ж.OperatorUser }
 func (v PrefsView) ProfileName() string                   { return v.ж.ProfileName }
 func (v PrefsView) AutoUpdate() AutoUpdatePrefs           { return v.ж.AutoUpdate }
-func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.View() }
+func (v PrefsView) QoS() bool                             { return v.ж.QoS }
+func (v PrefsView) RelayDailyLimitMB() int                { return v.ж.RelayDailyLimitMB }
+func (v PrefsView) MetricsPort() int                      { return v.ж.MetricsPort }
+func (v PrefsView) MetricsOnTailnet() bool                { return v.ж.MetricsOnTailnet }
+func (v PrefsView) OtherVPNPolicy() string                { return v.ж.OtherVPNPolicy }
+func (v PrefsView) Proxy() ProxyPrefs                     { return v.ж.Proxy }
+func (v PrefsView) ExitNodePolicy() views.Slice[ExitNodeRule] {
+	return views.SliceOf(v.ж.ExitNodePolicy)
+}
+func (v PrefsView) FixIPForwarding() bool { return v.ж.FixIPForwarding }
+func (v PrefsView) NAT64() NAT64Prefs     { return v.ж.NAT64 }
+func (v PrefsView) AdvertiseEndpoints() views.Slice[netip.AddrPort] {
+	return views.SliceOf(v.ж.AdvertiseEndpoints)
+}
+func (v PrefsView) HideStalePeersDays() int             { return v.ж.HideStalePeersDays }
+func (v PrefsView) BandwidthQuota() BandwidthQuotaPrefs { return v.ж.BandwidthQuota }
+func (v PrefsView) NetcheckHistoryHours() int           { return v.ж.NetcheckHistoryHours }
+func (v PrefsView) KeepHotPeers() views.Slice[string]   { return views.SliceOf(v.ж.KeepHotPeers) }
+func (v PrefsView) KeepHotIntervalSeconds() int         { return v.ж.KeepHotIntervalSeconds }
+func (v PrefsView) AllowedPeers() views.Slice[string]   { return views.SliceOf(v.ж.AllowedPeers) }
+func (v PrefsView) Persist() persist.PersistView        { return v.ж.Persist.View() }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
@@ -97,7 +129,10 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
 	ExitNodeAllowLANAccess bool
+	ExitNodeExcludeRoutes  []netip.Prefix
+	ExitNodeExcludeUIDs    []uint32
 	CorpDNS                bool
+	DNSRoutes              map[string][]string
 	RunSSH                 bool
 	WantRunning            bool
 	LoggedOut              bool
@@ -113,6 +148,22 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	OperatorUser           string
 	ProfileName            string
 	AutoUpdate             AutoUpdatePrefs
+	QoS                    bool
+	RelayDailyLimitMB      int
+	MetricsPort            int
+	MetricsOnTailnet       bool
+	OtherVPNPolicy         string
+	Proxy                  ProxyPrefs
+	ExitNodePolicy         []ExitNodeRule
+	FixIPForwarding        bool
+	NAT64                  NAT64Prefs
+	AdvertiseEndpoints     []netip.AddrPort
+	HideStalePeersDays     int
+	BandwidthQuota         BandwidthQuotaPrefs
+	NetcheckHistoryHours   int
+	KeepHotPeers           []string
+	KeepHotIntervalSeconds int
+	AllowedPeers           []string
 	Persist                *persist.Persist
 }{})
 
@@ -294,15 +345,41 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string                   { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string                  { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string                   { return v.ж.Text }
+func (v HTTPHandlerView) NoDirList() bool                { return v.ж.NoDirList }
+func (v HTTPHandlerView) CacheMaxAge() int               { return v.ж.CacheMaxAge }
+func (v HTTPHandlerView) AllowFrom() views.Slice[string] { return views.SliceOf(v.ж.AllowFrom) }
+func (v HTTPHandlerView) IdentityHeaders() views.Slice[string] {
+	return views.SliceOf(v.ж.IdentityHeaders)
+}
+func (v HTTPHandlerView) ProxyTimeout() int              { return v.ж.ProxyTimeout }
+func (v HTTPHandlerView) ProxyIdleTimeout() int          { return v.ж.ProxyIdleTimeout }
+func (v HTTPHandlerView) ProxyKeepAlive() int            { return v.ж.ProxyKeepAlive }
+func (v HTTPHandlerView) ProxyBufferSize() int           { return v.ж.ProxyBufferSize }
+func (v HTTPHandlerView) Fallbacks() views.Slice[string] { return views.SliceOf(v.ж.Fallbacks) }
+func (v HTTPHandlerView) HealthCheck() string            { return v.ж.HealthCheck }
+func (v HTTPHandlerView) HealthCheckInterval() int       { return v.ж.HealthCheckInterval }
+func (v HTTPHandlerView) Maintenance() string            { return v.ж.Maintenance }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path                string
+	Proxy               string
+	Text                string
+	NoDirList           bool
+	CacheMaxAge         int
+	AllowFrom           []string
+	IdentityHeaders     []string
+	ProxyTimeout        int
+	ProxyIdleTimeout    int
+	ProxyKeepAlive      int
+	ProxyBufferSize     int
+	Fallbacks           []string
+	HealthCheck         string
+	HealthCheckInterval int
+	Maintenance         string
 }{})
 
 // View returns a readonly view of WebServerConfig.
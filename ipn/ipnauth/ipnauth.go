@@ -11,6 +11,7 @@
 	"os"
 	"os/user"
 	"runtime"
+	"slices"
 	"strconv"
 
 	"inet.af/peercred"
@@ -160,6 +161,53 @@ func (ci *ConnIdentity) IsReadonlyConn(operatorUID string, logf logger.Logf) boo
 	return ro
 }
 
+// OperatorPermissions returns the operator permissions that grants give
+// the connection's user, either directly or through membership of a group.
+// See ipn.OperatorPermissions.
+//
+// Like IsReadonlyConn, it's not used on Windows, where it returns nil.
+func (ci *ConnIdentity) OperatorPermissions(grants []ipn.OperatorGrant) []string {
+	if runtime.GOOS == "windows" || len(grants) == 0 || ci.creds == nil {
+		return nil
+	}
+	uid, ok := ci.creds.UserID()
+	if !ok {
+		return nil
+	}
+	var u *user.User // looked up lazily
+	lookup := func() *user.User {
+		if u == nil {
+			var err error
+			if u, err = user.LookupId(uid); err != nil {
+				u = &user.User{Uid: uid}
+			}
+		}
+		return u
+	}
+	var perms []string
+	for _, g := range grants {
+		switch {
+		case g.User != "":
+			if g.User != uid && g.User != lookup().Username {
+				continue
+			}
+		case g.Group != "":
+			if lookup().Username == "" {
+				continue
+			}
+			if yes, err := groupmember.IsMemberOfGroup(g.Group, u.Username); err != nil || !yes {
+				continue
+			}
+		}
+		for _, p := range g.Permissions {
+			if !slices.Contains(perms, p) {
+				perms = append(perms, p)
+			}
+		}
+	}
+	return perms
+}
+
 func isLocalAdmin(uid string) (bool, error) {
 	u, err := user.LookupId(uid)
 	if err != nil {
@@ -0,0 +1,141 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/set"
+	"tailscale.com/wgengine/filter"
+)
+
+// AccessSummary returns who the packet filter lets reach this node, and on
+// which ports.
+func (b *LocalBackend) AccessSummary() (*ipnstate.AccessSummary, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	return accessSummary(nm), nil
+}
+
+// accessSummary returns the sources that the rules of nm's packet filter
+// let reach nm's own Tailscale IPs.
+func accessSummary(nm *netmap.NetworkMap) *ipnstate.AccessSummary {
+	toSelf := func(dst netip.Prefix) bool {
+		for _, a := range nm.Addresses {
+			if a.IsSingleIP() && dst.Contains(a.Addr()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	peerPorts := map[tailcfg.StableNodeID]set.Set[string]{}
+	sourcePorts := map[netip.Prefix]set.Set[string]{}
+	for _, m := range nm.PacketFilter {
+		var ports []string
+		for _, dst := range m.Dsts {
+			if !toSelf(dst.Net) {
+				continue
+			}
+			for _, proto := range m.IPProto {
+				if p := formatAccessPorts(proto, dst.Ports); p != "" {
+					ports = append(ports, p)
+				}
+			}
+		}
+		if len(ports) == 0 {
+			continue
+		}
+		for _, src := range m.Srcs {
+			isPeer := false
+			for _, p := range nm.Peers {
+				for i := range p.Addresses().LenIter() {
+					a := p.Addresses().At(i)
+					if !a.IsSingleIP() || !src.Contains(a.Addr()) {
+						continue
+					}
+					addPorts(peerPorts, p.StableID(), ports)
+					if src == a {
+						isPeer = true
+					}
+				}
+			}
+			if !isPeer {
+				addPorts(sourcePorts, src, ports)
+			}
+		}
+	}
+
+	sum := &ipnstate.AccessSummary{}
+	for _, p := range nm.Peers {
+		ports, ok := peerPorts[p.StableID()]
+		if !ok {
+			continue
+		}
+		sum.Peers = append(sum.Peers, ipnstate.PeerAccess{
+			ID:      p.StableID(),
+			DNSName: p.Name(),
+			Tags:    p.Tags().AsSlice(),
+			Ports:   sortedPorts(ports),
+		})
+	}
+	slices.SortFunc(sum.Peers, func(a, b ipnstate.PeerAccess) int {
+		return strings.Compare(a.DNSName, b.DNSName)
+	})
+	for src, ports := range sourcePorts {
+		sum.Sources = append(sum.Sources, ipnstate.SourceAccess{
+			Prefix: src,
+			Ports:  sortedPorts(ports),
+		})
+	}
+	slices.SortFunc(sum.Sources, func(a, b ipnstate.SourceAccess) int {
+		if c := a.Prefix.Addr().Compare(b.Prefix.Addr()); c != 0 {
+			return c
+		}
+		return a.Prefix.Bits() - b.Prefix.Bits()
+	})
+	return sum
+}
+
+// formatAccessPorts formats the ports of proto in pr for
+// ipnstate.PeerAccess.Ports, or returns the empty string for protocols
+// that aren't reachable from peers.
+func formatAccessPorts(proto ipproto.Proto, pr filter.PortRange) string {
+	switch proto {
+	case ipproto.ICMPv4, ipproto.ICMPv6:
+		return "icmp"
+	case ipproto.TCP, ipproto.UDP, ipproto.SCTP, ipproto.DCCP:
+		return strings.ToLower(proto.String()) + ":" + pr.String()
+	case ipproto.Unknown, ipproto.Fragment, ipproto.TSMP:
+		return ""
+	}
+	return strings.ToLower(proto.String())
+}
+
+func addPorts[K comparable](m map[K]set.Set[string], k K, ports []string) {
+	if m[k] == nil {
+		m[k] = set.Set[string]{}
+	}
+	for _, p := range ports {
+		m[k].Add(p)
+	}
+}
+
+func sortedPorts(ports set.Set[string]) []string {
+	s := make([]string, 0, len(ports))
+	for p := range ports {
+		s = append(s, p)
+	}
+	slices.Sort(s)
+	return s
+}
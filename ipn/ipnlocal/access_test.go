@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestAccessSummary(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	peer := func(id, name, ip string, tags ...string) tailcfg.NodeView {
+		return (&tailcfg.Node{
+			StableID:  tailcfg.StableNodeID(id),
+			Name:      name,
+			Addresses: []netip.Prefix{pfx(ip + "/32")},
+			Tags:      tags,
+		}).View()
+	}
+	allPorts := filter.PortRange{First: 0, Last: 65535}
+	nm := &netmap.NetworkMap{
+		Addresses: []netip.Prefix{pfx("100.64.0.1/32")},
+		Peers: []tailcfg.NodeView{
+			peer("n2", "b.example.ts.net.", "100.64.0.2"),
+			peer("n3", "a.example.ts.net.", "100.64.0.3", "tag:ci"),
+			peer("n4", "c.example.ts.net.", "100.64.0.4"),
+		},
+		PacketFilter: []filter.Match{
+			{
+				IPProto: []ipproto.Proto{ipproto.TCP},
+				Srcs:    []netip.Prefix{pfx("100.64.0.2/32"), pfx("100.64.0.3/32")},
+				Dsts: []filter.NetPortRange{
+					{Net: pfx("100.64.0.1/32"), Ports: filter.PortRange{First: 22, Last: 22}},
+					{Net: pfx("100.64.0.1/32"), Ports: filter.PortRange{First: 80, Last: 80}},
+				},
+			},
+			{
+				// Subnet routers' peers may reach the whole tailnet's
+				// ICMP, with only 100.64.0.3 among our peers.
+				IPProto: []ipproto.Proto{ipproto.ICMPv4, ipproto.ICMPv6},
+				Srcs:    []netip.Prefix{pfx("100.64.0.0/31"), pfx("100.64.0.3/32")},
+				Dsts:    []filter.NetPortRange{{Net: pfx("0.0.0.0/0"), Ports: allPorts}},
+			},
+			{
+				// Not to this node; ignored.
+				IPProto: []ipproto.Proto{ipproto.UDP},
+				Srcs:    []netip.Prefix{pfx("100.64.0.4/32")},
+				Dsts:    []filter.NetPortRange{{Net: pfx("100.64.0.2/32"), Ports: allPorts}},
+			},
+		},
+	}
+
+	got := accessSummary(nm)
+	want := &ipnstate.AccessSummary{
+		Peers: []ipnstate.PeerAccess{
+			{ID: "n3", DNSName: "a.example.ts.net.", Tags: []string{"tag:ci"}, Ports: []string{"icmp", "tcp:22", "tcp:80"}},
+			{ID: "n2", DNSName: "b.example.ts.net.", Ports: []string{"tcp:22", "tcp:80"}},
+		},
+		Sources: []ipnstate.SourceAccess{
+			{Prefix: pfx("100.64.0.0/31"), Ports: []string{"icmp"}},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"strings"
+
+	"go4.org/netipx"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/views"
+	"tailscale.com/util/mak"
+	"tailscale.com/wgengine/filter"
+)
+
+// allowedPeerMatches reports whether peer p is the one, or one of those,
+// named by spec, an entry of the AllowedPeers pref.
+func allowedPeerMatches(p tailcfg.NodeView, spec string) bool {
+	if strings.HasPrefix(spec, "tag:") {
+		return views.SliceContains(p.Tags(), spec)
+	}
+	return exitNodeMatches(p, spec)
+}
+
+// peerAllowed reports whether prefs permit traffic with peer p: whether
+// the AllowedPeers pref is empty or names p.
+func peerAllowed(prefs ipn.PrefsView, p tailcfg.NodeView) bool {
+	if !prefs.Valid() || prefs.AllowedPeers().Len() == 0 {
+		return true
+	}
+	for i := range prefs.AllowedPeers().LenIter() {
+		if allowedPeerMatches(p, prefs.AllowedPeers().At(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedPeerKeys returns the node keys of the peers that the AllowedPeers
+// pref excludes, or nil if it's empty.
+func blockedPeerKeys(peers []tailcfg.NodeView, prefs ipn.PrefsView) map[key.NodePublic]bool {
+	var blocked map[key.NodePublic]bool
+	for _, p := range peers {
+		if !peerAllowed(prefs, p) {
+			mak.Set(&blocked, p.Key(), true)
+		}
+	}
+	return blocked
+}
+
+// restrictMatchesToAllowedPeers returns the packet filter matches ms
+// limited to traffic from the peers that prefs allow, and from the subnets
+// they route. Matches left with no sources are dropped.
+func restrictMatchesToAllowedPeers(ms []filter.Match, peers []tailcfg.NodeView, prefs ipn.PrefsView) []filter.Match {
+	if !prefs.Valid() || prefs.AllowedPeers().Len() == 0 {
+		return ms
+	}
+	var allowedB netipx.IPSetBuilder
+	for _, p := range peers {
+		if !peerAllowed(prefs, p) {
+			continue
+		}
+		for i := range p.AllowedIPs().LenIter() {
+			allowedB.AddPrefix(p.AllowedIPs().At(i))
+		}
+	}
+	allowed, _ := allowedB.IPSet()
+
+	ret := make([]filter.Match, 0, len(ms))
+	for _, m := range ms {
+		var srcsB netipx.IPSetBuilder
+		for _, src := range m.Srcs {
+			srcsB.AddPrefix(src)
+		}
+		srcsB.Intersect(allowed)
+		srcs, _ := srcsB.IPSet()
+		if m.Srcs = srcs.Prefixes(); len(m.Srcs) > 0 {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestRestrictMatchesToAllowedPeers(t *testing.T) {
+	bastion := (&tailcfg.Node{
+		ID:           1,
+		Key:          key.NewNode().Public(),
+		Name:         "bastion.example.ts.net.",
+		ComputedName: "bastion",
+		Addresses: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.1/32"),
+		},
+		AllowedIPs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.1/32"),
+			netip.MustParsePrefix("10.0.0.0/24"), // routed subnet
+		},
+	}).View()
+	monitor := (&tailcfg.Node{
+		ID:           2,
+		Key:          key.NewNode().Public(),
+		Name:         "monitor.example.ts.net.",
+		ComputedName: "monitor",
+		Tags:         []string{"tag:monitoring"},
+		Addresses: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.2/32"),
+		},
+		AllowedIPs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.2/32"),
+		},
+	}).View()
+	laptop := (&tailcfg.Node{
+		ID:           3,
+		Key:          key.NewNode().Public(),
+		Name:         "laptop.example.ts.net.",
+		ComputedName: "laptop",
+		Addresses: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.3/32"),
+		},
+		AllowedIPs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.3/32"),
+		},
+	}).View()
+	peers := []tailcfg.NodeView{bastion, monitor, laptop}
+
+	ms := []filter.Match{
+		{Srcs: []netip.Prefix{netip.MustParsePrefix("100.64.0.0/10")}},
+		{Srcs: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")}},
+		{Srcs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	}
+
+	if got := restrictMatchesToAllowedPeers(ms, peers, (&ipn.Prefs{}).View()); !reflect.DeepEqual(got, ms) {
+		t.Errorf("no AllowedPeers: got %v; want matches unchanged", got)
+	}
+
+	prefs := (&ipn.Prefs{AllowedPeers: []string{"bastion", "tag:monitoring"}}).View()
+	got := restrictMatchesToAllowedPeers(ms, peers, prefs)
+	want := []filter.Match{
+		{Srcs: []netip.Prefix{
+			netip.MustParsePrefix("100.64.0.1/32"),
+			netip.MustParsePrefix("100.64.0.2/32"),
+		}},
+		{Srcs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	blocked := blockedPeerKeys(peers, prefs)
+	if len(blocked) != 1 || !blocked[laptop.Key()] {
+		t.Errorf("blockedPeerKeys = %v; want only laptop's key", blocked)
+	}
+	if !peerAllowed(prefs, monitor) || peerAllowed(prefs, laptop) {
+		t.Errorf("peerAllowed(monitor), peerAllowed(laptop) = %v, %v; want true, false", peerAllowed(prefs, monitor), peerAllowed(prefs, laptop))
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// localAPITokenPrefix is the prefix of all LocalAPI tokens, to make them
+// recognizable, such as by secret scanners.
+const localAPITokenPrefix = "tslapi-"
+
+// maxLocalAPITokens is the maximum number of LocalAPI tokens that may exist
+// at once, to keep the state store small.
+const maxLocalAPITokens = 100
+
+// storedLocalAPIToken is a LocalAPI token as saved in the state store.
+type storedLocalAPIToken struct {
+	ipn.LocalAPIToken
+	SecretHash string // hex SHA-256 of the token's secret part
+}
+
+// localAPITokensLocked returns the LocalAPI tokens from the state store,
+// keyed by ID.
+//
+// b.mu must be held.
+func (b *LocalBackend) localAPITokensLocked() (map[string]storedLocalAPIToken, error) {
+	toks := map[string]storedLocalAPIToken{}
+	j, err := b.store.ReadState(ipn.LocalAPITokensStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return toks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(j, &toks); err != nil {
+		return nil, fmt.Errorf("decoding LocalAPI tokens: %w", err)
+	}
+	return toks, nil
+}
+
+func (b *LocalBackend) saveLocalAPITokensLocked(toks map[string]storedLocalAPIToken) error {
+	j, err := json.Marshal(toks)
+	if err != nil {
+		return err
+	}
+	return b.store.WriteState(ipn.LocalAPITokensStateKey, j)
+}
+
+// LocalAPITokens returns the LocalAPI tokens that exist, sorted by creation
+// time.
+func (b *LocalBackend) LocalAPITokens() ([]ipn.LocalAPIToken, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]ipn.LocalAPIToken, 0, len(toks))
+	for _, t := range toks {
+		ret = append(ret, t.LocalAPIToken)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].Created.Equal(ret[j].Created) {
+			return ret[i].Created.Before(ret[j].Created)
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// CreateLocalAPIToken creates a LocalAPI token granting scopes. It returns
+// the token, which isn't stored and can't be retrieved again, along with
+// its description.
+func (b *LocalBackend) CreateLocalAPIToken(name string, scopes []string) (token string, info ipn.LocalAPIToken, err error) {
+	if err := ipn.CheckLocalAPIScopes(scopes); err != nil {
+		return "", info, err
+	}
+	var idb [4]byte
+	var secretb [16]byte
+	if _, err := rand.Read(idb[:]); err != nil {
+		return "", info, err
+	}
+	if _, err := rand.Read(secretb[:]); err != nil {
+		return "", info, err
+	}
+	id, secret := hex.EncodeToString(idb[:]), hex.EncodeToString(secretb[:])
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return "", info, err
+	}
+	if len(toks) >= maxLocalAPITokens {
+		return "", info, fmt.Errorf("too many LocalAPI tokens; revoke some first (max %d)", maxLocalAPITokens)
+	}
+	if _, dup := toks[id]; dup {
+		return "", info, errors.New("token ID collision; try again")
+	}
+	info = ipn.LocalAPIToken{
+		ID:      id,
+		Name:    name,
+		Scopes:  append([]string(nil), scopes...),
+		Created: b.clock.Now().UTC().Truncate(time.Second),
+	}
+	toks[id] = storedLocalAPIToken{
+		LocalAPIToken: info,
+		SecretHash:    hashLocalAPITokenSecret(secret),
+	}
+	if err := b.saveLocalAPITokensLocked(toks); err != nil {
+		return "", ipn.LocalAPIToken{}, err
+	}
+	b.logf("created LocalAPI token %s (%q) with scopes %q", id, name, scopes)
+	return localAPITokenPrefix + id + "-" + secret, info, nil
+}
+
+// RevokeLocalAPIToken deletes the LocalAPI token with the given ID.
+func (b *LocalBackend) RevokeLocalAPIToken(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		return err
+	}
+	if _, ok := toks[id]; !ok {
+		return fmt.Errorf("no LocalAPI token with ID %q", id)
+	}
+	delete(toks, id)
+	if err := b.saveLocalAPITokensLocked(toks); err != nil {
+		return err
+	}
+	b.logf("revoked LocalAPI token %s", id)
+	return nil
+}
+
+// CheckLocalAPIToken reports whether token is a valid LocalAPI token and,
+// if so, returns its description.
+func (b *LocalBackend) CheckLocalAPIToken(token string) (info ipn.LocalAPIToken, ok bool) {
+	rest, ok := strings.CutPrefix(token, localAPITokenPrefix)
+	if !ok {
+		return info, false
+	}
+	id, secret, ok := strings.Cut(rest, "-")
+	if !ok {
+		return info, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	toks, err := b.localAPITokensLocked()
+	if err != nil {
+		b.logf("reading LocalAPI tokens: %v", err)
+		return info, false
+	}
+	t, ok := toks[id]
+	if !ok {
+		return info, false
+	}
+	if subtle.ConstantTimeCompare([]byte(hashLocalAPITokenSecret(secret)), []byte(t.SecretHash)) != 1 {
+		return info, false
+	}
+	return t.LocalAPIToken, true
+}
+
+func hashLocalAPITokenSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"time"
+
+	"tailscale.com/clientupdate"
+	"tailscale.com/ipn"
+	"tailscale.com/version"
+)
+
+// updatePolicy returns the policy for applying updates set by prefs.
+func updatePolicy(prefs ipn.AutoUpdatePrefs) clientupdate.Policy {
+	return clientupdate.Policy{
+		Window: prefs.Window,
+		Train:  prefs.Train,
+		Delay:  time.Duration(prefs.DelayDays) * 24 * time.Hour,
+	}
+}
+
+// lastUpdate is the most recent automatic update, as saved under
+// ipn.LastUpdateStateKey.
+type lastUpdate struct {
+	Version string
+	Started time.Time
+}
+
+// checkUpdatePolicy returns nil if the latest version may be applied now
+// under the AutoUpdate prefs' policy, or else an error saying why it's
+// deferred.
+func (b *LocalBackend) checkUpdatePolicy() error {
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs().AutoUpdate()
+	var ver string
+	if b.lastClientVersion != nil {
+		ver = b.lastClientVersion.LatestVersion
+	}
+	seen := b.clientVersionSeen
+	b.mu.Unlock()
+	return updatePolicy(prefs).Allow(b.clock.Now(), ver, seen)
+}
+
+// saveLastUpdate records that an automatic update to the latest version
+// was started.
+func (b *LocalBackend) saveLastUpdate() {
+	b.mu.Lock()
+	var u lastUpdate
+	if b.lastClientVersion != nil {
+		u.Version = b.lastClientVersion.LatestVersion
+	}
+	b.mu.Unlock()
+	if u.Version == "" {
+		return
+	}
+	u.Started = b.clock.Now()
+	j, err := json.Marshal(u)
+	if err != nil {
+		return
+	}
+	if err := b.store.WriteState(ipn.LastUpdateStateKey, j); err != nil {
+		b.logf("failed to save last update: %v", err)
+	}
+}
+
+// AutoUpdateStatus returns the state of automatic updates: the available
+// version not yet applied, whether the update policy is holding it back,
+// and the version most recently applied.
+func (b *LocalBackend) AutoUpdateStatus() ipn.AutoUpdateStatus {
+	b.mu.Lock()
+	cv := b.lastClientVersion
+	seen := b.clientVersionSeen
+	applying := b.c2nUpdateStatus.started
+	prefs := b.pm.CurrentPrefs().AutoUpdate()
+	b.mu.Unlock()
+
+	st := ipn.AutoUpdateStatus{
+		Current:  version.Short(),
+		Applying: applying,
+	}
+	if cv != nil && !cv.RunningLatest && cv.LatestVersion != "" {
+		st.Pending = cv.LatestVersion
+		st.PendingSince = seen
+		if err := updatePolicy(prefs).Allow(b.clock.Now(), cv.LatestVersion, seen); err != nil {
+			st.Deferred = err.Error()
+		}
+	}
+	if j, err := b.store.ReadState(ipn.LastUpdateStateKey); err == nil {
+		var u lastUpdate
+		if json.Unmarshal(j, &u) == nil && u.Version == st.Current {
+			st.Applied = u.Version
+			st.AppliedAt = u.Started
+		}
+	}
+	return st
+}
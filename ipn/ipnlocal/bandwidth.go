@@ -0,0 +1,414 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+var warnBandwidthQuota = health.NewWarnable(health.WithCode("bandwidth-quota"), health.WithSeverity(health.SeverityLow), health.WithSubsystem(health.SysIPN))
+
+const (
+	// bandwidthSampleInterval is how often the engine's peer traffic
+	// counters are added to the bandwidth usage. It matches the length of
+	// the engine's traffic buckets.
+	bandwidthSampleInterval = time.Minute
+
+	// bandwidthSaveInterval is how often the bandwidth usage is saved to
+	// the state store, if it changed.
+	bandwidthSaveInterval = 10 * time.Minute
+)
+
+// bandwidthMeter counts the traffic a node exchanges over Tailscale in the
+// usage periods of its bandwidth quota, and tracks which of the quota's
+// thresholds the usage has crossed.
+//
+// The zero value is ready for use.
+type bandwidthMeter struct {
+	mu         sync.Mutex
+	quota      ipn.BandwidthQuotaPrefs
+	usage      ipn.BandwidthUsage // PeriodEnd is zero until the first period starts
+	alerted    int                // highest threshold percentage alerted in the period
+	lastBucket time.Time          // start of the newest engine traffic bucket counted
+	dirty      bool               // whether the state changed since it was last saved
+
+	// funnelConns are the open Funnel connections, whose traffic is
+	// added to the usage by flushFunnelLocked.
+	funnelConns set.Set[*funnelCountingConn]
+}
+
+// savedBandwidthMeter is the state of a bandwidthMeter saved in the state
+// store under ipn.BandwidthUsageStateKey.
+type savedBandwidthMeter struct {
+	Quota   ipn.BandwidthQuotaPrefs
+	Usage   ipn.BandwidthUsage
+	Alerted int `json:",omitempty"`
+}
+
+// setQuota sets the quota to apply from now on.
+func (m *bandwidthMeter) setQuota(q ipn.BandwidthQuotaPrefs, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old := m.quota
+	if q == old {
+		return
+	}
+	m.quota = q
+	m.usage.QuotaBytes = uint64(q.MonthlyMB) << 20
+	if q.ResetDay != old.ResetDay && !m.usage.PeriodEnd.IsZero() {
+		// Move the current period to the new reset day, keeping the
+		// usage counted so far.
+		m.usage.PeriodStart, m.usage.PeriodEnd = q.Period(now)
+	}
+	if q.MonthlyMB != old.MonthlyMB || q.WarnPercent != old.WarnPercent {
+		// Alert anew for whichever thresholds of the new quota the usage
+		// has already crossed.
+		m.alerted = 0
+	}
+	m.dirty = true
+}
+
+// rollLocked starts a new usage period if now is past the current one.
+//
+// m.mu must be held.
+func (m *bandwidthMeter) rollLocked(now time.Time) {
+	if !m.usage.PeriodEnd.IsZero() && now.Before(m.usage.PeriodEnd) {
+		return
+	}
+	start, end := m.quota.Period(now)
+	m.usage = ipn.BandwidthUsage{
+		PeriodStart: start,
+		PeriodEnd:   end,
+		QuotaBytes:  uint64(m.quota.MonthlyMB) << 20,
+	}
+	m.alerted = 0
+	m.dirty = true
+}
+
+// addPeerTraffic counts the buckets of the engine's peer traffic history
+// that haven't been counted yet. Traffic with the peer whose node key is
+// exitNode is also counted as traffic with the exit node exitNodeID.
+func (m *bandwidthMeter) addPeerTraffic(now time.Time, traffic []ipnstate.PeerTraffic, exitNodeID tailcfg.StableNodeID, exitNode key.NodePublic) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(now)
+	newest := m.lastBucket
+	for _, pt := range traffic {
+		var c ipn.ByteCounts
+		for _, b := range pt.Buckets {
+			if !b.Start.After(m.lastBucket) {
+				continue
+			}
+			if b.Start.After(newest) {
+				newest = b.Start
+			}
+			c.RxBytes += uint64(b.RxBytes)
+			c.TxBytes += uint64(b.TxBytes)
+		}
+		if c.Total() == 0 {
+			continue
+		}
+		m.usage.Total = addByteCounts(m.usage.Total, c)
+		if !exitNodeID.IsZero() && pt.NodeKey == exitNode {
+			if m.usage.ExitNodes == nil {
+				m.usage.ExitNodes = make(map[tailcfg.StableNodeID]ipn.ByteCounts)
+			}
+			m.usage.ExitNodes[exitNodeID] = addByteCounts(m.usage.ExitNodes[exitNodeID], c)
+		}
+		m.dirty = true
+	}
+	m.lastBucket = newest
+}
+
+// addFunnelLocked counts c as traffic of Funnel connections to target. It's
+// already part of the peer traffic, from the Funnel ingress node.
+//
+// m.mu must be held.
+func (m *bandwidthMeter) addFunnelLocked(target ipn.HostPort, c ipn.ByteCounts) {
+	if c.Total() == 0 {
+		return
+	}
+	if m.usage.Funnel == nil {
+		m.usage.Funnel = make(map[ipn.HostPort]ipn.ByteCounts)
+	}
+	m.usage.Funnel[target] = addByteCounts(m.usage.Funnel[target], c)
+	m.dirty = true
+}
+
+// flushFunnelLocked adds the traffic counted by the open Funnel connections
+// since the last flush to the usage.
+//
+// m.mu must be held.
+func (m *bandwidthMeter) flushFunnelLocked(now time.Time) {
+	m.rollLocked(now)
+	for c := range m.funnelConns {
+		m.addFunnelLocked(c.target, c.takeCounts())
+	}
+}
+
+// trackFunnelConn starts counting the traffic of the Funnel connection c.
+func (m *bandwidthMeter) trackFunnelConn(c *funnelCountingConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mak.Set(&m.funnelConns, c, struct{}{})
+}
+
+// untrackFunnelConn adds the remaining traffic of the closed Funnel
+// connection c to the usage and stops tracking it.
+func (m *bandwidthMeter) untrackFunnelConn(now time.Time, c *funnelCountingConn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(now)
+	m.addFunnelLocked(c.target, c.takeCounts())
+	delete(m.funnelConns, c)
+}
+
+func addByteCounts(a, b ipn.ByteCounts) ipn.ByteCounts {
+	return ipn.ByteCounts{
+		RxBytes: a.RxBytes + b.RxBytes,
+		TxBytes: a.TxBytes + b.TxBytes,
+	}
+}
+
+// checkQuota reports the highest threshold of the quota that the usage has
+// crossed in the current period, as a health error, or nil if none. If that
+// threshold hasn't been alerted yet in the period, it also returns an alert
+// for it.
+func (m *bandwidthMeter) checkQuota(now time.Time) (alert *ipn.BandwidthAlert, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollLocked(now)
+	quota, used := m.usage.QuotaBytes, m.usage.Total.Total()
+	if quota == 0 {
+		return nil, nil
+	}
+	var crossed int
+	switch {
+	case used >= quota:
+		crossed = 100
+	case m.quota.WarnPercent != 0 && used*100 >= quota*uint64(m.quota.WarnPercent):
+		crossed = m.quota.WarnPercent
+	default:
+		return nil, nil
+	}
+	if crossed > m.alerted {
+		m.alerted = crossed
+		m.dirty = true
+		alert = &ipn.BandwidthAlert{
+			Percent:    crossed,
+			UsedBytes:  used,
+			QuotaBytes: quota,
+			PeriodEnd:  m.usage.PeriodEnd,
+		}
+	}
+	resets := m.usage.PeriodEnd.Format(time.DateOnly)
+	if crossed == 100 {
+		return alert, fmt.Errorf("bandwidth quota of %d MB reached (%d MB used); usage resets on %s", quota>>20, used>>20, resets)
+	}
+	return alert, fmt.Errorf("%d%% of bandwidth quota used (%d of %d MB); usage resets on %s", crossed, used>>20, quota>>20, resets)
+}
+
+// get returns the usage in the current period.
+func (m *bandwidthMeter) get(now time.Time) ipn.BandwidthUsage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushFunnelLocked(now)
+	u := m.usage
+	u.ExitNodes = maps.Clone(u.ExitNodes)
+	u.Funnel = maps.Clone(u.Funnel)
+	return u
+}
+
+// stateToSave returns the JSON state to save to the store, or nil if it
+// hasn't changed since it was last returned.
+func (m *bandwidthMeter) stateToSave() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.dirty {
+		return nil
+	}
+	j, err := json.Marshal(savedBandwidthMeter{
+		Quota:   m.quota,
+		Usage:   m.usage,
+		Alerted: m.alerted,
+	})
+	if err != nil {
+		return nil
+	}
+	m.dirty = false
+	return j
+}
+
+// flushFunnel adds the traffic counted by the open Funnel connections since
+// the last flush to the usage.
+func (m *bandwidthMeter) flushFunnel(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.flushFunnelLocked(now)
+}
+
+// restore restores the state saved by stateToSave.
+func (m *bandwidthMeter) restore(j []byte) error {
+	var s savedBandwidthMeter
+	if err := json.Unmarshal(j, &s); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quota = s.Quota
+	m.usage = s.Usage
+	m.alerted = s.Alerted
+	return nil
+}
+
+// BandwidthUsage returns the traffic this node has exchanged over Tailscale
+// in the current usage period of its bandwidth quota.
+func (b *LocalBackend) BandwidthUsage() ipn.BandwidthUsage {
+	return b.bandwidth.get(b.clock.Now())
+}
+
+// restoreBandwidthUsage restores the bandwidth usage saved by
+// saveBandwidthUsage, if any.
+func (b *LocalBackend) restoreBandwidthUsage() {
+	j, err := b.store.ReadState(ipn.BandwidthUsageStateKey)
+	if err != nil {
+		return
+	}
+	if err := b.bandwidth.restore(j); err != nil {
+		b.logf("invalid bandwidth usage in store: %v", err)
+	}
+}
+
+// saveBandwidthUsage writes the bandwidth usage to the store, if it
+// changed since it was last written.
+func (b *LocalBackend) saveBandwidthUsage() {
+	j := b.bandwidth.stateToSave()
+	if j == nil {
+		return
+	}
+	if err := ipn.WriteState(b.store, ipn.BandwidthUsageStateKey, j); err != nil {
+		b.logf("failed to save bandwidth usage: %v", err)
+	}
+}
+
+// meterBandwidth is a goroutine that adds the engine's peer traffic to the
+// bandwidth usage every bandwidthSampleInterval, saving it every
+// bandwidthSaveInterval, until b is shut down.
+func (b *LocalBackend) meterBandwidth() {
+	ticker, tickerChannel := b.clock.NewTicker(bandwidthSampleInterval)
+	defer ticker.Stop()
+	lastSave := b.clock.Now()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tickerChannel:
+		}
+		b.sampleBandwidth()
+		if now := b.clock.Now(); now.Sub(lastSave) >= bandwidthSaveInterval {
+			b.saveBandwidthUsage()
+			lastSave = now
+		}
+	}
+}
+
+// sampleBandwidth adds the engine's latest peer traffic to the bandwidth
+// usage, then updates the quota health warning and sends an alert to IPN
+// bus watchers if the usage crossed a threshold of the quota.
+func (b *LocalBackend) sampleBandwidth() {
+	traffic := b.e.PeerTraffic()
+
+	b.mu.Lock()
+	var exitNode key.NodePublic
+	exitNodeID := b.pm.CurrentPrefs().ExitNodeID()
+	if !exitNodeID.IsZero() && b.netMap != nil {
+		if p, ok := b.netMap.PeerWithStableID(exitNodeID); ok {
+			exitNode = p.Key()
+		}
+	}
+	b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.bandwidth.addPeerTraffic(now, traffic, exitNodeID, exitNode)
+	b.bandwidth.flushFunnel(now)
+	alert, err := b.bandwidth.checkQuota(now)
+	warnBandwidthQuota.Set(err)
+	if alert != nil {
+		b.logf("bandwidth: %v", err)
+		b.send(ipn.Notify{BandwidthAlert: alert})
+		b.saveBandwidthUsage()
+	}
+}
+
+// funnelCountingConn is a net.Conn of a Funnel connection to target that
+// counts its traffic for a bandwidthMeter. The counts are added to the
+// meter's usage when it's sampled and when the conn is closed, so that Read
+// and Write don't contend on the meter's lock.
+type funnelCountingConn struct {
+	net.Conn
+	b      *LocalBackend
+	target ipn.HostPort
+
+	rx, tx    atomic.Uint64 // bytes not yet added to the meter
+	closeOnce sync.Once
+}
+
+// countFunnelConn returns c wrapped to count its traffic as Funnel traffic
+// to target in b's bandwidth usage. If c is an *ipn.FunnelConn, which
+// embedders read Src and Target from, it's kept and its Conn wrapped instead.
+func (b *LocalBackend) countFunnelConn(c net.Conn, target ipn.HostPort) net.Conn {
+	if fc, ok := c.(*ipn.FunnelConn); ok {
+		fc.Conn = b.countFunnelConn(fc.Conn, target)
+		return fc
+	}
+	cc := &funnelCountingConn{Conn: c, b: b, target: target}
+	b.bandwidth.trackFunnelConn(cc)
+	return cc
+}
+
+// takeCounts returns the traffic counted since it was last called.
+func (c *funnelCountingConn) takeCounts() ipn.ByteCounts {
+	return ipn.ByteCounts{
+		RxBytes: c.rx.Swap(0),
+		TxBytes: c.tx.Swap(0),
+	}
+}
+
+func (c *funnelCountingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.rx.Add(uint64(n))
+	}
+	return n, err
+}
+
+func (c *funnelCountingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.tx.Add(uint64(n))
+	}
+	return n, err
+}
+
+func (c *funnelCountingConn) Close() error {
+	err := c.Conn.Close()
+	c.closeOnce.Do(func() {
+		c.b.bandwidth.untrackFunnelConn(c.b.clock.Now(), c)
+	})
+	return err
+}
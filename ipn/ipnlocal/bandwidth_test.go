@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+)
+
+func TestBandwidthMeter(t *testing.T) {
+	exitKey, otherKey := key.NewNode().Public(), key.NewNode().Public()
+	const exitID = tailcfg.StableNodeID("exit")
+	now := time.Date(2023, 3, 20, 12, 0, 0, 0, time.UTC)
+	traffic := func(starts ...time.Time) []ipnstate.PeerTraffic {
+		var exit, other ipnstate.PeerTraffic
+		exit.NodeKey, other.NodeKey = exitKey, otherKey
+		for _, s := range starts {
+			exit.Buckets = append(exit.Buckets, ipnstate.TrafficBucket{Start: s, RxBytes: 300 << 20, TxBytes: 100 << 20})
+			other.Buckets = append(other.Buckets, ipnstate.TrafficBucket{Start: s, RxBytes: 50 << 20, TxBytes: 50 << 20})
+		}
+		return []ipnstate.PeerTraffic{exit, other}
+	}
+
+	var m bandwidthMeter
+	m.setQuota(ipn.BandwidthQuotaPrefs{MonthlyMB: 1000, ResetDay: 15, WarnPercent: 80}, now)
+
+	t0, t1 := now.Add(-2*time.Minute), now.Add(-time.Minute)
+	m.addPeerTraffic(now, traffic(t0), exitID, exitKey)
+	if alert, err := m.checkQuota(now); alert != nil || err != nil {
+		t.Fatalf("at 500MB: got alert %+v, err %v; want none", alert, err)
+	}
+
+	// The engine's history still has t0's bucket; it's not counted twice.
+	m.addPeerTraffic(now, traffic(t0, t1), exitID, exitKey)
+	alert, err := m.checkQuota(now)
+	if alert == nil || alert.Percent != 100 || err == nil {
+		t.Fatalf("at 1000MB: got alert %+v, err %v; want 100%% alert", alert, err)
+	}
+	if alert, err := m.checkQuota(now); alert != nil || err == nil {
+		t.Fatalf("after alert: got alert %+v, err %v; want only err", alert, err)
+	}
+
+	got := m.get(now)
+	want := ipn.BandwidthUsage{
+		PeriodStart: time.Date(2023, 3, 15, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:   time.Date(2023, 4, 15, 0, 0, 0, 0, time.UTC),
+		Total:       ipn.ByteCounts{RxBytes: 700 << 20, TxBytes: 300 << 20},
+		ExitNodes: map[tailcfg.StableNodeID]ipn.ByteCounts{
+			exitID: {RxBytes: 600 << 20, TxBytes: 200 << 20},
+		},
+		QuotaBytes: 1000 << 20,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("usage = %+v; want %+v", got, want)
+	}
+
+	// The usage survives a restart.
+	var m2 bandwidthMeter
+	if err := m2.restore(m.stateToSave()); err != nil {
+		t.Fatal(err)
+	}
+	if got := m2.get(now); !reflect.DeepEqual(got, want) {
+		t.Errorf("restored usage = %+v; want %+v", got, want)
+	}
+	m2.setQuota(ipn.BandwidthQuotaPrefs{MonthlyMB: 1000, ResetDay: 15, WarnPercent: 80}, now)
+	if alert, _ := m2.checkQuota(now); alert != nil {
+		t.Errorf("restored meter alerted again: %+v", alert)
+	}
+
+	// Raising the quota alerts for the new threshold crossed.
+	m2.setQuota(ipn.BandwidthQuotaPrefs{MonthlyMB: 1200, ResetDay: 15, WarnPercent: 80}, now)
+	if alert, _ := m2.checkQuota(now); alert == nil || alert.Percent != 80 {
+		t.Errorf("after raising quota: got alert %+v; want 80%%", alert)
+	}
+
+	// A new period starts with no usage.
+	next := time.Date(2023, 4, 15, 0, 0, 1, 0, time.UTC)
+	if alert, err := m2.checkQuota(next); alert != nil || err != nil {
+		t.Errorf("new period: got alert %+v, err %v; want none", alert, err)
+	}
+	if got := m2.get(next); got.Total.Total() != 0 || !got.PeriodStart.Equal(want.PeriodEnd) {
+		t.Errorf("new period usage = %+v", got)
+	}
+}
+
+func TestFunnelCountingConn(t *testing.T) {
+	now := time.Date(2023, 3, 20, 12, 0, 0, 0, time.UTC)
+	b := &LocalBackend{clock: tstest.NewClock(tstest.ClockOpts{Start: now})}
+	const target = ipn.HostPort("foo.ts.net:443")
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := b.countFunnelConn(&ipn.FunnelConn{Conn: c1, Target: target}, target)
+	if _, ok := c.(*ipn.FunnelConn); !ok {
+		t.Fatalf("countFunnelConn returned %T; want *ipn.FunnelConn", c)
+	}
+
+	go c2.Write([]byte("hello"))
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+	go io.ReadFull(c2, make([]byte, 3))
+	if _, err := c.Write([]byte("hey")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := ipn.ByteCounts{RxBytes: 5, TxBytes: 3}
+	if got := b.BandwidthUsage().Funnel[target]; got != want {
+		t.Errorf("open conn usage = %+v; want %+v", got, want)
+	}
+	go c2.Write([]byte("!"))
+	if _, err := io.ReadFull(c, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c.Close()
+	want.RxBytes++
+	if got := b.BandwidthUsage().Funnel[target]; got != want {
+		t.Errorf("closed conn usage = %+v; want %+v", got, want)
+	}
+	if n := len(b.bandwidth.funnelConns); n != 0 {
+		t.Errorf("%d conns still tracked after close", n)
+	}
+}
@@ -150,6 +150,10 @@ func (b *LocalBackend) handleC2NUpdatePost(w http.ResponseWriter, r *http.Reques
 		res.Err = "not supported"
 		return
 	}
+	if err := b.checkUpdatePolicy(); err != nil {
+		res.Err = fmt.Sprintf("deferred by update policy: %v", err)
+		return
+	}
 
 	// Check if update was already started, and mark as started.
 	if !b.trySetC2NUpdateStarted() {
@@ -195,6 +199,7 @@ func (b *LocalBackend) handleC2NUpdatePost(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	res.Started = true
+	b.saveLastUpdate()
 
 	// Run update asynchronously and respond that it started.
 	go func() {
@@ -80,6 +80,13 @@ func (b *LocalBackend) certDir() (string, error) {
 
 var acmeDebug = envknob.RegisterBool("TS_DEBUG_ACME")
 
+// certSharedStorePath, if set, is a state store (in the syntax of
+// tailscaled's --state flag, such as "kube:<secret-name>") in which to keep
+// certs instead of the usual place, shared with other nodes serving the same
+// names, such as high-availability replicas of a service. See
+// certSharedStore.
+var certSharedStorePath = envknob.RegisterString("TS_CERT_SHARED_STORE")
+
 // GetCertPEM gets the TLSCertKeyPair for domain, either from cache or via the
 // ACME process. ACME process is used for new domain certs, existing expired
 // certs or existing certs that should get renewed due to upcoming expiry.
@@ -239,6 +246,9 @@ type certStore interface {
 var errCertExpired = errors.New("cert expired")
 
 func (b *LocalBackend) getCertStore() (certStore, error) {
+	if path := certSharedStorePath(); path != "" {
+		return openCertSharedStore(b.logf, path)
+	}
 	switch b.store.(type) {
 	case *store.FileStore:
 	case *mem.Store:
@@ -354,6 +364,161 @@ func (s certStateStore) WriteACMEKey(key []byte) error {
 	return ipn.WriteState(s.StateStore, ipn.StateKey(acmePEMName), key)
 }
 
+var (
+	certSharedStoreOnce sync.Once
+	certSharedStoreVal  certSharedStore
+	certSharedStoreErr  error
+)
+
+// openCertSharedStore returns the certSharedStore in the state store at
+// path, opening it on first use.
+func openCertSharedStore(logf logger.Logf, path string) (certStore, error) {
+	certSharedStoreOnce.Do(func() {
+		st, err := store.New(logf, path)
+		if err != nil {
+			certSharedStoreErr = fmt.Errorf("opening shared cert store: %w", err)
+			return
+		}
+		certSharedStoreVal = certSharedStore{
+			certStateStore: certStateStore{StateStore: st},
+			holder:         newCertLeaseHolder(),
+		}
+	})
+	return certSharedStoreVal, certSharedStoreErr
+}
+
+// certLeaseDuration is how long a node may take to get a cert from the
+// ACME server before others consider it gone and try themselves.
+const certLeaseDuration = 5 * time.Minute
+
+// certLeasePollInterval is how often a node waiting for another to get a
+// cert checks the store for it.
+var certLeasePollInterval = 2 * time.Second
+
+// certSharedStore is a certStore shared by several nodes serving the same
+// names. Before asking the ACME server for a cert, a node takes a lease on
+// the domain in the store; the others wait for it to write the cert rather
+// than also asking for one, so that each cert is issued and renewed just
+// once, well within the ACME server's rate limits.
+//
+// Leases are exclusive if the store implements ipn.StateStoreCAS (as
+// kubestore does); otherwise races between nodes are narrowed, but not
+// ruled out.
+type certSharedStore struct {
+	certStateStore
+	holder string // identifies this node in leases
+}
+
+// certLease is the value of a certSharedStore lease.
+type certLease struct {
+	Holder  string    `json:",omitempty"`
+	Expires time.Time `json:",omitempty"`
+}
+
+func newCertLeaseHolder() string {
+	host, _ := os.Hostname()
+	var rnd [4]byte
+	rand.Read(rnd[:])
+	return fmt.Sprintf("%s/%x", host, rnd)
+}
+
+func certLeaseKey(domain string) ipn.StateKey {
+	return ipn.StateKey(domain + ".lease")
+}
+
+// tryLease tries to take the lease on getting domain's cert until expires.
+// It reports whether it got it, which it doesn't if another node holds it.
+func (s certSharedStore) tryLease(domain string, now, expires time.Time) (bool, error) {
+	key := certLeaseKey(domain)
+	cur, err := s.ReadState(key)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		cur = nil
+	} else if err != nil {
+		return false, err
+	} else {
+		var l certLease
+		if json.Unmarshal(cur, &l) == nil && l.Holder != s.holder && now.Before(l.Expires) {
+			return false, nil
+		}
+	}
+	lease, err := json.Marshal(certLease{Holder: s.holder, Expires: expires})
+	if err != nil {
+		return false, err
+	}
+	if cas, ok := s.StateStore.(ipn.StateStoreCAS); ok {
+		return cas.CompareAndSwapState(key, cur, lease)
+	}
+	if err := s.WriteState(key, lease); err != nil {
+		return false, err
+	}
+	// Without an atomic swap, check that a concurrent writer didn't
+	// overwrite the lease; the last one to write wins.
+	got, err := s.ReadState(key)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(got, lease), nil
+}
+
+// releaseLease gives up a lease taken by tryLease.
+func (s certSharedStore) releaseLease(domain string) error {
+	j, err := json.Marshal(certLease{})
+	if err != nil {
+		return err
+	}
+	return s.WriteState(certLeaseKey(domain), j)
+}
+
+// awaitCertLease takes the lease on getting domain's cert from the shared
+// store s, waiting while another node holds it. If that node stores a new
+// cert meanwhile, it returns that instead, without the lease. prev is the
+// cert that was in the store before, if any.
+func (b *LocalBackend) awaitCertLease(ctx context.Context, s certSharedStore, logf logger.Logf, domain string, prev *TLSCertKeyPair) (*TLSCertKeyPair, error) {
+	// newCert returns the cert in the store, if another node put a
+	// valid one there since prev.
+	newCert := func() *TLSCertKeyPair {
+		p, err := getCertPEMCached(s, domain, b.clock.Now())
+		if err != nil || prev != nil && bytes.Equal(p.CertPEM, prev.CertPEM) {
+			return nil
+		}
+		// Forget when prev was due for renewal.
+		b.domainRenewed(domain)
+		return p
+	}
+	for waited := false; ; waited = true {
+		now := b.clock.Now()
+		ok, err := s.tryLease(domain, now, now.Add(certLeaseDuration))
+		if err != nil {
+			return nil, fmt.Errorf("taking cert lease: %w", err)
+		}
+		if ok {
+			// Another node may have finished just before we took
+			// the lease.
+			if p := newCert(); p != nil {
+				if err := s.releaseLease(domain); err != nil {
+					logf("releasing cert lease: %v", err)
+				}
+				return p, nil
+			}
+			return nil, nil
+		}
+		if !waited {
+			logf("another node is getting the cert; waiting for it")
+		}
+		t := time.NewTimer(certLeasePollInterval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		if p := newCert(); p != nil {
+			logf("got cert from another node")
+			return p, nil
+		}
+	}
+}
+
 // TLSCertKeyPair is a TLS public and private key, and whether they were obtained
 // from cache or freshly obtained.
 type TLSCertKeyPair struct {
@@ -385,19 +550,32 @@ func (b *LocalBackend) getCertPEM(ctx context.Context, cs certStore, logf logger
 	// In case this method was triggered multiple times in parallel (when
 	// serving incoming requests), check whether one of the other goroutines
 	// already renewed the cert before us.
-	if p, err := getCertPEMCached(cs, domain, now); err == nil {
+	prev, err := getCertPEMCached(cs, domain, now)
+	if err == nil {
 		// shouldStartDomainRenewal caches its result so it's OK to call this
 		// frequently.
-		shouldRenew, err := b.shouldStartDomainRenewal(cs, domain, now, p)
+		shouldRenew, err := b.shouldStartDomainRenewal(cs, domain, now, prev)
 		if err != nil {
 			logf("error checking for certificate renewal: %v", err)
 		} else if !shouldRenew {
-			return p, nil
+			return prev, nil
 		}
 	} else if !errors.Is(err, ipn.ErrStateNotExist) && !errors.Is(err, errCertExpired) {
 		return nil, err
 	}
 
+	if ss, ok := cs.(certSharedStore); ok {
+		p, err := b.awaitCertLease(ctx, ss, logf, domain, prev)
+		if p != nil || err != nil {
+			return p, err
+		}
+		defer func() {
+			if err := ss.releaseLease(domain); err != nil {
+				logf("releasing cert lease: %v", err)
+			}
+		}()
+	}
+
 	ac, err := acmeClient(cs)
 	if err != nil {
 		return nil, err
@@ -6,6 +6,8 @@
 package ipnlocal
 
 import (
+	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -18,7 +20,9 @@
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tstest"
 )
 
 func TestValidLookingCertDomain(t *testing.T) {
@@ -199,3 +203,132 @@ func TestShouldStartDomainRenewal(t *testing.T) {
 		})
 	}
 }
+
+// testCertFiles returns the CA roots, cert and key from testdata, which are
+// valid at 2023-02-10.
+func testCertFiles(t *testing.T) (roots *x509.CertPool, cert, key []byte) {
+	t.Helper()
+	root, err := certTestFS.ReadFile("testdata/rootCA.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots = x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(root) {
+		t.Fatal("Unable to add test CA to the cert pool")
+	}
+	if cert, err = certTestFS.ReadFile("testdata/example.com.pem"); err != nil {
+		t.Fatal(err)
+	}
+	if key, err = certTestFS.ReadFile("testdata/example.com-key.pem"); err != nil {
+		t.Fatal(err)
+	}
+	return roots, cert, key
+}
+
+// noCASStore hides the ipn.StateStoreCAS implementation of its StateStore.
+type noCASStore struct {
+	ipn.StateStore
+}
+
+func TestCertSharedStoreLease(t *testing.T) {
+	const domain = "example.com"
+	now := time.Date(2023, time.February, 10, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name string
+		st   ipn.StateStore
+	}{
+		{"CAS", new(mem.Store)},
+		{"NoCAS", noCASStore{new(mem.Store)}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			a := certSharedStore{certStateStore{StateStore: tt.st}, "a"}
+			b := certSharedStore{certStateStore{StateStore: tt.st}, "b"}
+			try := func(s certSharedStore, now time.Time) bool {
+				t.Helper()
+				ok, err := s.tryLease(domain, now, now.Add(certLeaseDuration))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return ok
+			}
+			if !try(a, now) {
+				t.Fatal("a didn't get free lease")
+			}
+			if try(b, now.Add(time.Minute)) {
+				t.Fatal("b got lease held by a")
+			}
+			if !try(a, now.Add(time.Minute)) {
+				t.Fatal("a didn't get lease it holds")
+			}
+			if !try(b, now.Add(time.Minute+certLeaseDuration)) {
+				t.Fatal("b didn't get expired lease")
+			}
+			if err := b.releaseLease(domain); err != nil {
+				t.Fatal(err)
+			}
+			if !try(a, now.Add(2*certLeaseDuration)) {
+				t.Fatal("a didn't get released lease")
+			}
+		})
+	}
+}
+
+func TestAwaitCertLease(t *testing.T) {
+	const domain = "example.com"
+	tstest.Replace(t, &certLeasePollInterval, 10*time.Millisecond)
+	roots, cert, key := testCertFiles(t)
+	now := time.Date(2023, time.February, 10, 0, 0, 0, 0, time.UTC)
+	st := new(mem.Store)
+	a := certSharedStore{certStateStore{StateStore: st, testRoots: roots}, "a"}
+	b := certSharedStore{certStateStore{StateStore: st, testRoots: roots}, "b"}
+	lb := &LocalBackend{logf: t.Logf, clock: tstest.NewClock(tstest.ClockOpts{Start: now})}
+
+	// With the lease free, b takes it and has to get the cert itself.
+	p, err := lb.awaitCertLease(context.Background(), b, t.Logf, domain, nil)
+	if p != nil || err != nil {
+		t.Fatalf("awaitCertLease = %v, %v; want lease", p, err)
+	}
+	if err := b.releaseLease(domain); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a holding the lease, b waits for a's cert.
+	if ok, err := a.tryLease(domain, now, now.Add(certLeaseDuration)); !ok || err != nil {
+		t.Fatalf("tryLease = %v, %v", ok, err)
+	}
+	type result struct {
+		p   *TLSCertKeyPair
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		p, err := lb.awaitCertLease(context.Background(), b, t.Logf, domain, nil)
+		done <- result{p, err}
+	}()
+	select {
+	case r := <-done:
+		t.Fatalf("awaitCertLease returned %v, %v while a held the lease", r.p, r.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := a.WriteKey(domain, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.WriteCert(domain, cert); err != nil {
+		t.Fatal(err)
+	}
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	if r.p == nil || !bytes.Equal(r.p.CertPEM, cert) {
+		t.Fatalf("awaitCertLease = %v; want a's cert", r.p)
+	}
+
+	// Canceling the wait gives up.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	prev := r.p
+	if _, err := lb.awaitCertLease(ctx, b, t.Logf, domain, prev); err != context.Canceled {
+		t.Errorf("awaitCertLease with canceled context = %v; want context.Canceled", err)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/net/dns/publicdns"
+)
+
+// configReloadInterval is how often the config file is checked for changes.
+const configReloadInterval = 5 * time.Second
+
+// setConfigLocked records conf as the config file in effect and applies the
+// prefs it sets to the current profile. It does not reconfigure the running
+// system; it is meant to be called before Start.
+//
+// b.mu must be held.
+func (b *LocalBackend) setConfigLocked(conf *conffile.Config) error {
+	old := b.pm.CurrentPrefs()
+	p, _, err := b.applyConfigLocked(conf)
+	if err != nil {
+		return err
+	}
+	if err := b.pm.SetPrefs(p.View()); err != nil {
+		return err
+	}
+	b.notePrefsChangedLocked(old, ipn.PrefSourceConfigFile)
+	return nil
+}
+
+// applyConfigLocked makes conf the config file in effect, replacing b.conf,
+// and returns the current prefs edited as it says, which the caller must
+// then set. Prefs the previous config set but conf doesn't are reset to
+// their defaults, so the prefs a config leads to don't depend on the
+// configs before it. It also returns the edits conf makes, for logging.
+//
+// If conf is invalid, b.conf and the process-wide settings it controls are
+// left unchanged.
+//
+// b.mu must be held.
+func (b *LocalBackend) applyConfigLocked(conf *conffile.Config) (*ipn.Prefs, *ipn.MaskedPrefs, error) {
+	mp, err := conf.Parsed.ToPrefs()
+	if err != nil {
+		return nil, nil, err
+	}
+	p := b.pm.CurrentPrefs().AsStruct()
+	if b.conf == nil {
+		if !mp.WantRunningSet {
+			// An unset Enabled means running at startup, but unlike the
+			// prefs the config sets, it can then be changed imperatively.
+			p.WantRunning = true
+		}
+	} else {
+		oldMP, err := b.conf.Parsed.ToPrefs()
+		if err != nil {
+			return nil, nil, err
+		}
+		defaults := ipn.NewPrefs()
+		defaults.WantRunning = true // as for an unset Enabled at startup
+		reset := oldMP.Dropped(&mp, defaults)
+		p.ApplyEdits(&reset)
+	}
+	p.ApplyEdits(&mp)
+	if err := b.checkPrefsLocked(p); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", conf.Path, err)
+	}
+	// Check all of the process-wide settings before changing any.
+	if err := publicdns.CheckExtraProviders(conf.Parsed.DoHProviders); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", conf.Path, err)
+	}
+	if _, err := conf.Parsed.Backoff.Policies(); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", conf.Path, err)
+	}
+	if err := publicdns.SetExtraProviders(conf.Parsed.DoHProviders); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", conf.Path, err)
+	}
+	if err := setBackoffPolicies(conf.Parsed.Backoff); err != nil {
+		return nil, nil, fmt.Errorf("config file %s: %w", conf.Path, err)
+	}
+	b.conf = conf
+	return p, &mp, nil
+}
+
+// ReloadConfig re-reads the config file tailscaled was started with and, if
+// it changed, applies it. It reports whether the config changed.
+//
+// An invalid config file is reported as an error and otherwise ignored, so
+// the previous config stays in effect.
+func (b *LocalBackend) ReloadConfig() (changed bool, err error) {
+	// b.mu is held until the new prefs are set, so that concurrent
+	// reloads apply the file one at a time.
+	b.mu.Lock()
+	old := b.conf
+	if old == nil {
+		b.mu.Unlock()
+		return false, errors.New("not running with a config file")
+	}
+	raw, err := os.ReadFile(old.Path)
+	if err != nil {
+		b.mu.Unlock()
+		return false, err
+	}
+	// Parse even if the file is unchanged, as secret files it refers to
+	// may have changed.
+	conf, err := conffile.Parse(old.Path, raw)
+	if err != nil {
+		b.mu.Unlock()
+		return false, err
+	}
+	if bytes.Equal(raw, old.Raw) && reflect.DeepEqual(conf.Parsed, old.Parsed) {
+		b.mu.Unlock()
+		return false, nil
+	}
+	p, mp, err := b.applyConfigLocked(conf)
+	if err != nil {
+		b.mu.Unlock()
+		return false, err
+	}
+	needsLogin := b.state == ipn.NeedsLogin && conf.Parsed.AuthKey != nil
+	b.logf("ReloadConfig: %v", mp.Pretty())
+	b.setPrefsLockedOnEntry("ReloadConfig", ipn.PrefSourceConfigFile, p) // does a b.mu.Unlock
+
+	// The serve config may have changed without any prefs changing.
+	b.mu.Lock()
+	b.setTCPPortsInterceptedFromNetmapAndPrefsLocked(b.pm.CurrentPrefs())
+	b.mu.Unlock()
+
+	if needsLogin {
+		// Log in with the (possibly new) auth key.
+		if err := b.Start(ipn.Options{}); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// watchConfigFile periodically reloads the config file until b is shut down.
+func (b *LocalBackend) watchConfigFile() {
+	tc, tick := b.clock.NewTicker(configReloadInterval)
+	defer tc.Stop()
+	var lastErr string // to avoid logging the same error every tick
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tick:
+		}
+		_, err := b.ReloadConfig()
+		if err == nil {
+			lastErr = ""
+			continue
+		}
+		if err.Error() != lastErr {
+			b.logf("config file reload: %v", err)
+		}
+		lastErr = err.Error()
+	}
+}
+
+// checkEditAllowedByConfigLocked returns an error if mp changes any pref
+// set by a locked config file.
+//
+// b.mu must be held.
+func (b *LocalBackend) checkEditAllowedByConfigLocked(mp *ipn.MaskedPrefs) error {
+	if b.conf == nil || !b.conf.Parsed.IsLocked() {
+		return nil
+	}
+	confPrefs, err := b.conf.Parsed.ToPrefs()
+	if err != nil {
+		return err
+	}
+	if names := mp.Conflicts(&confPrefs); len(names) > 0 {
+		return fmt.Errorf("can't change %s: set by locked config file %s", strings.Join(names, ", "), b.conf.Path)
+	}
+	return nil
+}
+
+// checkPrefsAllowedByConfigLocked returns an error if replacing the current
+// prefs with p would change any pref set by a locked config file.
+//
+// b.mu must be held.
+func (b *LocalBackend) checkPrefsAllowedByConfigLocked(p *ipn.Prefs) error {
+	if b.conf == nil || !b.conf.Parsed.IsLocked() {
+		return nil
+	}
+	confPrefs, err := b.conf.Parsed.ToPrefs()
+	if err != nil {
+		return err
+	}
+	want := p.Clone()
+	want.ApplyEdits(&confPrefs)
+	if !want.Equals(p) {
+		return fmt.Errorf("prefs conflict with locked config file %s; edit the config file instead", b.conf.Path)
+	}
+	return nil
+}
+
+// authKeyFromConfigLocked returns the auth key from the config file, if any.
+// Any "file:" or ${ENV_VAR} reference in it was already expanded by
+// conffile.Parse.
+//
+// b.mu must be held.
+func (b *LocalBackend) authKeyFromConfigLocked() (string, error) {
+	if b.conf == nil || b.conf.Parsed.AuthKey == nil {
+		return "", nil
+	}
+	return *b.conf.Parsed.AuthKey, nil
+}
+
+// setBackoffPolicies sets the process-wide retry policies of connections
+// to those of bc, or to the defaults if it's nil.
+func setBackoffPolicies(bc *ipn.BackoffConfig) error {
+	policies, err := bc.Policies()
+	if err != nil {
+		return err
+	}
+	for c, p := range policies {
+		backoff.SetPolicy(c, p)
+	}
+	return nil
+}
@@ -314,6 +314,45 @@ func TestDNSConfigForNetmap(t *testing.T) {
 				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
 			},
 		},
+		{
+			name: "local_dns_routes",
+			nm: &netmap.NetworkMap{
+				DNS: tailcfg.DNSConfig{
+					Routes: map[string][]*dnstype.Resolver{
+						"corp.internal": {{Addr: "100.64.0.53"}},
+						"example.com":   {{Addr: "100.64.0.54"}},
+					},
+				},
+			},
+			prefs: &ipn.Prefs{
+				CorpDNS: true,
+				DNSRoutes: map[string][]string{
+					"corp.internal": {"192.168.1.53", "192.168.1.54:5353"},
+					"lab.internal":  {"https://dns.lab.internal/dns-query"},
+				},
+			},
+			want: &dns.Config{
+				Hosts: map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{
+					"corp.internal.": {{Addr: "192.168.1.53"}, {Addr: "192.168.1.54:5353"}},
+					"example.com.":   {{Addr: "100.64.0.54"}},
+					"lab.internal.":  {{Addr: "https://dns.lab.internal/dns-query"}},
+				},
+			},
+		},
+		{
+			name: "local_dns_routes_need_corp_dns",
+			nm:   &netmap.NetworkMap{},
+			prefs: &ipn.Prefs{
+				DNSRoutes: map[string][]string{
+					"corp.internal": {"192.168.1.53"},
+				},
+			},
+			want: &dns.Config{
+				Hosts:  map[dnsname.FQDN][]netip.Addr{},
+				Routes: map[dnsname.FQDN][]*dnstype.Resolver{},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
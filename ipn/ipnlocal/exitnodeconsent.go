@@ -0,0 +1,155 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/rands"
+)
+
+// maxExitNodeConsents is how many exit node consent notices are kept,
+// acknowledged or not.
+const maxExitNodeConsents = 20
+
+// errNoExitNodeConsent is returned when acknowledging an exit node consent
+// notice that doesn't exist.
+var errNoExitNodeConsent = errors.New("no such exit node consent notice")
+
+// readExitNodeConsents returns the saved exit node consent notices, oldest
+// first.
+func (b *LocalBackend) readExitNodeConsents() []ipn.ExitNodeConsent {
+	j, err := b.store.ReadState(ipn.ExitNodeConsentsStateKey)
+	if err != nil {
+		return nil
+	}
+	var cs []ipn.ExitNodeConsent
+	if err := json.Unmarshal(j, &cs); err != nil {
+		b.logf("invalid exit node consents in store: %v", err)
+		return nil
+	}
+	return cs
+}
+
+// writeExitNodeConsents saves the exit node consent notices cs.
+func (b *LocalBackend) writeExitNodeConsents(cs []ipn.ExitNodeConsent) {
+	j, err := json.Marshal(cs)
+	if err != nil {
+		return
+	}
+	if err := b.store.WriteState(ipn.ExitNodeConsentsStateKey, j); err != nil {
+		b.logf("failed to save exit node consents: %v", err)
+	}
+}
+
+// exitNodeOfLocked returns the exit node that p routes traffic through:
+// its stable ID if it's known, its IP if not, or neither if p doesn't use
+// an exit node.
+//
+// b.mu must be held.
+func (b *LocalBackend) exitNodeOfLocked(p ipn.PrefsView) (tailcfg.StableNodeID, netip.Addr) {
+	if !p.Valid() {
+		return "", netip.Addr{}
+	}
+	if id := p.ExitNodeID(); !id.IsZero() {
+		return id, netip.Addr{}
+	}
+	ip := p.ExitNodeIP()
+	if !ip.IsValid() {
+		return "", netip.Addr{}
+	}
+	if peer, ok := b.nodeByAddr[ip]; ok {
+		return peer.StableID(), netip.Addr{}
+	}
+	return "", ip
+}
+
+// noteExitNodeChangeLocked records a notice for the user to acknowledge, and
+// sends it to the IPN bus, if src is a policy rather than the user and it
+// changed the exit node from that of old, the prefs before the change, to
+// that of the current prefs.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteExitNodeChangeLocked(old ipn.PrefsView, src ipn.PrefSource) {
+	switch src {
+	case ipn.PrefSourceConfigFile, ipn.PrefSourceControl, ipn.PrefSourceExitNodePolicy:
+	default:
+		return
+	}
+	cur := b.pm.CurrentPrefs()
+	if src == ipn.PrefSourceControl && old.Valid() && old.ExitNodeIP().IsValid() && !cur.ExitNodeID().IsZero() {
+		// The netmap resolved the exit node's IP to its ID, which
+		// doesn't change the exit node. See setExitNodeID.
+		return
+	}
+	oldID, oldIP := b.exitNodeOfLocked(old)
+	id, ip := b.exitNodeOfLocked(cur)
+	if id == oldID && ip == oldIP {
+		return
+	}
+	c := ipn.ExitNodeConsent{
+		ID:         rands.HexString(16),
+		Time:       b.clock.Now(),
+		Source:     src,
+		ExitNodeID: id,
+		ExitNodeIP: ip,
+	}
+	if b.netMap != nil && !id.IsZero() {
+		if peer, ok := b.netMap.PeerWithStableID(id); ok {
+			c.ExitNodeName = peer.ComputedName()
+		}
+	}
+	cs := append(b.readExitNodeConsents(), c)
+	if len(cs) > maxExitNodeConsents {
+		cs = cs[len(cs)-maxExitNodeConsents:]
+	}
+	b.writeExitNodeConsents(cs)
+	to := "none"
+	switch {
+	case !id.IsZero():
+		to = string(id)
+	case ip.IsValid():
+		to = ip.String()
+	}
+	b.logf("exit node set to %s by %s; awaiting acknowledgement of notice %s", to, src, c.ID)
+	go b.send(ipn.Notify{ExitNodeConsent: &c})
+}
+
+// ExitNodeConsents returns the notices of the exit node changes made by
+// policy, oldest first, with whether the user acknowledged them.
+func (b *LocalBackend) ExitNodeConsents() []ipn.ExitNodeConsent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readExitNodeConsents()
+}
+
+// AcknowledgeExitNodeConsent records that the user acknowledged the exit
+// node consent notice with the given ID, or all pending notices if id is
+// empty.
+func (b *LocalBackend) AcknowledgeExitNodeConsent(id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cs := b.readExitNodeConsents()
+	now := b.clock.Now()
+	found := false
+	for i := range cs {
+		if id != "" && cs[i].ID != id {
+			continue
+		}
+		found = true
+		if cs[i].Pending() {
+			cs[i].Acknowledged = now
+			b.logf("exit node consent %s acknowledged", cs[i].ID)
+		}
+	}
+	if !found && id != "" {
+		return errNoExitNodeConsent
+	}
+	b.writeExitNodeConsents(cs)
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsd"
+	"tailscale.com/tstest"
+	"tailscale.com/types/logid"
+	"tailscale.com/wgengine"
+)
+
+func TestExitNodeConsent(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+
+	setExitNode := func(src ipn.PrefSource, id tailcfg.StableNodeID, ip netip.Addr) {
+		t.Helper()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		old := b.pm.CurrentPrefs()
+		p := old.AsStruct()
+		p.ExitNodeID = id
+		p.ExitNodeIP = ip
+		if err := b.pm.SetPrefs(p.View()); err != nil {
+			t.Fatal(err)
+		}
+		b.notePrefsChangedLocked(old, src)
+	}
+
+	// Changes made by the user need no consent.
+	setExitNode(ipn.PrefSourceCLI, "user-choice", netip.Addr{})
+	if cs := b.ExitNodeConsents(); len(cs) != 0 {
+		t.Fatalf("user change recorded %d notices", len(cs))
+	}
+
+	// Nor does the control server resolving an exit node IP to its ID.
+	setExitNode(ipn.PrefSourceCLI, "", netip.MustParseAddr("100.64.0.9"))
+	setExitNode(ipn.PrefSourceControl, "node9", netip.Addr{})
+	if cs := b.ExitNodeConsents(); len(cs) != 0 {
+		t.Fatalf("IP resolution recorded %d notices", len(cs))
+	}
+
+	setExitNode(ipn.PrefSourceExitNodePolicy, "policy-choice", netip.Addr{})
+	setExitNode(ipn.PrefSourceExitNodePolicy, "policy-choice", netip.Addr{}) // unchanged
+	setExitNode(ipn.PrefSourceConfigFile, "", netip.Addr{})
+	cs := b.ExitNodeConsents()
+	if len(cs) != 2 {
+		t.Fatalf("got %d notices; want 2", len(cs))
+	}
+	if c := cs[0]; c.Source != ipn.PrefSourceExitNodePolicy || c.ExitNodeID != "policy-choice" || !c.Pending() {
+		t.Errorf("first notice = %+v; want pending, from the exit node policy", c)
+	}
+	if c := cs[1]; c.Source != ipn.PrefSourceConfigFile || c.ExitNodeID != "" || !c.Pending() {
+		t.Errorf("second notice = %+v; want pending, from the config file, without exit node", c)
+	}
+
+	if err := b.AcknowledgeExitNodeConsent("nonexistent"); err == nil {
+		t.Error("acknowledging unknown notice succeeded")
+	}
+	if err := b.AcknowledgeExitNodeConsent(cs[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	cs = b.ExitNodeConsents()
+	if cs[0].Pending() || !cs[1].Pending() {
+		t.Errorf("after acknowledging first: pending = %v, %v; want false, true", cs[0].Pending(), cs[1].Pending())
+	}
+	if err := b.AcknowledgeExitNodeConsent(""); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range b.ExitNodeConsents() {
+		if c.Pending() {
+			t.Errorf("notice %s still pending after acknowledging all", c.ID)
+		}
+	}
+}
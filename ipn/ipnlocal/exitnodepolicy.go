@@ -0,0 +1,238 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+// currentNetworks returns the identifiers of the networks this machine is
+// on, as matched by ipn.ExitNodeRule.Network: the Wi-Fi SSID and the LAN
+// router's IP address, when known. It's a var for tests.
+var currentNetworks = func() []string {
+	var ret []string
+	if ssid := interfaces.WiFiSSID(); ssid != "" {
+		ret = append(ret, ssid)
+	}
+	if gw, _, ok := interfaces.LikelyHomeRouterIP(); ok {
+		ret = append(ret, gw.String())
+	}
+	return ret
+}
+
+// setExitNodePolicyFromPrefsLocked starts applying the ExitNodePolicy pref
+// when it changes.
+//
+// b.mu must be held.
+func (b *LocalBackend) setExitNodePolicyFromPrefsLocked(prefs ipn.PrefsView) {
+	var rules []ipn.ExitNodeRule
+	if prefs.Valid() {
+		rules = prefs.ExitNodePolicy().AsSlice()
+	}
+	if slices.Equal(rules, b.exitNodePolicy) {
+		return
+	}
+	b.exitNodePolicy = rules
+	b.exitNodePolicyChoice = ""
+	if b.exitNodePolicyTimer != nil {
+		b.exitNodePolicyTimer.Stop()
+		b.exitNodePolicyTimer = nil
+	}
+	b.maybeRunExitNodePolicyLocked(false)
+}
+
+// maybeRunExitNodePolicyLocked re-evaluates the exit node policy, if there
+// is one, in a new goroutine. If networkChanged, it first looks up the
+// current networks again.
+//
+// b.mu must be held.
+func (b *LocalBackend) maybeRunExitNodePolicyLocked(networkChanged bool) {
+	if len(b.exitNodePolicy) > 0 {
+		go b.runExitNodePolicy(networkChanged)
+	}
+}
+
+// runExitNodePolicy evaluates the exit node policy and, if the rule it picks
+// has changed since it last ran, sets the exit node that rule calls for.
+// The current networks are looked up if refreshNetworks is set or they
+// haven't been yet.
+func (b *LocalBackend) runExitNodePolicy(refreshNetworks bool) {
+	b.mu.Lock()
+	if len(b.exitNodePolicy) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	if refreshNetworks || b.exitNodePolicyNetworks == nil {
+		b.mu.Unlock()
+		// This may run commands, so don't hold b.mu.
+		networks := currentNetworks()
+		b.mu.Lock()
+		b.exitNodePolicyNetworks = append([]string{}, networks...)
+	}
+	rules := b.exitNodePolicy
+	networks := b.exitNodePolicyNetworks
+	if len(rules) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	now := b.clock.Now()
+	b.armExitNodePolicyTimerLocked(now)
+	i := slices.IndexFunc(rules, func(r ipn.ExitNodeRule) bool {
+		return r.Matches(networks, now)
+	})
+	if i < 0 {
+		b.exitNodePolicyChoice = ""
+		b.mu.Unlock()
+		return
+	}
+	choice := fmt.Sprintf("%d:%v", i, rules[i])
+	if choice == b.exitNodePolicyChoice {
+		b.mu.Unlock()
+		return
+	}
+	id, ok := b.resolveExitNodeLocked(rules[i].ExitNode)
+	if !ok {
+		// No netmap yet, or no such exit node. Try again on the next
+		// netmap.
+		b.mu.Unlock()
+		return
+	}
+	b.exitNodePolicyChoice = choice
+	p := b.pm.CurrentPrefs()
+	if !p.Valid() || (p.ExitNodeID() == id && !p.ExitNodeIP().IsValid()) {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("exit node policy: rule %d (%v) selects exit node %q", i+1, rules[i], id)
+	np := p.AsStruct()
+	np.ExitNodeID = id
+	np.ExitNodeIP = netip.Addr{}
+	if b.cc == nil {
+		// Not started yet; Start picks up the saved prefs.
+		if err := b.pm.SetPrefs(np.View()); err != nil {
+			b.logf("failed to save prefs: %v", err)
+		}
+		b.notePrefsChangedLocked(p, ipn.PrefSourceExitNodePolicy)
+		b.mu.Unlock()
+		return
+	}
+	b.setPrefsLockedOnEntry("ExitNodePolicy", ipn.PrefSourceExitNodePolicy, np)
+}
+
+// armExitNodePolicyTimerLocked arranges for the exit node policy to be run
+// again at the start of the next minute, if it has any rules limited to
+// certain hours.
+//
+// b.mu must be held.
+func (b *LocalBackend) armExitNodePolicyTimerLocked(now time.Time) {
+	if b.exitNodePolicyTimer != nil {
+		b.exitNodePolicyTimer.Stop()
+		b.exitNodePolicyTimer = nil
+	}
+	if !slices.ContainsFunc(b.exitNodePolicy, func(r ipn.ExitNodeRule) bool { return r.Hours != "" }) {
+		return
+	}
+	next := now.Truncate(time.Minute).Add(time.Minute)
+	b.exitNodePolicyTimer = b.clock.AfterFunc(next.Sub(now), func() { b.runExitNodePolicy(false) })
+}
+
+// resolveExitNodeLocked returns the stable ID of the exit node that an
+// ipn.ExitNodeRule.ExitNode value refers to in the current netmap, or the
+// empty ID for ipn.ExitNodeNone. It reports false if there's no such exit
+// node.
+//
+// b.mu must be held.
+func (b *LocalBackend) resolveExitNodeLocked(spec string) (_ tailcfg.StableNodeID, ok bool) {
+	if spec == ipn.ExitNodeNone {
+		return "", true
+	}
+	nm := b.netMap
+	if nm == nil {
+		return "", false
+	}
+	country, isAuto := "", spec == ipn.ExitNodeAuto
+	if c, ok := strings.CutPrefix(spec, ipn.ExitNodeAuto+":"); ok {
+		country, isAuto = c, true
+	}
+
+	var best tailcfg.NodeView
+	var bestLatency float64
+	for _, p := range nm.Peers {
+		if !tsaddr.ContainsExitRoutes(p.AllowedIPs()) {
+			continue
+		}
+		if !isAuto {
+			if exitNodeMatches(p, spec) {
+				return p.StableID(), true
+			}
+			continue
+		}
+		if online := p.Online(); online != nil && !*online {
+			continue
+		}
+		if country != "" {
+			hi := p.Hostinfo()
+			if !hi.Valid() || hi.Location() == nil || !strings.EqualFold(hi.Location().CountryCode, country) {
+				continue
+			}
+		}
+		latency := b.derpLatencyLocked(p)
+		if !best.Valid() || latency < bestLatency || (latency == bestLatency && p.StableID() < best.StableID()) {
+			best, bestLatency = p, latency
+		}
+	}
+	if !best.Valid() {
+		return "", false
+	}
+	return best.StableID(), true
+}
+
+// exitNodeMatches reports whether the peer p is the one named by spec: its
+// stable ID, a Tailscale IP, or its MagicDNS name, short or full.
+func exitNodeMatches(p tailcfg.NodeView, spec string) bool {
+	if spec == string(p.StableID()) || strings.EqualFold(spec, p.ComputedName()) ||
+		strings.EqualFold(strings.TrimSuffix(spec, "."), strings.TrimSuffix(p.Name(), ".")) {
+		return true
+	}
+	ip, err := netip.ParseAddr(spec)
+	if err != nil {
+		return false
+	}
+	for i := range p.Addresses().LenIter() {
+		if pfx := p.Addresses().At(i); pfx.IsSingleIP() && pfx.Addr() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// derpLatencyLocked returns this node's latency in seconds, per the last
+// netcheck, to the home DERP region of peer p, or +Inf if unknown. It
+// approximates the latency to p itself.
+//
+// b.mu must be held.
+func (b *LocalBackend) derpLatencyLocked(p tailcfg.NodeView) float64 {
+	ni := b.lastNetInfo
+	ipp, err := netip.ParseAddrPort(p.DERP())
+	if ni == nil || err != nil || ipp.Addr() != tailcfg.DerpMagicIPAddr {
+		return math.Inf(1)
+	}
+	ret := math.Inf(1)
+	for _, fam := range []string{"v4", "v6"} {
+		if l, ok := ni.DERPLatency[fmt.Sprintf("%d-%s", ipp.Port(), fam)]; ok {
+			ret = min(ret, l)
+		}
+	}
+	return ret
+}
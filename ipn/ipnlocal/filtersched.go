@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/filter"
+)
+
+// activePacketFilterLocked returns the rules of nm's packet filter that
+// apply now, per their time windows, and arranges for the filter to be
+// updated when that next changes. nm may be nil.
+//
+// b.mu must be held.
+func (b *LocalBackend) activePacketFilterLocked(nm *netmap.NetworkMap) []filter.Match {
+	if b.filterSchedTimer != nil {
+		b.filterSchedTimer.Stop()
+		b.filterSchedTimer = nil
+	}
+	var pf []filter.Match
+	if nm != nil {
+		pf = nm.PacketFilter
+	}
+	now := b.clock.Now()
+	active, st := filter.ActiveMatches(pf, now)
+	b.filterSched = st
+	if !st.Next.IsZero() {
+		b.filterSchedTimer = b.clock.AfterFunc(st.Next.Sub(now), b.onFilterScheduleChange)
+	}
+	return active
+}
+
+// onFilterScheduleChange updates the packet filter when the time windows of
+// its rules open or close, and notifies the IPN bus if that changed which
+// rules apply.
+func (b *LocalBackend) onFilterScheduleChange() {
+	if b.ctx.Err() != nil {
+		return
+	}
+	b.mu.Lock()
+	prev := b.filterSched
+	b.updateFilterLocked(b.netMap, b.pm.CurrentPrefs())
+	st := b.filterSched
+	b.mu.Unlock()
+
+	if st.Active == prev.Active && st.Inactive == prev.Inactive {
+		return
+	}
+	b.logf("packet filter schedule: %d of %d scheduled rules active", st.Active, st.Active+st.Inactive)
+	b.send(ipn.Notify{FilterSchedule: &ipn.FilterScheduleChange{
+		ActiveRules:   st.Active,
+		InactiveRules: st.Inactive,
+		NextChange:    st.Next,
+	}})
+}
@@ -0,0 +1,285 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/ringbuffer"
+)
+
+const (
+	// flowLogDedupWindow is how long after a flow is logged that packets
+	// of the same 5-tuple aren't logged again. It covers retransmitted
+	// TCP SYNs and the later packets of UDP and ICMP flows.
+	flowLogDedupWindow = time.Minute
+
+	// flowLogDedupFlows is the most recently logged flows remembered for
+	// deduplication.
+	flowLogDedupFlows = 1024
+
+	// flowLogQueueLen is how many flows may wait to be logged before
+	// further ones are dropped, rather than slowing down the packet path.
+	flowLogQueueLen = 256
+)
+
+var metricFlowLogDropped = clientmetric.NewCounter("flow_log_dropped")
+
+// flowEvent is a flow seen by the packet filter, on its way from the tstun
+// hook to the flow log.
+type flowEvent struct {
+	flow     flowtrack.Tuple
+	accepted bool
+	when     time.Time
+}
+
+// flowLog is the LocalBackend's opt-in audit log of the flows peers start
+// to this node, kept in a ring buffer and optionally exported.
+//
+// The zero value is a disabled log.
+type flowLog struct {
+	mu      sync.Mutex
+	cfg     ipnstate.FlowLogConfig
+	ents    *ringbuffer.RingBuffer[ipnstate.FlowLogEntry] // nil if disabled
+	seq     uint64                                        // of the last entry added
+	changed chan struct{}                                 // closed and replaced when an entry is added
+	seen    flowtrack.Cache[time.Time]                    // when each recent flow was logged
+
+	exportMu     sync.Mutex
+	export       io.WriteCloser // or nil; each Write is one entry
+	exportFile   string
+	exportSyslog bool
+}
+
+// enabled reports whether flows should be logged, to the ring buffer or
+// an export.
+func (fl *flowLog) enabled() bool {
+	fl.mu.Lock()
+	ringEnabled := fl.ents != nil
+	fl.mu.Unlock()
+	fl.exportMu.Lock()
+	defer fl.exportMu.Unlock()
+	return ringEnabled || fl.export != nil
+}
+
+func (fl *flowLog) setConfig(cfg ipnstate.FlowLogConfig) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if cfg.Size <= 0 {
+		cfg = ipnstate.FlowLogConfig{}
+	}
+	if cfg.Size != fl.cfg.Size {
+		fl.ents = nil
+		if cfg.Size > 0 {
+			fl.ents = ringbuffer.New[ipnstate.FlowLogEntry](cfg.Size)
+		}
+	}
+	fl.cfg = cfg
+}
+
+// setExport replaces where flows are exported to, closing the previous
+// writer, if any.
+func (fl *flowLog) setExport(w io.WriteCloser, file string, syslog bool) {
+	fl.exportMu.Lock()
+	defer fl.exportMu.Unlock()
+	if fl.export != nil {
+		fl.export.Close()
+	}
+	fl.export, fl.exportFile, fl.exportSyslog = w, file, syslog
+}
+
+// shouldLog reports whether ev is the start of a flow that hasn't been
+// logged within flowLogDedupWindow, and if so, records it as logged.
+func (fl *flowLog) shouldLog(ev flowEvent) bool {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.seen.MaxEntries = flowLogDedupFlows
+	if last, ok := fl.seen.Get(ev.flow); ok && ev.when.Sub(*last) < flowLogDedupWindow {
+		return false
+	}
+	fl.seen.Add(ev.flow, ev.when)
+	return true
+}
+
+// add logs e, numbering it, and exports it.
+func (fl *flowLog) add(e ipnstate.FlowLogEntry) {
+	fl.mu.Lock()
+	fl.seq++
+	e.Seq = fl.seq
+	if fl.ents != nil {
+		fl.ents.Add(e)
+		if fl.changed != nil {
+			close(fl.changed)
+			fl.changed = nil
+		}
+	}
+	fl.mu.Unlock()
+
+	fl.exportMu.Lock()
+	defer fl.exportMu.Unlock()
+	if fl.export == nil {
+		return
+	}
+	var line []byte
+	if fl.exportSyslog {
+		line = []byte(formatFlowLogEntry(e))
+	} else {
+		line, _ = json.Marshal(e)
+		line = append(line, '\n')
+	}
+	fl.export.Write(line)
+}
+
+// formatFlowLogEntry formats e as a line of text, for syslog.
+func formatFlowLogEntry(e ipnstate.FlowLogEntry) string {
+	verdict := "rejected"
+	if e.Accepted {
+		verdict = "accepted"
+	}
+	who := "unknown peer"
+	if e.Node != "" {
+		who = fmt.Sprintf("%s (%s, %s)", e.Node, e.NodeID, e.User)
+	}
+	return fmt.Sprintf("%s %s flow from %v to %v by %s", verdict, e.Proto, e.Src, e.Dst, who)
+}
+
+// entriesSince returns the logged entries after seq, and a channel that's
+// closed when another is added.
+func (fl *flowLog) entriesSince(seq uint64) (cfg ipnstate.FlowLogConfig, ents []ipnstate.FlowLogEntry, changed <-chan struct{}) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.ents != nil {
+		for _, e := range fl.ents.GetAll() {
+			if e.Seq > seq {
+				ents = append(ents, e)
+			}
+		}
+	}
+	if fl.changed == nil {
+		fl.changed = make(chan struct{})
+	}
+	return fl.cfg, ents, fl.changed
+}
+
+// SetFlowLogConfig configures the audit log of inbound flows.
+func (b *LocalBackend) SetFlowLogConfig(cfg ipnstate.FlowLogConfig) error {
+	b.flowLog.setConfig(cfg)
+	return b.updateFlowLogger()
+}
+
+// SetFlowLogExport sets where inbound flows are also written to, as they're
+// logged: appended as JSON lines to file, if non-empty, or, if syslog is
+// true, sent to the local syslog daemon. It's meant to be set by
+// tailscaled's flags, not by LocalAPI users.
+func (b *LocalBackend) SetFlowLogExport(file string, syslog bool) error {
+	var w io.WriteCloser
+	switch {
+	case file != "" && syslog:
+		return errors.New("flow log can be exported to a file or syslog, not both")
+	case file != "":
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		w = f
+	case syslog:
+		var err error
+		if w, err = newFlowLogSyslog(); err != nil {
+			return err
+		}
+	}
+	b.flowLog.setExport(w, file, syslog)
+	return b.updateFlowLogger()
+}
+
+// FlowLog returns the inbound flows logged after the one numbered since,
+// waiting until ctx is done for one if there are none and wait is true.
+func (b *LocalBackend) FlowLog(ctx context.Context, since uint64, wait bool) *ipnstate.FlowLog {
+	cfg, ents, changed := b.flowLog.entriesSince(since)
+	if wait && len(ents) == 0 {
+		select {
+		case <-changed:
+			cfg, ents, _ = b.flowLog.entriesSince(since)
+		case <-ctx.Done():
+		}
+	}
+	fl := &ipnstate.FlowLog{
+		Config:  cfg,
+		Entries: ents,
+	}
+	b.flowLog.exportMu.Lock()
+	fl.ExportFile, fl.ExportSyslog = b.flowLog.exportFile, b.flowLog.exportSyslog
+	b.flowLog.exportMu.Unlock()
+	return fl
+}
+
+// updateFlowLogger installs or removes the tstun hook that feeds the flow
+// log, depending on whether it's enabled, starting the goroutine that
+// logs flows the first time.
+func (b *LocalBackend) updateFlowLogger() error {
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return errors.New("no TUN wrapper")
+	}
+	if !b.flowLog.enabled() {
+		tunWrap.SetFlowLogger(nil)
+		return nil
+	}
+	b.mu.Lock()
+	ch := b.flowLogCh
+	if ch == nil {
+		ch = make(chan flowEvent, flowLogQueueLen)
+		b.flowLogCh = ch
+		go b.logFlows(ch)
+	}
+	b.mu.Unlock()
+	tunWrap.SetFlowLogger(func(flow flowtrack.Tuple, accepted bool) {
+		select {
+		case ch <- flowEvent{flow: flow, accepted: accepted, when: b.clock.Now()}:
+		default:
+			metricFlowLogDropped.Add(1)
+		}
+	})
+	return nil
+}
+
+// logFlows is a goroutine that logs the flows received on ch, along with
+// the identity of the peers that started them, until b is shut down.
+func (b *LocalBackend) logFlows(ch <-chan flowEvent) {
+	for {
+		var ev flowEvent
+		select {
+		case <-b.ctx.Done():
+			return
+		case ev = <-ch:
+		}
+		if !b.flowLog.shouldLog(ev) {
+			continue
+		}
+		e := ipnstate.FlowLogEntry{
+			Time:     ev.when,
+			Proto:    ev.flow.Proto.String(),
+			Src:      ev.flow.Src,
+			Dst:      ev.flow.Dst,
+			Accepted: ev.accepted,
+		}
+		if n, u, ok := b.WhoIs(netip.AddrPortFrom(ev.flow.Src.Addr(), 0)); ok {
+			e.Node = n.Name()
+			e.NodeID = n.StableID()
+			e.User = u.LoginName
+		}
+		b.flowLog.add(e)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || plan9 || js || wasip1
+
+package ipnlocal
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// newFlowLogSyslog returns an error; there's no syslog on this platform.
+func newFlowLogSyslog() (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
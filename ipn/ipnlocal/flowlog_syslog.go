@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !plan9 && !js && !wasip1
+
+package ipnlocal
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// newFlowLogSyslog returns a writer that sends each write to the local
+// syslog daemon as a message tagged "tailscaled-flows".
+func newFlowLogSyslog() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "tailscaled-flows")
+}
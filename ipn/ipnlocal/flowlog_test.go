@@ -0,0 +1,109 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/types/ipproto"
+)
+
+type nopCloseBuffer struct{ bytes.Buffer }
+
+func (*nopCloseBuffer) Close() error { return nil }
+
+func TestFlowLog(t *testing.T) {
+	var fl flowLog
+	if fl.enabled() {
+		t.Fatal("zero flowLog is enabled")
+	}
+	fl.setConfig(ipnstate.FlowLogConfig{Size: 2})
+	if !fl.enabled() {
+		t.Fatal("flowLog with Size 2 is not enabled")
+	}
+
+	now := time.Date(2023, 3, 20, 12, 0, 0, 0, time.UTC)
+	flow := func(srcPort uint16) flowtrack.Tuple {
+		return flowtrack.Tuple{
+			Proto: ipproto.TCP,
+			Src:   netip.AddrPortFrom(netip.MustParseAddr("100.64.0.2"), srcPort),
+			Dst:   netip.MustParseAddrPort("100.64.0.1:22"),
+		}
+	}
+	if !fl.shouldLog(flowEvent{flow: flow(1000), when: now}) {
+		t.Error("first flow not logged")
+	}
+	if fl.shouldLog(flowEvent{flow: flow(1000), when: now.Add(time.Second)}) {
+		t.Error("retransmitted flow logged again")
+	}
+	if !fl.shouldLog(flowEvent{flow: flow(1000), when: now.Add(flowLogDedupWindow)}) {
+		t.Error("flow not logged again after dedup window")
+	}
+
+	var export nopCloseBuffer
+	fl.setExport(&export, "flows.json", false)
+	for i := uint16(1); i <= 3; i++ {
+		f := flow(1000 + i)
+		fl.add(ipnstate.FlowLogEntry{Proto: f.Proto.String(), Src: f.Src, Dst: f.Dst, Accepted: i != 2})
+	}
+
+	_, ents, changed := fl.entriesSince(0)
+	if len(ents) != 2 || ents[0].Seq != 2 || ents[1].Seq != 3 {
+		t.Fatalf("entries = %+v; want the last two", ents)
+	}
+	if _, ents, _ := fl.entriesSince(2); len(ents) != 1 || ents[0].Src.Port() != 1003 {
+		t.Errorf("entries since 2 = %+v; want only the third", ents)
+	}
+	select {
+	case <-changed:
+		t.Fatal("changed closed before another entry was added")
+	default:
+	}
+	fl.add(ipnstate.FlowLogEntry{})
+	select {
+	case <-changed:
+	default:
+		t.Fatal("changed not closed when an entry was added")
+	}
+
+	dec := json.NewDecoder(&export)
+	var got []ipnstate.FlowLogEntry
+	for dec.More() {
+		var e ipnstate.FlowLogEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 4 || got[1].Accepted || !got[2].Accepted {
+		t.Errorf("exported %+v; want all four entries", got)
+	}
+
+	fl.setConfig(ipnstate.FlowLogConfig{})
+	fl.setExport(nil, "", false)
+	if fl.enabled() {
+		t.Error("flowLog enabled after disabling")
+	}
+}
+
+func TestFormatFlowLogEntry(t *testing.T) {
+	e := ipnstate.FlowLogEntry{
+		Proto:  "TCP",
+		Src:    netip.MustParseAddrPort("100.64.0.2:1000"),
+		Dst:    netip.MustParseAddrPort("100.64.0.1:22"),
+		Node:   "peer.example.ts.net.",
+		NodeID: "n123",
+		User:   "alice@example.com",
+	}
+	want := "rejected TCP flow from 100.64.0.2:1000 to 100.64.0.1:22 by peer.example.ts.net. (n123, alice@example.com)"
+	if got := formatFlowLogEntry(e); got != want {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"runtime"
+	"slices"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/netutil"
+)
+
+// fixIPForwardingFile is the sysctl.d file to which the FixIPForwarding
+// pref persists its changes. It's a var for tests.
+var fixIPForwardingFile = netutil.FixIPForwardingFile
+
+// setIPForwardingFromPrefsLocked fixes the system's IP forwarding sysctls
+// for the advertised routes, in a new goroutine, when the FixIPForwarding
+// pref is set and the routes change.
+//
+// b.mu must be held.
+func (b *LocalBackend) setIPForwardingFromPrefsLocked(prefs ipn.PrefsView) {
+	var routes []netip.Prefix
+	if prefs.Valid() && prefs.FixIPForwarding() {
+		routes = prefs.AdvertiseRoutes().AsSlice()
+	}
+	if slices.Equal(routes, b.fixIPForwardingRoutes) {
+		return
+	}
+	b.fixIPForwardingRoutes = routes
+	if len(routes) == 0 || runtime.GOOS != "linux" || b.sys.IsNetstackRouter() {
+		return
+	}
+	go b.fixIPForwarding(routes)
+}
+
+// fixIPForwarding changes the sysctls that would prevent routes from being
+// forwarded, logging what it changed. The old values are also recorded in
+// fixIPForwardingFile.
+func (b *LocalBackend) fixIPForwarding(routes []netip.Prefix) {
+	changes, err := netutil.FixIPForwarding(routes, b.sys.NetMon.Get().InterfaceState(), fixIPForwardingFile)
+	for _, c := range changes {
+		b.logf("FixIPForwarding: set %v", c)
+	}
+	if err != nil {
+		b.logf("FixIPForwarding: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// keepHotSilentPings is how many intervals without a reply from one of the
+// KeepHotPeers are logged as the heartbeat to it failing.
+const keepHotSilentPings = 3
+
+// keepHotPeer is the state of the heartbeat to one of the KeepHotPeers.
+type keepHotPeer struct {
+	lastPong time.Time // when it last replied, or when its pings started
+	silent   bool      // whether the lack of replies was logged
+}
+
+// setKeepHotFromPrefsLocked starts, stops or reschedules the heartbeat
+// pings to the KeepHotPeers pref when it or its interval change.
+//
+// b.mu must be held.
+func (b *LocalBackend) setKeepHotFromPrefsLocked(prefs ipn.PrefsView) {
+	var peers []string
+	var interval time.Duration
+	if prefs.Valid() && prefs.KeepHotPeers().Len() > 0 {
+		peers = prefs.KeepHotPeers().AsSlice()
+		interval = prefs.KeepHotInterval()
+	}
+	if slices.Equal(peers, b.keepHotNames) && interval == b.keepHotInterval {
+		return
+	}
+	b.keepHotNames = peers
+	b.keepHotInterval = interval
+	b.keepHot = nil
+	if b.keepHotTimer != nil {
+		b.keepHotTimer.Stop()
+		b.keepHotTimer = nil
+	}
+	if len(peers) == 0 {
+		return
+	}
+	if b.netMap != nil {
+		if _, unknown := resolveKeepHotPeers(b.netMap, peers); len(unknown) > 0 {
+			b.logf("keep-hot: no peers named %q", unknown)
+		}
+	}
+	b.keepHotTimer = b.clock.AfterFunc(interval, b.sendKeepHotPings)
+}
+
+// resolveKeepHotPeers returns the Tailscale IPs to ping for the
+// KeepHotPeers names in nm, preferring IPv4, and the names matching no peer.
+func resolveKeepHotPeers(nm *netmap.NetworkMap, names []string) (targets []netip.Addr, unknown []string) {
+	for _, name := range names {
+		var target netip.Addr
+		for _, p := range nm.Peers {
+			if !exitNodeMatches(p, name) {
+				continue
+			}
+			if ip, err := netip.ParseAddr(name); err == nil {
+				target = ip
+				break
+			}
+			for i := range p.Addresses().LenIter() {
+				if pfx := p.Addresses().At(i); pfx.IsSingleIP() && (!target.IsValid() || pfx.Addr().Is4() && !target.Is4()) {
+					target = pfx.Addr()
+				}
+			}
+			break
+		}
+		if !target.IsValid() {
+			unknown = append(unknown, name)
+		} else if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, unknown
+}
+
+// sendKeepHotPings sends a TSMP ping to each of the KeepHotPeers, while
+// running, logs the ones that stopped replying, and schedules the next
+// pings.
+func (b *LocalBackend) sendKeepHotPings() {
+	b.mu.Lock()
+	if b.ctx.Err() != nil || len(b.keepHotNames) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.keepHotTimer = b.clock.AfterFunc(b.keepHotInterval, b.sendKeepHotPings)
+	if b.state != ipn.Running || b.netMap == nil {
+		b.mu.Unlock()
+		return
+	}
+	now := b.clock.Now()
+	targets, _ := resolveKeepHotPeers(b.netMap, b.keepHotNames)
+	old := b.keepHot
+	b.keepHot = make(map[netip.Addr]*keepHotPeer, len(targets))
+	for _, ip := range targets {
+		kp, ok := old[ip]
+		if !ok {
+			kp = &keepHotPeer{lastPong: now}
+		}
+		b.keepHot[ip] = kp
+		if !kp.silent && now.Sub(kp.lastPong) > keepHotSilentPings*b.keepHotInterval {
+			kp.silent = true
+			b.logf("keep-hot: no reply from %v since %v", ip, kp.lastPong.Format(time.RFC3339))
+		}
+	}
+	b.mu.Unlock()
+
+	for _, ip := range targets {
+		b.e.Ping(ip, tailcfg.PingTSMP, 0, func(pr *ipnstate.PingResult) {
+			if pr.Err != "" {
+				return
+			}
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if kp, ok := b.keepHot[ip]; ok {
+				if kp.silent {
+					b.logf("keep-hot: %v is replying again", ip)
+				}
+				kp.lastPong, kp.silent = b.clock.Now(), false
+			}
+		})
+	}
+}
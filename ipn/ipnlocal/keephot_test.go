@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestResolveKeepHotPeers(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				ID:   1,
+				Name: "plc1.example.ts.net.",
+				Addresses: []netip.Prefix{
+					netip.MustParsePrefix("fd7a:115c:a1e0::1/128"),
+					netip.MustParsePrefix("100.64.0.1/32"),
+				},
+			}).View(),
+			(&tailcfg.Node{
+				ID:   2,
+				Name: "scada.example.ts.net.",
+				Addresses: []netip.Prefix{
+					netip.MustParsePrefix("100.64.0.2/32"),
+					netip.MustParsePrefix("fd7a:115c:a1e0::2/128"),
+				},
+			}).View(),
+		},
+	}
+	targets, unknown := resolveKeepHotPeers(nm, []string{
+		"plc1.example.ts.net",
+		"fd7a:115c:a1e0::2",
+		"100.64.0.1", // same peer as plc1
+		"nope",
+	})
+	wantTargets := []netip.Addr{
+		netip.MustParseAddr("100.64.0.1"),
+		netip.MustParseAddr("fd7a:115c:a1e0::2"),
+	}
+	if !reflect.DeepEqual(targets, wantTargets) {
+		t.Errorf("targets = %v; want %v", targets, wantTargets)
+	}
+	if want := []string{"nope"}; !reflect.DeepEqual(unknown, want) {
+		t.Errorf("unknown = %q; want %q", unknown, want)
+	}
+}
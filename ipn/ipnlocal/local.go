@@ -42,20 +42,27 @@
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/ipnauth"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/policy"
 	"tailscale.com/log/sockstatlog"
 	"tailscale.com/logpolicy"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/net/relaystats"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/net/tstun"
 	"tailscale.com/paths"
 	"tailscale.com/portlist"
 	"tailscale.com/syncs"
@@ -149,19 +156,26 @@ type LocalBackend struct {
 	dialer                *tsdial.Dialer  // non-nil; TODO(bradfitz): remove; use sys
 	backendLogID          logid.PublicID
 	unregisterNetMon      func()
+	unregisterProxyChange func()
 	unregisterHealthWatch func()
 	portpoll              *portlist.Poller // may be nil
 	portpollOnce          sync.Once        // guards starting readPoller
+	portMapLeaseOnce      sync.Once        // guards restorePortMapLease
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
 	newDecompressor       func() (controlclient.Decompressor, error)
 	varRoot               string         // or empty if SetVarRoot never called
 	logFlushFunc          func()         // or nil if SetLogFlusher wasn't called
+	peerAPIDebugHandler   http.Handler   // or nil if SetPeerAPIDebugHandler wasn't called
 	em                    *expiryManager // non-nil
 	sshAtomicBool         atomic.Bool
 	shutdownCalled        bool // if Shutdown has been called
 	debugSink             *capture.Sink
 	sockstatLogger        *sockstatlog.Logger
 
+	// metricsTailnetPort is the port on which metrics are served on this
+	// node's Tailscale IPs, or zero if they're not.
+	metricsTailnetPort atomic.Uint32
+
 	// getTCPHandlerForFunnelFlow returns a handler for an incoming TCP flow for
 	// the provided srcAddr and dstPort if one exists.
 	//
@@ -182,8 +196,19 @@ type LocalBackend struct {
 	// It's used to detect when the user has changed their profile.
 	lastProfileID ipn.ProfileID
 
+	// standbyNetMaps holds the most recent network map of each profile
+	// that has had one during this process's lifetime. It is used to bring
+	// the data path up for a profile as soon as it's switched to, without
+	// waiting for the control server. See applyStandbyNetMap.
+	standbyNetMaps map[ipn.ProfileID]*netmap.NetworkMap
+
+	// peerAPIExtHandlers are the peerapi handlers registered with
+	// RegisterPeerAPIHandler, keyed by path.
+	peerAPIExtHandlers map[string]peerAPIExtHandler
+
 	filterAtomic                 atomic.Pointer[filter.Filter]
 	containsViaIPFuncAtomic      syncs.AtomicValue[func(netip.Addr) bool]
+	dns64RoutesAtomic            syncs.AtomicValue[[]netip.Prefix]
 	shouldInterceptTCPPortAtomic syncs.AtomicValue[func(uint16) bool]
 	numClientStatusCalls         atomic.Uint32
 
@@ -206,6 +231,7 @@ type LocalBackend struct {
 	hostinfo *tailcfg.Hostinfo
 	// netMap is not mutated in-place once set.
 	netMap           *netmap.NetworkMap
+	reconfiguredNM   *netmap.NetworkMap     // netMap as last applied to the engine by authReconfig
 	nmExpiryTimer    tstime.TimerController // for updating netMap on node expiry; can be nil
 	nodeByAddr       map[netip.Addr]tailcfg.NodeView
 	activeLogin      string // last logged LoginName from netMap
@@ -218,13 +244,45 @@ type LocalBackend struct {
 	interact         bool
 	egg              bool
 	prevIfState      *interfaces.State
-	peerAPIServer    *peerAPIServer // or nil
+	netEventWatchers set.HandleSet[chan<- ipnstate.NetworkEvent]
+	lastPortalCheck  *netcheck.Report // last report whose captive portal result was sent to netEventWatchers
+	peerAPIServer    *peerAPIServer   // or nil
 	peerAPIListeners []*peerAPIListener
 	loginFlags       controlclient.LoginFlags
 	incomingFiles    map[*incomingFile]bool
 	fileWaiters      set.HandleSet[context.CancelFunc] // of wake-up funcs
 	notifyWatchers   set.HandleSet[chan *ipn.Notify]
-	lastStatusTime   time.Time // status.AsOf value of the last processed status update
+	lastStatusTime   time.Time        // status.AsOf value of the last processed status update
+	lastPortMapLease portmapper.Lease // last port mapping lease written to the store
+	// relayStats counts the traffic forwarded on behalf of peers
+	// when acting as a subnet router or exit node.
+	relayStats relaystats.Tracker
+	// bandwidth counts the traffic exchanged over Tailscale in the
+	// usage periods of the BandwidthQuota pref.
+	bandwidth bandwidthMeter
+	// netcheckHistory records netcheck samples for latency trend
+	// reports while the NetcheckHistoryHours pref is set.
+	netcheckHistory netcheckHistory
+	// flowLog is the opt-in audit log of inbound flows from peers.
+	flowLog flowLog
+	// flowLogCh carries inbound flows from the tstun hook to the
+	// goroutine that logs them. It's nil until flow logging is first
+	// enabled.
+	flowLogCh chan flowEvent
+	// metricsServer serves Prometheus metrics on localhost, if enabled
+	// by the MetricsPort pref.
+	metricsServer *metricsServer
+	// proxyPrefs is the Proxy pref last applied with tshttpproxy.SetConfig.
+	proxyPrefs ipn.ProxyPrefs
+	// proxyCreds is the "user:password" credentials for the proxy in
+	// the Proxy pref, from ipn.ProxyCredentialsStateKey.
+	proxyCreds string
+	// proxyCredsApplied is the proxyCreds last applied with
+	// tshttpproxy.SetConfig, alongside proxyPrefs.
+	proxyCredsApplied string
+	// otherVPNs are the VPNs other than Tailscale that were active as of
+	// the last network change.
+	otherVPNs []netmon.OtherVPN
 	// directFileRoot, if non-empty, means to write received files
 	// directly to this directory, without staging them in an
 	// intermediate buffered directory for "pick-up" later. If
@@ -240,16 +298,56 @@ type LocalBackend struct {
 	directFileRoot          string
 	directFileDoFinalRename bool // false on macOS, true on several NAS platforms
 	componentLogUntil       map[string]componentLogState
+
+	// wantRunningSched is the pending timed change of WantRunning, if
+	// any, and wantRunningTimer the timer for its next step.
+	wantRunningSched *ipn.WantRunningSchedule
+	wantRunningTimer tstime.TimerController
+
+	// exitNodePolicy is the ExitNodePolicy pref in effect, and
+	// exitNodePolicyChoice the rule it last applied, if any.
+	// exitNodePolicyNetworks are the networks the policy last saw this
+	// machine on. See runExitNodePolicy.
+	exitNodePolicy         []ipn.ExitNodeRule
+	exitNodePolicyChoice   string
+	exitNodePolicyNetworks []string
+	exitNodePolicyTimer    tstime.TimerController
+
+	// keepHotNames and keepHotInterval are the KeepHotPeers pref and its
+	// interval last applied, keepHot the state of the heartbeat to each
+	// resolved peer IP, and keepHotTimer the timer for the next pings.
+	keepHotNames    []string
+	keepHotInterval time.Duration
+	keepHot         map[netip.Addr]*keepHotPeer
+	keepHotTimer    tstime.TimerController
+
+	// filterSched is the state of the time windows of the packet filter
+	// rules last installed, and filterSchedTimer the timer to update the
+	// filter at their next change.
+	filterSched      filter.ScheduleStatus
+	filterSchedTimer tstime.TimerController
+
+	// fixIPForwardingRoutes are the advertised routes that the
+	// FixIPForwarding pref last fixed the sysctls for, if it's set.
+	fixIPForwardingRoutes []netip.Prefix
+
+	// lastNetInfo is the last NetInfo from magicsock, or nil.
+	lastNetInfo *tailcfg.NetInfo
+
 	// c2nUpdateStatus is the status of c2n-triggered client update.
 	c2nUpdateStatus updateStatus
+	// conf is the config file in effect when running in declarative
+	// config-file mode, or nil otherwise.
+	conf *conffile.Config
 
 	// ServeConfig fields. (also guarded by mu)
 	lastServeConfJSON   mem.RO              // last JSON that was parsed into serveConfig
 	serveConfig         ipn.ServeConfigView // or !Valid if none
 	activeWatchSessions set.Set[string]     // of WatchIPN SessionID
 
-	serveListeners     map[netip.AddrPort]*serveListener // addrPort => serveListener
-	serveProxyHandlers sync.Map                          // string (HTTPHandler.Proxy) => *httputil.ReverseProxy
+	serveListeners      map[netip.AddrPort]*serveListener // addrPort => serveListener
+	serveProxyHandlers  sync.Map                          // serveProxyKey => *httputil.ReverseProxy
+	serveHealthCheckers sync.Map                          // serveHealthKey => *serveHealthChecker
 
 	// statusLock must be held before calling statusChanged.Wait() or
 	// statusChanged.Broadcast().
@@ -271,6 +369,9 @@ type LocalBackend struct {
 
 	// Last ClientVersion received in MapResponse, guarded by mu.
 	lastClientVersion *tailcfg.ClientVersion
+	// clientVersionSeen is when lastClientVersion.LatestVersion was first
+	// received, guarded by mu.
+	clientVersionSeen time.Time
 }
 
 type updateStatus struct {
@@ -355,6 +456,7 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	// then also whenever it changes:
 	b.linkChange(&netmon.ChangeDelta{New: netMon.InterfaceState()})
 	b.unregisterNetMon = netMon.RegisterChangeCallback(b.linkChange)
+	b.unregisterProxyChange = tshttpproxy.RegisterChangeCallback(b.onProxyConfigChange)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
 
@@ -363,6 +465,9 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 	} else {
 		b.logf("[unexpected] failed to wire up PeerAPI port for engine %T", e)
 	}
+	if mc, ok := b.sys.MagicSock.GetOK(); ok {
+		mc.SetPeerSilentCallback(b.onPeerSilent)
+	}
 
 	for _, component := range debuggableComponents {
 		key := componentStateKey(component)
@@ -374,6 +479,22 @@ func NewLocalBackend(logf logger.Logf, logID logid.PublicID, sys *tsd.System, lo
 		}
 	}
 
+	b.restoreWantRunningSchedule()
+	b.restoreBandwidthUsage()
+	b.restoreProxyCredentials()
+	go b.meterBandwidth()
+	go b.recordNetcheckHistory()
+
+	if sys.InitialConfig != nil {
+		b.mu.Lock()
+		err := b.setConfigLocked(sys.InitialConfig)
+		b.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		go b.watchConfigFile()
+	}
+
 	return b, nil
 }
 
@@ -526,6 +647,7 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	ifst := delta.New
 	hadPAC := b.prevIfState.HasPAC()
 	b.prevIfState = ifst
+	b.sendNetworkEventLocked(ipnstate.NetworkEvent{Link: b.linkStateLocked(delta)})
 	b.pauseOrResumeControlClientLocked()
 
 	// If the PAC-ness of the network changed, reconfig wireguard+route to
@@ -540,10 +662,24 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 		}
 	}
 
+	// If another VPN came up or went away, reconfigure the routes to
+	// apply the OtherVPNPolicy pref.
+	if b.setOtherVPNsLocked(netmon.OtherVPNs(ifst, b.sys.NetMon.Get().TailscaleInterfaceName())) {
+		switch b.state {
+		case ipn.NoState, ipn.Stopped:
+			// Do nothing.
+		default:
+			go b.authReconfig()
+		}
+	}
+
 	// If the local network configuration has changed, our filter may
 	// need updating to tweak default routes.
 	b.updateFilterLocked(b.netMap, b.pm.CurrentPrefs())
 
+	// A new network may call for a different exit node.
+	b.maybeRunExitNodePolicyLocked(true)
+
 	if peerAPIListenAsync && b.netMap != nil && b.state == ipn.Running {
 		want := len(b.netMap.Addresses)
 		if len(b.peerAPIListeners) < want {
@@ -553,6 +689,20 @@ func (b *LocalBackend) linkChange(delta *netmon.ChangeDelta) {
 	}
 }
 
+// onProxyConfigChange is called when the outbound HTTP proxy configuration
+// changes. It re-dials control and DERP so they use the new proxy.
+func (b *LocalBackend) onProxyConfigChange() {
+	b.mu.Lock()
+	cc := b.cc
+	b.mu.Unlock()
+	if cc != nil {
+		cc.ResetConnections()
+	}
+	if mc, err := b.magicConn(); err == nil {
+		mc.ReconnectDERPs("proxy-changed")
+	}
+}
+
 func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
 	if err == nil {
 		b.logf("health(%q): ok", sys)
@@ -591,6 +741,7 @@ func (b *LocalBackend) Shutdown() {
 		b.sshServer = nil
 	}
 	b.closePeerAPIListenersLocked()
+	b.closeMetricsServerLocked()
 	if b.debugSink != nil {
 		b.e.InstallCaptureHook(nil)
 		b.debugSink.Close()
@@ -603,10 +754,13 @@ func (b *LocalBackend) Shutdown() {
 	}
 
 	b.unregisterNetMon()
+	b.unregisterProxyChange()
 	b.unregisterHealthWatch()
 	if cc != nil {
 		cc.Shutdown()
 	}
+	b.saveBandwidthUsage()
+	b.saveNetcheckHistory()
 	b.ctxCancel()
 	b.e.Close()
 	b.e.Wait()
@@ -691,7 +845,7 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 			s.Health = append(s.Health, m)
 		}
 		if version.IsUnstableBuild() {
-			s.Health = append(s.Health, "This is an unstable (development) version of Tailscale; frequent updates and bugs are likely")
+			s.Health = append(s.Health, healthmsg.UnstableBuild)
 		}
 		if b.netMap != nil {
 			s.CertDomains = append([]string(nil), b.netMap.DNS.CertDomains...)
@@ -702,6 +856,9 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 			s.CurrentTailnet.MagicDNSSuffix = b.netMap.MagicDNSSuffix()
 			s.CurrentTailnet.MagicDNSEnabled = b.netMap.DNS.Proxied
 			s.CurrentTailnet.Name = b.netMap.Domain
+			if c := b.netMap.SSHHostCerts; c != nil {
+				s.CurrentTailnet.SSHHostCAs = append([]string(nil), c.CAs...)
+			}
 			if prefs := b.pm.CurrentPrefs(); prefs.Valid() {
 				if !prefs.RouteAll() && b.netMap.AnyPeersAdvertiseRoutes() {
 					s.Health = append(s.Health, healthmsg.WarnAcceptRoutesOff)
@@ -752,6 +909,9 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 			for _, addr := range tailscaleIPs {
 				ss.TailscaleIPs = append(ss.TailscaleIPs, addr)
 			}
+			if b.hostinfo != nil {
+				ss.SSH_HostKeys = b.hostinfo.SSH_HostKeys
+			}
 
 		} else {
 			ss.HostName, _ = os.Hostname()
@@ -775,8 +935,14 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 	for id, up := range b.netMap.UserProfiles {
 		sb.AddUser(id, up)
 	}
-	exitNodeID := b.pm.CurrentPrefs().ExitNodeID()
+	prefs := b.pm.CurrentPrefs()
+	exitNodeID := prefs.ExitNodeID()
+	var hidden []key.NodePublic
 	for _, p := range b.netMap.Peers {
+		if b.peerHiddenLocked(p) {
+			hidden = append(hidden, p.Key())
+			continue
+		}
 		var lastSeen time.Time
 		if p.LastSeen() != nil {
 			lastSeen = *p.LastSeen()
@@ -800,6 +966,7 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 			LastSeen:        lastSeen,
 			Online:          online != nil && *online,
 			ShareeNode:      p.Hostinfo().ShareeNode(),
+			LocallyBlocked:  !peerAllowed(prefs, p),
 			ExitNode:        p.StableID() != "" && p.StableID() == exitNodeID,
 			SSH_HostKeys:    p.Hostinfo().SSH_HostKeys().AsSlice(),
 			Location:        p.Hostinfo().Location(),
@@ -815,6 +982,15 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 		}
 		sb.AddPeer(p.Key(), ps)
 	}
+	if len(hidden) > 0 {
+		// The engine may have added hidden peers it has WireGuard
+		// state for; drop them too.
+		sb.MutateStatus(func(s *ipnstate.Status) {
+			for _, k := range hidden {
+				delete(s.Peer, k)
+			}
+		})
+	}
 }
 
 // peerStatusFromNode copies fields that exist in the Node struct for
@@ -828,6 +1004,10 @@ func peerStatusFromNode(ps *ipnstate.PeerStatus, n tailcfg.NodeView) {
 		v := n.Tags()
 		ps.Tags = &v
 	}
+	if n.AllowedIPs().Len() != 0 {
+		v := n.AllowedIPs()
+		ps.AllowedIPs = &v
+	}
 	if n.PrimaryRoutes().Len() != 0 {
 		v := n.PrimaryRoutes()
 		ps.PrimaryRoutes = &v
@@ -846,9 +1026,34 @@ func peerStatusFromNode(ps *ipnstate.PeerStatus, n tailcfg.NodeView) {
 // If the IP address is a Tailscale IP, the provided port may be 0.
 // If ok == true, n and u are valid.
 func (b *LocalBackend) WhoIs(ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
-	var zero tailcfg.NodeView
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	return b.whoIsLocked(ipp)
+}
+
+// WhoIsBatch is like WhoIs, for many addresses at once, all looked up in
+// the same netmap. The result for ipps[i] is at index i, and is nil if
+// there's no match. Unlike WhoIs, it includes the peer capabilities.
+func (b *LocalBackend) WhoIsBatch(ipps []netip.AddrPort) []*apitype.WhoIsResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ret := make([]*apitype.WhoIsResponse, len(ipps))
+	for i, ipp := range ipps {
+		n, u, ok := b.whoIsLocked(ipp)
+		if !ok {
+			continue
+		}
+		ret[i] = &apitype.WhoIsResponse{
+			Node:        n.AsStruct(),
+			UserProfile: &u,
+			CapMap:      b.peerCapsLocked(ipp.Addr()),
+		}
+	}
+	return ret
+}
+
+func (b *LocalBackend) whoIsLocked(ipp netip.AddrPort) (n tailcfg.NodeView, u tailcfg.UserProfile, ok bool) {
+	var zero tailcfg.NodeView
 	n, ok = b.nodeByAddr[ipp.Addr()]
 	if !ok {
 		var ip netip.Addr
@@ -1004,7 +1209,8 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 	b.mu.Lock()
 
 	prefsChanged := false
-	prefs := b.pm.CurrentPrefs().AsStruct()
+	oldPrefs := b.pm.CurrentPrefs()
+	prefs := oldPrefs.AsStruct()
 	netMap := b.netMap
 	interact := b.interact
 
@@ -1047,6 +1253,7 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 		if err := b.pm.SetPrefs(prefs.View()); err != nil {
 			b.logf("Failed to save new controlclient state: %v", err)
 		}
+		b.notePrefsChangedLocked(oldPrefs, ipn.PrefSourceControl)
 	}
 	// initTKALocked is dependent on CurrentProfile.ID, which is initialized
 	// (for new profiles) on the first call to b.pm.SetPrefs.
@@ -1093,11 +1300,13 @@ func (b *LocalBackend) SetControlClientStatus(c controlclient.Client, st control
 			health.SetLocalLogConfigHealth(errors.New(msg))
 			// Connecting to this tailnet without logging is forbidden; boot us outta here.
 			b.mu.Lock()
+			oldPrefs := b.pm.CurrentPrefs()
 			prefs.WantRunning = false
 			p := prefs.View()
 			if err := b.pm.SetPrefs(p); err != nil {
 				b.logf("Failed to save new controlclient state: %v", err)
 			}
+			b.notePrefsChangedLocked(oldPrefs, ipn.PrefSourceControl)
 			b.mu.Unlock()
 			b.send(ipn.Notify{ErrMessage: &msg, Prefs: &p})
 			return
@@ -1205,10 +1414,63 @@ func (b *LocalBackend) setWgengineStatus(s *wgengine.Status, err error) {
 		}
 		b.stateMachine()
 	}
+	if needUpdateEndpoints {
+		b.savePortMapLease()
+	}
 	b.broadcastStatusChanged()
 	b.send(ipn.Notify{Engine: &es})
 }
 
+// restorePortMapLease restores the port mapping lease saved by
+// savePortMapLease, if any, so that the same external port can be renewed
+// and announced right away after a restart.
+func (b *LocalBackend) restorePortMapLease() {
+	mc, err := b.magicConn()
+	if err != nil {
+		return
+	}
+	j, err := b.store.ReadState(ipn.PortMapLeaseStateKey)
+	if err != nil {
+		return
+	}
+	var l portmapper.Lease
+	if err := json.Unmarshal(j, &l); err != nil {
+		b.logf("invalid port mapping lease in store: %v", err)
+		return
+	}
+	b.mu.Lock()
+	b.lastPortMapLease = l
+	b.mu.Unlock()
+	mc.RestorePortMapLease(l)
+}
+
+// savePortMapLease writes magicsock's current port mapping lease, if any,
+// to the store.
+func (b *LocalBackend) savePortMapLease() {
+	mc, err := b.magicConn()
+	if err != nil {
+		return
+	}
+	l, ok := mc.PortMapLease()
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	if l == b.lastPortMapLease {
+		b.mu.Unlock()
+		return
+	}
+	b.lastPortMapLease = l
+	b.mu.Unlock()
+	j, err := json.Marshal(l)
+	if err != nil {
+		return
+	}
+	if err := ipn.WriteState(b.store, ipn.PortMapLeaseStateKey, j); err != nil {
+		b.logf("failed to save port mapping lease: %v", err)
+	}
+}
+
 func (b *LocalBackend) broadcastStatusChanged() {
 	// The sync.Cond docs say: "It is allowed but not required for the caller to hold c.L during the call."
 	// In this particular case, we must acquire b.statusLock. Otherwise we might broadcast before
@@ -1320,6 +1582,10 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	}
 
 	if opts.UpdatePrefs != nil {
+		if err := b.checkPrefsAllowedByConfigLocked(opts.UpdatePrefs); err != nil {
+			b.mu.Unlock()
+			return err
+		}
 		if err := b.checkPrefsLocked(opts.UpdatePrefs); err != nil {
 			b.mu.Unlock()
 			return err
@@ -1330,6 +1596,14 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 			return err
 		}
 	}
+	if opts.AuthKey == "" && b.state != ipn.Running {
+		authKey, err := b.authKeyFromConfigLocked()
+		if err != nil {
+			b.mu.Unlock()
+			return err
+		}
+		opts.AuthKey = authKey
+	}
 	profileID := b.pm.CurrentProfile().ID
 
 	// The iOS client sends a "Start" whenever its UI screen comes
@@ -1387,6 +1661,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		if err := b.pm.SetPrefs(pv); err != nil {
 			b.logf("failed to save UpdatePrefs state: %v", err)
 		}
+		b.notePrefsChangedLocked(oldPrefs, cmpx.Or(opts.PrefSource, ipn.PrefSourceLocalAPI))
 		b.setAtomicValuesFromPrefsLocked(pv)
 	}
 
@@ -1415,6 +1690,8 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	b.updateFilterLocked(nil, ipn.PrefsView{})
 	b.mu.Unlock()
 
+	b.portMapLeaseOnce.Do(b.restorePortMapLease)
+
 	if b.portpoll != nil {
 		b.portpollOnce.Do(func() {
 			go b.readPoller()
@@ -1530,7 +1807,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	return nil
 }
 
-var warnInvalidUnsignedNodes = health.NewWarnable()
+var warnInvalidUnsignedNodes = health.NewWarnable(health.WithCode("invalid-packet-filter"), health.WithSeverity(health.SeverityHigh), health.WithSubsystem(health.SysTKA))
 
 // updateFilterLocked updates the packet filter in wgengine based on the
 // given netMap and user preferences.
@@ -1554,13 +1831,13 @@ func (b *LocalBackend) updateFilterLocked(netMap *netmap.NetworkMap, prefs ipn.P
 	logNetsB.AddPrefix(tsaddr.CGNATRange())
 	logNetsB.AddPrefix(tsaddr.TailscaleULARange())
 	logNetsB.RemovePrefix(tsaddr.ChromeOSVMRange())
+	packetFilter = b.activePacketFilterLocked(netMap)
 	if haveNetmap {
+		packetFilter = restrictMatchesToAllowedPeers(packetFilter, netMap.Peers, prefs)
 		addrs = netMap.Addresses
 		for _, p := range addrs {
 			localNetsB.AddPrefix(p)
 		}
-		packetFilter = netMap.PacketFilter
-
 		if packetFilterPermitsUnlockedNodes(netMap.Peers, packetFilter) {
 			err := errors.New("server sent invalid packet filter permitting traffic to unlocked nodes; rejecting all packets for safety")
 			warnInvalidUnsignedNodes.Set(err)
@@ -1684,6 +1961,25 @@ func (b *LocalBackend) setFilter(f *filter.Filter) {
 	b.e.SetFilter(f)
 }
 
+// DebugPacketFilterLatency times n rule lookups in the current packet
+// filter and reports their latency percentiles.
+func (b *LocalBackend) DebugPacketFilterLatency(n int) (*ipnstate.DebugPacketFilterLatencyReport, error) {
+	f := b.filterAtomic.Load()
+	if f == nil {
+		return nil, errors.New("no packet filter")
+	}
+	rep := new(ipnstate.DebugPacketFilterLatencyReport)
+	rep.Rules, rep.SrcPrefixes, rep.IndexedRules = f.RuleStats()
+	lat := f.LookupLatencies(n)
+	if len(lat) == 0 {
+		return rep, nil
+	}
+	pct := func(p int) time.Duration { return lat[(len(lat)-1)*p/100] }
+	rep.Lookups = len(lat)
+	rep.P50, rep.P90, rep.P99, rep.Max = pct(50), pct(90), pct(99), lat[len(lat)-1]
+	return rep, nil
+}
+
 var removeFromDefaultRoute = []netip.Prefix{
 	// RFC1918 LAN ranges
 	netip.MustParsePrefix("192.168.0.0/16"),
@@ -2188,6 +2484,9 @@ func (b *LocalBackend) tellClientToBrowseToURL(url string) {
 // a non-nil ClientVersion message.
 func (b *LocalBackend) onClientVersion(v *tailcfg.ClientVersion) {
 	b.mu.Lock()
+	if b.lastClientVersion == nil || b.lastClientVersion.LatestVersion != v.LatestVersion {
+		b.clientVersionSeen = b.clock.Now()
+	}
 	b.lastClientVersion = v
 	b.mu.Unlock()
 	switch runtime.GOOS {
@@ -2363,10 +2662,44 @@ func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 		b.lastServeConfJSON = mem.B(nil)
 		b.serveConfig = ipn.ServeConfigView{}
 	} else {
-		filtered := tsaddr.FilterPrefixesCopy(p.AdvertiseRoutes(), tsaddr.IsViaPrefix)
+		var filtered []netip.Prefix
+		for _, r := range advertisedRoutes(p) {
+			if tsaddr.IsViaPrefix(r) || tsaddr.IsNAT64Prefix(r) {
+				filtered = append(filtered, r)
+			}
+		}
 		b.containsViaIPFuncAtomic.Store(tsaddr.NewContainsIPFunc(filtered))
 		b.setTCPPortsInterceptedFromNetmapAndPrefsLocked(p)
 	}
+	b.setQoSFromNetmapAndPrefsLocked(p)
+	b.setRelayStatsFromNetmapAndPrefsLocked(p)
+	if p.Valid() {
+		b.bandwidth.setQuota(p.BandwidthQuota(), b.clock.Now())
+		b.netcheckHistory.setHours(p.NetcheckHistoryHours(), b.clock.Now())
+	}
+	b.setMetricsServerFromPrefsLocked(p)
+	b.setProxyFromPrefsLocked(p)
+	b.setExitNodePolicyFromPrefsLocked(p)
+	b.setKeepHotFromPrefsLocked(p)
+	b.setIPForwardingFromPrefsLocked(p)
+	b.setNAT64FromPrefsLocked(p)
+	b.setStaticEndpointsFromPrefsLocked(p)
+}
+
+// setStaticEndpointsFromPrefsLocked gives magicsock the public endpoints
+// set by the AdvertiseEndpoints pref.
+//
+// b.mu must be held.
+func (b *LocalBackend) setStaticEndpointsFromPrefsLocked(p ipn.PrefsView) {
+	mc, ok := b.sys.MagicSock.GetOK()
+	if !ok {
+		return
+	}
+	var eps []netip.AddrPort
+	if p.Valid() {
+		eps = p.AdvertiseEndpoints().AsSlice()
+	}
+	mc.SetStaticEndpoints(eps)
 }
 
 // State returns the backend state machine's current state.
@@ -2634,9 +2967,87 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkFunnelEnabledLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := checkDNSRoutesPrefs(p); err != nil {
+		errs = append(errs, err)
+	}
+	if p.RelayDailyLimitMB < 0 {
+		errs = append(errs, errors.New("relay daily limit must not be negative"))
+	}
+	if p.MetricsPort < 0 || p.MetricsPort > 65535 {
+		errs = append(errs, fmt.Errorf("invalid metrics port %d", p.MetricsPort))
+	}
+	if err := checkProxyURLHasNoCredentials(p.Proxy.URL); err != nil {
+		errs = append(errs, err)
+	}
+	if c := proxyConfigFromPrefs(p.Proxy, ""); c != nil {
+		if err := tshttpproxy.CheckConfig(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, r := range p.ExitNodePolicy {
+		if err := r.Check(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := p.NAT64.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := p.BandwidthQuota.Check(); err != nil {
+		errs = append(errs, err)
+	}
+	if p.NetcheckHistoryHours < 0 || p.NetcheckHistoryHours > ipn.MaxNetcheckHistoryHours {
+		errs = append(errs, fmt.Errorf("NetcheckHistoryHours must be between 0 and %d", ipn.MaxNetcheckHistoryHours))
+	}
+	if p.KeepHotIntervalSeconds != 0 && (p.KeepHotIntervalSeconds < ipn.MinKeepHotIntervalSeconds || p.KeepHotIntervalSeconds > ipn.MaxKeepHotIntervalSeconds) {
+		errs = append(errs, fmt.Errorf("KeepHotIntervalSeconds must be between %d and %d", ipn.MinKeepHotIntervalSeconds, ipn.MaxKeepHotIntervalSeconds))
+	}
+	if err := updatePolicy(p.AutoUpdate).Check(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, ep := range p.AdvertiseEndpoints {
+		if !ep.IsValid() || ep.Port() == 0 || ep.Addr().IsUnspecified() {
+			errs = append(errs, fmt.Errorf("invalid endpoint %v to advertise", ep))
+		}
+	}
 	return multierr.New(errs...)
 }
 
+// checkDNSRoutesPrefs checks that p.DNSRoutes has valid DNS suffixes and
+// resolver addresses.
+func checkDNSRoutesPrefs(p *ipn.Prefs) error {
+	for suffix, resolvers := range p.DNSRoutes {
+		if _, err := dnsname.ToFQDN(suffix); err != nil {
+			return fmt.Errorf("invalid DNS route suffix %q: %w", suffix, err)
+		}
+		if len(resolvers) == 0 {
+			return fmt.Errorf("DNS route %q has no resolvers", suffix)
+		}
+		for _, r := range resolvers {
+			if !validDNSRouteResolver(r) {
+				return fmt.Errorf("invalid resolver %q for DNS route %q; want IP, IP:port, https:// URL, or tls:// address", r, suffix)
+			}
+		}
+	}
+	return nil
+}
+
+// validDNSRouteResolver reports whether addr is a valid resolver address
+// for a local DNS route.
+func validDNSRouteResolver(addr string) bool {
+	if strings.HasPrefix(addr, "https://") {
+		_, err := url.Parse(addr)
+		return err == nil
+	}
+	if hp, ok := strings.CutPrefix(addr, "tls://"); ok {
+		return hp != ""
+	}
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddrPort(addr)
+	return err == nil
+}
+
 func (b *LocalBackend) checkSSHPrefsLocked(p *ipn.Prefs) error {
 	if !p.RunSSH {
 		return nil
@@ -2725,8 +3136,20 @@ func (b *LocalBackend) checkFunnelEnabledLocked(p *ipn.Prefs) error {
 	return nil
 }
 
+// EditPrefs applies the changes in mp to the current prefs, attributing
+// them to ipn.PrefSourceLocalAPI. See EditPrefsAs.
 func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
+	return b.EditPrefsAs(mp, ipn.PrefSourceLocalAPI)
+}
+
+// EditPrefsAs applies the changes in mp to the current prefs, recording src
+// as the source of the prefs that change.
+func (b *LocalBackend) EditPrefsAs(mp *ipn.MaskedPrefs, src ipn.PrefSource) (ipn.PrefsView, error) {
 	b.mu.Lock()
+	if err := b.checkEditAllowedByConfigLocked(mp); err != nil {
+		b.mu.Unlock()
+		return ipn.PrefsView{}, err
+	}
 	if mp.EggSet {
 		mp.EggSet = false
 		b.egg = true
@@ -2749,8 +3172,12 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 		b.mu.Unlock()
 		return stripKeysFromPrefs(p0), nil
 	}
+	if p1.WantRunning != p0.WantRunning() && b.wantRunningSched != nil {
+		b.logf("EditPrefs: WantRunning changed; canceling WantRunning schedule")
+		b.setWantRunningScheduleLocked(nil)
+	}
 	b.logf("EditPrefs: %v", mp.Pretty())
-	newPrefs := b.setPrefsLockedOnEntry("EditPrefs", p1) // does a b.mu.Unlock
+	newPrefs := b.setPrefsLockedOnEntry("EditPrefs", src, p1) // does a b.mu.Unlock
 
 	// Note: don't perform any actions for the new prefs here. Not
 	// every prefs change goes through EditPrefs. Put your actions
@@ -2784,7 +3211,7 @@ func (b *LocalBackend) SetPrefs(newp *ipn.Prefs) {
 		panic("SetPrefs got nil prefs")
 	}
 	b.mu.Lock()
-	b.setPrefsLockedOnEntry("SetPrefs", newp)
+	b.setPrefsLockedOnEntry("SetPrefs", ipn.PrefSourceLocalAPI, newp)
 }
 
 // wantIngressLocked reports whether this node has ingress configured. This bool
@@ -2803,8 +3230,9 @@ func (b *LocalBackend) wantIngressLocked() bool {
 
 // setPrefsLockedOnEntry requires b.mu be held to call it, but it
 // unlocks b.mu when done. newp ownership passes to this function.
+// The prefs that change are attributed to src.
 // It returns a readonly copy of the new prefs.
-func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn.PrefsView {
+func (b *LocalBackend) setPrefsLockedOnEntry(caller string, src ipn.PrefSource, newp *ipn.Prefs) ipn.PrefsView {
 	netMap := b.netMap
 	b.setAtomicValuesFromPrefsLocked(newp.View())
 
@@ -2856,6 +3284,7 @@ func (b *LocalBackend) setPrefsLockedOnEntry(caller string, newp *ipn.Prefs) ipn
 	if err := b.pm.SetPrefs(prefs); err != nil {
 		b.logf("failed to save new controlclient state: %v", err)
 	}
+	b.notePrefsChangedLocked(oldp, src)
 	b.lastProfileID = b.pm.CurrentProfile().ID
 	b.mu.Unlock()
 
@@ -2946,6 +3375,9 @@ func (b *LocalBackend) TCPHandlerForDst(src, dst netip.AddrPort) (handler func(c
 		opts = append(opts, ptr.To(tcpip.KeepaliveIdleOption(72*time.Hour)))
 		return b.handleSSHConn, opts
 	}
+	if port := b.metricsTailnetPort.Load(); port != 0 && uint32(dst.Port()) == port {
+		return b.handleMetricsConn, opts
+	}
 	if port, ok := b.GetPeerAPIPort(dst.Addr()); ok && dst.Port() == port {
 		return func(c net.Conn) error {
 			b.handlePeerAPIConn(src, dst, c)
@@ -3052,6 +3484,7 @@ func (b *LocalBackend) authReconfig() {
 	nm := b.netMap
 	hasPAC := b.prevIfState.HasPAC()
 	disableSubnetsIfPAC := hasCapability(nm, tailcfg.NodeAttrDisableSubnetsIfPAC)
+	otherVPNPolicy, otherVPNs := b.otherVPNPolicyLocked(prefs)
 	b.mu.Unlock()
 
 	if blocked {
@@ -3080,6 +3513,17 @@ func (b *LocalBackend) authReconfig() {
 			flags &^= netmap.AllowSubnetRoutes
 		}
 	}
+	switch otherVPNPolicy {
+	case ipn.OtherVPNYield, ipn.OtherVPNScope:
+		if prefs.ExitNodeID() != "" || prefs.ExitNodeIP().IsValid() {
+			b.logf("authReconfig: other VPN active, policy %q; not using exit node", otherVPNPolicy)
+			prefs = withoutExitNode(prefs)
+		}
+		if otherVPNPolicy == ipn.OtherVPNYield && flags&netmap.AllowSubnetRoutes != 0 {
+			b.logf("authReconfig: other VPN active, policy %q; disabling subnet routes", otherVPNPolicy)
+			flags &^= netmap.AllowSubnetRoutes
+		}
+	}
 
 	// Keep the dialer updated about whether we're supposed to use
 	// an exit node's DNS server (so SOCKS5/HTTP outgoing dials
@@ -3095,12 +3539,30 @@ func (b *LocalBackend) authReconfig() {
 		b.logf("wgcfg: %v", err)
 		return
 	}
+	if blocked := blockedPeerKeys(nm.Peers, prefs); len(blocked) > 0 {
+		// Leave out the peers that the AllowedPeers pref excludes,
+		// so that no traffic to or from them gets through WireGuard.
+		cfg.Peers = slices.DeleteFunc(cfg.Peers, func(p wgcfg.Peer) bool {
+			return blocked[p.PublicKey]
+		})
+	}
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(b.logf, version.OS())
+	if otherVPNPolicy != "" {
+		oneCGNATRoute = false
+	}
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
+	if otherVPNPolicy == ipn.OtherVPNYield {
+		rcfg.Routes = routesWithoutOverlaps(rcfg.Routes, otherVPNs)
+	}
 	dcfg := dnsConfigForNetmap(nm, prefs, b.logf, version.OS())
 
 	err = b.e.Reconfig(cfg, rcfg, dcfg)
+	if err == nil || err == wgengine.ErrNoChanges {
+		b.mu.Lock()
+		b.reconfiguredNM = nm
+		b.mu.Unlock()
+	}
 	if err == wgengine.ErrNoChanges {
 		return
 	}
@@ -3201,7 +3663,11 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, prefs ipn.PrefsView, logf logger.
 		dcfg.Hosts[fqdn] = ips
 	}
 	set(nm.Name, views.SliceOf(nm.Addresses))
+	now := time.Now()
 	for _, peer := range nm.Peers {
+		if peerIsStale(peer, prefs.HideStalePeersDays(), prefs.ExitNodeID(), now) {
+			continue
+		}
 		set(peer.Name(), peer.Addresses())
 	}
 	for _, rec := range nm.DNS.ExtraRecords {
@@ -3247,10 +3713,29 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, prefs ipn.PrefsView, logf logger.
 		}
 	}
 
+	// addLocalRoutes adds the split DNS routes configured on this device,
+	// replacing any routes from control for the same suffix.
+	addLocalRoutes := func() {
+		prefs.DNSRoutes().Range(func(suffix string, resolvers views.Slice[string]) bool {
+			fqdn, err := dnsname.ToFQDN(suffix)
+			if err != nil {
+				logf("[unexpected] invalid local DNS route suffix %q", suffix)
+				return true
+			}
+			rs := make([]*dnstype.Resolver, 0, resolvers.Len())
+			for i := range resolvers.LenIter() {
+				rs = append(rs, &dnstype.Resolver{Addr: resolvers.At(i)})
+			}
+			dcfg.Routes[fqdn] = rs
+			return true
+		})
+	}
+
 	// If we're using an exit node and that exit node is new enough (1.19.x+)
 	// to run a DoH DNS proxy, then send all our DNS traffic through it.
 	if dohURL, ok := exitNodeCanProxyDNS(nm, prefs.ExitNodeID()); ok {
 		addDefault([]*dnstype.Resolver{{Addr: dohURL}})
+		addLocalRoutes()
 		return dcfg
 	}
 
@@ -3275,6 +3760,7 @@ func dnsConfigForNetmap(nm *netmap.NetworkMap, prefs ipn.PrefsView, logf logger.
 			dcfg.Routes[fqdn] = append(dcfg.Routes[fqdn], r)
 		}
 	}
+	addLocalRoutes()
 
 	// Set FallbackResolvers as the default resolvers in the
 	// scenarios that can't handle a purely split-DNS config. See
@@ -3319,6 +3805,15 @@ func (b *LocalBackend) SetVarRoot(dir string) {
 	b.varRoot = dir
 }
 
+// SetPeerAPIDebugHandler sets the handler of the process's debug server,
+// serving paths starting with "/debug/", to serve over the PeerAPI to peers
+// that may debug this node.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetPeerAPIDebugHandler(h http.Handler) {
+	b.peerAPIDebugHandler = h
+}
+
 // SetLogFlusher sets a func to be called to flush log uploads.
 //
 // It should only be called before the LocalBackend is used.
@@ -3628,6 +4123,24 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 			}
 			b.logf("allowing exit node access to local IPs: %v", rs.LocalRoutes)
 		}
+		if excl := unmapIPPrefixes(prefs.ExitNodeExcludeRoutes().AsSlice()); len(excl) > 0 {
+			if runtime.GOOS == "darwin" && !version.IsSandboxedMacOS() {
+				// The utun router used by tailscaled on macOS ignores
+				// LocalRoutes, so leave the excluded routes out of the
+				// exit node's default routes instead.
+				rs.Routes = excludeFromDefaultRoutes(rs.Routes, excl)
+			} else {
+				rs.LocalRoutes = append(rs.LocalRoutes, excl...)
+			}
+			b.logf("excluding from exit node: %v", excl)
+		}
+		if uids := prefs.ExitNodeExcludeUIDs(); uids.Len() > 0 {
+			if runtime.GOOS == "linux" {
+				rs.BypassUIDs = uids.AsSlice()
+			} else {
+				b.logf("excluding users from the exit node is not supported on %s", runtime.GOOS)
+			}
+		}
 	}
 
 	if slices.ContainsFunc(rs.LocalAddrs, tsaddr.PrefixIs4) {
@@ -3637,6 +4150,25 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 	return rs
 }
 
+// excludeFromDefaultRoutes returns routes with its IPv4 and IPv6 default
+// routes replaced by the routes covering everything but excl.
+func excludeFromDefaultRoutes(routes, excl []netip.Prefix) []netip.Prefix {
+	var b netipx.IPSetBuilder
+	var ret []netip.Prefix
+	for _, r := range routes {
+		if r.Bits() == 0 {
+			b.AddPrefix(r)
+		} else {
+			ret = append(ret, r)
+		}
+	}
+	for _, r := range excl {
+		b.RemovePrefix(r)
+	}
+	s, _ := b.IPSet()
+	return append(ret, s.Prefixes()...)
+}
+
 func unmapIPPrefix(ipp netip.Prefix) netip.Prefix {
 	return netip.PrefixFrom(ipp.Addr().Unmap(), ipp.Bits())
 }
@@ -3655,7 +4187,7 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 	if h := prefs.Hostname(); h != "" {
 		hi.Hostname = h
 	}
-	hi.RoutableIPs = prefs.AdvertiseRoutes().AsSlice()
+	hi.RoutableIPs = advertisedRoutes(prefs)
 	hi.RequestTags = prefs.AdvertiseTags().AsSlice()
 	hi.ShieldsUp = prefs.ShieldsUp()
 
@@ -3944,8 +4476,8 @@ func (b *LocalBackend) ResetForClientDisconnect() {
 func (b *LocalBackend) ShouldRunSSH() bool { return b.sshAtomicBool.Load() && envknob.CanSSHD() }
 
 // ShouldHandleViaIP reports whether ip is an IPv6 address in the
-// Tailscale ULA's v6 "via" range embedding an IPv4 address to be forwarded to
-// by Tailscale.
+// Tailscale ULA's v6 "via" range, or in the NAT64 prefix, embedding an IPv4
+// address to be forwarded to by Tailscale.
 func (b *LocalBackend) ShouldHandleViaIP(ip netip.Addr) bool {
 	if f, ok := b.containsViaIPFuncAtomic.LoadOk(); ok {
 		return f(ip)
@@ -4016,6 +4548,8 @@ func (b *LocalBackend) assertClientLocked() {
 func (b *LocalBackend) setNetInfo(ni *tailcfg.NetInfo) {
 	b.mu.Lock()
 	cc := b.cc
+	b.lastNetInfo = ni
+	b.noteNetcheckReportLocked()
 	b.mu.Unlock()
 
 	if cc == nil {
@@ -4024,6 +4558,26 @@ func (b *LocalBackend) setNetInfo(ni *tailcfg.NetInfo) {
 	cc.SetNetInfo(ni)
 }
 
+// onPeerSilent is called by magicsock when the peer with node key k went
+// silent on its direct path mid-flow, to tell IPN bus watchers.
+func (b *LocalBackend) onPeerSilent(k key.NodePublic) {
+	b.mu.Lock()
+	nm := b.netMap
+	b.mu.Unlock()
+	if nm == nil {
+		return
+	}
+	for _, p := range nm.Peers {
+		if p.Key() == k {
+			b.send(ipn.Notify{PeerSilent: &ipn.PeerSilent{
+				ID:   p.StableID(),
+				Name: p.Name(),
+			}})
+			return
+		}
+	}
+}
+
 func hasCapability(nm *netmap.NetworkMap, cap string) bool {
 	if nm != nil && nm.SelfNode.Valid() {
 		return views.SliceContains(nm.SelfNode.Capabilities(), cap)
@@ -4040,7 +4594,9 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 	if nm != nil {
 		login = cmpx.Or(nm.UserProfiles[nm.User()].LoginName, "<missing-profile>")
 	}
+	oldNetMap := b.netMap
 	b.netMap = nm
+	b.rememberStandbyNetMapLocked(nm)
 	if login != b.activeLogin {
 		b.logf("active login: %v", login)
 		b.activeLogin = login
@@ -4068,38 +4624,10 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 	netns.SetDisableBindConnToInterface(hasCapability(nm, tailcfg.CapabilityDebugDisableBindConnToInterface))
 
 	b.setTCPPortsInterceptedFromNetmapAndPrefsLocked(b.pm.CurrentPrefs())
-	if nm == nil {
-		b.nodeByAddr = nil
-		return
-	}
-
-	// Update the nodeByAddr index.
-	if b.nodeByAddr == nil {
-		b.nodeByAddr = map[netip.Addr]tailcfg.NodeView{}
-	}
-	// First pass, mark everything unwanted.
-	for k := range b.nodeByAddr {
-		b.nodeByAddr[k] = tailcfg.NodeView{}
-	}
-	addNode := func(n tailcfg.NodeView) {
-		for i := range n.Addresses().LenIter() {
-			if ipp := n.Addresses().At(i); ipp.IsSingleIP() {
-				b.nodeByAddr[ipp.Addr()] = n
-			}
-		}
-	}
-	if nm.SelfNode.Valid() {
-		addNode(nm.SelfNode)
-	}
-	for _, p := range nm.Peers {
-		addNode(p)
-	}
-	// Third pass, actually delete the unwanted items.
-	for k, v := range b.nodeByAddr {
-		if !v.Valid() {
-			delete(b.nodeByAddr, k)
-		}
-	}
+	b.setQoSFromNetmapAndPrefsLocked(b.pm.CurrentPrefs())
+	b.setRelayStatsFromNetmapAndPrefsLocked(b.pm.CurrentPrefs())
+	b.maybeRunExitNodePolicyLocked(false)
+	b.nodeByAddr = updateNodeByAddr(b.nodeByAddr, oldNetMap, nm)
 }
 
 // setDebugLogsByCapabilityLocked sets debug logging based on the self node's
@@ -4126,6 +4654,14 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 		b.serveConfig = ipn.ServeConfigView{}
 		return
 	}
+	if b.conf != nil && b.conf.Parsed.ServeConfigTemp != nil {
+		// The config file's serve config replaces the one in the
+		// StateStore. Forget the last JSON so that the StateStore is
+		// re-read if the config file stops setting one.
+		b.lastServeConfJSON = mem.B(nil)
+		b.serveConfig = b.conf.Parsed.ServeConfigTemp.View()
+		return
+	}
 
 	confKey := ipn.ServeConfigKey(b.pm.CurrentProfile().ID)
 	// TODO(maisem,bradfitz): prevent reading the config from disk
@@ -4155,6 +4691,89 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 	b.serveConfig = conf.View()
 }
 
+// setQoSFromNetmapAndPrefsLocked configures the traffic prioritization of
+// the TUN device and magicsock as a function of the QoS pref and the peers
+// in b.netMap.
+//
+// b.mu must be held.
+func (b *LocalBackend) setQoSFromNetmapAndPrefsLocked(prefs ipn.PrefsView) {
+	if ms, ok := b.sys.MagicSock.GetOK(); ok {
+		ms.SetQoS(prefs.Valid() && prefs.QoS())
+	}
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return
+	}
+	if !prefs.Valid() || !prefs.QoS() {
+		tunWrap.SetQoS(nil)
+		return
+	}
+	qos := &tstun.QoSConfig{
+		InteractivePorts: tstun.DefaultInteractivePorts,
+	}
+	if nm := b.netMap; nm != nil {
+		// Taildrop sends files to peers' PeerAPI, so treat traffic to it
+		// as bulk.
+		for _, peer := range nm.Peers {
+			p4, p6 := peerAPIPorts(peer)
+			for i := range peer.Addresses().LenIter() {
+				pfx := peer.Addresses().At(i)
+				if !pfx.IsSingleIP() {
+					continue
+				}
+				port := p4
+				if pfx.Addr().Is6() {
+					port = p6
+				}
+				if port != 0 {
+					mak.Set(&qos.BulkDsts, netip.AddrPortFrom(pfx.Addr(), port), true)
+				}
+			}
+		}
+	}
+	tunWrap.SetQoS(qos)
+}
+
+// setRelayStatsFromNetmapAndPrefsLocked enables accounting of the traffic
+// forwarded on behalf of peers if prefs advertise routes, which makes this
+// node a subnet router or exit node.
+//
+// b.mu must be held.
+func (b *LocalBackend) setRelayStatsFromNetmapAndPrefsLocked(prefs ipn.PrefsView) {
+	tunWrap, ok := b.sys.Tun.GetOK()
+	if !ok {
+		return
+	}
+	if !prefs.Valid() || prefs.AdvertiseRoutes().Len() == 0 {
+		tunWrap.SetRelayStats(nil)
+		return
+	}
+	if nm := b.netMap; nm != nil {
+		b.relayStats.SetSelfAddrs(nm.Addresses)
+	}
+	b.relayStats.SetDailyLimit(uint64(prefs.RelayDailyLimitMB()) << 20)
+	tunWrap.SetRelayStats(&b.relayStats)
+}
+
+// RelayStats returns the traffic this node has forwarded on behalf of each
+// peer as a subnet router or exit node, for up to the last days days, most
+// recent first. If days is zero, all retained days are returned.
+func (b *LocalBackend) RelayStats(days int) []relaystats.DayUsage {
+	return b.relayStats.Usage(days)
+}
+
+// PeerTraffic returns the traffic this node has exchanged with each peer
+// over the last hour, in one-minute buckets.
+func (b *LocalBackend) PeerTraffic() []ipnstate.PeerTraffic {
+	return b.e.PeerTraffic()
+}
+
+// SubscribeConnStats calls fn with the per-connection statistics of the
+// node's traffic, per opts, until unsubscribe is called.
+func (b *LocalBackend) SubscribeConnStats(opts connstats.SubscribeOptions, fn func(connstats.Snapshot)) (unsubscribe func()) {
+	return b.e.SubscribeConnStats(opts, fn)
+}
+
 // setTCPPortsInterceptedFromNetmapAndPrefsLocked calls setTCPPortsIntercepted with
 // the ports that tailscaled should handle as a function of b.netMap and b.prefs.
 //
@@ -4165,6 +4784,9 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 	if prefs.Valid() && prefs.RunSSH() && envknob.CanSSHD() {
 		handlePorts = append(handlePorts, 22)
 	}
+	if prefs.Valid() && prefs.MetricsOnTailnet() && prefs.MetricsPort() != 0 {
+		handlePorts = append(handlePorts, uint16(prefs.MetricsPort()))
+	}
 
 	b.reloadServeConfigLocked(prefs)
 	if b.serveConfig.Valid() {
@@ -4201,41 +4823,62 @@ func (b *LocalBackend) setServeProxyHandlersLocked() {
 	if !b.serveConfig.Valid() {
 		return
 	}
-	var backends map[string]bool
+	var backends map[serveProxyKey]bool
+	var healthChecks map[serveHealthKey]bool
 	b.serveConfig.RangeOverWebs(func(_ ipn.HostPort, conf ipn.WebServerConfigView) (cont bool) {
 		conf.Handlers().Range(func(_ string, h ipn.HTTPHandlerView) (cont bool) {
-			backend := h.Proxy()
-			if backend == "" {
-				// Only create proxy handlers for servers with a proxy backend.
-				return true
-			}
-			mak.Set(&backends, backend, true)
-			if _, ok := b.serveProxyHandlers.Load(backend); ok {
-				return true
-			}
+			// Only create proxy handlers for servers with a proxy backend.
+			for _, backend := range h.ProxyBackends() {
+				if h.HealthChecked() {
+					hk := serveHealthKeyOf(h, backend)
+					mak.Set(&healthChecks, hk, true)
+					if _, ok := b.serveHealthCheckers.Load(hk); !ok {
+						b.serveHealthCheckers.Store(hk, b.startServeHealthChecker(hk))
+					}
+				}
 
-			b.logf("serve: creating a new proxy handler for %s", backend)
-			p, err := b.proxyHandlerForBackend(backend)
-			if err != nil {
-				// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
-				// in the CLI, so just log the error here.
-				b.logf("[unexpected] could not create proxy for %v: %s", backend, err)
-				return true
+				k := serveProxyKeyOf(h)
+				k.backend = backend
+				mak.Set(&backends, k, true)
+				if _, ok := b.serveProxyHandlers.Load(k); ok {
+					continue
+				}
+
+				b.logf("serve: creating a new proxy handler for %s", k.backend)
+				p, err := b.proxyHandlerForBackend(k)
+				if err != nil {
+					// The backend endpoint (h.Proxy) should have been validated by expandProxyTarget
+					// in the CLI, so just log the error here.
+					b.logf("[unexpected] could not create proxy for %v: %s", k.backend, err)
+					continue
+				}
+				b.serveProxyHandlers.Store(k, p)
 			}
-			b.serveProxyHandlers.Store(backend, p)
 			return true
 		})
 		return true
 	})
 
+	// Stop health checking backends that are no longer health checked.
+	b.serveHealthCheckers.Range(func(key, value any) bool {
+		if !healthChecks[key.(serveHealthKey)] {
+			value.(*serveHealthChecker).cancel()
+			b.serveHealthCheckers.Delete(key)
+		}
+		return true
+	})
+
 	// Clean up handlers for proxy backends that are no longer present
 	// in configuration.
 	b.serveProxyHandlers.Range(func(key, value any) bool {
-		backend := key.(string)
-		if !backends[backend] {
-			b.logf("serve: closing idle connections to %s", backend)
-			value.(*httputil.ReverseProxy).Transport.(*http.Transport).CloseIdleConnections()
-			b.serveProxyHandlers.Delete(backend)
+		k := key.(serveProxyKey)
+		if !backends[k] {
+			b.logf("serve: closing idle connections to %s", k.backend)
+			tr := value.(*httputil.ReverseProxy).Transport
+			if c, ok := tr.(interface{ CloseIdleConnections() }); ok {
+				c.CloseIdleConnections()
+			}
+			b.serveProxyHandlers.Delete(k)
 		}
 		return true
 	})
@@ -4268,6 +4911,17 @@ func (b *LocalBackend) OperatorUserID() string {
 	return u.Uid
 }
 
+// OperatorGrants returns the operator permissions granted to local users
+// and groups by the config file, if any.
+func (b *LocalBackend) OperatorGrants() []ipn.OperatorGrant {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conf == nil {
+		return nil
+	}
+	return b.conf.Parsed.Operators
+}
+
 // TestOnlyPublicKeys returns the current machine and node public
 // keys. Used in tests only to facilitate automated node authorization
 // in the test harness.
@@ -4536,10 +5190,18 @@ func (b *LocalBackend) CheckIPForwarding() error {
 	}
 
 	// TODO: let the caller pass in the ranges.
-	warn, err := netutil.CheckIPForwarding(tsaddr.ExitRoutes(), b.sys.NetMon.Get().InterfaceState())
+	routes := tsaddr.ExitRoutes()
+	state := b.sys.NetMon.Get().InterfaceState()
+	warn, err := netutil.CheckIPForwarding(routes, state)
 	if err != nil {
 		return err
 	}
+	if warn == nil {
+		warn, err = netutil.CheckReversePathFiltering(routes, state)
+		if err != nil {
+			return err
+		}
+	}
 	return warn
 }
 
@@ -4776,7 +5438,7 @@ func (b *LocalBackend) sshServerOrInit() (_ SSHServer, err error) {
 	return b.sshServer, nil
 }
 
-var warnSSHSELinux = health.NewWarnable()
+var warnSSHSELinux = health.NewWarnable(health.WithCode("ssh-selinux"), health.WithSeverity(health.SeverityLow), health.WithSubsystem(health.SysSSH), health.WithDocURL("https://tailscale.com/s/ssh-selinux"))
 
 func (b *LocalBackend) updateSELinuxHealthWarning() {
 	if hostinfo.IsSELinuxEnforcing() {
@@ -4935,7 +5597,11 @@ func (b *LocalBackend) SwitchProfile(profile ipn.ProfileID) error {
 		b.mu.Unlock()
 		return err
 	}
-	return b.resetForProfileChangeLockedOnEntry()
+	if err := b.resetForProfileChangeLockedOnEntry(); err != nil {
+		return err
+	}
+	b.applyStandbyNetMap(profile)
+	return nil
 }
 
 func (b *LocalBackend) initTKALocked() error {
@@ -4978,6 +5644,7 @@ func (b *LocalBackend) initTKALocked() error {
 			profile:   cp.ID,
 			authority: authority,
 			storage:   storage,
+			sigCache:  tka.NewSigCache(filepath.Join(chonkDir, tkaSigCacheFile)),
 		}
 		b.logf("tka initialized at head %x", authority.Head())
 	}
@@ -5022,6 +5689,7 @@ func (b *LocalBackend) DeleteProfile(p ipn.ProfileID) error {
 		}
 		return err
 	}
+	delete(b.standbyNetMaps, p)
 	if !needToRestart {
 		return nil
 	}
@@ -5068,6 +5736,7 @@ func (b *LocalBackend) ResetAuth() error {
 		b.mu.Unlock()
 		return err
 	}
+	b.standbyNetMaps = nil
 	return b.resetForProfileChangeLockedOnEntry()
 }
 
@@ -5135,6 +5804,63 @@ func (b *LocalBackend) GetPeerEndpointChanges(ctx context.Context, ip netip.Addr
 	return chs, nil
 }
 
+// GetPeerEndpointCandidates returns this node's endpoints and those of the
+// peer with the given Tailscale IP, with the state of path discovery for
+// each of the latter.
+func (b *LocalBackend) GetPeerEndpointCandidates(ctx context.Context, ip netip.Addr) (*ipnstate.EndpointCandidates, error) {
+	pip, ok := b.e.PeerForIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no matching peer")
+	}
+	if pip.IsSelf {
+		return nil, fmt.Errorf("%v is local Tailscale IP", ip)
+	}
+
+	mc, err := b.magicConn()
+	if err != nil {
+		return nil, fmt.Errorf("getting magicsock conn: %w", err)
+	}
+
+	cs, err := mc.EndpointCandidates(pip.Node)
+	if err != nil {
+		return nil, fmt.Errorf("getting endpoint candidates: %w", err)
+	}
+	return cs, nil
+}
+
+// SetDNSQueryLogConfig configures the query log of the DNS resolver.
+func (b *LocalBackend) SetDNSQueryLogConfig(cfg ipnstate.DNSQueryLogConfig) error {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return errors.New("no DNS manager")
+	}
+	dm.Resolver().SetQueryLogConfig(cfg)
+	return nil
+}
+
+// DNSQueryLog returns the DNS resolver's queries logged after the one
+// numbered since, waiting until ctx is done for one if there are none and
+// wait is true.
+func (b *LocalBackend) DNSQueryLog(ctx context.Context, since uint64, wait bool) (*ipnstate.DNSQueryLog, error) {
+	dm, ok := b.sys.DNSManager.GetOK()
+	if !ok {
+		return nil, errors.New("no DNS manager")
+	}
+	return dm.Resolver().QueryLog(ctx, since, wait), nil
+}
+
+// FirewallRuleset returns the firewall rules the router wants installed, in
+// format, when the host's firewall is managed externally. See
+// router.FirewallExporter.
+func (b *LocalBackend) FirewallRuleset(format string) (string, error) {
+	r, _ := b.sys.Router.GetOK()
+	fe, ok := r.(router.FirewallExporter)
+	if !ok {
+		return "", router.ErrNoFirewallRuleset
+	}
+	return fe.FirewallRuleset(format)
+}
+
 var breakTCPConns func() error
 
 func (b *LocalBackend) DebugBreakTCPConns() error {
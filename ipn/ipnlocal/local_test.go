@@ -5,27 +5,38 @@
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/netip"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"go4.org/netipx"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/dns/publicdns"
 	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/persist"
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
@@ -364,6 +375,26 @@ func TestPeerRoutes(t *testing.T) {
 
 }
 
+func TestExcludeFromDefaultRoutes(t *testing.T) {
+	pp := netip.MustParsePrefix
+	routes := []netip.Prefix{pp("100.101.102.103/32"), pp("0.0.0.0/0"), pp("::/0")}
+	got := excludeFromDefaultRoutes(routes, []netip.Prefix{pp("128.0.0.0/2"), pp("192.0.0.0/2"), pp("8000::/1")})
+	want := []netip.Prefix{
+		pp("100.101.102.103/32"),
+		pp("0.0.0.0/1"),
+		pp("::/1"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+
+	// Without a default route, there's nothing to exclude from.
+	got = excludeFromDefaultRoutes(routes[:1], []netip.Prefix{pp("100.101.102.0/24")})
+	if !reflect.DeepEqual(got, routes[:1]) {
+		t.Errorf("got %v; want %v", got, routes[:1])
+	}
+}
+
 func TestPeerAPIBase(t *testing.T) {
 	tests := []struct {
 		name string
@@ -879,3 +910,627 @@ func TestWatchNotificationsCallbacks(t *testing.T) {
 		t.Fatalf("unexpected number of watchers in new LocalBackend, want: 0 got: %v", len(b.notifyWatchers))
 	}
 }
+
+func TestPeerNotes(t *testing.T) {
+	b := &LocalBackend{store: new(mem.Store)}
+	mustSet := func(notes map[tailcfg.StableNodeID]ipn.PeerNote, replace bool) {
+		t.Helper()
+		if err := b.SetPeerNotes(notes, replace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(want map[tailcfg.StableNodeID]ipn.PeerNote) {
+		t.Helper()
+		got, err := b.PeerNotes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("PeerNotes = %v; want %v", got, want)
+		}
+	}
+
+	check(map[tailcfg.StableNodeID]ipn.PeerNote{})
+	mustSet(map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n1": {Nickname: "nas"},
+		"n2": {Nickname: "printer", Notes: "2nd floor"},
+	}, false)
+	mustSet(map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n2": {},
+		"n3": {Notes: "borrowed laptop"},
+	}, false)
+	check(map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n1": {Nickname: "nas"},
+		"n3": {Notes: "borrowed laptop"},
+	})
+	mustSet(map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n4": {Nickname: "router"},
+	}, true)
+	check(map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n4": {Nickname: "router"},
+	})
+
+	long := map[tailcfg.StableNodeID]ipn.PeerNote{
+		"n5": {Nickname: strings.Repeat("x", maxPeerNicknameLen+1)},
+	}
+	if err := b.SetPeerNotes(long, false); err == nil {
+		t.Error("SetPeerNotes with long nickname succeeded; want error")
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.conf")
+	writeConf := func(s string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(s), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConf(`{"version": "alpha0", "Hostname": "gw", "AdvertiseRoutes": ["10.0.0.0/24"]}`)
+	conf, err := conffile.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	sys.InitialConfig = conf
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.ctxCancel)
+	b.SetControlClientGetterForTesting(func(opts controlclient.Options) (controlclient.Client, error) {
+		return newClient(t, opts), nil
+	})
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	p := b.Prefs()
+	if p.Hostname() != "gw" || !p.WantRunning() || p.AdvertiseRoutes().Len() != 1 {
+		t.Errorf("prefs not applied from config file: %v", p.Pretty())
+	}
+
+	// Prefs set by the locked config file can't be changed, but others can.
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{Hostname: "other"}, HostnameSet: true}); err == nil || !strings.Contains(err.Error(), "Hostname") {
+		t.Errorf("EditPrefs(Hostname) err = %v; want conflict", err)
+	}
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{Hostname: "gw"}, HostnameSet: true}); err != nil {
+		t.Errorf("EditPrefs(same Hostname) = %v", err)
+	}
+	if _, err := b.EditPrefsAs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{ShieldsUp: true}, ShieldsUpSet: true}, ipn.PrefSourceCLI); err != nil {
+		t.Errorf("EditPrefs(ShieldsUp) = %v", err)
+	}
+	prov := b.PrefProvenance()
+	if got := prov["Hostname"].Source; got != ipn.PrefSourceConfigFile {
+		t.Errorf("Hostname source = %q; want %q", got, ipn.PrefSourceConfigFile)
+	}
+	if got := prov["ShieldsUp"].Source; got != ipn.PrefSourceCLI {
+		t.Errorf("ShieldsUp source = %q; want %q", got, ipn.PrefSourceCLI)
+	}
+	if _, ok := prov["RunSSH"]; ok {
+		t.Error("unchanged RunSSH has a source")
+	}
+
+	// Without Enabled, the config doesn't lock WantRunning, so "tailscale
+	// down" works.
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{WantRunning: false}, WantRunningSet: true}); err != nil {
+		t.Errorf("EditPrefs(WantRunning=false) = %v", err)
+	}
+	if changed, err := b.ReloadConfig(); err != nil || changed || b.Prefs().WantRunning() {
+		t.Errorf("ReloadConfig = %v, %v, WantRunning = %v; want false, nil, false", changed, err, b.Prefs().WantRunning())
+	}
+
+	if changed, err := b.ReloadConfig(); err != nil || changed {
+		t.Errorf("ReloadConfig of unchanged file = %v, %v; want false, nil", changed, err)
+	}
+
+	writeConf(`{"version": "alpha0", "Hostname": "gw2", "Locked": false}`)
+	if changed, err := b.ReloadConfig(); err != nil || !changed {
+		t.Fatalf("ReloadConfig = %v, %v; want true, nil", changed, err)
+	}
+	// AdvertiseRoutes, no longer in the file, is reset; ShieldsUp, which
+	// it never set, is kept.
+	p = b.Prefs()
+	if p.Hostname() != "gw2" || !p.ShieldsUp() || p.AdvertiseRoutes().Len() != 0 {
+		t.Errorf("prefs after reload: %v", p.Pretty())
+	}
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{Hostname: "other"}, HostnameSet: true}); err != nil {
+		t.Errorf("EditPrefs(Hostname) with unlocked config = %v", err)
+	}
+
+	// Concurrent reloads apply the file once.
+	writeConf(`{"version": "alpha0", "Hostname": "gw3", "Locked": false}`)
+	var nChanged atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if changed, err := b.ReloadConfig(); err != nil {
+				t.Errorf("ReloadConfig = %v", err)
+			} else if changed {
+				nChanged.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := nChanged.Load(); n != 1 || b.Prefs().Hostname() != "gw3" {
+		t.Errorf("%d concurrent reloads changed the config, Hostname = %q; want 1, %q", n, b.Prefs().Hostname(), "gw3")
+	}
+
+	// An invalid file is rejected, leaving the previous config in effect.
+	writeConf(`{"version": "alpha0", "NetfilterMode": "sometimes"}`)
+	if _, err := b.ReloadConfig(); err == nil {
+		t.Error("ReloadConfig of invalid file succeeded")
+	}
+	if p := b.Prefs(); p.Hostname() != "gw3" {
+		t.Errorf("Hostname = %q after failed reload; want %q", p.Hostname(), "gw3")
+	}
+
+	// A file that's invalid only in its backoff policies doesn't change
+	// the DoH providers either.
+	writeConf(`{"version": "alpha0", "Locked": false,
+		"DoHProviders": [{"DoH": "https://doh.example.com/dns-query", "IPs": ["192.0.2.53"]}],
+		"Backoff": {"Preset": "bogus"}}`)
+	if _, err := b.ReloadConfig(); err == nil {
+		t.Error("ReloadConfig with invalid backoff succeeded")
+	}
+	if _, _, ok := publicdns.DoHEndpointFromIP(netip.MustParseAddr("192.0.2.53")); ok {
+		t.Error("DoH providers changed by a rejected config")
+	}
+}
+
+func TestStandbyNetMap(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.ctxCancel)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	login := func(uid tailcfg.UserID, name string, nodeKey key.NodePrivate) (ipn.ProfileID, *netmap.NetworkMap) {
+		t.Helper()
+		b.pm.NewProfile()
+		p := ipn.NewPrefs()
+		p.WantRunning = true
+		p.Persist = &persist.Persist{
+			PrivateNodeKey: nodeKey,
+			NodeID:         tailcfg.StableNodeID(name),
+			UserProfile:    tailcfg.UserProfile{ID: uid, LoginName: name + "@example.com"},
+		}
+		if err := b.pm.SetPrefs(p.View()); err != nil {
+			t.Fatal(err)
+		}
+		nm := &netmap.NetworkMap{NodeKey: nodeKey.Public()}
+		b.setNetMapLocked(nm)
+		return b.pm.CurrentProfile().ID, nm
+	}
+	keyA := key.NewNode()
+	idA, nmA := login(1, "a", keyA)
+	idB, _ := login(2, "b", key.NewNode())
+
+	if got := b.standbyNetMapLocked(idA); got != nil {
+		t.Errorf("standby netmap for non-current profile = %p; want nil", got)
+	}
+	switchTo := func(id ipn.ProfileID) {
+		t.Helper()
+		if err := b.pm.SwitchProfile(id); err != nil {
+			t.Fatal(err)
+		}
+		b.setNetMapLocked(nil)
+	}
+	switchTo(idA)
+	if got := b.standbyNetMapLocked(idA); got != nmA {
+		t.Errorf("standby netmap = %p; want %p", got, nmA)
+	}
+
+	// The standby netmap is only used while the profile wants to run with
+	// the same, unexpired node key.
+	edit := func(f func(*ipn.Prefs)) {
+		t.Helper()
+		p := b.pm.CurrentPrefs().AsStruct()
+		f(p)
+		if err := b.pm.SetPrefs(p.View()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	edit(func(p *ipn.Prefs) { p.WantRunning = false })
+	if got := b.standbyNetMapLocked(idA); got != nil {
+		t.Errorf("standby netmap with WantRunning=false = %p; want nil", got)
+	}
+	edit(func(p *ipn.Prefs) { p.WantRunning = true })
+	nmA.Expiry = b.clock.Now().Add(-time.Minute)
+	if got := b.standbyNetMapLocked(idA); got != nil {
+		t.Errorf("standby netmap with expired key = %p; want nil", got)
+	}
+	nmA.Expiry = time.Time{}
+	edit(func(p *ipn.Prefs) { p.Persist.PrivateNodeKey = key.NewNode() })
+	if got := b.standbyNetMapLocked(idA); got != nil {
+		t.Errorf("standby netmap with new node key = %p; want nil", got)
+	}
+	edit(func(p *ipn.Prefs) { p.Persist.PrivateNodeKey = keyA })
+
+	// Deleting a profile discards its standby netmap.
+	switchTo(idB)
+	b.mu.Unlock()
+	if err := b.DeleteProfile(idA); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	if _, ok := b.standbyNetMaps[idB]; !ok || len(b.standbyNetMaps) != 1 {
+		t.Errorf("standby netmaps = %v; want only profile %v", b.standbyNetMaps, idB)
+	}
+}
+
+func TestOtherVPNPolicy(t *testing.T) {
+	b := &LocalBackend{logf: t.Logf}
+	pfx := netip.MustParsePrefix
+	wg := netmon.OtherVPN{Interface: "wg0", Mode: netmon.VPNFullTunnel, Prefixes: []netip.Prefix{pfx("10.8.0.2/24")}}
+	tun := netmon.OtherVPN{Interface: "tun1", Mode: netmon.VPNSplitOverlap, Prefixes: []netip.Prefix{pfx("100.96.0.5/16")}}
+	split := netmon.OtherVPN{Interface: "tun2", Mode: netmon.VPNSplit, Prefixes: []netip.Prefix{pfx("10.20.0.7/16")}}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.setOtherVPNsLocked([]netmon.OtherVPN{wg, split}) {
+		t.Error("setOtherVPNsLocked: want changed")
+	}
+	if b.setOtherVPNsLocked([]netmon.OtherVPN{split, wg}) {
+		t.Error("setOtherVPNsLocked with same conflicting VPNs: want unchanged")
+	}
+
+	for _, tt := range []struct {
+		policy string
+		want   string
+	}{
+		{"", ""},
+		{ipn.OtherVPNOverride, ""},
+		{ipn.OtherVPNYield, ipn.OtherVPNYield},
+		{ipn.OtherVPNScope, ipn.OtherVPNScope},
+	} {
+		got, vpns := b.otherVPNPolicyLocked((&ipn.Prefs{OtherVPNPolicy: tt.policy}).View())
+		if got != tt.want {
+			t.Errorf("policy %q: got %q; want %q", tt.policy, got, tt.want)
+		}
+		if got != "" && !reflect.DeepEqual(vpns, []netmon.OtherVPN{wg}) {
+			t.Errorf("policy %q: VPNs = %v; want [wg0]", tt.policy, vpns)
+		}
+	}
+
+	routes := []netip.Prefix{pfx("100.96.3.4/32"), pfx("100.100.1.1/32"), pfx("10.8.0.0/16"), pfx("192.168.5.0/24")}
+	got := routesWithoutOverlaps(routes, []netmon.OtherVPN{wg, tun})
+	if want := []netip.Prefix{pfx("100.100.1.1/32"), pfx("192.168.5.0/24")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("routesWithoutOverlaps = %v; want %v", got, want)
+	}
+
+	if !b.setOtherVPNsLocked([]netmon.OtherVPN{split}) {
+		t.Error("setOtherVPNsLocked without conflicts: want changed")
+	}
+	if got, _ := b.otherVPNPolicyLocked((&ipn.Prefs{OtherVPNPolicy: ipn.OtherVPNYield}).View()); got != "" {
+		t.Errorf("policy without conflicts = %q; want none", got)
+	}
+}
+
+func TestWantRunningSchedule(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	store := new(mem.Store)
+	sys.Set(store)
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.ctxCancel)
+	b.SetControlClientGetterForTesting(func(opts controlclient.Options) (controlclient.Client, error) {
+		return newClient(t, opts), nil
+	})
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	setWantRunning := func(want bool) {
+		t.Helper()
+		if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{WantRunning: want}, WantRunningSet: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitWantRunning := func(want bool) {
+		t.Helper()
+		if err := tstest.WaitFor(5*time.Second, func() error {
+			if got := b.Prefs().WantRunning(); got != want {
+				return fmt.Errorf("WantRunning = %v; want %v", got, want)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A timed connect: on now, and off again shortly.
+	if err := b.SetWantRunningSchedule(&ipn.WantRunningSchedule{WantRunning: true, Until: time.Now().Add(100 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Prefs().WantRunning() {
+		t.Error("WantRunning not set at start of window")
+	}
+	waitWantRunning(false)
+	if s := b.WantRunningSchedule(); s != nil {
+		t.Errorf("schedule after window = %+v; want nil", s)
+	}
+
+	// A delayed timed disconnect, saved to the store, and canceled by a
+	// manual change of WantRunning.
+	setWantRunning(true)
+	until := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := b.SetWantRunningSchedule(&ipn.WantRunningSchedule{WantRunning: false, After: time.Now().Add(200 * time.Millisecond), Until: until}); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Prefs().WantRunning() {
+		t.Error("WantRunning cleared before After")
+	}
+	waitWantRunning(false)
+	if s := b.WantRunningSchedule(); s == nil || !s.After.IsZero() || !s.Until.Equal(until) {
+		t.Errorf("schedule after After = %+v; want just Until %v", s, until)
+	}
+	j, err := store.ReadState(ipn.WantRunningScheduleStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved *ipn.WantRunningSchedule
+	if err := json.Unmarshal(j, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved == nil || !saved.Until.Equal(until) {
+		t.Errorf("saved schedule = %+v; want Until %v", saved, until)
+	}
+	setWantRunning(true)
+	if s := b.WantRunningSchedule(); s != nil {
+		t.Errorf("schedule after manual change = %+v; want nil", s)
+	}
+
+	for _, s := range []*ipn.WantRunningSchedule{
+		{WantRunning: true},
+		{WantRunning: true, Until: time.Now().Add(-time.Minute)},
+		{WantRunning: true, After: time.Now().Add(time.Hour), Until: time.Now().Add(time.Minute)},
+	} {
+		if err := b.SetWantRunningSchedule(s); err == nil {
+			t.Errorf("SetWantRunningSchedule(%+v) succeeded; want error", s)
+		}
+	}
+}
+
+func TestLocalAPITokens(t *testing.T) {
+	b := &LocalBackend{store: new(mem.Store), logf: t.Logf, clock: tstime.StdClock{}}
+
+	if _, _, err := b.CreateLocalAPIToken("bad", []string{"everything"}); err == nil {
+		t.Error("CreateLocalAPIToken with unknown scope succeeded; want error")
+	}
+	tok, info, err := b.CreateLocalAPIToken("monitoring", []string{ipn.LocalAPIScopeReadStatus})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(tok, localAPITokenPrefix+info.ID+"-") {
+		t.Errorf("token %q doesn't start with prefix and ID %q", tok, info.ID)
+	}
+	got, ok := b.CheckLocalAPIToken(tok)
+	if !ok || got.ID != info.ID || !got.HasScope(ipn.LocalAPIScopeReadStatus) || got.HasScope(ipn.LocalAPIScopeManagePrefs) {
+		t.Errorf("CheckLocalAPIToken = %+v, %v; want %+v", got, ok, info)
+	}
+	for _, bad := range []string{"", tok + "x", localAPITokenPrefix + info.ID + "-0000", "tslapi-nope"} {
+		if _, ok := b.CheckLocalAPIToken(bad); ok {
+			t.Errorf("CheckLocalAPIToken(%q) succeeded; want failure", bad)
+		}
+	}
+
+	// Only the hash of the secret is stored.
+	j, err := b.store.ReadState(ipn.LocalAPITokensStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secret, _ := strings.Cut(strings.TrimPrefix(tok, localAPITokenPrefix), "-")
+	if strings.Contains(string(j), secret) {
+		t.Errorf("store contains token secret: %s", j)
+	}
+
+	toks, err := b.LocalAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toks) != 1 || toks[0].Name != "monitoring" {
+		t.Errorf("LocalAPITokens = %+v; want just %q", toks, "monitoring")
+	}
+	if err := b.RevokeLocalAPIToken(info.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.CheckLocalAPIToken(tok); ok {
+		t.Error("revoked token still valid")
+	}
+	if err := b.RevokeLocalAPIToken(info.ID); err == nil {
+		t.Error("revoking unknown token succeeded; want error")
+	}
+}
+
+func TestExitNodePolicy(t *testing.T) {
+	var networks atomic.Pointer[[]string]
+	setNetwork := func(n ...string) { networks.Store(&n) }
+	setNetwork("HomeWiFi", "192.168.1.1")
+	tstest.Replace(t, &currentNetworks, func() []string { return *networks.Load() })
+
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.ctxCancel)
+	b.SetControlClientGetterForTesting(func(opts controlclient.Options) (controlclient.Client, error) {
+		return newClient(t, opts), nil
+	})
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	exitNode := func(id tailcfg.StableNodeID, name string, region int, country string) tailcfg.NodeView {
+		return (&tailcfg.Node{
+			StableID:     id,
+			Name:         name + ".tail-scale.ts.net.",
+			ComputedName: name,
+			DERP:         fmt.Sprintf("%s:%d", tailcfg.DerpMagicIP, region),
+			Addresses:    []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			AllowedIPs: []netip.Prefix{
+				netip.MustParsePrefix("100.64.0.1/32"),
+				netip.MustParsePrefix("0.0.0.0/0"),
+				netip.MustParsePrefix("::/0"),
+			},
+			Hostinfo: (&tailcfg.Hostinfo{Location: &tailcfg.Location{CountryCode: country}}).View(),
+		}).View()
+	}
+	b.mu.Lock()
+	b.netMap = &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			exitNode("nFRA", "fra", 1, "DE"),
+			exitNode("nNYC", "nyc", 2, "US"),
+			(&tailcfg.Node{StableID: "nLaptop", Name: "laptop.tail-scale.ts.net."}).View(),
+		},
+	}
+	b.lastNetInfo = &tailcfg.NetInfo{DERPLatency: map[string]float64{"1-v4": 0.05, "2-v4": 0.01}}
+	for _, tt := range []struct {
+		spec string
+		want tailcfg.StableNodeID
+	}{
+		{"none", ""},
+		{"auto", "nNYC"},
+		{"auto:de", "nFRA"},
+		{"fra", "nFRA"},
+		{"nyc.tail-scale.ts.net", "nNYC"},
+		{"nNYC", "nNYC"},
+	} {
+		if got, ok := b.resolveExitNodeLocked(tt.spec); !ok || got != tt.want {
+			t.Errorf("resolveExitNode(%q) = %q, %v; want %q", tt.spec, got, ok, tt.want)
+		}
+	}
+	for _, spec := range []string{"laptop", "auto:FR"} {
+		if got, ok := b.resolveExitNodeLocked(spec); ok {
+			t.Errorf("resolveExitNode(%q) = %q; want no exit node", spec, got)
+		}
+	}
+	b.mu.Unlock()
+
+	waitExitNode := func(want tailcfg.StableNodeID) {
+		t.Helper()
+		b.runExitNodePolicy(true)
+		if err := tstest.WaitFor(5*time.Second, func() error {
+			if got := b.Prefs().ExitNodeID(); got != want {
+				return fmt.Errorf("ExitNodeID = %q; want %q", got, want)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			ExitNodeID: "nFRA",
+			ExitNodePolicy: []ipn.ExitNodeRule{
+				{Network: "HomeWiFi", ExitNode: ipn.ExitNodeNone},
+				{ExitNode: ipn.ExitNodeAuto},
+			},
+		},
+		ExitNodeIDSet:     true,
+		ExitNodePolicySet: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	waitExitNode("")
+
+	setNetwork("CoffeeShop")
+	waitExitNode("nNYC")
+
+	// An exit node chosen by hand stays until the policy's choice
+	// changes.
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{ExitNodeID: "nFRA"}, ExitNodeIDSet: true}); err != nil {
+		t.Fatal(err)
+	}
+	waitExitNode("nFRA")
+
+	setNetwork("192.168.7.1", "HomeWiFi")
+	waitExitNode("")
+
+	if _, err := b.EditPrefs(&ipn.MaskedPrefs{
+		Prefs:             ipn.Prefs{ExitNodePolicy: []ipn.ExitNodeRule{{ExitNode: ipn.ExitNodeNone, Hours: "25:00-26:00"}}},
+		ExitNodePolicySet: true,
+	}); err == nil {
+		t.Error("EditPrefs accepted an invalid exit node policy")
+	}
+}
+
+func TestWhoIsBatch(t *testing.T) {
+	b := &LocalBackend{
+		netMap: &netmap.NetworkMap{
+			UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+				1: {LoginName: "someone@example.com"},
+			},
+		},
+		nodeByAddr: map[netip.Addr]tailcfg.NodeView{
+			netip.MustParseAddr("100.64.0.1"): (&tailcfg.Node{
+				ComputedName: "peer",
+				User:         1,
+			}).View(),
+			netip.MustParseAddr("100.64.0.2"): (&tailcfg.Node{
+				ComputedName: "no-profile",
+				User:         2,
+			}).View(),
+		},
+	}
+	got := b.WhoIsBatch([]netip.AddrPort{
+		netip.MustParseAddrPort("100.64.0.1:0"),
+		netip.MustParseAddrPort("100.64.0.9:0"),
+		netip.MustParseAddrPort("100.64.0.2:0"),
+		netip.MustParseAddrPort("100.64.0.1:0"),
+	})
+	if len(got) != 4 {
+		t.Fatalf("got %d results; want 4", len(got))
+	}
+	for i, want := range []string{"peer", "", "", "peer"} {
+		var name string
+		if got[i] != nil {
+			name = got[i].Node.ComputedName
+			if got[i].UserProfile.LoginName != "someone@example.com" {
+				t.Errorf("result %d: LoginName = %q", i, got[i].UserProfile.LoginName)
+			}
+		}
+		if name != want {
+			t.Errorf("result %d: node %q; want %q", i, name, want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netutil"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/multierr"
+)
+
+// metricsServer serves Prometheus metrics over HTTP on a localhost port.
+type metricsServer struct {
+	port int
+	srv  *http.Server
+}
+
+// setMetricsServerFromPrefsLocked starts, restarts or stops the metrics
+// server to match the MetricsPort and MetricsOnTailnet prefs.
+//
+// b.mu must be held.
+func (b *LocalBackend) setMetricsServerFromPrefsLocked(prefs ipn.PrefsView) {
+	var port int
+	if prefs.Valid() {
+		port = prefs.MetricsPort()
+	}
+	if port != 0 && prefs.MetricsOnTailnet() {
+		b.metricsTailnetPort.Store(uint32(port))
+	} else {
+		b.metricsTailnetPort.Store(0)
+	}
+
+	if b.metricsServer != nil && b.metricsServer.port == port {
+		return
+	}
+	b.closeMetricsServerLocked()
+	if port == 0 {
+		return
+	}
+
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(b.serveMetrics),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	var listening bool
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			b.logf("metrics: %v", err)
+			continue
+		}
+		listening = true
+		go srv.Serve(ln)
+	}
+	if !listening {
+		return
+	}
+	b.logf("metrics: serving on localhost:%d", port)
+	b.metricsServer = &metricsServer{port: port, srv: srv}
+}
+
+// closeMetricsServerLocked stops the localhost metrics server, if running.
+//
+// b.mu must be held.
+func (b *LocalBackend) closeMetricsServerLocked() {
+	if b.metricsServer == nil {
+		return
+	}
+	b.metricsServer.srv.Close()
+	b.metricsServer = nil
+}
+
+// handleMetricsConn serves metrics over c, a connection to MetricsPort on
+// one of this node's Tailscale IPs.
+func (b *LocalBackend) handleMetricsConn(c net.Conn) error {
+	hs := &http.Server{
+		Handler:           http.HandlerFunc(b.serveMetrics),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return hs.Serve(netutil.NewOneConnListener(c, nil))
+}
+
+func (b *LocalBackend) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	clientmetric.WritePrometheusExpositionFormat(w)
+	b.writeBackendMetrics(w)
+}
+
+// writeBackendMetrics writes metrics describing the state of the backend,
+// its WireGuard engine, DERP connections and health to w, in the Prometheus
+// text-based exposition format.
+func (b *LocalBackend) writeBackendMetrics(w io.Writer) {
+	b.mu.Lock()
+	state := b.state
+	es := b.engineStatus
+	var peers, homeDERP int
+	if b.netMap != nil {
+		peers = len(b.netMap.Peers)
+	}
+	if b.hostinfo != nil && b.hostinfo.NetInfo != nil {
+		homeDERP = b.hostinfo.NetInfo.PreferredDERP
+	}
+	b.mu.Unlock()
+
+	var healthProblems int
+	if err := health.OverallError(); err != nil {
+		healthProblems = 1
+		if me, ok := err.(multierr.Error); ok {
+			healthProblems = len(me.Errors())
+		}
+	}
+
+	gauge := func(name string, v int64) {
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", name, name, v)
+	}
+	fmt.Fprintf(w, "# TYPE tailscaled_backend_state gauge\n")
+	for s := ipn.NoState; s <= ipn.Running; s++ {
+		var v int
+		if s == state {
+			v = 1
+		}
+		fmt.Fprintf(w, "tailscaled_backend_state{state=%q} %d\n", s.String(), v)
+	}
+	gauge("tailscaled_peers", int64(peers))
+	gauge("tailscaled_peers_active", int64(es.NumLive))
+	gauge("tailscaled_wireguard_received_bytes", es.RBytes)
+	gauge("tailscaled_wireguard_sent_bytes", es.WBytes)
+	gauge("tailscaled_derp_connections", int64(es.LiveDERPs))
+	gauge("tailscaled_derp_home_region", int64(homeDERP))
+	gauge("tailscaled_health_problems", int64(healthProblems))
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestServeMetrics(t *testing.T) {
+	b := &LocalBackend{
+		logf:  t.Logf,
+		state: ipn.Running,
+		netMap: &netmap.NetworkMap{
+			Peers: nodeViews([]*tailcfg.Node{{ID: 1}, {ID: 2}}),
+		},
+		hostinfo:     &tailcfg.Hostinfo{NetInfo: &tailcfg.NetInfo{PreferredDERP: 3}},
+		engineStatus: ipn.EngineStatus{NumLive: 1, LiveDERPs: 1, RBytes: 10, WBytes: 20},
+	}
+
+	rec := httptest.NewRecorder()
+	b.serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`tailscaled_backend_state{state="Running"} 1`,
+		`tailscaled_backend_state{state="NeedsLogin"} 0`,
+		"tailscaled_peers 2\n",
+		"tailscaled_peers_active 1\n",
+		"tailscaled_wireguard_received_bytes 10\n",
+		"tailscaled_wireguard_sent_bytes 20\n",
+		"tailscaled_derp_connections 1\n",
+		"tailscaled_derp_home_region 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q; got:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	b.serveMetrics(rec, httptest.NewRequest("GET", "/other", nil))
+	if rec.Code != 404 {
+		t.Errorf("status for /other = %d; want 404", rec.Code)
+	}
+}
+
+func TestMetricsServerFromPrefs(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	b := &LocalBackend{logf: t.Logf}
+	b.mu.Lock()
+	b.setMetricsServerFromPrefsLocked((&ipn.Prefs{MetricsPort: port, MetricsOnTailnet: true}).View())
+	b.mu.Unlock()
+	t.Cleanup(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.closeMetricsServerLocked()
+	})
+	if got := b.metricsTailnetPort.Load(); got != uint32(port) {
+		t.Errorf("metricsTailnetPort = %d; want %d", got, port)
+	}
+
+	res, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "tailscaled_backend_state") {
+		t.Errorf("unexpected metrics body:\n%s", body)
+	}
+
+	b.mu.Lock()
+	b.setMetricsServerFromPrefsLocked((&ipn.Prefs{}).View())
+	b.mu.Unlock()
+	if b.metricsServer != nil || b.metricsTailnetPort.Load() != 0 {
+		t.Error("metrics server still enabled after clearing MetricsPort")
+	}
+	if _, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", port)); err == nil {
+		t.Error("metrics still served after clearing MetricsPort")
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn"
+	"tailscale.com/net/tsaddr"
+)
+
+// advertisedRoutes returns the routes the node advertises for prefs: its
+// AdvertiseRoutes followed by the IPv6 routes derived from them by the
+// NAT64 pref.
+func advertisedRoutes(prefs ipn.PrefsView) []netip.Prefix {
+	routes := prefs.AdvertiseRoutes().AsSlice()
+	return append(routes, prefs.NAT64().Routes(routes)...)
+}
+
+// setNAT64FromPrefsLocked updates the NAT64 routes whose names the peerapi
+// DNS server synthesizes AAAA records for.
+//
+// b.mu must be held.
+func (b *LocalBackend) setNAT64FromPrefsLocked(prefs ipn.PrefsView) {
+	var routes []netip.Prefix
+	if prefs.Valid() && prefs.NAT64().Enabled {
+		routes = tsaddr.FilterPrefixesCopy(prefs.AdvertiseRoutes(), func(p netip.Prefix) bool {
+			return p.Addr().Is4() && p.Bits() != 0
+		})
+	}
+	b.dns64RoutesAtomic.Store(routes)
+}
+
+// dns64Routes returns the IPv4 subnets for which the peerapi DNS server
+// synthesizes AAAA records, or nil if DNS64 is off.
+func (b *LocalBackend) dns64Routes() []netip.Prefix {
+	routes, _ := b.dns64RoutesAtomic.LoadOk()
+	return routes
+}
+
+// dns64 returns res, the response to the DNS query q, with AAAA records in
+// the NAT64 prefix added, if q is a AAAA query that res has no answers for
+// and the name has A records in routes. lookup resolves a query, and is
+// used for the A query. If there's nothing to synthesize, res is returned
+// unchanged.
+func dns64(q, res []byte, routes []netip.Prefix, lookup func(q []byte) ([]byte, error)) ([]byte, error) {
+	if len(routes) == 0 {
+		return res, nil
+	}
+	var qm dnsmessage.Message
+	if err := qm.Unpack(q); err != nil || len(qm.Questions) != 1 || qm.Questions[0].Type != dnsmessage.TypeAAAA {
+		return res, nil
+	}
+	var rm dnsmessage.Message
+	if err := rm.Unpack(res); err != nil || rm.RCode != dnsmessage.RCodeSuccess {
+		return res, nil
+	}
+	for _, a := range rm.Answers {
+		if a.Header.Type == dnsmessage.TypeAAAA {
+			return res, nil
+		}
+	}
+
+	aq := qm
+	aq.Questions = []dnsmessage.Question{qm.Questions[0]}
+	aq.Questions[0].Type = dnsmessage.TypeA
+	aqb, err := aq.Pack()
+	if err != nil {
+		return nil, err
+	}
+	ares, err := lookup(aqb)
+	if err != nil {
+		return nil, err
+	}
+	var am dnsmessage.Message
+	if err := am.Unpack(ares); err != nil {
+		return nil, err
+	}
+	var answers []dnsmessage.Resource
+	for _, a := range am.Answers {
+		switch body := a.Body.(type) {
+		case *dnsmessage.CNAMEResource:
+			answers = append(answers, a)
+		case *dnsmessage.AResource:
+			ip := netip.AddrFrom4(body.A)
+			if !containsAddr(routes, ip) {
+				continue
+			}
+			nat64 := tsaddr.NAT64Range().Addr().As16()
+			copy(nat64[12:], body.A[:])
+			h := a.Header
+			h.Type = dnsmessage.TypeAAAA
+			answers = append(answers, dnsmessage.Resource{
+				Header: h,
+				Body:   &dnsmessage.AAAAResource{AAAA: nat64},
+			})
+		}
+	}
+	if !hasAAAA(answers) {
+		return res, nil
+	}
+	rm.Answers = answers
+	rm.Authorities = nil
+	return rm.Pack()
+}
+
+func containsAddr(routes []netip.Prefix, ip netip.Addr) bool {
+	for _, r := range routes {
+		if r.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAAAA(rrs []dnsmessage.Resource) bool {
+	for _, rr := range rrs {
+		if rr.Header.Type == dnsmessage.TypeAAAA {
+			return true
+		}
+	}
+	return false
+}
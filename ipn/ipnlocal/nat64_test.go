@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestDNS64(t *testing.T) {
+	routes := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}
+	name := dnsmessage.MustNewName("db.corp.")
+
+	reply := func(q []byte, answers ...dnsmessage.Resource) []byte {
+		t.Helper()
+		var m dnsmessage.Message
+		if err := m.Unpack(q); err != nil {
+			t.Fatal(err)
+		}
+		m.Header.Response = true
+		m.Answers = answers
+		b, err := m.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	a := func(ip string) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: netip.MustParseAddr(ip).As4()},
+		}
+	}
+	aaaa := func(ip string) dnsmessage.Resource {
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeAAAA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AAAAResource{AAAA: netip.MustParseAddr(ip).As16()},
+		}
+	}
+	answerIPs := func(res []byte) []string {
+		t.Helper()
+		var m dnsmessage.Message
+		if err := m.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		var ips []string
+		for _, rr := range m.Answers {
+			if b, ok := rr.Body.(*dnsmessage.AAAAResource); ok {
+				ips = append(ips, netip.AddrFrom16(b.AAAA).String())
+			}
+		}
+		return ips
+	}
+	noLookup := func([]byte) ([]byte, error) { return nil, errors.New("unexpected lookup") }
+
+	aaaaQuery := dnsQueryForName("db.corp", "aaaa")
+
+	t.Run("synthesized", func(t *testing.T) {
+		lookup := func(q []byte) ([]byte, error) {
+			return reply(q, a("10.1.2.3"), a("192.168.0.1")), nil
+		}
+		res, err := dns64(aaaaQuery, reply(aaaaQuery), routes, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := answerIPs(res)
+		if len(got) != 1 || got[0] != "64:ff9b::a01:203" {
+			t.Errorf("got %v, want [64:ff9b::a01:203]", got)
+		}
+	})
+	t.Run("has-aaaa", func(t *testing.T) {
+		res := reply(aaaaQuery, aaaa("fd00::1"))
+		got, err := dns64(aaaaQuery, res, routes, noLookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(res) {
+			t.Error("response with AAAA records changed")
+		}
+	})
+	t.Run("outside-routes", func(t *testing.T) {
+		lookup := func(q []byte) ([]byte, error) {
+			return reply(q, a("192.168.0.1")), nil
+		}
+		res := reply(aaaaQuery)
+		got, err := dns64(aaaaQuery, res, routes, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(res) {
+			t.Error("response changed for A records outside routes")
+		}
+	})
+	t.Run("a-query", func(t *testing.T) {
+		q := dnsQueryForName("db.corp", "a")
+		res := reply(q)
+		got, err := dns64(q, res, routes, noLookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(res) {
+			t.Error("response to A query changed")
+		}
+	})
+	t.Run("disabled", func(t *testing.T) {
+		res := reply(aaaaQuery)
+		got, err := dns64(aaaaQuery, res, nil, noLookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != string(res) {
+			t.Error("response changed with DNS64 off")
+		}
+	})
+}
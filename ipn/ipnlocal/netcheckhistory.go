@@ -0,0 +1,324 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+const (
+	// netcheckSampleInterval is how often the latest netcheck report is
+	// recorded in the netcheck history, if enabled.
+	netcheckSampleInterval = 5 * time.Minute
+
+	// netcheckSaveInterval is how often the netcheck history is saved,
+	// if it changed.
+	netcheckSaveInterval = time.Hour
+
+	// netcheckSampleRegions is how many of the fastest DERP regions'
+	// latencies a netcheck sample records.
+	netcheckSampleRegions = 5
+
+	// netcheckHistoryFile is the name of the file in the var root the
+	// netcheck history is saved to.
+	netcheckHistoryFile = "netcheck-history.json"
+)
+
+// netcheckHistory is the history of netcheck samples recorded while the
+// NetcheckHistoryHours pref is set.
+//
+// The zero value is ready for use.
+type netcheckHistory struct {
+	loadOnce sync.Once
+
+	mu         sync.Mutex
+	hours      int
+	samples    []ipnstate.NetcheckSample // oldest first
+	lastReport *netcheck.Report          // report of the newest sample
+	dirty      bool                      // whether samples changed since last saved
+}
+
+// setHours sets how many hours of samples to retain, dropping older ones.
+// Zero stops recording and drops all samples.
+func (h *netcheckHistory) setHours(hours int, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hours == h.hours {
+		return
+	}
+	h.hours = hours
+	h.trimLocked(now)
+}
+
+// trimLocked drops the samples older than the retention period.
+//
+// h.mu must be held.
+func (h *netcheckHistory) trimLocked(now time.Time) {
+	cutoff := now.Add(-time.Duration(h.hours) * time.Hour)
+	i := 0
+	for i < len(h.samples) && (h.hours == 0 || h.samples[i].Time.Before(cutoff)) {
+		i++
+	}
+	if i > 0 {
+		h.samples = slices.Delete(h.samples, 0, i)
+		h.dirty = true
+	}
+}
+
+// add records a sample of report, unless recording is disabled or report
+// was already recorded.
+func (h *netcheckHistory) add(now time.Time, report *netcheck.Report) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hours == 0 || report == nil || report == h.lastReport {
+		return
+	}
+	h.lastReport = report
+	h.samples = append(h.samples, netcheckSampleOf(now, report))
+	h.dirty = true
+	h.trimLocked(now)
+}
+
+// netcheckSampleOf returns the netcheck sample of report at now.
+func netcheckSampleOf(now time.Time, report *netcheck.Report) ipnstate.NetcheckSample {
+	s := ipnstate.NetcheckSample{
+		Time:          now,
+		PreferredDERP: report.PreferredDERP,
+	}
+	regions := make([]int, 0, len(report.RegionLatency))
+	for id := range report.RegionLatency {
+		regions = append(regions, id)
+	}
+	slices.SortFunc(regions, func(a, b int) int {
+		return cmp.Compare(report.RegionLatency[a], report.RegionLatency[b])
+	})
+	if len(regions) > netcheckSampleRegions {
+		regions = regions[:netcheckSampleRegions]
+	}
+	if len(regions) > 0 {
+		s.DERPLatency = make(map[int]time.Duration, len(regions))
+		for _, id := range regions {
+			s.DERPLatency[id] = report.RegionLatency[id]
+		}
+	}
+	return s
+}
+
+// report returns the trends of the samples of the last hours hours, or of
+// all retained samples if hours is zero.
+func (h *netcheckHistory) report(now time.Time, hours int, dm *tailcfg.DERPMap) *ipnstate.NetcheckHistory {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trimLocked(now)
+
+	res := &ipnstate.NetcheckHistory{Hours: h.hours}
+	samples := h.samples
+	if hours != 0 {
+		res.Since = now.Add(-time.Duration(hours) * time.Hour)
+		i, _ := slices.BinarySearchFunc(samples, res.Since, func(s ipnstate.NetcheckSample, t time.Time) int {
+			return s.Time.Compare(t)
+		})
+		samples = samples[i:]
+	} else if len(samples) > 0 {
+		res.Since = samples[0].Time
+	}
+	res.Samples = len(samples)
+	if len(samples) == 0 {
+		return res
+	}
+
+	mid := res.Since.Add(now.Sub(res.Since) / 2)
+	type latencies struct{ all, early, late []time.Duration }
+	byRegion := map[int]*latencies{}
+	prevHome := samples[0].PreferredDERP
+	for _, s := range samples {
+		if s.PreferredDERP != prevHome {
+			res.HomeChanges = append(res.HomeChanges, ipnstate.HomeDERPChange{
+				Time: s.Time,
+				From: prevHome,
+				To:   s.PreferredDERP,
+			})
+			prevHome = s.PreferredDERP
+		}
+		for id, d := range s.DERPLatency {
+			l := byRegion[id]
+			if l == nil {
+				l = new(latencies)
+				byRegion[id] = l
+			}
+			l.all = append(l.all, d)
+			if s.Time.Before(mid) {
+				l.early = append(l.early, d)
+			} else {
+				l.late = append(l.late, d)
+			}
+		}
+	}
+	for id, l := range byRegion {
+		slices.Sort(l.all)
+		t := ipnstate.DERPRegionTrend{
+			RegionID:    id,
+			Samples:     len(l.all),
+			Min:         l.all[0],
+			Median:      median(l.all),
+			Max:         l.all[len(l.all)-1],
+			EarlyMedian: median(l.early),
+			LateMedian:  median(l.late),
+		}
+		if dm != nil && dm.Regions[id] != nil {
+			t.RegionCode = dm.Regions[id].RegionCode
+		}
+		res.Regions = append(res.Regions, t)
+	}
+	slices.SortFunc(res.Regions, func(a, b ipnstate.DERPRegionTrend) int {
+		if c := cmp.Compare(a.Median, b.Median); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.RegionID, b.RegionID)
+	})
+	return res
+}
+
+// median returns the median of ds, sorting it, or zero if it's empty.
+func median(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	slices.Sort(ds)
+	return ds[len(ds)/2]
+}
+
+// stateToSave returns the JSON samples to save, or nil if they haven't
+// changed since they were last returned.
+func (h *netcheckHistory) stateToSave() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.dirty {
+		return nil
+	}
+	j, err := json.Marshal(h.samples)
+	if err != nil {
+		return nil
+	}
+	h.dirty = false
+	return j
+}
+
+// restore restores the samples saved by stateToSave, ahead of any recorded
+// since, dropping those no longer retained at now.
+func (h *netcheckHistory) restore(j []byte, now time.Time) error {
+	var samples []ipnstate.NetcheckSample
+	if err := json.Unmarshal(j, &samples); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.samples = append(samples, h.samples...)
+	h.trimLocked(now)
+	return nil
+}
+
+// netcheckHistoryPath returns the path of the file the netcheck history is
+// saved to, or the empty string if there's no var root.
+func (b *LocalBackend) netcheckHistoryPath() string {
+	root := b.TailscaleVarRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, netcheckHistoryFile)
+}
+
+// loadNetcheckHistory restores the netcheck history saved by
+// saveNetcheckHistory the first time it's called.
+func (b *LocalBackend) loadNetcheckHistory() {
+	b.netcheckHistory.loadOnce.Do(func() {
+		path := b.netcheckHistoryPath()
+		if path == "" {
+			return
+		}
+		j, err := os.ReadFile(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				b.logf("netcheck history: %v", err)
+			}
+			return
+		}
+		if err := b.netcheckHistory.restore(j, b.clock.Now()); err != nil {
+			b.logf("invalid netcheck history in %s: %v", path, err)
+		}
+	})
+}
+
+// saveNetcheckHistory writes the netcheck history to the var root, if it
+// changed since it was last written, removing the file if it's empty.
+func (b *LocalBackend) saveNetcheckHistory() {
+	b.loadNetcheckHistory()
+	path := b.netcheckHistoryPath()
+	if path == "" {
+		return
+	}
+	j := b.netcheckHistory.stateToSave()
+	switch {
+	case j == nil:
+		return
+	case string(j) == "null" || string(j) == "[]":
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			b.logf("netcheck history: %v", err)
+		}
+	default:
+		if err := atomicfile.WriteFile(path, j, 0600); err != nil {
+			b.logf("failed to save netcheck history: %v", err)
+		}
+	}
+}
+
+// recordNetcheckHistory is a goroutine that records magicsock's latest
+// netcheck report in the netcheck history every netcheckSampleInterval,
+// saving it every netcheckSaveInterval, until b is shut down.
+func (b *LocalBackend) recordNetcheckHistory() {
+	ticker, tickerChannel := b.clock.NewTicker(netcheckSampleInterval)
+	defer ticker.Stop()
+	lastSave := b.clock.Now()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-tickerChannel:
+		}
+		b.loadNetcheckHistory()
+		if mc, err := b.magicConn(); err == nil {
+			b.netcheckHistory.add(b.clock.Now(), mc.LastNetcheckReport())
+		}
+		if now := b.clock.Now(); now.Sub(lastSave) >= netcheckSaveInterval {
+			b.saveNetcheckHistory()
+			lastSave = now
+		}
+	}
+}
+
+// NetcheckHistory returns the trends of the netcheck samples recorded in
+// the last hours hours, or in all retained samples if hours is zero.
+func (b *LocalBackend) NetcheckHistory(hours int) *ipnstate.NetcheckHistory {
+	b.loadNetcheckHistory()
+	b.mu.Lock()
+	var dm *tailcfg.DERPMap
+	if b.netMap != nil {
+		dm = b.netMap.DERPMap
+	}
+	b.mu.Unlock()
+	return b.netcheckHistory.report(b.clock.Now(), hours, dm)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netcheck"
+	"tailscale.com/tailcfg"
+)
+
+func TestNetcheckHistory(t *testing.T) {
+	const ms = time.Millisecond
+	start := time.Date(2023, 3, 20, 12, 0, 0, 0, time.UTC)
+	report := func(home int, latencies ...time.Duration) *netcheck.Report {
+		r := &netcheck.Report{PreferredDERP: home, RegionLatency: map[int]time.Duration{}}
+		for i, d := range latencies {
+			r.RegionLatency[i+1] = d
+		}
+		return r
+	}
+	dm := &tailcfg.DERPMap{Regions: map[int]*tailcfg.DERPRegion{
+		1: {RegionID: 1, RegionCode: "nyc"},
+		2: {RegionID: 2, RegionCode: "sfo"},
+	}}
+
+	var h netcheckHistory
+	h.add(start, report(1, 10*ms))
+	if got := h.report(start, 0, dm); got.Samples != 0 {
+		t.Fatalf("recorded %d samples while disabled", got.Samples)
+	}
+
+	h.setHours(2, start)
+	r1 := report(1, 10*ms, 50*ms)
+	h.add(start, r1)
+	h.add(start.Add(time.Minute), r1) // same report, not recorded again
+	h.add(start.Add(30*time.Minute), report(1, 20*ms, 50*ms))
+	h.add(start.Add(60*time.Minute), report(2, 80*ms, 40*ms))
+	h.add(start.Add(90*time.Minute), report(2, 90*ms, 40*ms, 1*ms, 2*ms, 3*ms, 4*ms))
+
+	now := start.Add(120 * time.Minute)
+	got := h.report(now, 0, dm)
+	want := &ipnstate.NetcheckHistory{
+		Hours:   2,
+		Since:   start,
+		Samples: 4,
+		Regions: []ipnstate.DERPRegionTrend{
+			{RegionID: 3, Samples: 1, Min: 1 * ms, Median: 1 * ms, Max: 1 * ms, LateMedian: 1 * ms},
+			{RegionID: 4, Samples: 1, Min: 2 * ms, Median: 2 * ms, Max: 2 * ms, LateMedian: 2 * ms},
+			{RegionID: 5, Samples: 1, Min: 3 * ms, Median: 3 * ms, Max: 3 * ms, LateMedian: 3 * ms},
+			{RegionID: 6, Samples: 1, Min: 4 * ms, Median: 4 * ms, Max: 4 * ms, LateMedian: 4 * ms},
+			// The last sample only has the five fastest regions, without
+			// region 1.
+			{RegionID: 1, RegionCode: "nyc", Samples: 3, Min: 10 * ms, Median: 20 * ms, Max: 80 * ms, EarlyMedian: 20 * ms, LateMedian: 80 * ms},
+			{RegionID: 2, RegionCode: "sfo", Samples: 4, Min: 40 * ms, Median: 50 * ms, Max: 50 * ms, EarlyMedian: 50 * ms, LateMedian: 40 * ms},
+		},
+		HomeChanges: []ipnstate.HomeDERPChange{
+			{Time: start.Add(60 * time.Minute), From: 1, To: 2},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("report:\n got %+v\nwant %+v", got, want)
+	}
+
+	if got := h.report(now, 1, dm); got.Samples != 2 || got.HomeChanges != nil || !got.Since.Equal(start.Add(60*time.Minute)) {
+		t.Errorf("last hour: got %d samples since %v, home changes %v; want 2 since %v, none", got.Samples, got.Since, got.HomeChanges, start.Add(60*time.Minute))
+	}
+
+	// Samples survive a save and restore, less those no longer retained.
+	j := h.stateToSave()
+	if j == nil {
+		t.Fatal("nothing to save")
+	}
+	if h.stateToSave() != nil {
+		t.Error("unchanged history saved again")
+	}
+	var h2 netcheckHistory
+	h2.setHours(2, now)
+	later := start.Add(151 * time.Minute)
+	if err := h2.restore(j, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := h2.report(later, 0, dm); got.Samples != 2 {
+		t.Errorf("after restore, got %d samples; want 2", got.Samples)
+	}
+
+	h2.setHours(0, now)
+	if got := h2.report(now, 0, dm); got.Samples != 0 || got.Hours != 0 {
+		t.Errorf("after disabling, got %d samples, %d hours; want none", got.Samples, got.Hours)
+	}
+}
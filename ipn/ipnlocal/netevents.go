@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"slices"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/netmon"
+)
+
+// WatchNetworkEvents registers ch to be sent network events until the
+// returned unregister func is called. Events are dropped if ch isn't
+// ready to receive them.
+func (b *LocalBackend) WatchNetworkEvents(ch chan<- ipnstate.NetworkEvent) (unregister func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.netEventWatchers.Add(ch)
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.netEventWatchers, h)
+	}
+}
+
+// CurrentNetworkEvent returns the current network state as a NetworkEvent,
+// with the result of the last captive portal check, if any.
+func (b *LocalBackend) CurrentNetworkEvent() ipnstate.NetworkEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ev := ipnstate.NetworkEvent{
+		Time: b.clock.Now(),
+		Link: b.linkStateLocked(&netmon.ChangeDelta{New: b.prevIfState}),
+	}
+	if r := b.lastPortalCheck; r != nil {
+		ev.CaptivePortal = r.CaptivePortal
+	}
+	return ev
+}
+
+// sendNetworkEventLocked sends ev, timestamped now, to the registered
+// network event watchers.
+//
+// b.mu must be held.
+func (b *LocalBackend) sendNetworkEventLocked(ev ipnstate.NetworkEvent) {
+	if len(b.netEventWatchers) == 0 {
+		return
+	}
+	ev.Time = b.clock.Now()
+	for _, ch := range b.netEventWatchers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// noteNetcheckReportLocked sends the result of the latest netcheck's
+// captive portal check to the network event watchers, if it did one.
+//
+// b.mu must be held.
+func (b *LocalBackend) noteNetcheckReportLocked() {
+	mc, err := b.magicConn()
+	if err != nil {
+		return
+	}
+	r := mc.LastNetcheckReport()
+	if r == nil || r == b.lastPortalCheck || r.CaptivePortal == "" {
+		return
+	}
+	b.lastPortalCheck = r
+	b.sendNetworkEventLocked(ipnstate.NetworkEvent{CaptivePortal: r.CaptivePortal})
+}
+
+// linkStateLocked summarizes the network state after delta.
+//
+// b.mu must be held.
+func (b *LocalBackend) linkStateLocked(delta *netmon.ChangeDelta) *ipnstate.LinkState {
+	st := delta.New
+	if st == nil {
+		return nil
+	}
+	ls := &ipnstate.LinkState{
+		Major:                 delta.Major,
+		TimeJumped:            delta.TimeJumped,
+		DefaultRouteInterface: st.DefaultRouteInterface,
+		HaveV4:                st.HaveV4,
+		HaveV6:                st.HaveV6,
+		Expensive:             st.IsExpensive,
+	}
+	tsIf := b.sys.NetMon.Get().TailscaleInterfaceName()
+	for name, iface := range st.Interface {
+		if name == tsIf || iface.Interface == nil || !iface.IsUp() || len(st.InterfaceIPs[name]) == 0 {
+			continue
+		}
+		ls.Interfaces = append(ls.Interfaces, name)
+	}
+	slices.Sort(ls.Interfaces)
+	return ls
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/netmon"
+	"tailscale.com/tsd"
+	"tailscale.com/tstest"
+	"tailscale.com/types/logid"
+	"tailscale.com/wgengine"
+)
+
+func TestNetworkEvents(t *testing.T) {
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+
+	up := func(name string) interfaces.Interface {
+		return interfaces.Interface{Interface: &net.Interface{Name: name, Flags: net.FlagUp}}
+	}
+	st := &interfaces.State{
+		Interface: map[string]interfaces.Interface{
+			"eth0":  up("eth0"),
+			"wlan0": up("wlan0"),
+			"down0": {Interface: &net.Interface{Name: "down0"}},
+			"noip0": up("noip0"),
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0":  {netip.MustParsePrefix("192.168.1.2/24")},
+			"wlan0": {netip.MustParsePrefix("10.0.0.2/24")},
+			"down0": {netip.MustParsePrefix("10.1.0.2/24")},
+		},
+		HaveV4:                true,
+		IsExpensive:           true,
+		DefaultRouteInterface: "wlan0",
+	}
+
+	evc := make(chan ipnstate.NetworkEvent, 1)
+	unreg := b.WatchNetworkEvents(evc)
+	b.linkChange(&netmon.ChangeDelta{New: st, Major: true})
+
+	var ev ipnstate.NetworkEvent
+	select {
+	case ev = <-evc:
+	default:
+		t.Fatal("no event after link change")
+	}
+	want := &ipnstate.LinkState{
+		Major:                 true,
+		DefaultRouteInterface: "wlan0",
+		Interfaces:            []string{"eth0", "wlan0"},
+		HaveV4:                true,
+		Expensive:             true,
+	}
+	if !reflect.DeepEqual(ev.Link, want) {
+		t.Errorf("Link = %+v; want %+v", ev.Link, want)
+	}
+	if ev.Time.IsZero() {
+		t.Error("event has no time")
+	}
+
+	cur := b.CurrentNetworkEvent()
+	want.Major = false
+	if !reflect.DeepEqual(cur.Link, want) {
+		t.Errorf("current Link = %+v; want %+v", cur.Link, want)
+	}
+
+	unreg()
+	b.linkChange(&netmon.ChangeDelta{New: st})
+	select {
+	case ev := <-evc:
+		t.Errorf("got event %+v after unregistering", ev)
+	default:
+	}
+}
@@ -46,11 +46,23 @@
 	}
 )
 
+// tkaSigCacheFile is the name of the file in the tailchonk directory that
+// the signatures known to verify are saved in.
+const tkaSigCacheFile = "sig-cache.json"
+
 type tkaState struct {
 	profile   ipn.ProfileID
 	authority *tka.Authority
 	storage   *tka.FS
 	filtered  []ipnstate.TKAFilteredPeer
+
+	// sigCache caches the node-key signatures that verified, so that
+	// they're not all verified again at every start. It may be nil.
+	sigCache *tka.SigCache
+
+	// pendingRecovery is the recovery AUM most recently generated or
+	// co-signed by this node, which has not yet been submitted.
+	pendingRecovery *tka.AUM
 }
 
 // tkaFilterNetmapLocked checks the signatures on each node key, dropping
@@ -77,7 +89,7 @@ func (b *LocalBackend) tkaFilterNetmapLocked(nm *netmap.NetworkMap) {
 			b.logf("Network lock is dropping peer %v(%v) due to missing signature", p.ID(), p.StableID())
 			mak.Set(&toDelete, i, true)
 		} else {
-			if err := b.tka.authority.NodeKeyAuthorized(p.Key(), p.KeySignature().AsSlice()); err != nil {
+			if err := b.tka.sigCache.NodeKeyAuthorized(b.tka.authority, p.Key(), p.KeySignature().AsSlice()); err != nil {
 				b.logf("Network lock is dropping peer %v(%v) due to failed signature check: %v", p.ID(), p.StableID(), err)
 				mak.Set(&toDelete, i, true)
 			}
@@ -116,11 +128,14 @@ func (b *LocalBackend) tkaFilterNetmapLocked(nm *netmap.NetworkMap) {
 	}
 
 	// Check that we ourselves are not locked out, report a health issue if so.
-	if nm.SelfNode.Valid() && b.tka.authority.NodeKeyAuthorized(nm.SelfNode.Key(), nm.SelfNode.KeySignature().AsSlice()) != nil {
+	if nm.SelfNode.Valid() && b.tka.sigCache.NodeKeyAuthorized(b.tka.authority, nm.SelfNode.Key(), nm.SelfNode.KeySignature().AsSlice()) != nil {
 		health.SetTKAHealth(errors.New(healthmsg.LockedOut))
 	} else {
 		health.SetTKAHealth(nil)
 	}
+	if err := b.tka.sigCache.Save(); err != nil {
+		b.logf("tka: saving signature cache: %v", err)
+	}
 }
 
 // tkaSyncIfNeeded examines TKA info reported from the control plane,
@@ -367,6 +382,7 @@ func (b *LocalBackend) tkaBootstrapFromGenesisLocked(g tkatype.MarshaledAUM, per
 		profile:   b.pm.CurrentProfile().ID,
 		authority: authority,
 		storage:   chonk,
+		sigCache:  tka.NewSigCache(filepath.Join(chonkDir, tkaSigCacheFile)),
 	}
 	return nil
 }
@@ -445,6 +461,15 @@ func (b *LocalBackend) NetworkLockStatus() *ipnstate.NetworkLockStatus {
 
 	stateID1, _ := b.tka.authority.StateIDs()
 
+	var pendingRecovery *ipnstate.NetworkLockRecovery
+	if b.tka.pendingRecovery != nil {
+		var err error
+		pendingRecovery, err = b.networkLockRecoveryLocked(b.tka.pendingRecovery, nlPriv.Public())
+		if err != nil {
+			b.logf("network-lock: pending recovery AUM: %v", err)
+		}
+	}
+
 	return &ipnstate.NetworkLockStatus{
 		Enabled:       true,
 		Head:          &head,
@@ -454,9 +479,39 @@ func (b *LocalBackend) NetworkLockStatus() *ipnstate.NetworkLockStatus {
 		TrustedKeys:   outKeys,
 		FilteredPeers: filtered,
 		StateID:       stateID1,
+
+		PendingRecovery: pendingRecovery,
 	}
 }
 
+// networkLockRecoveryLocked describes the signing progress of the
+// recovery AUM aum, from the perspective of the node with the
+// network-lock key self.
+//
+// b.mu must be held, and b.tka must be non-nil.
+func (b *LocalBackend) networkLockRecoveryLocked(aum *tka.AUM, self key.NLPublic) (*ipnstate.NetworkLockRecovery, error) {
+	progress, err := b.tka.authority.RevocationProgress(aum)
+	if err != nil {
+		return nil, err
+	}
+	out := &ipnstate.NetworkLockRecovery{
+		Raw:            aum.Serialize(),
+		Weight:         progress.Weight,
+		RequiredWeight: progress.RequiredWeight,
+	}
+	for _, k := range progress.Revoked {
+		out.RevokedKeys = append(out.RevokedKeys, key.NLPublicFromEd25519Unsafe(k.Public))
+	}
+	for _, k := range progress.Signers {
+		pub := key.NLPublicFromEd25519Unsafe(k.Public)
+		out.Signers = append(out.Signers, pub)
+		if pub == self {
+			out.SignedBySelf = true
+		}
+	}
+	return out, nil
+}
+
 // NetworkLockInit enables network-lock for the tailnet, with the tailnets'
 // key authority initialized to trust the provided keys.
 //
@@ -611,22 +666,12 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 			return key.NodePublic{}, tka.NodeKeySignature{}, errors.New("this node is not trusted by network lock")
 		}
 
-		p, err := nodeKey.MarshalBinary()
+		sig, err := tka.SignNodeKey(nodeKey, rotationPublic, nlPriv)
 		if err != nil {
 			return key.NodePublic{}, tka.NodeKeySignature{}, err
 		}
-		sig := tka.NodeKeySignature{
-			SigKind:        tka.SigDirect,
-			KeyID:          nlPriv.KeyID(),
-			Pubkey:         p,
-			WrappingPubkey: rotationPublic,
-		}
-		sig.Signature, err = nlPriv.SignNKS(sig.SigHash())
-		if err != nil {
-			return key.NodePublic{}, tka.NodeKeySignature{}, fmt.Errorf("signature failed: %w", err)
-		}
 
-		return b.pm.CurrentPrefs().Persist().PublicNodeKey(), sig, nil
+		return b.pm.CurrentPrefs().Persist().PublicNodeKey(), *sig, nil
 	}(nodeKey, rotationPublic)
 	if err != nil {
 		return err
@@ -639,6 +684,46 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 	return nil
 }
 
+// NetworkLockSubmitSignature submits to the control plane a node-key
+// signature made elsewhere, such as by the CLI with a signing key held in
+// an HSM or signing agent. The signature must be by a key trusted by
+// tailnet lock.
+func (b *LocalBackend) NetworkLockSubmitSignature(sig tkatype.MarshaledSignature) error {
+	var nks tka.NodeKeySignature
+	if err := nks.Unserialize(sig); err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+	if nks.SigKind != tka.SigDirect {
+		return fmt.Errorf("unexpected signature kind %v", nks.SigKind)
+	}
+	var nodeKey key.NodePublic
+	if err := nodeKey.UnmarshalBinary(nks.Pubkey); err != nil {
+		return fmt.Errorf("decoding signed node-key: %w", err)
+	}
+
+	b.mu.Lock()
+	var ourNodeKey key.NodePublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() {
+		ourNodeKey = p.Persist().PublicNodeKey()
+	}
+	if b.tka == nil {
+		b.mu.Unlock()
+		return errNetworkLockNotActive
+	}
+	err := b.tka.authority.NodeKeyAuthorized(nodeKey, sig)
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("signature not valid under tailnet lock: %w", err)
+	}
+	if ourNodeKey.IsZero() {
+		return errMissingNetmap
+	}
+
+	b.logf("Submitting externally-made network-lock signature for %v to control plane", nodeKey)
+	_, err = b.tkaSubmitSignature(ourNodeKey, sig)
+	return err
+}
+
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
 func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err error) {
 	defer func() {
@@ -876,6 +961,7 @@ func (b *LocalBackend) NetworkLockGenerateRecoveryAUM(removeKeys []tkatype.KeyID
 		return nil, fmt.Errorf("signing failed: %w", err)
 	}
 
+	b.tka.pendingRecovery = aum
 	return aum, nil
 }
 
@@ -910,9 +996,28 @@ func (b *LocalBackend) NetworkLockCosignRecoveryAUM(aum *tka.AUM) (*tka.AUM, err
 	}
 	aum.Signatures = append(aum.Signatures, sigs...)
 
+	b.tka.pendingRecovery = aum
 	return aum, nil
 }
 
+// NetworkLockInspectRecoveryAUM describes the provided recovery AUM: the
+// keys it revokes, which trusted keys have signed it, and whether it has
+// enough signatures to be submitted.
+func (b *LocalBackend) NetworkLockInspectRecoveryAUM(aum *tka.AUM) (*ipnstate.NetworkLockRecovery, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tka == nil {
+		return nil, errNetworkLockNotActive
+	}
+	var self key.NLPublic
+	if p := b.pm.CurrentPrefs(); p.Valid() && p.Persist().Valid() && !p.Persist().NetworkLockKey().IsZero() {
+		self = p.Persist().NetworkLockKey().Public()
+	}
+	return b.networkLockRecoveryLocked(aum, self)
+}
+
+// NetworkLockSubmitRecoveryAUM submits the provided recovery AUM to
+// control, which distributes it to the rest of the tailnet.
 func (b *LocalBackend) NetworkLockSubmitRecoveryAUM(aum *tka.AUM) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -930,7 +1035,13 @@ func (b *LocalBackend) NetworkLockSubmitRecoveryAUM(aum *tka.AUM) error {
 	b.mu.Unlock()
 	_, err := b.tkaDoSyncSend(ourNodeKey, aum.Hash(), []tka.AUM{*aum}, false)
 	b.mu.Lock()
-	return err
+	if err != nil {
+		return err
+	}
+	if b.tka != nil {
+		b.tka.pendingRecovery = nil
+	}
+	return nil
 }
 
 var tkaSuffixEncoder = base64.RawStdEncoding
@@ -980,22 +1091,7 @@ func (b *LocalBackend) NetworkLockVerifySigningDeeplink(url string) tka.Deeplink
 }
 
 func signNodeKey(nodeInfo tailcfg.TKASignInfo, signer key.NLPrivate) (*tka.NodeKeySignature, error) {
-	p, err := nodeInfo.NodePublic.MarshalBinary()
-	if err != nil {
-		return nil, err
-	}
-
-	sig := tka.NodeKeySignature{
-		SigKind:        tka.SigDirect,
-		KeyID:          signer.KeyID(),
-		Pubkey:         p,
-		WrappingPubkey: nodeInfo.RotationPubkey,
-	}
-	sig.Signature, err = signer.SignNKS(sig.SigHash())
-	if err != nil {
-		return nil, fmt.Errorf("signature failed: %w", err)
-	}
-	return &sig, nil
+	return tka.SignNodeKey(nodeInfo.NodePublic, nodeInfo.RotationPubkey, signer)
 }
 
 func (b *LocalBackend) tkaInitBegin(ourNodeKey key.NodePublic, aum tka.AUM) (*tailcfg.TKAInitBeginResponse, error) {
@@ -1095,6 +1095,22 @@ func TestTKARecoverCompromisedKeyFlow(t *testing.T) {
 	if err != nil {
 		t.Fatalf("NetworkLockGenerateRecoveryAUM() failed: %v", err)
 	}
+	if b.tka.pendingRecovery != aum {
+		t.Error("generated recovery AUM is not pending")
+	}
+	rec, err := b.NetworkLockInspectRecoveryAUM(aum)
+	if err != nil {
+		t.Fatalf("NetworkLockInspectRecoveryAUM() failed: %v", err)
+	}
+	if len(rec.RevokedKeys) != 1 || rec.RevokedKeys[0] != compromisedPriv.Public() {
+		t.Errorf("RevokedKeys = %v, want [%v]", rec.RevokedKeys, compromisedPriv.Public())
+	}
+	if len(rec.Signers) != 1 || rec.Signers[0] != nlPriv.Public() || !rec.SignedBySelf {
+		t.Errorf("Signers = %v (self=%v), want [%v]", rec.Signers, rec.SignedBySelf, nlPriv.Public())
+	}
+	if rec.Weight != 2 || rec.RequiredWeight != 2 {
+		t.Errorf("weight = %d/%d, want 2/2", rec.Weight, rec.RequiredWeight)
+	}
 
 	// Cosign using the cosigning key.
 	{
@@ -1125,4 +1141,7 @@ func TestTKARecoverCompromisedKeyFlow(t *testing.T) {
 	if err := b.NetworkLockSubmitRecoveryAUM(aum); err != nil {
 		t.Errorf("NetworkLockSubmitRecoveryAUM() failed: %v", err)
 	}
+	if b.tka.pendingRecovery != nil {
+		t.Error("recovery AUM still pending after submission")
+	}
 }
@@ -0,0 +1,79 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// updateNodeByAddr returns the index of the nodes of nm by their
+// single-IP addresses, updating in place idx, the index of prev.
+//
+// Successive netmaps share the views of their unchanged nodes, so only the
+// nodes whose views differ are reindexed. That keeps the cost of a map
+// update proportional to the number of peers it changes, rather than to
+// the size of the tailnet, for all but a walk of the peer lists.
+func updateNodeByAddr(idx map[netip.Addr]tailcfg.NodeView, prev, nm *netmap.NetworkMap) map[netip.Addr]tailcfg.NodeView {
+	if nm == nil {
+		return nil
+	}
+	if idx == nil || prev == nil || !peersSortedByID(prev.Peers) || !peersSortedByID(nm.Peers) {
+		idx = make(map[netip.Addr]tailcfg.NodeView, len(nm.Peers)+1)
+		prev = &netmap.NetworkMap{}
+	}
+
+	var none tailcfg.NodeView
+	reindexNode(idx, prev.SelfNode, nm.SelfNode)
+	old, cur := prev.Peers, nm.Peers
+	for len(old) > 0 || len(cur) > 0 {
+		switch {
+		case len(cur) == 0 || len(old) > 0 && old[0].ID() < cur[0].ID():
+			reindexNode(idx, old[0], none)
+			old = old[1:]
+		case len(old) == 0 || cur[0].ID() < old[0].ID():
+			reindexNode(idx, none, cur[0])
+			cur = cur[1:]
+		default:
+			reindexNode(idx, old[0], cur[0])
+			old, cur = old[1:], cur[1:]
+		}
+	}
+	return idx
+}
+
+// reindexNode replaces node was with node n in idx. Either may be invalid,
+// for a node that's added or removed.
+func reindexNode(idx map[netip.Addr]tailcfg.NodeView, was, n tailcfg.NodeView) {
+	if was == n {
+		return
+	}
+	if was.Valid() {
+		for i := range was.Addresses().LenIter() {
+			if ipp := was.Addresses().At(i); ipp.IsSingleIP() && idx[ipp.Addr()] == was {
+				delete(idx, ipp.Addr())
+			}
+		}
+	}
+	if n.Valid() {
+		for i := range n.Addresses().LenIter() {
+			if ipp := n.Addresses().At(i); ipp.IsSingleIP() {
+				idx[ipp.Addr()] = n
+			}
+		}
+	}
+}
+
+// peersSortedByID reports whether peers is sorted by increasing node ID,
+// as the netmaps from control are.
+func peersSortedByID(peers []tailcfg.NodeView) bool {
+	for i := 1; i < len(peers); i++ {
+		if peers[i-1].ID() >= peers[i].ID() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func testIndexNode(id tailcfg.NodeID, addrs ...string) tailcfg.NodeView {
+	n := &tailcfg.Node{ID: id, Name: fmt.Sprintf("node%d", id)}
+	for _, a := range addrs {
+		n.Addresses = append(n.Addresses, netip.MustParsePrefix(a))
+	}
+	return n.View()
+}
+
+// testNodesByAddr is the index of nm built from scratch.
+func testNodesByAddr(nm *netmap.NetworkMap) map[netip.Addr]tailcfg.NodeID {
+	ret := map[netip.Addr]tailcfg.NodeID{}
+	for _, n := range append([]tailcfg.NodeView{nm.SelfNode}, nm.Peers...) {
+		for i := range n.Addresses().LenIter() {
+			if ipp := n.Addresses().At(i); ipp.IsSingleIP() {
+				ret[ipp.Addr()] = n.ID()
+			}
+		}
+	}
+	return ret
+}
+
+func TestUpdateNodeByAddr(t *testing.T) {
+	self := testIndexNode(1, "100.64.0.1/32")
+	p2 := testIndexNode(2, "100.64.0.2/32", "fd7a:115c:a1e0::2/128")
+	p3 := testIndexNode(3, "100.64.0.3/32", "10.0.0.0/24")
+	p4 := testIndexNode(4, "100.64.0.4/32")
+	p3moved := testIndexNode(3, "100.64.0.33/32")
+	p5 := testIndexNode(5, "100.64.0.3/32") // takes p3's old address
+
+	netmaps := []*netmap.NetworkMap{
+		{SelfNode: self, Peers: []tailcfg.NodeView{p2, p3}},
+		{SelfNode: self, Peers: []tailcfg.NodeView{p2, p3, p4}},
+		{SelfNode: self, Peers: []tailcfg.NodeView{p2, p3moved, p4, p5}},
+		{SelfNode: self, Peers: []tailcfg.NodeView{p4, p5}},
+		{SelfNode: self, Peers: []tailcfg.NodeView{p5, p2}}, // unsorted
+		{SelfNode: testIndexNode(1, "100.64.0.100/32"), Peers: []tailcfg.NodeView{p2}},
+	}
+	var idx map[netip.Addr]tailcfg.NodeView
+	var prev *netmap.NetworkMap
+	for i, nm := range netmaps {
+		idx = updateNodeByAddr(idx, prev, nm)
+		prev = nm
+		got := map[netip.Addr]tailcfg.NodeID{}
+		for a, n := range idx {
+			got[a] = n.ID()
+		}
+		if want := testNodesByAddr(nm); !reflect.DeepEqual(got, want) {
+			t.Errorf("netmap %d: index = %v; want %v", i, got, want)
+		}
+	}
+	if idx := updateNodeByAddr(idx, prev, nil); idx != nil {
+		t.Errorf("index of nil netmap = %v; want nil", idx)
+	}
+}
+
+func BenchmarkUpdateNodeByAddr(b *testing.B) {
+	const numPeers = 5000
+	self := testIndexNode(1, "100.64.0.1/32")
+	peers := make([]tailcfg.NodeView, numPeers)
+	for i := range peers {
+		id := tailcfg.NodeID(i + 2)
+		peers[i] = testIndexNode(id, fmt.Sprintf("100.%d.%d.%d/32", 64+(i>>16), (i>>8)&0xff, i&0xff))
+	}
+	prev := &netmap.NetworkMap{SelfNode: self, Peers: peers}
+	idx := updateNodeByAddr(nil, nil, prev)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A map update changing one peer, as for an endpoint or
+		// online change.
+		peers := append([]tailcfg.NodeView(nil), prev.Peers...)
+		j := i % numPeers
+		n := peers[j].AsStruct()
+		n.Online = new(bool)
+		peers[j] = n.View()
+		nm := &netmap.NetworkMap{SelfNode: self, Peers: peers}
+		idx = updateNodeByAddr(idx, prev, nm)
+		prev = nm
+	}
+}
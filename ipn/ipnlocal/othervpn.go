@@ -0,0 +1,113 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netmon"
+)
+
+var warnOtherVPN = health.NewWarnable(health.WithCode("vpn-coexistence"), health.WithSeverity(health.SeverityMedium), health.WithSubsystem(health.SysNetwork))
+
+// setOtherVPNsLocked records the VPNs other than Tailscale that are active
+// on this machine and updates the health warning about those conflicting
+// with Tailscale. It reports whether the set of conflicting VPNs changed.
+//
+// b.mu must be held.
+func (b *LocalBackend) setOtherVPNsLocked(vpns []netmon.OtherVPN) (changed bool) {
+	old := conflictingVPNs(b.otherVPNs)
+	b.otherVPNs = vpns
+	cur := conflictingVPNs(vpns)
+	changed = !slices.EqualFunc(old, cur, func(a, b netmon.OtherVPN) bool {
+		return a.Interface == b.Interface && a.Mode == b.Mode
+	})
+	if changed {
+		b.logf("other VPNs: %v", vpns)
+	}
+	if len(cur) == 0 {
+		warnOtherVPN.Set(nil)
+		return changed
+	}
+
+	var names, modes []string
+	full := false
+	for _, v := range cur {
+		names = append(names, v.Interface)
+		modes = append(modes, string(v.Mode))
+		full = full || v.Mode == netmon.VPNFullTunnel
+	}
+	var msg string
+	if full {
+		msg = fmt.Sprintf("another VPN (%s) has the default route; Tailscale's exit node and subnet routes may not work", strings.Join(names, ", "))
+	} else {
+		msg = fmt.Sprintf("another VPN (%s) uses addresses in Tailscale's range; some peers may be unreachable", strings.Join(names, ", "))
+	}
+	msg += `. Use "tailscale set --other-vpn" to choose how they coexist`
+	warnOtherVPN.SetWithArgs(errors.New(msg), health.Args{
+		"interfaces": strings.Join(names, ","),
+		"modes":      strings.Join(modes, ","),
+	})
+	return changed
+}
+
+// conflictingVPNs returns the VPNs in vpns that conflict with Tailscale.
+func conflictingVPNs(vpns []netmon.OtherVPN) []netmon.OtherVPN {
+	var ret []netmon.OtherVPN
+	for _, v := range vpns {
+		if v.Conflicts() {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// otherVPNPolicyLocked returns the OtherVPNPolicy pref to apply to the
+// routes, and the VPNs it applies to. It returns the empty string if no
+// conflicting VPN is active or the policy is to override them.
+//
+// b.mu must be held.
+func (b *LocalBackend) otherVPNPolicyLocked(prefs ipn.PrefsView) (policy string, vpns []netmon.OtherVPN) {
+	if !prefs.Valid() {
+		return "", nil
+	}
+	switch policy := prefs.OtherVPNPolicy(); policy {
+	case ipn.OtherVPNYield, ipn.OtherVPNScope:
+		if vpns := conflictingVPNs(b.otherVPNs); len(vpns) > 0 {
+			return policy, vpns
+		}
+	}
+	return "", nil
+}
+
+// withoutExitNode returns a copy of prefs without an exit node.
+func withoutExitNode(prefs ipn.PrefsView) ipn.PrefsView {
+	p := prefs.AsStruct()
+	p.ExitNodeID = ""
+	p.ExitNodeIP = netip.Addr{}
+	return p.View()
+}
+
+// routesWithoutOverlaps returns the routes that don't overlap the addresses
+// of any of vpns.
+func routesWithoutOverlaps(routes []netip.Prefix, vpns []netmon.OtherVPN) []netip.Prefix {
+	var ret []netip.Prefix
+	for _, r := range routes {
+		overlaps := slices.ContainsFunc(vpns, func(v netmon.OtherVPN) bool {
+			return slices.ContainsFunc(v.Prefixes, func(p netip.Prefix) bool {
+				return p.Masked().Overlaps(r)
+			})
+		})
+		if !overlaps {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
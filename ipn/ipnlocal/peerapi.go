@@ -49,6 +49,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/multierr"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine/filter"
@@ -695,6 +696,14 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handleDNSQuery(w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, PeerAPIHandlerPrefix) {
+		h.handleExtension(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/v0/debug/") {
+		h.handleServeDebug(w, r)
+		return
+	}
 	switch r.URL.Path {
 	case "/v0/goroutines":
 		h.handleServeGoroutines(w, r)
@@ -723,6 +732,12 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case "/v0/sockstats":
 		h.handleServeSockStats(w, r)
 		return
+	case "/v0/fleet-status":
+		h.handleServeFleetStatus(w, r)
+		return
+	case "/v0/bench":
+		h.handleServeBench(w, r)
+		return
 	case "/v0/ingress":
 		metricIngressCalls.Add(1)
 		h.handleServeIngress(w, r)
@@ -1054,6 +1069,15 @@ func (h *peerAPIHandler) canWakeOnLAN() bool {
 	return h.isSelf || h.peerHasCap(tailcfg.PeerCapabilityWakeOnLAN)
 }
 
+// canReadFleetStatus reports whether h can read this node's summary for a
+// fleet dashboard.
+func (h *peerAPIHandler) canReadFleetStatus() bool {
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		return false
+	}
+	return h.isSelf || h.peerHasCap(tailcfg.PeerCapabilityFleetStatus)
+}
+
 var allowSelfIngress = envknob.RegisterBool("TS_ALLOW_SELF_INGRESS")
 
 // canIngress reports whether h can send ingress requests to this node.
@@ -1159,7 +1183,9 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	finalPath := dstFile
 	if h.ps.directFileMode && !h.ps.directFileDoFinalRename {
+		finalPath = partialFile
 		if inFile != nil { // non-zero length; TODO: notify even for zero length
 			inFile.markAndNotifyDone()
 		}
@@ -1181,6 +1207,17 @@ func (h *peerAPIHandler) handlePeerPut(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, "{}\n")
 	h.ps.knownEmpty.Store(false)
 	h.ps.b.sendFileNotify()
+
+	if hook := h.ps.b.taildropHook(); hook != nil {
+		go h.ps.b.runTaildropHook(hook, ipn.TaildropHookEvent{
+			Path:     finalPath,
+			Name:     baseName,
+			Size:     finalSize,
+			FromNode: h.peerNode.ComputedName(),
+			FromIP:   h.remoteAddr.Addr(),
+			FromUser: h.peerUser.LoginName,
+		})
+	}
 }
 
 func approxSize(n int64) string {
@@ -1229,6 +1266,15 @@ func (h *peerAPIHandler) handleServeEnv(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(data)
 }
 
+func (h *peerAPIHandler) handleServeFleetStatus(w http.ResponseWriter, r *http.Request) {
+	if !h.canReadFleetStatus() {
+		http.Error(w, "denied; no fleet-status cap", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.ps.b.StatusWithoutPeers().FleetNodeStatus())
+}
+
 func (h *peerAPIHandler) handleServeMagicsock(w http.ResponseWriter, r *http.Request) {
 	if !h.canDebug() {
 		http.Error(w, "denied; no debug access", http.StatusForbidden)
@@ -1241,6 +1287,42 @@ func (h *peerAPIHandler) handleServeMagicsock(w http.ResponseWriter, r *http.Req
 	http.Error(w, "miswired", 500)
 }
 
+// handleServeDebug serves the process's debug server handlers (pprof,
+// expvars and such) under /v0/debug/, if tailscaled was configured to.
+func (h *peerAPIHandler) handleServeDebug(w http.ResponseWriter, r *http.Request) {
+	if !h.canDebug() {
+		http.Error(w, "denied; no debug access", http.StatusForbidden)
+		return
+	}
+	dh := h.ps.b.peerAPIDebugHandler
+	if dh == nil {
+		http.Error(w, "debug handlers not enabled on this node", http.StatusNotFound)
+		return
+	}
+	http.StripPrefix("/v0", dh).ServeHTTP(w, r)
+}
+
+// handleServeBench discards the request body, as sent by
+// "tailscale debug bench" on another node, replying with how many bytes of
+// it were received.
+func (h *peerAPIHandler) handleServeBench(w http.ResponseWriter, r *http.Request) {
+	if !h.canDebug() {
+		http.Error(w, "denied; no debug access", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "bad method", http.StatusMethodNotAllowed)
+		return
+	}
+	n, err := io.Copy(io.Discard, r.Body)
+	if err != nil {
+		h.logf("bench: %v after %d bytes", err, n)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Bytes int64 }{n})
+}
+
 func (h *peerAPIHandler) handleServeMetrics(w http.ResponseWriter, r *http.Request) {
 	if !h.canDebug() {
 		http.Error(w, "denied; no debug access", http.StatusForbidden)
@@ -1325,16 +1407,17 @@ func (h *peerAPIHandler) replyToDNSQueries() bool {
 		return true
 	}
 	b := h.ps.b
-	if !b.OfferingExitNode() {
-		// If we're not an exit node, there's no point to
-		// being a DNS server for somebody.
-		return false
-	}
 	if !h.remoteAddr.IsValid() {
 		// This should never be the case if the peerAPIHandler
 		// was wired up correctly, but just in case.
 		return false
 	}
+	if !b.OfferingExitNode() {
+		// If we're not an exit node, there's no point to
+		// being a DNS server for somebody, unless we do DNS64
+		// for IPv6-only peers that may reach our NAT64 subnets.
+		return h.canReachDNS64Routes()
+	}
 	// Otherwise, we're an exit node but the peer is not us, so
 	// we need to check if they're allowed access to the internet.
 	// As peerapi bypasses wgengine/filter checks, we need to check
@@ -1360,6 +1443,23 @@ func (h *peerAPIHandler) replyToDNSQueries() bool {
 	return verdict == filter.Accept
 }
 
+// canReachDNS64Routes reports whether the peer may use the peerapi DNS
+// server for DNS64: whether the NAT64 pref is on and, as in
+// replyToDNSQueries, the filter would accept a packet from the peer to
+// port 53 of one of the subnets it applies to.
+func (h *peerAPIHandler) canReachDNS64Routes() bool {
+	f := h.ps.b.filterAtomic.Load()
+	if f == nil {
+		return false
+	}
+	for _, r := range h.ps.b.dns64Routes() {
+		if f.CheckTCP(h.remoteAddr.Addr(), r.Addr(), 53) == filter.Accept {
+			return true
+		}
+	}
+	return false
+}
+
 // handleDNSQuery implements a DoH server (RFC 8484) over the peerapi.
 // It's not over HTTPS as the spec dictates, but rather HTTP-over-WireGuard.
 func (h *peerAPIHandler) handleDNSQuery(w http.ResponseWriter, r *http.Request) {
@@ -1367,10 +1467,6 @@ func (h *peerAPIHandler) handleDNSQuery(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "DNS not wired up", http.StatusNotImplemented)
 		return
 	}
-	if !h.replyToDNSQueries() {
-		http.Error(w, "DNS access denied", http.StatusForbidden)
-		return
-	}
 	pretty := false // non-DoH debug mode for humans
 	q, publicError := dohQuery(r)
 	if publicError != "" && r.Method == "GET" {
@@ -1384,6 +1480,13 @@ func (h *peerAPIHandler) handleDNSQuery(w http.ResponseWriter, r *http.Request)
 		http.Error(w, publicError, http.StatusBadRequest)
 		return
 	}
+	// Names with a handler registered on the resolver, such as by a
+	// tsnet app, are this node's own, so any peer may look them up.
+	handler := h.ps.resolver.HandlerFor(dnsQueryName(q))
+	if handler == nil && !h.replyToDNSQueries() {
+		http.Error(w, "DNS access denied", http.StatusForbidden)
+		return
+	}
 
 	// Some timeout that's short enough to be noticed by humans
 	// but long enough that it's longer than real DNS timeouts.
@@ -1391,7 +1494,19 @@ func (h *peerAPIHandler) handleDNSQuery(w http.ResponseWriter, r *http.Request)
 
 	ctx, cancel := context.WithTimeout(r.Context(), arbitraryTimeout)
 	defer cancel()
-	res, err := h.ps.resolver.HandleExitNodeDNSQuery(ctx, q, h.remoteAddr, h.ps.b.allowExitNodeDNSProxyToServeName)
+	lookup := func(q []byte) ([]byte, error) {
+		return h.ps.resolver.HandleExitNodeDNSQuery(ctx, q, h.remoteAddr, h.ps.b.allowExitNodeDNSProxyToServeName)
+	}
+	var res []byte
+	var err error
+	if handler != nil {
+		res, err = handler(ctx, q, h.remoteAddr)
+	} else {
+		res, err = lookup(q)
+		if err == nil {
+			res, err = dns64(q, res, h.ps.b.dns64Routes(), lookup)
+		}
+	}
 	if err != nil {
 		h.logf("handleDNS fwd error: %v", err)
 		if err := ctx.Err(); err != nil {
@@ -1471,6 +1586,24 @@ func dnsQueryForName(name, typStr string) []byte {
 	return msg
 }
 
+// dnsQueryName returns the lowercased name in the question of the DNS
+// query q, or the empty string if q is malformed.
+func dnsQueryName(q []byte) dnsname.FQDN {
+	var p dnsmessage.Parser
+	if _, err := p.Start(q); err != nil {
+		return ""
+	}
+	qq, err := p.Question()
+	if err != nil {
+		return ""
+	}
+	name, err := dnsname.ToFQDN(strings.ToLower(qq.Name.String()))
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
 func writePrettyDNSReply(w io.Writer, res []byte) (err error) {
 	defer func() {
 		if err != nil {
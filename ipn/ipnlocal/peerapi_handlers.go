@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/tailcfg"
+)
+
+// PeerAPIHandlerPrefix is the path prefix under which handlers registered
+// with RegisterPeerAPIHandler are served, so they can't conflict with the
+// peerapi's built-in handlers.
+const PeerAPIHandlerPrefix = "/v0/x/"
+
+// PeerAPIHandler describes the peer making a peerapi request to a handler
+// registered with LocalBackend.RegisterPeerAPIHandler.
+type PeerAPIHandler interface {
+	// Peer returns the node making the request.
+	Peer() tailcfg.NodeView
+
+	// PeerCaps returns the capabilities granted to the peer by the
+	// tailnet's access controls.
+	PeerCaps() tailcfg.PeerCapMap
+
+	// Self returns this node.
+	Self() tailcfg.NodeView
+
+	// RemoteAddr returns the Tailscale IP and port the request came from.
+	RemoteAddr() netip.AddrPort
+
+	// IsSelfUntagged reports whether the peer is owned by the same user
+	// as this node and neither is tagged.
+	IsSelfUntagged() bool
+
+	// Logf logs to this node's logs, with a peerapi prefix.
+	Logf(format string, a ...any)
+}
+
+// PeerAPIHandlerFunc handles a peerapi request to a registered path.
+type PeerAPIHandlerFunc func(PeerAPIHandler, http.ResponseWriter, *http.Request)
+
+// peerAPIExtHandler is a handler registered with RegisterPeerAPIHandler.
+type peerAPIExtHandler struct {
+	capability tailcfg.PeerCapability // if non-empty, required unless IsSelfUntagged
+	f          PeerAPIHandlerFunc
+}
+
+// RegisterPeerAPIHandler registers f to serve peerapi requests to path, so
+// that node-to-node services can use the peerapi's authenticated transport
+// instead of listening on their own ports.
+//
+// The path must start with PeerAPIHandlerPrefix. If it ends in "/", f
+// serves all paths below it too. Registering the same path twice is an
+// error.
+//
+// If capability is non-empty, only peers granted capability by the
+// tailnet's access controls, or untagged peers owned by the same user as
+// this untagged node, may call f; others get a 403 Forbidden. Otherwise any
+// peer allowed to reach this node may. Peers that may only use the peerapi
+// unsigned (see tailcfg.Node.UnsignedPeerAPIOnly) can never call f.
+func (b *LocalBackend) RegisterPeerAPIHandler(path string, capability tailcfg.PeerCapability, f PeerAPIHandlerFunc) error {
+	if f == nil {
+		return errors.New("nil handler")
+	}
+	if !strings.HasPrefix(path, PeerAPIHandlerPrefix) || len(path) == len(PeerAPIHandlerPrefix) {
+		return fmt.Errorf("peerapi handler path %q must be below %q", path, PeerAPIHandlerPrefix)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, dup := b.peerAPIExtHandlers[path]; dup {
+		return fmt.Errorf("peerapi handler for %q already registered", path)
+	}
+	if b.peerAPIExtHandlers == nil {
+		b.peerAPIExtHandlers = make(map[string]peerAPIExtHandler)
+	}
+	b.peerAPIExtHandlers[path] = peerAPIExtHandler{capability: capability, f: f}
+	return nil
+}
+
+// peerAPIExtHandlerFor returns the registered handler for path, preferring
+// an exact match and then the longest matching registered subtree.
+func (b *LocalBackend) peerAPIExtHandlerFor(path string) (_ peerAPIExtHandler, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h, ok := b.peerAPIExtHandlers[path]; ok {
+		return h, true
+	}
+	var best string
+	for p := range b.peerAPIExtHandlers {
+		if strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) && len(p) > len(best) {
+			best = p
+		}
+	}
+	if best == "" {
+		return peerAPIExtHandler{}, false
+	}
+	return b.peerAPIExtHandlers[best], true
+}
+
+// handleExtension serves a request to a path below PeerAPIHandlerPrefix.
+func (h *peerAPIHandler) handleExtension(w http.ResponseWriter, r *http.Request) {
+	eh, ok := h.ps.b.peerAPIExtHandlerFor(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if h.peerNode.UnsignedPeerAPIOnly() {
+		http.Error(w, "denied; unsigned peer", http.StatusForbidden)
+		return
+	}
+	if eh.capability != "" && !h.IsSelfUntagged() && !h.peerHasCap(eh.capability) {
+		h.logf("%s: denied; %v lacks capability %v", r.URL.Path, h.remoteAddr, eh.capability)
+		http.Error(w, "denied; missing capability", http.StatusForbidden)
+		return
+	}
+	eh.f(h, w, r)
+}
+
+func (h *peerAPIHandler) Peer() tailcfg.NodeView     { return h.peerNode }
+func (h *peerAPIHandler) Self() tailcfg.NodeView     { return h.selfNode }
+func (h *peerAPIHandler) RemoteAddr() netip.AddrPort { return h.remoteAddr }
+func (h *peerAPIHandler) Logf(format string, a ...any) {
+	h.logf(format, a...)
+}
+
+func (h *peerAPIHandler) PeerCaps() tailcfg.PeerCapMap {
+	return h.ps.b.PeerCaps(h.remoteAddr.Addr())
+}
+
+func (h *peerAPIHandler) IsSelfUntagged() bool {
+	return h.isSelf && h.selfNode.Tags().Len() == 0 && h.peerNode.Tags().Len() == 0
+}
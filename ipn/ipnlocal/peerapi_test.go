@@ -5,6 +5,7 @@
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,11 +17,14 @@
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"go4.org/netipx"
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
@@ -165,6 +169,12 @@ func TestHandlePeerAPI(t *testing.T) {
 				bodyContains("ServeHTTP"),
 			),
 		},
+		{
+			name:   "fleet-status/deny-nonself-no-cap",
+			isSelf: false,
+			req:    httptest.NewRequest("GET", "/v0/fleet-status", nil),
+			checks: checks(httpStatus(403)),
+		},
 		{
 			name:       "reject_non_owner_put",
 			isSelf:     false,
@@ -751,3 +761,272 @@ func TestRedactErr(t *testing.T) {
 		})
 	}
 }
+
+func TestPeerAPIRegisteredHandlers(t *testing.T) {
+	const inventoryCap = tailcfg.PeerCapability("example.com/cap/inventory")
+	selfIP := netip.MustParsePrefix("100.100.100.101/32")
+	grantedIP := netip.MustParseAddr("100.100.100.102")
+	selfNode := (&tailcfg.Node{Addresses: []netip.Prefix{selfIP}}).View()
+	lb := &LocalBackend{
+		logf:   t.Logf,
+		netMap: &netmap.NetworkMap{SelfNode: selfNode, Addresses: []netip.Prefix{selfIP}},
+		clock:  &tstest.Clock{},
+	}
+	lb.filterAtomic.Store(filter.New([]filter.Match{{
+		Srcs: []netip.Prefix{netip.PrefixFrom(grantedIP, 32)},
+		Caps: []filter.CapMatch{{Dst: selfIP, Cap: inventoryCap}},
+	}}, nil, nil, nil, t.Logf))
+
+	serve := func(p PeerAPIHandler, w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s from %v", r.URL.Path, p.RemoteAddr().Addr())
+	}
+	must.Do(lb.RegisterPeerAPIHandler("/v0/x/inventory", inventoryCap, serve))
+	must.Do(lb.RegisterPeerAPIHandler("/v0/x/probe/", "", serve))
+	for _, bad := range []string{"/v0/x/", "/v0/goroutines", "/x/foo"} {
+		if err := lb.RegisterPeerAPIHandler(bad, "", serve); err == nil {
+			t.Errorf("RegisterPeerAPIHandler(%q) succeeded", bad)
+		}
+	}
+	if err := lb.RegisterPeerAPIHandler("/v0/x/inventory", "", serve); err == nil {
+		t.Error("duplicate RegisterPeerAPIHandler succeeded")
+	}
+
+	tests := []struct {
+		name     string
+		isSelf   bool
+		from     netip.Addr
+		tagged   bool
+		unsigned bool
+		path     string
+		checks   []check
+	}{
+		{
+			name:   "self",
+			isSelf: true,
+			from:   netip.MustParseAddr("100.100.100.200"),
+			path:   "/v0/x/inventory",
+			checks: checks(httpStatus(200), bodyContains("hello /v0/x/inventory from 100.100.100.200")),
+		},
+		{
+			name:   "granted",
+			from:   grantedIP,
+			path:   "/v0/x/inventory",
+			checks: checks(httpStatus(200)),
+		},
+		{
+			name:   "not-granted",
+			from:   netip.MustParseAddr("100.100.100.103"),
+			path:   "/v0/x/inventory",
+			checks: checks(httpStatus(403)),
+		},
+		{
+			name:   "tagged-self-not-granted",
+			isSelf: true,
+			tagged: true,
+			from:   netip.MustParseAddr("100.100.100.103"),
+			path:   "/v0/x/inventory",
+			checks: checks(httpStatus(403)),
+		},
+		{
+			name:   "no-cap-subtree",
+			from:   netip.MustParseAddr("100.100.100.103"),
+			path:   "/v0/x/probe/live",
+			checks: checks(httpStatus(200), bodyContains("hello /v0/x/probe/live")),
+		},
+		{
+			name:     "unsigned",
+			from:     netip.MustParseAddr("100.100.100.103"),
+			unsigned: true,
+			path:     "/v0/x/probe/live",
+			checks:   checks(httpStatus(403)),
+		},
+		{
+			name:   "not-registered",
+			isSelf: true,
+			from:   netip.MustParseAddr("100.100.100.103"),
+			path:   "/v0/x/other",
+			checks: checks(httpStatus(404)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := &tailcfg.Node{UnsignedPeerAPIOnly: tt.unsigned}
+			if tt.tagged {
+				peer.Tags = []string{"tag:server"}
+			}
+			e := &peerAPITestEnv{rr: httptest.NewRecorder()}
+			e.ph = &peerAPIHandler{
+				ps:         &peerAPIServer{b: lb},
+				isSelf:     tt.isSelf,
+				remoteAddr: netip.AddrPortFrom(tt.from, 12345),
+				selfNode:   selfNode,
+				peerNode:   peer.View(),
+			}
+			req := httptest.NewRequest("GET", "http://100.100.100.101:12345"+tt.path, nil)
+			e.ph.ServeHTTP(e.rr, req)
+			for _, f := range tt.checks {
+				f(t, e)
+			}
+		})
+	}
+}
+
+func TestTaildropHook(t *testing.T) {
+	events := make(chan ipn.TaildropHookEvent, 1)
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev ipn.TaildropHookEvent
+		if err := json.NewDecoder(r.Body).Decode(&ev); err != nil {
+			t.Error(err)
+		}
+		events <- ev
+	}))
+	defer hookSrv.Close()
+
+	outFile := filepath.Join(t.TempDir(), "hook.out")
+	tests := []struct {
+		name string
+		hook string
+		wait func(t *testing.T) ipn.TaildropHookEvent
+	}{
+		{
+			name: "url",
+			hook: fmt.Sprintf(`{"URL": %q}`, hookSrv.URL+"/drop"),
+			wait: func(t *testing.T) ipn.TaildropHookEvent {
+				select {
+				case ev := <-events:
+					return ev
+				case <-time.After(10 * time.Second):
+					t.Fatal("timeout waiting for hook")
+				}
+				panic("unreachable")
+			},
+		},
+		{
+			name: "exec",
+			hook: fmt.Sprintf(`{"Exec": ["sh", "-c", "printf '%%s\\n' \"$TS_TAILDROP_PATH\" \"$TS_TAILDROP_NAME\" \"$TS_TAILDROP_SIZE\" \"$TS_TAILDROP_FROM_NODE\" \"$TS_TAILDROP_FROM_IP\" \"$TS_TAILDROP_FROM_USER\" > %s"]}`, outFile),
+			wait: func(t *testing.T) ipn.TaildropHookEvent {
+				var lines []string
+				if err := tstest.WaitFor(10*time.Second, func() error {
+					b, err := os.ReadFile(outFile)
+					if err != nil {
+						return err
+					}
+					lines = strings.Split(strings.TrimSpace(string(b)), "\n")
+					if len(lines) != 6 {
+						return fmt.Errorf("got %q", b)
+					}
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				size, _ := strconv.ParseInt(lines[2], 10, 64)
+				return ipn.TaildropHookEvent{
+					Path:     lines[0],
+					Name:     lines[1],
+					Size:     size,
+					FromNode: lines[3],
+					FromIP:   netip.MustParseAddr(lines[4]),
+					FromUser: lines[5],
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.name == "exec" && runtime.GOOS == "windows" {
+				t.Skip("no sh")
+			}
+			conf, err := conffile.Parse("test.conf", []byte(`{"version": "alpha0", "TaildropHook": `+tt.hook+`}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := t.TempDir()
+			ph := &peerAPIHandler{
+				isSelf:     true,
+				remoteAddr: netip.MustParseAddrPort("100.100.100.102:4567"),
+				peerNode: (&tailcfg.Node{
+					ComputedName: "some-peer-name",
+				}).View(),
+				peerUser: tailcfg.UserProfile{LoginName: "alice@example.com"},
+				selfNode: (&tailcfg.Node{
+					Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")},
+				}).View(),
+				ps: &peerAPIServer{
+					b: &LocalBackend{
+						logf:           t.Logf,
+						capFileSharing: true,
+						clock:          &tstest.Clock{},
+						conf:           conf,
+					},
+					rootDir: dir,
+				},
+			}
+			rr := httptest.NewRecorder()
+			ph.ServeHTTP(rr, httptest.NewRequest("PUT", "http://100.100.100.101:123/v0/put/report.pdf", strings.NewReader("hello")))
+			if res := rr.Result(); res.StatusCode != 200 {
+				t.Fatal(res.Status)
+			}
+			got := tt.wait(t)
+			want := ipn.TaildropHookEvent{
+				Path:     filepath.Join(dir, "report.pdf"),
+				Name:     "report.pdf",
+				Size:     5,
+				FromNode: "some-peer-name",
+				FromIP:   netip.MustParseAddr("100.100.100.102"),
+				FromUser: "alice@example.com",
+			}
+			if got != want {
+				t.Errorf("hook got %+v; want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestHandlePeerAPIDebug(t *testing.T) {
+	debugMux := http.NewServeMux()
+	debugMux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "vars here")
+	})
+	selfNode := (&tailcfg.Node{
+		Addresses:    []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")},
+		Capabilities: []string{tailcfg.CapabilityDebug},
+	}).View()
+	tests := []struct {
+		name       string
+		isSelf     bool
+		enabled    bool
+		wantStatus int
+	}{
+		{"accept-self", true, true, 200},
+		{"deny-nonself", false, true, 403},
+		{"not-enabled", true, false, 404},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := &LocalBackend{
+				logf:   t.Logf,
+				netMap: &netmap.NetworkMap{SelfNode: selfNode},
+				clock:  &tstest.Clock{},
+			}
+			if tt.enabled {
+				lb.SetPeerAPIDebugHandler(debugMux)
+			}
+			ph := &peerAPIHandler{
+				isSelf:   tt.isSelf,
+				selfNode: selfNode,
+				peerNode: (&tailcfg.Node{ComputedName: "some-peer-name"}).View(),
+				ps:       &peerAPIServer{b: lb},
+			}
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/v0/debug/vars", nil)
+			req.Host = "100.100.100.101:12345"
+			ph.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d; body: %s", rr.Code, tt.wantStatus, rr.Body.String())
+			}
+			if tt.wantStatus == 200 && rr.Body.String() != "vars here" {
+				t.Errorf("body = %q", rr.Body.String())
+			}
+		})
+	}
+}
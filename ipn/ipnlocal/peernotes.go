@@ -0,0 +1,85 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// Limits on the size of peer notes, to keep the state store small.
+const (
+	maxPeerNicknameLen = 64
+	maxPeerNotesLen    = 4 << 10
+	maxPeerNotes       = 10000
+)
+
+// PeerNotes returns the nicknames and notes the user has assigned to
+// peers, keyed by the peers' stable node IDs.
+func (b *LocalBackend) PeerNotes() (map[tailcfg.StableNodeID]ipn.PeerNote, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.peerNotesLocked()
+}
+
+func (b *LocalBackend) peerNotesLocked() (map[tailcfg.StableNodeID]ipn.PeerNote, error) {
+	notes := map[tailcfg.StableNodeID]ipn.PeerNote{}
+	j, err := b.store.ReadState(ipn.PeerNotesStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return notes, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(j, &notes); err != nil {
+		return nil, fmt.Errorf("decoding peer notes: %w", err)
+	}
+	return notes, nil
+}
+
+// SetPeerNotes updates the nicknames and notes assigned to peers.
+//
+// If replace is true, notes replaces all existing notes, as when importing
+// a previous export. Otherwise notes is merged into the existing notes,
+// and a zero PeerNote removes the notes for that peer.
+func (b *LocalBackend) SetPeerNotes(notes map[tailcfg.StableNodeID]ipn.PeerNote, replace bool) error {
+	for id, n := range notes {
+		if id == "" {
+			return errors.New("empty node ID")
+		}
+		if len(n.Nickname) > maxPeerNicknameLen {
+			return fmt.Errorf("nickname for %v is longer than %d bytes", id, maxPeerNicknameLen)
+		}
+		if len(n.Notes) > maxPeerNotesLen {
+			return fmt.Errorf("notes for %v are longer than %d bytes", id, maxPeerNotesLen)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	cur := map[tailcfg.StableNodeID]ipn.PeerNote{}
+	if !replace {
+		var err error
+		if cur, err = b.peerNotesLocked(); err != nil {
+			return err
+		}
+	}
+	maps.Copy(cur, notes)
+	maps.DeleteFunc(cur, func(_ tailcfg.StableNodeID, n ipn.PeerNote) bool {
+		return n.IsZero()
+	})
+	if len(cur) > maxPeerNotes {
+		return fmt.Errorf("too many peer notes (%d); max %d", len(cur), maxPeerNotes)
+	}
+	j, err := json.Marshal(cur)
+	if err != nil {
+		return err
+	}
+	return ipn.WriteState(b.store, ipn.PeerNotesStateKey, j)
+}
@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+
+	"tailscale.com/ipn"
+)
+
+// readPrefProvenance returns the saved provenance of the prefs of all
+// profiles, keyed by profile ID and then pref name.
+func (b *LocalBackend) readPrefProvenance() map[ipn.ProfileID]map[string]ipn.PrefProvenance {
+	all := map[ipn.ProfileID]map[string]ipn.PrefProvenance{}
+	j, err := b.store.ReadState(ipn.PrefProvenanceStateKey)
+	if err != nil {
+		return all
+	}
+	if err := json.Unmarshal(j, &all); err != nil {
+		b.logf("invalid pref provenance in store: %v", err)
+	}
+	if all == nil {
+		all = map[ipn.ProfileID]map[string]ipn.PrefProvenance{}
+	}
+	return all
+}
+
+// notePrefsChangedLocked records src as the source of the current
+// profile's prefs that differ from old, the prefs before they were
+// changed. It must be called after the new prefs are set.
+//
+// A profile that hasn't logged in yet has no ID, so the provenance of its
+// prefs is kept under the empty ID until it does.
+//
+// If src is a policy that changed the exit node, the user is also asked to
+// acknowledge it; see noteExitNodeChangeLocked.
+//
+// b.mu must be held.
+func (b *LocalBackend) notePrefsChangedLocked(old ipn.PrefsView, src ipn.PrefSource) {
+	cur := b.pm.CurrentPrefs()
+	if !cur.Valid() {
+		return
+	}
+	b.noteExitNodeChangeLocked(old, src)
+	oldp := new(ipn.Prefs)
+	if old.Valid() {
+		oldp = old.AsStruct()
+	}
+	names := ipn.ChangedPrefs(oldp, cur.AsStruct())
+	id := b.pm.CurrentProfile().ID
+	all := b.readPrefProvenance()
+	pending, hasPending := all[""]
+	if len(names) == 0 && (id == "" || !hasPending) {
+		return
+	}
+	prov := all[id]
+	if prov == nil {
+		prov = map[string]ipn.PrefProvenance{}
+		all[id] = prov
+	}
+	if id != "" && hasPending {
+		for name, pp := range pending {
+			if _, ok := prov[name]; !ok {
+				prov[name] = pp
+			}
+		}
+		delete(all, "")
+	}
+	pp := ipn.PrefProvenance{Source: src, Changed: b.clock.Now()}
+	for _, name := range names {
+		prov[name] = pp
+	}
+	j, err := json.Marshal(all)
+	if err != nil {
+		return
+	}
+	if err := b.store.WriteState(ipn.PrefProvenanceStateKey, j); err != nil {
+		b.logf("failed to save pref provenance: %v", err)
+	}
+}
+
+// PrefProvenance returns where the current profile's prefs came from, keyed
+// by pref name. Prefs not in the map haven't changed from their defaults
+// since their provenance started being tracked.
+func (b *LocalBackend) PrefProvenance() map[string]ipn.PrefProvenance {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prov := b.readPrefProvenance()[b.pm.CurrentProfile().ID]
+	if prov == nil {
+		prov = map[string]ipn.PrefProvenance{}
+	}
+	return prov
+}
@@ -0,0 +1,97 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"tailscale.com/ipn"
+	"tailscale.com/net/tshttpproxy"
+)
+
+// proxyConfigFromPrefs returns the tshttpproxy configuration for the Proxy
+// pref p and the proxy credentials creds, or nil to use the environment's.
+func proxyConfigFromPrefs(p ipn.ProxyPrefs, creds string) *tshttpproxy.Config {
+	if p == (ipn.ProxyPrefs{}) {
+		return nil
+	}
+	return &tshttpproxy.Config{
+		HTTPProxy:  p.URL,
+		HTTPSProxy: p.URL,
+		NoProxy:    p.NoProxy,
+		PACURL:     p.PACURL,
+		AutoDetect: p.AutoDetect,
+		Auth:       p.Auth,
+		User:       creds,
+	}
+}
+
+// checkProxyURLHasNoCredentials returns an error if the Proxy pref URL
+// proxyURL includes credentials, which belong in SetProxyCredentials
+// instead because prefs are readable by any local user.
+func checkProxyURLHasNoCredentials(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	if u, err := url.Parse(proxyURL); err == nil && u.User != nil {
+		return errors.New("proxy URL must not contain credentials; set them with --proxy-user instead")
+	}
+	return nil
+}
+
+// restoreProxyCredentials loads the proxy credentials saved by
+// SetProxyCredentials, if any.
+func (b *LocalBackend) restoreProxyCredentials() {
+	creds, err := b.store.ReadState(ipn.ProxyCredentialsStateKey)
+	if err != nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.proxyCreds = string(creds)
+}
+
+// SetProxyCredentials sets the "user:password" credentials to use with
+// the proxy in the Proxy pref, or clears them if creds is empty. For
+// NTLM, the user may be of the form "DOMAIN\user".
+//
+// The credentials are kept in the state store rather than the prefs, and
+// no LocalAPI endpoint returns them.
+func (b *LocalBackend) SetProxyCredentials(creds string) error {
+	if creds != "" && !strings.Contains(creds, ":") {
+		return errors.New("proxy credentials must be in the form user:password")
+	}
+	if err := ipn.WriteState(b.store, ipn.ProxyCredentialsStateKey, []byte(creds)); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.proxyCreds = creds
+	b.setProxyFromPrefsLocked(b.pm.CurrentPrefs())
+	return nil
+}
+
+// setProxyFromPrefsLocked applies the Proxy pref and the proxy credentials
+// to tshttpproxy when either changes. Changes made meanwhile through the
+// proxy-config LocalAPI endpoint are left alone until then.
+//
+// b.mu must be held.
+func (b *LocalBackend) setProxyFromPrefsLocked(prefs ipn.PrefsView) {
+	var p ipn.ProxyPrefs
+	if prefs.Valid() {
+		p = prefs.Proxy()
+	}
+	if p == b.proxyPrefs && b.proxyCreds == b.proxyCredsApplied {
+		return
+	}
+	b.proxyPrefs = p
+	b.proxyCredsApplied = b.proxyCreds
+	// SetConfig notifies onProxyConfigChange in a new goroutine, so
+	// it's safe to call with b.mu held.
+	if err := tshttpproxy.SetConfig(b.logf, proxyConfigFromPrefs(p, b.proxyCreds)); err != nil {
+		b.logf("proxy: %v", err)
+	}
+}
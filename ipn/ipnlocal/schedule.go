@@ -0,0 +1,179 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// WantRunningSchedule returns the pending timed change of the WantRunning
+// pref, or nil if there is none.
+func (b *LocalBackend) WantRunningSchedule() *ipn.WantRunningSchedule {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.wantRunningSched == nil {
+		return nil
+	}
+	s := *b.wantRunningSched
+	return &s
+}
+
+// SetWantRunningSchedule replaces the pending timed change of the
+// WantRunning pref with s, or cancels it if s is nil. WantRunning is set to
+// s.WantRunning at s.After, or now if that's zero, and set back at s.Until,
+// if non-zero. The schedule is saved to the state store so that it still
+// happens if tailscaled restarts in the meantime.
+//
+// Changing WantRunning by other means (such as a plain "tailscale up" or
+// "tailscale down") cancels the schedule.
+func (b *LocalBackend) SetWantRunningSchedule(s *ipn.WantRunningSchedule) error {
+	if s != nil {
+		if s.After.IsZero() && s.Until.IsZero() {
+			return errors.New("schedule has neither an After nor an Until time")
+		}
+		if !s.After.IsZero() && !s.Until.IsZero() && !s.Until.After(s.After) {
+			return errors.New("schedule's Until time is not after its After time")
+		}
+		if !s.Until.IsZero() && !s.Until.After(b.clock.Now()) {
+			return errors.New("schedule's Until time is in the past")
+		}
+		s2 := *s
+		s = &s2
+	}
+	b.mu.Lock()
+	if s == nil {
+		b.logf("WantRunning schedule canceled")
+	} else {
+		b.logf("WantRunning schedule: %v after %v until %v", s.WantRunning, s.After, s.Until)
+	}
+	b.setWantRunningScheduleLocked(s)
+	b.runWantRunningScheduleLockedOnEntry()
+	return nil
+}
+
+// restoreWantRunningSchedule loads the schedule saved by
+// SetWantRunningSchedule, if any, and carries on with it.
+func (b *LocalBackend) restoreWantRunningSchedule() {
+	j, err := b.store.ReadState(ipn.WantRunningScheduleStateKey)
+	if err != nil {
+		return
+	}
+	var s *ipn.WantRunningSchedule
+	if err := json.Unmarshal(j, &s); err != nil {
+		b.logf("invalid WantRunning schedule in store: %v", err)
+		return
+	}
+	if s == nil {
+		return
+	}
+	b.mu.Lock()
+	b.wantRunningSched = s
+	b.runWantRunningScheduleLockedOnEntry()
+}
+
+// setWantRunningScheduleLocked sets and saves the WantRunning schedule,
+// stopping the timer for the previous one.
+//
+// b.mu must be held.
+func (b *LocalBackend) setWantRunningScheduleLocked(s *ipn.WantRunningSchedule) {
+	if b.wantRunningTimer != nil {
+		b.wantRunningTimer.Stop()
+		b.wantRunningTimer = nil
+	}
+	b.wantRunningSched = s
+	j, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	if err := b.store.WriteState(ipn.WantRunningScheduleStateKey, j); err != nil {
+		b.logf("failed to save WantRunning schedule: %v", err)
+	}
+}
+
+// runWantRunningScheduleLockedOnEntry makes the change to WantRunning the
+// schedule calls for as of now, if any, and arms a timer for its next
+// change.
+//
+// b.mu must be held on entry; it's released on return.
+func (b *LocalBackend) runWantRunningScheduleLockedOnEntry() {
+	s := b.wantRunningSched
+	if s == nil {
+		b.mu.Unlock()
+		return
+	}
+	if b.wantRunningTimer != nil {
+		b.wantRunningTimer.Stop()
+		b.wantRunningTimer = nil
+	}
+
+	now := b.clock.Now()
+	var want bool
+	switch {
+	case !s.After.IsZero() && now.Before(s.After):
+		b.armWantRunningTimerLocked(s, s.After)
+		b.mu.Unlock()
+		return
+	case !s.Until.IsZero() && !now.Before(s.Until):
+		// The window is over, or it was missed entirely while
+		// tailscaled wasn't running.
+		want = !s.WantRunning
+		b.setWantRunningScheduleLocked(nil)
+	case s.Until.IsZero():
+		want = s.WantRunning
+		b.setWantRunningScheduleLocked(nil)
+	default:
+		want = s.WantRunning
+		if !s.After.IsZero() {
+			next := *s
+			next.After = time.Time{}
+			b.setWantRunningScheduleLocked(&next)
+		}
+		b.armWantRunningTimerLocked(b.wantRunningSched, s.Until)
+	}
+	b.setWantRunningLockedOnEntry(want)
+}
+
+// armWantRunningTimerLocked arranges for the schedule s to be run again at
+// t, if it's still the current schedule then.
+//
+// b.mu must be held.
+func (b *LocalBackend) armWantRunningTimerLocked(s *ipn.WantRunningSchedule, t time.Time) {
+	b.wantRunningTimer = b.clock.AfterFunc(t.Sub(b.clock.Now()), func() {
+		b.mu.Lock()
+		if b.wantRunningSched != s {
+			b.mu.Unlock()
+			return
+		}
+		b.runWantRunningScheduleLockedOnEntry()
+	})
+}
+
+// setWantRunningLockedOnEntry sets the WantRunning pref to want, as
+// scheduled by SetWantRunningSchedule.
+//
+// b.mu must be held on entry; it's released on return.
+func (b *LocalBackend) setWantRunningLockedOnEntry(want bool) {
+	p := b.pm.CurrentPrefs()
+	if !p.Valid() || p.WantRunning() == want {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("WantRunning schedule: setting WantRunning=%v", want)
+	np := p.AsStruct()
+	np.WantRunning = want
+	if b.cc == nil {
+		// Not started yet; Start picks up the saved prefs.
+		if err := b.pm.SetPrefs(np.View()); err != nil {
+			b.logf("failed to save prefs: %v", err)
+		}
+		b.notePrefsChangedLocked(p, ipn.PrefSourceSchedule)
+		b.mu.Unlock()
+		return
+	}
+	b.setPrefsLockedOnEntry("WantRunningSchedule", ipn.PrefSourceSchedule, np)
+}
@@ -41,6 +41,10 @@ type serveHTTPContext struct {
 	DestPort uint16
 }
 
+// serveHandlerContextKey is the context.Value key for the ipn.HTTPHandlerView
+// serving a request proxied by serve.
+type serveHandlerContextKey struct{}
+
 // serveListener is the state of host-level net.Listen for a specific (Tailscale IP, serve port)
 // combination. If there are two TailscaleIPs (v4 and v6) and three ports being served,
 // then there will be six of these active and looping in their Run method.
@@ -221,6 +225,9 @@ func (b *LocalBackend) SetServeConfig(config *ipn.ServeConfig) error {
 }
 
 func (b *LocalBackend) setServeConfigLocked(config *ipn.ServeConfig) error {
+	if b.conf != nil && b.conf.Parsed.ServeConfigTemp != nil {
+		return fmt.Errorf("serve config is managed by config file %s", b.conf.Path)
+	}
 	prefs := b.pm.CurrentPrefs()
 	if config.IsFunnelOn() && prefs.ShieldsUp() {
 		return errors.New("Unable to turn on Funnel while shields-up is enabled")
@@ -312,7 +319,7 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target
 				b.logf("localbackend: getConn didn't complete from %v to port %v", srcAddr, dport)
 				return
 			}
-			handler(c)
+			handler(b.countFunnelConn(c, target))
 			return
 		}
 	}
@@ -328,7 +335,7 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer tailcfg.NodeView, target
 		b.logf("localbackend: getConn didn't complete from %v to port %v", srcAddr, dport)
 		return
 	}
-	handler(c)
+	handler(b.countFunnelConn(c, target))
 }
 
 // tcpHandlerForServe returns a handler for a TCP connection to be served via
@@ -368,6 +375,10 @@ func (b *LocalBackend) tcpHandlerForServe(dport uint16, srcAddr netip.AddrPort)
 			}
 		}
 
+		// Accept cleartext HTTP/2 too, for gRPC clients.
+		if addH2C != nil {
+			addH2C(hs)
+		}
 		return func(c net.Conn) error {
 			return hs.Serve(netutil.NewOneConnListener(c, nil))
 		}
@@ -471,37 +482,146 @@ func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView,
 	}
 }
 
+// serveProxyKey is the key of LocalBackend.serveProxyHandlers: a
+// HTTPHandler.Proxy backend along with the handler options that change how
+// requests are proxied to it.
+type serveProxyKey struct {
+	backend     string
+	timeout     int // HTTPHandler.ProxyTimeout
+	idleTimeout int // HTTPHandler.ProxyIdleTimeout
+	keepAlive   int // HTTPHandler.ProxyKeepAlive
+	bufferSize  int // HTTPHandler.ProxyBufferSize
+}
+
+func serveProxyKeyOf(h ipn.HTTPHandlerView) serveProxyKey {
+	return serveProxyKey{
+		backend:     h.Proxy(),
+		timeout:     h.ProxyTimeout(),
+		idleTimeout: h.ProxyIdleTimeout(),
+		keepAlive:   h.ProxyKeepAlive(),
+		bufferSize:  h.ProxyBufferSize(),
+	}
+}
+
+// newH2CTransport, if non-nil, returns a RoundTripper that speaks cleartext
+// HTTP/2 to backends, dialing them with dial. If responseTimeout is
+// non-zero, requests fail if the backend doesn't send response headers
+// within it.
+var newH2CTransport func(dial func(ctx context.Context, network, addr string) (net.Conn, error), responseTimeout, idleTimeout time.Duration) http.RoundTripper
+
 // proxyHandlerForBackend creates a new HTTP reverse proxy for a particular backend that
-// we serve requests for. `backend` is a HTTPHandler.Proxy string (url, hostport or just port).
-func (b *LocalBackend) proxyHandlerForBackend(backend string) (*httputil.ReverseProxy, error) {
-	targetURL, insecure := expandProxyArg(backend)
+// we serve requests for. `k.backend` is a HTTPHandler.Proxy string (url, hostport or just port).
+//
+// Upgraded connections (WebSockets) and streaming responses (SSE, gRPC) are
+// proxied as they come; the proxy flushes such responses to the client
+// as soon as the backend writes them.
+func (b *LocalBackend) proxyHandlerForBackend(k serveProxyKey) (*httputil.ReverseProxy, error) {
+	targetURL, insecure := expandProxyArg(k.backend)
 	u, err := url.Parse(targetURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid url %s: %w", targetURL, err)
 	}
-	rp := &httputil.ReverseProxy{
-		Rewrite: func(r *httputil.ProxyRequest) {
-			r.SetURL(u)
-			r.Out.Host = r.In.Host
-			addProxyForwardedHeaders(r)
-			b.addTailscaleIdentityHeaders(r)
-		},
-		Transport: &http.Transport{
-			DialContext: b.dialer.SystemDial,
+	responseTimeout := time.Duration(k.timeout) * time.Second
+	idleTimeout := 90 * time.Second // as in http.DefaultTransport
+	if k.idleTimeout > 0 {
+		idleTimeout = time.Duration(k.idleTimeout) * time.Second
+	}
+	dial := b.dialer.SystemDial
+	if k.keepAlive != 0 {
+		dial = dialWithKeepAlive(dial, time.Duration(k.keepAlive)*time.Second)
+	}
+
+	var tr http.RoundTripper
+	if u.Scheme == "h2c" {
+		if newH2CTransport == nil {
+			return nil, fmt.Errorf("h2c backends are not supported on this platform")
+		}
+		u.Scheme = "http"
+		tr = newH2CTransport(dial, responseTimeout, idleTimeout)
+	} else {
+		tr = &http.Transport{
+			DialContext: dial,
 			TLSClientConfig: &tls.Config{
 				InsecureSkipVerify: insecure,
 			},
+			ResponseHeaderTimeout: responseTimeout,
+			IdleConnTimeout:       idleTimeout,
+			ReadBufferSize:        k.bufferSize,
+			WriteBufferSize:       k.bufferSize,
 			// Values for the following parameters have been copied from http.DefaultTransport.
 			ForceAttemptHTTP2:     true,
 			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
+		}
+	}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(u)
+			r.Out.Host = r.In.Host
+			addProxyForwardedHeaders(r)
+			b.addTailscaleIdentityHeaders(r)
 		},
+		Transport: tr,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			b.serveProxyError(w, r, k.backend, err)
+		},
+	}
+	if k.bufferSize > 0 {
+		rp.BufferPool = newProxyBufferPool(k.bufferSize)
 	}
 	return rp, nil
 }
 
+// dialWithKeepAlive returns a dial func that dials with dial and sets the
+// TCP keep-alive period of the resulting connection to d. Negative values
+// of d disable keep-alives.
+func dialWithKeepAlive(dial func(ctx context.Context, network, addr string) (net.Conn, error), d time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		nc := c
+		if u, ok := nc.(interface{ NetConn() net.Conn }); ok {
+			nc = u.NetConn()
+		}
+		if tc, ok := nc.(*net.TCPConn); ok {
+			if d < 0 {
+				tc.SetKeepAlive(false)
+			} else {
+				tc.SetKeepAlive(true)
+				tc.SetKeepAlivePeriod(d)
+			}
+		}
+		return c, nil
+	}
+}
+
+// proxyBufferPool is an httputil.BufferPool of buffers of a fixed size.
+type proxyBufferPool struct {
+	size int
+	pool sync.Pool // of *[]byte
+}
+
+func newProxyBufferPool(size int) *proxyBufferPool {
+	return &proxyBufferPool{size: size}
+}
+
+func (p *proxyBufferPool) Get() []byte {
+	if b, ok := p.pool.Get().(*[]byte); ok {
+		return *b
+	}
+	return make([]byte, p.size)
+}
+
+func (p *proxyBufferPool) Put(b []byte) {
+	if len(b) != p.size {
+		return
+	}
+	p.pool.Put(&b)
+}
+
 func addProxyForwardedHeaders(r *httputil.ProxyRequest) {
 	r.Out.Header.Set("X-Forwarded-Host", r.In.Host)
 	if r.In.TLS != nil {
@@ -514,9 +634,9 @@ func addProxyForwardedHeaders(r *httputil.ProxyRequest) {
 
 func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 	// Clear any incoming values squatting in the headers.
-	r.Out.Header.Del("Tailscale-User-Login")
-	r.Out.Header.Del("Tailscale-User-Name")
-	r.Out.Header.Del("Tailscale-User-Profile-Pic")
+	for _, k := range ipn.ServeIdentityHeaders {
+		r.Out.Header.Del(k)
+	}
 	r.Out.Header.Del("Tailscale-Headers-Info")
 
 	c, ok := getServeHTTPContext(r.Out)
@@ -532,10 +652,37 @@ func (b *LocalBackend) addTailscaleIdentityHeaders(r *httputil.ProxyRequest) {
 		// Only currently set for nodes with user identities.
 		return
 	}
-	r.Out.Header.Set("Tailscale-User-Login", user.LoginName)
-	r.Out.Header.Set("Tailscale-User-Name", user.DisplayName)
-	r.Out.Header.Set("Tailscale-User-Profile-Pic", user.ProfilePicURL)
-	r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+	h, _ := r.Out.Context().Value(serveHandlerContextKey{}).(ipn.HTTPHandlerView)
+	var added bool
+	for k, v := range map[string]string{
+		"Tailscale-User-Login":       user.LoginName,
+		"Tailscale-User-Name":        user.DisplayName,
+		"Tailscale-User-Profile-Pic": user.ProfilePicURL,
+	} {
+		if !h.Valid() || h.WantsIdentityHeader(k) {
+			r.Out.Header.Set(k, v)
+			added = true
+		}
+	}
+	if added {
+		r.Out.Header.Set("Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers")
+	}
+}
+
+// serveAllowedFrom reports whether h's AllowFrom list permits the request r.
+func (b *LocalBackend) serveAllowedFrom(h ipn.HTTPHandlerView, r *http.Request) bool {
+	if h.AllowFrom().Len() == 0 {
+		return true
+	}
+	c, ok := getServeHTTPContext(r)
+	if !ok {
+		return false
+	}
+	node, user, ok := b.WhoIs(c.SrcAddr)
+	if !ok {
+		return false // traffic from outside of Tailnet (funneled)
+	}
+	return h.AllowsFrom(node, user)
 }
 
 // serveWebHandler is an http.HandlerFunc that maps incoming requests to the
@@ -546,21 +693,33 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if !b.serveAllowedFrom(h, r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
 		return
 	}
-	if v := h.Path(); v != "" {
-		b.serveFileOrDirectory(w, r, v, mountPoint)
+	if h.Path() != "" {
+		b.serveFileOrDirectory(w, r, h, mountPoint)
 		return
 	}
 	if v := h.Proxy(); v != "" {
-		p, ok := b.serveProxyHandlers.Load(v)
+		k := serveProxyKeyOf(h)
+		backend, healthy := b.serveProxyBackend(h)
+		if !healthy && h.Maintenance() != "" {
+			serveMaintenancePage(w, h)
+			return
+		}
+		k.backend = backend
+		p, ok := b.serveProxyHandlers.Load(k)
 		if !ok {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
+		r = r.WithContext(context.WithValue(r.Context(), serveHandlerContextKey{}, h))
 		h := p.(http.Handler)
 		// Trim the mount point from the URL path before proxying. (#6571)
 		if r.URL.Path != "/" {
@@ -573,7 +732,10 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "empty handler", 500)
 }
 
-func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
+// serveFileOrDirectory serves the file or directory at h.Path, mounted at
+// mountPoint.
+func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, h ipn.HTTPHandlerView, mountPoint string) {
+	fileOrDir := h.Path()
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -594,6 +756,9 @@ func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Reque
 			return
 		}
 		defer f.Close()
+		if age := h.CacheMaxAge(); age > 0 {
+			w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", age))
+		}
 		http.ServeContent(w, r, path.Base(mountPoint), fi.ModTime(), f)
 		return
 	}
@@ -606,38 +771,79 @@ func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	var fs http.Handler = http.FileServer(http.Dir(fileOrDir))
+	var dir http.FileSystem = http.Dir(fileOrDir)
+	if h.NoDirList() {
+		dir = noDirListFS{dir}
+	}
+	var fs http.Handler = http.FileServer(dir)
 	if mountPoint != "/" {
 		fs = http.StripPrefix(strings.TrimSuffix(mountPoint, "/"), fs)
 	}
 	fs.ServeHTTP(&fixLocationHeaderResponseWriter{
 		ResponseWriter: w,
 		mountPoint:     mountPoint,
+		cacheMaxAge:    h.CacheMaxAge(),
 	}, r)
 }
 
+// noDirListFS is an http.FileSystem that pretends directories without an
+// index.html file don't exist, so http.FileServer doesn't list them.
+type noDirListFS struct {
+	http.FileSystem
+}
+
+func (fs noDirListFS) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		idx, err := fs.FileSystem.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		idx.Close()
+	}
+	return f, nil
+}
+
 // fixLocationHeaderResponseWriter is an http.ResponseWriter wrapper that, upon
-// flushing HTTP headers, prefixes any Location header with the mount point.
+// flushing HTTP headers, prefixes any Location header with the mount point
+// and, for successful responses, adds a Cache-Control header if cacheMaxAge
+// is set.
 type fixLocationHeaderResponseWriter struct {
 	http.ResponseWriter
-	mountPoint string
-	fixOnce    sync.Once // guards call to fix
+	mountPoint  string
+	cacheMaxAge int       // seconds; or 0 for no Cache-Control header
+	fixOnce     sync.Once // guards call to fix
 }
 
-func (w *fixLocationHeaderResponseWriter) fix() {
+func (w *fixLocationHeaderResponseWriter) fix(code int) {
 	h := w.ResponseWriter.Header()
 	if v := h.Get("Location"); v != "" {
 		h.Set("Location", w.mountPoint+v)
 	}
+	switch code {
+	case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+		if w.cacheMaxAge > 0 {
+			h.Set("Cache-Control", fmt.Sprintf("max-age=%d", w.cacheMaxAge))
+		}
+	}
 }
 
 func (w *fixLocationHeaderResponseWriter) WriteHeader(code int) {
-	w.fixOnce.Do(w.fix)
+	w.fixOnce.Do(func() { w.fix(code) })
 	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *fixLocationHeaderResponseWriter) Write(p []byte) (int, error) {
-	w.fixOnce.Do(w.fix)
+	w.fixOnce.Do(func() { w.fix(http.StatusOK) })
 	return w.ResponseWriter.Write(p)
 }
 
@@ -651,7 +857,7 @@ func expandProxyArg(s string) (targetURL string, insecureSkipVerify bool) {
 	if s == "" {
 		return "", false
 	}
-	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") {
+	if strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "h2c://") {
 		return s, false
 	}
 	if rest, ok := strings.CutPrefix(s, "https+insecure://"); ok {
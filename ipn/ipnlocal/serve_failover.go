@@ -0,0 +1,193 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// defaultServeHealthCheckInterval is the interval between health checks of
+// serve backends if HTTPHandler.HealthCheckInterval isn't set.
+const defaultServeHealthCheckInterval = 10 * time.Second
+
+// serveHealthKey is the key of LocalBackend.serveHealthCheckers: a
+// HTTPHandler.Proxy or HTTPHandler.Fallbacks backend along with how it's
+// health checked.
+type serveHealthKey struct {
+	backend  string
+	path     string // HTTPHandler.HealthCheck
+	interval time.Duration
+}
+
+func serveHealthKeyOf(h ipn.HTTPHandlerView, backend string) serveHealthKey {
+	k := serveHealthKey{
+		backend:  backend,
+		path:     h.HealthCheck(),
+		interval: defaultServeHealthCheckInterval,
+	}
+	if h.HealthCheckInterval() > 0 {
+		k.interval = time.Duration(h.HealthCheckInterval()) * time.Second
+	}
+	return k
+}
+
+// serveHealthChecker periodically checks whether a serve backend is
+// healthy, until its context is canceled.
+type serveHealthChecker struct {
+	b       *LocalBackend
+	k       serveHealthKey
+	cancel  context.CancelFunc
+	client  *http.Client
+	healthy atomic.Bool // backends are healthy until they fail a check
+}
+
+// startServeHealthChecker starts health checking the backend of k.
+func (b *LocalBackend) startServeHealthChecker(k serveHealthKey) *serveHealthChecker {
+	targetURL, insecure := expandProxyArg(k.backend)
+	ctx, cancel := context.WithCancel(b.ctx)
+	c := &serveHealthChecker{
+		b:      b,
+		k:      k,
+		cancel: cancel,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:       b.dialer.SystemDial,
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: insecure},
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	c.healthy.Store(true)
+	go c.run(ctx, targetURL)
+	return c
+}
+
+func (c *serveHealthChecker) run(ctx context.Context, targetURL string) {
+	t := time.NewTicker(c.k.interval)
+	defer t.Stop()
+	for {
+		c.setHealthy(c.check(ctx, targetURL))
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check returns an error if the backend at targetURL isn't healthy.
+func (c *serveHealthChecker) check(ctx context.Context, targetURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, min(c.k.interval, 5*time.Second))
+	defer cancel()
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	if c.k.path == "" || u.Scheme == "h2c" {
+		host := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+		conn, err := c.b.dialer.SystemDial(ctx, "tcp", host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+	u.Path = c.k.path
+	u.RawQuery = ""
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4<<10))
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 400 {
+		return fmt.Errorf("health check %s: %v", c.k.path, res.Status)
+	}
+	return nil
+}
+
+// setHealthy records the result err of checking or proxying to c's
+// backend, logging when it becomes unhealthy or healthy again.
+func (c *serveHealthChecker) setHealthy(err error) {
+	healthy := err == nil
+	if c.healthy.Swap(healthy) == healthy {
+		return
+	}
+	if healthy {
+		c.b.logf("serve: backend %s is healthy again", c.k.backend)
+	} else {
+		c.b.logf("serve: backend %s is unhealthy: %v", c.k.backend, err)
+	}
+}
+
+// serveProxyBackend returns the backend to proxy the requests of h to: the
+// first of h.ProxyBackends that's healthy. If none is, it returns h.Proxy
+// and false.
+func (b *LocalBackend) serveProxyBackend(h ipn.HTTPHandlerView) (backend string, healthy bool) {
+	if !h.HealthChecked() {
+		return h.Proxy(), true
+	}
+	for _, backend := range h.ProxyBackends() {
+		c, ok := b.serveHealthCheckers.Load(serveHealthKeyOf(h, backend))
+		if !ok || c.(*serveHealthChecker).healthy.Load() {
+			return backend, true
+		}
+	}
+	return h.Proxy(), false
+}
+
+// serveProxyError handles the error err proxying r to backend. If the
+// request's handler is health checked, backend is marked unhealthy until
+// it next passes its health check, and the handler's maintenance page, if
+// any, is served. Otherwise, it responds with a 502 Bad Gateway, as
+// httputil.ReverseProxy does by default.
+func (b *LocalBackend) serveProxyError(w http.ResponseWriter, r *http.Request, backend string, err error) {
+	b.logf("serve: proxy error: %v", err)
+	h, _ := r.Context().Value(serveHandlerContextKey{}).(ipn.HTTPHandlerView)
+	if h.Valid() && h.HealthChecked() {
+		if c, ok := b.serveHealthCheckers.Load(serveHealthKeyOf(h, backend)); ok && !errors.Is(err, context.Canceled) {
+			c.(*serveHealthChecker).setHealthy(err)
+		}
+		if h.Maintenance() != "" {
+			serveMaintenancePage(w, h)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// serveMaintenancePage responds with h's maintenance page.
+func serveMaintenancePage(w http.ResponseWriter, h ipn.HTTPHandlerView) {
+	retry := serveHealthKeyOf(h, "").interval
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(retry/time.Second)))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, h.Maintenance())
+}
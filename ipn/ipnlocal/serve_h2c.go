@@ -0,0 +1,40 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !ios && !android && !js
+
+package ipnlocal
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func init() {
+	newH2CTransport = func(dial func(ctx context.Context, network, addr string) (net.Conn, error), responseTimeout, idleTimeout time.Duration) http.RoundTripper {
+		// The http2.Transport takes its timeouts from the http.Transport
+		// it's configured on; t1 itself is never used to send requests.
+		t1 := &http.Transport{
+			ResponseHeaderTimeout: responseTimeout,
+			IdleConnTimeout:       idleTimeout,
+		}
+		t2, err := http2.ConfigureTransports(t1)
+		if err != nil {
+			// Only fails if t1 was already configured for HTTP/2.
+			panic(err)
+		}
+		// ConfigureTransports leaves t2 expecting t1 to dial its
+		// connections; have it dial its own instead.
+		t2.ConnPool = nil
+		t2.AllowHTTP = true
+		t2.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return dial(ctx, network, addr)
+		}
+		return t2
+	}
+}
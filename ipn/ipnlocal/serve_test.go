@@ -4,10 +4,13 @@
 package ipnlocal
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
@@ -15,10 +18,15 @@
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/tsdial"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/types/logid"
@@ -44,6 +52,7 @@ type res struct {
 		{"http://foo.com", res{"http://foo.com", false}},
 		{"https://foo.com", res{"https://foo.com", false}},
 		{"https+insecure://10.2.3.4", res{"https://10.2.3.4", true}},
+		{"h2c://localhost:50051", res{"h2c://localhost:50051", false}},
 	}
 	for _, tt := range tests {
 		target, insecure := expandProxyArg(tt.in)
@@ -229,6 +238,19 @@ func(w http.ResponseWriter, r *http.Request) {
 		Web: map[ipn.HostPort]*ipn.WebServerConfig{
 			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
 				"/": {Proxy: testServ.URL},
+				"/tagged/": {
+					Proxy:     testServ.URL,
+					AllowFrom: []string{"tag:server"},
+				},
+				"/login-only/": {
+					Proxy:           testServ.URL,
+					AllowFrom:       []string{"*@example.com"},
+					IdentityHeaders: []string{"Tailscale-User-Login"},
+				},
+				"/no-headers/": {
+					Proxy:           testServ.URL,
+					IdentityHeaders: []string{"none"},
+				},
 			}},
 		},
 	}
@@ -244,6 +266,8 @@ type headerCheck struct {
 	tests := []struct {
 		name        string
 		srcIP       string
+		path        string // or empty for "/"
+		wantCode    int    // or zero for 200
 		wantHeaders []headerCheck
 	}{
 		{
@@ -282,12 +306,66 @@ type headerCheck struct {
 				{"Tailscale-Headers-Info", ""},
 			},
 		},
+		{
+			name:     "allow-from-tag-denies-user",
+			srcIP:    "100.150.151.152",
+			path:     "/tagged/",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:  "allow-from-tag-permits-tagged-node",
+			srcIP: "100.150.151.153",
+			path:  "/tagged/",
+			wantHeaders: []headerCheck{
+				{"X-Forwarded-For", "100.150.151.153"},
+				{"Tailscale-User-Login", ""},
+			},
+		},
+		{
+			name:     "allow-from-denies-outside-tailnet",
+			srcIP:    "100.160.161.162",
+			path:     "/tagged/",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:  "allow-from-domain-with-some-headers",
+			srcIP: "100.150.151.152",
+			path:  "/login-only/",
+			wantHeaders: []headerCheck{
+				{"Tailscale-User-Login", "someone@example.com"},
+				{"Tailscale-User-Name", ""},
+				{"Tailscale-User-Profile-Pic", ""},
+				{"Tailscale-Headers-Info", "https://tailscale.com/s/serve-headers"},
+			},
+		},
+		{
+			name:     "allow-from-domain-denies-tagged-node",
+			srcIP:    "100.150.151.153",
+			path:     "/login-only/",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:  "no-identity-headers",
+			srcIP: "100.150.151.152",
+			path:  "/no-headers/",
+			wantHeaders: []headerCheck{
+				{"X-Forwarded-For", "100.150.151.152"},
+				{"Tailscale-User-Login", ""},
+				{"Tailscale-User-Name", ""},
+				{"Tailscale-User-Profile-Pic", ""},
+				{"Tailscale-Headers-Info", ""},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/"
+			}
 			req := &http.Request{
-				URL: &url.URL{Path: "/"},
+				URL: &url.URL{Path: path},
 				TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
 			}
 			req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
@@ -298,6 +376,14 @@ type headerCheck struct {
 			w := httptest.NewRecorder()
 			b.serveWebHandler(w, req)
 
+			wantCode := tt.wantCode
+			if wantCode == 0 {
+				wantCode = http.StatusOK
+			}
+			if got := w.Result().StatusCode; got != wantCode {
+				t.Fatalf("status = %d; want %d", got, wantCode)
+			}
+
 			// Verify the headers.
 			h := w.Result().Header
 			for _, c := range tt.wantHeaders {
@@ -309,6 +395,207 @@ type headerCheck struct {
 	}
 }
 
+func TestServeProxyOptions(t *testing.T) {
+	b := &LocalBackend{logf: t.Logf, dialer: &tsdial.Dialer{Logf: t.Logf}}
+	backend := func(h http.Handler) serveProxyKey {
+		s := httptest.NewServer(h)
+		t.Cleanup(s.Close)
+		return serveProxyKey{backend: s.Listener.Addr().String()}
+	}
+	proxy := func(k serveProxyKey) *httptest.Server {
+		rp, err := b.proxyHandlerForBackend(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := httptest.NewServer(rp)
+		t.Cleanup(s.Close)
+		return s
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		k := backend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}))
+		k.timeout = 1
+		res, err := http.Get(proxy(k).URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadGateway {
+			t.Errorf("status = %v; want %v", res.StatusCode, http.StatusBadGateway)
+		}
+	})
+
+	t.Run("h2c", func(t *testing.T) {
+		h := h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, r.Proto+" ")
+			io.Copy(w, r.Body)
+		}), &http2.Server{})
+		k := backend(h)
+		k.backend = "h2c://" + k.backend
+		k.bufferSize = 1024
+		body := strings.Repeat("x", 5000)
+		res, err := http.Post(proxy(k).URL, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "HTTP/2.0 " + body; string(got) != want {
+			t.Errorf("got %.20q (len %d); want %.20q (len %d)", got, len(got), want, len(want))
+		}
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		// An echo server speaking just enough of the WebSocket upgrade
+		// handshake to switch protocols.
+		k := backend(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Upgrade") != "websocket" {
+				http.Error(w, "not an upgrade", http.StatusBadRequest)
+				return
+			}
+			c, brw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			io.WriteString(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+			brw.Flush()
+			io.Copy(c, brw)
+		}))
+		k.keepAlive = 30
+		c, err := net.Dial("tcp", proxy(k).Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		io.WriteString(c, "GET / HTTP/1.1\r\nHost: example.ts.net\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		br := bufio.NewReader(c)
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("status = %v; want %v", res.StatusCode, http.StatusSwitchingProtocols)
+		}
+		for _, msg := range []string{"ping", "pong"} {
+			io.WriteString(c, msg)
+			got := make([]byte, len(msg))
+			if _, err := io.ReadFull(br, got); err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != msg {
+				t.Errorf("echo = %q; want %q", got, msg)
+			}
+		}
+	})
+}
+
+func TestServeFailover(t *testing.T) {
+	sys := &tsd.System{}
+	e, err := wgengine.NewUserspaceEngine(t.Logf, wgengine.Config{SetSubsystem: sys.Set})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Set(e)
+	sys.Set(new(mem.Store))
+	b, err := NewLocalBackend(t.Logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Shutdown()
+	pm := must.Get(newProfileManager(new(mem.Store), t.Logf))
+	pm.currentProfile = &ipn.LoginProfile{ID: "id0"}
+	b.pm = pm
+	b.netMap = &netmap.NetworkMap{
+		SelfNode: (&tailcfg.Node{Name: "example.ts.net"}).View(),
+	}
+
+	var primaryDown atomic.Bool
+	backend := func(name string, down *atomic.Bool) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if down != nil && down.Load() {
+				http.Error(w, "deploying", http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, name)
+		}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	primary := backend("primary", &primaryDown)
+	fallback := backend("fallback", nil)
+
+	conf := &ipn.ServeConfig{
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"example.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/": {
+					Proxy:               primary.URL,
+					Fallbacks:           []string{fallback.URL},
+					HealthCheck:         "/healthz",
+					HealthCheckInterval: 1,
+					Maintenance:         "<h1>Back soon</h1>",
+				},
+			}},
+		},
+	}
+	if err := b.SetServeConfig(conf); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() (code int, body string) {
+		req := &http.Request{
+			URL: &url.URL{Path: "/"},
+			TLS: &tls.ConnectionState{ServerName: "example.ts.net"},
+		}
+		req = req.WithContext(context.WithValue(req.Context(), serveHTTPContextKey{}, &serveHTTPContext{
+			DestPort: 443,
+			SrcAddr:  netip.MustParseAddrPort("100.150.151.152:1234"),
+		}))
+		w := httptest.NewRecorder()
+		b.serveWebHandler(w, req)
+		return w.Code, w.Body.String()
+	}
+	waitFor := func(wantCode int, wantBody string) {
+		t.Helper()
+		var code int
+		var body string
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if code, body = get(); code == wantCode && body == wantBody {
+				return
+			}
+		}
+		t.Fatalf("got %d %q; want %d %q", code, body, wantCode, wantBody)
+	}
+
+	waitFor(http.StatusOK, "primary")
+	primaryDown.Store(true)
+	waitFor(http.StatusOK, "fallback")
+	primaryDown.Store(false)
+	waitFor(http.StatusOK, "primary")
+
+	// A failure to proxy serves the maintenance page and fails over
+	// without waiting for the next health check.
+	primary.Close()
+	if code, body := get(); code != http.StatusServiceUnavailable || body != "<h1>Back soon</h1>" {
+		t.Errorf("proxy error: got %d %q; want the maintenance page", code, body)
+	}
+	if code, body := get(); code != http.StatusOK || body != "fallback" {
+		t.Errorf("after proxy error: got %d %q; want fallback", code, body)
+	}
+
+	fallback.Close()
+	waitFor(http.StatusServiceUnavailable, "<h1>Back soon</h1>")
+}
+
 func TestServeFileOrDirectory(t *testing.T) {
 	td := t.TempDir()
 	writeFile := func(suffix, contents string) {
@@ -388,7 +675,7 @@ func TestServeFileOrDirectory(t *testing.T) {
 	for _, tt := range tests {
 		rec := httptest.NewRecorder()
 		req := httptest.NewRequest("GET", tt.req, nil)
-		b.serveFileOrDirectory(rec, req, td, tt.mount)
+		b.serveFileOrDirectory(rec, req, (&ipn.HTTPHandler{Path: td}).View(), tt.mount)
 		if tt.want == nil {
 			t.Errorf("no want for path %q", tt.req)
 			return
@@ -398,3 +685,53 @@ func TestServeFileOrDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestServeFileOrDirectoryOptions(t *testing.T) {
+	td := t.TempDir()
+	writeFile := func(suffix, contents string) {
+		if err := os.WriteFile(filepath.Join(td, suffix), []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.MkdirAll(filepath.Join(td, "site"), 0700)
+	os.MkdirAll(filepath.Join(td, "files"), 0700)
+	writeFile("site/index.html", "welcome")
+	writeFile("files/data", "0123456789")
+
+	b := &LocalBackend{}
+	h := (&ipn.HTTPHandler{Path: td, NoDirList: true, CacheMaxAge: 60}).View()
+
+	tests := []struct {
+		req       string
+		rangeHdr  string
+		wantCode  int
+		wantBody  string
+		wantCache string // wanted Cache-Control header
+	}{
+		{req: "/", wantCode: 404},
+		{req: "/files/", wantCode: 404},
+		{req: "/site/", wantCode: 200, wantBody: "welcome", wantCache: "max-age=60"},
+		{req: "/files/data", wantCode: 200, wantBody: "0123456789", wantCache: "max-age=60"},
+		{req: "/files/data", rangeHdr: "bytes=2-4", wantCode: 206, wantBody: "234", wantCache: "max-age=60"},
+		{req: "/files/missing", wantCode: 404},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", tt.req, nil)
+		if tt.rangeHdr != "" {
+			req.Header.Set("Range", tt.rangeHdr)
+		}
+		b.serveFileOrDirectory(rec, req, h, "/")
+		res := rec.Result()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("%s (Range %q): status = %d; want %d", tt.req, tt.rangeHdr, res.StatusCode, tt.wantCode)
+			continue
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("%s (Range %q): body = %q; want %q", tt.req, tt.rangeHdr, rec.Body.String(), tt.wantBody)
+		}
+		if got := res.Header.Get("Cache-Control"); got != tt.wantCache {
+			t.Errorf("%s (Range %q): Cache-Control = %q; want %q", tt.req, tt.rangeHdr, got, tt.wantCache)
+		}
+	}
+}
@@ -23,6 +23,7 @@
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tailscale/golang-x-crypto/ssh"
 	"go4.org/mem"
@@ -108,7 +109,25 @@ func (b *LocalBackend) getSSHUsernames(req *tailcfg.C2NSSHUsernamesRequest) (*ta
 	return res, nil
 }
 
+// GetSSH_HostKeys returns the SSH host keys for Tailscale SSH, followed by
+// certificate signers for those keys that control issued host certificates
+// for.
+//
+// It must not be called with b.mu held.
 func (b *LocalBackend) GetSSH_HostKeys() (keys []ssh.Signer, err error) {
+	keys, err = b.getSSH_HostKeys()
+	if err != nil {
+		return nil, err
+	}
+	if nm := b.NetMap(); nm != nil && nm.SSHHostCerts != nil {
+		keys = append(keys, b.sshHostCertSigners(keys, nm.SSHHostCerts.Certificates, time.Now())...)
+	}
+	return keys, nil
+}
+
+// getSSH_HostKeys returns the SSH host keys for Tailscale SSH, without any
+// host certificates.
+func (b *LocalBackend) getSSH_HostKeys() ([]ssh.Signer, error) {
 	var existing map[string]ssh.Signer
 	if os.Geteuid() == 0 {
 		existing = b.getSystemSSH_HostKeys()
@@ -116,6 +135,43 @@ func (b *LocalBackend) GetSSH_HostKeys() (keys []ssh.Signer, err error) {
 	return b.getTailscaleSSH_HostKeys(existing)
 }
 
+// sshHostCertSigners returns signers that present the host certificates in
+// certs (in authorized_keys format) for the matching keys. Certificates that
+// are malformed, aren't host certificates, aren't valid at now, or don't
+// match any of keys are skipped.
+func (b *LocalBackend) sshHostCertSigners(keys []ssh.Signer, certs []string, now time.Time) (ret []ssh.Signer) {
+	for _, certStr := range certs {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(certStr))
+		if err != nil {
+			b.logf("ssh: invalid host certificate from control: %v", err)
+			continue
+		}
+		cert, ok := pub.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			b.logf("ssh: ignoring non-host-certificate %q from control", pub.Type())
+			continue
+		}
+		unix := uint64(now.Unix())
+		if unix < cert.ValidAfter || (cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore) {
+			continue
+		}
+		certKey := cert.Key.Marshal()
+		for _, k := range keys {
+			if !bytes.Equal(k.PublicKey().Marshal(), certKey) {
+				continue
+			}
+			cs, err := ssh.NewCertSigner(cert, k)
+			if err != nil {
+				b.logf("ssh: host certificate: %v", err)
+				break
+			}
+			ret = append(ret, cs)
+			break
+		}
+	}
+	return ret
+}
+
 // getTailscaleSSH_HostKeys returns the three (rsa, ecdsa, ed25519) SSH host
 // keys, reusing the provided ones in existing if present in the map.
 func (b *LocalBackend) getTailscaleSSH_HostKeys(existing map[string]ssh.Signer) (keys []ssh.Signer, err error) {
@@ -211,7 +267,7 @@ func (b *LocalBackend) getSystemSSH_HostKeys() (ret map[string]ssh.Signer) {
 }
 
 func (b *LocalBackend) getSSHHostKeyPublicStrings() (ret []string) {
-	signers, _ := b.GetSSH_HostKeys()
+	signers, _ := b.getSSH_HostKeys()
 	for _, signer := range signers {
 		ret = append(ret, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))))
 	}
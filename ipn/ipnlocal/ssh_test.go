@@ -6,10 +6,14 @@
 package ipnlocal
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/tailscale/golang-x-crypto/ssh"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/tailcfg"
 	"tailscale.com/util/must"
@@ -44,6 +48,55 @@ func TestSSHKeyGen(t *testing.T) {
 	}
 }
 
+func TestSSHHostCertSigners(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return must.Get(ssh.NewSignerFromKey(priv))
+	}
+	ca := newSigner()
+	hostKey := newSigner()
+	otherKey := newSigner()
+
+	now := time.Now()
+	certFor := func(k ssh.Signer, certType uint32, validBefore time.Time) string {
+		cert := &ssh.Certificate{
+			Key:             k.PublicKey(),
+			CertType:        certType,
+			ValidPrincipals: []string{"foo.tailnet.ts.net"},
+			ValidAfter:      uint64(now.Add(-time.Hour).Unix()),
+			ValidBefore:     uint64(validBefore.Unix()),
+		}
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return string(ssh.MarshalAuthorizedKey(cert))
+	}
+
+	b := &LocalBackend{logf: t.Logf}
+	certs := []string{
+		"garbage",
+		certFor(hostKey, ssh.UserCert, now.Add(time.Hour)),    // not a host cert
+		certFor(hostKey, ssh.HostCert, now.Add(-time.Minute)), // expired
+		certFor(otherKey, ssh.HostCert, now.Add(time.Hour)),   // unknown key
+		certFor(hostKey, ssh.HostCert, now.Add(time.Hour)),    // good
+		string(ssh.MarshalAuthorizedKey(hostKey.PublicKey())), // plain key
+	}
+	got := b.sshHostCertSigners([]ssh.Signer{hostKey}, certs, now)
+	if len(got) != 1 {
+		t.Fatalf("got %d signers; want 1", len(got))
+	}
+	cert, ok := got[0].PublicKey().(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("signer public key is %T; want *ssh.Certificate", got[0].PublicKey())
+	}
+	if !reflect.DeepEqual(cert.Key.Marshal(), hostKey.PublicKey().Marshal()) {
+		t.Errorf("certificate is for the wrong key")
+	}
+}
+
 type fakeSSHServer struct {
 	SSHServer
 }
@@ -0,0 +1,80 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"slices"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+)
+
+// peerIsStale reports whether p is hidden by a HideStalePeersDays pref of
+// days at time now: whether it's offline and was last seen more than days
+// ago. Peers control hasn't said when it last saw aren't stale, nor is the
+// current exit node.
+func peerIsStale(p tailcfg.NodeView, days int, exitNodeID tailcfg.StableNodeID, now time.Time) bool {
+	if days <= 0 {
+		return false
+	}
+	if online := p.Online(); online != nil && *online {
+		return false
+	}
+	if exitNodeID != "" && p.StableID() == exitNodeID {
+		return false
+	}
+	lastSeen := p.LastSeen()
+	if lastSeen == nil || lastSeen.IsZero() {
+		return false
+	}
+	return now.Sub(*lastSeen) > time.Duration(days)*24*time.Hour
+}
+
+// peerHiddenLocked reports whether p is hidden by the current
+// HideStalePeersDays pref.
+//
+// b.mu must be held.
+func (b *LocalBackend) peerHiddenLocked(p tailcfg.NodeView) bool {
+	prefs := b.pm.CurrentPrefs()
+	return peerIsStale(p, prefs.HideStalePeersDays(), prefs.ExitNodeID(), b.clock.Now())
+}
+
+// StalePeers returns the peers that a HideStalePeersDays pref of days
+// would hide, least recently seen first, so the pref's effect can be
+// previewed.
+func (b *LocalBackend) StalePeers(days int) []*ipnstate.PeerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.netMap == nil {
+		return nil
+	}
+	exitNodeID := b.pm.CurrentPrefs().ExitNodeID()
+	now := b.clock.Now()
+	var stale []*ipnstate.PeerStatus
+	for _, p := range b.netMap.Peers {
+		if !peerIsStale(p, days, exitNodeID, now) {
+			continue
+		}
+		ps := &ipnstate.PeerStatus{
+			HostName: p.Hostinfo().Hostname(),
+			DNSName:  p.Name(),
+			OS:       p.Hostinfo().OS(),
+			UserID:   p.User(),
+			LastSeen: *p.LastSeen(),
+		}
+		for i := range p.Addresses().LenIter() {
+			if addr := p.Addresses().At(i); addr.IsSingleIP() && tsaddr.IsTailscaleIP(addr.Addr()) {
+				ps.TailscaleIPs = append(ps.TailscaleIPs, addr.Addr())
+			}
+		}
+		peerStatusFromNode(ps, p)
+		stale = append(stale, ps)
+	}
+	slices.SortFunc(stale, func(a, b *ipnstate.PeerStatus) int {
+		return a.LastSeen.Compare(b.LastSeen)
+	})
+	return stale
+}
@@ -0,0 +1,36 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ptr"
+)
+
+func TestPeerIsStale(t *testing.T) {
+	now := time.Date(2023, 9, 1, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(d int) *time.Time { return ptr.To(now.Add(-time.Duration(d) * 24 * time.Hour)) }
+	tests := []struct {
+		name   string
+		node   *tailcfg.Node
+		days   int
+		exitID tailcfg.StableNodeID
+		want   bool
+	}{
+		{"pref-off", &tailcfg.Node{LastSeen: daysAgo(100)}, 0, "", false},
+		{"stale", &tailcfg.Node{LastSeen: daysAgo(31)}, 30, "", true},
+		{"recent", &tailcfg.Node{LastSeen: daysAgo(29)}, 30, "", false},
+		{"online", &tailcfg.Node{LastSeen: daysAgo(31), Online: ptr.To(true)}, 30, "", false},
+		{"never-seen", &tailcfg.Node{}, 30, "", false},
+		{"exit-node", &tailcfg.Node{StableID: "exit", LastSeen: daysAgo(31)}, 30, "exit", false},
+	}
+	for _, tt := range tests {
+		if got := peerIsStale(tt.node.View(), tt.days, tt.exitID, now); got != tt.want {
+			t.Errorf("%s: peerIsStale = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"tailscale.com/ipn"
+	"tailscale.com/types/netmap"
+)
+
+// The WireGuard engine, its TUN device and magicsock's sockets are shared by
+// all profiles and survive a profile switch; only their configuration
+// changes. What makes a switch slow is waiting for the new profile's control
+// client to connect and fetch a network map before the engine can be
+// configured. To avoid that, the last network map of each profile is kept
+// in memory as a warm standby and applied as soon as the profile is switched
+// to. The control client replaces it with a fresh one once connected.
+
+// rememberStandbyNetMapLocked records nm as the standby network map of the
+// current profile. A nil nm is ignored, so that resetting the network map
+// during a profile switch doesn't discard the previous profile's.
+//
+// b.mu must be held.
+func (b *LocalBackend) rememberStandbyNetMapLocked(nm *netmap.NetworkMap) {
+	if nm == nil {
+		return
+	}
+	id := b.pm.CurrentProfile().ID
+	if id == "" {
+		return
+	}
+	if b.standbyNetMaps == nil {
+		b.standbyNetMaps = make(map[ipn.ProfileID]*netmap.NetworkMap)
+	}
+	b.standbyNetMaps[id] = nm
+}
+
+// standbyNetMapLocked returns the standby network map for profile, or nil if
+// there is none or it can't be used with the current prefs: the profile must
+// be the current one, want to be running, and still have the node key and
+// an unexpired key the network map was issued for.
+//
+// b.mu must be held.
+func (b *LocalBackend) standbyNetMapLocked(profile ipn.ProfileID) *netmap.NetworkMap {
+	nm := b.standbyNetMaps[profile]
+	if nm == nil || b.netMap != nil || b.pm.CurrentProfile().ID != profile {
+		return nil
+	}
+	prefs := b.pm.CurrentPrefs()
+	if !prefs.Valid() || !prefs.WantRunning() || prefs.LoggedOut() || !prefs.Persist().Valid() {
+		return nil
+	}
+	if prefs.Persist().PrivateNodeKey().Public() != nm.NodeKey {
+		return nil
+	}
+	if !nm.Expiry.IsZero() && nm.Expiry.Before(b.clock.Now()) {
+		return nil
+	}
+	return nm
+}
+
+// applyStandbyNetMap configures the engine with the standby network map of
+// profile, if there is a usable one, so that traffic to its peers can flow
+// while its control client is still connecting.
+func (b *LocalBackend) applyStandbyNetMap(profile ipn.ProfileID) {
+	b.mu.Lock()
+	nm := b.standbyNetMapLocked(profile)
+	if nm == nil {
+		b.mu.Unlock()
+		return
+	}
+	b.logf("applying standby netmap for profile %v", profile)
+	b.setNetMapLocked(nm)
+	b.updateFilterLocked(nm, b.pm.CurrentPrefs())
+	b.mu.Unlock()
+
+	b.e.SetNetworkMap(nm)
+	b.e.SetDERPMap(nm.DERPMap)
+	b.send(ipn.Notify{NetMap: nm})
+	b.stateMachine()
+	b.authReconfig()
+}
@@ -258,6 +258,11 @@ func (cc *mockControl) SetTKAHead(head string) {
 	cc.logf("SetTKAHead: %s", head)
 }
 
+func (cc *mockControl) ResetConnections() {
+	cc.logf("ResetConnections")
+	cc.called("ResetConnections")
+}
+
 func (cc *mockControl) UpdateEndpoints(endpoints []tailcfg.Endpoint) {
 	// validate endpoint information here?
 	cc.logf("UpdateEndpoints:  ep=%v", endpoints)
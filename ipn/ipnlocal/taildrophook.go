@@ -0,0 +1,92 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"tailscale.com/ipn"
+)
+
+// taildropHookTimeout is how long a TaildropHook may take.
+var taildropHookTimeout = time.Minute
+
+// taildropHook returns the TaildropHook from the config file, if any.
+func (b *LocalBackend) taildropHook() *ipn.TaildropHook {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conf == nil {
+		return nil
+	}
+	return b.conf.Parsed.TaildropHook
+}
+
+// runTaildropHook runs hook for the received file described by ev, logging
+// any failure.
+func (b *LocalBackend) runTaildropHook(hook *ipn.TaildropHook, ev ipn.TaildropHookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), taildropHookTimeout)
+	defer cancel()
+	var err error
+	if len(hook.Exec) > 0 {
+		err = execTaildropHook(ctx, hook.Exec, ev)
+	} else {
+		err = postTaildropHook(ctx, hook.URL, ev)
+	}
+	if err != nil {
+		b.logf("taildrop hook for %q: %v", ev.Name, redactErr(err))
+	}
+}
+
+func execTaildropHook(ctx context.Context, argv []string, ev ipn.TaildropHookEvent) error {
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(),
+		"TS_TAILDROP_PATH="+ev.Path,
+		"TS_TAILDROP_NAME="+ev.Name,
+		"TS_TAILDROP_SIZE="+strconv.FormatInt(ev.Size, 10),
+		"TS_TAILDROP_FROM_NODE="+ev.FromNode,
+		"TS_TAILDROP_FROM_IP="+ev.FromIP.String(),
+		"TS_TAILDROP_FROM_USER="+ev.FromUser,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if len(out) > 1<<10 {
+			out = out[:1<<10]
+		}
+		return fmt.Errorf("%v: %w; output: %s", argv[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func postTaildropHook(ctx context.Context, url string, ev ipn.TaildropHookEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The URL is on localhost, so don't use any proxy.
+	hc := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	res, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("%s: %v: %s", url, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/set"
+)
+
+// waitPollInterval is how often WaitFor re-checks unmet conditions.
+const waitPollInterval = 250 * time.Millisecond
+
+// waitPingTimeout is how long WaitFor waits for the reply to each ping it
+// sends to check that a peer is reachable.
+const waitPingTimeout = 2 * time.Second
+
+// errWaitDNSDisabled is returned by WaitFor for the "dns" condition when
+// the tailnet's DNS configuration isn't used at all, as it never holds.
+var errWaitDNSDisabled = errors.New("can't wait for dns: disabled by --accept-dns=false")
+
+// WaitFor blocks until all of conds hold or ctx is done, in which case the
+// error wraps ctx.Err() and says which don't hold and why. The conditions
+// are:
+//
+//   - "running": the backend is in the Running state.
+//   - "routes": the routes and addresses of the current netmap are
+//     applied to the OS.
+//   - "dns": as "routes", and the tailnet's DNS configuration is applied.
+//     It fails right away if the DNS configuration is disabled.
+//   - "peer:<name>": the peer with the given name or Tailscale IP, as
+//     accepted by --exit-node, is in the netmap, not known to be offline,
+//     configured as with "routes", and answers a disco ping.
+//
+// All conditions imply "running". With no conditions, WaitFor waits for
+// "running".
+func (b *LocalBackend) WaitFor(ctx context.Context, conds []string) error {
+	return b.waitFor(ctx, conds, func(ctx context.Context, ip netip.Addr) error {
+		pr, err := b.Ping(ctx, ip, tailcfg.PingDisco, 0)
+		if err != nil {
+			return err
+		}
+		if pr.Err != "" {
+			return errors.New(pr.Err)
+		}
+		return nil
+	})
+}
+
+// waitFor is WaitFor, with ping checking that the peer with the given IP
+// is reachable.
+func (b *LocalBackend) waitFor(ctx context.Context, conds []string, ping func(context.Context, netip.Addr) error) error {
+	if len(conds) == 0 {
+		conds = []string{"running"}
+	}
+	for _, c := range conds {
+		switch name, _, _ := strings.Cut(c, ":"); name {
+		case "running", "routes", "dns":
+		case "peer":
+			if strings.TrimPrefix(c, "peer:") == "" {
+				return fmt.Errorf("invalid condition %q; want peer:<name>", c)
+			}
+		default:
+			return fmt.Errorf("unknown condition %q", c)
+		}
+	}
+
+	var reached set.Set[string] // peer conditions whose peer answered a ping
+	t := time.NewTicker(waitPollInterval)
+	defer t.Stop()
+	for {
+		unmet, toPing, err := b.unmetWaitConditions(conds, reached)
+		if err != nil {
+			return err
+		}
+		if len(unmet) == 0 {
+			return nil
+		}
+		newlyReached := false
+		for c, ip := range toPing {
+			pingCtx, cancel := context.WithTimeout(ctx, waitPingTimeout)
+			err := ping(pingCtx, ip)
+			cancel()
+			if err == nil {
+				mak.Set(&reached, c, struct{}{})
+				newlyReached = true
+			}
+		}
+		if newlyReached {
+			continue // re-check right away
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", strings.Join(unmet, "; "), ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// unmetWaitConditions returns descriptions of those of conds that don't
+// currently hold, and the IPs of the peers of the peer conditions, not in
+// reached, that hold but for a ping to check the peer is reachable. It
+// returns an error if one of conds can never hold.
+func (b *LocalBackend) unmetWaitConditions(conds []string, reached set.Set[string]) (unmet []string, toPing map[string]netip.Addr, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefs := b.pm.CurrentPrefs()
+	if slices.Contains(conds, "dns") && !prefs.CorpDNS() {
+		return nil, nil, errWaitDNSDisabled
+	}
+	if b.state != ipn.Running {
+		return []string{fmt.Sprintf("running (state is %v)", b.state)}, nil, nil
+	}
+	reconfigured := b.netMap != nil && b.reconfiguredNM == b.netMap
+
+	for _, c := range conds {
+		switch {
+		case c == "running":
+		case c == "routes" || c == "dns":
+			if !reconfigured {
+				unmet = append(unmet, c+" (netmap not yet applied)")
+			} else if !health.SubsystemReady(health.SysRouter) {
+				unmet = append(unmet, c+" (router not configured)")
+			} else if c == "dns" && !health.SubsystemReady(health.SysDNS) {
+				unmet = append(unmet, "dns (DNS not configured)")
+			}
+		case strings.HasPrefix(c, "peer:"):
+			ip, why := b.peerUnusableLocked(strings.TrimPrefix(c, "peer:"), prefs)
+			if why != "" {
+				unmet = append(unmet, c+" ("+why+")")
+			} else if !reconfigured {
+				unmet = append(unmet, c+" (netmap not yet applied)")
+			} else if !reached.Contains(c) {
+				unmet = append(unmet, c+" (no reply to ping)")
+				mak.Set(&toPing, c, ip)
+			}
+		}
+	}
+	return unmet, toPing, nil
+}
+
+// peerUnusableLocked returns the Tailscale IP of the peer named spec, or
+// why it can't be reached.
+//
+// b.mu must be held.
+func (b *LocalBackend) peerUnusableLocked(spec string, prefs ipn.PrefsView) (ip netip.Addr, why string) {
+	if b.netMap == nil {
+		return ip, "no netmap"
+	}
+	for _, p := range b.netMap.Peers {
+		if !exitNodeMatches(p, spec) {
+			continue
+		}
+		if online := p.Online(); online != nil && !*online {
+			return ip, "offline"
+		}
+		if !peerAllowed(prefs, p) {
+			return ip, "blocked by --allowed-peers"
+		}
+		for i := range p.Addresses().LenIter() {
+			if pfx := p.Addresses().At(i); pfx.IsSingleIP() {
+				return pfx.Addr(), ""
+			}
+		}
+		return ip, "no Tailscale IP"
+	}
+	return ip, "not in netmap"
+}
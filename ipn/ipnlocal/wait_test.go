@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/must"
+	"tailscale.com/util/set"
+)
+
+func TestWaitFor(t *testing.T) {
+	pm := must.Get(newProfileManager(new(mem.Store), t.Logf))
+	prefs := ipn.NewPrefs()
+	prefs.CorpDNS = true
+	pm.SetPrefs(prefs.View())
+	nm := &netmap.NetworkMap{
+		Peers: []tailcfg.NodeView{
+			(&tailcfg.Node{
+				ID:           1,
+				ComputedName: "myserver",
+				Online:       ptr.To(true),
+				Addresses:    []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			}).View(),
+			(&tailcfg.Node{
+				ID:           2,
+				ComputedName: "asleep",
+				Online:       ptr.To(false),
+			}).View(),
+		},
+	}
+	b := &LocalBackend{pm: pm, netMap: nm, state: ipn.Starting}
+	health.SetRouterHealth(nil)
+	health.SetDNSHealth(nil)
+
+	conds := []string{"dns", "peer:myserver", "peer:100.64.0.1", "peer:asleep", "peer:nope"}
+	unmet := func(reached ...string) []string {
+		t.Helper()
+		r := make(set.Set[string])
+		for _, c := range reached {
+			r.Add(c)
+		}
+		got, _, err := b.unmetWaitConditions(conds, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got, want := unmet(), []string{"running (state is Starting)"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("starting: got %q; want %q", got, want)
+	}
+
+	b.state = ipn.Running
+	want := []string{
+		"dns (netmap not yet applied)",
+		"peer:myserver (netmap not yet applied)",
+		"peer:100.64.0.1 (netmap not yet applied)",
+		"peer:asleep (offline)",
+		"peer:nope (not in netmap)",
+	}
+	if got := unmet(); !reflect.DeepEqual(got, want) {
+		t.Errorf("running: got %q; want %q", got, want)
+	}
+
+	b.reconfiguredNM = nm
+	want = []string{
+		"peer:myserver (no reply to ping)",
+		"peer:100.64.0.1 (no reply to ping)",
+		"peer:asleep (offline)",
+		"peer:nope (not in netmap)",
+	}
+	if got := unmet(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reconfigured: got %q; want %q", got, want)
+	}
+	if got := unmet("peer:myserver", "peer:100.64.0.1"); !reflect.DeepEqual(got, want[2:]) {
+		t.Errorf("reached: got %q; want %q", got, want[2:])
+	}
+
+	// Peers are only reachable once they answer a ping.
+	var pinged []netip.Addr
+	var answer bool
+	ping := func(ctx context.Context, ip netip.Addr) error {
+		pinged = append(pinged, ip)
+		if !answer {
+			return errors.New("timeout")
+		}
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.waitFor(ctx, conds[:2], ping); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitFor unanswered peer = %v; want deadline exceeded", err)
+	}
+	answer = true
+	pinged = nil
+	if err := b.waitFor(context.Background(), conds[:3], ping); err != nil {
+		t.Errorf("waitFor met conditions: %v", err)
+	}
+	if want := []netip.Addr{netip.MustParseAddr("100.64.0.1")}; len(pinged) != 2 || pinged[0] != want[0] || pinged[1] != want[0] {
+		t.Errorf("pinged %v; want the peer's IP for each peer condition", pinged)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.waitFor(ctx, conds, ping); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waitFor unmet conditions = %v; want deadline exceeded", err)
+	}
+	if err := b.waitFor(context.Background(), []string{"dns", "bogus"}, ping); err == nil {
+		t.Error("waitFor unknown condition succeeded")
+	}
+
+	// Waiting for DNS with DNS disabled fails right away.
+	prefs.CorpDNS = false
+	pm.SetPrefs(prefs.View())
+	if err := b.waitFor(context.Background(), []string{"dns"}, ping); err != errWaitDNSDisabled {
+		t.Errorf("waitFor dns with --accept-dns=false = %v; want %v", err, errWaitDNSDisabled)
+	}
+}
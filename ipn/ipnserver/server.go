@@ -202,6 +202,10 @@ func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
 		lah := localapi.NewHandler(lb, s.logf, s.netMon, s.backendLogID)
 		lah.PermitRead, lah.PermitWrite = s.localAPIPermissions(ci)
 		lah.PermitCert = s.connCanFetchCerts(ci)
+		lah.PermitAdmin = lah.PermitWrite && s.connIsAdmin(ci)
+		if !lah.PermitWrite && ci.IsUnixSock() {
+			lah.OperatorPermissions = ci.OperatorPermissions(lb.OperatorGrants())
+		}
 		lah.ServeHTTP(w, r)
 		return
 	}
@@ -298,6 +302,16 @@ func (s *Server) localAPIPermissions(ci *ipnauth.ConnIdentity) (read, write bool
 	return false, false
 }
 
+// connIsAdmin reports whether ci, which has LocalAPI write access, is
+// root or a local admin rather than only the operator user.
+func (s *Server) connIsAdmin(ci *ipnauth.ConnIdentity) bool {
+	if !ci.IsUnixSock() || envknob.GOOS() == "windows" {
+		// Only Unix sockets have an operator user.
+		return true
+	}
+	return !ci.IsReadonlyConn("" /* no operator */, logger.Discard)
+}
+
 // userIDFromString maps from either a numeric user id in string form
 // ("998") or username ("caddy") to its string userid ("998").
 // It returns the empty string on error.
@@ -477,6 +491,7 @@ type connIdentityContextKey struct{
 func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 	s.runCalled.Store(true)
 	defer func() {
+		systemd.Stopping()
 		if lb := s.lb.Load(); lb != nil {
 			lb.Shutdown()
 		}
@@ -497,6 +512,9 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 
 	s.startBackendIfNeeded()
 	systemd.Ready()
+	if d := systemd.WatchdogInterval(); d > 0 {
+		go s.runWatchdog(ctx, d/2)
+	}
 
 	hs := &http.Server{
 		Handler:     http.HandlerFunc(s.serveHTTP),
@@ -527,6 +545,25 @@ func (s *Server) Run(ctx context.Context, ln net.Listener) error {
 	return nil
 }
 
+// runWatchdog pings the systemd watchdog every interval until ctx is done,
+// as long as the LocalBackend, once set, still responds. If it deadlocks,
+// the pings stop and systemd restarts tailscaled.
+func (s *Server) runWatchdog(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if lb := s.lb.Load(); lb != nil {
+			lb.State() // blocks if the LocalBackend's mutex is stuck
+		}
+		systemd.Watchdog()
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
 // ServeHTMLStatus serves an HTML status page at http://localhost:41112/ for
 // Windows and via $DEBUG_LISTENER/debug/ipn when tailscaled's --debug flag
 // is used to run a debug server.
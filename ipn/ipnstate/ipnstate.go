@@ -20,6 +20,7 @@
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
+	"tailscale.com/types/opt"
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/views"
 	"tailscale.com/util/dnsname"
@@ -136,6 +137,33 @@ type NetworkLockStatus struct {
 	// generated upon enablement. This field is not populated if the
 	// network lock is disabled.
 	StateID uint64
+
+	// PendingRecovery describes the recovery AUM most recently generated
+	// or co-signed by this node which has not yet been submitted, if any.
+	PendingRecovery *NetworkLockRecovery `json:",omitempty"`
+}
+
+// NetworkLockRecovery describes the signing progress of a recovery AUM,
+// which retroactively removes trust in one or more compromised keys.
+type NetworkLockRecovery struct {
+	// Raw contains the serialized AUM. The AUM is sent in serialized
+	// form to avoid transitive dependences bloating this package.
+	Raw []byte
+
+	// RevokedKeys are the currently-trusted keys which the recovery AUM
+	// removes trust in.
+	RevokedKeys []key.NLPublic
+
+	// Signers are the trusted keys which have signed the recovery AUM.
+	Signers []key.NLPublic
+
+	// SignedBySelf is true if this node's network-lock key is among Signers.
+	SignedBySelf bool
+
+	// Weight is the sum of the votes of Signers, and RequiredWeight is
+	// the weight needed before the recovery AUM can be submitted.
+	Weight         uint
+	RequiredWeight uint
 }
 
 // NetworkLockUpdate describes a change to network-lock state.
@@ -164,6 +192,11 @@ type TailnetStatus struct {
 	// Note that the current device may still not support MagicDNS if
 	// `--accept-dns=false` was used.
 	MagicDNSEnabled bool
+
+	// SSHHostCAs are the public keys, in authorized_keys format, of the
+	// certificate authorities that sign SSH host certificates for nodes
+	// in the network.
+	SSHHostCAs []string `json:",omitempty"`
 }
 
 // ExitNodeStatus describes the current exit node.
@@ -198,6 +231,56 @@ type PeerStatusLite struct {
 	NodeKey key.NodePublic
 }
 
+// PeerTraffic is the recent traffic exchanged with a peer, counted in
+// fixed-length time buckets.
+type PeerTraffic struct {
+	NodeKey key.NodePublic
+	Buckets []TrafficBucket // oldest first
+}
+
+// TrafficBucket counts the bytes exchanged with a peer during the interval
+// starting at Start and ending at the next bucket's Start, or now.
+type TrafficBucket struct {
+	Start   time.Time
+	RxBytes int64
+	TxBytes int64
+}
+
+// FleetNodeStatus is a node's summary of its own state for fleet
+// dashboards, as served by its peerapi at /v0/fleet-status to peers with
+// tailcfg.PeerCapabilityFleetStatus.
+type FleetNodeStatus struct {
+	HostName string
+	OS       string
+	Version  string   // the daemon's long version
+	Health   []string // health check problems, if any
+
+	// KeyExpiry is when the node's key expires, or nil if it doesn't.
+	KeyExpiry *time.Time `json:",omitempty"`
+
+	// UpdateAvailable is the latest client version, if the node isn't
+	// running it. It's empty if the node is up to date or doesn't know.
+	UpdateAvailable string `json:",omitempty"`
+}
+
+// FleetNodeStatus returns the summary of the node described by st, which
+// needn't include peers.
+func (st *Status) FleetNodeStatus() FleetNodeStatus {
+	fs := FleetNodeStatus{
+		Version: st.Version,
+		Health:  st.Health,
+	}
+	if st.Self != nil {
+		fs.HostName = st.Self.HostName
+		fs.OS = st.Self.OS
+		fs.KeyExpiry = st.Self.KeyExpiry
+	}
+	if cv := st.ClientVersion; cv != nil && !cv.RunningLatest {
+		fs.UpdateAvailable = cv.LatestVersion
+	}
+	return fs
+}
+
 // PeerStatus describes a peer node and its current state.
 type PeerStatus struct {
 	ID        tailcfg.StableNodeID
@@ -221,6 +304,11 @@ type PeerStatus struct {
 	// See tailscale.com/tailcfg#Node.Tags for more information.
 	Tags *views.Slice[string] `json:",omitempty"`
 
+	// AllowedIPs are the IP prefixes the control plane allows to be
+	// routed to this node: its TailscaleIPs, and the routes it
+	// advertises that the tailnet admin approved.
+	AllowedIPs *views.Slice[netip.Prefix] `json:",omitempty"`
+
 	// PrimaryRoutes are the routes this node is currently the primary
 	// subnet router for, as determined by the control plane. It does
 	// not include the IPs in TailscaleIPs.
@@ -268,6 +356,11 @@ type PeerStatus struct {
 	// etc by default.
 	ShareeNode bool `json:",omitempty"`
 
+	// LocallyBlocked is whether this node refuses all traffic with the
+	// peer, whatever the tailnet's ACLs permit, because it's not in the
+	// node's AllowedPeers pref.
+	LocallyBlocked bool `json:",omitempty"`
+
 	// InNetworkMap means that this peer was seen in our latest network map.
 	// In theory, all of InNetworkMap and InMagicSock and InEngine should all be true.
 	InNetworkMap bool
@@ -413,6 +506,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.TailscaleIPs; v != nil {
 		e.TailscaleIPs = v
 	}
+	if v := st.AllowedIPs; v != nil && !v.IsNil() {
+		e.AllowedIPs = v
+	}
 	if v := st.PrimaryRoutes; v != nil && !v.IsNil() {
 		e.PrimaryRoutes = v
 	}
@@ -470,6 +566,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if st.ShareeNode {
 		e.ShareeNode = true
 	}
+	if st.LocallyBlocked {
+		e.LocallyBlocked = true
+	}
 	if st.Active {
 		e.Active = true
 	}
@@ -713,3 +812,278 @@ type DebugDERPRegionReport struct {
 	Warnings []string
 	Errors   []string
 }
+
+// DebugDERPBandwidthReport is the result of a DERP bandwidth probe
+// requested via the LocalAPI.
+type DebugDERPBandwidthReport struct {
+	RegionID    int
+	RegionCode  string
+	BytesPerSec int64 // measured download bandwidth; zero on failure
+	Errors      []string
+}
+
+// NetworkEvent is a change to the network the device is on, or the result
+// of a captive portal check, as streamed by the LocalAPI.
+type NetworkEvent struct {
+	Time time.Time
+
+	// Link, if non-nil, is the network state after the interfaces or
+	// default route changed.
+	Link *LinkState `json:",omitempty"`
+
+	// CaptivePortal, if set, is the result of a captive portal check:
+	// whether the device appears to be behind one.
+	CaptivePortal opt.Bool `json:",omitempty"`
+}
+
+// LinkState summarizes the state of the device's network interfaces.
+type LinkState struct {
+	Major      bool // whether the change was big enough to reconnect
+	TimeJumped bool // whether wall time jumped, as when waking from sleep
+
+	DefaultRouteInterface string   `json:",omitempty"`
+	Interfaces            []string `json:",omitempty"` // sorted names of the up interfaces with IPs
+	HaveV4                bool
+	HaveV6                bool
+	Expensive             bool // whether the default route is metered, such as cellular
+}
+
+// DebugPacketFilterLatencyReport is the result of timing rule lookups in
+// the packet filter, requested via the LocalAPI.
+type DebugPacketFilterLatencyReport struct {
+	Rules        int // number of rules, summed across address families
+	SrcPrefixes  int // total source prefixes of the rules
+	IndexedRules int // rules with enough sources to be looked up in an ART table
+
+	Lookups int // number of lookups timed
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+	Max     time.Duration
+}
+
+// EndpointCandidates are the UDP endpoints that NAT traversal has to work
+// with to connect directly to a peer, as returned by the LocalAPI.
+type EndpointCandidates struct {
+	Self []EndpointCandidate // this node's endpoints, as advertised to peers
+	Peer []EndpointCandidate // the peer's endpoints, best first
+}
+
+// EndpointCandidate is a UDP endpoint at which a node may be reachable
+// directly.
+type EndpointCandidate struct {
+	Addr netip.AddrPort
+
+	// Source is how the endpoint was found. For this node's endpoints,
+	// it's their tailcfg.EndpointType, such as "local", "stun" or
+	// "portmap". For a peer's, it's how it was most recently learned:
+	// "netmap" (from the coordination server), "call-me-maybe" (from
+	// the peer, via DERP) or "ping" (from a disco ping the peer sent
+	// from it).
+	Source string
+
+	// The remaining fields are only set for a peer's endpoints.
+
+	LastPing time.Time     `json:",omitempty"` // when it was last pinged
+	LastPong time.Time     `json:",omitempty"` // when it last replied
+	Latency  time.Duration `json:",omitempty"` // of its last reply
+	Pongs    int           `json:",omitempty"` // number of its recent replies kept, up to 64
+
+	// Score is how highly path discovery rates the endpoint: the
+	// percentage by which its latency beats that of the peer's slowest
+	// replying endpoint, plus bonuses for loopback, private and IPv6
+	// addresses. Higher is better. It's zero if it hasn't replied.
+	Score int `json:",omitempty"`
+
+	// Best is whether the endpoint is the path currently used to reach
+	// the peer. It's trusted to be, without further pings, until
+	// TrustedUntil.
+	Best         bool      `json:",omitempty"`
+	TrustedUntil time.Time `json:",omitempty"`
+}
+
+// DNSQueryLogConfig configures the query log of tailscaled's DNS resolver.
+type DNSQueryLogConfig struct {
+	// Size is the number of most recent queries to keep. Zero disables
+	// the log, which is the default.
+	Size int `json:",omitempty"`
+
+	// RedactNames is whether to log only the last two labels of queried
+	// names, such as "*.example.com.".
+	RedactNames bool `json:",omitempty"`
+
+	// RedactClients is whether to omit the addresses of the clients
+	// that sent queries.
+	RedactClients bool `json:",omitempty"`
+}
+
+// DNSQueryLog is the query log of tailscaled's DNS resolver, with
+// statistics on the upstream resolvers it forwards queries to.
+type DNSQueryLog struct {
+	Config    DNSQueryLogConfig
+	Entries   []DNSQueryLogEntry // oldest first
+	Upstreams []DNSUpstreamStats // sorted by Upstream
+}
+
+// DNSQueryLogEntry is a DNS query answered by tailscaled's resolver.
+type DNSQueryLogEntry struct {
+	// Seq increases by one with each query logged, so that a reader
+	// can ask for only the entries it hasn't seen.
+	Seq uint64
+
+	Time   time.Time
+	Client string `json:",omitempty"` // ip:port that sent the query, unless redacted
+	Name   string // the queried name, possibly redacted
+	Type   string // the query type, such as "TypeA"
+
+	// Source is how the query was answered: "local" (from MagicDNS
+	// or extra records), "handler" (by a special-purpose handler, such
+	// as for the 4via6 domain) or "forwarded" (by an upstream
+	// resolver).
+	Source string
+
+	Upstream string        `json:",omitempty"` // for forwarded queries, the resolver that answered
+	RCode    string        `json:",omitempty"` // the response code, such as "RCodeSuccess"
+	Err      string        `json:",omitempty"` // why there's no response, if none
+	Latency  time.Duration // time taken to answer
+}
+
+// DNSUpstreamStats are statistics on the queries tailscaled's resolver has
+// forwarded to an upstream resolver.
+type DNSUpstreamStats struct {
+	Upstream   string        // the resolver's address, such as "8.8.8.8" or "https://dns.google/dns-query"
+	Queries    int64         // queries sent
+	Errors     int64         // queries that failed, including with SERVFAIL
+	LatencyAvg time.Duration `json:",omitempty"` // of successful queries
+	LatencyMax time.Duration `json:",omitempty"` // of successful queries
+	LastError  string        `json:",omitempty"`
+}
+
+// FlowLogConfig configures tailscaled's audit log of inbound flows.
+type FlowLogConfig struct {
+	// Size is the number of most recent flows to keep. Zero disables
+	// the log, which is the default.
+	Size int `json:",omitempty"`
+}
+
+// FlowLog is tailscaled's audit log of the flows peers started, or tried
+// to start, to this node.
+type FlowLog struct {
+	Config  FlowLogConfig
+	Entries []FlowLogEntry // oldest first
+
+	// ExportFile and ExportSyslog are where tailscaled also writes the
+	// flows it logs, as configured by its --flow-log-file and
+	// --flow-log-syslog flags.
+	ExportFile   string `json:",omitempty"`
+	ExportSyslog bool   `json:",omitempty"`
+}
+
+// FlowLogEntry is a flow a peer started, or tried to start, to this node:
+// a TCP connection, or the first packet of another protocol's flow seen in
+// a while.
+type FlowLogEntry struct {
+	// Seq increases by one with each flow logged, so that a reader
+	// can ask for only the entries it hasn't seen.
+	Seq uint64
+
+	Time  time.Time
+	Proto string         // such as "TCP", "UDP" or "ICMPv4"
+	Src   netip.AddrPort // the peer's address
+	Dst   netip.AddrPort // the local address and port
+
+	// Accepted is whether the packet filter let the flow through.
+	Accepted bool
+
+	// Node, NodeID and User identify the peer, if known.
+	Node   string               `json:",omitempty"` // its MagicDNS name
+	NodeID tailcfg.StableNodeID `json:",omitempty"`
+	User   string               `json:",omitempty"` // login name of the node's user
+}
+
+// AccessSummary is who this node's packet filter, as derived from the
+// tailnet's ACLs, lets reach this node, and on which ports.
+type AccessSummary struct {
+	// Peers are the peers allowed to reach this node, sorted by
+	// DNSName.
+	Peers []PeerAccess
+
+	// Sources are the IP prefixes allowed to reach this node, such as
+	// "0.0.0.0/0" for any IPv4 address or a subnet routed by a peer,
+	// other than the addresses of individual peers.
+	Sources []SourceAccess `json:",omitempty"`
+}
+
+// PeerAccess is a peer allowed to reach this node, and how.
+type PeerAccess struct {
+	ID      tailcfg.StableNodeID
+	DNSName string
+	Tags    []string `json:",omitempty"`
+
+	// Ports are the protocols and ports the peer may reach, sorted,
+	// such as "tcp:22", "udp:3000-3999", "tcp:*" or "icmp".
+	Ports []string
+}
+
+// SourceAccess is an IP prefix allowed to reach this node, and how.
+type SourceAccess struct {
+	Prefix netip.Prefix
+	Ports  []string // as in PeerAccess
+}
+
+// NetcheckSample is a netcheck result recorded in the netcheck history,
+// if enabled by the NetcheckHistoryHours pref.
+type NetcheckSample struct {
+	Time          time.Time
+	PreferredDERP int // home DERP region ID, or 0 if none
+
+	// DERPLatency is the latency to the fastest DERP regions, keyed by
+	// region ID.
+	DERPLatency map[int]time.Duration `json:",omitempty"`
+}
+
+// NetcheckHistory reports the trends of the netcheck samples recorded
+// over a period of time.
+type NetcheckHistory struct {
+	// Hours is how many hours of samples are retained, from the
+	// NetcheckHistoryHours pref. It's zero if none are recorded.
+	Hours int
+
+	// Since is the start of the reported period.
+	Since time.Time
+
+	// Samples is the number of samples in the period.
+	Samples int
+
+	// Regions are the latency trends of the DERP regions in the
+	// period, sorted by median latency.
+	Regions []DERPRegionTrend `json:",omitempty"`
+
+	// HomeChanges are the changes of home DERP region in the period,
+	// oldest first.
+	HomeChanges []HomeDERPChange `json:",omitempty"`
+}
+
+// DERPRegionTrend is the latency trend of a DERP region in a
+// NetcheckHistory.
+type DERPRegionTrend struct {
+	RegionID   int
+	RegionCode string `json:",omitempty"`
+
+	// Samples is the number of samples that measured the region, which
+	// only include the fastest regions.
+	Samples int
+
+	Min, Median, Max time.Duration
+
+	// EarlyMedian and LateMedian are the median latencies in the first
+	// and second halves of the period, to show whether latency rose or
+	// fell. Either is zero if the region wasn't measured in its half.
+	EarlyMedian, LateMedian time.Duration
+}
+
+// HomeDERPChange is a change of home DERP region in a NetcheckHistory.
+type HomeDERPChange struct {
+	Time     time.Time
+	From, To int // region IDs, or 0 for none
+}
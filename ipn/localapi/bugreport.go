@@ -0,0 +1,384 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/hostinfo"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/mak"
+	"tailscale.com/util/osdiag"
+	"tailscale.com/util/rands"
+)
+
+func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "bugreport access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+
+	var lines []string
+	if id := q.Get("preview-id"); id != "" {
+		var ok bool
+		lines, ok = takeBugReportPreview(id, h.clock.Now())
+		if !ok {
+			http.Error(w, "unknown or expired bugreport preview", http.StatusNotFound)
+			return
+		}
+	} else {
+		redact, err := parseBugReportRedact(q.Get("redact"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		lines = h.bugReportPayload(r.Context(), q.Get("note"), defBool(q.Get("diagnose"), false), redact)
+		if defBool(q.Get("preview"), false) {
+			// Nothing is logged until the user has seen the payload
+			// and submits it by ID.
+			id := addBugReportPreview(lines, h.clock.Now())
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(apitype.BugReportPreview{ID: id, Lines: lines})
+			return
+		}
+	}
+
+	defer h.b.TryFlushLogs() // kick off upload after bugreport's done logging
+
+	logMarker := func() string {
+		return fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, h.clock.Now().UTC().Format("20060102150405Z"), rands.HexString(16))
+	}
+	if envknob.NoLogsNoSupport() {
+		logMarker = func() string { return "BUG-NO-LOGS-NO-SUPPORT-this-node-has-had-its-logging-disabled" }
+	}
+
+	startMarker := logMarker()
+	h.logf("user bugreport: %s", startMarker)
+
+	// Like Doctor, don't write more lines than logtail can keep up with.
+	logf := logger.SlowLoggerWithClock(r.Context(), h.logf, 20*time.Millisecond, 60, h.clock.Now)
+	for _, line := range lines {
+		logf("%s", line)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, startMarker)
+
+	// Nothing else to do if we're not in record mode; we wrote the marker
+	// above, so we can just finish our response now.
+	if !defBool(q.Get("record"), false) {
+		return
+	}
+
+	until := h.clock.Now().Add(12 * time.Hour)
+
+	var changed map[string]bool
+	for _, component := range []string{"magicsock"} {
+		if h.b.GetComponentDebugLogging(component).IsZero() {
+			if err := h.b.SetComponentDebugLogging(component, until); err != nil {
+				h.logf("bugreport: error setting component %q logging: %v", component, err)
+				continue
+			}
+
+			mak.Set(&changed, component, true)
+		}
+	}
+	defer func() {
+		for component := range changed {
+			h.b.SetComponentDebugLogging(component, time.Time{})
+		}
+	}()
+
+	// NOTE(andrew): if we have anything else we want to do while recording
+	// a bugreport, we can add it here.
+
+	// Read from the client; this will also return when the client closes
+	// the connection.
+	var buf [1]byte
+	_, err := r.Body.Read(buf[:])
+
+	switch {
+	case err == nil:
+		// good
+	case errors.Is(err, io.EOF):
+		// good
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		// this happens when Ctrl-C'ing the tailscale client; don't
+		// bother logging an error
+	default:
+		// Log but continue anyway.
+		h.logf("user bugreport: error reading body: %v", err)
+	}
+
+	// Generate another log marker and return it to the client.
+	endMarker := logMarker()
+	h.logf("user bugreport end: %s", endMarker)
+	fmt.Fprintln(w, endMarker)
+}
+
+// bugReportRedact is which kinds of identifying information to mask in a
+// bug report.
+type bugReportRedact struct {
+	hostnames bool
+	ips       bool
+}
+
+// parseBugReportRedact parses the "redact" query parameter, a
+// comma-separated list of "hostnames" and "ips".
+func parseBugReportRedact(s string) (bugReportRedact, error) {
+	var ret bugReportRedact
+	for _, v := range strings.Split(s, ",") {
+		switch strings.TrimSpace(v) {
+		case "":
+		case "hostnames":
+			ret.hostnames = true
+		case "ips":
+			ret.ips = true
+		default:
+			return ret, fmt.Errorf("unknown redact value %q", v)
+		}
+	}
+	return ret, nil
+}
+
+// bugReportPayload returns the diagnostic lines logged after a bug report's
+// marker, with the information selected by redact masked.
+func (h *Handler) bugReportPayload(ctx context.Context, note string, diagnose bool, redact bugReportRedact) []string {
+	var lines []string
+	var mu sync.Mutex // Doctor may log from multiple goroutines
+	logf := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+	}
+
+	if len(note) > 0 {
+		logf("user bugreport note: %s", note)
+	}
+	hi, _ := json.Marshal(hostinfo.New())
+	logf("user bugreport hostinfo: %s", hi)
+	if err := health.OverallError(); err != nil {
+		logf("user bugreport health: %s", err.Error())
+	} else {
+		logf("user bugreport health: ok")
+	}
+
+	// Information about the current node from the netmap
+	if nm := h.b.NetMap(); nm != nil {
+		if self := nm.SelfNode; self.Valid() {
+			logf("user bugreport node info: nodeid=%q stableid=%q expiry=%q", self.ID(), self.StableID(), self.KeyExpiry().Format(time.RFC3339))
+		}
+		logf("user bugreport public keys: machine=%q node=%q", nm.MachineKey, nm.NodeKey)
+	} else {
+		logf("user bugreport netmap: no active netmap")
+	}
+
+	// Print all envknobs; we otherwise only print these on startup, and
+	// printing them here ensures we don't have to go spelunking through
+	// logs for them.
+	envknob.LogCurrent(logger.WithPrefix(logf, "user bugreport: "))
+
+	// OS-specific details
+	osdiag.LogSupportInfo(logger.WithPrefix(logf, "user bugreport OS: "), osdiag.LogSupportInfoReasonBugReport)
+
+	if diagnose {
+		h.b.Doctor(ctx, logger.WithPrefix(logf, "diag: "))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if redact.hostnames || redact.ips {
+		rd := &bugReportRedactor{ips: redact.ips}
+		if redact.hostnames {
+			rd.hosts = h.bugReportHostnames()
+		}
+		for i, line := range lines {
+			lines[i] = rd.redact(line)
+		}
+	}
+	return lines
+}
+
+// bugReportHostnames returns the names of this node and its peers, longest
+// first, for redaction.
+func (h *Handler) bugReportHostnames() []string {
+	var names []string
+	add := func(name string) {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		// Very short names would mask unrelated words.
+		if len(name) >= 3 {
+			names = append(names, name)
+		}
+	}
+	add(hostinfo.New().Hostname)
+	if nm := h.b.NetMap(); nm != nil {
+		add(nm.MagicDNSSuffix())
+		addNode := func(n tailcfg.NodeView) {
+			if !n.Valid() {
+				return
+			}
+			add(n.Name())
+			add(n.ComputedName())
+			if hi := n.Hostinfo(); hi.Valid() {
+				add(hi.Hostname())
+			}
+		}
+		addNode(nm.SelfNode)
+		for _, p := range nm.Peers {
+			addNode(p)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+	return names
+}
+
+var (
+	bugReportIPv4Re = regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}`)
+	bugReportIPv6Re = regexp.MustCompile(`(?i)[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}(?:\.\d{1,3}){0,3}`)
+)
+
+// bugReportRedactor replaces hostnames and IP addresses with placeholders.
+// The same value is always replaced with the same placeholder, so that
+// support can still correlate lines of a redacted report.
+type bugReportRedactor struct {
+	hosts []string // lowercase, longest first
+	ips   bool
+
+	placeholders map[string]string // value to placeholder
+	numHosts     int
+	numIPs       int
+}
+
+func (rd *bugReportRedactor) redact(s string) string {
+	for _, host := range rd.hosts {
+		s = rd.redactHost(s, host)
+	}
+	if rd.ips {
+		s = bugReportIPv6Re.ReplaceAllStringFunc(s, rd.redactIP)
+		s = bugReportIPv4Re.ReplaceAllStringFunc(s, rd.redactIP)
+	}
+	return s
+}
+
+// redactHost replaces the case-insensitive occurrences of host in s that
+// aren't part of a longer name.
+func (rd *bugReportRedactor) redactHost(s, host string) string {
+	isNameByte := func(b byte) bool {
+		return b == '-' || b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+	}
+	lower := strings.ToLower(s)
+	var sb strings.Builder
+	for {
+		i := strings.Index(lower, host)
+		if i < 0 {
+			break
+		}
+		end := i + len(host)
+		if (i > 0 && isNameByte(lower[i-1])) || (end < len(lower) && isNameByte(lower[end])) {
+			sb.WriteString(s[:end])
+		} else {
+			sb.WriteString(s[:i])
+			sb.WriteString(rd.placeholder(host, "host"))
+		}
+		s, lower = s[end:], lower[end:]
+	}
+	sb.WriteString(s)
+	return sb.String()
+}
+
+func (rd *bugReportRedactor) redactIP(s string) string {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return s
+	}
+	// These don't identify anything and are useful when debugging.
+	if ip.IsLoopback() || ip.IsUnspecified() || ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6() {
+		return s
+	}
+	return rd.placeholder(ip.String(), "ip")
+}
+
+func (rd *bugReportRedactor) placeholder(v, kind string) string {
+	if p, ok := rd.placeholders[v]; ok {
+		return p
+	}
+	var n int
+	if kind == "ip" {
+		rd.numIPs++
+		n = rd.numIPs
+	} else {
+		rd.numHosts++
+		n = rd.numHosts
+	}
+	p := fmt.Sprintf("[%s-%d]", kind, n)
+	mak.Set(&rd.placeholders, v, p)
+	return p
+}
+
+// bugReportPreviewTimeout is how long a previewed bug report can be
+// submitted for.
+const bugReportPreviewTimeout = 10 * time.Minute
+
+// bugReportPreviews holds the bug report payloads that have been previewed
+// but not yet submitted, keyed by ID.
+var bugReportPreviews struct {
+	sync.Mutex
+	m map[string]pendingBugReport
+}
+
+type pendingBugReport struct {
+	lines   []string
+	expires time.Time
+}
+
+// addBugReportPreview stores lines to be submitted later and returns their
+// ID.
+func addBugReportPreview(lines []string, now time.Time) string {
+	bugReportPreviews.Lock()
+	defer bugReportPreviews.Unlock()
+	for id, p := range bugReportPreviews.m {
+		if now.After(p.expires) {
+			delete(bugReportPreviews.m, id)
+		}
+	}
+	id := rands.HexString(16)
+	mak.Set(&bugReportPreviews.m, id, pendingBugReport{lines: lines, expires: now.Add(bugReportPreviewTimeout)})
+	return id
+}
+
+// takeBugReportPreview removes and returns the previewed payload with the
+// given ID, if it hasn't expired.
+func takeBugReportPreview(id string, now time.Time) (lines []string, ok bool) {
+	bugReportPreviews.Lock()
+	defer bugReportPreviews.Unlock()
+	p, ok := bugReportPreviews.m[id]
+	if !ok {
+		return nil, false
+	}
+	delete(bugReportPreviews.m, id)
+	if now.After(p.expires) {
+		return nil, false
+	}
+	return p.lines, true
+}
@@ -17,6 +17,7 @@
 	"tailscale.com/derp/derphttp"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netaddr"
+	"tailscale.com/net/netcheck"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/stun"
 	"tailscale.com/tailcfg"
@@ -46,17 +47,7 @@ func (h *Handler) serveDebugDERPRegion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	regStr := r.FormValue("region")
-	var reg *tailcfg.DERPRegion
-	if id, err := strconv.Atoi(regStr); err == nil {
-		reg = dm.Regions[id]
-	} else {
-		for _, r := range dm.Regions {
-			if r.RegionCode == regStr {
-				reg = r
-				break
-			}
-		}
-	}
+	reg := findDERPRegion(dm, regStr)
 	if reg == nil {
 		st.Errors = append(st.Errors, fmt.Sprintf("no such region %q in DERP map", regStr))
 		return
@@ -293,6 +284,86 @@ func() {
 	// 	 cert is bad not just that the connection failed.
 }
 
+// findDERPRegion returns the region in dm whose ID or code is regStr,
+// or nil if there's no such region.
+func findDERPRegion(dm *tailcfg.DERPMap, regStr string) *tailcfg.DERPRegion {
+	if id, err := strconv.Atoi(regStr); err == nil {
+		return dm.Regions[id]
+	}
+	for _, r := range dm.Regions {
+		if r.RegionCode == regStr {
+			return r
+		}
+	}
+	return nil
+}
+
+// serveDebugDERPBandwidth measures the download bandwidth from a DERP
+// region, which defaults to the node's home region.
+func (h *Handler) serveDebugDERPBandwidth(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	var probe netcheck.BandwidthProbe
+	if v := r.FormValue("size"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		probe.Size = n
+	}
+	if v := r.FormValue("duration"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		probe.Duration = d
+	}
+
+	var st ipnstate.DebugDERPBandwidthReport
+	defer func() {
+		j, _ := json.Marshal(st)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(j)
+	}()
+
+	dm := h.b.DERPMap()
+	if dm == nil {
+		st.Errors = append(st.Errors, "no DERP map (not connected?)")
+		return
+	}
+	regStr := r.FormValue("region")
+	if regStr == "" {
+		regStr = h.b.StatusWithoutPeers().Self.Relay
+		if regStr == "" {
+			st.Errors = append(st.Errors, "no home DERP region; specify one")
+			return
+		}
+	}
+	reg := findDERPRegion(dm, regStr)
+	if reg == nil {
+		st.Errors = append(st.Errors, fmt.Sprintf("no such region %q in DERP map", regStr))
+		return
+	}
+	st.RegionID = reg.RegionID
+	st.RegionCode = reg.RegionCode
+
+	nc := &netcheck.Client{Logf: h.logf}
+	bw, err := nc.MeasureDERPBandwidth(r.Context(), reg, probe)
+	if err != nil {
+		st.Errors = append(st.Errors, fmt.Sprintf("bandwidth probe of region %q: %v", reg.RegionCode, err))
+		return
+	}
+	st.BytesPerSec = bw
+}
+
 func firstNonzero[T comparable](items ...T) T {
 	var zero T
 	for _, item := range items {
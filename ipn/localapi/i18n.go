@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"net/http"
+
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/health/healthmsg"
+	"tailscale.com/util/i18n"
+)
+
+// printerFor returns the Printer of the human-readable messages of the
+// response to r: in the locale of its Accept-Language header, or else of
+// tailscaled's TS_LOCALE environment variable, or else in English.
+//
+// Unlike the CLI, tailscaled ignores the LANG and LC_* variables, as
+// clients of the LocalAPI that don't ask for a locale may depend on the
+// English messages.
+func printerFor(r *http.Request) *i18n.Printer {
+	tags := i18n.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	if len(tags) == 0 {
+		if l := envknob.String("TS_LOCALE"); l != "" {
+			tags = []string{l}
+		}
+	}
+	return i18n.NewPrinter(i18n.Match(tags...))
+}
+
+// staticHealthMessages are the message IDs of the translations of the
+// Status.Health messages that aren't health warnings, by English text.
+var staticHealthMessages = map[string]string{
+	healthmsg.WarnAcceptRoutesOff: "health.accept-routes-off",
+	healthmsg.UnstableBuild:       "health.unstable-build",
+}
+
+// warningText returns the text of health warning w in p's locale.
+func warningText(p *i18n.Printer, w health.UnhealthyState) string {
+	if t, ok := p.Translate("health."+string(w.Code), w.Args); ok {
+		return t
+	}
+	return w.Text
+}
+
+// localizeHealth translates the Status.Health messages msgs in place into
+// p's locale, where it has translations.
+func localizeHealth(p *i18n.Printer, msgs []string) {
+	if p.Locale() == i18n.DefaultLocale || len(msgs) == 0 {
+		return
+	}
+	texts := map[string]string{} // English => translated
+	for _, w := range health.CurrentState().Warnings {
+		texts[w.Text] = warningText(p, w)
+	}
+	for en, id := range staticHealthMessages {
+		if t, ok := p.Translate(id, nil); ok {
+			texts[en] = t
+		}
+	}
+	for i, m := range msgs {
+		if t, ok := texts[m]; ok {
+			msgs[i] = t
+		}
+	}
+}
@@ -16,6 +16,7 @@
 	"net/http/httputil"
 	"net/netip"
 	"net/url"
+	"reflect"
 	"runtime"
 	"slices"
 	"strconv"
@@ -24,16 +25,17 @@
 	"time"
 
 	"tailscale.com/client/tailscale/apitype"
-	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/netutil"
 	"tailscale.com/net/portmapper"
+	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
@@ -44,11 +46,12 @@
 	"tailscale.com/types/ptr"
 	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/deephash"
 	"tailscale.com/util/httpm"
+	"tailscale.com/util/i18n"
 	"tailscale.com/util/mak"
-	"tailscale.com/util/osdiag"
-	"tailscale.com/util/rands"
 	"tailscale.com/version"
+	"tailscale.com/wgengine/router"
 )
 
 type localAPIHandler func(*Handler, http.ResponseWriter, *http.Request)
@@ -65,42 +68,65 @@
 
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
+	"access-summary":              (*Handler).serveAccessSummary,
+	"api-tokens":                  (*Handler).serveAPITokens,
+	"bandwidth-usage":             (*Handler).serveBandwidthUsage,
 	"bugreport":                   (*Handler).serveBugReport,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"conn-stats":                  (*Handler).serveConnStats,
 	"debug":                       (*Handler).serveDebug,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
+	"debug-derp-bandwidth":        (*Handler).serveDebugDERPBandwidth,
+	"debug-packet-filter-latency": (*Handler).serveDebugPacketFilterLatency,
 	"debug-packet-filter-matches": (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
+	"debug-netmon":                (*Handler).serveDebugNetmon,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
+	"debug-endpoints":             (*Handler).serveDebugEndpoints,
 	"debug-capture":               (*Handler).serveDebugCapture,
 	"debug-log":                   (*Handler).serveDebugLog,
 	"derpmap":                     (*Handler).serveDERPMap,
+	"dns-query-log":               (*Handler).serveDNSQueryLog,
+	"flow-log":                    (*Handler).serveFlowLog,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"dial":                        (*Handler).serveDial,
+	"exit-node-consent":           (*Handler).serveExitNodeConsent,
 	"file-targets":                (*Handler).serveFileTargets,
+	"firewall-rules":              (*Handler).serveFirewallRules,
 	"goroutines":                  (*Handler).serveGoroutines,
+	"health":                      (*Handler).serveHealth,
 	"id-token":                    (*Handler).serveIDToken,
 	"login-interactive":           (*Handler).serveLoginInteractive,
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"netcheck-history":            (*Handler).serveNetcheckHistory,
+	"peer-notes":                  (*Handler).servePeerNotes,
+	"peer-traffic":                (*Handler).servePeerTraffic,
 	"ping":                        (*Handler).servePing,
 	"prefs":                       (*Handler).servePrefs,
+	"prefs/provenance":            (*Handler).servePrefProvenance,
+	"proxy-config":                (*Handler).serveProxyConfig,
+	"proxy-credentials":           (*Handler).serveProxyCredentials,
 	"pprof":                       (*Handler).servePprof,
+	"relay-stats":                 (*Handler).serveRelayStats,
+	"reload-config":               (*Handler).serveReloadConfig,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
 	"set-dns":                     (*Handler).serveSetDNS,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
+	"stale-peers":                 (*Handler).serveStalePeers,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
 	"tka/init":                    (*Handler).serveTKAInit,
 	"tka/log":                     (*Handler).serveTKALog,
 	"tka/modify":                  (*Handler).serveTKAModify,
 	"tka/sign":                    (*Handler).serveTKASign,
+	"tka/submit-signature":        (*Handler).serveTKASubmitSignature,
 	"tka/status":                  (*Handler).serveTKAStatus,
 	"tka/disable":                 (*Handler).serveTKADisable,
 	"tka/force-local-disable":     (*Handler).serveTKALocalDisable,
@@ -109,13 +135,130 @@
 	"tka/verify-deeplink":         (*Handler).serveTKAVerifySigningDeeplink,
 	"tka/generate-recovery-aum":   (*Handler).serveTKAGenerateRecoveryAUM,
 	"tka/cosign-recovery-aum":     (*Handler).serveTKACosignRecoveryAUM,
+	"tka/inspect-recovery-aum":    (*Handler).serveTKAInspectRecoveryAUM,
 	"tka/submit-recovery-aum":     (*Handler).serveTKASubmitRecoveryAUM,
+	"update/status":               (*Handler).serveUpdateStatus,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
+	"want-running-schedule":       (*Handler).serveWantRunningSchedule,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
+	"whois-batch":                 (*Handler).serveWhoIsBatch,
+	"wait":                        (*Handler).serveWait,
 	"query-feature":               (*Handler).serveQueryFeature,
 }
 
+// scopedPrefs are the prefs that callers with only some permissions (see
+// permissionHandlers) may change. They're the connectivity choices of the
+// device's user; all other prefs, including those added later, need full
+// LocalAPI access, as they could give callers more access than their
+// permissions grant: full LocalAPI access with OperatorUser, a shell with
+// RunSSH, the node itself with ControlURL, local networks with
+// AdvertiseRoutes, and so on.
+var scopedPrefs = []string{
+	"WantRunning",
+	"ShieldsUp",
+	"RouteAll",
+	"CorpDNS",
+	"ExitNodeID",
+	"ExitNodeIP",
+	"ExitNodeAllowLANAccess",
+	"Hostname",
+}
+
+// checkScopedPrefsEdit returns an error if mp changes any prefs other than
+// the scopedPrefs.
+func checkScopedPrefsEdit(mp *ipn.MaskedPrefs) error {
+	mv := reflect.ValueOf(mp).Elem()
+	mt := mv.Type()
+	for i := 0; i < mt.NumField(); i++ {
+		name, ok := strings.CutSuffix(mt.Field(i).Name, "Set")
+		if !ok || mt.Field(i).Type.Kind() != reflect.Bool || !mv.Field(i).Bool() {
+			continue
+		}
+		if !slices.Contains(scopedPrefs, name) {
+			return fmt.Errorf("changing %s requires full LocalAPI access", name)
+		}
+	}
+	return nil
+}
+
+// checkScopedServeConfig returns an error if a caller with only some
+// permissions may not replace the serve config cur with sc, because sc adds
+// a handler that serves local files or forwards TCP to local ports. Proxies
+// to loopback ports, as in "tailscale serve 3000", are allowed, and so are
+// the handlers already in cur, so other handlers can still be changed.
+func checkScopedServeConfig(cur ipn.ServeConfigView, sc *ipn.ServeConfig) error {
+	have := cur.LocalTargets()
+	for _, t := range sc.LocalTargets() {
+		if proxy, ok := strings.CutPrefix(t, "proxy "); ok && isLoopbackProxy(proxy) {
+			continue
+		}
+		if !slices.Contains(have, t) {
+			return fmt.Errorf("serving %s requires full LocalAPI access", t)
+		}
+	}
+	return nil
+}
+
+// isLoopbackProxy reports whether the serve proxy target s, in any of the
+// forms HTTPHandler.Proxy accepts, is an HTTP(S) server on a loopback port.
+func isLoopbackProxy(s string) bool {
+	if _, err := strconv.ParseUint(s, 10, 16); err == nil {
+		return true // a port on 127.0.0.1
+	}
+	if !strings.Contains(s, "://") {
+		s = "http://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Port() == "" || u.User != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "https+insecure", "h2c":
+	default:
+		return false
+	}
+	if u.Hostname() == "localhost" {
+		return true
+	}
+	ip, err := netip.ParseAddr(u.Hostname())
+	return err == nil && ip.IsLoopback()
+}
+
+// permissionHandlers are the handlers (keys of handler) that callers with
+// each operator permission may use as if they had PermitWrite. Local users
+// are granted permissions by the config file (ipn.OperatorGrant), and
+// LocalAPI tokens by their scopes (ipn.LocalAPIToken.Permissions). Either
+// way the handlers keep them from escalating: see scopedPrefs and
+// checkScopedServeConfig.
+var permissionHandlers = map[string][]string{
+	ipn.OperatorPermStatusRead: {"status", "whois", "whois-batch", "wait", "health", "bandwidth-usage", "netcheck-history"},
+	ipn.OperatorPermServeWrite: {"serve-config"},
+	ipn.OperatorPermPrefsWrite: {"prefs", "prefs/provenance", "check-prefs"},
+	ipn.OperatorPermFilesRW:    {"files/", "file-put/", "file-targets"},
+}
+
+// permissionsPermit reports whether any of the operator permissions perms
+// permits requests to urlPath.
+func permissionsPermit(perms []string, urlPath string) bool {
+	name, ok := strings.CutPrefix(urlPath, "/localapi/v0/")
+	if !ok {
+		return false
+	}
+	if _, ok := handler[name]; !ok {
+		// A prefix match, such as "files/NAME".
+		if i := strings.IndexByte(name, '/'); i != -1 {
+			name = name[:i+1]
+		}
+	}
+	for _, p := range perms {
+		if slices.Contains(permissionHandlers[p], name) {
+			return true
+		}
+	}
+	return false
+}
+
 var (
 	// The clientmetrics package is stateful, but we want to expose a simple
 	// imperative API to local clients, so we need to keep track of
@@ -151,11 +294,28 @@ type Handler struct {
 	// cert fetching access.
 	PermitCert bool
 
+	// PermitAdmin is whether the client is root or a local admin, as
+	// opposed to the operator user, who also has PermitWrite. Managing
+	// LocalAPI tokens requires it.
+	PermitAdmin bool
+
+	// OperatorPermissions are the operator permissions granted to the
+	// client (see ipn.OperatorPermissions). The handlers they permit are
+	// allowed as if PermitWrite were true, within the limits described at
+	// permissionHandlers.
+	OperatorPermissions []string
+
 	b            *ipnlocal.LocalBackend
 	logf         logger.Logf
 	netMon       *netmon.Monitor // optional; nil means interfaces will be looked up on-demand
 	backendLogID logid.PublicID
 	clock        tstime.Clock
+
+	// scoped is whether the request is only permitted by operator
+	// permissions, from OperatorPermissions or a LocalAPI token. The
+	// handlers in permissionHandlers must keep such callers from gaining
+	// more access than their permissions grant.
+	scoped bool
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -186,6 +346,27 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	perms := h.OperatorPermissions
+	if tok := r.Header.Get(ipn.LocalAPITokenHeader); tok != "" {
+		// A token replaces the caller's own permissions with those
+		// of its scopes.
+		t, ok := h.b.CheckLocalAPIToken(tok)
+		if !ok {
+			metricInvalidRequests.Add(1)
+			http.Error(w, "invalid API token", http.StatusForbidden)
+			return
+		}
+		if !permissionsPermit(t.Permissions(), r.URL.Path) {
+			http.Error(w, "API token scopes don't permit "+r.URL.Path, http.StatusForbidden)
+			return
+		}
+		h.PermitRead, h.PermitWrite, h.PermitCert, h.PermitAdmin = false, false, false, false
+		perms = t.Permissions()
+	}
+	if !h.PermitWrite && permissionsPermit(perms, r.URL.Path) {
+		h.PermitRead, h.PermitWrite = true, true
+		h.scoped = true
+	}
 	if fn, ok := handlerForPath(r.URL.Path); ok {
 		fn(h, w, r)
 	} else {
@@ -298,113 +479,6 @@ func (h *Handler) serveIDToken(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) serveBugReport(w http.ResponseWriter, r *http.Request) {
-	if !h.PermitRead {
-		http.Error(w, "bugreport access denied", http.StatusForbidden)
-		return
-	}
-	if r.Method != "POST" {
-		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
-		return
-	}
-	defer h.b.TryFlushLogs() // kick off upload after bugreport's done logging
-
-	logMarker := func() string {
-		return fmt.Sprintf("BUG-%v-%v-%v", h.backendLogID, h.clock.Now().UTC().Format("20060102150405Z"), rands.HexString(16))
-	}
-	if envknob.NoLogsNoSupport() {
-		logMarker = func() string { return "BUG-NO-LOGS-NO-SUPPORT-this-node-has-had-its-logging-disabled" }
-	}
-
-	startMarker := logMarker()
-	h.logf("user bugreport: %s", startMarker)
-	if note := r.URL.Query().Get("note"); len(note) > 0 {
-		h.logf("user bugreport note: %s", note)
-	}
-	hi, _ := json.Marshal(hostinfo.New())
-	h.logf("user bugreport hostinfo: %s", hi)
-	if err := health.OverallError(); err != nil {
-		h.logf("user bugreport health: %s", err.Error())
-	} else {
-		h.logf("user bugreport health: ok")
-	}
-
-	// Information about the current node from the netmap
-	if nm := h.b.NetMap(); nm != nil {
-		if self := nm.SelfNode; self.Valid() {
-			h.logf("user bugreport node info: nodeid=%q stableid=%q expiry=%q", self.ID(), self.StableID(), self.KeyExpiry().Format(time.RFC3339))
-		}
-		h.logf("user bugreport public keys: machine=%q node=%q", nm.MachineKey, nm.NodeKey)
-	} else {
-		h.logf("user bugreport netmap: no active netmap")
-	}
-
-	// Print all envknobs; we otherwise only print these on startup, and
-	// printing them here ensures we don't have to go spelunking through
-	// logs for them.
-	envknob.LogCurrent(logger.WithPrefix(h.logf, "user bugreport: "))
-
-	// OS-specific details
-	osdiag.LogSupportInfo(logger.WithPrefix(h.logf, "user bugreport OS: "), osdiag.LogSupportInfoReasonBugReport)
-
-	if defBool(r.URL.Query().Get("diagnose"), false) {
-		h.b.Doctor(r.Context(), logger.WithPrefix(h.logf, "diag: "))
-	}
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintln(w, startMarker)
-
-	// Nothing else to do if we're not in record mode; we wrote the marker
-	// above, so we can just finish our response now.
-	if !defBool(r.URL.Query().Get("record"), false) {
-		return
-	}
-
-	until := h.clock.Now().Add(12 * time.Hour)
-
-	var changed map[string]bool
-	for _, component := range []string{"magicsock"} {
-		if h.b.GetComponentDebugLogging(component).IsZero() {
-			if err := h.b.SetComponentDebugLogging(component, until); err != nil {
-				h.logf("bugreport: error setting component %q logging: %v", component, err)
-				continue
-			}
-
-			mak.Set(&changed, component, true)
-		}
-	}
-	defer func() {
-		for component := range changed {
-			h.b.SetComponentDebugLogging(component, time.Time{})
-		}
-	}()
-
-	// NOTE(andrew): if we have anything else we want to do while recording
-	// a bugreport, we can add it here.
-
-	// Read from the client; this will also return when the client closes
-	// the connection.
-	var buf [1]byte
-	_, err := r.Body.Read(buf[:])
-
-	switch {
-	case err == nil:
-		// good
-	case errors.Is(err, io.EOF):
-		// good
-	case errors.Is(err, io.ErrUnexpectedEOF):
-		// this happens when Ctrl-C'ing the tailscale client; don't
-		// bother logging an error
-	default:
-		// Log but continue anyway.
-		h.logf("user bugreport: error reading body: %v", err)
-	}
-
-	// Generate another log marker and return it to the client.
-	endMarker := logMarker()
-	h.logf("user bugreport end: %s", endMarker)
-	fmt.Fprintln(w, endMarker)
-}
-
 func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "whois access denied", http.StatusForbidden)
@@ -442,6 +516,55 @@ func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+// maxWhoIsBatch is the most addresses that a whois-batch request may look up.
+const maxWhoIsBatch = 10000
+
+// serveWhoIsBatch is like serveWhoIs, for many addresses in one request,
+// which are all looked up in the same netmap. Addresses may be IP:port or,
+// for Tailscale IPs, just the IP.
+func (h *Handler) serveWhoIsBatch(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "whois access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	var req apitype.WhoIsBatchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", 400)
+		return
+	}
+	if len(req.Addrs) > maxWhoIsBatch {
+		http.Error(w, fmt.Sprintf("too many addresses; max %d", maxWhoIsBatch), 400)
+		return
+	}
+	ipps := make([]netip.AddrPort, len(req.Addrs))
+	for i, v := range req.Addrs {
+		ipp, err := netip.ParseAddrPort(v)
+		if err != nil {
+			ip, err := netip.ParseAddr(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid address %q", v), 400)
+				return
+			}
+			ipp = netip.AddrPortFrom(ip, 0)
+		}
+		ipps[i] = ipp
+	}
+	res := &apitype.WhoIsBatchResponse{
+		Results: make(map[string]*apitype.WhoIsResponse),
+	}
+	for i, wr := range h.b.WhoIsBatch(ipps) {
+		if wr != nil {
+			res.Results[req.Addrs[i]] = wr
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveGoroutines(w http.ResponseWriter, r *http.Request) {
 	// Require write access out of paranoia that the goroutine dump
 	// (at least its arguments) might contain something sensitive.
@@ -619,6 +742,31 @@ func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.R
 	enc.Encode(nm.PacketFilter)
 }
 
+// serveDebugPacketFilterLatency times lookups in the packet filter's
+// rules and reports their latency percentiles.
+func (h *Handler) serveDebugPacketFilterLatency(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	n := 10000
+	if v := r.FormValue("lookups"); v != "" {
+		var err error
+		n, err = strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 1e6 {
+			http.Error(w, "invalid lookups", http.StatusBadRequest)
+			return
+		}
+	}
+	rep, err := h.b.DebugPacketFilterLatency(n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
 func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
@@ -827,23 +975,511 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case "GET":
 		if !h.PermitRead {
-			http.Error(w, "serve config denied", http.StatusForbidden)
+			http.Error(w, "serve config denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		config := h.b.ServeConfig()
+		json.NewEncoder(w).Encode(config)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "serve config denied", http.StatusForbidden)
+			return
+		}
+		configIn := new(ipn.ServeConfig)
+		if err := json.NewDecoder(r.Body).Decode(configIn); err != nil {
+			writeErrorJSON(w, fmt.Errorf("decoding config: %w", err))
+			return
+		}
+		if h.scoped {
+			if err := checkScopedServeConfig(h.b.ServeConfig(), configIn); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+		if err := h.b.SetServeConfig(configIn); err != nil {
+			writeErrorJSON(w, fmt.Errorf("updating config: %w", err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveFirewallRules returns the firewall rules tailscaled wants installed
+// when the host's firewall is managed externally, in the format given by
+// the "format" query parameter ("nftables" by default).
+func (h *Handler) serveFirewallRules(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "firewall rules access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = "nftables"
+	}
+	rules, err := h.b.FirewallRuleset(format)
+	if errors.Is(err, router.ErrNoFirewallRuleset) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, rules)
+}
+
+// serveAccessSummary returns who the packet filter lets reach this node,
+// and on which ports.
+func (h *Handler) serveAccessSummary(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "access-summary access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	sum, err := h.b.AccessSummary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sum)
+}
+
+// serveBandwidthUsage returns the traffic this node has exchanged over
+// Tailscale in the current usage period of its bandwidth quota.
+func (h *Handler) serveBandwidthUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "bandwidth usage access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.BandwidthUsage())
+}
+
+// serveRelayStats returns the traffic forwarded on behalf of each peer as
+// a subnet router or exit node, for the number of days in the optional
+// "days" query parameter.
+func (h *Handler) serveRelayStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "relay stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	var days int
+	if v := r.FormValue("days"); v != "" {
+		var err error
+		days, err = strconv.Atoi(v)
+		if err != nil || days < 0 {
+			http.Error(w, "invalid days", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.RelayStats(days))
+}
+
+// serveNetcheckHistory returns the trends of the netcheck samples recorded
+// in the number of hours in the optional "hours" query parameter.
+func (h *Handler) serveNetcheckHistory(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "netcheck history access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusBadRequest)
+		return
+	}
+	var hours int
+	if v := r.FormValue("hours"); v != "" {
+		var err error
+		hours, err = strconv.Atoi(v)
+		if err != nil || hours < 0 {
+			http.Error(w, "invalid hours", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.NetcheckHistory(hours))
+}
+
+// serveReloadConfig re-reads the config file tailscaled was started with,
+// applying it if it changed.
+func (h *Handler) serveReloadConfig(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", http.StatusMethodNotAllowed)
+		return
+	}
+	changed, err := h.b.ReloadConfig()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{ Changed bool }{changed})
+}
+
+// serveHealth returns the current health problems as a health.State.
+func (h *Handler) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "health access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	st := health.CurrentState()
+	if p := printerFor(r); p.Locale() != i18n.DefaultLocale {
+		for i, uw := range st.Warnings {
+			st.Warnings[i].Text = warningText(p, uw)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// serveWait blocks until the comma-separated conditions in the "for"
+// parameter hold, as described at LocalBackend.WaitFor, for at most the
+// optional "timeout" duration.
+func (h *Handler) serveWait(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "wait access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	var conds []string
+	if v := r.FormValue("for"); v != "" {
+		conds = strings.Split(v, ",")
+	}
+	ctx := r.Context()
+	if v := r.FormValue("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			http.Error(w, "invalid 'timeout' parameter", 400)
+			return
+		}
+		if d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+	}
+	if err := h.b.WaitFor(ctx, conds); err != nil {
+		if ctx.Err() != nil {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, err.Error(), 400)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}\n")
+}
+
+func (h *Handler) servePeerTraffic(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "peer traffic access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.PeerTraffic())
+}
+
+// serveConnStats streams the per-connection statistics of the node's
+// traffic as JSON connstats.Snapshots, one per line, per the period,
+// max-conns, sample and physical query parameters.
+func (h *Handler) serveConnStats(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "conn stats access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "not a flusher", http.StatusInternalServerError)
+		return
+	}
+	var opts connstats.SubscribeOptions
+	var err error
+	if v := r.FormValue("period"); v != "" {
+		if opts.Period, err = time.ParseDuration(v); err != nil || opts.Period < time.Second {
+			http.Error(w, "invalid period; must be at least 1s", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("max-conns"); v != "" {
+		if opts.MaxConns, err = strconv.Atoi(v); err != nil || opts.MaxConns < 0 {
+			http.Error(w, "invalid max-conns", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.FormValue("sample"); v != "" {
+		if opts.SampleRate, err = strconv.ParseFloat(v, 64); err != nil || opts.SampleRate < 0 || opts.SampleRate > 1 {
+			http.Error(w, "invalid sample; must be in [0, 1]", http.StatusBadRequest)
+			return
+		}
+	}
+	opts.Physical = defBool(r.FormValue("physical"), false)
+
+	ctx := r.Context()
+	snaps := make(chan connstats.Snapshot, 16)
+	unsubscribe := h.b.SubscribeConnStats(opts, func(snap connstats.Snapshot) {
+		select {
+		case snaps <- snap:
+		case <-ctx.Done():
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case snap := <-snaps:
+			if err := enc.Encode(snap); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// serveUpdateStatus reports the state of automatic updates: the available
+// version, whether the update policy in the prefs is deferring it, and the
+// version most recently applied.
+func (h *Handler) serveUpdateStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "update status access denied", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.AutoUpdateStatus())
+}
+
+// serveStalePeers returns the peers that hiding peers not seen for the
+// number of days in the "days" query parameter would hide, to preview the
+// HideStalePeersDays pref.
+func (h *Handler) serveStalePeers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	days, err := strconv.Atoi(r.FormValue("days"))
+	if err != nil || days <= 0 {
+		http.Error(w, "invalid 'days' parameter", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.b.StalePeers(days))
+}
+
+// servePeerNotes gets or updates the nicknames and notes assigned to peers.
+//
+// A POST merges the provided notes into the existing ones, unless the
+// "replace" query parameter is true, in which case they replace them.
+func (h *Handler) servePeerNotes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "peer notes access denied", http.StatusForbidden)
+			return
+		}
+		notes, err := h.b.PeerNotes()
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notes)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "peer notes access denied", http.StatusForbidden)
+			return
+		}
+		var notes map[tailcfg.StableNodeID]ipn.PeerNote
+		if err := json.NewDecoder(r.Body).Decode(&notes); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		replace, _ := strconv.ParseBool(r.FormValue("replace"))
+		if err := h.b.SetPeerNotes(notes, replace); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveExitNodeConsent lists the notices of exit node changes made by policy
+// (GET), or acknowledges the one with the "id" parameter, or all pending
+// ones without it (POST).
+func (h *Handler) serveExitNodeConsent(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "exit node consent access denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.ExitNodeConsents())
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "exit node consent access denied", http.StatusForbidden)
+			return
+		}
+		if err := h.b.AcknowledgeExitNodeConsent(r.FormValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveAPITokens lists (GET), creates (POST) or revokes (DELETE, with an
+// "id" parameter) LocalAPI tokens.
+func (h *Handler) serveAPITokens(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitAdmin {
+		http.Error(w, "API token access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		toks, err := h.b.LocalAPITokens()
+		if err != nil {
+			writeErrorJSON(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(toks)
+	case "POST":
+		var req ipn.CreateLocalAPITokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		tok, info, err := h.b.CreateLocalAPIToken(req.Name, req.Scopes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ipn.CreateLocalAPITokenResponse{Token: tok, Info: info})
+	case "DELETE":
+		if err := h.b.RevokeLocalAPIToken(r.FormValue("id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveWantRunningSchedule gets or sets the pending timed change of the
+// WantRunning pref.
+//
+// A POST of a null body cancels it.
+func (h *Handler) serveWantRunningSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "want-running-schedule access denied", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.WantRunningSchedule())
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "want-running-schedule access denied", http.StatusForbidden)
+			return
+		}
+		var s *ipn.WantRunningSchedule
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetWantRunningSchedule(s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveProxyConfig gets or sets the HTTP proxy configuration used for
+// outbound connections to control, DERP and log servers.
+//
+// A POST of a null body reverts to the proxy settings of the environment.
+func (h *Handler) serveProxyConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		if !h.PermitRead {
+			http.Error(w, "proxy config denied", http.StatusForbidden)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		config := h.b.ServeConfig()
-		json.NewEncoder(w).Encode(config)
+		json.NewEncoder(w).Encode(tshttpproxy.GetConfig().Redacted())
 	case "POST":
 		if !h.PermitWrite {
-			http.Error(w, "serve config denied", http.StatusForbidden)
+			http.Error(w, "proxy config denied", http.StatusForbidden)
 			return
 		}
-		configIn := new(ipn.ServeConfig)
-		if err := json.NewDecoder(r.Body).Decode(configIn); err != nil {
+		var c *tshttpproxy.Config
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
 			writeErrorJSON(w, fmt.Errorf("decoding config: %w", err))
 			return
 		}
-		if err := h.b.SetServeConfig(configIn); err != nil {
+		if err := tshttpproxy.SetConfig(h.logf, c); err != nil {
 			writeErrorJSON(w, fmt.Errorf("updating config: %w", err))
 			return
 		}
@@ -853,6 +1489,29 @@ func (h *Handler) serveServeConfig(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveProxyCredentials sets the credentials for the proxy in the Proxy
+// pref. It's write-only: nothing returns them once set.
+func (h *Handler) serveProxyCredentials(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "proxy credentials access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	creds, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 4<<10))
+	if err != nil {
+		http.Error(w, "reading body", http.StatusBadRequest)
+		return
+	}
+	if err := h.b.SetProxyCredentials(string(creds)); err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
@@ -875,16 +1534,95 @@ func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "status access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	var st *ipnstate.Status
-	if defBool(r.FormValue("peers"), true) {
-		st = h.b.Status()
-	} else {
-		st = h.b.StatusWithoutPeers()
+	status := h.b.Status
+	if !defBool(r.FormValue("peers"), true) {
+		status = h.b.StatusWithoutPeers
+	}
+	p := printerFor(r)
+	serveConditionalJSON(h, w, r, func() *ipnstate.Status {
+		st := status()
+		localizeHealth(p, st.Health)
+		return st
+	})
+}
+
+// maxLongPoll is the longest that a conditional GET request with a
+// "waitsec" parameter waits for its resource to change.
+const maxLongPoll = 5 * time.Minute
+
+// longPollRecheckInterval is how often a long-polling request rechecks its
+// resource for changes that aren't announced on the IPN bus, such as those
+// of peers' traffic counters.
+const longPollRecheckInterval = 2 * time.Second
+
+// etagOf returns the HTTP entity tag for the contents of v.
+func etagOf[T any](v *T) string {
+	return `"` + deephash.Hash(v).String() + `"`
+}
+
+// serveConditionalJSON writes the JSON of the resource returned by get,
+// along with its ETag, for pollers of large resources such as the status
+// to avoid having it serialized and sent every time.
+//
+// If the request's If-None-Match header matches the resource's ETag, it
+// responds 304 Not Modified without a body instead. If the request also has
+// a "waitsec" parameter, it first waits up to that many seconds (at most
+// maxLongPoll) for the resource to change, responding with it as soon as
+// it does.
+func serveConditionalJSON[T any](h *Handler, w http.ResponseWriter, r *http.Request, get func() *T) {
+	v := get()
+	etag := etagOf(v)
+	if inm := r.Header.Get("If-None-Match"); inm == etag {
+		if s := r.FormValue("waitsec"); s != "" && s != "0" {
+			secs, err := strconv.Atoi(s)
+			if err != nil || secs < 0 {
+				http.Error(w, "invalid waitsec", http.StatusBadRequest)
+				return
+			}
+			v, etag = waitForChange(r.Context(), h.b, min(time.Duration(secs)*time.Second, maxLongPoll), etag, get)
+		}
+		if etag == inm {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
 	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/json")
 	e := json.NewEncoder(w)
 	e.SetIndent("", "\t")
-	e.Encode(st)
+	e.Encode(v)
+}
+
+// waitForChange waits up to d for the ETag of the resource returned by get
+// to differ from etag, rechecking it on each IPN bus notification and every
+// longPollRecheckInterval. It returns the resource and its ETag if it
+// changed, or a nil resource and etag if it didn't.
+func waitForChange[T any](ctx context.Context, b *ipnlocal.LocalBackend, d time.Duration, etag string, get func() *T) (*T, string) {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	notified := make(chan struct{}, 1)
+	go b.WatchNotifications(ctx, 0, nil, func(*ipn.Notify) bool {
+		select {
+		case notified <- struct{}{}:
+		default:
+		}
+		return true
+	})
+	t := time.NewTicker(longPollRecheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, etag
+		case <-notified:
+		case <-t.C:
+		}
+		v := get()
+		if newTag := etagOf(v); newTag != etag {
+			return v, newTag
+		}
+	}
 }
 
 func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.Request) {
@@ -915,6 +1653,80 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+// serveDebugEndpoints writes the endpoint candidates of this node and of the
+// peer with Tailscale IP "ip" as a JSON ipnstate.EndpointCandidates.
+func (h *Handler) serveDebugEndpoints(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", 400)
+		return
+	}
+	cs, err := h.b.GetPeerEndpointCandidates(r.Context(), ip)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(cs)
+}
+
+// serveDebugNetmon writes the current network state as a JSON
+// ipnstate.NetworkEvent. With "follow=true", it then streams further events
+// (link changes and captive portal check results) until the client goes
+// away.
+func (h *Handler) serveDebugNetmon(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	follow, _ := strconv.ParseBool(r.FormValue("follow"))
+	f, ok := w.(http.Flusher)
+	if follow && !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	// Register before taking the snapshot so no event is missed in between.
+	evc := make(chan ipnstate.NetworkEvent, 16)
+	if follow {
+		unreg := h.b.WatchNetworkEvents(evc)
+		defer unreg()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(h.b.CurrentNetworkEvent()); err != nil || !follow {
+		return
+	}
+	f.Flush()
+
+	ctx := r.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-evc:
+			if err := enc.Encode(ev); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
 // handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
 // and returns true. Otherwise it returns false, in which case it doesn't write
@@ -1005,6 +1817,7 @@ func (h *Handler) serveStart(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	o.PrefSource = ipn.PrefSourceFromHeader(r.Header.Get(ipn.PrefSourceHeader))
 	err := h.b.Start(o)
 	if err != nil {
 		// TODO(bradfitz): map error to a good HTTP error
@@ -1048,8 +1861,14 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 400)
 			return
 		}
+		if h.scoped {
+			if err := checkScopedPrefsEdit(mp); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
 		var err error
-		prefs, err = h.b.EditPrefs(mp)
+		prefs, err = h.b.EditPrefsAs(mp, ipn.PrefSourceFromHeader(r.Header.Get(ipn.PrefSourceHeader)))
 		if err != nil {
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
@@ -1057,7 +1876,11 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	case "GET", "HEAD":
-		prefs = h.b.Prefs()
+		serveConditionalJSON(h, w, r, func() *ipn.PrefsView {
+			prefs := h.b.Prefs()
+			return &prefs
+		})
+		return
 	default:
 		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
 		return
@@ -1068,6 +1891,23 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 	e.Encode(prefs)
 }
 
+// servePrefProvenance reports where the current prefs came from, keyed by
+// pref name.
+func (h *Handler) servePrefProvenance(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "prefs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.PrefProvenance())
+}
+
 type resJSON struct {
 	Error string `json:",omitempty"`
 }
@@ -1292,6 +2132,139 @@ func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	e.Encode(h.b.DERPMap())
 }
 
+// serveDNSQueryLog serves the DNS resolver's query log as a JSON
+// ipnstate.DNSQueryLog on GET, with only the entries after the "since"
+// sequence number and, with "waitsec", waiting up to that many seconds for
+// one if there are none yet. A POST of a JSON ipnstate.DNSQueryLogConfig
+// configures the log.
+func (h *Handler) serveDNSQueryLog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Require write access, as the log holds the DNS lookups of
+		// every local user, not just the caller's.
+		if !h.PermitWrite {
+			http.Error(w, "dns-query-log access denied", http.StatusForbidden)
+			return
+		}
+		var since uint64
+		if s := r.FormValue("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+		ctx := r.Context()
+		var wait bool
+		if s := r.FormValue("waitsec"); s != "" && s != "0" {
+			secs, err := strconv.Atoi(s)
+			if err != nil || secs < 0 {
+				http.Error(w, "invalid waitsec", http.StatusBadRequest)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, min(time.Duration(secs)*time.Second, maxLongPoll))
+			defer cancel()
+			wait = true
+		}
+		ql, err := h.b.DNSQueryLog(ctx, since, wait)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ql)
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "dns-query-log access denied", http.StatusForbidden)
+			return
+		}
+		var cfg ipnstate.DNSQueryLogConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cfg.Size > maxDNSQueryLogSize {
+			http.Error(w, fmt.Sprintf("size must be at most %d", maxDNSQueryLogSize), http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetDNSQueryLogConfig(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// maxDNSQueryLogSize is the most DNS queries the query log may keep.
+const maxDNSQueryLogSize = 100_000
+
+// serveFlowLog serves the audit log of inbound flows as a JSON
+// ipnstate.FlowLog on GET, with only the entries after the "since" sequence
+// number and, with "waitsec", waiting up to that many seconds for one if
+// there are none yet. A POST of a JSON ipnstate.FlowLogConfig configures
+// the log.
+func (h *Handler) serveFlowLog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		// Require write access, as the log identifies the peers
+		// connecting to every local user's services.
+		if !h.PermitWrite {
+			http.Error(w, "flow-log access denied", http.StatusForbidden)
+			return
+		}
+		var since uint64
+		if s := r.FormValue("since"); s != "" {
+			var err error
+			if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "invalid since", http.StatusBadRequest)
+				return
+			}
+		}
+		ctx := r.Context()
+		var wait bool
+		if s := r.FormValue("waitsec"); s != "" && s != "0" {
+			secs, err := strconv.Atoi(s)
+			if err != nil || secs < 0 {
+				http.Error(w, "invalid waitsec", http.StatusBadRequest)
+				return
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, min(time.Duration(secs)*time.Second, maxLongPoll))
+			defer cancel()
+			wait = true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.b.FlowLog(ctx, since, wait))
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "flow-log access denied", http.StatusForbidden)
+			return
+		}
+		var cfg ipnstate.FlowLogConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if cfg.Size > maxFlowLogSize {
+			http.Error(w, fmt.Sprintf("size must be at most %d", maxFlowLogSize), http.StatusBadRequest)
+			return
+		}
+		if err := h.b.SetFlowLogConfig(cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "GET or POST required", http.StatusMethodNotAllowed)
+	}
+}
+
+// maxFlowLogSize is the most flows the flow log may keep.
+const maxFlowLogSize = 100_000
+
 // serveSetExpirySooner sets the expiry date on the current machine, specified
 // by an `expiry` unix timestamp as POST or query param.
 func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
@@ -1394,8 +2367,21 @@ func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The Dial-Network header, if present, is "tcp" or "udp". UDP
+	// datagrams are framed over the connection by a
+	// netutil.PacketStreamConn.
+	network := r.Header.Get("Dial-Network")
+	switch network {
+	case "":
+		network = "tcp"
+	case "tcp", "udp":
+	default:
+		http.Error(w, "unsupported Dial-Network", http.StatusBadRequest)
+		return
+	}
+
 	addr := net.JoinHostPort(hostStr, portStr)
-	outConn, err := h.b.Dialer().UserDial(r.Context(), "tcp", addr)
+	outConn, err := h.b.Dialer().UserDial(r.Context(), network, addr)
 	if err != nil {
 		http.Error(w, "dial failure: "+err.Error(), http.StatusBadGateway)
 		return
@@ -1416,6 +2402,9 @@ func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	reqConn = netutil.NewDrainBufConn(reqConn, brw.Reader)
+	if network == "udp" {
+		reqConn = netutil.NewPacketStreamConn(reqConn)
+	}
 
 	errc := make(chan error, 1)
 	go func() {
@@ -1542,6 +2531,33 @@ type signRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) serveTKASubmitSignature(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "lock status access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type submitRequest struct {
+		Signature tkatype.MarshaledSignature
+	}
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.b.NetworkLockSubmitSignature(req.Signature); err != nil {
+		http.Error(w, "submitting signature failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock init access denied", http.StatusForbidden)
@@ -1840,6 +2856,37 @@ func (h *Handler) serveTKACosignRecoveryAUM(w http.ResponseWriter, r *http.Reque
 	w.Write(res.Serialize())
 }
 
+func (h *Handler) serveTKAInspectRecoveryAUM(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.LimitReader(r.Body, 1024*1024)
+	aumBytes, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, "reading AUM", http.StatusBadRequest)
+		return
+	}
+	var aum tka.AUM
+	if err := aum.Unserialize(aumBytes); err != nil {
+		http.Error(w, "decoding AUM", http.StatusBadRequest)
+		return
+	}
+
+	res, err := h.b.NetworkLockInspectRecoveryAUM(&aum)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveTKASubmitRecoveryAUM(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "access denied", http.StatusForbidden)
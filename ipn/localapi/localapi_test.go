@@ -6,15 +6,27 @@
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/hostinfo"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tsd"
 	"tailscale.com/tstest"
+	"tailscale.com/tstime"
+	"tailscale.com/types/logid"
+	"tailscale.com/wgengine"
 )
 
 func TestValidHost(t *testing.T) {
@@ -77,3 +89,354 @@ func TestSetPushDeviceToken(t *testing.T) {
 		t.Errorf("hostinfo.PushDeviceToken=%q, want %q", got, want)
 	}
 }
+
+func TestTokenPermits(t *testing.T) {
+	status := ipn.LocalAPIToken{Scopes: []string{ipn.LocalAPIScopeReadStatus}}
+	serve := ipn.LocalAPIToken{Scopes: []string{ipn.LocalAPIScopeManageServe, ipn.LocalAPIScopeManagePrefs}}
+	tests := []struct {
+		tok  ipn.LocalAPIToken
+		path string
+		want bool
+	}{
+		{status, "/localapi/v0/status", true},
+		{status, "/localapi/v0/whois", true},
+		{status, "/localapi/v0/whois-batch", true},
+		{status, "/localapi/v0/health", true},
+		{status, "/localapi/v0/prefs", false},
+		{status, "/localapi/v0/api-tokens", false},
+		{serve, "/localapi/v0/serve-config", true},
+		{serve, "/localapi/v0/prefs", true},
+		{serve, "/localapi/v0/status", false},
+		{serve, "/localapi/v0/serve-config/extra", false},
+		{serve, "/serve-config", false},
+	}
+	for _, tt := range tests {
+		if got := permissionsPermit(tt.tok.Permissions(), tt.path); got != tt.want {
+			t.Errorf("permissionsPermit(%q, %q) = %v; want %v", tt.tok.Scopes, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPermissionsPermit(t *testing.T) {
+	for perm, names := range permissionHandlers {
+		if !slices.Contains(ipn.OperatorPermissions, perm) {
+			t.Errorf("unknown operator permission %q", perm)
+		}
+		for _, name := range names {
+			if _, ok := handler[name]; !ok {
+				t.Errorf("operator permission %q: unknown handler %q", perm, name)
+			}
+		}
+	}
+
+	status := []string{ipn.OperatorPermStatusRead}
+	files := []string{ipn.OperatorPermFilesRW, ipn.OperatorPermServeWrite}
+	tests := []struct {
+		perms []string
+		path  string
+		want  bool
+	}{
+		{nil, "/localapi/v0/status", false},
+		{status, "/localapi/v0/status", true},
+		{status, "/localapi/v0/metrics", false},
+		{status, "/localapi/v0/prefs", false},
+		{files, "/localapi/v0/files/", true},
+		{files, "/localapi/v0/files/report.pdf", true},
+		{files, "/localapi/v0/file-put/nXYZ/report.pdf", true},
+		{files, "/localapi/v0/serve-config", true},
+		{files, "/localapi/v0/serve-config/extra", false},
+		{files, "/localapi/v0/logout", false},
+		{files, "/files/", false},
+	}
+	for _, tt := range tests {
+		if got := permissionsPermit(tt.perms, tt.path); got != tt.want {
+			t.Errorf("permissionsPermit(%q, %q) = %v; want %v", tt.perms, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBugReportRedactor(t *testing.T) {
+	rd := &bugReportRedactor{
+		hosts: []string{"laptop.tail1234.ts.net", "tail1234.ts.net", "laptop"},
+		ips:   true,
+	}
+	tests := []struct {
+		in, want string
+	}{
+		{"peer laptop.tail1234.ts.net. at 100.64.1.2:41641", "peer [host-1]. at [ip-1]:41641"},
+		{"LAPTOP is up; laptops and my-laptop aren't", "[host-2] is up; laptops and my-laptop aren't"},
+		{"dns: 100.100.100.100, 127.0.0.1 and [fd7a:115c:a1e0::1]:53", "dns: 100.100.100.100, 127.0.0.1 and [[ip-2]]:53"},
+		{"again 100.64.1.2 at 12:34:56 via other.tail1234.ts.net", "again [ip-1] at 12:34:56 via other.[host-3]"},
+		{"nodekey:abcdef mac=aa:bb:cc:dd:ee:ff", "nodekey:abcdef mac=aa:bb:cc:dd:ee:ff"},
+	}
+	for _, tt := range tests {
+		if got := rd.redact(tt.in); got != tt.want {
+			t.Errorf("redact(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBugReportPreview(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	var logged []string
+	h := &Handler{
+		PermitRead: true,
+		b:          &ipnlocal.LocalBackend{},
+		logf: func(format string, args ...any) {
+			logged = append(logged, fmt.Sprintf(format, args...))
+		},
+		clock: tstime.StdClock{},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+	post := func(query string) *http.Response {
+		t.Helper()
+		res, err := s.Client().Post(s.URL+"/localapi/v0/bugreport?"+query, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	res := post("preview=true&redact=ips&note=" + url.QueryEscape("broken since 192.0.2.7"))
+	var p apitype.BugReportPreview
+	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Lines) == 0 || p.Lines[0] != "user bugreport note: broken since [ip-1]" {
+		t.Errorf("preview lines = %q; want redacted note first", p.Lines)
+	}
+	if len(logged) != 0 {
+		t.Errorf("preview logged %q; want nothing", logged)
+	}
+
+	res = post("preview-id=" + p.ID)
+	marker, _ := io.ReadAll(res.Body)
+	if !strings.HasPrefix(string(marker), "BUG-") {
+		t.Errorf("marker = %q", marker)
+	}
+	want := append([]string{"user bugreport: " + strings.TrimSpace(string(marker))}, p.Lines...)
+	if !reflect.DeepEqual(logged, want) {
+		t.Errorf("logged %q; want %q", logged, want)
+	}
+
+	// A preview can only be submitted once.
+	if res := post("preview-id=" + p.ID); res.StatusCode != http.StatusNotFound {
+		t.Errorf("resubmitting preview: status %d; want 404", res.StatusCode)
+	}
+	if res := post("redact=nope"); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad redact: status %d; want 400", res.StatusCode)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+	logf := tstest.WhileTestRunningLogger(t)
+	sys := new(tsd.System)
+	sys.Set(new(mem.Store))
+	e, err := wgengine.NewFakeUserspaceEngine(logf, sys.Set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Set(e)
+	t.Cleanup(e.Close)
+	b, err := ipnlocal.NewLocalBackend(logf, logid.PublicID{}, sys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Shutdown)
+	if err := b.Start(ipn.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(b, logf, nil, logid.PublicID{})
+	h.PermitRead = true
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	get := func(path, etag string, wantCode int) (string, []byte) {
+		t.Helper()
+		req, err := http.NewRequest("GET", s.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != wantCode {
+			t.Fatalf("GET %s: status %v; want %v: %s", path, res.StatusCode, wantCode, body)
+		}
+		return res.Header.Get("ETag"), body
+	}
+
+	for _, path := range []string{"/localapi/v0/status", "/localapi/v0/prefs"} {
+		etag, _ := get(path, "", http.StatusOK)
+		if etag == "" {
+			t.Fatalf("GET %s: no ETag", path)
+		}
+		if got, body := get(path, etag, http.StatusNotModified); got != etag || len(body) > 0 {
+			t.Errorf("GET %s: not modified response has ETag %q, body %q", path, got, body)
+		}
+		get(path, `"stale"`, http.StatusOK)
+	}
+
+	etag, _ := get("/localapi/v0/prefs", "", http.StatusOK)
+	time.AfterFunc(100*time.Millisecond, func() {
+		b.EditPrefs(&ipn.MaskedPrefs{
+			Prefs:        ipn.Prefs{ShieldsUp: true},
+			ShieldsUpSet: true,
+		})
+	})
+	newTag, body := get("/localapi/v0/prefs?waitsec=10", etag, http.StatusOK)
+	if newTag == etag {
+		t.Errorf("ETag didn't change after prefs edit")
+	}
+	var p ipn.Prefs
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !p.ShieldsUp {
+		t.Errorf("long-polled prefs = %v; want shields up", p.Pretty())
+	}
+	get("/localapi/v0/prefs?waitsec=1", newTag, http.StatusNotModified)
+}
+
+func TestCheckScopedPrefsEdit(t *testing.T) {
+	mv := reflect.ValueOf(new(ipn.MaskedPrefs)).Elem()
+	for _, name := range scopedPrefs {
+		if !mv.FieldByName(name + "Set").IsValid() {
+			t.Errorf("unknown scoped pref %q", name)
+		}
+	}
+
+	if err := checkScopedPrefsEdit(&ipn.MaskedPrefs{ShieldsUpSet: true}); err != nil {
+		t.Errorf("ShieldsUp: %v", err)
+	}
+	if err := checkScopedPrefsEdit(&ipn.MaskedPrefs{ExitNodeIDSet: true, ExitNodeAllowLANAccessSet: true}); err != nil {
+		t.Errorf("ExitNodeID: %v", err)
+	}
+	for _, mp := range []*ipn.MaskedPrefs{
+		{Prefs: ipn.Prefs{OperatorUser: "mallory"}, OperatorUserSet: true},
+		{RunSSHSet: true, ShieldsUpSet: true},
+		{MetricsOnTailnetSet: true},
+		{AdvertiseRoutesSet: true},
+		{NetfilterModeSet: true},
+		{AllowedPeersSet: true},
+		{ExitNodeExcludeUIDsSet: true},
+		{DNSRoutesSet: true},
+		{BandwidthQuotaSet: true},
+	} {
+		if err := checkScopedPrefsEdit(mp); err == nil {
+			t.Errorf("%s: got nil error", mp.Pretty())
+		}
+	}
+}
+
+func TestCheckScopedServeConfig(t *testing.T) {
+	web := func(h *ipn.HTTPHandler) *ipn.ServeConfig {
+		return &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"node.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{"/": h}},
+			},
+		}
+	}
+	proxy := web(&ipn.HTTPHandler{Proxy: "http://127.0.0.1:3000"})
+	tests := []struct {
+		name   string
+		cur    *ipn.ServeConfig
+		sc     *ipn.ServeConfig
+		wantOK bool
+	}{
+		{"text", nil, web(&ipn.HTTPHandler{Text: "hi"}), true},
+		{"path", nil, web(&ipn.HTTPHandler{Path: "/etc"}), false},
+		{"proxy", nil, proxy, true},
+		{"proxy-port", nil, web(&ipn.HTTPHandler{Proxy: "3000"}), true},
+		{"proxy-localhost", nil, web(&ipn.HTTPHandler{Proxy: "https+insecure://localhost:8443"}), true},
+		{"proxy-ipv6", nil, web(&ipn.HTTPHandler{Proxy: "http://[::1]:3000"}), true},
+		{"proxy-lan", nil, web(&ipn.HTTPHandler{Proxy: "http://192.168.1.1:80"}), false},
+		{"proxy-no-port", nil, web(&ipn.HTTPHandler{Proxy: "http://127.0.0.1"}), false},
+		{"proxy-unix", nil, web(&ipn.HTTPHandler{Proxy: "unix:/var/run/docker.sock"}), false},
+		{"tcp-forward", nil, &ipn.ServeConfig{TCP: map[uint16]*ipn.TCPPortHandler{22: {TCPForward: "127.0.0.1:22"}}}, false},
+		{"foreground", nil, &ipn.ServeConfig{Foreground: map[string]*ipn.ServeConfig{"s1": proxy}}, true},
+		{"foreground-path", nil, &ipn.ServeConfig{Foreground: map[string]*ipn.ServeConfig{"s1": web(&ipn.HTTPHandler{Path: "/etc"})}}, false},
+		{"existing-path", web(&ipn.HTTPHandler{Path: "/srv"}), web(&ipn.HTTPHandler{Path: "/srv"}), true},
+		{"other-path", web(&ipn.HTTPHandler{Path: "/srv"}), web(&ipn.HTTPHandler{Path: "/etc"}), false},
+	}
+	for _, tt := range tests {
+		err := checkScopedServeConfig(tt.cur.View(), tt.sc)
+		if (err == nil) != tt.wantOK {
+			t.Errorf("%s: checkScopedServeConfig = %v; want ok=%v", tt.name, err, tt.wantOK)
+		}
+	}
+}
+
+func TestOperatorPermissionsCantEscalate(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	h := &Handler{
+		PermitRead:          true,
+		OperatorPermissions: []string{ipn.OperatorPermPrefsWrite, ipn.OperatorPermServeWrite},
+		b:                   &ipnlocal.LocalBackend{},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	for _, tt := range []struct {
+		method, path string
+		body         any
+	}{
+		{"PATCH", "/localapi/v0/prefs", &ipn.MaskedPrefs{Prefs: ipn.Prefs{OperatorUser: "mallory"}, OperatorUserSet: true}},
+		{"POST", "/localapi/v0/serve-config", &ipn.ServeConfig{TCP: map[uint16]*ipn.TCPPortHandler{22: {TCPForward: "127.0.0.1:22"}}}},
+		{"GET", "/localapi/v0/api-tokens", nil},
+	} {
+		body, err := json.Marshal(tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(tt.method, s.URL+tt.path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s: status %v; want 403", tt.method, tt.path, res.StatusCode)
+		}
+	}
+}
+
+func TestSensitiveLogsRequireWrite(t *testing.T) {
+	tstest.Replace(t, &validLocalHostForTesting, true)
+
+	h := &Handler{
+		PermitRead: true,
+		b:          &ipnlocal.LocalBackend{},
+	}
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	for _, path := range []string{
+		"/localapi/v0/dns-query-log",
+		"/localapi/v0/flow-log",
+	} {
+		res, err := s.Client().Get(s.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusForbidden {
+			t.Errorf("GET %s: status %v; want 403", path, res.StatusCode)
+		}
+	}
+}
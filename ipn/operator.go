@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Operator permissions. Each permits a local user who isn't root or the
+// operator (Prefs.OperatorUser, who has them all) to use a subset of the
+// LocalAPI methods that otherwise require full access. LocalAPI tokens
+// grant them too, by scope (see LocalAPIToken.Permissions).
+const (
+	// OperatorPermStatusRead permits reading status, WhoIs and health.
+	OperatorPermStatusRead = "status:read"
+
+	// OperatorPermServeWrite permits reading and changing the serve
+	// config, as with "tailscale serve" and "tailscale funnel", but not
+	// adding handlers that serve local files or forward TCP to local
+	// ports (see ServeConfig.LocalTargets). Proxies to loopback ports are
+	// allowed.
+	OperatorPermServeWrite = "serve:write"
+
+	// OperatorPermPrefsWrite permits reading prefs and changing, as with
+	// "tailscale set", the few that only affect the device's own
+	// connectivity, such as ShieldsUp and ExitNodeID.
+	OperatorPermPrefsWrite = "prefs:write"
+
+	// OperatorPermFilesRW permits sending and receiving Taildrop files.
+	OperatorPermFilesRW = "files:rw"
+)
+
+// OperatorPermissions are the valid operator permissions.
+var OperatorPermissions = []string{
+	OperatorPermStatusRead,
+	OperatorPermServeWrite,
+	OperatorPermPrefsWrite,
+	OperatorPermFilesRW,
+}
+
+// OperatorGrant grants operator permissions to a local user or to the
+// members of a local group. Exactly one of User or Group must be set.
+type OperatorGrant struct {
+	User        string   `json:",omitempty"` // user name or numeric ID
+	Group       string   `json:",omitempty"` // group name
+	Permissions []string // of OperatorPermissions
+}
+
+// Check reports whether g is valid.
+func (g OperatorGrant) Check() error {
+	if (g.User == "") == (g.Group == "") {
+		return errors.New("Operators: exactly one of User or Group must be set")
+	}
+	if len(g.Permissions) == 0 {
+		return fmt.Errorf("Operators: no Permissions given; want one or more of %q", OperatorPermissions)
+	}
+	for _, p := range g.Permissions {
+		if !slices.Contains(OperatorPermissions, p) {
+			return fmt.Errorf("Operators: unknown permission %q; want one or more of %q", p, OperatorPermissions)
+		}
+	}
+	return nil
+}
@@ -10,11 +10,14 @@
 	"fmt"
 	"log"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
+	"slices"
 	"strings"
+	"time"
 
 	"tailscale.com/atomicfile"
 	"tailscale.com/ipn/ipnstate"
@@ -103,10 +106,28 @@ type Prefs struct {
 	// routed directly or via the exit node.
 	ExitNodeAllowLANAccess bool
 
+	// ExitNodeExcludeRoutes are destinations that are reached directly,
+	// rather than via the exit node, such as backup servers or package
+	// mirrors.
+	ExitNodeExcludeRoutes []netip.Prefix `json:",omitempty"`
+
+	// ExitNodeExcludeUIDs are the local users whose traffic, other than
+	// to the tailnet, bypasses the exit node. It's only supported on
+	// Linux.
+	ExitNodeExcludeUIDs []uint32 `json:",omitempty"`
+
 	// CorpDNS specifies whether to install the Tailscale network's
 	// DNS configuration, if it exists.
 	CorpDNS bool
 
+	// DNSRoutes are split DNS routes configured locally on this device,
+	// mapping DNS suffixes (such as "corp.internal") to the resolvers
+	// that should handle queries for them. Each resolver is an IP, an
+	// IP:port, or a DNS-over-HTTPS URL. When CorpDNS is true, they're
+	// merged with the tailnet's DNS configuration, taking precedence over
+	// routes from the control plane for the same suffix.
+	DNSRoutes map[string][]string `json:",omitempty"`
+
 	// RunSSH bool is whether this node should run an SSH
 	// server, permitting access to peers according to the
 	// policies as configured by the Tailnet's admin(s).
@@ -200,6 +221,95 @@ type Prefs struct {
 	// AutoUpdatePrefs docs for more details.
 	AutoUpdate AutoUpdatePrefs
 
+	// QoS specifies whether to prioritize latency-sensitive traffic (such
+	// as SSH, WireGuard keepalives and DERP-relayed handshakes) over bulk
+	// transfers (such as Taildrop) when sending packets into the tunnel,
+	// and to set corresponding DSCP marks on the encrypted UDP packets.
+	QoS bool `json:",omitempty"`
+
+	// RelayDailyLimitMB, if non-zero, is the number of megabytes per day
+	// (UTC) that this node forwards on behalf of each peer when acting as a
+	// subnet router or exit node. Traffic from a peer over its limit is
+	// dropped until the next day.
+	RelayDailyLimitMB int `json:",omitempty"`
+
+	// MetricsPort, if non-zero, is the TCP port on which tailscaled serves
+	// its metrics in the Prometheus text format at /metrics on localhost.
+	MetricsPort int `json:",omitempty"`
+
+	// MetricsOnTailnet specifies whether the metrics are also served on
+	// MetricsPort of this node's Tailscale IPs, to peers permitted by the
+	// tailnet policy. It has no effect if MetricsPort is zero.
+	MetricsOnTailnet bool `json:",omitempty"`
+
+	// OtherVPNPolicy specifies how Tailscale's routes coexist with another
+	// VPN that's active on this machine and conflicts with them. It's one
+	// of the OtherVPN constants; empty means OtherVPNOverride.
+	OtherVPNPolicy string `json:",omitempty"`
+
+	// Proxy specifies the outbound HTTP proxy for tailscaled's own
+	// connections to the control plane, DERP and log servers. See
+	// ProxyPrefs docs for more details.
+	Proxy ProxyPrefs
+
+	// ExitNodePolicy is a list of rules for choosing the exit node
+	// automatically as the network or time of day changes. See
+	// ExitNodeRule for details.
+	ExitNodePolicy []ExitNodeRule `json:",omitempty"`
+
+	// FixIPForwarding specifies whether tailscaled should change the
+	// system's sysctls, persistently, when they would prevent
+	// AdvertiseRoutes from being forwarded: enabling IP forwarding and
+	// loosening strict reverse path filtering. Otherwise it only warns.
+	//
+	// Linux-only.
+	FixIPForwarding bool `json:",omitempty"`
+
+	// NAT64 specifies how IPv6-only tailnet clients may reach the IPv4
+	// subnets in AdvertiseRoutes. See NAT64Prefs.
+	NAT64 NAT64Prefs
+
+	// AdvertiseEndpoints are public IP:port endpoints at which peers can
+	// reach this node, in addition to the ones it discovers, such as a
+	// port manually forwarded to tailscaled's port on a router. Each is
+	// only advertised while checks find it reachable.
+	AdvertiseEndpoints []netip.AddrPort `json:",omitempty"`
+
+	// HideStalePeersDays, if non-zero, hides peers that are offline and
+	// haven't been seen for this many days from status output, MagicDNS
+	// and the web client, to keep the device list of large tailnets
+	// usable. Hidden peers are still reachable by IP address.
+	HideStalePeersDays int `json:",omitempty"`
+
+	// BandwidthQuota sets an optional monthly quota on the traffic this
+	// node exchanges over Tailscale. See BandwidthQuotaPrefs.
+	BandwidthQuota BandwidthQuotaPrefs
+
+	// NetcheckHistoryHours, if non-zero, makes tailscaled record a
+	// lightweight netcheck sample (DERP latencies and home region)
+	// periodically and keep this many hours of them, up to
+	// MaxNetcheckHistoryHours, for latency trend reports.
+	NetcheckHistoryHours int `json:",omitempty"`
+
+	// KeepHotPeers are peers, each by name or Tailscale IP, to send a
+	// tiny TSMP ping every KeepHotIntervalSeconds even while the tunnel
+	// is idle, so that stateful firewalls and NATs on the path never
+	// expire its flows, for links that can't tolerate the delay of
+	// reconnecting.
+	KeepHotPeers []string `json:",omitempty"`
+
+	// KeepHotIntervalSeconds is the number of seconds between the pings
+	// to KeepHotPeers, or zero for DefaultKeepHotInterval. It must be
+	// between MinKeepHotIntervalSeconds and MaxKeepHotIntervalSeconds.
+	KeepHotIntervalSeconds int `json:",omitempty"`
+
+	// AllowedPeers, if non-empty, are the only peers this node exchanges
+	// traffic with, each by name, stable node ID, Tailscale IP or
+	// "tag:<name>" for the peers with that tag. All traffic from and to
+	// other peers is dropped, even if the tailnet's ACLs permit it, as
+	// defense in depth against ACL mistakes on high-security hosts.
+	AllowedPeers []string `json:",omitempty"`
+
 	// The Persist field is named 'Config' in the file for backward
 	// compatibility with earlier versions.
 	// TODO(apenwarr): We should move this out of here, it's not a pref.
@@ -208,6 +318,24 @@ type Prefs struct {
 	Persist *persist.Persist `json:"Config"`
 }
 
+// Values of Prefs.OtherVPNPolicy, applied while another VPN whose routes
+// conflict with Tailscale's is active (see netmon.OtherVPN.Conflicts).
+const (
+	// OtherVPNOverride installs Tailscale's routes as usual, taking
+	// precedence over the other VPN where they overlap.
+	OtherVPNOverride = "override"
+
+	// OtherVPNYield leaves the other VPN's traffic alone: no exit node
+	// or subnet routes are installed, nor any route to a peer that
+	// overlaps the other VPN's addresses.
+	OtherVPNYield = "yield"
+
+	// OtherVPNScope narrows Tailscale's routes to exactly what's needed
+	// to reach peers: a route per peer address instead of the whole CGNAT
+	// range, subnet routes, and no exit node.
+	OtherVPNScope = "scope"
+)
+
 // AutoUpdatePrefs are the auto update settings for the node agent.
 type AutoUpdatePrefs struct {
 	// Check specifies whether background checks for updates are enabled. When
@@ -218,6 +346,355 @@ type AutoUpdatePrefs struct {
 	// enabled, tailscaled will apply available updates in the background.
 	// Check must also be set when Apply is set.
 	Apply bool
+
+	// Window, if non-empty, limits applying updates to a daily window of
+	// local time, as "HH:MM-HH:MM". The window may wrap past midnight.
+	Window string `json:",omitempty"`
+
+	// Train, if non-empty, pins updates to a release train, such as
+	// "1.50". Versions of other trains aren't applied.
+	Train string `json:",omitempty"`
+
+	// DelayDays is how many days a version must have been available
+	// before it's applied.
+	DelayDays int `json:",omitempty"`
+}
+
+// ProxyPrefs are the outbound HTTP proxy settings of the node agent. The
+// zero value uses the proxy settings of the environment: the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables and, on Windows, the
+// system's proxy settings.
+type ProxyPrefs struct {
+	// URL, if non-empty, is the http or https URL of the proxy to use for
+	// all connections, such as "http://proxy.example.com:3128". It must
+	// not contain credentials; prefs are readable by any local user, so
+	// those are kept separately (see LocalBackend.SetProxyCredentials).
+	URL string `json:",omitempty"`
+
+	// NoProxy is a comma-separated list of hosts to connect to without
+	// a proxy, like the NO_PROXY environment variable.
+	NoProxy string `json:",omitempty"`
+
+	// PACURL, if non-empty, is the URL of a proxy auto-config (PAC) file
+	// to choose the proxy with when URL is empty.
+	PACURL string `json:",omitempty"`
+
+	// AutoDetect is whether to look for a PAC file with WPAD when
+	// neither URL nor PACURL is set.
+	AutoDetect bool `json:",omitempty"`
+
+	// Auth is the proxy authentication scheme: empty for Basic (or on
+	// Windows, Negotiate as the logged-in user), or "ntlm".
+	Auth string `json:",omitempty"`
+}
+
+// Pretty returns a short description of the proxy settings, or the empty
+// string for the zero value.
+func (pp ProxyPrefs) Pretty() string {
+	if pp == (ProxyPrefs{}) {
+		return ""
+	}
+	var parts []string
+	if pp.URL != "" {
+		u, err := url.Parse(pp.URL)
+		if err == nil {
+			parts = append(parts, u.Redacted())
+		} else {
+			parts = append(parts, "invalid")
+		}
+	}
+	if pp.PACURL != "" {
+		parts = append(parts, "pac="+pp.PACURL)
+	}
+	if pp.AutoDetect {
+		parts = append(parts, "auto")
+	}
+	if pp.NoProxy != "" {
+		parts = append(parts, "noproxy="+pp.NoProxy)
+	}
+	if pp.Auth != "" {
+		parts = append(parts, "auth="+pp.Auth)
+	}
+	return "proxy=" + strings.Join(parts, ",") + " "
+}
+
+// NAT64Prefs are the settings that let IPv6-only tailnet clients reach the
+// IPv4 subnets that a node advertises in Prefs.AdvertiseRoutes, by
+// advertising them again mapped into IPv6 routes that netstack translates
+// back to IPv4. The zero value advertises only the routes as given.
+type NAT64Prefs struct {
+	// Enabled specifies whether to advertise each IPv4 subnet route in
+	// the NAT64 Well-Known Prefix, 64:ff9b::/96, and to answer AAAA
+	// queries made through this node's peerapi DNS server with addresses
+	// in it (DNS64) for names that have only A records in those subnets.
+	Enabled bool `json:",omitempty"`
+
+	// ViaSiteID, if non-zero, specifies that each IPv4 subnet route is
+	// also advertised as a 4via6 route with this site ID, as printed by
+	// "tailscale debug via". It must be at most MaxViaSiteID.
+	ViaSiteID uint32 `json:",omitempty"`
+}
+
+// MaxViaSiteID is the largest site ID for 4via6 routes, the same limit as
+// "tailscale debug via".
+const MaxViaSiteID = 0xff
+
+// Check reports whether np is valid.
+func (np NAT64Prefs) Check() error {
+	if np.ViaSiteID > MaxViaSiteID {
+		return fmt.Errorf("4via6 site ID %d out of range; want 0 to %d", np.ViaSiteID, MaxViaSiteID)
+	}
+	return nil
+}
+
+// Routes returns the IPv6 routes that np derives from advertised, the
+// node's AdvertiseRoutes. Exit routes and IPv6 routes are left alone.
+func (np NAT64Prefs) Routes(advertised []netip.Prefix) []netip.Prefix {
+	if !np.Enabled && np.ViaSiteID == 0 {
+		return nil
+	}
+	var ret []netip.Prefix
+	for _, r := range advertised {
+		if !r.Addr().Is4() || r.Bits() == 0 {
+			continue
+		}
+		if np.Enabled {
+			if p, err := tsaddr.MapNAT64(r); err == nil {
+				ret = append(ret, p)
+			}
+		}
+		if np.ViaSiteID != 0 {
+			if p, err := tsaddr.MapVia(np.ViaSiteID, r); err == nil {
+				ret = append(ret, p)
+			}
+		}
+	}
+	return ret
+}
+
+// Pretty returns a short description of np, or the empty string for the
+// zero value.
+func (np NAT64Prefs) Pretty() string {
+	var parts []string
+	if np.Enabled {
+		parts = append(parts, "on")
+	}
+	if np.ViaSiteID != 0 {
+		parts = append(parts, fmt.Sprintf("via=%d", np.ViaSiteID))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "nat64=" + strings.Join(parts, ",") + " "
+}
+
+// BandwidthQuotaPrefs are the settings of a monthly quota on the traffic a
+// node exchanges over Tailscale, for nodes on metered cloud egress or
+// cellular plans. Traffic is counted whether or not a quota is set; the
+// quota only adds health warnings and notifications as usage crosses its
+// thresholds. The zero value sets no quota and starts each usage period on
+// the first of the month.
+type BandwidthQuotaPrefs struct {
+	// MonthlyMB, if non-zero, is the number of megabytes, in both
+	// directions, that the node may use per usage period. Reaching it
+	// raises a health warning; traffic isn't blocked.
+	MonthlyMB int `json:",omitempty"`
+
+	// ResetDay is the day of the month, from 1 to MaxBandwidthResetDay,
+	// on which each usage period starts at midnight local time, such as
+	// the first day of a billing cycle. Zero means 1.
+	ResetDay int `json:",omitempty"`
+
+	// WarnPercent, if non-zero, is the percentage of MonthlyMB, below
+	// 100, at which to warn ahead of reaching the quota.
+	WarnPercent int `json:",omitempty"`
+}
+
+// MaxBandwidthResetDay is the latest day of the month on which a usage
+// period may start, so that every month has it.
+const MaxBandwidthResetDay = 28
+
+// MaxNetcheckHistoryHours is the maximum value of the NetcheckHistoryHours
+// pref: 30 days.
+const MaxNetcheckHistoryHours = 30 * 24
+
+// DefaultKeepHotInterval is the interval between the pings to KeepHotPeers
+// if KeepHotIntervalSeconds is zero. It's shorter than the shortest UDP
+// timeouts of common NATs and firewalls, as WireGuard's recommended
+// persistent keepalive is.
+const DefaultKeepHotInterval = 25 * time.Second
+
+// MinKeepHotIntervalSeconds and MaxKeepHotIntervalSeconds bound the
+// KeepHotIntervalSeconds pref.
+const (
+	MinKeepHotIntervalSeconds = 5
+	MaxKeepHotIntervalSeconds = 3600
+)
+
+// KeepHotInterval returns the interval between the pings to the
+// KeepHotPeers of p.
+func (p *Prefs) KeepHotInterval() time.Duration {
+	if p.KeepHotIntervalSeconds == 0 {
+		return DefaultKeepHotInterval
+	}
+	return time.Duration(p.KeepHotIntervalSeconds) * time.Second
+}
+
+// KeepHotInterval returns the interval between the pings to the
+// KeepHotPeers of p.
+func (p PrefsView) KeepHotInterval() time.Duration { return p.ж.KeepHotInterval() }
+
+// Check reports whether bq is valid.
+func (bq BandwidthQuotaPrefs) Check() error {
+	if bq.MonthlyMB < 0 {
+		return errors.New("bandwidth quota must not be negative")
+	}
+	if bq.ResetDay < 0 || bq.ResetDay > MaxBandwidthResetDay {
+		return fmt.Errorf("bandwidth quota reset day %d out of range; want 1 to %d", bq.ResetDay, MaxBandwidthResetDay)
+	}
+	if bq.WarnPercent < 0 || bq.WarnPercent >= 100 {
+		return fmt.Errorf("bandwidth quota warning percentage %d out of range; want 1 to 99", bq.WarnPercent)
+	}
+	return nil
+}
+
+// Period returns the usage period that contains t: from midnight of the
+// most recent ResetDay, in t's location, to the next.
+func (bq BandwidthQuotaPrefs) Period(t time.Time) (start, end time.Time) {
+	day := bq.ResetDay
+	if day < 1 {
+		day = 1
+	}
+	y, m, d := t.Date()
+	if d < day {
+		m-- // time.Date normalizes month 0 to December of the year before
+	}
+	start = time.Date(y, m, day, 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Pretty returns a short description of bq, or the empty string for the
+// zero value.
+func (bq BandwidthQuotaPrefs) Pretty() string {
+	var parts []string
+	if bq.MonthlyMB != 0 {
+		parts = append(parts, fmt.Sprintf("%dMB", bq.MonthlyMB))
+	}
+	if bq.ResetDay != 0 {
+		parts = append(parts, fmt.Sprintf("day=%d", bq.ResetDay))
+	}
+	if bq.WarnPercent != 0 {
+		parts = append(parts, fmt.Sprintf("warn=%d%%", bq.WarnPercent))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "quota=" + strings.Join(parts, ",") + " "
+}
+
+// Special values of ExitNodeRule.ExitNode.
+const (
+	// ExitNodeAuto selects the exit node with the lowest latency, or with
+	// an "auto:" prefix and a country code (like "auto:DE"), the one
+	// with the lowest latency in that country.
+	ExitNodeAuto = "auto"
+
+	// ExitNodeNone clears the exit node.
+	ExitNodeNone = "none"
+)
+
+// ExitNodeRule is a rule of an exit node policy (Prefs.ExitNodePolicy).
+// Whenever the network or time of day changes, the first rule whose
+// conditions all match picks the exit node. If no rule matches, the exit
+// node is left alone.
+//
+// The policy only changes the exit node when its choice changes, so an exit
+// node set by hand stays until then.
+type ExitNodeRule struct {
+	// Network, if non-empty, limits the rule to when this machine is on
+	// the given network: the SSID of the Wi-Fi network, or the IP address
+	// of the LAN's router.
+	Network string `json:",omitempty"`
+
+	// Hours, if non-empty, limits the rule to a daily window of local
+	// time, like "09:00-17:30". The window may wrap around midnight.
+	Hours string `json:",omitempty"`
+
+	// ExitNode is the exit node to use when the rule matches: its
+	// Tailscale IP, MagicDNS name or stable node ID, or ExitNodeAuto or
+	// ExitNodeNone.
+	ExitNode string
+}
+
+// Check reports whether r is valid.
+func (r ExitNodeRule) Check() error {
+	if r.ExitNode == "" {
+		return errors.New("exit node policy rule has no exit node")
+	}
+	if r.Hours != "" {
+		if _, _, err := parseHours(r.Hours); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches reports whether r's conditions hold when this machine is on the
+// networks identified by networks (see ExitNodeRule.Network) at time now.
+func (r ExitNodeRule) Matches(networks []string, now time.Time) bool {
+	if r.Network != "" && !slices.Contains(networks, r.Network) {
+		return false
+	}
+	if r.Hours != "" {
+		start, end, err := parseHours(r.Hours)
+		if err != nil {
+			return false
+		}
+		m := now.Hour()*60 + now.Minute()
+		if start <= end {
+			return start <= m && m < end
+		}
+		return m >= start || m < end
+	}
+	return true
+}
+
+func (r ExitNodeRule) String() string {
+	var sb strings.Builder
+	sb.WriteString(r.ExitNode)
+	if r.Network != "" {
+		fmt.Fprintf(&sb, " on network %q", r.Network)
+	}
+	if r.Hours != "" {
+		fmt.Fprintf(&sb, " during %s", r.Hours)
+	}
+	return sb.String()
+}
+
+// parseHours parses a daily time window like "09:00-17:30" into its start
+// and end in minutes since midnight.
+func parseHours(s string) (start, end int, err error) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid hours %q; want HH:MM-HH:MM", s)
+	}
+	parse := func(v string) (int, error) {
+		t, err := time.Parse("15:04", strings.TrimSpace(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid hours %q; want HH:MM-HH:MM", s)
+		}
+		return t.Hour()*60 + t.Minute(), nil
+	}
+	if start, err = parse(a); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parse(b); err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("invalid hours %q: empty window", s)
+	}
+	return start, end, nil
 }
 
 // MaskedPrefs is a Prefs with an associated bitmask of which fields are set.
@@ -230,7 +707,10 @@ type MaskedPrefs struct {
 	ExitNodeIDSet             bool `json:",omitempty"`
 	ExitNodeIPSet             bool `json:",omitempty"`
 	ExitNodeAllowLANAccessSet bool `json:",omitempty"`
+	ExitNodeExcludeRoutesSet  bool `json:",omitempty"`
+	ExitNodeExcludeUIDsSet    bool `json:",omitempty"`
 	CorpDNSSet                bool `json:",omitempty"`
+	DNSRoutesSet              bool `json:",omitempty"`
 	RunSSHSet                 bool `json:",omitempty"`
 	WantRunningSet            bool `json:",omitempty"`
 	LoggedOutSet              bool `json:",omitempty"`
@@ -246,6 +726,22 @@ type MaskedPrefs struct {
 	OperatorUserSet           bool `json:",omitempty"`
 	ProfileNameSet            bool `json:",omitempty"`
 	AutoUpdateSet             bool `json:",omitempty"`
+	QoSSet                    bool `json:",omitempty"`
+	RelayDailyLimitMBSet      bool `json:",omitempty"`
+	MetricsPortSet            bool `json:",omitempty"`
+	MetricsOnTailnetSet       bool `json:",omitempty"`
+	OtherVPNPolicySet         bool `json:",omitempty"`
+	ProxySet                  bool `json:",omitempty"`
+	ExitNodePolicySet         bool `json:",omitempty"`
+	FixIPForwardingSet        bool `json:",omitempty"`
+	NAT64Set                  bool `json:",omitempty"`
+	AdvertiseEndpointsSet     bool `json:",omitempty"`
+	HideStalePeersDaysSet     bool `json:",omitempty"`
+	BandwidthQuotaSet         bool `json:",omitempty"`
+	NetcheckHistoryHoursSet   bool `json:",omitempty"`
+	KeepHotPeersSet           bool `json:",omitempty"`
+	KeepHotIntervalSecondsSet bool `json:",omitempty"`
+	AllowedPeersSet           bool `json:",omitempty"`
 }
 
 // ApplyEdits mutates p, assigning fields from m.Prefs for each MaskedPrefs
@@ -281,6 +777,53 @@ func (m *MaskedPrefs) IsEmpty() bool {
 	return true
 }
 
+// Conflicts returns the names of the prefs (without their "Set" suffix)
+// which both m and o set, to different values.
+func (m *MaskedPrefs) Conflicts(o *MaskedPrefs) []string {
+	if m == nil || o == nil {
+		return nil
+	}
+	var names []string
+	mv := reflect.ValueOf(m).Elem()
+	ov := reflect.ValueOf(o).Elem()
+	mpv := reflect.ValueOf(&m.Prefs).Elem()
+	opv := reflect.ValueOf(&o.Prefs).Elem()
+	mt := mv.Type()
+	for i := 1; i < mt.NumField(); i++ {
+		if !mv.Field(i).Bool() || !ov.Field(i).Bool() {
+			continue
+		}
+		if !reflect.DeepEqual(mpv.Field(i-1).Interface(), opv.Field(i-1).Interface()) {
+			names = append(names, strings.TrimSuffix(mt.Field(i).Name, "Set"))
+		}
+	}
+	return names
+}
+
+// Dropped returns edits that reset the prefs which m sets but next
+// doesn't to their values in defaults.
+func (m *MaskedPrefs) Dropped(next *MaskedPrefs, defaults *Prefs) MaskedPrefs {
+	var ret MaskedPrefs
+	if m == nil {
+		return ret
+	}
+	if next == nil {
+		next = new(MaskedPrefs)
+	}
+	mv := reflect.ValueOf(m).Elem()
+	nv := reflect.ValueOf(next).Elem()
+	rv := reflect.ValueOf(&ret).Elem()
+	rpv := reflect.ValueOf(&ret.Prefs).Elem()
+	dv := reflect.ValueOf(defaults).Elem()
+	for i := 1; i < mv.NumField(); i++ {
+		if mv.Field(i).Bool() && !nv.Field(i).Bool() {
+			rv.Field(i).SetBool(true)
+			rpv.Field(i - 1).Set(dv.Field(i - 1))
+		}
+	}
+	return ret
+}
+
 func (m *MaskedPrefs) Pretty() string {
 	if m == nil {
 		return "MaskedPrefs{<nil>}"
@@ -342,6 +885,9 @@ func (p *Prefs) pretty(goos string) string {
 		sb.WriteString("mesh=false ")
 	}
 	fmt.Fprintf(&sb, "dns=%v want=%v ", p.CorpDNS, p.WantRunning)
+	if len(p.DNSRoutes) > 0 {
+		fmt.Fprintf(&sb, "dnsroutes=%v ", p.DNSRoutes)
+	}
 	if p.RunSSH {
 		sb.WriteString("ssh=true ")
 	}
@@ -362,6 +908,12 @@ func (p *Prefs) pretty(goos string) string {
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	}
+	if len(p.ExitNodeExcludeRoutes) > 0 {
+		fmt.Fprintf(&sb, "exitexclude=%v ", p.ExitNodeExcludeRoutes)
+	}
+	if len(p.ExitNodeExcludeUIDs) > 0 {
+		fmt.Fprintf(&sb, "exitexcludeuids=%v ", p.ExitNodeExcludeUIDs)
+	}
 	if len(p.AdvertiseRoutes) > 0 || goos == "linux" {
 		fmt.Fprintf(&sb, "routes=%v ", p.AdvertiseRoutes)
 	}
@@ -384,6 +936,46 @@ func (p *Prefs) pretty(goos string) string {
 		fmt.Fprintf(&sb, "op=%q ", p.OperatorUser)
 	}
 	sb.WriteString(p.AutoUpdate.Pretty())
+	if p.QoS {
+		sb.WriteString("qos=true ")
+	}
+	if p.RelayDailyLimitMB != 0 {
+		fmt.Fprintf(&sb, "relaylimit=%dMB ", p.RelayDailyLimitMB)
+	}
+	if p.MetricsPort != 0 {
+		fmt.Fprintf(&sb, "metrics=%d", p.MetricsPort)
+		if p.MetricsOnTailnet {
+			sb.WriteString("+tailnet")
+		}
+		sb.WriteString(" ")
+	}
+	if p.OtherVPNPolicy != "" {
+		fmt.Fprintf(&sb, "othervpn=%s ", p.OtherVPNPolicy)
+	}
+	sb.WriteString(p.Proxy.Pretty())
+	if len(p.ExitNodePolicy) > 0 {
+		fmt.Fprintf(&sb, "exitpolicy=%d ", len(p.ExitNodePolicy))
+	}
+	if p.FixIPForwarding {
+		sb.WriteString("fixipfwd=true ")
+	}
+	sb.WriteString(p.NAT64.Pretty())
+	if len(p.AdvertiseEndpoints) > 0 {
+		fmt.Fprintf(&sb, "endpoints=%v ", p.AdvertiseEndpoints)
+	}
+	if p.HideStalePeersDays != 0 {
+		fmt.Fprintf(&sb, "hidestale=%dd ", p.HideStalePeersDays)
+	}
+	sb.WriteString(p.BandwidthQuota.Pretty())
+	if p.NetcheckHistoryHours != 0 {
+		fmt.Fprintf(&sb, "netcheckhistory=%dh ", p.NetcheckHistoryHours)
+	}
+	if len(p.KeepHotPeers) > 0 {
+		fmt.Fprintf(&sb, "keephot=%s/%v ", strings.Join(p.KeepHotPeers, ","), p.KeepHotInterval())
+	}
+	if len(p.AllowedPeers) > 0 {
+		fmt.Fprintf(&sb, "allowedpeers=%s ", strings.Join(p.AllowedPeers, ","))
+	}
 	if p.Persist != nil {
 		sb.WriteString(p.Persist.Pretty())
 	} else {
@@ -424,7 +1016,10 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
+		slices.Equal(p.ExitNodeExcludeRoutes, p2.ExitNodeExcludeRoutes) &&
+		slices.Equal(p.ExitNodeExcludeUIDs, p2.ExitNodeExcludeUIDs) &&
 		p.CorpDNS == p2.CorpDNS &&
+		compareDNSRoutes(p.DNSRoutes, p2.DNSRoutes) &&
 		p.RunSSH == p2.RunSSH &&
 		p.WantRunning == p2.WantRunning &&
 		p.LoggedOut == p2.LoggedOut &&
@@ -439,17 +1034,46 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		compareStrings(p.AdvertiseTags, p2.AdvertiseTags) &&
 		p.Persist.Equals(p2.Persist) &&
 		p.ProfileName == p2.ProfileName &&
-		p.AutoUpdate == p2.AutoUpdate
+		p.AutoUpdate == p2.AutoUpdate &&
+		p.QoS == p2.QoS &&
+		p.RelayDailyLimitMB == p2.RelayDailyLimitMB &&
+		p.MetricsPort == p2.MetricsPort &&
+		p.MetricsOnTailnet == p2.MetricsOnTailnet &&
+		p.OtherVPNPolicy == p2.OtherVPNPolicy &&
+		p.Proxy == p2.Proxy &&
+		slices.Equal(p.ExitNodePolicy, p2.ExitNodePolicy) &&
+		p.FixIPForwarding == p2.FixIPForwarding &&
+		p.NAT64 == p2.NAT64 &&
+		slices.Equal(p.AdvertiseEndpoints, p2.AdvertiseEndpoints) &&
+		p.HideStalePeersDays == p2.HideStalePeersDays &&
+		p.BandwidthQuota == p2.BandwidthQuota &&
+		p.NetcheckHistoryHours == p2.NetcheckHistoryHours &&
+		slices.Equal(p.KeepHotPeers, p2.KeepHotPeers) &&
+		p.KeepHotIntervalSeconds == p2.KeepHotIntervalSeconds &&
+		slices.Equal(p.AllowedPeers, p2.AllowedPeers)
 }
 
 func (au AutoUpdatePrefs) Pretty() string {
-	if au.Apply {
-		return "update=on "
+	var sb strings.Builder
+	switch {
+	case au.Apply:
+		sb.WriteString("update=on")
+	case au.Check:
+		sb.WriteString("update=check")
+	default:
+		sb.WriteString("update=off")
+	}
+	if au.Window != "" {
+		fmt.Fprintf(&sb, ",window=%s", au.Window)
 	}
-	if au.Check {
-		return "update=check "
+	if au.Train != "" {
+		fmt.Fprintf(&sb, ",train=%s", au.Train)
 	}
-	return "update=off "
+	if au.DelayDays != 0 {
+		fmt.Fprintf(&sb, ",delay=%dd", au.DelayDays)
+	}
+	sb.WriteString(" ")
+	return sb.String()
 }
 
 func compareIPNets(a, b []netip.Prefix) bool {
@@ -476,6 +1100,19 @@ func compareStrings(a, b []string) bool {
 	return true
 }
 
+func compareDNSRoutes(a, b map[string][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		v2, ok := b[k]
+		if !ok || !compareStrings(v, v2) {
+			return false
+		}
+	}
+	return true
+}
+
 // NewPrefs returns the default preferences to use.
 func NewPrefs() *Prefs {
 	// Provide default values for options which might be missing
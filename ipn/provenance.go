@@ -0,0 +1,87 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"reflect"
+	"time"
+)
+
+// PrefSourceHeader is the HTTP request header in which LocalAPI callers say
+// what they are, such as "cli", so that the prefs they change are
+// attributed to them.
+const PrefSourceHeader = "Tailscale-Pref-Source"
+
+// PrefSource is where the value of a pref came from.
+type PrefSource string
+
+const (
+	// PrefSourceCLI is the tailscale CLI.
+	PrefSourceCLI PrefSource = "cli"
+
+	// PrefSourceGUI is a GUI client.
+	PrefSourceGUI PrefSource = "gui"
+
+	// PrefSourceWeb is the web client.
+	PrefSourceWeb PrefSource = "web"
+
+	// PrefSourceLocalAPI is a LocalAPI client that didn't say what it is,
+	// or a program embedding tailscaled's LocalBackend.
+	PrefSourceLocalAPI PrefSource = "localapi"
+
+	// PrefSourceConfigFile is the config file tailscaled was started with.
+	PrefSourceConfigFile PrefSource = "conffile"
+
+	// PrefSourceControl is the control server, such as when logging in
+	// or when the network map resolves the exit node.
+	PrefSourceControl PrefSource = "control"
+
+	// PrefSourceExitNodePolicy is the ExitNodePolicy pref's rules
+	// choosing an exit node.
+	PrefSourceExitNodePolicy PrefSource = "exit-node-policy"
+
+	// PrefSourceSchedule is a timed change of WantRunning, as made by
+	// "tailscale up --after" or "tailscale down --until".
+	PrefSourceSchedule PrefSource = "schedule"
+)
+
+// PrefSourceFromHeader returns the PrefSource named by the PrefSourceHeader
+// value v. Only the sources that LocalAPI clients can claim are recognized;
+// anything else is PrefSourceLocalAPI.
+func PrefSourceFromHeader(v string) PrefSource {
+	switch s := PrefSource(v); s {
+	case PrefSourceCLI, PrefSourceGUI, PrefSourceWeb:
+		return s
+	}
+	return PrefSourceLocalAPI
+}
+
+// PrefProvenance is where the current value of a pref came from.
+type PrefProvenance struct {
+	// Source is what last changed the pref.
+	Source PrefSource
+
+	// Changed is when the pref was last changed.
+	Changed time.Time
+}
+
+// ChangedPrefs returns the names of the prefs, as in MaskedPrefs without
+// the "Set" suffix, whose values differ between a and b. Persist is not a
+// pref and is ignored.
+func ChangedPrefs(a, b *Prefs) []string {
+	av := reflect.ValueOf(a).Elem()
+	bv := reflect.ValueOf(b).Elem()
+	t := av.Type()
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if name == "Persist" {
+			continue
+		}
+		if !reflect.DeepEqual(av.Field(i).Interface(), bv.Field(i).Interface()) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
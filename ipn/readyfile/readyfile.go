@@ -0,0 +1,130 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package readyfile maintains a file describing whether tailscaled is up,
+// for process supervisors and container healthchecks that can't use
+// systemd notifications or the LocalAPI.
+package readyfile
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"os"
+	"reflect"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnlocal"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+)
+
+// Doc is the JSON contents of the ready file.
+type Doc struct {
+	// Ready is whether tailscaled is running and connected to the
+	// tailnet; that is, whether BackendState is "Running".
+	Ready bool
+
+	// BackendState is the ipn.State, such as "Running" or "NeedsLogin".
+	BackendState string
+
+	Hostname     string       `json:",omitempty"` // the node's hostname
+	DNSName      string       `json:",omitempty"` // the node's MagicDNS name, with a trailing dot
+	TailscaleIPs []netip.Addr `json:",omitempty"`
+
+	// Updated is when the file was last written.
+	Updated time.Time
+}
+
+// Clear removes the ready file at path, if it exists, so that a file left
+// from a previous run isn't mistaken for readiness.
+func Clear(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Run maintains the ready file at path for b until ctx is done.
+//
+// The file is first written once b is running, then rewritten whenever
+// its state, hostname or Tailscale IPs change. When ctx is done, it's
+// removed.
+func Run(ctx context.Context, logf logger.Logf, b *ipnlocal.LocalBackend, path string) {
+	w := &writer{path: path}
+	var (
+		st ipn.State
+		nm *netmap.NetworkMap
+	)
+	b.WatchNotifications(ctx, ipn.NotifyInitialState|ipn.NotifyInitialNetMap, nil, func(n *ipn.Notify) (keepGoing bool) {
+		if n.State != nil {
+			st = *n.State
+		}
+		if n.NetMap != nil {
+			nm = n.NetMap
+		}
+		if err := w.update(st, nm); err != nil {
+			logf("readyfile: %v", err)
+		}
+		return true
+	})
+	if err := Clear(path); err != nil {
+		logf("readyfile: %v", err)
+	}
+}
+
+// writer writes the ready file when its contents change.
+type writer struct {
+	path    string
+	written bool // whether the file has been written yet
+	last    Doc  // what was last written, if written
+}
+
+// update writes the ready file for the backend state st and netmap nm
+// (which may be nil), if it's changed since the last write and tailscaled
+// has been running at least once.
+func (w *writer) update(st ipn.State, nm *netmap.NetworkMap) error {
+	d := docFor(st, nm)
+	if !w.written && !d.Ready {
+		return nil
+	}
+	if w.written && reflect.DeepEqual(d, w.last) {
+		return nil
+	}
+	wd := d
+	wd.Updated = time.Now()
+	bs, err := json.MarshalIndent(wd, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(w.path, append(bs, '\n'), 0644); err != nil {
+		return err
+	}
+	w.written, w.last = true, d
+	return nil
+}
+
+// docFor returns the Doc for st and nm, without Updated set.
+func docFor(st ipn.State, nm *netmap.NetworkMap) Doc {
+	d := Doc{
+		Ready:        st == ipn.Running,
+		BackendState: st.String(),
+	}
+	if nm == nil || !nm.SelfNode.Valid() {
+		return d
+	}
+	d.Hostname = nm.SelfNode.Hostinfo().Hostname()
+	d.DNSName = nm.Name
+	for _, pfx := range nm.Addresses {
+		if pfx.IsSingleIP() {
+			d.TailscaleIPs = append(d.TailscaleIPs, pfx.Addr())
+		}
+	}
+	return d
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package readyfile
+
+import (
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ready.json")
+	w := &writer{path: path}
+	nm := &netmap.NetworkMap{
+		Name:      "foo.tail-scale.ts.net.",
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.1.2/32"), netip.MustParsePrefix("fd7a:115c:a1e0::1/128")},
+		SelfNode: (&tailcfg.Node{
+			Hostinfo: (&tailcfg.Hostinfo{Hostname: "foo"}).View(),
+		}).View(),
+	}
+
+	read := func() (d Doc, exists bool) {
+		t.Helper()
+		bs, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			return d, false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(bs, &d); err != nil {
+			t.Fatal(err)
+		}
+		return d, true
+	}
+	update := func(st ipn.State, nm *netmap.NetworkMap) {
+		t.Helper()
+		if err := w.update(st, nm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update(ipn.Starting, nil)
+	if _, ok := read(); ok {
+		t.Fatal("ready file written before running")
+	}
+
+	update(ipn.Running, nm)
+	d, ok := read()
+	if !ok {
+		t.Fatal("ready file not written once running")
+	}
+	want := Doc{
+		Ready:        true,
+		BackendState: "Running",
+		Hostname:     "foo",
+		DNSName:      "foo.tail-scale.ts.net.",
+		TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.1.2"), netip.MustParseAddr("fd7a:115c:a1e0::1")},
+	}
+	if d.Updated.IsZero() {
+		t.Error("Updated not set")
+	}
+	d.Updated = want.Updated
+	if !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v; want %+v", d, want)
+	}
+
+	update(ipn.Stopped, nm)
+	if d, _ := read(); d.Ready || d.BackendState != "Stopped" {
+		t.Errorf("after stopping, got Ready=%v, BackendState=%q; want false, Stopped", d.Ready, d.BackendState)
+	}
+
+	if err := Clear(path); err != nil {
+		t.Fatal(err)
+	}
+	update(ipn.Stopped, nm)
+	if _, ok := read(); ok {
+		t.Error("unchanged state rewrote ready file")
+	}
+}
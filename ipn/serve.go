@@ -145,12 +145,163 @@ type HTTPHandler struct {
 	// Exactly one of the following may be set.
 
 	Path  string `json:",omitempty"` // absolute path to directory or file to serve
-	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030
+	Proxy string `json:",omitempty"` // http://localhost:3000/, localhost:3030, 3030, h2c://localhost:50051
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
-	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
-	// temporary ones? Error codes? Redirects?
+	// NoDirList, if true, disables listing the contents of directories
+	// under Path that have no index.html file. Requests for them get a
+	// 404 Not Found instead.
+	NoDirList bool `json:",omitempty"`
+
+	// CacheMaxAge, if non-zero, is the number of seconds clients may cache
+	// files served from Path, sent as a Cache-Control max-age directive.
+	CacheMaxAge int `json:",omitempty"`
+
+	// AllowFrom, if non-empty, restricts access to requests from tailnet
+	// nodes matching one of its entries, checked against the requesting
+	// node's identity on each request. Entries are login names of users
+	// ("alice@example.com"), "*@" followed by a domain to match all users
+	// of that domain, or tags ("tag:server"). Login names only match
+	// untagged nodes. Requests from other nodes, or over Funnel, get a 403
+	// Forbidden. See CheckServeAllowFrom.
+	AllowFrom []string `json:",omitempty"`
+
+	// IdentityHeaders, if non-empty, limits the identity headers added to
+	// requests proxied from users' nodes to those listed, out of
+	// ServeIdentityHeaders. The single entry "none" adds none, for
+	// backends that make their own auth decisions. Incoming requests'
+	// values of these headers are always stripped.
+	IdentityHeaders []string `json:",omitempty"`
+
+	// ProxyTimeout, if non-zero, is the number of seconds to wait for the
+	// Proxy backend to send response headers before failing the request
+	// with a 502 Bad Gateway. It doesn't limit how long the response body
+	// may take, so streaming responses (SSE, gRPC) and upgraded connections
+	// (WebSockets) can stay open indefinitely once established.
+	ProxyTimeout int `json:",omitempty"`
+
+	// ProxyIdleTimeout, if non-zero, is the number of seconds idle
+	// connections to the Proxy backend are kept open for reuse. The
+	// default is 90 seconds.
+	ProxyIdleTimeout int `json:",omitempty"`
+
+	// ProxyKeepAlive, if non-zero, is the interval in seconds between TCP
+	// keep-alive probes on connections to the Proxy backend. Negative
+	// values disable keep-alives. The default is 15 seconds.
+	ProxyKeepAlive int `json:",omitempty"`
+
+	// ProxyBufferSize, if non-zero, is the size in bytes of the buffers
+	// used to copy request and response bodies to and from the Proxy
+	// backend. The default is 32 KiB.
+	ProxyBufferSize int `json:",omitempty"`
+
+	// Fallbacks, if non-empty, are backends in the same forms as Proxy
+	// that requests are proxied to, in order, while the Proxy backend
+	// and any earlier fallbacks are failing their health checks, such as
+	// during a deploy. They're proxied to with the same options as Proxy.
+	Fallbacks []string `json:",omitempty"`
+
+	// HealthCheck, if non-empty, is the path of an HTTP endpoint of the
+	// Proxy and Fallbacks backends that answers with a 2xx or 3xx status
+	// while the backend is healthy. If empty, a backend is healthy if it
+	// accepts TCP connections. Backends are only health checked if
+	// Fallbacks or Maintenance is set.
+	HealthCheck string `json:",omitempty"`
+
+	// HealthCheckInterval, if non-zero, is the number of seconds between
+	// health checks of the backends. The default is 10 seconds.
+	HealthCheckInterval int `json:",omitempty"`
+
+	// Maintenance, if non-empty, is the HTML body of the 503 Service
+	// Unavailable response sent instead of proxying requests while no
+	// backend is healthy, or when proxying to the backend fails.
+	Maintenance string `json:",omitempty"`
+
+	// TODO(bradfitz): TTL on mapping for temporary ones? Error codes?
+	// Redirects?
+}
+
+// ServeIdentityHeaders are the headers identifying the user that serve adds
+// to the requests it proxies from users' (untagged) nodes.
+var ServeIdentityHeaders = []string{
+	"Tailscale-User-Login",
+	"Tailscale-User-Name",
+	"Tailscale-User-Profile-Pic",
+}
+
+// CheckServeAllowFrom returns an error if entry isn't a valid
+// HTTPHandler.AllowFrom entry.
+func CheckServeAllowFrom(entry string) error {
+	switch {
+	case strings.HasPrefix(entry, "tag:"):
+		return tailcfg.CheckTag(entry)
+	case strings.HasPrefix(entry, "*@"):
+		if len(entry) == len("*@") || strings.ContainsAny(entry[2:], "@*") {
+			return fmt.Errorf("invalid domain pattern %q; want *@example.com", entry)
+		}
+		return nil
+	case strings.HasPrefix(entry, "group:") || strings.HasPrefix(entry, "autogroup:"):
+		return fmt.Errorf("%q: groups aren't supported; list users or tags instead", entry)
+	}
+	if _, domain, ok := strings.Cut(entry, "@"); !ok || domain == "" || strings.Contains(entry, "*") {
+		return fmt.Errorf("invalid entry %q; want a login name, *@domain or tag", entry)
+	}
+	return nil
+}
+
+// CheckServeIdentityHeaders returns an error if headers isn't a valid
+// HTTPHandler.IdentityHeaders value.
+func CheckServeIdentityHeaders(headers []string) error {
+	for _, h := range headers {
+		if h == "none" {
+			if len(headers) > 1 {
+				return errors.New(`identity header "none" can't be combined with others`)
+			}
+			continue
+		}
+		if !slices.ContainsFunc(ServeIdentityHeaders, func(s string) bool { return strings.EqualFold(s, h) }) {
+			return fmt.Errorf("unknown identity header %q; want one of %s or none", h, strings.Join(ServeIdentityHeaders, ", "))
+		}
+	}
+	return nil
+}
+
+// AllowsFrom reports whether v's AllowFrom list permits requests from node,
+// owned by user.
+func (v HTTPHandlerView) AllowsFrom(node tailcfg.NodeView, user tailcfg.UserProfile) bool {
+	allow := v.AllowFrom()
+	if allow.Len() == 0 {
+		return true
+	}
+	for i := 0; i < allow.Len(); i++ {
+		e := allow.At(i)
+		switch {
+		case strings.HasPrefix(e, "tag:"):
+			if node.Tags().ContainsFunc(func(t string) bool { return t == e }) {
+				return true
+			}
+		case node.IsTagged():
+			// Users' login names only match their untagged nodes.
+		case strings.HasPrefix(e, "*@"):
+			if len(user.LoginName) > len(e)-1 && strings.EqualFold(user.LoginName[len(user.LoginName)-(len(e)-1):], e[1:]) {
+				return true
+			}
+		case strings.EqualFold(user.LoginName, e):
+			return true
+		}
+	}
+	return false
+}
+
+// WantsIdentityHeader reports whether the identity header name (one of
+// ServeIdentityHeaders) should be added to requests proxied by v.
+func (v HTTPHandlerView) WantsIdentityHeader(name string) bool {
+	hs := v.IdentityHeaders()
+	if hs.Len() == 0 {
+		return true
+	}
+	return hs.ContainsFunc(func(h string) bool { return strings.EqualFold(h, name) })
 }
 
 // WebHandlerExists reports whether if the ServeConfig Web handler exists for
@@ -225,6 +376,46 @@ func (sc *ServeConfig) IsServingHTTP(port uint16) bool {
 	return sc.TCP[port].HTTP
 }
 
+// LocalTargets returns the local files and ports that sc serves or proxies
+// to, including in its foreground configs, such as "path /var/www",
+// "proxy http://127.0.0.1:3000" or "tcp-forward 127.0.0.1:22".
+//
+// View version of ServeConfig.LocalTargets.
+func (v ServeConfigView) LocalTargets() []string { return v.ж.LocalTargets() }
+
+// LocalTargets returns the local files and ports that sc serves or proxies
+// to, including in its foreground configs, such as "path /var/www",
+// "proxy http://127.0.0.1:3000" or "tcp-forward 127.0.0.1:22".
+func (sc *ServeConfig) LocalTargets() []string {
+	if sc == nil {
+		return nil
+	}
+	var targets []string
+	for _, h := range sc.TCP {
+		if h != nil && h.TCPForward != "" {
+			targets = append(targets, "tcp-forward "+h.TCPForward)
+		}
+	}
+	for _, w := range sc.Web {
+		if w == nil {
+			continue
+		}
+		for _, h := range w.Handlers {
+			switch {
+			case h == nil:
+			case h.Path != "":
+				targets = append(targets, "path "+h.Path)
+			case h.Proxy != "":
+				targets = append(targets, "proxy "+h.Proxy)
+			}
+		}
+	}
+	for _, fg := range sc.Foreground {
+		targets = append(targets, fg.LocalTargets()...)
+	}
+	return targets
+}
+
 // IsFunnelOn reports whether if ServeConfig is currently allowing funnel
 // traffic for any host:port.
 //
@@ -419,3 +610,18 @@ func (v ServeConfigView) HasFunnelForTarget(target HostPort) bool {
 	})
 	return exists
 }
+
+// ProxyBackends returns the backends v proxies to, in order of preference:
+// its Proxy backend followed by its Fallbacks.
+func (v HTTPHandlerView) ProxyBackends() []string {
+	if v.Proxy() == "" {
+		return nil
+	}
+	return append([]string{v.Proxy()}, v.Fallbacks().AsSlice()...)
+}
+
+// HealthChecked reports whether the backends v proxies to are health
+// checked, which they are if it has Fallbacks or a Maintenance page.
+func (v HTTPHandlerView) HealthChecked() bool {
+	return v.Proxy() != "" && (v.Fallbacks().Len() > 0 || v.Maintenance() != "")
+}
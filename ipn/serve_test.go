@@ -37,3 +37,55 @@ func TestCheckFunnelAccess(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckServeAllowFrom(t *testing.T) {
+	tests := []struct {
+		entry   string
+		wantErr bool
+	}{
+		{"alice@example.com", false},
+		{"*@example.com", false},
+		{"tag:server", false},
+		{"tag:", true},
+		{"*@", true},
+		{"*@*.com", true},
+		{"alice", true},
+		{"a*@example.com", true},
+		{"group:eng", true},
+		{"autogroup:member", true},
+	}
+	for _, tt := range tests {
+		err := CheckServeAllowFrom(tt.entry)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckServeAllowFrom(%q) = %v; want error: %v", tt.entry, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHTTPHandlerAllowsFrom(t *testing.T) {
+	user := (&tailcfg.Node{}).View()
+	tagged := (&tailcfg.Node{Tags: []string{"tag:server"}}).View()
+	alice := tailcfg.UserProfile{LoginName: "alice@example.com"}
+	tests := []struct {
+		allow []string
+		node  tailcfg.NodeView
+		want  bool
+	}{
+		{nil, user, true},
+		{[]string{"alice@example.com"}, user, true},
+		{[]string{"ALICE@example.com"}, user, true},
+		{[]string{"bob@example.com"}, user, false},
+		{[]string{"*@example.com"}, user, true},
+		{[]string{"*@ample.com"}, user, false},
+		{[]string{"alice@example.com"}, tagged, false},
+		{[]string{"tag:server"}, tagged, true},
+		{[]string{"tag:other", "*@example.com"}, tagged, false},
+		{[]string{"tag:server"}, user, false},
+	}
+	for _, tt := range tests {
+		h := (&HTTPHandler{AllowFrom: tt.allow}).View()
+		if got := h.AllowsFrom(tt.node, alice); got != tt.want {
+			t.Errorf("AllowFrom %q, tags %v: got %v; want %v", tt.allow, tt.node.Tags().AsSlice(), got, tt.want)
+		}
+	}
+}
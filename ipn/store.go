@@ -53,6 +53,53 @@
 	// CurrentProfileStateKey is the key under which we store the current
 	// profile.
 	CurrentProfileStateKey = StateKey("_current-profile")
+
+	// PortMapLeaseStateKey is the key under which we store the most
+	// recent NAT-PMP or PCP port mapping lease, so it can be renewed
+	// after a restart. The value is a JSON-encoded portmapper.Lease.
+	PortMapLeaseStateKey = StateKey("_portmap-lease")
+
+	// PeerNotesStateKey is the key under which we store the nicknames
+	// and notes the user has assigned to peers. The value is a
+	// JSON-encoded map[tailcfg.StableNodeID]PeerNote.
+	PeerNotesStateKey = StateKey("_peer-notes")
+
+	// WantRunningScheduleStateKey is the key under which we store the
+	// pending timed change of the WantRunning pref, if any, so it still
+	// happens after a restart. The value is a JSON-encoded
+	// WantRunningSchedule.
+	WantRunningScheduleStateKey = StateKey("_want-running-schedule")
+
+	// LocalAPITokensStateKey is the key under which we store the LocalAPI
+	// tokens that have been created, keyed by ID. Only a hash of each
+	// token's secret is stored.
+	LocalAPITokensStateKey = StateKey("_localapi-tokens")
+
+	// LastUpdateStateKey is the key under which we store the version
+	// and start time of the most recent automatic update, so it can be
+	// reported once tailscaled restarts running the new version.
+	LastUpdateStateKey = StateKey("_last-update")
+
+	// PrefProvenanceStateKey is the key under which we store where each
+	// profile's prefs came from. The value is a JSON-encoded
+	// map[ProfileID]map[string]PrefProvenance, keyed by pref name.
+	PrefProvenanceStateKey = StateKey("_pref-provenance")
+
+	// BandwidthUsageStateKey is the key under which we store the traffic
+	// counted in the current bandwidth usage period, so it survives
+	// restarts. It's kept per machine rather than per profile.
+	BandwidthUsageStateKey = StateKey("_bandwidth-usage")
+
+	// ExitNodeConsentsStateKey is the key under which we store the
+	// notices of exit node changes made by policy and whether the user
+	// acknowledged them. The value is a JSON-encoded []ExitNodeConsent.
+	ExitNodeConsentsStateKey = StateKey("_exit-node-consents")
+
+	// ProxyCredentialsStateKey is the key under which we store the
+	// credentials for the outbound HTTP proxy in Prefs.Proxy, as
+	// "user:password". They're kept out of the prefs, which any local
+	// user may read.
+	ProxyCredentialsStateKey = StateKey("_proxy-credentials")
 )
 
 // CurrentProfileID returns the StateKey that stores the
@@ -94,6 +141,17 @@ type StateStoreDialerSetter interface {
 	SetDialer(d func(ctx context.Context, network, address string) (net.Conn, error))
 }
 
+// StateStoreCAS is an optional interface that StateStores can implement
+// to update state atomically, so that nodes sharing a store can coordinate
+// through it.
+type StateStoreCAS interface {
+	StateStore
+	// CompareAndSwapState saves bs as the state associated with ID if the
+	// current state is old, or if old is nil, if there is no current
+	// state. It reports whether it did.
+	CompareAndSwapState(id StateKey, old, bs []byte) (bool, error)
+}
+
 // ReadStoreInt reads an integer from a StateStore.
 func ReadStoreInt(store StateStore, id StateKey) (int64, error) {
 	v, err := store.ReadState(id)
@@ -0,0 +1,197 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// KeyStore protects the key with which an encrypted FileStore encrypts its
+// state file, such as the machine and node private keys.
+type KeyStore interface {
+	// Name returns the kind of key store, such as "dpapi" or
+	// "passphrase". It's recorded in the state file.
+	Name() string
+
+	// WrapKey protects key, returning the bytes to store in the state
+	// file from which UnwrapKey can recover it.
+	WrapKey(key []byte) (wrapped []byte, err error)
+
+	// UnwrapKey recovers a key protected by WrapKey.
+	UnwrapKey(wrapped []byte) (key []byte, err error)
+}
+
+// ParseKeyStore returns the KeyStore described by s, which is one of:
+//
+//   - "dpapi" (Windows only): the Windows Data Protection API, under the
+//     account tailscaled runs as.
+//   - "keychain" (macOS only): the System keychain.
+//   - "passphrase:<path>": a passphrase, read from the file at path.
+func ParseKeyStore(s string) (KeyStore, error) {
+	name, arg, _ := strings.Cut(s, ":")
+	switch name {
+	case "dpapi", "keychain":
+		ks := newPlatformKeyStore(name)
+		if ks == nil {
+			return nil, fmt.Errorf("%s key store not supported on this platform", name)
+		}
+		return ks, nil
+	case "passphrase":
+		if arg == "" {
+			return nil, errors.New(`passphrase key store requires a file: "passphrase:<path>"`)
+		}
+		bs, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return NewPassphraseKeyStore(string(bytes.TrimRight(bs, "\r\n")))
+	case "tpm":
+		return nil, errors.New("tpm key store not supported in this build")
+	}
+	return nil, fmt.Errorf("unknown key store %q; want dpapi, keychain or passphrase:<path>", s)
+}
+
+// newPlatformKeyStore returns the named key store of the OS, or nil if it
+// has no such key store. It's replaced on platforms that have one.
+var newPlatformKeyStore = func(name string) KeyStore { return nil }
+
+// NewPassphraseKeyStore returns a KeyStore that protects keys with a key
+// derived from passphrase.
+func NewPassphraseKeyStore(passphrase string) (KeyStore, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	return passphraseKeyStore(passphrase), nil
+}
+
+type passphraseKeyStore string
+
+const passphraseSaltLen = 16
+
+func (passphraseKeyStore) Name() string { return "passphrase" }
+
+func (p passphraseKeyStore) aead(salt []byte) (cipher.AEAD, error) {
+	kek := argon2.IDKey([]byte(p), salt, 1, 64*1024, 4, chacha20poly1305.KeySize)
+	return chacha20poly1305.NewX(kek)
+}
+
+// WrapKey returns a random salt followed by key, as encrypted by
+// the AEAD keyed by the passphrase and salt.
+func (p passphraseKeyStore) WrapKey(key []byte) ([]byte, error) {
+	salt := make([]byte, passphraseSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	return append(salt, sealRandomNonce(aead, key)...), nil
+}
+
+func (p passphraseKeyStore) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < passphraseSaltLen {
+		return nil, errors.New("wrapped key too short")
+	}
+	aead, err := p.aead(wrapped[:passphraseSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	key, err := openRandomNonce(aead, wrapped[passphraseSaltLen:])
+	if err != nil {
+		return nil, errors.New("wrong passphrase")
+	}
+	return key, nil
+}
+
+// encryptedFile is the JSON form of an encrypted state file.
+type encryptedFile struct {
+	// EncryptedState is the version of the format; it's currently
+	// always 1. It tells an encrypted state file apart from an
+	// unencrypted one, in which it's zero.
+	EncryptedState int
+	KeyStore       string // the KeyStore's Name
+	WrappedKey     []byte // the data key, as wrapped by the KeyStore
+	Data           []byte // the nonce then the sealed unencrypted file
+}
+
+// sealRandomNonce returns a random nonce followed by plaintext, as sealed
+// with it by aead.
+func sealRandomNonce(aead cipher.AEAD, plaintext []byte) []byte {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand doesn't fail
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil)
+}
+
+// openRandomNonce opens what sealRandomNonce returned.
+func openRandomNonce(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+}
+
+// seal returns the encrypted state file holding bs, the unencrypted one,
+// generating and wrapping the data key first if needed.
+// s.mu must be held exclusively, or s not yet shared.
+func (s *FileStore) seal(bs []byte) (*encryptedFile, error) {
+	if s.dataKey == nil {
+		key := make([]byte, chacha20poly1305.KeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		wrapped, err := s.ks.WrapKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("wrapping key with %v: %w", s.ks.Name(), err)
+		}
+		s.dataKey, s.wrappedKey = key, wrapped
+	}
+	aead, err := chacha20poly1305.NewX(s.dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedFile{
+		EncryptedState: 1,
+		KeyStore:       s.ks.Name(),
+		WrappedKey:     s.wrappedKey,
+		Data:           sealRandomNonce(aead, bs),
+	}, nil
+}
+
+// open returns the unencrypted state file in ef, keeping its data key for
+// later writes.
+// s.mu must be held exclusively, or s not yet shared.
+func (s *FileStore) open(ef *encryptedFile) ([]byte, error) {
+	if ef.EncryptedState != 1 {
+		return nil, fmt.Errorf("unsupported encrypted state version %d", ef.EncryptedState)
+	}
+	if ef.KeyStore != s.ks.Name() {
+		return nil, fmt.Errorf("encrypted with %v key store, not %v", ef.KeyStore, s.ks.Name())
+	}
+	key, err := s.ks.UnwrapKey(ef.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping key with %v: %w", ef.KeyStore, err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	bs, err := openRandomNonce(aead, ef.Data)
+	if err != nil {
+		return nil, err
+	}
+	s.dataKey, s.wrappedKey = key, ef.WrappedKey
+	return bs, nil
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+func init() {
+	newPlatformKeyStore = func(name string) KeyStore {
+		if name == "keychain" {
+			return keychainKeyStore{}
+		}
+		return nil
+	}
+}
+
+// keychainKeyStore is a KeyStore that keeps keys in the keychain of the
+// account tailscaled runs as (the System keychain, for root), using the
+// security(1) tool, hex-encoded as the item's password. The wrapped key is
+// the name of its keychain item.
+type keychainKeyStore struct{}
+
+const keychainService = "tailscaled-state-key"
+
+func (keychainKeyStore) Name() string { return "keychain" }
+
+func (keychainKeyStore) WrapKey(key []byte) ([]byte, error) {
+	const account = "state"
+	// Pass the command on stdin to "security -i" rather than as
+	// arguments, which other users could see in ps.
+	cmd := exec.Command("/usr/bin/security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		keychainService, account, hex.EncodeToString(key)))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("security add-generic-password: %w: %s", err, out)
+	}
+	return []byte(account), nil
+}
+
+func (keychainKeyStore) UnwrapKey(wrapped []byte) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("/usr/bin/security", "find-generic-password", "-s", keychainService, "-a", string(wrapped), "-w")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("security find-generic-password: %w: %s", err, stderr.Bytes())
+	}
+	return hex.DecodeString(strings.TrimSpace(string(out)))
+}
@@ -0,0 +1,54 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package store
+
+import (
+	"bytes"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func init() {
+	newPlatformKeyStore = func(name string) KeyStore {
+		if name == "dpapi" {
+			return dpapiKeyStore{}
+		}
+		return nil
+	}
+}
+
+// dpapiKeyStore is a KeyStore that protects keys with the Windows Data
+// Protection API, so that only the account that wrapped them (normally
+// LocalSystem, for tailscaled) can unwrap them.
+type dpapiKeyStore struct{}
+
+// dpapiEntropy is mixed into the protection, so that other programs
+// running as the same account can't unwrap the key with DPAPI alone.
+var dpapiEntropy = []byte("tailscaled state key")
+
+func (dpapiKeyStore) Name() string { return "dpapi" }
+
+func (dpapiKeyStore) WrapKey(key []byte) ([]byte, error) {
+	return dpapiCall(windows.CryptProtectData, key)
+}
+
+func (dpapiKeyStore) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return dpapiCall(func(in *windows.DataBlob, _ *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error {
+		return windows.CryptUnprotectData(in, nil, entropy, reserved, prompt, flags, out)
+	}, wrapped)
+}
+
+type dpapiFunc func(in *windows.DataBlob, name *uint16, entropy *windows.DataBlob, reserved uintptr, prompt *windows.CryptProtectPromptStruct, flags uint32, out *windows.DataBlob) error
+
+func dpapiCall(f dpapiFunc, b []byte) ([]byte, error) {
+	in := windows.DataBlob{Size: uint32(len(b)), Data: unsafe.SliceData(b)}
+	entropy := windows.DataBlob{Size: uint32(len(dpapiEntropy)), Data: unsafe.SliceData(dpapiEntropy)}
+	var out windows.DataBlob
+	if err := f(&in, nil, &entropy, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+	return bytes.Clone(unsafe.Slice(out.Data, out.Size)), nil
+}
@@ -6,6 +6,7 @@
 package kubestore
 
 import (
+	"bytes"
 	"context"
 	"net"
 	"strings"
@@ -118,3 +119,56 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	}
 	return err
 }
+
+// CompareAndSwapState implements the ipn.StateStoreCAS interface. It relies
+// on the Kubernetes API rejecting updates to a Secret that was changed
+// since it was read.
+func (s *Store) CompareAndSwapState(id ipn.StateKey, old, bs []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := sanitizeKey(id)
+	secret, err := s.client.GetSecret(ctx, s.secretName)
+	if err != nil {
+		if st, ok := err.(*kube.Status); ok && st.Code == 404 {
+			if old != nil {
+				return false, nil
+			}
+			err := s.client.CreateSecret(ctx, &kube.Secret{
+				TypeMeta: kube.TypeMeta{
+					APIVersion: "v1",
+					Kind:       "Secret",
+				},
+				ObjectMeta: kube.ObjectMeta{
+					Name: s.secretName,
+				},
+				Data: map[string][]byte{
+					key: bs,
+				},
+			})
+			return conflictOK(err)
+		}
+		return false, err
+	}
+	cur, ok := secret.Data[key]
+	if ok != (old != nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	secret.Data[key] = bs
+	// The Secret's ResourceVersion, from GetSecret, makes the update
+	// fail if another write happened in between.
+	return conflictOK(s.client.UpdateSecret(ctx, secret))
+}
+
+// conflictOK converts the result of creating or updating a Secret to that
+// of CompareAndSwapState: a conflict with a concurrent write means the swap
+// didn't happen, rather than an error.
+func conflictOK(err error) (bool, error) {
+	if err == nil {
+		return true, nil
+	}
+	if st, ok := err.(*kube.Status); ok && st.Code == 409 {
+		return false, nil
+	}
+	return false, err
+}
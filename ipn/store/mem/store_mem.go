@@ -48,6 +48,21 @@ func (s *Store) WriteState(id ipn.StateKey, bs []byte) error {
 	return nil
 }
 
+// CompareAndSwapState implements the ipn.StateStoreCAS interface.
+func (s *Store) CompareAndSwapState(id ipn.StateKey, old, bs []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.cache[id]
+	if ok != (old != nil) || !bytes.Equal(cur, old) {
+		return false, nil
+	}
+	if s.cache == nil {
+		s.cache = map[ipn.StateKey][]byte{}
+	}
+	s.cache[id] = bytes.Clone(bs)
+	return true, nil
+}
+
 // LoadFromJSON attempts to unmarshal json content into the
 // in-memory cache.
 func (s *Store) LoadFromJSON(data []byte) error {
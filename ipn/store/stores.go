@@ -7,6 +7,7 @@
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -110,8 +111,14 @@ func TryWindowsAppDataMigration(logf logger.Logf, path string) string {
 type FileStore struct {
 	path string
 
-	mu    sync.RWMutex
-	cache map[ipn.StateKey][]byte
+	// ks, if non-nil, is the key store that protects the key with which
+	// the file is encrypted. See NewEncryptedFileStore.
+	ks KeyStore
+
+	mu         sync.RWMutex
+	cache      map[ipn.StateKey][]byte
+	dataKey    []byte // if ks is non-nil, the key that encrypts the file
+	wrappedKey []byte // dataKey, as wrapped by ks
 }
 
 // Path returns the path that NewFileStore was called with.
@@ -120,7 +127,26 @@ func (s *FileStore) Path() string { return s.path }
 func (s *FileStore) String() string { return fmt.Sprintf("FileStore(%q)", s.path) }
 
 // NewFileStore returns a new file store that persists to path.
+//
+// It fails if the file at path was written by a store returned by
+// NewEncryptedFileStore.
 func NewFileStore(logf logger.Logf, path string) (ipn.StateStore, error) {
+	return newFileStore(logf, path, nil)
+}
+
+// NewEncryptedFileStore returns a new file store that persists to path,
+// encrypted with a key that's protected by ks.
+//
+// If the file at path is an unencrypted state file, as written by a store
+// returned by NewFileStore, it's encrypted in place.
+func NewEncryptedFileStore(logf logger.Logf, path string, ks KeyStore) (ipn.StateStore, error) {
+	if ks == nil {
+		return nil, errors.New("nil KeyStore")
+	}
+	return newFileStore(logf, path, ks)
+}
+
+func newFileStore(logf logger.Logf, path string, ks KeyStore) (*FileStore, error) {
 	// We unconditionally call this to ensure that our perms are correct
 	if err := paths.MkStateDir(filepath.Dir(path)); err != nil {
 		return nil, fmt.Errorf("creating state directory: %w", err)
@@ -135,28 +161,47 @@ func NewFileStore(logf logger.Logf, path string) (ipn.StateStore, error) {
 		err = os.ErrNotExist
 	}
 
+	ret := &FileStore{
+		path:  path,
+		ks:    ks,
+		cache: map[ipn.StateKey][]byte{},
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Write out an initial file, to verify that we can write
 			// to the path.
-			if err = atomicfile.WriteFile(path, []byte("{}"), 0600); err != nil {
+			if err := ret.writeLocked(); err != nil {
 				return nil, err
 			}
-			return &FileStore{
-				path:  path,
-				cache: map[ipn.StateKey][]byte{},
-			}, nil
+			return ret, nil
 		}
 		return nil, err
 	}
 
-	ret := &FileStore{
-		path:  path,
-		cache: map[ipn.StateKey][]byte{},
+	var ef encryptedFile
+	if err := json.Unmarshal(bs, &ef); err != nil {
+		return nil, err
+	}
+	switch {
+	case ef.EncryptedState != 0:
+		if ks == nil {
+			return nil, fmt.Errorf("state file %q is encrypted; an encryption key store must be configured to read it", path)
+		}
+		if bs, err = ret.open(&ef); err != nil {
+			return nil, fmt.Errorf("decrypting state file %q: %w", path, err)
+		}
+	case ks != nil:
+		logf("store: encrypting state file %q with %v key", path, ks.Name())
 	}
 	if err := json.Unmarshal(bs, &ret.cache); err != nil {
 		return nil, err
 	}
+	if ks != nil && ef.EncryptedState == 0 {
+		// Migrate an unencrypted state file.
+		if err := ret.writeLocked(); err != nil {
+			return nil, fmt.Errorf("encrypting state file %q: %w", path, err)
+		}
+	}
 
 	return ret, nil
 }
@@ -180,9 +225,24 @@ func (s *FileStore) WriteState(id ipn.StateKey, bs []byte) error {
 		return nil
 	}
 	s.cache[id] = bytes.Clone(bs)
+	return s.writeLocked()
+}
+
+// writeLocked writes s.cache to s.path, encrypting it if s.ks is non-nil.
+// s.mu must be held exclusively, or s not yet shared.
+func (s *FileStore) writeLocked() error {
 	bs, err := json.MarshalIndent(s.cache, "", "  ")
 	if err != nil {
 		return err
 	}
+	if s.ks != nil {
+		ef, err := s.seal(bs)
+		if err != nil {
+			return err
+		}
+		if bs, err = json.MarshalIndent(ef, "", "  "); err != nil {
+			return err
+		}
+	}
 	return atomicfile.WriteFile(s.path, bs, 0600)
 }
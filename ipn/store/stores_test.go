@@ -4,7 +4,11 @@
 package store
 
 import (
+	"bytes"
+	"encoding/base64"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"tailscale.com/ipn"
@@ -142,6 +146,32 @@ func TestMemoryStore(t *testing.T) {
 	testStoreSemantics(t, store)
 }
 
+func TestMemoryStoreCAS(t *testing.T) {
+	var store ipn.StateStoreCAS = new(mem.Store)
+	cas := func(old, bs string, want bool) {
+		t.Helper()
+		var oldb []byte
+		if old != "" {
+			oldb = []byte(old)
+		}
+		ok, err := store.CompareAndSwapState("foo", oldb, []byte(bs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("CompareAndSwapState(%q, %q) = %v; want %v", old, bs, ok, want)
+		}
+	}
+	cas("x", "a", false) // doesn't exist yet
+	cas("", "a", true)
+	cas("", "b", false) // exists now
+	cas("x", "b", false)
+	cas("a", "b", true)
+	if bs, err := store.ReadState("foo"); err != nil || string(bs) != "b" {
+		t.Errorf("ReadState = %q, %v; want b", bs, err)
+	}
+}
+
 func TestFileStore(t *testing.T) {
 	tstest.PanicOnLog()
 
@@ -177,3 +207,76 @@ func TestFileStore(t *testing.T) {
 		}
 	}
 }
+
+func TestEncryptedFileStore(t *testing.T) {
+	tstest.PanicOnLog()
+
+	path := filepath.Join(t.TempDir(), "test-encrypted-file-store.conf")
+	ks, err := NewPassphraseKeyStore("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Start with an unencrypted state file, which should be migrated.
+	store, err := NewFileStore(nil, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.WriteState("old", []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewEncryptedFileStore(t.Logf, path, ks)
+	if err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	if bs, err := store.ReadState("old"); err != nil || string(bs) != "plain" {
+		t.Errorf("after migration, ReadState = %q, %v; want plain", bs, err)
+	}
+	testStoreSemantics(t, store)
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(bs, []byte("quux")) || bytes.Contains(bs, []byte(base64.StdEncoding.EncodeToString([]byte("quux")))) {
+		t.Errorf("state file not encrypted: %s", bs)
+	}
+
+	// Reopen with the same passphrase.
+	store, err = NewEncryptedFileStore(nil, path, ks)
+	if err != nil {
+		t.Fatalf("reopening: %v", err)
+	}
+	for key, want := range map[ipn.StateKey]string{"old": "plain", "foo": "bar", "baz": "quux"} {
+		if bs, err := store.ReadState(key); err != nil || string(bs) != want {
+			t.Errorf("reopened, ReadState(%q) = %q, %v; want %q", key, bs, err, want)
+		}
+	}
+
+	wrong, _ := NewPassphraseKeyStore("hunter3")
+	if _, err := NewEncryptedFileStore(nil, path, wrong); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("with wrong passphrase, err = %v; want wrong passphrase", err)
+	}
+	if _, err := NewFileStore(nil, path); err == nil {
+		t.Error("unencrypted store read encrypted state file")
+	}
+}
+
+func TestParseKeyStore(t *testing.T) {
+	passFile := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(passFile, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := ParseKeyStore("passphrase:" + passFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ks != passphraseKeyStore("hunter2") {
+		t.Errorf("got %#v; want passphrase hunter2", ks)
+	}
+	for _, bad := range []string{"", "passphrase", "passphrase:" + passFile + ".missing", "tpm", "rot13"} {
+		if ks, err := ParseKeyStore(bad); err == nil {
+			t.Errorf("ParseKeyStore(%q) = %v; want error", bad, ks)
+		}
+	}
+}
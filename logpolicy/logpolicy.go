@@ -95,6 +95,52 @@ func LogHost() string {
 	return logtail.DefaultHost
 }
 
+// logSink, if non-nil, is where Policies created by New send their logs
+// in place of the log server. logSinkSpec is the spec it was made from.
+// See SetLogSink.
+var (
+	logSink     logtail.Sink
+	logSinkSpec string
+)
+
+// SetLogSink sets where the logs of Policies created afterwards by New are
+// sent, in place of the Tailscale log server. spec is one of:
+//
+//   - "" or "tailscale": the log server (the default)
+//   - "file:PATH": the file PATH, one JSON log entry per line, rotated at 10MB
+//   - "syslog": the local syslog daemon, or journald on systemd systems
+//   - "none": nowhere
+//
+// Logs are still written to stderr either way.
+func SetLogSink(spec string) error {
+	var sink logtail.Sink
+	switch {
+	case spec == "" || spec == "tailscale":
+	case spec == "none":
+		sink = logtail.DiscardSink
+	case spec == "syslog":
+		var err error
+		sink, err = logtail.NewSyslogSink(version.CmdName())
+		if err != nil {
+			return err
+		}
+	case strings.HasPrefix(spec, "file:"):
+		path := strings.TrimPrefix(spec, "file:")
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("log sink %q: path must be absolute", spec)
+		}
+		var err error
+		sink, err = logtail.NewFileSink(path, logtail.FileSinkOptions{})
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown log sink %q; want tailscale, file:PATH, syslog or none", spec)
+	}
+	logSink, logSinkSpec = sink, spec
+	return nil
+}
+
 // Config represents an instance of logs in a collection.
 type Config struct {
 	Collection string
@@ -574,7 +620,10 @@ func NewWithConfigPath(collection, dir, cmdName string, netMon *netmon.Monitor,
 		conf.IncludeProcSequence = true
 	}
 
-	if envknob.NoLogsNoSupport() || testenv.InTest() {
+	if logSink != nil {
+		logf("Logs are going to %q, not to Tailscale. Tailscale will not be able to provide support.", logSinkSpec)
+		conf.Sink = logSink
+	} else if envknob.NoLogsNoSupport() || testenv.InTest() {
 		logf("You have disabled logging. Tailscale will not be able to provide support.")
 		conf.HTTPC = &http.Client{Transport: noopPretendSuccessTransport{}}
 	} else if val := getLogTarget(); val != "" {
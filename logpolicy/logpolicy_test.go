@@ -5,6 +5,7 @@
 
 import (
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -34,3 +35,27 @@ func TestLogHost(t *testing.T) {
 		}
 	}
 }
+
+func TestSetLogSink(t *testing.T) {
+	defer SetLogSink("")
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"", false},
+		{"tailscale", false},
+		{"none", false},
+		{"file:" + filepath.Join(t.TempDir(), "tailscaled.log"), false},
+		{"file:relative.log", true},
+		{"splunk", true},
+	}
+	for _, tt := range tests {
+		err := SetLogSink(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SetLogSink(%q) = %v; want error: %v", tt.spec, err, tt.wantErr)
+		}
+		if err == nil && (logSink == nil) != (tt.spec == "" || tt.spec == "tailscale") {
+			t.Errorf("SetLogSink(%q): sink = %v", tt.spec, logSink)
+		}
+	}
+}
@@ -6,7 +6,6 @@
 
 import (
 	"context"
-	"math/rand"
 	"time"
 
 	"tailscale.com/tstime"
@@ -18,6 +17,7 @@
 type Backoff struct {
 	n          int // number of consecutive failures
 	maxBackoff time.Duration
+	component  Component // whose Policy applies, or empty for none
 
 	// Name is the name of this backoff timer, for logging purposes.
 	name string
@@ -44,6 +44,15 @@ func NewBackoff(name string, logf logger.Logf, maxBackoff time.Duration) *Backof
 	}
 }
 
+// NewComponentBackoff is like NewBackoff, but for a connection of
+// component c: its Policy applies, with maxBackoff the default of
+// Policy.MaxBackoff, and its failures are counted in c's metrics.
+func NewComponentBackoff(c Component, name string, logf logger.Logf, maxBackoff time.Duration) *Backoff {
+	b := NewBackoff(name, logf, maxBackoff)
+	b.component = c
+	return b
+}
+
 // Backoff sleeps an increasing amount of time if err is non-nil.
 // and the context is not a
 // It resets the backoff schedule once err is nil.
@@ -59,15 +68,14 @@ func (b *Backoff) BackOff(ctx context.Context, err error) {
 	}
 
 	b.n++
-	// n^2 backoff timer is a little smoother than the
-	// common choice of 2^n.
-	d := time.Duration(b.n*b.n) * 10 * time.Millisecond
-	if d > b.maxBackoff {
-		d = b.maxBackoff
+	var p Policy
+	if b.component != "" {
+		p = b.component.Policy()
+		b.component.NoteFailure(b.n)
 	}
-	// Randomize the delay between 0.5-1.5 x msec, in order
+	// The delay is randomized, 0.5-1.5x by default, in order
 	// to prevent accidental "thundering herd" problems.
-	d = time.Duration(float64(d) * (rand.Float64() + 0.5))
+	d := p.delay(b.n, b.maxBackoff)
 
 	if d >= b.LogLongerThan {
 		b.logf("%s: [v1] backoff: %d msec", b.name, d.Milliseconds())
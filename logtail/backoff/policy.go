@@ -0,0 +1,178 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backoff
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/cmpx"
+)
+
+// Component is a kind of connection whose retry Policy can be configured.
+type Component string
+
+const (
+	Control Component = "control" // the coordination server
+	DERP    Component = "derp"    // DERP relay servers
+	Log     Component = "log"     // log uploads
+)
+
+// Components are all the Components.
+var Components = []Component{Control, DERP, Log}
+
+// defaultJitter is the fraction by which delays are randomized by default.
+const defaultJitter = 0.5
+
+// defaultStormThreshold is the number of consecutive failures counted as a
+// retry storm if a Policy doesn't set FailFastAfter.
+const defaultStormThreshold = 10
+
+// Policy is how persistently a Component's connections are retried after
+// they fail. A zero field means the Component's default.
+type Policy struct {
+	// MaxBackoff is the longest to wait between attempts. For log
+	// uploads, which are retried at a steady interval rather than
+	// increasingly, it's that interval.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction, in (0, 1], by which each wait is randomized
+	// to avoid many clients retrying in lockstep: a wait of d becomes one
+	// between d*(1-Jitter) and d*(1+Jitter). It defaults to 0.5.
+	Jitter float64
+
+	// FailFastAfter is the number of consecutive failures after which the
+	// connection is considered down: rather than keep growing, waits are
+	// MaxBackoff straight away, so that a dead link isn't hammered. It's
+	// also when a run of failures counts as a retry storm in metrics. If
+	// zero, waits grow until they reach MaxBackoff, and 10 failures are a
+	// storm.
+	FailFastAfter int
+}
+
+// Check reports whether p is valid.
+func (p Policy) Check() error {
+	if p.MaxBackoff < 0 {
+		return fmt.Errorf("negative MaxBackoff %v", p.MaxBackoff)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("Jitter %v is not between 0 and 1", p.Jitter)
+	}
+	if p.FailFastAfter < 0 {
+		return fmt.Errorf("negative FailFastAfter %d", p.FailFastAfter)
+	}
+	return nil
+}
+
+// Jittered returns d randomized by p.Jitter.
+func (p Policy) Jittered(d time.Duration) time.Duration {
+	j := cmpx.Or(p.Jitter, defaultJitter)
+	return time.Duration(float64(d) * (1 - j + 2*j*rand.Float64()))
+}
+
+// delay returns how long to wait after the nth consecutive failure, where
+// defaultMax is the longest wait if p doesn't set MaxBackoff.
+func (p Policy) delay(n int, defaultMax time.Duration) time.Duration {
+	maxBackoff := cmpx.Or(p.MaxBackoff, defaultMax)
+	// n^2 backoff timer is a little smoother than the
+	// common choice of 2^n.
+	d := time.Duration(n*n) * 10 * time.Millisecond
+	if d > maxBackoff || (p.FailFastAfter > 0 && n >= p.FailFastAfter) {
+		d = maxBackoff
+	}
+	return p.Jittered(d)
+}
+
+// Preset is a named set of Policies for all Components.
+type Preset string
+
+const (
+	// Interactive retries quickly, so that connectivity comes back as
+	// soon as possible for someone waiting on it. It's the default.
+	Interactive Preset = "interactive"
+
+	// Server retries less aggressively, with longer and more randomized
+	// waits, to spare flaky or metered links, and the servers, from
+	// retry storms when many nodes lose connectivity at once.
+	Server Preset = "server"
+)
+
+// ParsePreset parses the name of a Preset. The empty string is Interactive.
+func ParsePreset(s string) (Preset, error) {
+	switch p := Preset(s); p {
+	case "":
+		return Interactive, nil
+	case Interactive, Server:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown backoff preset %q; want %q or %q", s, Interactive, Server)
+}
+
+// Policy returns the Policy of c in p.
+func (p Preset) Policy(c Component) Policy {
+	if p != Server {
+		return Policy{}
+	}
+	switch c {
+	case Control:
+		return Policy{MaxBackoff: 2 * time.Minute, Jitter: 0.75, FailFastAfter: 15}
+	case DERP:
+		return Policy{MaxBackoff: time.Minute, Jitter: 0.75, FailFastAfter: 15}
+	case Log:
+		return Policy{MaxBackoff: 5 * time.Minute, Jitter: 0.75}
+	}
+	return Policy{}
+}
+
+var (
+	policyMu sync.Mutex
+	policies = map[Component]Policy{}
+)
+
+// SetPolicy sets the Policy of c, process-wide. It applies from the next
+// failure of c's connections on.
+func SetPolicy(c Component, p Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policies[c] = p
+}
+
+// Policy returns the current Policy of c.
+func (c Component) Policy() Policy {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	return policies[c]
+}
+
+type componentMetrics struct {
+	retries *clientmetric.Metric // failed attempts that are retried
+	storms  *clientmetric.Metric // runs of failures reaching the storm threshold
+}
+
+var metrics = func() map[Component]componentMetrics {
+	m := make(map[Component]componentMetrics)
+	for _, c := range Components {
+		m[c] = componentMetrics{
+			retries: clientmetric.NewCounter(fmt.Sprintf("backoff_%s_retries", c)),
+			storms:  clientmetric.NewCounter(fmt.Sprintf("backoff_%s_retry_storms", c)),
+		}
+	}
+	return m
+}()
+
+// NoteFailure records in metrics that a connection of c failed for the nth
+// consecutive time and is to be retried.
+func (c Component) NoteFailure(n int) {
+	m, ok := metrics[c]
+	if !ok {
+		return
+	}
+	m.retries.Add(1)
+	if n == cmpx.Or(c.Policy().FailFastAfter, defaultStormThreshold) {
+		m.storms.Add(1)
+	}
+}
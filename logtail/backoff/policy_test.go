@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        Policy
+		n        int
+		min, max time.Duration
+	}{
+		{"default-ramp", Policy{}, 10, 500 * time.Millisecond, 1500 * time.Millisecond},
+		{"default-max", Policy{}, 1000, 15 * time.Second, 45 * time.Second},
+		{"max-backoff", Policy{MaxBackoff: time.Minute}, 1000, 30 * time.Second, 90 * time.Second},
+		{"jitter", Policy{Jitter: 0.1}, 1000, 27 * time.Second, 33 * time.Second},
+		{"before-fail-fast", Policy{FailFastAfter: 5}, 4, 80 * time.Millisecond, 240 * time.Millisecond},
+		{"fail-fast", Policy{FailFastAfter: 5}, 5, 15 * time.Second, 45 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if d := tt.p.delay(tt.n, 30*time.Second); d < tt.min || d > tt.max {
+				t.Fatalf("%s: delay = %v; want between %v and %v", tt.name, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	for _, s := range []string{"", "interactive", "server"} {
+		p, err := ParsePreset(s)
+		if err != nil {
+			t.Fatalf("ParsePreset(%q): %v", s, err)
+		}
+		for _, c := range Components {
+			if err := p.Policy(c).Check(); err != nil {
+				t.Errorf("%v policy of %v: %v", p, c, err)
+			}
+		}
+	}
+	if p := Interactive.Policy(Control); p != (Policy{}) {
+		t.Errorf("interactive control policy = %+v; want defaults", p)
+	}
+	if _, err := ParsePreset("fast"); err == nil {
+		t.Error("ParsePreset of unknown preset succeeded")
+	}
+}
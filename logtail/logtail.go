@@ -22,6 +22,7 @@
 	"time"
 
 	"tailscale.com/envknob"
+	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/sockstats"
 	"tailscale.com/tstime"
@@ -61,6 +62,10 @@ type Config struct {
 	Buffer         Buffer          // temp storage, if nil a MemoryBuffer
 	NewZstdEncoder func() Encoder  // if set, used to compress logs for transmission
 
+	// Sink, if non-nil, is where logs are sent instead of the log server
+	// at BaseURL. BaseURL, HTTPC and NewZstdEncoder are then unused.
+	Sink Sink
+
 	// MetricsDelta, if non-nil, is a func that returns an encoding
 	// delta in clientmetrics to upload alongside existing logs.
 	// It can return either an empty string (for nothing) or a string
@@ -145,6 +150,7 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		flushDelayFn:   cfg.FlushDelayFn,
 		clock:          cfg.Clock,
 		metricsDelta:   cfg.MetricsDelta,
+		sink:           cfg.Sink,
 
 		procID:              procID,
 		includeProcSequence: cfg.IncludeProcSequence,
@@ -153,7 +159,7 @@ func NewLogger(cfg Config, logf tslogger.Logf) *Logger {
 		shutdownDone:  make(chan struct{}),
 	}
 	l.SetSockstatsLabel(sockstats.LabelLogtailLogger)
-	if cfg.NewZstdEncoder != nil {
+	if cfg.NewZstdEncoder != nil && cfg.Sink == nil {
 		l.zstdEncoder = cfg.NewZstdEncoder()
 	}
 
@@ -172,6 +178,7 @@ type Logger struct {
 	stderrLevel    int64 // accessed atomically
 	httpc          *http.Client
 	url            string
+	sink           Sink // or nil to upload to url
 	lowMem         bool
 	skipClientTime bool
 	netMonitor     *netmon.Monitor
@@ -376,12 +383,18 @@ func (l *Logger) uploading(ctx context.Context) {
 		var numFailures int
 		var firstFailure time.Time
 		for len(body) > 0 && ctx.Err() == nil {
-			retryAfter, err := l.upload(ctx, body, origlen)
+			var retryAfter time.Duration
+			var err error
+			if l.sink != nil {
+				retryAfter, err = l.sink.Upload(ctx, body)
+			} else {
+				retryAfter, err = l.upload(ctx, body, origlen)
+			}
 			if err != nil {
 				numFailures++
 				firstFailure = l.clock.Now()
 
-				if !l.internetUp() {
+				if l.sink == nil && !l.internetUp() {
 					fmt.Fprintf(l.stderr, "logtail: internet down; waiting\n")
 					l.awaitInternetUp(ctx)
 					continue
@@ -395,8 +408,12 @@ func (l *Logger) uploading(ctx context.Context) {
 
 				// Sleep for the specified retryAfter period,
 				// otherwise default to some random value.
+				backoff.Log.NoteFailure(numFailures)
 				if retryAfter <= 0 {
 					retryAfter = time.Duration(30+mrand.Intn(30)) * time.Second
+					if p := backoff.Log.Policy(); p.MaxBackoff > 0 {
+						retryAfter = p.Jittered(p.MaxBackoff)
+					}
 				}
 				tstime.Sleep(ctx, retryAfter)
 			} else {
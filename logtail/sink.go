@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Sink is where a Logger sends its logs, in place of the log server.
+type Sink interface {
+	// Upload stores or sends body, a JSON array of log entries. body
+	// must not be retained after Upload returns.
+	//
+	// If Upload fails, the Logger retries after retryAfter, or a random
+	// delay if it's zero or negative.
+	Upload(ctx context.Context, body []byte) (retryAfter time.Duration, err error)
+}
+
+// DiscardSink is a Sink that drops all logs.
+var DiscardSink Sink = discardSink{}
+
+type discardSink struct{}
+
+func (discardSink) Upload(context.Context, []byte) (time.Duration, error) { return 0, nil }
+
+// FileSinkOptions are the options for NewFileSink.
+type FileSinkOptions struct {
+	MaxFileSize int64 // size at which the file is rotated; 0 means 10MB
+	MaxBackups  int   // number of rotated files to keep; 0 means 5
+}
+
+// NewFileSink returns a Sink that appends log entries, one JSON object per
+// line, to the file at path.
+//
+// Once the file exceeds opts.MaxFileSize, it's renamed to path+".1", any
+// older path+".N" files are renamed to path+".N+1", and the oldest beyond
+// opts.MaxBackups is removed.
+func NewFileSink(path string, opts FileSinkOptions) (Sink, error) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = 10 << 20
+	}
+	if opts.MaxBackups <= 0 {
+		opts.MaxBackups = 5
+	}
+	s := &fileSink{path: path, opts: opts}
+	if err := s.openLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type fileSink struct {
+	path string
+	opts FileSinkOptions
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func (s *fileSink) openLocked() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *fileSink) rotateLocked() error {
+	s.f.Close()
+	s.f = nil
+	os.Remove(fmt.Sprintf("%s.%d", s.path, s.opts.MaxBackups))
+	for i := s.opts.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.openLocked()
+}
+
+func (s *fileSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	for _, e := range entries {
+		buf.Write(e)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		// A previous rotation failed to reopen the file.
+		if err := s.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.f.Write(buf.Bytes())
+	s.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+	if s.size >= s.opts.MaxFileSize {
+		// The entries were written, so don't fail the upload if
+		// rotation fails; the next Upload reopens the file if needed
+		// and tries again.
+		s.rotateLocked()
+	}
+	return 0, nil
+}
+
+// sinkEntry is the part of a log entry that text-based Sinks use.
+type sinkEntry struct {
+	Text    string `json:"text"`
+	Verbose int    `json:"v"`
+}
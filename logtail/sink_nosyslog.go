@@ -0,0 +1,16 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || plan9 || js || wasip1
+
+package logtail
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// NewSyslogSink returns an error; there's no syslog on this platform.
+func NewSyslogSink(tag string) (Sink, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !windows && !plan9 && !js && !wasip1
+
+package logtail
+
+import (
+	"context"
+	"encoding/json"
+	"log/syslog"
+	"strings"
+	"time"
+)
+
+// NewSyslogSink returns a Sink that sends the text of each log entry to the
+// local syslog daemon (which on systemd systems is journald) with the given
+// tag. Verbose entries are sent at debug priority and others at info.
+func NewSyslogSink(tag string) (Sink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func (s *syslogSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	var entries []sinkEntry
+	if err := json.Unmarshal(body, &entries); err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		text := strings.TrimSuffix(e.Text, "\n")
+		if text == "" {
+			continue
+		}
+		var err error
+		if e.Verbose > 0 {
+			err = s.w.Debug(text)
+		} else {
+			err = s.w.Info(text)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
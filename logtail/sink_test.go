@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logtail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type chanSink chan []byte
+
+func (s chanSink) Upload(ctx context.Context, body []byte) (time.Duration, error) {
+	s <- bytes.Clone(body)
+	return 0, nil
+}
+
+func TestLoggerSink(t *testing.T) {
+	sink := make(chanSink, 10)
+	l := NewLogger(Config{
+		Sink:           sink,
+		BaseURL:        "http://invalid.example/",
+		NewZstdEncoder: func() Encoder { panic("unexpected compression") },
+		FlushDelayFn:   func() time.Duration { return 0 },
+	}, t.Logf)
+	defer l.Shutdown(context.Background())
+	l.Write([]byte(strings.Repeat("sunk ", 100)))
+
+	for {
+		select {
+		case body := <-sink:
+			var entries []sinkEntry
+			if err := json.Unmarshal(body, &entries); err != nil {
+				t.Fatalf("body %q: %v", body, err)
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.Text, "sunk ") {
+					return
+				}
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for sink")
+		}
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.log")
+	sink, err := NewFileSink(path, FileSinkOptions{MaxFileSize: 100, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		body := fmt.Sprintf(`[{"text": "line %d, part 1"}, {"text": "line %d, part 2"}]`, i, i)
+		if _, err := sink.Upload(context.Background(), []byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := sink.Upload(context.Background(), []byte("not json")); err == nil {
+		t.Error("Upload of invalid JSON succeeded")
+	}
+
+	// Each upload writes 54 bytes, so the file is rotated after every second
+	// one, and only the newest two rotated files are kept.
+	for suffix, want := range map[string]string{
+		"":   `{"text": "line 4, part 1"}` + "\n" + `{"text": "line 4, part 2"}` + "\n",
+		".1": `{"text": "line 2, part 1"}` + "\n" + `{"text": "line 2, part 2"}` + "\n" + `{"text": "line 3, part 1"}` + "\n" + `{"text": "line 3, part 2"}` + "\n",
+		".2": `{"text": "line 0, part 1"}` + "\n" + `{"text": "line 0, part 2"}` + "\n" + `{"text": "line 1, part 1"}` + "\n" + `{"text": "line 1, part 2"}` + "\n",
+	} {
+		got, err := os.ReadFile(path + suffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s%s = %q; want %q", filepath.Base(path), suffix, got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists; want only 2 backups", path)
+	}
+}
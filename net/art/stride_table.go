@@ -161,6 +161,20 @@ func (t *strideTable[T]) allot(idx int, old, new *T) {
 
 // insert adds the route addr/prefixLen to t, with value val.
 func (t *strideTable[T]) insert(addr uint8, prefixLen int, val T) {
+	// For allot to work correctly, each distinct prefix in the
+	// strideTable must have a different value pointer, even if val is
+	// identical. This new()+assignment guarantees that each inserted
+	// prefix gets a unique address.
+	p := new(T)
+	*p = val
+
+	t.insertPtr(addr, prefixLen, p)
+}
+
+// insertPtr is like insert, but stores p itself rather than a copy of
+// the value it points to. p must not be the value pointer of any other
+// prefix in t.
+func (t *strideTable[T]) insertPtr(addr uint8, prefixLen int, p *T) {
 	idx := prefixIndex(addr, prefixLen)
 	if !t.hasPrefixRootedAt(idx) {
 		// This route entry is being freshly created (not just
@@ -169,16 +183,7 @@ func (t *strideTable[T]) insert(addr uint8, prefixLen int, val T) {
 	}
 
 	old := t.entries[idx]
-
-	// For allot to work correctly, each distinct prefix in the
-	// strideTable must have a different value pointer, even if val is
-	// identical. This new()+assignment guarantees that each inserted
-	// prefix gets a unique address.
-	p := new(T)
-	*p = val
-
 	t.allot(idx, old, p)
-	return
 }
 
 // delete removes the route addr/prefixLen from t. Reports whether the
@@ -129,6 +129,14 @@ type prefixAndRoute struct {
 // Insert adds pfx to the table, with value val.
 // If pfx is already present in the table, its value is set to val.
 func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
+	// Each prefix needs its own value pointer; see strideTable.insert.
+	p := new(T)
+	*p = val
+	t.insert(pfx, p)
+}
+
+// insert adds pfx to the table, with the value pointed to by p.
+func (t *Table[T]) insert(pfx netip.Prefix, p *T) {
 	t.init()
 
 	// The standard library doesn't enforce normalized prefixes (where
@@ -160,7 +168,7 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 		if debugInsert {
 			fmt.Printf("insert: default route\n")
 		}
-		st.insert(0, 0, val)
+		st.insertPtr(0, 0, p)
 		return
 	}
 
@@ -215,7 +223,7 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 			// We've reached the end of the prefix, whichever
 			// strideTable we're looking at now is the place where we
 			// need to insert.
-			st.insert(bs[finalByteIdx], finalBits, val)
+			st.insertPtr(bs[finalByteIdx], finalBits, p)
 			return
 		}
 
@@ -235,7 +243,7 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 			// jump straight to the final strideTable that hosts this
 			// prefix.
 			child.prefix = finalStridePrefix
-			child.insert(bs[finalByteIdx], finalBits, val)
+			child.insertPtr(bs[finalByteIdx], finalBits, p)
 			if debugInsert {
 				fmt.Printf("insert: new leaf st.prefix=%s child.prefix=%s addr=%d/%d\n", st.prefix, child.prefix, bs[finalByteIdx], finalBits)
 			}
@@ -279,7 +287,7 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 				if debugInsert {
 					fmt.Printf("insert: into intermediate intermediate.prefix=%s addr=%d/%d\n", intermediate.prefix, bs[finalByteIdx], finalBits)
 				}
-				intermediate.insert(bs[finalByteIdx], finalBits, val)
+				intermediate.insertPtr(bs[finalByteIdx], finalBits, p)
 			} else {
 				// pfx lives in a different child subtree of
 				// intermediate. By definition this subtree doesn't
@@ -294,7 +302,7 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 					panic("new child path unexpectedly exists during path decompression")
 				}
 				st.prefix = finalStridePrefix
-				st.insert(bs[finalByteIdx], finalBits, val)
+				st.insertPtr(bs[finalByteIdx], finalBits, p)
 				if debugInsert {
 					fmt.Printf("insert: new child st.prefix=%s addr=%d/%d\n", st.prefix, bs[finalByteIdx], finalBits)
 				}
@@ -314,6 +322,27 @@ func (t *Table[T]) Insert(pfx netip.Prefix, val T) {
 	}
 }
 
+// Route is a prefix and its associated value, for use with InsertBulk.
+type Route[T any] struct {
+	Prefix netip.Prefix
+	Val    T
+}
+
+// InsertBulk adds all of routes to the table, as if by calling Insert on
+// each in order: if a prefix appears more than once, the last value wins.
+//
+// It's cheaper than individual Inserts for large numbers of routes, such as
+// the thousands of subnet routes of an app connector or cloud VPC router,
+// as the values are stored in a single allocation rather than one per
+// route. That allocation is retained until all of the routes are deleted.
+func (t *Table[T]) InsertBulk(routes []Route[T]) {
+	vals := make([]T, len(routes))
+	for i, r := range routes {
+		vals[i] = r.Val
+		t.insert(r.Prefix, &vals[i])
+	}
+}
+
 // Delete removes pfx from the table, if it is present.
 func (t *Table[T]) Delete(pfx netip.Prefix) {
 	t.init()
@@ -620,6 +620,33 @@ func TestInsertCompare(t *testing.T) {
 	}
 }
 
+func TestInsertBulk(t *testing.T) {
+	t.Parallel()
+	pfxs := randomPrefixes(10_000)
+	// Duplicate some prefixes with new values, which should win, as with
+	// repeated Inserts.
+	for i := 0; i < 100; i++ {
+		pfxs = append(pfxs, slowPrefixEntry[int]{pfxs[i].pfx, -i - 1})
+	}
+
+	var one, bulk Table[int]
+	routes := make([]Route[int], 0, len(pfxs))
+	for _, pfx := range pfxs {
+		one.Insert(pfx.pfx, pfx.val)
+		routes = append(routes, Route[int]{pfx.pfx, pfx.val})
+	}
+	bulk.InsertBulk(routes)
+
+	for i := 0; i < 10_000; i++ {
+		a := randomAddr()
+		oneVal, oneOK := one.Get(a)
+		bulkVal, bulkOK := bulk.Get(a)
+		if !getsEqual(oneVal, oneOK, bulkVal, bulkOK) {
+			t.Fatalf("get(%q) = (%v, %v), want (%v, %v)", a, bulkVal, bulkOK, oneVal, oneOK)
+		}
+	}
+}
+
 func TestInsertShuffled(t *testing.T) {
 	// The order in which you insert prefixes into a route table
 	// should not matter, as long as you're inserting the same set of
@@ -929,6 +956,24 @@ func BenchmarkTableInsertion(b *testing.B) {
 	})
 }
 
+func BenchmarkTableInsertBulk(b *testing.B) {
+	forFamilyAndCount(b, func(b *testing.B, routes []slowPrefixEntry[int]) {
+		bulk := make([]Route[int], 0, len(routes))
+		for _, route := range routes {
+			bulk = append(bulk, Route[int]{route.pfx, route.val})
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var rt Table[int]
+			rt.InsertBulk(bulk)
+		}
+		b.StopTimer()
+		inserts := float64(b.N) * float64(len(routes))
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/inserts, "ns/op")
+		b.ReportMetric(inserts/b.Elapsed().Seconds(), "routes/s")
+	})
+}
+
 func BenchmarkTableDelete(b *testing.B) {
 	forFamilyAndCount(b, func(b *testing.B, routes []slowPrefixEntry[int]) {
 		// Collect memstats for one round of insertions, so we can remove it
@@ -21,7 +21,8 @@
 // All methods are safe for concurrent use.
 // The zero value is ready for use.
 type Statistics struct {
-	maxConns int // immutable once set
+	maxConns int           // immutable once set
+	tee      []*Statistics // immutable; if non-nil, updates are forwarded to these
 
 	mu sync.Mutex
 	connCnts
@@ -79,10 +80,36 @@ func NewStatistics(maxPeriod time.Duration, maxConns int, dump func(start, end t
 	return s
 }
 
+// Tee returns a Statistics that forwards all updates to each of stats,
+// or nil if stats is empty. Nil elements of stats are ignored.
+// Shutting down the returned Statistics does nothing;
+// each of stats must be shut down by its owner.
+func Tee(stats ...*Statistics) *Statistics {
+	var tee []*Statistics
+	for _, s := range stats {
+		if s != nil {
+			tee = append(tee, s)
+		}
+	}
+	switch len(tee) {
+	case 0:
+		return nil
+	case 1:
+		return tee[0]
+	}
+	return &Statistics{tee: tee}
+}
+
 // UpdateTxVirtual updates the counters for a transmitted IP packet
 // The source and destination of the packet directly correspond with
 // the source and destination in netlogtype.Connection.
 func (s *Statistics) UpdateTxVirtual(b []byte) {
+	if s.tee != nil {
+		for _, s := range s.tee {
+			s.updateVirtual(b, false)
+		}
+		return
+	}
 	s.updateVirtual(b, false)
 }
 
@@ -90,6 +117,12 @@ func (s *Statistics) UpdateTxVirtual(b []byte) {
 // The source and destination of the packet are inverted with respect to
 // the source and destination in netlogtype.Connection.
 func (s *Statistics) UpdateRxVirtual(b []byte) {
+	if s.tee != nil {
+		for _, s := range s.tee {
+			s.updateVirtual(b, true)
+		}
+		return
+	}
 	s.updateVirtual(b, true)
 }
 
@@ -136,6 +169,12 @@ func (s *Statistics) updateVirtual(b []byte, receive bool) {
 // The dst is a remote IP address and port that corresponds
 // with some physical peer backing the Tailscale IP address.
 func (s *Statistics) UpdateTxPhysical(src netip.Addr, dst netip.AddrPort, n int) {
+	if s.tee != nil {
+		for _, s := range s.tee {
+			s.updatePhysical(src, dst, n, false)
+		}
+		return
+	}
 	s.updatePhysical(src, dst, n, false)
 }
 
@@ -144,6 +183,12 @@ func (s *Statistics) UpdateTxPhysical(src netip.Addr, dst netip.AddrPort, n int)
 // The dst is a remote IP address and port that corresponds
 // with some physical peer backing the Tailscale IP address.
 func (s *Statistics) UpdateRxPhysical(src netip.Addr, dst netip.AddrPort, n int) {
+	if s.tee != nil {
+		for _, s := range s.tee {
+			s.updatePhysical(src, dst, n, true)
+		}
+		return
+	}
 	s.updatePhysical(src, dst, n, true)
 }
 
@@ -217,6 +262,9 @@ func (s *Statistics) TestExtract() (virtual, physical map[netlogtype.Connection]
 // Statistics for any subsequent calls to Update will be dropped.
 // It is safe to call Shutdown concurrently and repeatedly.
 func (s *Statistics) Shutdown(context.Context) error {
+	if s.tee != nil {
+		return nil
+	}
 	s.shutdown()
 	return s.group.Wait()
 }
@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package connstats
+
+import (
+	"hash/maphash"
+	"math"
+	"net/netip"
+	"sort"
+	"time"
+
+	"tailscale.com/types/netlogtype"
+)
+
+// DefaultPeriod is the SubscribeOptions.Period used if none is set.
+const DefaultPeriod = 10 * time.Second
+
+// SubscribeOptions are the options of a subscription to connection
+// statistics, as made with NewSubscriber.
+type SubscribeOptions struct {
+	// Period is how often the subscriber is sent the counts accumulated
+	// since the previous Snapshot. If zero, DefaultPeriod is used.
+	Period time.Duration `json:",omitempty"`
+
+	// MaxConns, if non-zero, is the maximum number of connections counted
+	// in a Snapshot. Reaching it sends a Snapshot before the end of the
+	// Period.
+	MaxConns int `json:",omitempty"`
+
+	// SampleRate, if in the range (0, 1), is the fraction of connections
+	// whose counts are reported. Connections are sampled, not packets: a
+	// sampled connection's counts are complete, and the same connections
+	// are sampled in every Snapshot of the process. Otherwise, all
+	// connections are reported.
+	SampleRate float64 `json:",omitempty"`
+
+	// Physical is whether to report the counts of the WireGuard packets
+	// exchanged with each peer endpoint, as well as those of the
+	// connections between Tailscale IPs.
+	Physical bool `json:",omitempty"`
+}
+
+// Snapshot is the counts of the connections seen during a period, as sent
+// to subscribers.
+type Snapshot struct {
+	Start time.Time // inclusive
+	End   time.Time // inclusive
+
+	// Virtual are the counts of the connections with Tailscale IPs, and
+	// with the subnets and exit nodes routed through them.
+	Virtual []netlogtype.ConnectionCounts `json:",omitempty"`
+
+	// Physical are the counts of the WireGuard packets exchanged with
+	// each peer, by the peer's Tailscale IP (as Src) and the endpoint
+	// they were exchanged with (as Dst). It's only set if the
+	// subscription asked for it.
+	Physical []netlogtype.ConnectionCounts `json:",omitempty"`
+}
+
+// NewSubscriber returns Statistics that call fn with a Snapshot of the
+// counts of its connections, per opts. Only periods with traffic are
+// reported. fn is called from a single goroutine, which it shouldn't block
+// for long.
+//
+// Shutdown must be called to cleanup resources, which also sends the last
+// Snapshot.
+func NewSubscriber(opts SubscribeOptions, fn func(Snapshot)) *Statistics {
+	period := opts.Period
+	if period <= 0 {
+		period = DefaultPeriod
+	}
+	return NewStatistics(period, opts.MaxConns, func(start, end time.Time, virtual, physical map[netlogtype.Connection]netlogtype.Counts) {
+		snap := Snapshot{
+			Start:   start,
+			End:     end,
+			Virtual: sampleCounts(virtual, opts.SampleRate),
+		}
+		if opts.Physical {
+			snap.Physical = sampleCounts(physical, opts.SampleRate)
+		}
+		if len(snap.Virtual)+len(snap.Physical) > 0 {
+			fn(snap)
+		}
+	})
+}
+
+// sampleSeed is the seed of the hashes that pick the sampled connections,
+// fixed so that the same connections are picked for the life of the
+// process.
+var sampleSeed = maphash.MakeSeed()
+
+// sampled reports whether c's counts are reported at the sample rate.
+func sampled(c netlogtype.Connection, rate float64) bool {
+	if rate <= 0 || rate >= 1 {
+		return true
+	}
+	var h maphash.Hash
+	h.SetSeed(sampleSeed)
+	h.WriteByte(byte(c.Proto))
+	for _, ap := range []netip.AddrPort{c.Src, c.Dst} {
+		b, _ := ap.MarshalBinary()
+		h.Write(b)
+	}
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// sampleCounts returns the counts of m's sampled connections, sorted by
+// connection.
+func sampleCounts(m map[netlogtype.Connection]netlogtype.Counts, rate float64) []netlogtype.ConnectionCounts {
+	var ret []netlogtype.ConnectionCounts
+	for c, cnts := range m {
+		if sampled(c, rate) {
+			ret = append(ret, netlogtype.ConnectionCounts{Connection: c, Counts: cnts})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].Connection, ret[j].Connection
+		if a.Proto != b.Proto {
+			return a.Proto < b.Proto
+		}
+		if a.Src != b.Src {
+			return addrPortLess(a.Src, b.Src)
+		}
+		return addrPortLess(a.Dst, b.Dst)
+	})
+	return ret
+}
+
+func addrPortLess(a, b netip.AddrPort) bool {
+	if a.Addr() != b.Addr() {
+		return a.Addr().Less(b.Addr())
+	}
+	return a.Port() < b.Port()
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package connstats
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+func TestTee(t *testing.T) {
+	if Tee() != nil || Tee(nil, nil) != nil {
+		t.Error("Tee of no Statistics isn't nil")
+	}
+	s1 := NewStatistics(0, 0, nil)
+	defer s1.Shutdown(context.Background())
+	if Tee(nil, s1) != s1 {
+		t.Error("Tee of one Statistics isn't that Statistics")
+	}
+
+	s2 := NewStatistics(0, 0, nil)
+	defer s2.Shutdown(context.Background())
+	tee := Tee(s1, s2)
+	p := testPacketV4(ipproto.TCP, [4]byte{100, 64, 0, 1}, [4]byte{100, 64, 0, 2}, 123, 456, 100)
+	tee.UpdateTxVirtual(p)
+	tee.UpdateRxPhysical(netip.MustParseAddr("100.64.0.2"), netip.MustParseAddrPort("1.2.3.4:41641"), 200)
+	if err := tee.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []*Statistics{s1, s2} {
+		virtual, physical := s.TestExtract()
+		if len(virtual) != 1 || len(physical) != 1 {
+			t.Errorf("Statistics %d: got %d virtual and %d physical connections; want 1 and 1", i, len(virtual), len(physical))
+		}
+	}
+}
+
+func TestSubscriber(t *testing.T) {
+	snaps := make(chan Snapshot, 1)
+	s := NewSubscriber(SubscribeOptions{}, func(snap Snapshot) { snaps <- snap })
+	for i := 0; i < 3; i++ {
+		s.UpdateTxVirtual(testPacketV4(ipproto.UDP, [4]byte{100, 64, 0, 1}, [4]byte{100, 64, 0, 2}, 1000+uint16(i), 53, 100))
+	}
+	s.UpdateTxPhysical(netip.MustParseAddr("100.64.0.2"), netip.MustParseAddrPort("1.2.3.4:41641"), 200)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	snap := <-snaps
+	if len(snap.Virtual) != 3 || snap.Physical != nil {
+		t.Fatalf("got %d virtual and %d physical counts; want 3 and none", len(snap.Virtual), len(snap.Physical))
+	}
+	for i, cc := range snap.Virtual {
+		if got, want := cc.Src.Port(), 1000+uint16(i); got != want {
+			t.Errorf("Virtual[%d].Src port = %d; want %d, in order", i, got, want)
+		}
+		if cc.Counts != (netlogtype.Counts{TxPackets: 1, TxBytes: 100}) {
+			t.Errorf("Virtual[%d].Counts = %+v", i, cc.Counts)
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	const n = 10000
+	var kept int
+	for i := 0; i < n; i++ {
+		c := netlogtype.Connection{
+			Proto: ipproto.TCP,
+			Src:   netip.AddrPortFrom(netip.MustParseAddr("100.64.0.1"), uint16(i)),
+			Dst:   netip.MustParseAddrPort("100.64.0.2:443"),
+		}
+		if sampled(c, 0.25) {
+			kept++
+		}
+		if sampled(c, 0.25) != sampled(c, 0.25) {
+			t.Fatalf("sampling of %v isn't consistent", c)
+		}
+		if !sampled(c, 0) || !sampled(c, 1) {
+			t.Fatalf("%v not sampled without a sample rate", c)
+		}
+	}
+	if kept < n/5 || kept > n*3/10 {
+		t.Errorf("sampled %d of %d connections at rate 0.25", kept, n)
+	}
+}
@@ -288,7 +288,7 @@ func (m *directManager) setWant(want []byte) {
 	m.wantResolvConf = want
 }
 
-var warnTrample = health.NewWarnable()
+var warnTrample = health.NewWarnable(health.WithCode("dns-resolv-conf-overwritten"), health.WithSubsystem(health.SysDNS), health.WithDocURL("https://tailscale.com/s/dns-fight"))
 
 // checkForFileTrample checks whether /etc/resolv.conf has been trampled
 // by another program on the system. (e.g. a DHCP client)
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package publicdns
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sync"
+)
+
+// Provider is a DNS provider that isn't built in, such as a corporate DoH
+// gateway, whose IPs are upgraded to DNS-over-HTTPS like the built-in
+// providers' are.
+type Provider struct {
+	// DoH is the provider's DoH base URL, like
+	// "https://doh.example.com/dns-query".
+	DoH string
+
+	// IPs are the provider's DNS server IPs, which are also dialed to
+	// reach DoH. The DoH URL's host is not resolved.
+	IPs []netip.Addr
+
+	// DoHOnly is whether the provider doesn't speak DNS on port 53 at
+	// its IPs, so only DoH is used.
+	DoHOnly bool `json:",omitempty"`
+}
+
+// Check reports whether p is valid.
+func (p *Provider) Check() error {
+	u, err := url.Parse(p.DoH)
+	if err != nil {
+		return fmt.Errorf("DoH provider: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("DoH provider URL %q is not an https URL", p.DoH)
+	}
+	if len(p.IPs) == 0 {
+		return fmt.Errorf("DoH provider %q has no IPs", p.DoH)
+	}
+	for _, ip := range p.IPs {
+		if !ip.IsValid() || ip.IsUnspecified() {
+			return fmt.Errorf("DoH provider %q has invalid IP %v", p.DoH, ip)
+		}
+	}
+	return nil
+}
+
+var (
+	extraMu sync.Mutex
+	// extraOf maps from the IPs of providers added by SetExtraProviders
+	// to their provider.
+	extraOf map[netip.Addr]*Provider // guarded by extraMu
+	// extraOfBase maps from the DoH base URL of providers added by
+	// SetExtraProviders to their provider.
+	extraOfBase map[string]*Provider // guarded by extraMu
+)
+
+// SetExtraProviders replaces the providers known in addition to the built-in
+// ones with ps. Built-in providers take precedence over any of ps with the
+// same IPs or DoH base URL. If ps is invalid, the providers are left
+// unchanged.
+//
+// DoH clients already made for a provider keep dialing the IPs it had at
+// the time.
+func SetExtraProviders(ps []Provider) error {
+	of, ofBase, err := indexExtraProviders(ps)
+	if err != nil {
+		return err
+	}
+	populateOnce.Do(populate)
+	extraMu.Lock()
+	defer extraMu.Unlock()
+	extraOf, extraOfBase = of, ofBase
+	return nil
+}
+
+// CheckExtraProviders reports whether SetExtraProviders would accept ps.
+func CheckExtraProviders(ps []Provider) error {
+	_, _, err := indexExtraProviders(ps)
+	return err
+}
+
+// indexExtraProviders validates ps and returns the values of extraOf and
+// extraOfBase for them.
+func indexExtraProviders(ps []Provider) (of map[netip.Addr]*Provider, ofBase map[string]*Provider, err error) {
+	of = map[netip.Addr]*Provider{}
+	ofBase = map[string]*Provider{}
+	for _, p := range ps {
+		p := &Provider{DoH: p.DoH, IPs: slices.Clone(p.IPs), DoHOnly: p.DoHOnly}
+		if err := p.Check(); err != nil {
+			return nil, nil, err
+		}
+		if _, ok := ofBase[p.DoH]; ok {
+			return nil, nil, fmt.Errorf("duplicate DoH provider %q", p.DoH)
+		}
+		ofBase[p.DoH] = p
+		for _, ip := range p.IPs {
+			if _, ok := of[ip]; ok {
+				return nil, nil, fmt.Errorf("IP %v is in more than one DoH provider", ip)
+			}
+			of[ip] = p
+		}
+	}
+	if len(ps) == 0 {
+		return nil, nil, nil
+	}
+	return of, ofBase, nil
+}
+
+func extraOfIP(ip netip.Addr) (*Provider, bool) {
+	extraMu.Lock()
+	defer extraMu.Unlock()
+	p, ok := extraOf[ip]
+	return p, ok
+}
+
+func extraIPsOfBase(base string) []netip.Addr {
+	extraMu.Lock()
+	defer extraMu.Unlock()
+	if p, ok := extraOfBase[base]; ok {
+		return p.IPs
+	}
+	return nil
+}
+
+func extraIsDoHOnly(ip netip.Addr) bool {
+	p, ok := extraOfIP(ip)
+	return ok && p.DoHOnly
+}
@@ -47,6 +47,9 @@ func DoHEndpointFromIP(ip netip.Addr) (dohBase string, dohOnly bool, ok bool) {
 		}
 		return sb.String(), true, true
 	}
+	if p, ok := extraOfIP(ip); ok {
+		return p.DoH, p.DoHOnly, true
+	}
 	return "", false, false
 }
 
@@ -80,6 +83,9 @@ func DoHIPsOfBase(dohBase string) []netip.Addr {
 	if s := dohIPsOfBase[dohBase]; len(s) > 0 {
 		return s
 	}
+	if s := extraIPsOfBase(dohBase); len(s) > 0 {
+		return s
+	}
 	if hexStr, ok := strings.CutPrefix(dohBase, "https://dns.nextdns.io/"); ok {
 		// The path is of the form /<profile-hex>[/<hostname>/<model>/<device id>...]
 		// or /<profile-hex>?<query params>
@@ -113,7 +119,11 @@ func DoHIPsOfBase(dohBase string) []netip.Addr {
 // if found, along with a boolean indicating success.
 func DoHV6(base string) (ip netip.Addr, ok bool) {
 	populateOnce.Do(populate)
-	for _, ip := range dohIPsOfBase[base] {
+	ips := dohIPsOfBase[base]
+	if len(ips) == 0 {
+		ips = extraIPsOfBase(base)
+	}
+	for _, ip := range ips {
 		if ip.Is6() {
 			return ip, true
 		}
@@ -238,5 +248,6 @@ func nextDNSv6Gen(ip netip.Addr, id []byte) netip.Addr {
 func IPIsDoHOnlyServer(ip netip.Addr) bool {
 	return nextDNSv6RangeA.Contains(ip) || nextDNSv6RangeB.Contains(ip) ||
 		nextDNSv4RangeA.Contains(ip) || nextDNSv4RangeB.Contains(ip) ||
-		ip == wikimediaDNSv4Addr || ip == wikimediaDNSv6Addr
+		ip == wikimediaDNSv4Addr || ip == wikimediaDNSv6Addr ||
+		extraIsDoHOnly(ip)
 }
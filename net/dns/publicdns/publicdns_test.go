@@ -124,3 +124,55 @@ func TestDoHIPsOfBase(t *testing.T) {
 		}
 	}
 }
+
+func TestSetExtraProviders(t *testing.T) {
+	defer SetExtraProviders(nil)
+
+	const base = "https://doh.corp.example/dns-query"
+	ip4 := netip.MustParseAddr("10.1.2.3")
+	ip6 := netip.MustParseAddr("fd00::53")
+	if err := SetExtraProviders([]Provider{{DoH: base, IPs: []netip.Addr{ip4, ip6}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, only, ok := DoHEndpointFromIP(ip4); !ok || only || got != base {
+		t.Errorf("DoHEndpointFromIP(%v) = %q, %v, %v; want %q, false, true", ip4, got, only, ok, base)
+	}
+	if got := DoHIPsOfBase(base); !reflect.DeepEqual(got, []netip.Addr{ip4, ip6}) {
+		t.Errorf("DoHIPsOfBase = %v", got)
+	}
+	if got, ok := DoHV6(base); !ok || got != ip6 {
+		t.Errorf("DoHV6 = %v, %v; want %v, true", got, ok, ip6)
+	}
+	if IPIsDoHOnlyServer(ip4) {
+		t.Errorf("IPIsDoHOnlyServer(%v) = true", ip4)
+	}
+
+	// Built-in providers win.
+	google := netip.MustParseAddr("8.8.8.8")
+	if err := SetExtraProviders([]Provider{{DoH: base, IPs: []netip.Addr{ip4, google}, DoHOnly: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, _ := DoHEndpointFromIP(google); got != "https://dns.google/dns-query" {
+		t.Errorf("DoHEndpointFromIP(%v) = %q; want built-in", google, got)
+	}
+	if !IPIsDoHOnlyServer(ip4) {
+		t.Errorf("IPIsDoHOnlyServer(%v) = false", ip4)
+	}
+
+	for _, ps := range [][]Provider{
+		{{DoH: "http://doh.corp.example/dns-query", IPs: []netip.Addr{ip4}}},
+		{{DoH: base}},
+		{{DoH: base, IPs: []netip.Addr{{}}}},
+		{{DoH: base, IPs: []netip.Addr{ip4}}, {DoH: base, IPs: []netip.Addr{ip6}}},
+		{{DoH: base, IPs: []netip.Addr{ip4}}, {DoH: base + "2", IPs: []netip.Addr{ip4}}},
+	} {
+		if err := SetExtraProviders(ps); err == nil {
+			t.Errorf("SetExtraProviders(%v) succeeded; want error", ps)
+		}
+	}
+
+	SetExtraProviders(nil)
+	if _, _, ok := DoHEndpointFromIP(ip4); ok {
+		t.Errorf("DoHEndpointFromIP(%v) still known after reset", ip4)
+	}
+}
@@ -6,6 +6,8 @@
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -185,7 +187,11 @@ type forwarder struct {
 
 	mu sync.Mutex // guards following
 
-	dohClient map[string]*http.Client // urlBase -> client
+	dohClient map[string]*http.Client // urlBase (or resolverKey for custom DoH) -> client
+
+	// testRootCAs, if non-nil, are the roots trusted for custom DoH and DoT
+	// resolvers instead of the system roots. It's only set by tests.
+	testRootCAs *x509.CertPool
 
 	// routes are per-suffix resolvers to use, with
 	// the most specific routes first.
@@ -200,6 +206,9 @@ type forwarder struct {
 	// /etc/resolv.conf is missing/corrupt, and the peerapi ExitDNS stub
 	// resolver lookup.
 	cloudHostFallback []resolverAndDelay
+
+	statsMu sync.Mutex
+	stats   map[string]*upstreamStats // by resolver address; see noteUpstreamResult
 }
 
 func init() {
@@ -380,13 +389,7 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 	if err != nil {
 		return nil, false
 	}
-	nsDialer := netns.NewDialer(f.logf, f.netMon)
-	dialer := dnscache.Dialer(nsDialer.DialContext, &dnscache.Resolver{
-		SingleHost:             dohURL.Hostname(),
-		SingleHostStaticResult: allIPs,
-		Logf:                   f.logf,
-		NetMon:                 f.netMon,
-	})
+	dialer := f.staticDialer(dohURL.Hostname(), allIPs)
 	c = &http.Client{
 		Transport: &http.Transport{
 			ForceAttemptHTTP2: true,
@@ -406,6 +409,95 @@ func (f *forwarder) getKnownDoHClientForProvider(urlBase string) (c *http.Client
 	return c, true
 }
 
+// getDoHClient returns an HTTP client for the DoH resolver r.
+//
+// Well-known providers are dialed at the IPs known by the publicdns
+// package. Other providers are dialed at r's BootstrapResolution, or at the
+// URL's host if it's an IP address.
+func (f *forwarder) getDoHClient(r *dnstype.Resolver) (*http.Client, error) {
+	if c, ok := f.getKnownDoHClientForProvider(r.Addr); ok {
+		return c, nil
+	}
+	key := resolverKey(r)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.dohClient[key]; ok {
+		return c, nil
+	}
+	dohURL, err := url.Parse(r.Addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := bootstrapAddrs(dohURL.Hostname(), r.BootstrapResolution)
+	if err != nil {
+		return nil, err
+	}
+	dialer := f.staticDialer(dohURL.Hostname(), ips)
+	c := &http.Client{
+		Transport: &http.Transport{
+			ForceAttemptHTTP2: true,
+			IdleConnTimeout:   dohTransportTimeout,
+			TLSClientConfig:   f.tlsConfig(dohURL.Hostname()),
+			DialContext: func(ctx context.Context, netw, addr string) (net.Conn, error) {
+				if !strings.HasPrefix(netw, "tcp") {
+					return nil, fmt.Errorf("unexpected network %q", netw)
+				}
+				return dialer(ctx, netw, addr)
+			},
+		},
+	}
+	if f.dohClient == nil {
+		f.dohClient = map[string]*http.Client{}
+	}
+	f.dohClient[key] = c
+	return c, nil
+}
+
+// resolverKey returns a string identifying the address and bootstrap
+// resolution of r.
+func resolverKey(r *dnstype.Resolver) string {
+	if len(r.BootstrapResolution) == 0 {
+		return r.Addr
+	}
+	return fmt.Sprintf("%s %v", r.Addr, r.BootstrapResolution)
+}
+
+// bootstrapAddrs returns the IP addresses to dial to reach the DoH or DoT
+// server host: host itself if it's an IP address, otherwise bootstrap.
+//
+// There's deliberately no fallback to resolving host with the system
+// resolver: that may well be us, and would leak the name in the clear.
+func bootstrapAddrs(host string, bootstrap []netip.Addr) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	if len(bootstrap) == 0 {
+		return nil, fmt.Errorf("no bootstrap addresses for resolver host %q", host)
+	}
+	return bootstrap, nil
+}
+
+// staticDialer returns a dialer that race dials ips for host.
+func (f *forwarder) staticDialer(host string, ips []netip.Addr) dnscache.DialContextFunc {
+	nsDialer := netns.NewDialer(f.logf, f.netMon)
+	return dnscache.Dialer(nsDialer.DialContext, &dnscache.Resolver{
+		SingleHost:             host,
+		SingleHostStaticResult: ips,
+		Logf:                   f.logf,
+		NetMon:                 f.netMon,
+	})
+}
+
+// tlsConfig returns the TLS config for connecting to the custom DoH or DoT
+// server host.
+func (f *forwarder) tlsConfig(host string) *tls.Config {
+	return &tls.Config{
+		ServerName: host,
+		RootCAs:    f.testRootCAs,
+	}
+}
+
 const dohType = "application/dns-message"
 
 func (f *forwarder) sendDoH(ctx context.Context, urlBase string, c *http.Client, packet []byte) ([]byte, error) {
@@ -459,22 +551,15 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 		return f.sendDoH(ctx, rr.name.Addr, f.dialer.PeerAPIHTTPClient(), fq.packet)
 	}
 	if strings.HasPrefix(rr.name.Addr, "https://") {
-		// Only known DoH providers are supported currently. Specifically, we
-		// only support DoH providers where we can TCP connect to them on port
-		// 443 at the same IP address they serve normal UDP DNS from (1.1.1.1,
-		// 8.8.8.8, 9.9.9.9, etc.) That's why OpenDNS and custom DoH providers
-		// aren't currently supported. There's no backup DNS resolution path for
-		// them.
-		urlBase := rr.name.Addr
-		if hc, ok := f.getKnownDoHClientForProvider(urlBase); ok {
-			return f.sendDoH(ctx, urlBase, hc, fq.packet)
+		hc, err := f.getDoHClient(rr.name)
+		if err != nil {
+			metricDNSFwdErrorType.Add(1)
+			return nil, err
 		}
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("arbitrary https:// resolvers not supported yet")
+		return f.sendDoH(ctx, rr.name.Addr, hc, fq.packet)
 	}
 	if strings.HasPrefix(rr.name.Addr, "tls://") {
-		metricDNSFwdErrorType.Add(1)
-		return nil, fmt.Errorf("tls:// resolvers not supported yet")
+		return f.sendDoT(ctx, fq, rr)
 	}
 
 	return f.sendUDP(ctx, fq, rr)
@@ -482,6 +567,91 @@ func (f *forwarder) send(ctx context.Context, fq *forwardQuery, rr resolverAndDe
 
 var errServerFailure = errors.New("response code indicates server issue")
 
+// parseDoTAddr parses a DoT resolver address of the form
+// "tls://host[:port]" into its host and port, defaulting to port 853.
+func parseDoTAddr(addr string) (host, port string, err error) {
+	hp, ok := strings.CutPrefix(addr, "tls://")
+	hp = strings.TrimSuffix(hp, "/")
+	if !ok || hp == "" {
+		return "", "", fmt.Errorf("invalid DNS-over-TLS resolver %q", addr)
+	}
+	if ip, err := netip.ParseAddr(hp); err == nil {
+		return ip.String(), "853", nil
+	}
+	if host, port, err := net.SplitHostPort(hp); err == nil {
+		return host, port, nil
+	}
+	return hp, "853", nil
+}
+
+// sendDoT sends fq to the DNS-over-TLS (RFC 7858) resolver rr.
+//
+// TODO: reuse connections between queries rather than dialing a new
+// connection for each one.
+func (f *forwarder) sendDoT(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) ([]byte, error) {
+	host, port, err := parseDoTAddr(rr.name.Addr)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	ips, err := bootstrapAddrs(host, rr.name.BootstrapResolution)
+	if err != nil {
+		metricDNSFwdErrorType.Add(1)
+		return nil, err
+	}
+	metricDNSFwdDoT.Add(1)
+	ctx = sockstats.WithSockStats(ctx, sockstats.LabelDNSForwarderDoT, f.logf)
+
+	tc, err := f.staticDialer(host, ips)(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	conn := tls.Client(tc, f.tlsConfig(host))
+	defer conn.Close()
+
+	fq.closeOnCtxDone.Add(conn)
+	defer fq.closeOnCtxDone.Remove(conn)
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+	}
+
+	// Messages are prefixed by their two byte length (RFC 1035, 4.2.2).
+	req := make([]byte, 2+len(fq.packet))
+	binary.BigEndian.PutUint16(req, uint16(len(fq.packet)))
+	copy(req[2:], fq.packet)
+	if _, err := conn.Write(req); err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	var lenBuf [2]byte
+	if _, err := io.ReadFull(conn, lenBuf[:]); err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	out := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+	if _, err := io.ReadFull(conn, out); err != nil {
+		metricDNSFwdDoTErrorTransport.Add(1)
+		return nil, err
+	}
+	if len(out) < headerBytes {
+		metricDNSFwdDoTErrorResponse.Add(1)
+		return nil, fmt.Errorf("response too small (%d bytes)", len(out))
+	}
+	if getTxID(out) != fq.txid {
+		metricDNSFwdDoTErrorResponse.Add(1)
+		return nil, errors.New("txid doesn't match")
+	}
+	if getRCode(out) == dns.RCodeServerFailure {
+		metricDNSFwdDoTErrorResponse.Add(1)
+		return nil, errServerFailure
+	}
+	if truncatedFlagSet(out) {
+		metricDNSFwdTruncated.Add(1)
+	}
+	return out, nil
+}
+
 func (f *forwarder) sendUDP(ctx context.Context, fq *forwardQuery, rr resolverAndDelay) (ret []byte, err error) {
 	ipp, ok := rr.name.IPPort()
 	if !ok {
@@ -690,7 +860,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 	}
 	defer fq.closeOnCtxDone.Close()
 
-	resc := make(chan []byte, 1) // it's fine buffered or not
+	resc := make(chan packet, 1) // it's fine buffered or not
 	errc := make(chan error, 1)  // it's fine buffered or not too
 	for i := range resolvers {
 		go func(rr *resolverAndDelay) {
@@ -703,7 +873,13 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 					return
 				}
 			}
+			t0 := time.Now()
 			resb, err := f.send(ctx, fq, *rr)
+			if err == nil || ctx.Err() == nil {
+				// Otherwise another resolver answered first, or the
+				// query was abandoned.
+				f.noteUpstreamResult(rr.name.Addr, time.Since(t0), err)
+			}
 			if err != nil {
 				select {
 				case errc <- err:
@@ -712,7 +888,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 				return
 			}
 			select {
-			case resc <- resb:
+			case resc <- packet{bs: resb, addr: query.addr, upstream: rr.name.Addr}:
 			case <-ctx.Done():
 			}
 		}(&resolvers[i])
@@ -727,7 +903,7 @@ func (f *forwarder) forwardWithDestChan(ctx context.Context, query packet, respo
 			case <-ctx.Done():
 				metricDNSFwdErrorContext.Add(1)
 				return ctx.Err()
-			case responseChan <- packet{v, query.addr}:
+			case responseChan <- v:
 				metricDNSFwdSuccess.Add(1)
 				return nil
 			}
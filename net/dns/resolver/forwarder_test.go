@@ -4,8 +4,16 @@
 package resolver
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
@@ -161,6 +169,183 @@ func TestGetKnownDoHClientForProvider(t *testing.T) {
 	t.Logf("Got: %+v", res)
 }
 
+// testDNSAnswer returns a response to query answering it with ip.
+func testDNSAnswer(t *testing.T, query []byte, ip netip.Addr) []byte {
+	t.Helper()
+	var p dns.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := p.Question()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Response = true
+	b := dns.NewBuilder(nil, h)
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	b.AResource(dns.ResourceHeader{Name: q.Name, Class: dns.ClassINET, TTL: 60}, dns.AResource{A: ip.As4()})
+	res, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func testDNSQuery(t *testing.T, txid uint16) []byte {
+	t.Helper()
+	b := dns.NewBuilder(nil, dns.Header{ID: txid, RecursionDesired: true})
+	b.StartQuestions()
+	b.Question(dns.Question{
+		Name:  dns.MustNewName("foo.example."),
+		Type:  dns.TypeA,
+		Class: dns.ClassINET,
+	})
+	q, err := b.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func TestSendCustomDoH(t *testing.T) {
+	want := netip.MustParseAddr("1.2.3.4")
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := io.ReadAll(r.Body)
+		if err != nil || r.Header.Get("Content-Type") != dohType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", dohType)
+		w.Write(testDNSAnswer(t, q, want))
+	}))
+	defer srv.Close()
+	ipp := netip.MustParseAddrPort(srv.Listener.Addr().String())
+
+	fwd := newForwarder(t.Logf, nil, nil, nil)
+	defer fwd.Close()
+	fwd.testRootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	// The test server's certificate is valid for example.com, so it can be
+	// reached by name using bootstrap resolution.
+	byName := &dnstype.Resolver{
+		Addr:                fmt.Sprintf("https://example.com:%d/dns-query", ipp.Port()),
+		BootstrapResolution: []netip.Addr{ipp.Addr()},
+	}
+	byIP := &dnstype.Resolver{Addr: srv.URL + "/dns-query"}
+	noBootstrap := &dnstype.Resolver{Addr: byName.Addr}
+
+	for _, r := range []*dnstype.Resolver{byName, byIP} {
+		fq := &forwardQuery{txid: 42, packet: testDNSQuery(t, 42), closeOnCtxDone: new(closePool)}
+		res, err := fwd.send(context.Background(), fq, resolverAndDelay{name: r})
+		if err != nil {
+			t.Fatalf("send(%v): %v", r.Addr, err)
+		}
+		if getTxID(res) != 42 {
+			t.Errorf("send(%v): txid = %v; want 42", r.Addr, getTxID(res))
+		}
+	}
+	fq := &forwardQuery{txid: 42, packet: testDNSQuery(t, 42), closeOnCtxDone: new(closePool)}
+	if _, err := fwd.send(context.Background(), fq, resolverAndDelay{name: noBootstrap}); err == nil {
+		t.Errorf("send without bootstrap addresses succeeded; want error")
+	}
+}
+
+func TestSendDoT(t *testing.T) {
+	want := netip.MustParseAddr("1.2.3.4")
+	srv := httptest.NewUnstartedServer(nil)
+	srv.StartTLS() // for its certificate
+	defer srv.Close()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srv.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				var lenBuf [2]byte
+				if _, err := io.ReadFull(c, lenBuf[:]); err != nil {
+					return
+				}
+				q := make([]byte, binary.BigEndian.Uint16(lenBuf[:]))
+				if _, err := io.ReadFull(c, q); err != nil {
+					return
+				}
+				res := testDNSAnswer(t, q, want)
+				res = append(binary.BigEndian.AppendUint16(nil, uint16(len(res))), res...)
+				c.Write(res)
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	fwd := newForwarder(t.Logf, nil, nil, nil)
+	defer fwd.Close()
+	fwd.testRootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	r := &dnstype.Resolver{
+		Addr:                "tls://example.com:" + port,
+		BootstrapResolution: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+	}
+	fq := &forwardQuery{txid: 42, packet: testDNSQuery(t, 42), closeOnCtxDone: new(closePool)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := fwd.send(ctx, fq, resolverAndDelay{name: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p dns.Parser
+	if _, err := p.Start(res); err != nil {
+		t.Fatal(err)
+	}
+	p.SkipAllQuestions()
+	if _, err := p.AnswerHeader(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.AResource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := netip.AddrFrom4(a.A); got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+func TestParseDoTAddr(t *testing.T) {
+	tests := []struct {
+		addr       string
+		host, port string
+		wantErr    bool
+	}{
+		{addr: "tls://dns.example", host: "dns.example", port: "853"},
+		{addr: "tls://dns.example:8853", host: "dns.example", port: "8853"},
+		{addr: "tls://1.1.1.1", host: "1.1.1.1", port: "853"},
+		{addr: "tls://2606:4700:4700::1111", host: "2606:4700:4700::1111", port: "853"},
+		{addr: "tls://[2606:4700:4700::1111]:853", host: "2606:4700:4700::1111", port: "853"},
+		{addr: "tls://", wantErr: true},
+		{addr: "https://dns.example", wantErr: true},
+	}
+	for _, tt := range tests {
+		host, port, err := parseDoTAddr(tt.addr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDoTAddr(%q) error = %v; wantErr %v", tt.addr, err, tt.wantErr)
+			continue
+		}
+		if host != tt.host || port != tt.port {
+			t.Errorf("parseDoTAddr(%q) = %q, %q; want %q, %q", tt.addr, host, port, tt.host, tt.port)
+		}
+	}
+}
+
 func BenchmarkNameFromQuery(b *testing.B) {
 	builder := dns.NewBuilder(nil, dns.Header{})
 	builder.StartQuestions()
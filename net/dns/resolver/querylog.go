@@ -0,0 +1,201 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package resolver
+
+import (
+	"context"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/util/ringbuffer"
+)
+
+// queryLog is the Resolver's opt-in log of recent queries.
+type queryLog struct {
+	mu      sync.Mutex
+	cfg     ipnstate.DNSQueryLogConfig
+	ents    *ringbuffer.RingBuffer[ipnstate.DNSQueryLogEntry] // nil if disabled
+	seq     uint64                                            // of the last entry added
+	changed chan struct{}                                     // closed and replaced when an entry is added
+}
+
+// enabled reports whether queries should be logged.
+func (ql *queryLog) enabled() bool {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	return ql.ents != nil
+}
+
+func (ql *queryLog) setConfig(cfg ipnstate.DNSQueryLogConfig) {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if cfg.Size <= 0 {
+		cfg = ipnstate.DNSQueryLogConfig{}
+	}
+	if cfg.Size != ql.cfg.Size {
+		ql.ents = nil
+		if cfg.Size > 0 {
+			ql.ents = ringbuffer.New[ipnstate.DNSQueryLogEntry](cfg.Size)
+		}
+	}
+	ql.cfg = cfg
+}
+
+// add logs a query, sent from client and answered by source, from the
+// query and response packets; response is nil on error.
+func (ql *queryLog) add(start time.Time, client netip.AddrPort, query []byte, source, upstream string, response []byte, err error) {
+	e := ipnstate.DNSQueryLogEntry{
+		Time:     start,
+		Client:   client.String(),
+		Source:   source,
+		Upstream: upstream,
+		Latency:  time.Since(start),
+	}
+	var p dns.Parser
+	if _, perr := p.Start(query); perr == nil {
+		if q, perr := p.Question(); perr == nil {
+			e.Name = q.Name.String()
+			e.Type = q.Type.String()
+		}
+	}
+	if err != nil {
+		e.Err = err.Error()
+	}
+	if response != nil {
+		if h, perr := p.Start(response); perr == nil {
+			e.RCode = h.RCode.String()
+		}
+	}
+
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if ql.ents == nil {
+		return
+	}
+	if ql.cfg.RedactClients {
+		e.Client = ""
+	}
+	if ql.cfg.RedactNames {
+		e.Name = redactName(e.Name)
+	}
+	ql.seq++
+	e.Seq = ql.seq
+	ql.ents.Add(e)
+	if ql.changed != nil {
+		close(ql.changed)
+		ql.changed = nil
+	}
+}
+
+// redactName returns name with all but its last two labels replaced
+// by "*".
+func redactName(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	if len(labels) <= 2 {
+		return name
+	}
+	return "*." + strings.Join(labels[len(labels)-2:], ".") + "."
+}
+
+// entriesSince returns the logged entries after seq, and a channel that's
+// closed when another is added.
+func (ql *queryLog) entriesSince(seq uint64) (cfg ipnstate.DNSQueryLogConfig, ents []ipnstate.DNSQueryLogEntry, changed <-chan struct{}) {
+	ql.mu.Lock()
+	defer ql.mu.Unlock()
+	if ql.ents != nil {
+		for _, e := range ql.ents.GetAll() {
+			if e.Seq > seq {
+				ents = append(ents, e)
+			}
+		}
+	}
+	if ql.changed == nil {
+		ql.changed = make(chan struct{})
+	}
+	return ql.cfg, ents, ql.changed
+}
+
+// SetQueryLogConfig configures the Resolver's query log.
+func (r *Resolver) SetQueryLogConfig(cfg ipnstate.DNSQueryLogConfig) {
+	r.qlog.setConfig(cfg)
+}
+
+// QueryLog returns the queries logged after the one numbered since (zero
+// for all of them) and statistics on the upstream resolvers.
+//
+// If wait is true and there are no such queries yet, it waits for one
+// until ctx is done.
+func (r *Resolver) QueryLog(ctx context.Context, since uint64, wait bool) *ipnstate.DNSQueryLog {
+	cfg, ents, changed := r.qlog.entriesSince(since)
+	if wait && len(ents) == 0 {
+		select {
+		case <-changed:
+			cfg, ents, _ = r.qlog.entriesSince(since)
+		case <-ctx.Done():
+		}
+	}
+	return &ipnstate.DNSQueryLog{
+		Config:    cfg,
+		Entries:   ents,
+		Upstreams: r.forwarder.upstreamStats(),
+	}
+}
+
+// upstreamStats accumulates the statistics in an ipnstate.DNSUpstreamStats.
+type upstreamStats struct {
+	queries    int64
+	errors     int64
+	latencySum time.Duration // of successful queries
+	latencyMax time.Duration
+	lastErr    string
+}
+
+// noteUpstreamResult records the result of a query sent to the upstream
+// resolver addr, which took d.
+func (f *forwarder) noteUpstreamResult(addr string, d time.Duration, err error) {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	st := f.stats[addr]
+	if st == nil {
+		if f.stats == nil {
+			f.stats = map[string]*upstreamStats{}
+		}
+		st = new(upstreamStats)
+		f.stats[addr] = st
+	}
+	st.queries++
+	if err != nil {
+		st.errors++
+		st.lastErr = err.Error()
+		return
+	}
+	st.latencySum += d
+	st.latencyMax = max(st.latencyMax, d)
+}
+
+func (f *forwarder) upstreamStats() []ipnstate.DNSUpstreamStats {
+	f.statsMu.Lock()
+	defer f.statsMu.Unlock()
+	ret := make([]ipnstate.DNSUpstreamStats, 0, len(f.stats))
+	for addr, st := range f.stats {
+		s := ipnstate.DNSUpstreamStats{
+			Upstream:   addr,
+			Queries:    st.queries,
+			Errors:     st.errors,
+			LatencyMax: st.latencyMax,
+			LastError:  st.lastErr,
+		}
+		if ok := st.queries - st.errors; ok > 0 {
+			s.LatencyAvg = st.latencySum / time.Duration(ok)
+		}
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Upstream < ret[j].Upstream })
+	return ret
+}
@@ -35,6 +35,7 @@
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/cloudenv"
 	"tailscale.com/util/dnsname"
+	"tailscale.com/util/mak"
 )
 
 const dnsSymbolicFQDN = "magicdns.localhost-tailscale-daemon."
@@ -55,6 +56,10 @@
 type packet struct {
 	bs   []byte
 	addr netip.AddrPort // src for a request, dst for a response
+
+	// upstream, for a forwarded response, is the address of the
+	// resolver that sent it.
+	upstream string
 }
 
 // Config is a resolver configuration.
@@ -190,13 +195,21 @@ type Resolver struct {
 	// wg signals when all goroutines have stopped.
 	wg sync.WaitGroup
 
+	qlog queryLog // opt-in log of recent queries; see SetQueryLogConfig
+
 	// mu guards the following fields from being updated while used.
 	mu           sync.Mutex
 	localDomains []dnsname.FQDN
 	hostToIP     map[dnsname.FQDN][]netip.Addr
 	ipToHost     map[netip.Addr]dnsname.FQDN
+	handlers     map[dnsname.FQDN]Handler // see SetHandler
 }
 
+// A Handler answers DNS queries for the names it's registered for with
+// Resolver.SetHandler. query is the DNS request packet, and the returned
+// response is the whole DNS response packet.
+type Handler func(ctx context.Context, query []byte, from netip.AddrPort) (response []byte, err error)
+
 type ForwardLinkSelector interface {
 	// PickLink returns which network device should be used to query
 	// the DNS server at the given IP.
@@ -247,6 +260,58 @@ func (r *Resolver) SetConfig(cfg Config) error {
 	return nil
 }
 
+// SetHandler registers h to answer queries for suffix and the names under
+// it, in place of the hosts and forwarding of the resolver's Config. If
+// handlers are registered for more than one suffix of a name, the longest
+// wins. A nil h removes the handler for suffix.
+func (r *Resolver) SetHandler(suffix dnsname.FQDN, h Handler) {
+	suffix = dnsname.FQDN(strings.ToLower(string(suffix)))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h == nil {
+		delete(r.handlers, suffix)
+		return
+	}
+	mak.Set(&r.handlers, suffix, h)
+}
+
+// HandlerFor returns the Handler registered with SetHandler that answers
+// queries for name, or nil if there is none.
+func (r *Resolver) HandlerFor(name dnsname.FQDN) Handler {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var best dnsname.FQDN
+	var h Handler
+	for suffix, sh := range r.handlers {
+		if suffix.Contains(name) && len(suffix) > len(best) {
+			best, h = suffix, sh
+		}
+	}
+	return h
+}
+
+// handlerForQuery returns the Handler registered for the name in the
+// question of query, or nil if there is none or query is malformed.
+func (r *Resolver) handlerForQuery(query []byte) Handler {
+	r.mu.Lock()
+	n := len(r.handlers)
+	r.mu.Unlock()
+	if n == 0 {
+		return nil
+	}
+	p := dnsParserPool.Get().(*dnsParser)
+	defer dnsParserPool.Put(p)
+	if err := p.parseQuery(query); err != nil {
+		return nil
+	}
+	rawName := p.Question.Name.Data[:p.Question.Name.Length]
+	name, err := dnsname.ToFQDN(rawNameToLower(rawName))
+	if err != nil {
+		return nil
+	}
+	return r.HandlerFor(name)
+}
+
 // Close shuts down the resolver and ensures poll goroutines have exited.
 // The Resolver cannot be used again after Close is called.
 func (r *Resolver) Close() {
@@ -275,28 +340,49 @@ func (r *Resolver) Query(ctx context.Context, bs []byte, from netip.AddrPort) ([
 	default:
 	}
 
+	if !r.qlog.enabled() {
+		res, _, _, err := r.query(ctx, bs, from)
+		return res, err
+	}
+	start := time.Now()
+	res, source, upstream, err := r.query(ctx, bs, from)
+	r.qlog.add(start, from, bs, source, upstream, res, err)
+	return res, err
+}
+
+// query answers the query bs from Query, returning how it was answered
+// (see ipnstate.DNSQueryLogEntry.Source) and, if forwarded, the upstream
+// resolver that answered it.
+func (r *Resolver) query(ctx context.Context, bs []byte, from netip.AddrPort) (res []byte, source, upstream string, err error) {
+	if h := r.handlerForQuery(bs); h != nil {
+		metricDNSQueryHandler.Add(1)
+		res, err := h(ctx, bs, from)
+		return res, "handler", "", err
+	}
+
 	out, err := r.respond(bs)
 	if err == errNotOurName {
 		responses := make(chan packet, 1)
 		ctx, cancel := context.WithTimeout(ctx, dnsQueryTimeout)
 		defer close(responses)
 		defer cancel()
-		err = r.forwarder.forwardWithDestChan(ctx, packet{bs, from}, responses)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: bs, addr: from}, responses)
 		if err != nil {
 			select {
 			// Best effort: use any error response sent by forwardWithDestChan.
 			// This is present in some errors paths, such as when all upstream
 			// DNS servers replied with an error.
 			case resp := <-responses:
-				return resp.bs, err
+				return resp.bs, "forwarded", resp.upstream, err
 			default:
-				return nil, err
+				return nil, "forwarded", "", err
 			}
 		}
-		return (<-responses).bs, nil
+		resp := <-responses
+		return resp.bs, "forwarded", resp.upstream, nil
 	}
 
-	return out, err
+	return out, "local", "", err
 }
 
 // parseExitNodeQuery parses a DNS request packet.
@@ -369,7 +455,7 @@ func (r *Resolver) HandleExitNodeDNSQuery(ctx context.Context, q []byte, from ne
 			}}
 		}
 
-		err = r.forwarder.forwardWithDestChan(ctx, packet{q, from}, ch, resolvers...)
+		err = r.forwarder.forwardWithDestChan(ctx, packet{bs: q, addr: from}, ch, resolvers...)
 		if err != nil {
 			metricDNSExitProxyErrorForward.Add(1)
 			return nil, err
@@ -1273,6 +1359,7 @@ func unARPA(a string) (ipStr string, ok bool) {
 var (
 	metricDNSQueryLocal       = clientmetric.NewCounter("dns_query_local")
 	metricDNSQueryErrorClosed = clientmetric.NewCounter("dns_query_local_error_closed")
+	metricDNSQueryHandler     = clientmetric.NewCounter("dns_query_local_handler")
 
 	metricDNSErrorParseNoQ   = clientmetric.NewCounter("dns_query_respond_error_no_question")
 	metricDNSErrorParseQuery = clientmetric.NewCounter("dns_query_respond_error_parse")
@@ -1312,6 +1399,10 @@ func unARPA(a string) (ipStr string, ok bool) {
 	metricDNSFwdDoHErrorTransport = clientmetric.NewCounter("dns_query_fwd_doh_error_transport")
 	metricDNSFwdDoHErrorBody      = clientmetric.NewCounter("dns_query_fwd_doh_error_body")
 
+	metricDNSFwdDoT               = clientmetric.NewCounter("dns_query_fwd_dot")
+	metricDNSFwdDoTErrorTransport = clientmetric.NewCounter("dns_query_fwd_dot_error_transport")
+	metricDNSFwdDoTErrorResponse  = clientmetric.NewCounter("dns_query_fwd_dot_error_response")
+
 	metricDNSResolveLocal             = clientmetric.NewCounter("dns_resolve_local")
 	metricDNSResolveLocalErrorOnion   = clientmetric.NewCounter("dns_resolve_local_error_onion")
 	metricDNSResolveLocalErrorMissing = clientmetric.NewCounter("dns_resolve_local_error_missing")
@@ -23,6 +23,7 @@
 
 	miekdns "github.com/miekg/dns"
 	dns "golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
@@ -901,6 +902,48 @@ func TestFull(t *testing.T) {
 	}
 }
 
+func TestSetHandler(t *testing.T) {
+	r := newResolver(t)
+	defer r.Close()
+	r.SetConfig(dnsCfg)
+
+	handler := func(resp []byte) Handler {
+		return func(ctx context.Context, query []byte, from netip.AddrPort) ([]byte, error) {
+			return resp, nil
+		}
+	}
+	r.SetHandler("app.test1.ipn.dev.", handler([]byte("app")))
+	r.SetHandler("DB.App.Test1.ipn.dev.", handler([]byte("db")))
+
+	tests := []struct {
+		name  string
+		qname dnsname.FQDN
+		want  []byte
+	}{
+		{"suffix", "app.test1.ipn.dev.", []byte("app")},
+		{"subdomain", "x.app.test1.ipn.dev.", []byte("app")},
+		{"longest", "x.db.app.test1.ipn.dev.", []byte("db")},
+		{"host", "test1.ipn.dev.", ipv4Response},
+		{"nxdomain", "test3.ipn.dev.", nxdomainResponse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := syncRespond(r, dnspacket(tt.qname, dns.TypeA, noEdns))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("response = %x; want %x", got, tt.want)
+			}
+		})
+	}
+
+	r.SetHandler("app.test1.ipn.dev.", nil)
+	if h := r.HandlerFor("x.app.test1.ipn.dev."); h != nil {
+		t.Error("handler still registered after removal")
+	}
+}
+
 func TestAllocs(t *testing.T) {
 	r := newResolver(t)
 	defer r.Close()
@@ -1468,3 +1511,68 @@ func TestServfail(t *testing.T) {
 		t.Errorf("response was %X, want %X", pkt, wantPkt)
 	}
 }
+
+func TestQueryLog(t *testing.T) {
+	server := serveDNS(t, "127.0.0.1:0", "test.site.", miekdns.HandlerFunc(func(w miekdns.ResponseWriter, req *miekdns.Msg) {
+		m := new(miekdns.Msg)
+		m.SetReply(req)
+		w.WriteMsg(m)
+	}))
+	defer server.Shutdown()
+	upstream := server.PacketConn.LocalAddr().String()
+
+	r := newResolver(t)
+	defer r.Close()
+	cfg := dnsCfg
+	cfg.Routes = map[dnsname.FQDN][]*dnstype.Resolver{
+		".": {{Addr: upstream}},
+	}
+	r.SetConfig(cfg)
+	from := netip.MustParseAddrPort("100.64.0.1:1234")
+	query := func(name dnsname.FQDN) {
+		t.Helper()
+		if _, err := r.Query(context.Background(), dnspacket(name, dns.TypeA, noEdns), from); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	query("test1.ipn.dev.")
+	if ql := r.QueryLog(context.Background(), 0, false); len(ql.Entries) != 0 {
+		t.Fatalf("logged %d queries while disabled", len(ql.Entries))
+	}
+
+	r.SetQueryLogConfig(ipnstate.DNSQueryLogConfig{Size: 2})
+	query("test1.ipn.dev.")
+	query("test.site.")
+	ql := r.QueryLog(context.Background(), 0, false)
+	if len(ql.Entries) != 2 {
+		t.Fatalf("got %d entries; want 2", len(ql.Entries))
+	}
+	local, fwd := ql.Entries[0], ql.Entries[1]
+	if local.Name != "test1.ipn.dev." || local.Source != "local" || local.RCode != "RCodeSuccess" || local.Client != from.String() || local.Type != "TypeA" {
+		t.Errorf("local entry = %+v", local)
+	}
+	if fwd.Name != "test.site." || fwd.Source != "forwarded" || fwd.Upstream != upstream || fwd.Seq != local.Seq+1 {
+		t.Errorf("forwarded entry = %+v", fwd)
+	}
+	if len(ql.Upstreams) != 1 || ql.Upstreams[0].Upstream != upstream || ql.Upstreams[0].Queries != 1 || ql.Upstreams[0].Errors != 0 {
+		t.Errorf("upstreams = %+v", ql.Upstreams)
+	}
+
+	// Only entries after since are returned, waiting for one if asked.
+	if got := r.QueryLog(context.Background(), fwd.Seq, false).Entries; len(got) != 0 {
+		t.Errorf("entries since last = %+v; want none", got)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := r.QueryLog(ctx, fwd.Seq, true).Entries; len(got) != 0 {
+		t.Errorf("waited entries = %+v; want none", got)
+	}
+
+	r.SetQueryLogConfig(ipnstate.DNSQueryLogConfig{Size: 2, RedactNames: true, RedactClients: true})
+	query("test1.ipn.dev.")
+	ents := r.QueryLog(context.Background(), fwd.Seq, true).Entries
+	if len(ents) != 1 || ents[0].Name != "*.ipn.dev." || ents[0].Client != "" {
+		t.Errorf("redacted entries = %+v", ents)
+	}
+}
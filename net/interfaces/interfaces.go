@@ -527,6 +527,18 @@ func HTTPOfListener(ln net.Listener) string {
 
 var likelyHomeRouterIP func() (netip.Addr, bool)
 
+var wifiSSID func() string
+
+// WiFiSSID returns the SSID of the Wi-Fi network this machine is connected
+// to, or the empty string if it isn't on Wi-Fi or the SSID can't be
+// determined on this platform.
+func WiFiSSID() string {
+	if wifiSSID == nil {
+		return ""
+	}
+	return wifiSSID()
+}
+
 // LikelyHomeRouterIP returns the likely IP of the residential router,
 // which will always be an IPv4 private address, if found.
 // In addition, it returns the IP address of the current machine on
@@ -28,6 +28,7 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPLinux
+	wifiSSID = wifiSSIDLinux
 }
 
 var procNetRouteErr atomic.Bool
@@ -323,3 +324,25 @@ func defaultRouteInterfaceAndroidIPRoute() (ifname string, err error) {
 	}
 	return ifname, nil
 }
+
+// wifiSSIDLinux returns the current Wi-Fi network's SSID using iwgetid
+// (from wireless-tools) or, failing that, NetworkManager's nmcli.
+func wifiSSIDLinux() string {
+	if runtime.GOOS == "android" {
+		return ""
+	}
+	if out, err := exec.Command("iwgetid", "-r").Output(); err == nil {
+		return strings.TrimSpace(string(out))
+	}
+	out, err := exec.Command("nmcli", "-t", "-f", "active,ssid", "dev", "wifi").Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if ssid, ok := strings.CutPrefix(line, "yes:"); ok {
+			// In terse mode, nmcli escapes colons in values.
+			return strings.ReplaceAll(ssid, `\:`, ":")
+		}
+	}
+	return ""
+}
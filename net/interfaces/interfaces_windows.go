@@ -7,6 +7,7 @@
 	"log"
 	"net/netip"
 	"net/url"
+	"os/exec"
 	"strings"
 	"syscall"
 	"unsafe"
@@ -22,6 +23,7 @@
 
 func init() {
 	likelyHomeRouterIP = likelyHomeRouterIPWindows
+	wifiSSID = wifiSSIDWindows
 	getPAC = getPACWindows
 }
 
@@ -276,3 +278,21 @@ func getPACWindows() string {
 	log.Printf("getPACWindows: %T=%v", e, e) // syscall.Errno=0x....
 	return ""
 }
+
+// wifiSSIDWindows returns the current Wi-Fi network's SSID from the output
+// of "netsh wlan show interfaces", which has lines like:
+//
+//	SSID                   : CoffeeShop
+func wifiSSIDWindows() string {
+	out, err := exec.Command("netsh", "wlan", "show", "interfaces").Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(k) == "SSID" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/tailcfg"
+)
+
+// Defaults for BandwidthProbe.
+const (
+	DefaultBandwidthProbeSize     = 4 << 20
+	DefaultBandwidthProbeDuration = 5 * time.Second
+)
+
+// BandwidthProbe configures a DERP download bandwidth measurement.
+type BandwidthProbe struct {
+	// Size is the maximum number of bytes to download.
+	// If zero, DefaultBandwidthProbeSize is used.
+	Size int64
+
+	// Duration is the maximum amount of time to spend downloading.
+	// If zero, DefaultBandwidthProbeDuration is used.
+	Duration time.Duration
+}
+
+func (p BandwidthProbe) size() int64 {
+	if p.Size > 0 {
+		return p.Size
+	}
+	return DefaultBandwidthProbeSize
+}
+
+func (p BandwidthProbe) duration() time.Duration {
+	if p.Duration > 0 {
+		return p.Duration
+	}
+	return DefaultBandwidthProbeDuration
+}
+
+// MeasureDERPBandwidth estimates the available download bandwidth from the
+// DERP region reg by fetching up to probe.Size bytes from its
+// /derp/bandwidth-check endpoint for up to probe.Duration. It returns the
+// observed throughput in bytes per second. DERP servers only serve the
+// endpoint if run with --bandwidth-check-max.
+//
+// It lets users tell "the relay is slow" apart from "my uplink is slow":
+// a DERP connection that's much slower than this probe points at the relay
+// path rather than the local network.
+func (c *Client) MeasureDERPBandwidth(ctx context.Context, reg *tailcfg.DERPRegion, probe BandwidthProbe) (bytesPerSec int64, err error) {
+	if reg == nil {
+		return 0, errors.New("no DERP region")
+	}
+	metricBandwidthProbe.Add(1)
+	defer func() {
+		if err != nil {
+			metricBandwidthProbeError.Add(1)
+		}
+	}()
+
+	// Allow some slack on top of the download itself for the dial and
+	// TLS handshake.
+	ctx, cancel := context.WithTimeout(ctx, probe.duration()+overallProbeTimeout)
+	defer cancel()
+
+	dc := derphttp.NewNetcheckClient(c.logf)
+	defer dc.Close()
+
+	tlsConn, tcpConn, node, err := dc.DialRegionTLS(ctx, reg)
+	if err != nil {
+		return 0, err
+	}
+	defer tcpConn.Close()
+
+	hc := &http.Client{Transport: singleConnTransport(tlsConn)}
+	url := fmt.Sprintf("https://%s/derp/bandwidth-check?size=%d", node.HostName, probe.size())
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected status code: %d (%s)", res.StatusCode, res.Status)
+	}
+
+	// Time only the body transfer, not the connection setup or the
+	// server's time to first byte.
+	start := c.timeNow()
+	deadline := time.AfterFunc(probe.duration(), func() { tcpConn.Close() })
+	defer deadline.Stop()
+	n, err := io.Copy(io.Discard, io.LimitReader(res.Body, probe.size()))
+	elapsed := c.timeNow().Sub(start)
+	if err != nil && n == 0 {
+		return 0, err
+	}
+	if elapsed <= 0 || n == 0 {
+		return 0, errors.New("no data received")
+	}
+	bytesPerSec = int64(float64(n) / elapsed.Seconds())
+	c.vlogf("bandwidth probe of %v: %d bytes in %v (%d B/s)", reg.RegionCode, n, elapsed.Round(time.Millisecond), bytesPerSec)
+	return bytesPerSec, nil
+}
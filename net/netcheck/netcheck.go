@@ -66,6 +66,10 @@
 	// hairpinCheckTimeout is the amount of time we wait for a
 	// hairpinned packet to come back.
 	hairpinCheckTimeout = 100 * time.Millisecond
+	// natBehaviorTestTimeout is the amount of time we wait for the
+	// response to each RFC 5780 NAT behavior discovery test before
+	// concluding that the NAT filtered it or the server didn't answer.
+	natBehaviorTestTimeout = 300 * time.Millisecond
 	// defaultActiveRetransmitTime is the retransmit interval we use
 	// for STUN probes when we're in steady state (not in start-up),
 	// but don't have previous latency information for a DERP
@@ -100,6 +104,15 @@ type Report struct {
 	// (on IPv4).
 	HairPinning opt.Bool
 
+	// NATMapping and NATFiltering are how the NAT, if any, maps and
+	// filters UDP flows on IPv4, as determined by the NAT behavior
+	// discovery tests of RFC 5780: one of NATEndpointIndependent,
+	// NATAddressDependent or NATAddressAndPortDependent, per RFC 4787.
+	// They're empty if not determined, such as when no STUN server
+	// reachable supports the tests.
+	NATMapping   string `json:",omitempty"`
+	NATFiltering string `json:",omitempty"`
+
 	// UPnP is whether UPnP appears present on the LAN.
 	// Empty means not checked.
 	UPnP opt.Bool
@@ -122,9 +135,29 @@ type Report struct {
 	// intercepting HTTP traffic.
 	CaptivePortal opt.Bool
 
+	// DERPBandwidth is the measured download bandwidth from the
+	// PreferredDERP region, in bytes per second. It is zero unless a
+	// bandwidth probe was requested; see Client.MeasureDERPBandwidth.
+	DERPBandwidth int64 `json:",omitempty"`
+
 	// TODO: update Clone when adding new fields
 }
 
+// NAT mapping and filtering behaviors, as reported in Report.NATMapping and
+// Report.NATFiltering.
+const (
+	// NATEndpointIndependent is a NAT that reuses a mapping for all
+	// destinations, or that lets in packets from any source to it.
+	NATEndpointIndependent = "endpoint-independent"
+	// NATAddressDependent is a NAT that maps, or filters, per
+	// destination IP address.
+	NATAddressDependent = "address-dependent"
+	// NATAddressAndPortDependent is a NAT that maps, or filters, per
+	// destination IP address and port, making direct connections
+	// hardest.
+	NATAddressAndPortDependent = "address-and-port-dependent"
+)
+
 // AnyPortMappingChecked reports whether any of UPnP, PMP, or PCP are non-empty.
 func (r *Report) AnyPortMappingChecked() bool {
 	return r.UPnP != "" || r.PMP != "" || r.PCP != ""
@@ -202,13 +235,15 @@ type Client struct {
 	testEnoughRegions      int
 	testCaptivePortalDelay time.Duration
 
-	mu       sync.Mutex            // guards following
-	nextFull bool                  // do a full region scan, even if last != nil
-	prev     map[time.Time]*Report // some previous reports
-	last     *Report               // most recent report
-	lastFull time.Time             // time of last full (non-incremental) report
-	curState *reportState          // non-nil if we're in a call to GetReport
-	resolver *dnscache.Resolver    // only set if UseDNSCache is true
+	mu           sync.Mutex            // guards following
+	nextFull     bool                  // do a full region scan, even if last != nil
+	prev         map[time.Time]*Report // some previous reports
+	last         *Report               // most recent report
+	lastFull     time.Time             // time of last full (non-incremental) report
+	fullInterval time.Duration         // interval between full reports; 0 means default
+	decisions    []ScheduleDecision    // recent changes to fullInterval, oldest first
+	curState     *reportState          // non-nil if we're in a call to GetReport
+	resolver     *dnscache.Resolver    // only set if UseDNSCache is true
 }
 
 func (c *Client) enoughRegions() int {
@@ -313,7 +348,7 @@ func (c *Client) ReceiveSTUNPacket(pkt []byte, src netip.AddrPort) {
 	}
 	rs.mu.Unlock()
 	if ok {
-		onDone(addrPort)
+		onDone(addrPort, stun.ParseOtherAddress(pkt))
 	}
 }
 
@@ -530,15 +565,28 @@ type reportState struct {
 	incremental bool // doing a lite, follow-up netcheck
 	stopProbeCh chan struct{}
 	waitPortMap sync.WaitGroup
+	natResult   chan natBehavior // receives the result of the NAT behavior tests
 
 	mu            sync.Mutex
 	sentHairCheck bool
-	report        *Report                            // to be returned by GetReport
-	inFlight      map[stun.TxID]func(netip.AddrPort) // called without c.mu held
+	sentNATTests  bool
+	report        *Report // to be returned by GetReport
+	inFlight      map[stun.TxID]stunCallback
 	gotEP4        string
 	timers        []*time.Timer
 }
 
+// stunCallback is called, without c.mu held, with the mapped address in the
+// STUN response to a request in flight, and the OTHER-ADDRESS advertised in
+// it, if any.
+type stunCallback func(mapped, other netip.AddrPort)
+
+// natBehavior is the result of the NAT behavior discovery tests, for
+// Report.NATMapping and Report.NATFiltering.
+type natBehavior struct {
+	mapping, filtering string
+}
+
 func (rs *reportState) anyUDP() bool {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
@@ -630,6 +678,130 @@ func (rs *reportState) waitHairCheck(ctx context.Context) {
 	}
 }
 
+// maybeStartNATBehaviorTests starts the RFC 5780 NAT behavior discovery
+// tests against the IPv4 STUN server at server, which mapped us to mapped,
+// if it advertised a usable alternate address other, and the tests aren't
+// already running.
+func (rs *reportState) maybeStartNATBehaviorTests(server, mapped, other netip.AddrPort) {
+	if !other.Addr().Is4() || other.Addr() == server.Addr() || other.Port() == server.Port() {
+		// The server can't change both its IP and port.
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.sentNATTests || rs.incremental {
+		return
+	}
+	rs.sentNATTests = true
+	rs.c.vlogf("starting NAT behavior tests with %v (other %v)", server, other)
+	go func() {
+		rs.natResult <- rs.runNATBehaviorTests(server, mapped, other)
+	}()
+}
+
+// runNATBehaviorTests runs the filtering and mapping tests of RFC 5780
+// Sections 4.3 and 4.4 against the STUN server at server.
+func (rs *reportState) runNATBehaviorTests(server, mapped, other netip.AddrPort) (nb natBehavior) {
+	// The filtering tests go first, as the mapping tests open the NAT
+	// to the server's alternate address.
+	switch {
+	case rs.natTestRoundTrip(server, true, true).IsValid():
+		nb.filtering = NATEndpointIndependent
+	case rs.natTestRoundTrip(server, false, true).IsValid():
+		nb.filtering = NATAddressDependent
+	default:
+		nb.filtering = NATAddressAndPortDependent
+	}
+
+	mapped2 := rs.natTestRoundTrip(netip.AddrPortFrom(other.Addr(), server.Port()), false, false)
+	switch {
+	case !mapped2.IsValid():
+		// The server's alternate IP didn't answer; we can't tell.
+	case mapped2 == mapped:
+		nb.mapping = NATEndpointIndependent
+	default:
+		switch mapped3 := rs.natTestRoundTrip(other, false, false); {
+		case !mapped3.IsValid():
+		case mapped3 == mapped2:
+			nb.mapping = NATAddressDependent
+		default:
+			nb.mapping = NATAddressAndPortDependent
+		}
+	}
+	return nb
+}
+
+// natTestRoundTrip sends a binding request to dst, asking it to change its
+// IP and/or port to respond, and returns the mapped address in the response
+// or the zero value if none arrives within natBehaviorTestTimeout.
+func (rs *reportState) natTestRoundTrip(dst netip.AddrPort, changeIP, changePort bool) netip.AddrPort {
+	txID := stun.NewTxID()
+	req := stun.Request(txID)
+	if changeIP || changePort {
+		req = stun.ChangeRequest(txID, changeIP, changePort)
+	}
+	gotRes := make(chan netip.AddrPort, 1)
+	rs.mu.Lock()
+	rs.inFlight[txID] = func(mapped, _ netip.AddrPort) { gotRes <- mapped }
+	rs.mu.Unlock()
+	defer func() {
+		rs.mu.Lock()
+		delete(rs.inFlight, txID)
+		rs.mu.Unlock()
+	}()
+
+	metricSTUNSend4.Add(1)
+	if _, err := rs.c.SendPacket(req, dst); err != nil && !neterror.TreatAsLostUDP(err) {
+		return netip.AddrPort{}
+	}
+	timer := time.NewTimer(natBehaviorTestTimeout)
+	defer timer.Stop()
+	select {
+	case mapped := <-gotRes:
+		return mapped
+	case <-timer.C:
+		return netip.AddrPort{}
+	}
+}
+
+// waitNATBehavior waits for the NAT behavior tests to finish, if they were
+// started, and adds their result to the report. Incremental reports reuse
+// the previous result.
+func (rs *reportState) waitNATBehavior(ctx context.Context) {
+	rs.mu.Lock()
+	ret := rs.report
+	if rs.incremental {
+		if rs.c.last != nil {
+			ret.NATMapping = rs.c.last.NATMapping
+			ret.NATFiltering = rs.c.last.NATFiltering
+		}
+		rs.mu.Unlock()
+		return
+	}
+	sent := rs.sentNATTests
+	rs.mu.Unlock()
+	if !sent {
+		return
+	}
+
+	var nb natBehavior
+	select {
+	case nb = <-rs.natResult:
+	case <-ctx.Done():
+		rs.c.vlogf("NAT behavior tests context timeout")
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	ret.NATMapping = nb.mapping
+	ret.NATFiltering = nb.filtering
+	if ret.MappingVariesByDestIP == "" && nb.mapping != "" {
+		// We heard from only one STUN server, but the mapping test
+		// talked to two of its addresses.
+		ret.MappingVariesByDestIP.Set(nb.mapping != NATEndpointIndependent)
+	}
+}
+
 func (rs *reportState) stopTimers() {
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
@@ -766,9 +938,10 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 	rs := &reportState{
 		c:           c,
 		report:      newReport(),
-		inFlight:    map[stun.TxID]func(netip.AddrPort){},
+		inFlight:    map[stun.TxID]stunCallback{},
 		hairTX:      stun.NewTxID(), // random payload
 		gotHairSTUN: make(chan netip.AddrPort, 1),
+		natResult:   make(chan natBehavior, 1),
 		hairTimeout: make(chan struct{}),
 		stopProbeCh: make(chan struct{}, 1),
 	}
@@ -786,7 +959,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 	now := c.timeNow()
 
 	doFull := false
-	if c.nextFull || now.Sub(c.lastFull) > 5*time.Minute {
+	if c.nextFull || now.Sub(c.lastFull) > c.fullReportIntervalLocked() {
 		doFull = true
 	}
 	// If the last report had a captive portal and reported no UDP access,
@@ -947,6 +1120,7 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 
 	rs.waitHairCheck(ctx)
 	c.vlogf("hairCheck done")
+	rs.waitNATBehavior(ctx)
 	if !c.SkipExternalNetwork && c.PortMapper != nil {
 		rs.waitPortMap.Wait()
 		c.vlogf("portMap done")
@@ -1022,7 +1196,13 @@ func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Rep
 	report := rs.report.Clone()
 	rs.mu.Unlock()
 
+	c.mu.Lock()
+	prev := c.last
+	c.mu.Unlock()
 	c.addReportHistoryAndSetPreferredDERP(report, dm.View())
+	c.mu.Lock()
+	c.updateFullReportScheduleLocked(prev, report, !rs.incremental)
+	c.mu.Unlock()
 	c.logConciseReport(report, dm)
 
 	return report
@@ -1179,23 +1359,7 @@ func (c *Client) measureHTTPSLatency(ctx context.Context, reg *tailcfg.DERPRegio
 		return 0, ip, fmt.Errorf("no unexpected RemoteAddr %#v", tlsConn.RemoteAddr())
 	}
 
-	connc := make(chan *tls.Conn, 1)
-	connc <- tlsConn
-
-	tr := &http.Transport{
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return nil, errors.New("unexpected DialContext dial")
-		},
-		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			select {
-			case nc := <-connc:
-				return nc, nil
-			default:
-				return nil, errors.New("only one conn expected")
-			}
-		},
-	}
-	hc := &http.Client{Transport: tr}
+	hc := &http.Client{Transport: singleConnTransport(tlsConn)}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+node.HostName+"/derp/latency-check", nil)
 	if err != nil {
@@ -1226,6 +1390,27 @@ func (c *Client) measureHTTPSLatency(ctx context.Context, reg *tailcfg.DERPRegio
 	return result.ServerProcessing, ip, nil
 }
 
+// singleConnTransport returns an HTTP transport whose first and only dial
+// returns tlsConn.
+func singleConnTransport(tlsConn *tls.Conn) *http.Transport {
+	connc := make(chan *tls.Conn, 1)
+	connc <- tlsConn
+
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return nil, errors.New("unexpected DialContext dial")
+		},
+		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			select {
+			case nc := <-connc:
+				return nc, nil
+			default:
+				return nil, errors.New("only one conn expected")
+			}
+		},
+	}
+}
+
 func (c *Client) measureAllICMPLatency(ctx context.Context, rs *reportState, need []*tailcfg.DERPRegion) error {
 	if len(need) == 0 {
 		return nil
@@ -1309,6 +1494,9 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		}
 		fmt.Fprintf(w, " mapvarydest=%v", r.MappingVariesByDestIP)
 		fmt.Fprintf(w, " hair=%v", r.HairPinning)
+		if r.NATMapping != "" || r.NATFiltering != "" {
+			fmt.Fprintf(w, " nat=%v/%v", r.NATMapping, r.NATFiltering)
+		}
 		if r.AnyPortMappingChecked() {
 			fmt.Fprintf(w, " portmap=%v%v%v", conciseOptBool(r.UPnP, "U"), conciseOptBool(r.PMP, "M"), conciseOptBool(r.PCP, "C"))
 		} else {
@@ -1516,8 +1704,11 @@ func (rs *reportState) runProbe(ctx context.Context, dm *tailcfg.DERPMap, probe
 	sent := time.Now() // after DNS lookup above
 
 	rs.mu.Lock()
-	rs.inFlight[txID] = func(ipp netip.AddrPort) {
+	rs.inFlight[txID] = func(ipp, other netip.AddrPort) {
 		rs.addNodeLatency(node, ipp, time.Since(sent))
+		if probe.proto == probeIPv4 && other.IsValid() {
+			rs.maybeStartNATBehaviorTests(addr, ipp, other)
+		}
 		cancelSet() // abort other nodes in this set
 	}
 	rs.mu.Unlock()
@@ -1684,4 +1875,7 @@ func conciseOptBool(b opt.Bool, trueVal string) string {
 	metricSTUNRecv4 = clientmetric.NewCounter("netcheck_stun_recv_ipv4")
 	metricSTUNRecv6 = clientmetric.NewCounter("netcheck_stun_recv_ipv6")
 	metricHTTPSend  = clientmetric.NewCounter("netcheck_https_measure")
+
+	metricBandwidthProbe      = clientmetric.NewCounter("netcheck_bandwidth_probe")
+	metricBandwidthProbeError = clientmetric.NewCounter("netcheck_bandwidth_probe_error")
 )
@@ -190,6 +190,37 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestNATBehavior(t *testing.T) {
+	stunAddr, cleanup := stuntest.ServeNATBehavior(t)
+	defer cleanup()
+
+	c := &Client{
+		Logf: t.Logf,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.Standalone(ctx, "127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := c.GetReport(ctx, stuntest.DERPMapOf(stunAddr.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// There's no NAT on loopback.
+	if r.NATMapping != NATEndpointIndependent {
+		t.Errorf("NATMapping = %q; want %q", r.NATMapping, NATEndpointIndependent)
+	}
+	if r.NATFiltering != NATEndpointIndependent {
+		t.Errorf("NATFiltering = %q; want %q", r.NATFiltering, NATEndpointIndependent)
+	}
+	if !r.MappingVariesByDestIP.EqualBool(false) {
+		t.Errorf("MappingVariesByDestIP = %q; want false", r.MappingVariesByDestIP)
+	}
+}
+
 func TestWorksWhenUDPBlocked(t *testing.T) {
 	blackhole, err := net.ListenPacket("udp4", "127.0.0.1:0")
 	if err != nil {
@@ -971,3 +1002,64 @@ func TestNodeAddrResolve(t *testing.T) {
 		})
 	}
 }
+
+func TestFullReportSchedule(t *testing.T) {
+	fakeTime := time.Unix(123, 0)
+	c := &Client{
+		TimeNow: func() time.Time { return fakeTime },
+	}
+	report := func(derp int, latency time.Duration) *Report {
+		return &Report{
+			PreferredDERP: derp,
+			RegionLatency: map[int]time.Duration{derp: latency},
+		}
+	}
+	steps := []struct {
+		name string
+		prev *Report
+		r    *Report
+		full bool
+		link int // 1 for a minor link change, 2 for a major one
+		want time.Duration
+	}{
+		{name: "first", r: report(1, 20*time.Millisecond), full: true, want: defaultFullReportInterval},
+		{name: "stable-full", prev: report(1, 20*time.Millisecond), r: report(1, 22*time.Millisecond), full: true, want: 10 * time.Minute},
+		{name: "stable-incremental", prev: report(1, 20*time.Millisecond), r: report(1, 22*time.Millisecond), want: 10 * time.Minute},
+		{name: "stable-full-again", prev: report(1, 20*time.Millisecond), r: report(1, 22*time.Millisecond), full: true, want: 20 * time.Minute},
+		{name: "stable-max", prev: report(1, 20*time.Millisecond), r: report(1, 22*time.Millisecond), full: true, want: maxFullReportInterval},
+		{name: "latency-rising", prev: report(1, 20*time.Millisecond), r: report(1, 50*time.Millisecond), want: 15 * time.Minute},
+		{name: "derp-changed", prev: report(1, 20*time.Millisecond), r: report(2, 10*time.Millisecond), want: 7*time.Minute + 30*time.Second},
+		{name: "link-change", link: 1, want: minFullReportInterval},
+		{name: "link-change-major", link: 2, want: minFullReportInterval},
+	}
+	for _, s := range steps {
+		fakeTime = fakeTime.Add(time.Minute)
+		switch s.link {
+		case 0:
+			c.mu.Lock()
+			c.updateFullReportScheduleLocked(s.prev, s.r, s.full)
+			c.mu.Unlock()
+		case 1:
+			c.NoteLinkChange(false)
+		case 2:
+			c.NoteLinkChange(true)
+		}
+		sched := c.FullReportSchedule()
+		if sched.Interval != s.want {
+			t.Errorf("%s: interval = %v; want %v", s.name, sched.Interval, s.want)
+		}
+	}
+
+	sched := c.FullReportSchedule()
+	var reasons []string
+	for _, d := range sched.Decisions {
+		reasons = append(reasons, d.Reason)
+	}
+	wantReasons := []string{"stable", "stable", "stable", "derp-latency-rising", "preferred-derp-changed", "link-change-minor"}
+	if !reflect.DeepEqual(reasons, wantReasons) {
+		t.Errorf("decisions = %q; want %q", reasons, wantReasons)
+	}
+	if !sched.NextFull.IsZero() {
+		t.Errorf("NextFull = %v after major link change; want zero", sched.NextFull)
+	}
+}
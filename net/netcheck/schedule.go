@@ -0,0 +1,143 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// minFullReportInterval is the shortest interval between full reports,
+	// used after link changes and while DERP latency is rising.
+	minFullReportInterval = 1 * time.Minute
+
+	// defaultFullReportInterval is the interval between full reports
+	// before anything's known about the network's stability.
+	defaultFullReportInterval = 5 * time.Minute
+
+	// maxFullReportInterval is the longest interval between full reports,
+	// reached after consecutive full reports find nothing changed.
+	maxFullReportInterval = 30 * time.Minute
+
+	// maxScheduleDecisions is how many recent schedule decisions are kept
+	// for debugging.
+	maxScheduleDecisions = 20
+)
+
+// FullReportSchedule is when the Client does its next full (non-incremental)
+// report, and why.
+type FullReportSchedule struct {
+	// Interval is the current interval between full reports.
+	Interval time.Duration
+
+	// LastFull is when the last full report started, or the zero time if
+	// there hasn't been one.
+	LastFull time.Time
+
+	// NextFull is when the next full report is due. It's the zero time if
+	// the next report is to be full regardless.
+	NextFull time.Time
+
+	// Decisions are the most recent changes to Interval, oldest first.
+	Decisions []ScheduleDecision
+}
+
+// ScheduleDecision is a change to the interval between full reports.
+type ScheduleDecision struct {
+	At       time.Time
+	Reason   string
+	Interval time.Duration // the new interval
+}
+
+func (d ScheduleDecision) String() string {
+	return fmt.Sprintf("%v: %s, interval=%v", d.At.Format(time.RFC3339), d.Reason, d.Interval)
+}
+
+// fullReportIntervalLocked returns the current interval between full
+// reports.
+//
+// c.mu must be held.
+func (c *Client) fullReportIntervalLocked() time.Duration {
+	if c.fullInterval == 0 {
+		return defaultFullReportInterval
+	}
+	return c.fullInterval
+}
+
+// setFullReportIntervalLocked sets the interval between full reports to d,
+// clamped to the allowed range, and records why if it changed.
+//
+// c.mu must be held.
+func (c *Client) setFullReportIntervalLocked(d time.Duration, reason string) {
+	d = max(minFullReportInterval, min(d, maxFullReportInterval))
+	if d == c.fullReportIntervalLocked() {
+		return
+	}
+	c.fullInterval = d
+	dec := ScheduleDecision{At: c.timeNow(), Reason: reason, Interval: d}
+	if len(c.decisions) == maxScheduleDecisions {
+		c.decisions = append(c.decisions[:0], c.decisions[1:]...)
+	}
+	c.decisions = append(c.decisions, dec)
+	c.vlogf("full report schedule: %v", dec)
+}
+
+// NoteLinkChange tells c that the machine's network link changed, so the
+// interval between full reports drops to its minimum until the network is
+// stable again. If major, the next report is also made full.
+func (c *Client) NoteLinkChange(major bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if major {
+		c.nextFull = true
+		c.setFullReportIntervalLocked(minFullReportInterval, "link-change-major")
+	} else {
+		c.setFullReportIntervalLocked(minFullReportInterval, "link-change-minor")
+	}
+}
+
+// updateFullReportScheduleLocked adjusts the interval between full reports
+// after report r, which was a full one if full, given the previous report
+// prev (which may be nil).
+//
+// The interval is halved when the preferred DERP region changes or its
+// latency rises noticeably, and doubled when a full report finds it stable.
+//
+// c.mu must be held.
+func (c *Client) updateFullReportScheduleLocked(prev, r *Report, full bool) {
+	if prev == nil || prev.PreferredDERP == 0 {
+		return
+	}
+	cur := c.fullReportIntervalLocked()
+	if r.PreferredDERP != prev.PreferredDERP {
+		c.setFullReportIntervalLocked(cur/2, "preferred-derp-changed")
+		return
+	}
+	was, ok1 := prev.RegionLatency[prev.PreferredDERP]
+	now, ok2 := r.RegionLatency[prev.PreferredDERP]
+	if ok1 && ok2 && now > was*3/2 && now-was >= preferredDERPAbsoluteDiff {
+		c.setFullReportIntervalLocked(cur/2, "derp-latency-rising")
+		return
+	}
+	if full {
+		c.setFullReportIntervalLocked(cur*2, "stable")
+	}
+}
+
+// FullReportSchedule returns when c does its next full report and the
+// recent decisions about how often to do them.
+func (c *Client) FullReportSchedule() FullReportSchedule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := FullReportSchedule{
+		Interval:  c.fullReportIntervalLocked(),
+		LastFull:  c.lastFull,
+		Decisions: append([]ScheduleDecision(nil), c.decisions...),
+	}
+	if !c.nextFull && !c.lastFull.IsZero() {
+		s.NextFull = c.lastFull.Add(s.Interval)
+	}
+	return s
+}
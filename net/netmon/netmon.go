@@ -162,6 +162,12 @@ func (m *Monitor) SetTailscaleInterfaceName(ifName string) {
 	m.tsIfName = ifName
 }
 
+// TailscaleInterfaceName returns the name of the Tailscale interface set by
+// SetTailscaleInterfaceName, or the empty string if unknown.
+func (m *Monitor) TailscaleInterfaceName() string {
+	return m.tsIfName
+}
+
 // GatewayAndSelfIP returns the current network's default gateway, and
 // the machine's default IP for that gateway.
 //
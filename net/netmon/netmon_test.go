@@ -7,6 +7,7 @@
 	"flag"
 	"net"
 	"net/netip"
+	"reflect"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -240,3 +241,54 @@ func (m *testOSMon) IsInterestingInterface(name string) bool {
 	}
 	return m.Interesting(name)
 }
+
+func TestOtherVPNs(t *testing.T) {
+	ifUp := func(name, desc string) interfaces.Interface {
+		return interfaces.Interface{
+			Interface: &net.Interface{Name: name, Flags: net.FlagUp},
+			Desc:      desc,
+		}
+	}
+	pfx := netip.MustParsePrefix
+	st := &interfaces.State{
+		Interface: map[string]interfaces.Interface{
+			"eth0":       ifUp("eth0", ""),
+			"tailscale0": ifUp("tailscale0", ""),
+			"wg0":        ifUp("wg0", ""),
+			"tun1":       ifUp("tun1", ""),
+			"utun3":      ifUp("utun3", ""),
+			"Ethernet 2": ifUp("Ethernet 2", "Cisco AnyConnect Virtual Miniport Adapter"),
+			"tun9":       {Interface: &net.Interface{Name: "tun9"}}, // down
+		},
+		InterfaceIPs: map[string][]netip.Prefix{
+			"eth0":       {pfx("192.168.1.10/24")},
+			"tailscale0": {pfx("100.101.102.103/32")},
+			"wg0":        {pfx("10.8.0.2/24")},
+			"tun1":       {pfx("100.96.0.5/16")},
+			"Ethernet 2": {pfx("10.20.0.7/16")},
+			"tun9":       {pfx("10.9.0.1/24")},
+		},
+		DefaultRouteInterface: "wg0",
+	}
+	want := []OtherVPN{
+		{Interface: "Ethernet 2", Mode: VPNSplit, Prefixes: []netip.Prefix{pfx("10.20.0.7/16")}},
+		{Interface: "tun1", Mode: VPNSplitOverlap, Prefixes: []netip.Prefix{pfx("100.96.0.5/16")}},
+		{Interface: "wg0", Mode: VPNFullTunnel, Prefixes: []netip.Prefix{pfx("10.8.0.2/24")}},
+	}
+	got := OtherVPNs(st, "tailscale0")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	var conflicts []string
+	for _, v := range got {
+		if v.Conflicts() {
+			conflicts = append(conflicts, v.Interface)
+		}
+	}
+	if want := []string{"tun1", "wg0"}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts = %q; want %q", conflicts, want)
+	}
+	if got := OtherVPNs(nil, ""); got != nil {
+		t.Errorf("OtherVPNs(nil) = %v; want nil", got)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmon
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+	"sort"
+	"strings"
+
+	"tailscale.com/net/interfaces"
+	"tailscale.com/net/tsaddr"
+)
+
+// VPNMode classifies how another VPN active on this machine coexists with
+// Tailscale.
+type VPNMode string
+
+const (
+	// VPNFullTunnel means the other VPN holds the default route, so
+	// Tailscale's exit node and subnet routes fight with it over where
+	// traffic goes.
+	VPNFullTunnel = VPNMode("full-tunnel")
+
+	// VPNSplitOverlap means the other VPN only routes some traffic but
+	// uses addresses in Tailscale's CGNAT or ULA ranges, so some of its
+	// destinations are ambiguous with Tailscale peers.
+	VPNSplitOverlap = VPNMode("split-overlap")
+
+	// VPNSplit means the other VPN only routes some traffic and doesn't
+	// overlap Tailscale's address ranges. It's not expected to conflict.
+	VPNSplit = VPNMode("split")
+)
+
+// OtherVPN describes a non-Tailscale VPN interface that's up.
+type OtherVPN struct {
+	Interface string
+	Mode      VPNMode
+	Prefixes  []netip.Prefix // the interface's addresses
+}
+
+func (v OtherVPN) String() string {
+	return fmt.Sprintf("%s (%s)", v.Interface, v.Mode)
+}
+
+// Conflicts reports whether v is expected to interfere with Tailscale's
+// routing.
+func (v OtherVPN) Conflicts() bool {
+	return v.Mode == VPNFullTunnel || v.Mode == VPNSplitOverlap
+}
+
+// vpnIfacePrefixes are the name prefixes of tunnel interfaces commonly
+// created by VPN software.
+var vpnIfacePrefixes = []string{
+	"utun",    // macOS, iOS
+	"wg",      // WireGuard
+	"tun",     // OpenVPN and others
+	"tap",     // OpenVPN bridged mode
+	"ipsec",   // strongSwan and others
+	"gpd",     // GlobalProtect
+	"cscotun", // Cisco AnyConnect
+	"nordlynx",
+}
+
+// vpnIfaceWords are substrings of the names or descriptions of interfaces
+// created by VPN software, notably on Windows where names are free-form.
+var vpnIfaceWords = []string{
+	"vpn",
+	"wireguard",
+	"anyconnect",
+	"globalprotect",
+	"tap-windows",
+}
+
+// isVPNInterface reports whether the interface looks like it belongs to
+// VPN software.
+func isVPNInterface(iface interfaces.Interface) bool {
+	name := strings.ToLower(iface.Name)
+	for _, p := range vpnIfacePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	desc := strings.ToLower(iface.Desc)
+	for _, w := range vpnIfaceWords {
+		if strings.Contains(name, w) || strings.Contains(desc, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTailscaleInterface reports whether the interface with the given name and
+// addresses is, or looks like, the Tailscale interface.
+func isTailscaleInterface(name, tsIfName string, pfxs []netip.Prefix) bool {
+	if tsIfName != "" && name == tsIfName {
+		return true
+	}
+	if runtime.GOOS == "darwin" && strings.HasPrefix(name, "utun") {
+		// The macOS network extension can leave several utun devices
+		// with the same Tailscale addresses; see
+		// interfaces.isTailscaleInterface.
+		for _, p := range pfxs {
+			if tsaddr.IsTailscaleIP(p.Addr()) {
+				return true
+			}
+		}
+	}
+	return name == "Tailscale" || // as it is on Windows
+		strings.HasPrefix(name, "tailscale")
+}
+
+// OtherVPNs returns the non-Tailscale VPN interfaces that are up in s, and
+// how each coexists with Tailscale, sorted by interface name. tsIfName is
+// the name of Tailscale's interface, if known.
+func OtherVPNs(s *interfaces.State, tsIfName string) []OtherVPN {
+	if s == nil {
+		return nil
+	}
+	cgnat := tsaddr.CGNATRange()
+	ula := tsaddr.TailscaleULARange()
+	var vpns []OtherVPN
+	for name, iface := range s.Interface {
+		pfxs := s.InterfaceIPs[name]
+		if !iface.IsUp() || iface.IsLoopback() || isTailscaleInterface(name, tsIfName, pfxs) || !isVPNInterface(iface) {
+			continue
+		}
+		if len(pfxs) == 0 {
+			// Not configured, e.g. an idle utun device that macOS
+			// keeps around for system services.
+			continue
+		}
+		mode := VPNSplit
+		for _, p := range pfxs {
+			if p.Overlaps(cgnat) || p.Overlaps(ula) {
+				mode = VPNSplitOverlap
+				break
+			}
+		}
+		if name == s.DefaultRouteInterface {
+			mode = VPNFullTunnel
+		}
+		vpns = append(vpns, OtherVPN{Interface: name, Mode: mode, Prefixes: pfxs})
+	}
+	sort.Slice(vpns, func(i, j int) bool { return vpns[i].Interface < vpns[j].Interface })
+	return vpns
+}
@@ -11,8 +11,10 @@
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"tailscale.com/net/interfaces"
 )
@@ -171,6 +173,9 @@ func ipForwardSysctlKey(format sysctlFormat, p protocol, iface string) string {
 	return fmt.Sprintf(k, iface)
 }
 
+// procSys is where the sysctl tree is mounted. It's a var for tests.
+var procSys = "/proc/sys"
+
 type sysctlFormat int
 
 const (
@@ -193,13 +198,13 @@ func ipForwardSysctlKey(format sysctlFormat, p protocol, iface string) string {
 // sysctl (which on Linux just reads from /proc/sys anyway).
 func ipForwardingEnabledLinux(p protocol, iface string) (bool, error) {
 	k := ipForwardSysctlKey(slashFormat, p, iface)
-	bs, err := os.ReadFile(filepath.Join("/proc/sys", k))
+	bs, err := os.ReadFile(filepath.Join(procSys, k))
 	if err != nil {
 		if os.IsNotExist(err) {
 			// If IPv6 is disabled, sysctl keys like "net.ipv6.conf.all.forwarding" just don't
 			// exist on disk. But first diagnose whether procfs is even mounted before assuming
 			// absence means false.
-			if fi, err := os.Stat("/proc/sys"); err != nil {
+			if fi, err := os.Stat(procSys); err != nil {
 				return false, fmt.Errorf("failed to check sysctl %v; no procfs? %w", k, err)
 			} else if !fi.IsDir() {
 				return false, fmt.Errorf("failed to check sysctl %v; /proc/sys isn't a directory, is %v", k, fi.Mode())
@@ -221,3 +226,205 @@ func ipForwardingEnabledLinux(p protocol, iface string) (bool, error) {
 	on := val == 1 || val == 2
 	return on, nil
 }
+
+// rpFilterSysctlKey returns the key of the IPv4 reverse path filtering sysctl
+// for iface, which may be "all", in the given format.
+func rpFilterSysctlKey(format sysctlFormat, iface string) string {
+	if format == dotFormat {
+		return "net.ipv4.conf." + strings.ReplaceAll(iface, ".", "/") + ".rp_filter"
+	}
+	return "net/ipv4/conf/" + iface + "/rp_filter"
+}
+
+// reversePathFilterLinux returns the value of the IPv4 rp_filter sysctl for
+// iface, which may be "all": 0 for off, 1 for strict and 2 for loose. It
+// returns 0 if the sysctl doesn't exist.
+func reversePathFilterLinux(iface string) (int, error) {
+	k := rpFilterSysctlKey(slashFormat, iface)
+	bs, err := os.ReadFile(filepath.Join(procSys, k))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	val, err := strconv.Atoi(string(bytes.TrimSpace(bs)))
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse %s: %w", k, err)
+	}
+	return val, nil
+}
+
+// hasExitRoute reports whether routes includes an IPv4 default route.
+func hasExitRoute(routes []netip.Prefix) bool {
+	for _, r := range routes {
+		if r.Bits() == 0 && r.Addr().Is4() {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckReversePathFiltering reports whether strict reverse path filtering
+// is enabled on any interface, which drops traffic that an exit node
+// forwards. It returns a warning naming those interfaces if so.
+// The state param must not be nil.
+func CheckReversePathFiltering(routes []netip.Prefix, state *interfaces.State) (warn, err error) {
+	if runtime.GOOS != "linux" || !hasExitRoute(routes) {
+		return nil, nil
+	}
+	if state == nil {
+		return nil, fmt.Errorf("Couldn't check system's reverse path filtering configuration; no link state")
+	}
+	all, err := reversePathFilterLinux("all")
+	if err != nil {
+		return nil, err
+	}
+	var strict []string
+	for _, iface := range state.Interface {
+		if iface.Name == "lo" {
+			continue
+		}
+		v, err := reversePathFilterLinux(iface.Name)
+		if err != nil {
+			return nil, err
+		}
+		// The kernel uses the greater of the "all" and interface values.
+		if max(all, v) == 1 {
+			strict = append(strict, iface.Name)
+		}
+	}
+	if len(strict) == 0 {
+		return nil, nil
+	}
+	return fmt.Errorf("Strict reverse path filtering (rp_filter=1) is enabled on %s, exit node traffic may be dropped.\nSee https://tailscale.com/s/ip-forwarding", strings.Join(strict, ", ")), nil
+}
+
+// SysctlChange is a sysctl setting changed by FixIPForwarding.
+type SysctlChange struct {
+	Key string // in dot format, like "net.ipv4.ip_forward"
+	Old string // the value before the change
+	New string
+}
+
+func (c SysctlChange) String() string {
+	return fmt.Sprintf("%s=%s (was %s)", c.Key, c.New, c.Old)
+}
+
+// FixIPForwardingFile is the file to which FixIPForwarding normally
+// persists its changes.
+const FixIPForwardingFile = "/etc/sysctl.d/99-tailscale.conf"
+
+// FixIPForwarding changes the system's sysctls as needed for the given
+// routes to be forwarded, as reported by CheckIPForwarding and
+// CheckReversePathFiltering: it enables IPv4 and IPv6 forwarding and, for
+// exit nodes, changes strict reverse path filtering to loose.
+//
+// The changes take effect immediately and are also written to confFile, a
+// sysctl.d file, along with the values they replaced, so that they persist
+// across reboots. It returns the changes made, if any, even if persisting
+// them fails.
+//
+// It's only supported on Linux.
+func FixIPForwarding(routes []netip.Prefix, state *interfaces.State, confFile string) ([]SysctlChange, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("fixing IP forwarding isn't supported on %v", runtime.GOOS)
+	}
+	if state == nil {
+		return nil, fmt.Errorf("no link state")
+	}
+
+	type fix struct {
+		dotKey, slashKey, val string
+	}
+	var fixes []fix
+	wantV4, wantV6 := protocolsRequiredForForwarding(routes, state)
+	for _, p := range []protocol{ipv4, ipv6} {
+		if (p == ipv4 && !wantV4) || (p == ipv6 && !wantV6) {
+			continue
+		}
+		k := ipForwardSysctlKey(slashFormat, p, "")
+		if _, err := os.Stat(filepath.Join(procSys, k)); os.IsNotExist(err) {
+			// IPv6 is disabled; nothing to forward.
+			continue
+		}
+		on, err := ipForwardingEnabledLinux(p, "")
+		if err != nil {
+			return nil, err
+		}
+		if !on {
+			fixes = append(fixes, fix{ipForwardSysctlKey(dotFormat, p, ""), k, "1"})
+		}
+	}
+	if wantV4 && hasExitRoute(routes) {
+		var ifaces []string
+		for _, iface := range state.Interface {
+			if iface.Name != "lo" {
+				ifaces = append(ifaces, iface.Name)
+			}
+		}
+		slices.Sort(ifaces)
+		for _, iface := range append([]string{"all"}, ifaces...) {
+			v, err := reversePathFilterLinux(iface)
+			if err != nil {
+				return nil, err
+			}
+			if v == 1 {
+				fixes = append(fixes, fix{rpFilterSysctlKey(dotFormat, iface), rpFilterSysctlKey(slashFormat, iface), "2"})
+			}
+		}
+	}
+
+	var changes []SysctlChange
+	for _, f := range fixes {
+		path := filepath.Join(procSys, f.slashKey)
+		old, err := os.ReadFile(path)
+		if err != nil {
+			return changes, err
+		}
+		if err := os.WriteFile(path, []byte(f.val+"\n"), 0644); err != nil {
+			return changes, fmt.Errorf("setting %s: %w", f.dotKey, err)
+		}
+		changes = append(changes, SysctlChange{Key: f.dotKey, Old: string(bytes.TrimSpace(old)), New: f.val})
+	}
+	if len(changes) == 0 || confFile == "" {
+		return changes, nil
+	}
+	return changes, persistSysctlChanges(confFile, changes)
+}
+
+// persistSysctlChanges adds changes to the sysctl.d file confFile,
+// replacing any earlier settings of the same keys, with comments recording
+// the values they replaced.
+func persistSysctlChanges(confFile string, changes []SysctlChange) error {
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		changed[c.Key] = true
+	}
+	var buf bytes.Buffer
+	old, err := os.ReadFile(confFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(old) == 0 {
+		buf.WriteString("# Written by tailscaled to forward subnet routes and exit node traffic.\n")
+	}
+	for _, line := range strings.SplitAfter(string(old), "\n") {
+		k, _, ok := strings.Cut(line, "=")
+		if ok && !strings.HasPrefix(line, "#") && changed[strings.TrimSpace(k)] {
+			continue
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	for _, c := range changes {
+		fmt.Fprintf(&buf, "# %s was %s before %s\n%s = %s\n", c.Key, c.Old, now, c.Key, c.New)
+	}
+	if err := os.MkdirAll(filepath.Dir(confFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(confFile, buf.Bytes(), 0644)
+}
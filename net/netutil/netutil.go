@@ -6,6 +6,8 @@
 
 import (
 	"bufio"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"sync"
@@ -115,3 +117,66 @@ func (w wrappedConn) Write(bs []byte) (int, error) {
 func (w wrappedConn) Close() error {
 	return w.rwc.Close()
 }
+
+// MaxStreamPacketSize is the largest packet a PacketStreamConn carries.
+const MaxStreamPacketSize = 1<<16 - 1
+
+// NewPacketStreamConn returns a net.Conn that sends and receives whole
+// packets over the stream c, each prefixed by its length as a big-endian
+// uint16. Each Write sends one packet and each Read returns one,
+// truncated to the size of the read buffer as UDP reads are, so that
+// datagrams keep their boundaries when relayed over a stream.
+func NewPacketStreamConn(c net.Conn) net.Conn {
+	return &packetStreamConn{Conn: c}
+}
+
+type packetStreamConn struct {
+	net.Conn
+
+	rmu sync.Mutex // serializes Reads
+	wmu sync.Mutex // serializes Writes
+}
+
+func (c *packetStreamConn) Read(bs []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.Conn, hdr[:]); err != nil {
+		return 0, err
+	}
+	size := int(binary.BigEndian.Uint16(hdr[:]))
+	n := min(size, len(bs))
+	if _, err := io.ReadFull(c.Conn, bs[:n]); err != nil {
+		return 0, noEOF(err)
+	}
+	if n < size {
+		if _, err := io.CopyN(io.Discard, c.Conn, int64(size-n)); err != nil {
+			return 0, noEOF(err)
+		}
+	}
+	return n, nil
+}
+
+func (c *packetStreamConn) Write(bs []byte) (int, error) {
+	if len(bs) > MaxStreamPacketSize {
+		return 0, fmt.Errorf("packet of %d bytes exceeds maximum of %d", len(bs), MaxStreamPacketSize)
+	}
+	buf := make([]byte, 2+len(bs))
+	binary.BigEndian.PutUint16(buf, uint16(len(bs)))
+	copy(buf[2:], bs)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(bs), nil
+}
+
+// noEOF returns err, or io.ErrUnexpectedEOF if it's io.EOF, for streams
+// that end in the middle of a packet.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
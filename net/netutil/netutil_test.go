@@ -6,8 +6,14 @@
 import (
 	"io"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+
+	"tailscale.com/net/interfaces"
 )
 
 type conn struct {
@@ -65,3 +71,122 @@ func TestIPForwardingEnabledLinux(t *testing.T) {
 		t.Errorf("got true; want false")
 	}
 }
+
+func TestFixIPForwarding(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	dir := t.TempDir()
+	procSys = filepath.Join(dir, "proc")
+	t.Cleanup(func() { procSys = "/proc/sys" })
+	sysctls := map[string]string{
+		"net/ipv4/ip_forward":            "0",
+		"net/ipv6/conf/all/forwarding":   "1",
+		"net/ipv4/conf/all/rp_filter":    "0",
+		"net/ipv4/conf/eth0/forwarding":  "0",
+		"net/ipv4/conf/eth0/rp_filter":   "1",
+		"net/ipv4/conf/wlan0/forwarding": "0",
+		"net/ipv4/conf/wlan0/rp_filter":  "2",
+	}
+	for k, v := range sysctls {
+		path := filepath.Join(procSys, k)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(v+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state := &interfaces.State{
+		Interface: map[string]interfaces.Interface{
+			"eth0":  {Interface: &net.Interface{Name: "eth0"}},
+			"wlan0": {Interface: &net.Interface{Name: "wlan0"}},
+		},
+	}
+	routes := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+
+	if warn, err := CheckReversePathFiltering(routes, state); err != nil || warn == nil || !strings.Contains(warn.Error(), "eth0") || strings.Contains(warn.Error(), "wlan0") {
+		t.Errorf("CheckReversePathFiltering = %v, %v; want warning about eth0 only", warn, err)
+	}
+
+	confFile := filepath.Join(dir, "sysctl.d", "99-tailscale.conf")
+	if err := os.MkdirAll(filepath.Dir(confFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(confFile, []byte("net.ipv4.ip_forward = 0\nvm.swappiness = 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := FixIPForwarding(routes, state, confFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SysctlChange{
+		{Key: "net.ipv4.ip_forward", Old: "0", New: "1"},
+		{Key: "net.ipv4.conf.eth0.rp_filter", Old: "1", New: "2"},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes = %v; want %v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("changes[%d] = %v; want %v", i, changes[i], want[i])
+		}
+	}
+	if on, err := ipForwardingEnabledLinux(ipv4, ""); err != nil || !on {
+		t.Errorf("IPv4 forwarding = %v, %v; want enabled", on, err)
+	}
+	if warn, err := CheckReversePathFiltering(routes, state); warn != nil || err != nil {
+		t.Errorf("CheckReversePathFiltering after fix = %v, %v; want nil", warn, err)
+	}
+
+	conf, err := os.ReadFile(confFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"vm.swappiness = 10", "net.ipv4.ip_forward = 1", "net.ipv4.conf.eth0.rp_filter = 2", "# net.ipv4.ip_forward was 0 before "} {
+		if !strings.Contains(string(conf), line) {
+			t.Errorf("conf file lacks %q:\n%s", line, conf)
+		}
+	}
+	if strings.Contains(string(conf), "net.ipv4.ip_forward = 0") {
+		t.Errorf("conf file still has old setting:\n%s", conf)
+	}
+
+	changes, err = FixIPForwarding(routes, state, confFile)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("second FixIPForwarding = %v, %v; want no changes", changes, err)
+	}
+}
+
+func TestPacketStreamConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	p1, p2 := NewPacketStreamConn(c1), NewPacketStreamConn(c2)
+	defer p1.Close()
+	defer p2.Close()
+
+	go func() {
+		for _, pkt := range []string{"hello", "", "world, truncated"} {
+			if _, err := p1.Write([]byte(pkt)); err != nil {
+				t.Errorf("Write(%q): %v", pkt, err)
+			}
+		}
+		p1.Close()
+	}()
+	for _, want := range []string{"hello", "", "world"} {
+		buf := make([]byte, 5)
+		n, err := p2.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("Read = %q; want %q", got, want)
+		}
+	}
+	if _, err := p2.Read(make([]byte, 5)); err != io.EOF {
+		t.Errorf("Read after close: err = %v; want io.EOF", err)
+	}
+
+	if _, err := p1.Write(make([]byte, MaxStreamPacketSize+1)); err == nil {
+		t.Error("Write of oversized packet: got nil error")
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package packet
+
+// DSCP is a Differentiated Services Code Point (RFC 2474): the upper six
+// bits of the IPv4 TOS byte or the IPv6 Traffic Class byte. The lower two
+// bits of those bytes are ECN and are never touched by the DSCP methods.
+type DSCP uint8
+
+// Well-known DSCP values used by Tailscale's traffic classification.
+const (
+	DSCPDefault     DSCP = 0  // CS0; best effort
+	DSCPBulk        DSCP = 8  // CS1; lower than best effort ("scavenger")
+	DSCPInteractive DSCP = 46 // EF; expedited forwarding
+)
+
+// DSCP returns the DSCP value of the packet, or DSCPDefault if q is not
+// a valid IPv4 or IPv6 packet.
+func (q *Parsed) DSCP() DSCP {
+	switch q.IPVersion {
+	case 4:
+		if len(q.b) < ip4HeaderLength {
+			return DSCPDefault
+		}
+		return DSCP(q.b[1] >> 2)
+	case 6:
+		if len(q.b) < ip6HeaderLength {
+			return DSCPDefault
+		}
+		tc := q.b[0]<<4 | q.b[1]>>4
+		return DSCP(tc >> 2)
+	}
+	return DSCPDefault
+}
+
+// SetDSCP rewrites the DSCP bits of the packet in place, preserving its ECN
+// bits. For IPv4, the header checksum is updated accordingly. It does nothing
+// if q is not a valid IPv4 or IPv6 packet.
+func (q *Parsed) SetDSCP(d DSCP) {
+	b := q.b
+	switch q.IPVersion {
+	case 4:
+		if len(b) < ip4HeaderLength {
+			return
+		}
+		old := [2]byte{b[0], b[1]}
+		b[1] = byte(d)<<2 | b[1]&0x03
+		updateV4Checksum(b[10:12], old[:], b[0:2])
+	case 6:
+		if len(b) < ip6HeaderLength {
+			return
+		}
+		tc := b[0]<<4 | b[1]>>4
+		tc = byte(d)<<2 | tc&0x03
+		b[0] = b[0]&0xf0 | tc>>4
+		b[1] = tc<<4 | b[1]&0x0f
+	}
+}
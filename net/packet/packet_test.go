@@ -733,3 +733,49 @@ func BenchmarkString(b *testing.B) {
 		})
 	}
 }
+
+func TestSetDSCP(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"tcp4", tcp4PacketBuffer},
+		{"udp4", udp4RequestBuffer},
+		{"tcp6", tcp6RequestBuffer},
+		{"udp6", udp6RequestBuffer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := bytes.Clone(tt.buf)
+			if buf[0]>>4 == 4 {
+				// The test vectors don't all have valid IPv4 header checksums.
+				binary.BigEndian.PutUint16(buf[10:12], fullHeaderChecksumV4(buf[:ip4HeaderLength]))
+			}
+			orig := bytes.Clone(buf)
+			var p Parsed
+			p.Decode(buf)
+			if got := p.DSCP(); got != DSCPDefault {
+				t.Fatalf("initial DSCP = %d; want %d", got, DSCPDefault)
+			}
+			for _, d := range []DSCP{DSCPInteractive, DSCPBulk, DSCPDefault} {
+				p.SetDSCP(d)
+				if got := p.DSCP(); got != d {
+					t.Errorf("after SetDSCP(%d), DSCP = %d", d, got)
+				}
+				if p.IPVersion == 4 {
+					if got, want := binary.BigEndian.Uint16(buf[10:12]), fullHeaderChecksumV4(buf[:ip4HeaderLength]); got != want {
+						t.Errorf("after SetDSCP(%d), checksum = %#x; want %#x", d, got, want)
+					}
+				}
+			}
+			if !bytes.Equal(buf, orig) {
+				t.Errorf("packet not restored after resetting DSCP:\ngot:  %x\nwant: %x", buf, orig)
+			}
+			var p2 Parsed
+			p2.Decode(buf)
+			if p2.Src != p.Src || p2.Dst != p.Dst || p2.IPProto != p.IPProto {
+				t.Errorf("re-decoded packet = %v; want %v", &p2, &p)
+			}
+		})
+	}
+}
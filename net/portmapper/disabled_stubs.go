@@ -14,6 +14,10 @@
 
 type uPnPDiscoResponse struct{}
 
+type upnpEvents struct{}
+
+func (*upnpEvents) close() {}
+
 func parseUPnPDiscoResponse([]byte) (uPnPDiscoResponse, error) {
 	return uPnPDiscoResponse{}, nil
 }
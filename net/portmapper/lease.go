@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package portmapper
+
+import (
+	"net/netip"
+	"time"
+)
+
+// Lease is a NAT-PMP or PCP port mapping granted by a gateway, in a form
+// that can be persisted and later handed back to RestoreLease so that a
+// restarted client can renew the same external port instead of
+// rediscovering a new one.
+//
+// UPnP mappings are not represented as leases.
+type Lease struct {
+	Protocol  string         // "pmp" or "pcp"
+	Gateway   netip.Addr     // gateway that granted the mapping
+	Internal  netip.AddrPort // local address the mapping points to
+	External  netip.AddrPort // address the mapping is reachable at
+	GoodUntil time.Time      // when the mapping expires
+
+	// PCPNonce is the nonce of a PCP mapping, which RFC 6887 requires
+	// renewals to repeat. It's zero for NAT-PMP leases and if unknown.
+	PCPNonce [12]byte
+}
+
+// Lease returns the client's current NAT-PMP or PCP mapping, if any.
+func (c *Client) Lease() (l Lease, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch m := c.mapping.(type) {
+	case *pmpMapping:
+		return Lease{
+			Protocol:  "pmp",
+			Gateway:   m.gw.Addr(),
+			Internal:  m.internal,
+			External:  m.external,
+			GoodUntil: m.goodUntil,
+		}, true
+	case *pcpMapping:
+		return Lease{
+			Protocol:  "pcp",
+			Gateway:   m.gw.Addr(),
+			Internal:  m.internal,
+			External:  m.external,
+			GoodUntil: m.goodUntil,
+			PCPNonce:  m.nonce,
+		}, true
+	}
+	return Lease{}, false
+}
+
+// RestoreLease installs a lease previously returned by Lease, typically
+// from before a restart, and starts renewing it in the background.
+//
+// The lease is ignored and false is returned if it has expired, if the
+// client already has a mapping, or if the current gateway, local IP or
+// local port don't match the lease. Otherwise the lease's external address
+// is immediately returned by GetCachedMappingOrStartCreatingOne, and the
+// renewal asks the gateway for the same external port.
+func (c *Client) RestoreLease(l Lease) bool {
+	now := time.Now()
+	if !l.GoodUntil.After(now) || !l.External.IsValid() {
+		return false
+	}
+	gw, myIP, ok := c.gatewayAndSelfIP()
+	if !ok || gw != l.Gateway || myIP != l.Internal.Addr() {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.mapping != nil || c.localPort != l.Internal.Port() {
+		return false
+	}
+	gwAddr := netip.AddrPortFrom(gw, c.pxpPort())
+	switch l.Protocol {
+	case "pmp":
+		c.mapping = &pmpMapping{
+			c:         c,
+			gw:        gwAddr,
+			internal:  l.Internal,
+			external:  l.External,
+			goodUntil: l.GoodUntil,
+			// A zero renewAfter renews it as soon as possible.
+		}
+	case "pcp":
+		c.mapping = &pcpMapping{
+			c:         c,
+			gw:        gwAddr,
+			internal:  l.Internal,
+			external:  l.External,
+			goodUntil: l.GoodUntil,
+			nonce:     l.PCPNonce,
+		}
+	default:
+		return false
+	}
+	c.logf("restored %s lease for %v until %v", l.Protocol, l.External, l.GoodUntil.Format(time.RFC3339))
+	c.maybeStartMappingLocked()
+	return true
+}
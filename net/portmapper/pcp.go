@@ -54,6 +54,10 @@ type pcpMapping struct {
 	renewAfter time.Time
 	goodUntil  time.Time
 
+	// nonce is the mapping nonce the mapping was created with, which
+	// requests to renew or delete it must repeat. It's zero if unknown.
+	nonce [12]byte
+
 	// TODO should this also contain an epoch?
 	// Doesn't seem to be used elsewhere, but can use it for validation at some point.
 }
@@ -67,7 +71,7 @@ func (p *pcpMapping) Release(ctx context.Context) {
 		return
 	}
 	defer uc.Close()
-	pkt := buildPCPRequestMappingPacket(p.internal.Addr(), p.internal.Port(), p.external.Port(), 0, p.external.Addr())
+	pkt := buildPCPRequestMappingPacket(p.internal.Addr(), p.internal.Port(), p.external.Port(), 0, p.external.Addr(), p.nonce)
 	uc.WriteToUDPAddrPort(pkt, p.gw)
 }
 
@@ -75,11 +79,14 @@ func (p *pcpMapping) Release(ctx context.Context) {
 // To create a packet which deletes a mapping, lifetimeSec should be set to 0.
 // If prevPort is not known, it should be set to 0.
 // If prevExternalIP is not known, it should be set to 0.0.0.0.
+// The nonce should be that of the mapping being renewed or deleted, if
+// any, or else newPCPNonce.
 func buildPCPRequestMappingPacket(
 	myIP netip.Addr,
 	localPort, prevPort uint16,
 	lifetimeSec uint32,
 	prevExternalIP netip.Addr,
+	nonce [12]byte,
 ) (pkt []byte) {
 	// 24 byte common PCP header + 36 bytes of MAP-specific fields
 	pkt = make([]byte, 24+36)
@@ -90,7 +97,7 @@ func buildPCPRequestMappingPacket(
 	copy(pkt[8:24], myIP16[:])
 
 	mapOp := pkt[24:]
-	rand.Read(mapOp[:12]) // 96 bit mapping nonce
+	copy(mapOp[:12], nonce[:]) // 96 bit mapping nonce
 
 	// TODO: should this be a UDP mapping? It looks like it supports "all protocols" with 0, but
 	// also doesn't support a local port then.
@@ -103,6 +110,12 @@ func buildPCPRequestMappingPacket(
 	return pkt
 }
 
+// newPCPNonce returns a random mapping nonce for a new PCP mapping.
+func newPCPNonce() (nonce [12]byte) {
+	rand.Read(nonce[:])
+	return nonce
+}
+
 // parsePCPMapResponse parses resp into a partially populated pcpMapping.
 // In particular, its Client is not populated.
 func parsePCPMapResponse(resp []byte) (*pcpMapping, error) {
@@ -119,7 +132,6 @@ func parsePCPMapResponse(resp []byte) (*pcpMapping, error) {
 	if res.ResultCode != pcpCodeOK {
 		return nil, fmt.Errorf("PCP response not ok, code %d", res.ResultCode)
 	}
-	// TODO: don't ignore the nonce and make sure it's the same?
 	externalPort := binary.BigEndian.Uint16(resp[42:44])
 	externalIPBytes := [16]byte{}
 	copy(externalIPBytes[:], resp[44:])
@@ -134,6 +146,7 @@ func parsePCPMapResponse(resp []byte) (*pcpMapping, error) {
 		renewAfter: now.Add(lifetime / 2),
 		goodUntil:  now.Add(lifetime),
 	}
+	copy(mapping.nonce[:], resp[24:36])
 
 	return mapping, nil
 }
@@ -4,6 +4,7 @@
 package portmapper
 
 import (
+	"bytes"
 	"encoding/binary"
 	"net/netip"
 	"testing"
@@ -25,6 +26,9 @@ func TestParsePCPMapResponse(t *testing.T) {
 	if mapping.external != expectedAddr {
 		t.Errorf("mismatched external address, got: %v, want: %v", mapping.external, expectedAddr)
 	}
+	if want := examplePCPMapResponse[24:36]; !bytes.Equal(mapping.nonce[:], want) {
+		t.Errorf("mismatched nonce, got: %x, want: %x", mapping.nonce, want)
+	}
 }
 
 const (
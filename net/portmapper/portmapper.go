@@ -94,6 +94,7 @@ type Client struct {
 	uPnPSawTime    time.Time         // time we last saw UPnP was available
 	uPnPMeta       uPnPDiscoResponse // Location header from UPnP UDP discovery response
 	uPnPHTTPClient *http.Client      // netns-configured HTTP client for UPnP; nil until needed
+	uPnPEvents     *upnpEvents       // subscription to events of the UPnP mapping's service, or nil
 
 	localPort uint16
 
@@ -296,6 +297,10 @@ func (c *Client) invalidateMappingsLocked(releaseOld bool) {
 		}
 		c.mapping = nil
 	}
+	if c.uPnPEvents != nil {
+		c.uPnPEvents.close()
+		c.uPnPEvents = nil
+	}
 	c.pmpPubIP = netip.Addr{}
 	c.pmpPubIPTime = time.Time{}
 	c.pcpSawTime = time.Time{}
@@ -446,6 +451,14 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	// prevPort is the port we had most previously, if any. We try
 	// to ask for the same port. 0 means to give us any port.
 	var prevPort uint16
+	// prevExternalIP is the external IP of the previous PCP mapping, if
+	// any, which PCP also lets us ask for again.
+	prevExternalIP := wildcardIP
+	// prevPCP is whether the previous mapping was created with PCP.
+	var prevPCP bool
+	// pcpNonce is the nonce to ask for a PCP mapping with, which must be
+	// that of the previous mapping to renew it.
+	var pcpNonce [12]byte
 
 	// Do we have an existing mapping that's valid?
 	now := time.Now()
@@ -456,6 +469,14 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 		}
 		// The mapping might still be valid, so just try to renew it.
 		prevPort = m.External().Port()
+		if pm, ok := m.(*pcpMapping); ok {
+			prevPCP = true
+			prevExternalIP = pm.external.Addr()
+			pcpNonce = pm.nonce
+		}
+	}
+	if pcpNonce == ([12]byte{}) {
+		pcpNonce = newPCPNonce()
 	}
 
 	if c.debug.DisablePCP && c.debug.DisablePMP {
@@ -486,7 +507,7 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 	if haveRecentPMP {
 		m.external = netip.AddrPortFrom(c.pmpPubIP, m.external.Port())
 	}
-	if c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP {
+	if c.lastProbe.After(now.Add(-5*time.Second)) && !haveRecentPMP && !haveRecentPCP && !prevPCP {
 		c.mu.Unlock()
 		// fallback to UPnP portmapping
 		if external, ok := c.getUPnPPortMapping(ctx, gw, internalAddr, prevPort); ok {
@@ -507,13 +528,13 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 
 	pxpAddr := netip.AddrPortFrom(gw, c.pxpPort())
 
-	preferPCP := !c.debug.DisablePCP && (c.debug.DisablePMP || (!haveRecentPMP && haveRecentPCP))
+	preferPCP := !c.debug.DisablePCP && (c.debug.DisablePMP || (!haveRecentPMP && (haveRecentPCP || prevPCP)))
 
-	// Create a mapping, defaulting to PMP unless only PCP was seen recently.
+	// Create a mapping, defaulting to PMP unless only PCP was seen recently
+	// or we're renewing a PCP mapping.
 	if preferPCP {
-		// TODO replace wildcardIP here with previous external if known.
 		// Only do PCP mapping in the case when PMP did not appear to be available recently.
-		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, pcpMapLifetimeSec, wildcardIP)
+		pkt := buildPCPRequestMappingPacket(myIP, localPort, prevPort, pcpMapLifetimeSec, prevExternalIP, pcpNonce)
 		if _, err := uc.WriteToUDPAddrPort(pkt, pxpAddr); err != nil {
 			if neterror.TreatAsLostUDP(err) {
 				err = NoMappingError{ErrNoPortMappingServices}
@@ -587,6 +608,10 @@ func (c *Client) createOrGetMapping(ctx context.Context) (external netip.AddrPor
 					// PCP should only have a single packet response
 					return netip.AddrPort{}, NoMappingError{ErrNoPortMappingServices}
 				}
+				if pcpMapping.nonce != pcpNonce {
+					c.logf("ignoring PCP response with mismatched nonce")
+					continue
+				}
 				pcpMapping.c = c
 				pcpMapping.internal = m.internal
 				pcpMapping.gw = netip.AddrPortFrom(gw, c.pxpPort())
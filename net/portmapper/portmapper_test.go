@@ -5,6 +5,7 @@
 
 import (
 	"context"
+	"net/netip"
 	"os"
 	"reflect"
 	"strconv"
@@ -122,3 +123,71 @@ func TestPCPIntegration(t *testing.T) {
 		t.Errorf("got nil mapping after successful createOrGetMapping")
 	}
 }
+
+func TestRestoreLease(t *testing.T) {
+	igd, err := NewTestIGD(t.Logf, TestIGDOptions{PMP: false, PCP: true, UPnP: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer igd.Close()
+
+	gw, myIP, _ := testIPAndGateway()
+	lease := Lease{
+		Protocol:  "pcp",
+		Gateway:   gw,
+		Internal:  netip.AddrPortFrom(myIP, 0),
+		External:  netip.MustParseAddrPort("127.0.0.1:4242"),
+		GoodUntil: time.Now().Add(time.Hour),
+		PCPNonce:  [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	}
+
+	t.Run("mismatch", func(t *testing.T) {
+		c := newTestClient(t, igd)
+		defer c.Close()
+		expired := lease
+		expired.GoodUntil = time.Now().Add(-time.Minute)
+		if c.RestoreLease(expired) {
+			t.Error("restored expired lease")
+		}
+		otherGW := lease
+		otherGW.Gateway = netip.MustParseAddr("10.0.0.1")
+		if c.RestoreLease(otherGW) {
+			t.Error("restored lease from other gateway")
+		}
+		if c.HaveMapping() {
+			t.Error("unexpected mapping")
+		}
+	})
+
+	t.Run("renew", func(t *testing.T) {
+		c := newTestClient(t, igd)
+		defer c.Close()
+		if !c.RestoreLease(lease) {
+			t.Fatal("lease not restored")
+		}
+		if ext, ok := c.GetCachedMappingOrStartCreatingOne(); !ok || ext != lease.External {
+			t.Errorf("cached mapping = %v, %v; want %v, true", ext, ok, lease.External)
+		}
+
+		// Renewing should use PCP again and ask for the same port.
+		ext, err := c.createOrGetMapping(context.Background())
+		if err != nil {
+			t.Fatalf("renewing: %v", err)
+		}
+		if ext != lease.External {
+			t.Errorf("renewed external = %v; want %v", ext, lease.External)
+		}
+		got, ok := c.Lease()
+		if !ok || got.Protocol != "pcp" || got.External != lease.External || !got.GoodUntil.After(lease.GoodUntil) {
+			t.Errorf("Lease() = %+v, %v; want renewed pcp lease", got, ok)
+		}
+		// The test IGD echoes the nonce of the request, which must be
+		// the lease's to renew the same mapping.
+		if got.PCPNonce != lease.PCPNonce {
+			t.Errorf("renewed with nonce %x; want %x", got.PCPNonce, lease.PCPNonce)
+		}
+		if st := igd.stats(); st.numPCPMapRecv == 0 || st.numPMPRecv != 0 {
+			t.Errorf("IGD stats = %+v; want PCP map requests only", st)
+		}
+	})
+}
@@ -350,6 +350,7 @@ func (c *Client) getUPnPPortMapping(
 	defer c.mu.Unlock()
 	c.mapping = upnp
 	c.localPort = newPort
+	c.maybeSubscribeUPnPEventsLocked(client, internal.Addr())
 	return upnp.external, true
 }
 
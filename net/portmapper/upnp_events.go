@@ -0,0 +1,321 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !js
+
+package portmapper
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tailscale/goupnp"
+)
+
+// References:
+//
+// UPnP Device Architecture 2.0, section 4 (Eventing): https://openconnectivity.org/upnp-specs/UPnP-arch-DeviceArchitecture-v2.0-20200417.pdf
+
+// upnpEventTimeout is how long we ask gateways to keep our event
+// subscriptions for. We renew them after half of what they grant.
+const upnpEventTimeout = 30 * time.Minute
+
+// upnpEvents is a GENA (UPnP eventing) subscription to the state variables
+// of the WAN connection service that a UPnP mapping was made with, so that
+// a change of the gateway's external IP address, or the removal of its
+// port mappings, is noticed within seconds rather than at the next renewal.
+//
+// Gateways that don't support eventing, or that can't reach our callback,
+// just don't send events, and we rely on renewing the mapping as before.
+type upnpEvents struct {
+	c          *Client
+	client     upnpClient   // of the mapping subscribed for
+	subURL     string       // event subscription URL of client's service
+	httpClient *http.Client // for SUBSCRIBE and UNSUBSCRIBE
+	ln         net.Listener // for NOTIFY requests
+	srv        *http.Server
+
+	mu     sync.Mutex // guards following
+	sid    string     // subscription ID, or empty if not (yet) subscribed
+	timer  *time.Timer
+	closed bool
+}
+
+// upnpEventSubURL returns the event subscription URL of the service of
+// client, or the empty string if it doesn't have one.
+func upnpEventSubURL(client upnpClient) string {
+	sc, ok := client.(interface{ GetServiceClient() *goupnp.ServiceClient })
+	if !ok {
+		return ""
+	}
+	svc := sc.GetServiceClient().Service
+	if svc == nil || !svc.EventSubURL.Ok || svc.EventSubURL.Str == "" {
+		return ""
+	}
+	return svc.EventSubURL.URL.String()
+}
+
+// maybeSubscribeUPnPEventsLocked starts subscribing to the events of the
+// service of client, which the current mapping was made with, if it's not
+// subscribed to them already and the service supports eventing. Events are
+// delivered to a listener on myIP, the address of the mapping's LAN
+// interface.
+//
+// c.mu must be held.
+func (c *Client) maybeSubscribeUPnPEventsLocked(client upnpClient, myIP netip.Addr) {
+	if c.closed {
+		return
+	}
+	if e := c.uPnPEvents; e != nil {
+		if e.client == client {
+			return
+		}
+		e.close()
+		c.uPnPEvents = nil
+	}
+	subURL := upnpEventSubURL(client)
+	if subURL == "" {
+		return
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(myIP.String(), "0"))
+	if err != nil {
+		c.logf("UPnP events: %v", err)
+		return
+	}
+	e := &upnpEvents{
+		c:          c,
+		client:     client,
+		subURL:     subURL,
+		httpClient: c.upnpHTTPClientLocked(),
+		ln:         ln,
+	}
+	e.srv = &http.Server{
+		Handler:           e,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	c.uPnPEvents = e
+	go e.srv.Serve(ln)
+	go e.subscribe()
+}
+
+// callbackURL returns the GENA CALLBACK header value of e.
+func (e *upnpEvents) callbackURL() string {
+	return fmt.Sprintf("<http://%s/>", e.ln.Addr())
+}
+
+// subscribe subscribes to the events, or renews the subscription if there
+// is one, and schedules the next renewal.
+func (e *upnpEvents) subscribe() {
+	e.mu.Lock()
+	sid, closed := e.sid, e.closed
+	e.mu.Unlock()
+	if closed {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	newSID, timeout, err := e.sendSubscribe(ctx, sid)
+	if err != nil && sid != "" {
+		// The gateway may have forgotten the subscription, such as
+		// when it restarted, so subscribe anew.
+		newSID, timeout, err = e.sendSubscribe(ctx, "")
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		if err == nil {
+			go e.unsubscribe(newSID)
+		}
+		return
+	}
+	if err != nil {
+		e.sid = ""
+		if e.c.debug.VerboseLogs {
+			e.c.logf("UPnP event subscription to %v: %v", e.subURL, err)
+		}
+		return
+	}
+	if sid == "" && e.c.debug.VerboseLogs {
+		e.c.logf("subscribed to UPnP events of %v for %v", e.subURL, timeout)
+	}
+	e.sid = newSID
+	e.timer = time.AfterFunc(max(timeout/2, 30*time.Second), e.subscribe)
+}
+
+// sendSubscribe sends a SUBSCRIBE request, renewing the subscription sid
+// if it's non-empty, and returns the subscription's ID and duration.
+func (e *upnpEvents) sendSubscribe(ctx context.Context, sid string) (newSID string, timeout time.Duration, err error) {
+	hdr := map[string]string{
+		"TIMEOUT": fmt.Sprintf("Second-%d", int(upnpEventTimeout.Seconds())),
+	}
+	if sid != "" {
+		hdr["SID"] = sid
+	} else {
+		hdr["CALLBACK"] = e.callbackURL()
+		hdr["NT"] = "upnp:event"
+	}
+	res, err := e.send(ctx, "SUBSCRIBE", hdr)
+	if err != nil {
+		return "", 0, err
+	}
+	newSID = res.Get("SID")
+	if newSID == "" {
+		return "", 0, fmt.Errorf("SUBSCRIBE: no SID in response")
+	}
+	return newSID, parseUPnPTimeout(res.Get("TIMEOUT")), nil
+}
+
+// unsubscribe does a best effort cancellation of the subscription sid.
+func (e *upnpEvents) unsubscribe(sid string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	e.send(ctx, "UNSUBSCRIBE", map[string]string{"SID": sid})
+}
+
+// send sends the GENA request method to the event subscription URL and
+// returns the response's header.
+func (e *upnpEvents) send(ctx context.Context, method string, hdr map[string]string) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, method, e.subURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		// Not canonicalized, as some gateways match GENA headers
+		// case-sensitively.
+		req.Header[k] = []string{v}
+	}
+	res, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %v", method, res.Status)
+	}
+	return res.Header, nil
+}
+
+// close ends the subscription, if any, and stops receiving events.
+func (e *upnpEvents) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.closed = true
+	if e.timer != nil {
+		e.timer.Stop()
+	}
+	e.srv.Close()
+	if e.sid != "" {
+		go e.unsubscribe(e.sid)
+	}
+}
+
+// ServeHTTP handles the NOTIFY requests of events.
+func (e *upnpEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "NOTIFY" || r.Header.Get("NT") != "upnp:event" || r.Header.Get("NTS") != "upnp:propchange" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	e.mu.Lock()
+	// The first event can arrive before the response to the SUBSCRIBE
+	// request that tells us its SID.
+	ok := !e.closed && (e.sid == "" || r.Header.Get("SID") == e.sid)
+	e.mu.Unlock()
+	if !ok {
+		http.Error(w, "unknown subscription", http.StatusPreconditionFailed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return
+	}
+	vars, err := parseUPnPPropertySet(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	e.c.noteUPnPEvent(e.client, vars)
+}
+
+// noteUPnPEvent handles an event with the values of the state variables
+// vars of the service of client. If they show that the current mapping,
+// made with client, is no longer reachable at its external address, the
+// mapping is expired, so that it's renewed, and onChange is called.
+func (c *Client) noteUPnPEvent(client upnpClient, vars map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.mapping.(*upnpMapping)
+	if !ok || m.client != client || !time.Now().Before(m.goodUntil) {
+		return
+	}
+	var why string
+	if s, ok := vars["ExternalIPAddress"]; ok {
+		if ip, _ := netip.ParseAddr(s); ip != m.external.Addr() {
+			why = fmt.Sprintf("external IP changed from %v to %q", m.external.Addr(), s)
+		}
+	}
+	if vars["PortMappingNumberOfEntries"] == "0" {
+		why = "port mappings were removed"
+	}
+	if why == "" {
+		return
+	}
+	c.logf("UPnP event: %s; renewing mapping", why)
+
+	// Mappings are immutable, so replace it with an expired copy, which
+	// keeps its client and port for the renewal.
+	expired := *m
+	expired.goodUntil = time.Time{}
+	expired.renewAfter = time.Time{}
+	c.mapping = &expired
+	if c.onChange != nil {
+		go c.onChange()
+	}
+}
+
+// parseUPnPPropertySet parses the body of a NOTIFY request, returning the
+// values of the state variables in it by name.
+func parseUPnPPropertySet(body []byte) (map[string]string, error) {
+	var ps struct {
+		XMLName    xml.Name `xml:"propertyset"`
+		Properties []struct {
+			Vars []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"property"`
+	}
+	if err := xml.Unmarshal(body, &ps); err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string)
+	for _, p := range ps.Properties {
+		for _, v := range p.Vars {
+			vars[v.XMLName.Local] = strings.TrimSpace(v.Value)
+		}
+	}
+	return vars, nil
+}
+
+// parseUPnPTimeout parses a GENA TIMEOUT header value, such as
+// "Second-1800". It returns upnpEventTimeout if it's invalid or infinite.
+func parseUPnPTimeout(s string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(s), "Second-"))
+	if err != nil || secs <= 0 {
+		return upnpEventTimeout
+	}
+	return time.Duration(secs) * time.Second
+}
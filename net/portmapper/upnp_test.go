@@ -13,8 +13,11 @@
 	"net/netip"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"tailscale.com/net/netaddr"
 	"tailscale.com/tstest"
 )
 
@@ -129,3 +132,131 @@ func TestGetUPnPClient(t *testing.T) {
 		})
 	}
 }
+
+func TestParseUPnPPropertySet(t *testing.T) {
+	body := `<?xml version="1.0"?>
+<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0"><e:property><ExternalIPAddress> 203.0.113.7 </ExternalIPAddress></e:property><e:property><PortMappingNumberOfEntries>2</PortMappingNumberOfEntries></e:property></e:propertyset>`
+	got, err := parseUPnPPropertySet([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"ExternalIPAddress":          "203.0.113.7",
+		"PortMappingNumberOfEntries": "2",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if _, err := parseUPnPPropertySet([]byte("<root/>")); err == nil {
+		t.Error("parsed a document that isn't a propertyset")
+	}
+}
+
+func TestUPnPEvents(t *testing.T) {
+	subs := make(chan http.Header, 1)
+	unsubs := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.RequestURI == "/rootDesc.xml":
+			io.WriteString(w, googleWifiRootDescXML)
+		case r.RequestURI == "/evt/IPConn" && r.Method == "SUBSCRIBE":
+			w.Header().Set("SID", "uuid:sub-1")
+			w.Header().Set("TIMEOUT", "Second-1800")
+			subs <- r.Header
+		case r.RequestURI == "/evt/IPConn" && r.Method == "UNSUBSCRIBE":
+			unsubs <- r.Header.Get("SID")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+	gw := netaddr.IPv4(127, 0, 0, 1)
+	client, err := getUPnPClient(context.Background(), t.Logf, DebugKnobs{}, gw, uPnPDiscoResponse{
+		Location: ts.URL + "/rootDesc.xml",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := upnpEventSubURL(client), ts.URL+"/evt/IPConn"; got != want {
+		t.Fatalf("event subscription URL = %q; want %q", got, want)
+	}
+
+	changed := make(chan bool, 1)
+	c := NewClient(t.Logf, nil, nil, func() { changed <- true })
+	defer c.Close()
+	c.mu.Lock()
+	c.mapping = &upnpMapping{
+		gw:        gw,
+		external:  netip.MustParseAddrPort("203.0.113.7:41641"),
+		internal:  netip.MustParseAddrPort("127.0.0.1:41641"),
+		goodUntil: time.Now().Add(time.Hour),
+		client:    client,
+	}
+	c.maybeSubscribeUPnPEventsLocked(client, gw)
+	c.mu.Unlock()
+
+	sub := <-subs
+	if got := sub.Get("NT"); got != "upnp:event" {
+		t.Errorf("NT = %q", got)
+	}
+	callback := strings.Trim(sub.Get("CALLBACK"), "<>")
+	notify := func(sid, vars string) int {
+		t.Helper()
+		body := `<e:propertyset xmlns:e="urn:schemas-upnp-org:event-1-0"><e:property>` + vars + `</e:property></e:propertyset>`
+		req, err := http.NewRequest("NOTIFY", callback, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("NT", "upnp:event")
+		req.Header.Set("NTS", "upnp:propchange")
+		req.Header.Set("SID", sid)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	// Wait for the subscription to be recorded.
+	for i := 0; ; i++ {
+		c.uPnPEvents.mu.Lock()
+		sid := c.uPnPEvents.sid
+		c.uPnPEvents.mu.Unlock()
+		if sid != "" {
+			break
+		}
+		if i == 100 {
+			t.Fatal("subscription not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := notify("uuid:other", "<ExternalIPAddress>198.51.100.1</ExternalIPAddress>"); got != http.StatusPreconditionFailed {
+		t.Errorf("NOTIFY of unknown subscription: status %v", got)
+	}
+	if got := notify("uuid:sub-1", "<ExternalIPAddress>203.0.113.7</ExternalIPAddress>"); got != http.StatusOK {
+		t.Errorf("NOTIFY: status %v", got)
+	}
+	if !c.HaveMapping() {
+		t.Fatal("mapping expired without an external IP change")
+	}
+	notify("uuid:sub-1", "<ExternalIPAddress>198.51.100.1</ExternalIPAddress>")
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("onChange not called after the external IP changed")
+	}
+	if c.HaveMapping() {
+		t.Error("mapping still valid after the external IP changed")
+	}
+
+	c.Close()
+	select {
+	case sid := <-unsubs:
+		if sid != "uuid:sub-1" {
+			t.Errorf("unsubscribed %q", sid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not unsubscribed on Close")
+	}
+}
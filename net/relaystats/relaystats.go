@@ -0,0 +1,190 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package relaystats counts the traffic a subnet router or exit node
+// forwards on behalf of each of its peers, rolled up by day, and optionally
+// enforces a daily limit per peer.
+package relaystats
+
+import (
+	"cmp"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/util/set"
+)
+
+// MaxDays is the number of days of usage that a Tracker retains,
+// including the current one.
+const MaxDays = 30
+
+// PeerUsage is the traffic forwarded on behalf of one peer.
+type PeerUsage struct {
+	// Peer is the Tailscale IP address of the peer.
+	Peer netip.Addr
+
+	// RxBytes is the number of bytes received from the peer and
+	// forwarded to a destination behind this node.
+	RxBytes uint64
+
+	// TxBytes is the number of bytes forwarded back to the peer.
+	TxBytes uint64
+}
+
+// DayUsage is the traffic forwarded on behalf of peers during one day.
+type DayUsage struct {
+	// Date is the day in UTC, in YYYY-MM-DD format.
+	Date string
+
+	// Peers is the usage of each peer that used this node during the day,
+	// ordered by decreasing total bytes.
+	Peers []PeerUsage
+}
+
+// Tracker counts the bytes forwarded on behalf of peers.
+// All methods are safe for concurrent use.
+// The zero value is ready for use.
+type Tracker struct {
+	timeNow func() time.Time // or nil for time.Now; for tests
+
+	mu    sync.Mutex
+	self  set.Set[netip.Addr]
+	limit uint64 // daily limit per peer in bytes, or zero for no limit
+	days  []day  // oldest first; at most MaxDays
+}
+
+type counts struct {
+	rx, tx uint64
+}
+
+type day struct {
+	date  string
+	peers map[netip.Addr]*counts
+}
+
+func (t *Tracker) now() time.Time {
+	if t.timeNow != nil {
+		return t.timeNow()
+	}
+	return time.Now()
+}
+
+// SetSelfAddrs sets the Tailscale addresses of this node. Traffic to and
+// from them is not forwarded, and so isn't counted.
+func (t *Tracker) SetSelfAddrs(addrs []netip.Prefix) {
+	self := make(set.Set[netip.Addr], len(addrs))
+	for _, pfx := range addrs {
+		if pfx.IsSingleIP() {
+			self.Add(pfx.Addr())
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.self = self
+}
+
+// SetDailyLimit sets the number of bytes that may be forwarded on behalf
+// of each peer per day (UTC), counting both directions. Once a peer exceeds
+// it, FromPeer reports false for the rest of the day. Zero means no limit.
+func (t *Tracker) SetDailyLimit(bytes uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limit = bytes
+}
+
+// isForwardedLocked reports whether a packet to or from a peer with the
+// non-peer address addr is forwarded traffic.
+//
+// t.mu must be held.
+func (t *Tracker) isForwardedLocked(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || t.self.Contains(addr) {
+		return false
+	}
+	return addr != tsaddr.TailscaleServiceIP() && addr != tsaddr.TailscaleServiceIPv6()
+}
+
+// todayLocked returns the counts for the current day, rolling over to a
+// new day if needed.
+//
+// t.mu must be held.
+func (t *Tracker) todayLocked() *day {
+	date := t.now().UTC().Format(time.DateOnly)
+	if n := len(t.days); n > 0 && t.days[n-1].date == date {
+		return &t.days[n-1]
+	}
+	if len(t.days) == MaxDays {
+		t.days = slices.Delete(t.days, 0, 1)
+	}
+	t.days = append(t.days, day{date: date, peers: map[netip.Addr]*counts{}})
+	return &t.days[len(t.days)-1]
+}
+
+// FromPeer counts a packet of n bytes received from the peer src and
+// destined to dst. It reports whether the packet may be forwarded, which
+// is false only if it is forwarded traffic and src has exceeded its daily
+// limit.
+func (t *Tracker) FromPeer(src, dst netip.Addr, n int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.isForwardedLocked(dst) {
+		return true
+	}
+	c := t.countsLocked(src)
+	if t.limit != 0 && c.rx+c.tx >= t.limit {
+		return false
+	}
+	c.rx += uint64(n)
+	return true
+}
+
+// ToPeer counts a packet of n bytes from src being sent to the peer dst.
+func (t *Tracker) ToPeer(src, dst netip.Addr, n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.isForwardedLocked(src) {
+		return
+	}
+	t.countsLocked(dst).tx += uint64(n)
+}
+
+// countsLocked returns today's counts for peer.
+//
+// t.mu must be held.
+func (t *Tracker) countsLocked(peer netip.Addr) *counts {
+	d := t.todayLocked()
+	c, ok := d.peers[peer]
+	if !ok {
+		c = new(counts)
+		d.peers[peer] = c
+	}
+	return c
+}
+
+// Usage returns the usage of up to the last days days, most recent first.
+// If days is zero or negative, all retained days are returned.
+func (t *Tracker) Usage(days int) []DayUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if days <= 0 || days > len(t.days) {
+		days = len(t.days)
+	}
+	ret := make([]DayUsage, 0, days)
+	for i := len(t.days) - 1; i >= len(t.days)-days; i-- {
+		d := t.days[i]
+		du := DayUsage{Date: d.date, Peers: make([]PeerUsage, 0, len(d.peers))}
+		for peer, c := range d.peers {
+			du.Peers = append(du.Peers, PeerUsage{Peer: peer, RxBytes: c.rx, TxBytes: c.tx})
+		}
+		slices.SortFunc(du.Peers, func(a, b PeerUsage) int {
+			if c := cmp.Compare(b.RxBytes+b.TxBytes, a.RxBytes+a.TxBytes); c != 0 {
+				return c
+			}
+			return a.Peer.Compare(b.Peer)
+		})
+		ret = append(ret, du)
+	}
+	return ret
+}
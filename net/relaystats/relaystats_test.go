@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package relaystats
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	now := time.Date(2023, 9, 1, 23, 0, 0, 0, time.UTC)
+	tr := &Tracker{timeNow: func() time.Time { return now }}
+	tr.SetSelfAddrs([]netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")})
+
+	var (
+		self   = netip.MustParseAddr("100.64.0.1")
+		peer1  = netip.MustParseAddr("100.64.0.2")
+		peer2  = netip.MustParseAddr("100.64.0.3")
+		lan    = netip.MustParseAddr("192.168.1.10")
+		quad10 = netip.MustParseAddr("100.100.100.100")
+	)
+
+	// Traffic to this node itself isn't forwarded.
+	tr.FromPeer(peer1, self, 1000)
+	tr.FromPeer(peer1, quad10, 1000)
+	tr.ToPeer(self, peer1, 1000)
+
+	tr.FromPeer(peer1, lan, 100)
+	tr.ToPeer(lan, peer1, 1000)
+	tr.FromPeer(peer2, lan, 10)
+
+	now = now.Add(2 * time.Hour) // next day
+	tr.FromPeer(peer2, lan, 5)
+
+	want := []DayUsage{
+		{Date: "2023-09-02", Peers: []PeerUsage{{Peer: peer2, RxBytes: 5}}},
+		{Date: "2023-09-01", Peers: []PeerUsage{
+			{Peer: peer1, RxBytes: 100, TxBytes: 1000},
+			{Peer: peer2, RxBytes: 10},
+		}},
+	}
+	if got := tr.Usage(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Usage(0) = %+v; want %+v", got, want)
+	}
+	if got := tr.Usage(1); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("Usage(1) = %+v; want %+v", got, want[:1])
+	}
+
+	tr.SetDailyLimit(100)
+	if !tr.FromPeer(peer1, lan, 100) {
+		t.Errorf("FromPeer under limit = false; want true")
+	}
+	if tr.FromPeer(peer1, lan, 1) {
+		t.Errorf("FromPeer over limit = true; want false")
+	}
+	if !tr.FromPeer(peer1, self, 1) {
+		t.Errorf("FromPeer to self over limit = false; want true")
+	}
+	if !tr.FromPeer(peer2, lan, 1) {
+		t.Errorf("FromPeer for other peer = false; want true")
+	}
+
+	for i := 0; i < MaxDays+5; i++ {
+		now = now.Add(24 * time.Hour)
+		tr.FromPeer(peer1, lan, 1)
+	}
+	if got := len(tr.Usage(0)); got != MaxDays {
+		t.Errorf("retained %d days; want %d", got, MaxDays)
+	}
+}
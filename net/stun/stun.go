@@ -25,6 +25,17 @@
 	// like an easy mistake for a server to make.
 	// And servers appear to send it.
 	attrXorMappedAddressAlt = 0x8020
+	// attrRegionHint is a Tailscale-specific attribute in which DERP
+	// servers suggest DERP regions to clients. It's in the
+	// comprehension-optional range, so other clients ignore it.
+	attrRegionHint = 0xc0de
+	// attrChangeRequest and attrOtherAddress are the attributes of
+	// RFC 5780 NAT behavior discovery.
+	attrChangeRequest = 0x0003
+	attrOtherAddress  = 0x802c
+
+	changeIPFlag   = 0x04
+	changePortFlag = 0x02
 
 	software       = "tailnode" // notably: 8 bytes long, so no padding
 	bindingRequest = "\x00\x01"
@@ -48,11 +59,35 @@ func NewTxID() TxID {
 // Request generates a binding request STUN packet.
 // The transaction ID, tID, should be a random sequence of bytes.
 func Request(tID TxID) []byte {
+	return request(tID, nil)
+}
+
+// ChangeRequest generates a binding request STUN packet asking the server
+// to respond from its alternate IP address and/or port, per the
+// CHANGE-REQUEST attribute of RFC 5780 Section 7.2. It must only be sent
+// to servers that advertised an alternate address; see ParseOtherAddress.
+func ChangeRequest(tID TxID, changeIP, changePort bool) []byte {
+	var flags uint32
+	if changeIP {
+		flags |= changeIPFlag
+	}
+	if changePort {
+		flags |= changePortFlag
+	}
+	attr := appendU16(nil, attrChangeRequest)
+	attr = appendU16(attr, 4)
+	attr = appendU32(attr, flags)
+	return request(tID, attr)
+}
+
+// request generates a binding request STUN packet with the
+// attributes attrs, already encoded, between SOFTWARE and FINGERPRINT.
+func request(tID TxID, attrs []byte) []byte {
 	// STUN header, RFC5389 Section 6.
 	const lenAttrSoftware = 4 + len(software)
-	b := make([]byte, 0, headerLen+lenAttrSoftware+lenFingerprint)
+	b := make([]byte, 0, headerLen+lenAttrSoftware+len(attrs)+lenFingerprint)
 	b = append(b, bindingRequest...)
-	b = appendU16(b, uint16(lenAttrSoftware+lenFingerprint)) // number of bytes following header
+	b = appendU16(b, uint16(lenAttrSoftware+len(attrs)+lenFingerprint)) // number of bytes following header
 	b = append(b, magicCookie...)
 	b = append(b, tID[:]...)
 
@@ -61,6 +96,8 @@ func Request(tID TxID) []byte {
 	b = appendU16(b, uint16(len(software)))
 	b = append(b, software...)
 
+	b = append(b, attrs...)
+
 	// Attribute FINGERPRINT, RFC5389 Section 15.5.
 	fp := fingerPrint(b)
 	b = appendU16(b, attrNumFingerprint)
@@ -121,6 +158,24 @@ func ParseBindingRequest(b []byte) (TxID, error) {
 	return txID, nil
 }
 
+// ParseChangeRequest reports whether b, a binding request accepted by
+// ParseBindingRequest, asks the server to respond from its alternate IP
+// address and/or port. See ChangeRequest.
+func ParseChangeRequest(b []byte) (changeIP, changePort bool) {
+	if !Is(b) {
+		return false, false
+	}
+	foreachAttr(b[headerLen:], func(attrType uint16, a []byte) error {
+		if attrType == attrChangeRequest && len(a) == 4 {
+			flags := binary.BigEndian.Uint32(a)
+			changeIP = flags&changeIPFlag != 0
+			changePort = flags&changePortFlag != 0
+		}
+		return nil
+	})
+	return changeIP, changePort
+}
+
 var (
 	ErrNotSTUN            = errors.New("response is not a STUN packet")
 	ErrNotSuccessResponse = errors.New("STUN packet is not a response")
@@ -190,6 +245,105 @@ func Response(txID TxID, addrPort netip.AddrPort) []byte {
 	return b
 }
 
+// AppendRegionHint appends to res, a binding response generated by Response,
+// an attribute suggesting the DERP regions with the given IDs to the client.
+// It returns res unmodified if regionIDs is empty or too long.
+func AppendRegionHint(res []byte, regionIDs []int) []byte {
+	attrLen := 4 * len(regionIDs)
+	if len(res) < headerLen || attrLen == 0 || attrLen > 1024 {
+		return res
+	}
+	res = appendU16(res, attrRegionHint)
+	res = appendU16(res, uint16(attrLen))
+	for _, id := range regionIDs {
+		res = appendU32(res, uint32(id))
+	}
+	binary.BigEndian.PutUint16(res[2:4], uint16(len(res)-headerLen))
+	return res
+}
+
+// ParseRegionHint returns the DERP region IDs suggested by the server in b, a
+// binding response, or nil if it has none.
+func ParseRegionHint(b []byte) []int {
+	if !Is(b) {
+		return nil
+	}
+	attrsLen := int(binary.BigEndian.Uint16(b[2:4]))
+	b = b[headerLen:]
+	if attrsLen > len(b) {
+		return nil
+	}
+	var ids []int
+	foreachAttr(b[:attrsLen], func(attrType uint16, a []byte) error {
+		if attrType != attrRegionHint {
+			return nil
+		}
+		for ; len(a) >= 4; a = a[4:] {
+			ids = append(ids, int(binary.BigEndian.Uint32(a)))
+		}
+		return nil
+	})
+	return ids
+}
+
+// AppendOtherAddress appends to res, a binding response generated by
+// Response, the OTHER-ADDRESS attribute of RFC 5780 Section 7.4,
+// advertising other as the server's alternate address: the one it
+// responds from when asked to change both its IP address and port. A
+// server with only an alternate port advertises its own IP address.
+// It returns res unmodified if other isn't valid.
+func AppendOtherAddress(res []byte, other netip.AddrPort) []byte {
+	addr := other.Addr()
+	var fam byte
+	switch {
+	case addr.Is4():
+		fam = 1
+	case addr.Is6():
+		fam = 2
+	default:
+		return res
+	}
+	if len(res) < headerLen {
+		return res
+	}
+	res = appendU16(res, attrOtherAddress)
+	res = appendU16(res, uint16(4+addr.BitLen()/8))
+	res = append(res, 0, fam)
+	res = appendU16(res, other.Port())
+	res = append(res, addr.AsSlice()...)
+	binary.BigEndian.PutUint16(res[2:4], uint16(len(res)-headerLen))
+	return res
+}
+
+// ParseOtherAddress returns the alternate address advertised by the server
+// in b, a binding response, or the zero value if it has none and so doesn't
+// support RFC 5780 NAT behavior discovery.
+func ParseOtherAddress(b []byte) netip.AddrPort {
+	if !Is(b) {
+		return netip.AddrPort{}
+	}
+	attrsLen := int(binary.BigEndian.Uint16(b[2:4]))
+	b = b[headerLen:]
+	if attrsLen > len(b) {
+		return netip.AddrPort{}
+	}
+	var other netip.AddrPort
+	foreachAttr(b[:attrsLen], func(attrType uint16, a []byte) error {
+		if attrType != attrOtherAddress {
+			return nil
+		}
+		ipSlice, port, err := mappedAddress(a)
+		if err != nil {
+			return nil
+		}
+		if ip, ok := netip.AddrFromSlice(ipSlice); ok {
+			other = netip.AddrPortFrom(ip.Unmap(), port)
+		}
+		return nil
+	})
+	return other
+}
+
 // ParseResponse parses a successful binding response STUN packet.
 // The IP address is extracted from the XOR-MAPPED-ADDRESS attribute.
 func ParseResponse(b []byte) (tID TxID, addr netip.AddrPort, err error) {
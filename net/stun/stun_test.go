@@ -8,6 +8,7 @@
 	"encoding/hex"
 	"fmt"
 	"net/netip"
+	"reflect"
 	"testing"
 
 	"tailscale.com/net/stun"
@@ -283,3 +284,68 @@ func TestResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestRegionHint(t *testing.T) {
+	addr := netip.MustParseAddrPort("1.2.3.4:567")
+	res := stun.Response(stun.NewTxID(), addr)
+	if got := stun.ParseRegionHint(res); got != nil {
+		t.Errorf("hint in plain response = %v; want nil", got)
+	}
+	res = stun.AppendRegionHint(res, []int{1, 900})
+	if got, want := stun.ParseRegionHint(res), []int{1, 900}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRegionHint = %v; want %v", got, want)
+	}
+	// Clients that don't know about the hint still parse the response.
+	if _, got, err := stun.ParseResponse(res); err != nil || got != addr {
+		t.Errorf("ParseResponse = %v, %v; want %v", got, err, addr)
+	}
+}
+
+func TestChangeRequest(t *testing.T) {
+	for _, tt := range []struct{ changeIP, changePort bool }{
+		{false, false},
+		{false, true},
+		{true, false},
+		{true, true},
+	} {
+		tx := stun.NewTxID()
+		req := stun.ChangeRequest(tx, tt.changeIP, tt.changePort)
+		gotTx, err := stun.ParseBindingRequest(req)
+		if err != nil {
+			t.Fatalf("%+v: %v", tt, err)
+		}
+		if gotTx != tx {
+			t.Errorf("%+v: txID = %x; want %x", tt, gotTx, tx)
+		}
+		if ip, port := stun.ParseChangeRequest(req); ip != tt.changeIP || port != tt.changePort {
+			t.Errorf("%+v: ParseChangeRequest = %v, %v", tt, ip, port)
+		}
+	}
+	if ip, port := stun.ParseChangeRequest(stun.Request(stun.NewTxID())); ip || port {
+		t.Errorf("plain request: ParseChangeRequest = %v, %v; want false, false", ip, port)
+	}
+}
+
+func TestOtherAddress(t *testing.T) {
+	addr := netip.MustParseAddrPort("1.2.3.4:567")
+	for _, other := range []netip.AddrPort{
+		netip.MustParseAddrPort("5.6.7.8:3479"),
+		netip.MustParseAddrPort("[2001:db8::1]:3479"),
+	} {
+		res := stun.Response(stun.NewTxID(), addr)
+		if got := stun.ParseOtherAddress(res); got.IsValid() {
+			t.Errorf("other address in plain response = %v; want none", got)
+		}
+		res = stun.AppendRegionHint(res, []int{1})
+		res = stun.AppendOtherAddress(res, other)
+		if got := stun.ParseOtherAddress(res); got != other {
+			t.Errorf("ParseOtherAddress = %v; want %v", got, other)
+		}
+		if got := stun.ParseRegionHint(res); !reflect.DeepEqual(got, []int{1}) {
+			t.Errorf("ParseRegionHint = %v; want [1]", got)
+		}
+		if _, got, err := stun.ParseResponse(res); err != nil || got != addr {
+			t.Errorf("ParseResponse = %v, %v; want %v", got, err, addr)
+		}
+	}
+}
@@ -45,15 +45,74 @@ func ServeWithPacketListener(t testing.TB, ln nettype.PacketListener) (addr *net
 		addr.IP = net.ParseIP("127.0.0.1")
 	}
 	doneCh := make(chan struct{})
-	go runSTUN(t, pc.(nettype.PacketConn), &stats, doneCh)
+	conns := &stunConns{{pc.(nettype.PacketConn)}}
+	go runSTUN(t, conns, 0, 0, &stats, doneCh)
 	return addr, func() {
 		pc.Close()
 		<-doneCh
 	}
 }
 
-func runSTUN(t testing.TB, pc nettype.PacketConn, stats *stunStats, done chan<- struct{}) {
+// stunConns are the sockets of a STUN test server, indexed by whether
+// they're bound to its alternate IP and to its alternate port. Only
+// [0][0] is non-nil for servers that don't do RFC 5780 NAT behavior
+// discovery.
+type stunConns [2][2]nettype.PacketConn
+
+// ServeNATBehavior is like Serve, but the server also answers the change
+// requests of RFC 5780 NAT behavior discovery, from an alternate port on
+// 127.0.0.1 and from both ports on the alternate IP 127.0.0.2. It skips
+// the test if it can't listen on 127.0.0.2, as on macOS.
+func ServeNATBehavior(t testing.TB) (addr *net.UDPAddr, cleanupFn func()) {
+	t.Helper()
+
+	var conns stunConns
+	closeAll := func() {
+		for _, row := range conns {
+			for _, pc := range row {
+				if pc != nil {
+					pc.Close()
+				}
+			}
+		}
+	}
+	listen := func(ip string, port int) nettype.PacketConn {
+		pc, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP(ip), Port: port})
+		if err != nil {
+			closeAll()
+			if ip != "127.0.0.1" {
+				t.Skipf("can't listen on alternate IP %v: %v", ip, err)
+			}
+			t.Fatalf("failed to open STUN listener: %v", err)
+		}
+		return pc
+	}
+	conns[0][0] = listen("127.0.0.1", 0)
+	conns[0][1] = listen("127.0.0.1", 0)
+	conns[1][0] = listen("127.0.0.2", conns[0][0].LocalAddr().(*net.UDPAddr).Port)
+	conns[1][1] = listen("127.0.0.2", conns[0][1].LocalAddr().(*net.UDPAddr).Port)
+
+	var stats stunStats
+	var dones []chan struct{}
+	for i := range conns {
+		for j := range conns[i] {
+			done := make(chan struct{})
+			dones = append(dones, done)
+			go runSTUN(t, &conns, i, j, &stats, done)
+		}
+	}
+	return conns[0][0].LocalAddr().(*net.UDPAddr), func() {
+		closeAll()
+		for _, done := range dones {
+			<-done
+		}
+	}
+}
+
+// runSTUN serves STUN requests on conns[ip][port] until it's closed.
+func runSTUN(t testing.TB, conns *stunConns, ip, port int, stats *stunStats, done chan<- struct{}) {
 	defer close(done)
+	pc := conns[ip][port]
 
 	var buf [64 << 10]byte
 	for {
@@ -84,12 +143,25 @@ func runSTUN(t testing.TB, pc nettype.PacketConn, stats *stunStats, done chan<-
 		stats.mu.Unlock()
 
 		res := stun.Response(txid, src)
-		if _, err := pc.WriteToUDPAddrPort(res, src); err != nil {
+		out := pc
+		if other := conns[ip^1][port^1]; other != nil {
+			res = stun.AppendOtherAddress(res, netaddr.Unmap(other.LocalAddr().(*net.UDPAddr).AddrPort()))
+			changeIP, changePort := stun.ParseChangeRequest(pkt)
+			out = conns[ip^b2i(changeIP)][port^b2i(changePort)]
+		}
+		if _, err := out.WriteToUDPAddrPort(res, src); err != nil {
 			t.Logf("STUN server write failed: %v", err)
 		}
 	}
 }
 
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func DERPMapOf(stun ...string) *tailcfg.DERPMap {
 	m := &tailcfg.DERPMap{
 		Regions: map[int]*tailcfg.DERPRegion{},
@@ -40,6 +40,7 @@ func CGNATRange() netip.Prefix {
 	ulaRange     oncePrefix
 	tsUlaRange   oncePrefix
 	tsViaRange   oncePrefix
+	nat64Range   oncePrefix
 	ula4To6Range oncePrefix
 	ulaEph6Range oncePrefix
 	serviceIPv6  oncePrefix
@@ -91,6 +92,13 @@ func TailscaleViaRange() netip.Prefix {
 	return tsViaRange.v
 }
 
+// NAT64Range returns the NAT64 Well-Known Prefix, 64:ff9b::/96, in which
+// IPv4 addresses are embedded for IPv6-only clients (RFC 6052).
+func NAT64Range() netip.Prefix {
+	nat64Range.Do(func() { mustPrefix(&nat64Range.v, "64:ff9b::/96") })
+	return nat64Range.v
+}
+
 // Tailscale4To6Range returns the subset of TailscaleULARange used for
 // auto-translated Tailscale ipv4 addresses.
 func Tailscale4To6Range() netip.Prefix {
@@ -299,6 +307,46 @@ func UnmapVia(ip netip.Addr) netip.Addr {
 	return ip
 }
 
+// IsNAT64Prefix reports whether p is a CIDR in the NAT64 Well-Known Prefix.
+// See NAT64Range.
+func IsNAT64Prefix(p netip.Prefix) bool {
+	return NAT64Range().Contains(p.Addr())
+}
+
+// UnmapNAT64 returns the IPv4 address embedded in the NAT64 address ip.
+//
+// If ip is not in NAT64Range, it returns ip unchanged.
+func UnmapNAT64(ip netip.Addr) netip.Addr {
+	if NAT64Range().Contains(ip) {
+		a := ip.As16()
+		return netip.AddrFrom4(*(*[4]byte)(a[12:16]))
+	}
+	return ip
+}
+
+// MapNAT64 returns the IPv6 route in NAT64Range for an IPv4 CIDR.
+func MapNAT64(v4 netip.Prefix) (netip.Prefix, error) {
+	if !v4.Addr().Is4() {
+		return netip.Prefix{}, errors.New("want IPv4 CIDR")
+	}
+	a := NAT64Range().Addr().As16()
+	ip4a := v4.Addr().As4()
+	copy(a[12:], ip4a[:])
+	return netip.PrefixFrom(netip.AddrFrom16(a), v4.Bits()+96), nil
+}
+
+// UnmapViaPrefix returns the site ID and IPv4 CIDR that the Tailscale "via"
+// route via maps, as MapVia does. ok is false if via isn't a via route of
+// an IPv4 CIDR.
+func UnmapViaPrefix(via netip.Prefix) (siteID uint32, v4 netip.Prefix, ok bool) {
+	if !IsViaPrefix(via) || via.Bits() < 96 {
+		return 0, netip.Prefix{}, false
+	}
+	a := via.Addr().As16()
+	siteID = binary.BigEndian.Uint32(a[8:12])
+	return siteID, netip.PrefixFrom(UnmapVia(via.Addr()), via.Bits()-96), true
+}
+
 // MapVia returns an IPv6 "via" route for an IPv4 CIDR in a given siteID.
 func MapVia(siteID uint32, v4 netip.Prefix) (via netip.Prefix, err error) {
 	if !v4.Addr().Is4() {
@@ -118,3 +118,49 @@ func TestUnmapVia(t *testing.T) {
 		}
 	}
 }
+
+func TestNAT64(t *testing.T) {
+	for _, tt := range []struct {
+		ip   string
+		want string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"64:ff9b::10.2.1.3", "10.2.1.3"},
+		{"64:ff9b:1::10.2.1.3", "64:ff9b:1::a02:103"},
+	} {
+		if got := UnmapNAT64(netip.MustParseAddr(tt.ip)).String(); got != tt.want {
+			t.Errorf("UnmapNAT64(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	got, err := MapNAT64(netip.MustParsePrefix("10.2.0.0/16"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParsePrefix("64:ff9b::a02:0/112"); got != want {
+		t.Errorf("MapNAT64 = %v, want %v", got, want)
+	}
+	if !IsNAT64Prefix(got) {
+		t.Errorf("IsNAT64Prefix(%v) = false", got)
+	}
+	if _, err := MapNAT64(netip.MustParsePrefix("fd00::/64")); err == nil {
+		t.Error("MapNAT64 of IPv6 prefix succeeded")
+	}
+}
+
+func TestUnmapViaPrefix(t *testing.T) {
+	v4 := netip.MustParsePrefix("10.1.0.0/16")
+	via, err := MapVia(7, v4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	siteID, got, ok := UnmapViaPrefix(via)
+	if !ok || siteID != 7 || got != v4 {
+		t.Errorf("UnmapViaPrefix(%v) = %v, %v, %v; want 7, %v, true", via, siteID, got, ok, v4)
+	}
+	for _, p := range []string{"10.1.0.0/16", "fd7a:115c:a1e0:b1a::/64", "fd00::/112"} {
+		if _, _, ok := UnmapViaPrefix(netip.MustParsePrefix(p)); ok {
+			t.Errorf("UnmapViaPrefix(%v) ok", p)
+		}
+	}
+}
@@ -33,14 +33,18 @@
 // Extension, none), user-selected route acceptance prefs, etc.
 type Dialer struct {
 	Logf logger.Logf
-	// UseNetstackForIP if non-nil is whether NetstackDialTCP (if
-	// it's non-nil) should be used to dial the provided IP.
+	// UseNetstackForIP if non-nil is whether NetstackDialTCP or
+	// NetstackDialUDP (if non-nil) should be used to dial the provided IP.
 	UseNetstackForIP func(netip.Addr) bool
 
 	// NetstackDialTCP dials the provided IPPort using netstack.
 	// If nil, it's not used.
 	NetstackDialTCP func(context.Context, netip.AddrPort) (net.Conn, error)
 
+	// NetstackDialUDP dials the provided IPPort over UDP using netstack.
+	// If nil, UDP dials that would use netstack fail.
+	NetstackDialUDP func(context.Context, netip.AddrPort) (net.Conn, error)
+
 	peerClientOnce sync.Once
 	peerClient     *http.Client
 
@@ -76,6 +80,12 @@ func (c sysConn) Close() error {
 	return nil
 }
 
+// NetConn returns the underlying connection, for callers that need to set
+// socket options on it such as TCP keep-alives.
+func (c sysConn) NetConn() net.Conn {
+	return c.Conn
+}
+
 // SetTUNName sets the name of the tun device in use ("tailscale0", "utun6",
 // etc). This is needed on some platforms to set sockopts to bind
 // to the same interface index.
@@ -344,6 +354,12 @@ func (d *Dialer) UserDial(ctx context.Context, network, addr string) (net.Conn,
 		return nil, err
 	}
 	if d.UseNetstackForIP != nil && d.UseNetstackForIP(ipp.Addr()) {
+		if strings.HasPrefix(network, "udp") {
+			if d.NetstackDialUDP == nil {
+				return nil, errors.New("UDP dials not supported by this Dialer")
+			}
+			return d.NetstackDialUDP(ctx, ipp)
+		}
 		if d.NetstackDialTCP == nil {
 			return nil, errors.New("Dialer not initialized correctly")
 		}
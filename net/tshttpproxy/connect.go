@@ -0,0 +1,225 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Connect asks the HTTP proxy at proxyURL to open a tunnel to target (a
+// host:port) with a CONNECT request over conn, an established connection to
+// the proxy. On success, conn is connected to target.
+//
+// It authenticates as GetAuthHeader does or, if the proxy configuration
+// says to use NTLM (see Config.Auth), with an NTLM handshake over conn.
+func Connect(ctx context.Context, conn net.Conn, proxyURL *url.URL, target string) error {
+	if d, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(d)
+		defer conn.SetDeadline(time.Time{})
+	}
+	br := bufio.NewReader(conn)
+
+	var res *http.Response
+	var err error
+	if user, pass, ok := proxyUser(proxyURL); useNTLM() && ok {
+		res, err = sendConnect(conn, br, target, "NTLM "+base64.StdEncoding.EncodeToString(ntlmNegotiate()))
+		if err != nil {
+			return err
+		}
+		if res.StatusCode == http.StatusProxyAuthRequired {
+			challenge, err := ntlmChallengeFromResponse(res)
+			if err != nil {
+				return fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+			}
+			msg, err := ntlmAuthenticate(challenge, user, pass)
+			if err != nil {
+				return fmt.Errorf("proxy %s: %w", proxyURL.Redacted(), err)
+			}
+			res, err = sendConnect(conn, br, target, "NTLM "+base64.StdEncoding.EncodeToString(msg))
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		auth, err := GetAuthHeader(proxyURL)
+		if err != nil {
+			log.Printf("failed to get proxy Auth header for %v; ignoring: %v", proxyURL.Redacted(), err)
+		}
+		res, err = sendConnect(conn, br, target, auth)
+		if err != nil {
+			return err
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid response status from HTTP proxy %s on CONNECT to %s: %v", proxyURL.Redacted(), target, res.Status)
+	}
+	if br.Buffered() > 0 {
+		// conn is handed back to the caller without br, so nothing
+		// may have been read past the response.
+		return fmt.Errorf("HTTP proxy %s sent unexpected data after CONNECT response", proxyURL.Redacted())
+	}
+	return nil
+}
+
+// sendConnect sends a CONNECT request for target, with the given
+// Proxy-Authorization header if non-empty, and reads the response.
+//
+// The body of an unsuccessful response is read and discarded, so that the
+// connection can be used for another request.
+func sendConnect(conn net.Conn, br *bufio.Reader, target, auth string) (*http.Response, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if auth != "" {
+		req.Header.Set(proxyAuthHeader, auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("writing CONNECT request: %w", err)
+	}
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, fmt.Errorf("reading CONNECT response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+		res.Body.Close()
+	}
+	return res, nil
+}
+
+// ntlmChallengeFromResponse returns the NTLM challenge message in a 407
+// response to an NTLM negotiate message.
+func ntlmChallengeFromResponse(res *http.Response) ([]byte, error) {
+	if res.Close {
+		return nil, errors.New("NTLM authentication failed: proxy closed the connection")
+	}
+	for _, v := range res.Header.Values("Proxy-Authenticate") {
+		if rest, ok := strings.CutPrefix(v, "NTLM "); ok {
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(rest))
+		}
+	}
+	return nil, errors.New("proxy doesn't support NTLM authentication")
+}
+
+// SetTransportProxyDialer makes tr connect through proxies itself, with
+// Connect, when the proxy configuration requires authentication that
+// http.Transport can't do on its own (currently, NTLM). Otherwise tr uses
+// proxies as usual.
+//
+// It must be called after tr's Proxy, DialContext and DialTLSContext fields
+// are set. DialContext is used to dial proxies and, after Connect, TLS is
+// done with tr.TLSClientConfig.
+func SetTransportProxyDialer(tr *http.Transport) {
+	proxy := tr.Proxy
+	if proxy == nil {
+		return
+	}
+	dial := tr.DialContext
+	if dial == nil {
+		var d net.Dialer
+		dial = d.DialContext
+	}
+	dialTLS := tr.DialTLSContext
+
+	// proxyFor returns the proxy to tunnel to addr with, if Connect is
+	// needed for it.
+	proxyFor := func(scheme, addr string) *url.URL {
+		if !useNTLM() {
+			return nil
+		}
+		u, err := proxy(&http.Request{URL: &url.URL{Scheme: scheme, Host: addr}})
+		if err != nil {
+			return nil
+		}
+		return u
+	}
+	dialProxy := func(ctx context.Context, network string, u *url.URL, addr string) (net.Conn, error) {
+		proxyAddr := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			proxyAddr = net.JoinHostPort(u.Hostname(), port)
+		}
+		conn, err := dial(ctx, network, proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme == "https" {
+			tc := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+			if err := tc.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			conn = tc
+		}
+		if err := Connect(ctx, conn, u, addr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	tr.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if u != nil && useNTLM() {
+			// Dialed below instead.
+			return nil, nil
+		}
+		return u, err
+	}
+	tr.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if u := proxyFor("http", addr); u != nil {
+			return dialProxy(ctx, network, u, addr)
+		}
+		return dial(ctx, network, addr)
+	}
+	tr.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		u := proxyFor("https", addr)
+		if u == nil && dialTLS != nil {
+			return dialTLS(ctx, network, addr)
+		}
+		var conn net.Conn
+		var err error
+		if u != nil {
+			conn, err = dialProxy(ctx, network, u, addr)
+		} else {
+			conn, err = dial(ctx, network, addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var cfg *tls.Config
+		if tr.TLSClientConfig != nil {
+			cfg = tr.TLSClientConfig.Clone()
+		} else {
+			cfg = new(tls.Config)
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tc := tls.Client(conn, cfg)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tc, nil
+	}
+}
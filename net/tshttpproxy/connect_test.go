@@ -0,0 +1,207 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ntlmProxy is a CONNECT proxy for tests that requires NTLM authentication
+// as user `CORP\alice` with password "hunter2".
+type ntlmProxy struct {
+	ln      net.Listener
+	tunnels atomic.Int32
+}
+
+var ntlmProxyTargetInfo = []byte{2, 0, 4, 0, 'C', 0, 'O', 0, 0, 0, 0, 0}
+
+func newNTLMProxy(t *testing.T) *ntlmProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &ntlmProxy{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(t, c)
+		}
+	}()
+	return p
+}
+
+func (p *ntlmProxy) URL() *url.URL {
+	return &url.URL{Scheme: "http", Host: p.ln.Addr().String()}
+}
+
+func (p *ntlmProxy) serve(t *testing.T, c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != "CONNECT" {
+			fmt.Fprintf(c, "HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		auth, _ := strings.CutPrefix(req.Header.Get("Proxy-Authorization"), "NTLM ")
+		msg, _ := base64.StdEncoding.DecodeString(auth)
+		switch {
+		case len(msg) > 12 && msg[8] == 1:
+			// Include a body, which the client must skip.
+			chal := base64.StdEncoding.EncodeToString(testNTLMChallenge(ntlmProxyTargetInfo))
+			fmt.Fprintf(c, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM %s\r\nContent-Length: 5\r\n\r\nnope!", chal)
+			continue
+		case len(msg) > 12 && msg[8] == 3:
+			if err := checkNTLMAuthenticate(t, msg); err != nil {
+				t.Errorf("proxy: %v", err)
+				fmt.Fprintf(c, "HTTP/1.1 407 Proxy Authentication Required\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+				return
+			}
+		default:
+			fmt.Fprintf(c, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: NTLM\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			fmt.Fprintf(c, "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		defer target.Close()
+		p.tunnels.Add(1)
+		fmt.Fprintf(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(target, br)
+		io.Copy(c, target)
+		return
+	}
+}
+
+func checkNTLMAuthenticate(t *testing.T, msg []byte) error {
+	_, nt, domain, user := parseNTLMAuthenticate(t, msg)
+	if domain != "CORP" || user != "alice" {
+		return fmt.Errorf("domain, user = %q, %q", domain, user)
+	}
+	if len(nt) < 40 {
+		return fmt.Errorf("short NT response")
+	}
+	var serverChallenge, clientChallenge [8]byte
+	copy(serverChallenge[:], "chalnge!")
+	copy(clientChallenge[:], nt[32:40])
+	ts := binary.LittleEndian.Uint64(nt[24:32])
+	want := ntlmv2Response(ntowfv2("alice", "hunter2", "CORP"), serverChallenge, clientChallenge, ts, ntlmProxyTargetInfo)
+	if !bytes.Equal(nt, want) {
+		return fmt.Errorf("wrong NT response")
+	}
+	return nil
+}
+
+func setNTLMConfig(t *testing.T, user string) {
+	if err := SetConfig(t.Logf, &Config{Auth: AuthNTLM, User: user}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetConfig(t.Logf, nil) })
+}
+
+func TestConnectNTLM(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer backend.Close()
+	proxy := newNTLMProxy(t)
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", proxy.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	target := backend.Listener.Addr().String()
+
+	setNTLMConfig(t, `CORP\alice:hunter2`)
+	c := dial()
+	if err := Connect(ctx, c, proxy.URL(), target); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", target)
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(res.Body); string(body) != "hello" {
+		t.Errorf("body = %q; want hello", body)
+	}
+
+	// Credentials in the proxy URL take precedence over Config.User.
+	setNTLMConfig(t, `CORP\alice:wrong`)
+	u := proxy.URL()
+	u.User = url.UserPassword(`CORP\alice`, "hunter2")
+	if err := Connect(ctx, dial(), u, target); err != nil {
+		t.Errorf("Connect with URL credentials: %v", err)
+	}
+
+	// Without NTLM configured, the proxy rejects the request.
+	SetConfig(t.Logf, nil)
+	if err := Connect(ctx, dial(), proxy.URL(), target); err == nil || !strings.Contains(err.Error(), "407") {
+		t.Errorf("Connect without NTLM = %v; want 407 error", err)
+	}
+}
+
+func TestSetTransportProxyDialer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	tlsSrv := httptest.NewTLSServer(handler)
+	defer tlsSrv.Close()
+	proxy := newNTLMProxy(t)
+	setNTLMConfig(t, `CORP\alice:hunter2`)
+
+	tr := &http.Transport{
+		Proxy:           http.ProxyURL(proxy.URL()),
+		TLSClientConfig: tlsSrv.Client().Transport.(*http.Transport).TLSClientConfig,
+	}
+	defer tr.CloseIdleConnections()
+	SetTransportProxyDialer(tr)
+	c := &http.Client{Transport: tr, Timeout: 10 * time.Second}
+	for _, u := range []string{plain.URL, tlsSrv.URL} {
+		res, err := c.Get(u)
+		if err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "hello" {
+			t.Errorf("GET %s = %q; want hello", u, body)
+		}
+	}
+	if n := proxy.tunnels.Load(); n != 2 {
+		t.Errorf("proxy tunnels = %d; want 2", n)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/md4"
+)
+
+// This file implements the client side of NTLMv2 authentication
+// ([MS-NLMP]), as used by proxies with "Proxy-Authenticate: NTLM".
+
+var ntlmSignature = []byte("NTLMSSP\x00")
+
+const (
+	ntlmNegotiateUnicode          = 0x00000001
+	ntlmNegotiateOEM              = 0x00000002
+	ntlmRequestTarget             = 0x00000004
+	ntlmNegotiateNTLM             = 0x00000200
+	ntlmNegotiateAlwaysSign       = 0x00008000
+	ntlmNegotiateExtendedSecurity = 0x00080000
+	ntlmNegotiateTargetInfo       = 0x00800000
+
+	ntlmFlags = ntlmNegotiateUnicode | ntlmNegotiateOEM | ntlmRequestTarget | ntlmNegotiateNTLM |
+		ntlmNegotiateAlwaysSign | ntlmNegotiateExtendedSecurity | ntlmNegotiateTargetInfo
+)
+
+// ntlmAvTimestamp is the AvId of the MsvAvTimestamp AV_PAIR in a
+// challenge's target info.
+const ntlmAvTimestamp = 7
+
+// ntlmNegotiate returns an NTLM NEGOTIATE_MESSAGE, the first message of the
+// handshake.
+func ntlmNegotiate() []byte {
+	b := make([]byte, 32)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 1)
+	binary.LittleEndian.PutUint32(b[12:], ntlmFlags)
+	// The domain and workstation fields are left empty.
+	return b
+}
+
+// ntlmChallenge is the part of a CHALLENGE_MESSAGE needed to answer it.
+type ntlmChallenge struct {
+	serverChallenge [8]byte
+	targetInfo      []byte
+}
+
+func parseNTLMChallenge(b []byte) (*ntlmChallenge, error) {
+	if len(b) < 48 || !bytes.Equal(b[:8], ntlmSignature) || binary.LittleEndian.Uint32(b[8:]) != 2 {
+		return nil, errors.New("invalid NTLM challenge message")
+	}
+	c := new(ntlmChallenge)
+	copy(c.serverChallenge[:], b[24:32])
+	n := int(binary.LittleEndian.Uint16(b[40:]))
+	off := int(binary.LittleEndian.Uint32(b[44:]))
+	if off > len(b) || n > len(b)-off {
+		return nil, errors.New("invalid NTLM challenge message target info")
+	}
+	c.targetInfo = b[off : off+n]
+	return c, nil
+}
+
+// ntlmAuthenticate returns the NTLM AUTHENTICATE_MESSAGE answering the
+// CHALLENGE_MESSAGE challenge with NTLMv2 for user, which may be of the form
+// DOMAIN\user.
+func ntlmAuthenticate(challenge []byte, user, password string) ([]byte, error) {
+	c, err := parseNTLMChallenge(challenge)
+	if err != nil {
+		return nil, err
+	}
+	var domain string
+	if d, u, ok := strings.Cut(user, `\`); ok {
+		domain, user = d, u
+	}
+	var clientChallenge [8]byte
+	if _, err := rand.Read(clientChallenge[:]); err != nil {
+		return nil, err
+	}
+	ts, ok := ntlmTargetTimestamp(c.targetInfo)
+	if !ok {
+		ts = ntlmTime(time.Now())
+	}
+	key := ntowfv2(user, password, domain)
+	nt := ntlmv2Response(key, c.serverChallenge, clientChallenge, ts, c.targetInfo)
+	lm := lmv2Response(key, c.serverChallenge, clientChallenge)
+
+	fields := [][]byte{lm, nt, ntlmUTF16(domain), ntlmUTF16(user), nil, nil}
+	const payloadOffset = 64
+	b := make([]byte, payloadOffset)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 3)
+	// LmChallengeResponse, NtChallengeResponse, DomainName, UserName,
+	// Workstation and EncryptedRandomSessionKey, each described by a
+	// length, max length and offset into the payload.
+	for i, f := range fields {
+		hdr := b[12+8*i:]
+		binary.LittleEndian.PutUint16(hdr[0:], uint16(len(f)))
+		binary.LittleEndian.PutUint16(hdr[2:], uint16(len(f)))
+		binary.LittleEndian.PutUint32(hdr[4:], uint32(len(b)))
+		b = append(b, f...)
+	}
+	binary.LittleEndian.PutUint32(b[60:], ntlmFlags)
+	return b, nil
+}
+
+// ntowfv2 returns the NTLMv2 response key for the given credentials.
+func ntowfv2(user, password, domain string) []byte {
+	h := md4.New()
+	h.Write(ntlmUTF16(password))
+	mac := hmac.New(md5.New, h.Sum(nil))
+	mac.Write(ntlmUTF16(strings.ToUpper(user) + domain))
+	return mac.Sum(nil)
+}
+
+// ntlmv2Response returns the NtChallengeResponse: the NTProofStr followed
+// by the client's blob it covers.
+func ntlmv2Response(key []byte, serverChallenge, clientChallenge [8]byte, ts uint64, targetInfo []byte) []byte {
+	temp := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	temp = binary.LittleEndian.AppendUint64(temp, ts)
+	temp = append(temp, clientChallenge[:]...)
+	temp = append(temp, 0, 0, 0, 0)
+	temp = append(temp, targetInfo...)
+	temp = append(temp, 0, 0, 0, 0)
+
+	mac := hmac.New(md5.New, key)
+	mac.Write(serverChallenge[:])
+	mac.Write(temp)
+	return append(mac.Sum(nil), temp...)
+}
+
+// lmv2Response returns the LmChallengeResponse.
+func lmv2Response(key []byte, serverChallenge, clientChallenge [8]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	mac.Write(serverChallenge[:])
+	mac.Write(clientChallenge[:])
+	return append(mac.Sum(nil), clientChallenge[:]...)
+}
+
+// ntlmTargetTimestamp returns the server's timestamp from a challenge's
+// target info, if it has one.
+func ntlmTargetTimestamp(info []byte) (uint64, bool) {
+	for len(info) >= 4 {
+		id := binary.LittleEndian.Uint16(info)
+		n := int(binary.LittleEndian.Uint16(info[2:]))
+		if len(info) < 4+n {
+			break
+		}
+		if id == ntlmAvTimestamp && n == 8 {
+			return binary.LittleEndian.Uint64(info[4:]), true
+		}
+		if id == 0 { // MsvAvEOL
+			break
+		}
+		info = info[4+n:]
+	}
+	return 0, false
+}
+
+// ntlmTime returns t as a Windows FILETIME: the number of 100ns intervals
+// since January 1, 1601.
+func ntlmTime(t time.Time) uint64 {
+	const epochDiff = 116444736000000000 // 1601 to 1970, in 100ns
+	return uint64(t.UnixNano()/100) + epochDiff
+}
+
+func ntlmUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		binary.LittleEndian.PutUint16(b[2*i:], c)
+	}
+	return b
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestNTLMv2 checks the NTLMv2 computations against the example in
+// [MS-NLMP] section 4.2.4.
+func TestNTLMv2(t *testing.T) {
+	var serverChallenge, clientChallenge [8]byte
+	copy(serverChallenge[:], mustHex(t, "0123456789abcdef"))
+	copy(clientChallenge[:], mustHex(t, "aaaaaaaaaaaaaaaa"))
+	targetInfo := mustHex(t, "02000c0044006f006d00610069006e0001000c0053006500720076006500720000000000")
+
+	key := ntowfv2("User", "Password", "Domain")
+	if want := mustHex(t, "0c868a403bfd7a93a3001ef22ef02e3f"); !bytes.Equal(key, want) {
+		t.Errorf("ntowfv2 = %x; want %x", key, want)
+	}
+	nt := ntlmv2Response(key, serverChallenge, clientChallenge, 0, targetInfo)
+	if want := mustHex(t, "68cd0ab851e51c96aabc927bebef6a1c"); !bytes.Equal(nt[:16], want) {
+		t.Errorf("NTProofStr = %x; want %x", nt[:16], want)
+	}
+	lm := lmv2Response(key, serverChallenge, clientChallenge)
+	if want := mustHex(t, "86c35097ac9cec102554764a57cccc19aaaaaaaaaaaaaaaa"); !bytes.Equal(lm, want) {
+		t.Errorf("LMv2 response = %x; want %x", lm, want)
+	}
+}
+
+// testNTLMChallenge returns a CHALLENGE_MESSAGE with the given target info.
+func testNTLMChallenge(targetInfo []byte) []byte {
+	b := make([]byte, 48)
+	copy(b, ntlmSignature)
+	binary.LittleEndian.PutUint32(b[8:], 2)
+	copy(b[24:], "chalnge!")
+	binary.LittleEndian.PutUint16(b[40:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint16(b[42:], uint16(len(targetInfo)))
+	binary.LittleEndian.PutUint32(b[44:], 48)
+	return append(b, targetInfo...)
+}
+
+// parseNTLMAuthenticate returns the fields of an AUTHENTICATE_MESSAGE:
+// the LM and NT responses, domain and user.
+func parseNTLMAuthenticate(t testing.TB, b []byte) (lm, nt []byte, domain, user string) {
+	t.Helper()
+	if len(b) < 64 || !bytes.Equal(b[:8], ntlmSignature) || binary.LittleEndian.Uint32(b[8:]) != 3 {
+		t.Fatalf("invalid authenticate message %x", b)
+	}
+	field := func(i int) []byte {
+		hdr := b[12+8*i:]
+		n, off := binary.LittleEndian.Uint16(hdr), binary.LittleEndian.Uint32(hdr[4:])
+		return b[off : off+uint32(n)]
+	}
+	utf16 := func(b []byte) string {
+		var s []rune
+		for i := 0; i+1 < len(b); i += 2 {
+			s = append(s, rune(binary.LittleEndian.Uint16(b[i:])))
+		}
+		return string(s)
+	}
+	return field(0), field(1), utf16(field(2)), utf16(field(3))
+}
+
+func TestNTLMAuthenticate(t *testing.T) {
+	ts := binary.LittleEndian.AppendUint64([]byte{ntlmAvTimestamp, 0, 8, 0}, 12345)
+	targetInfo := append(ts, 0, 0, 0, 0)
+	msg, err := ntlmAuthenticate(testNTLMChallenge(targetInfo), `CORP\alice`, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, nt, domain, user := parseNTLMAuthenticate(t, msg)
+	if domain != "CORP" || user != "alice" {
+		t.Errorf("domain, user = %q, %q; want CORP, alice", domain, user)
+	}
+	if len(lm) != 24 {
+		t.Errorf("LM response length = %d; want 24", len(lm))
+	}
+	// The blob after the NTProofStr carries the server's timestamp and
+	// target info.
+	if got := binary.LittleEndian.Uint64(nt[16+8:]); got != 12345 {
+		t.Errorf("timestamp = %d; want 12345", got)
+	}
+	if !bytes.Contains(nt, targetInfo) {
+		t.Errorf("NT response doesn't include target info")
+	}
+
+	var serverChallenge, clientChallenge [8]byte
+	copy(serverChallenge[:], "chalnge!")
+	copy(clientChallenge[:], nt[16+16:])
+	want := ntlmv2Response(ntowfv2("alice", "hunter2", "CORP"), serverChallenge, clientChallenge, 12345, targetInfo)
+	if !bytes.Equal(nt, want) {
+		t.Errorf("NT response = %x; want %x", nt, want)
+	}
+
+	if _, err := ntlmAuthenticate([]byte("garbage"), "u", "p"); err == nil {
+		t.Error("ntlmAuthenticate accepted an invalid challenge")
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+	"tailscale.com/types/logger"
+)
+
+// This file fetches proxy auto-config (PAC) files, and finds them with
+// WPAD, on platforms where the OS can evaluate PAC files but
+// sysProxyFromEnv doesn't take care of them (see sysSupportsPAC).
+// PAC files are JavaScript, found on the local network, so they're only
+// ever run by the OS's own PAC engine (see evalPACScript), never by this
+// package.
+
+// evalPACScript, if non-nil, evaluates the PAC file src with the OS's PAC
+// engine, returning what its FindProxyForURL function returns for urlStr
+// and host.
+var evalPACScript func(ctx context.Context, src, urlStr, host string) (string, error)
+
+// SupportsPAC reports whether Config.PACURL and Config.AutoDetect are
+// supported on this platform.
+func SupportsPAC() bool {
+	return sysSupportsPAC || evalPACScript != nil
+}
+
+// pacTTL is how long a fetched PAC file, or the absence of one found by
+// auto-detection, is used before fetching it again.
+const pacTTL = 30 * time.Minute
+
+// pacErrTTL is how long a failure to fetch a PAC file is remembered before
+// trying again.
+const pacErrTTL = time.Minute
+
+// maxPACSize is the maximum size of a PAC file.
+const maxPACSize = 1 << 20
+
+var pacCache struct {
+	mu      sync.Mutex
+	key     string // PAC URL, or "auto" for auto-detection
+	src     string // PAC file; empty if none
+	err     error  // fetch error, if any
+	expires time.Time
+}
+
+// invalidatePACCache forgets the cached PAC file, so that it's fetched
+// again (and on auto-detection, looked for again) on next use.
+func invalidatePACCache() {
+	pacCache.mu.Lock()
+	defer pacCache.mu.Unlock()
+	pacCache.expires = time.Time{}
+}
+
+// pacProxyFromConfig returns the proxy to use for req according to the PAC
+// file at pacURL or, if pacURL is empty and autoDetect is set, the one
+// found by WPAD. It returns nil for a direct connection.
+func pacProxyFromConfig(logf logger.Logf, req *http.Request, pacURL string, autoDetect bool) (*url.URL, error) {
+	if evalPACScript == nil {
+		return nil, nil
+	}
+	src, err := getPACScript(logf, pacURL, autoDetect)
+	if err != nil || src == "" {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), 5*time.Second)
+	defer cancel()
+	res, err := evalPACScript(ctx, src, pacURLArg(req.URL), req.URL.Hostname())
+	if err != nil {
+		return nil, fmt.Errorf("evaluating PAC file: %w", err)
+	}
+	return parsePACResult(res)
+}
+
+// pacURLArg returns the url argument to pass to FindProxyForURL for u.
+// Like browsers, it strips the path and query of https URLs, which
+// aren't visible to a proxy anyway.
+func pacURLArg(u *url.URL) string {
+	if u.Scheme == "https" {
+		return "https://" + u.Host + "/"
+	}
+	return u.String()
+}
+
+func getPACScript(logf logger.Logf, pacURL string, autoDetect bool) (string, error) {
+	key := pacURL
+	if key == "" {
+		if !autoDetect {
+			return "", nil
+		}
+		key = "auto"
+	}
+	pacCache.mu.Lock()
+	defer pacCache.mu.Unlock()
+	if pacCache.key == key && time.Now().Before(pacCache.expires) {
+		return pacCache.src, pacCache.err
+	}
+
+	var src string
+	var err error
+	if pacURL != "" {
+		src, err = fetchPACScript(pacURL)
+	} else {
+		for _, u := range wpadURLs() {
+			if src, err = fetchPACScript(u); err == nil {
+				logf("tshttpproxy: found PAC file at %s", u)
+				break
+			}
+		}
+		if src == "" {
+			// Not finding one isn't an error; there's just no proxy.
+			err = nil
+		}
+	}
+	pacCache.key = key
+	pacCache.src, pacCache.err = src, err
+	if err != nil {
+		logf("tshttpproxy: %v", err)
+		pacCache.expires = time.Now().Add(pacErrTTL)
+	} else {
+		pacCache.expires = time.Now().Add(pacTTL)
+	}
+	return src, err
+}
+
+// pacHTTPClient fetches PAC files. It never uses a proxy itself.
+var pacHTTPClient = &http.Client{
+	Transport: &http.Transport{Proxy: nil},
+	Timeout:   10 * time.Second,
+}
+
+func fetchPACScript(pacURL string) (string, error) {
+	res, err := pacHTTPClient.Get(pacURL)
+	if err != nil {
+		return "", fmt.Errorf("fetching PAC file: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching PAC file %s: %v", pacURL, res.Status)
+	}
+	src, err := io.ReadAll(io.LimitReader(res.Body, maxPACSize+1))
+	if err != nil {
+		return "", fmt.Errorf("fetching PAC file %s: %w", pacURL, err)
+	}
+	if len(src) > maxPACSize {
+		return "", fmt.Errorf("PAC file %s is too large", pacURL)
+	}
+	if !strings.Contains(string(src), "FindProxyForURL") {
+		return "", fmt.Errorf("PAC file %s has no FindProxyForURL function", pacURL)
+	}
+	return string(src), nil
+}
+
+// resolvConfPath is the resolv.conf whose search domains are used for
+// WPAD auto-detection.
+var resolvConfPath = "/etc/resolv.conf"
+
+// wpadURLs returns the URLs at which to look for a PAC file with WPAD's DNS
+// method: wpad.<domain>/wpad.dat for each of the system's search domains
+// and their parents, down to the registrable domain. It never goes past
+// that to a public suffix such as co.uk, where anyone could register the
+// wpad name and serve a PAC file sending all traffic to their proxy.
+func wpadURLs() []string {
+	b, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return nil
+	}
+	var urls []string
+	seen := map[string]bool{}
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 || (f[0] != "search" && f[0] != "domain") {
+			continue
+		}
+		for _, d := range f[1:] {
+			d = strings.TrimSuffix(strings.ToLower(d), ".")
+			registrable, err := publicsuffix.EffectiveTLDPlusOne(d)
+			if err != nil {
+				// d is itself a public suffix.
+				continue
+			}
+			for {
+				if !seen[d] {
+					seen[d] = true
+					urls = append(urls, "http://wpad."+d+"/wpad.dat")
+				}
+				if d == registrable {
+					break
+				}
+				_, d, _ = strings.Cut(d, ".")
+			}
+		}
+	}
+	return urls
+}
+
+// parsePACResult parses the return value of FindProxyForURL, such as
+// "PROXY proxy.example.com:8080; DIRECT", and returns the first proxy in it
+// that can be used, or nil for a direct connection.
+func parsePACResult(s string) (*url.URL, error) {
+	for _, ent := range strings.Split(s, ";") {
+		f := strings.Fields(ent)
+		if len(f) == 0 {
+			continue
+		}
+		switch strings.ToUpper(f[0]) {
+		case "DIRECT":
+			return nil, nil
+		case "PROXY", "HTTP", "HTTPS":
+			if len(f) != 2 {
+				return nil, fmt.Errorf("invalid PAC result %q", s)
+			}
+			scheme := "http"
+			if strings.EqualFold(f[0], "HTTPS") {
+				scheme = "https"
+			}
+			return &url.URL{Scheme: scheme, Host: f[1]}, nil
+		default:
+			// SOCKS proxies and anything else aren't supported;
+			// try the next one.
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build darwin && cgo && !ios
+
+package tshttpproxy
+
+/*
+#cgo LDFLAGS: -framework CoreFoundation -framework CFNetwork
+
+#include <CoreFoundation/CoreFoundation.h>
+#include <CFNetwork/CFNetwork.h>
+#include <stdlib.h>
+#include <string.h>
+
+// cfStringToC returns a malloc'd UTF-8 copy of s, or NULL.
+static char *cfStringToC(CFStringRef s) {
+	if (s == NULL) {
+		return NULL;
+	}
+	CFIndex n = CFStringGetMaximumSizeForEncoding(CFStringGetLength(s), kCFStringEncodingUTF8) + 1;
+	char *buf = malloc(n);
+	if (buf == NULL) {
+		return NULL;
+	}
+	if (!CFStringGetCString(s, buf, n, kCFStringEncodingUTF8)) {
+		free(buf);
+		return NULL;
+	}
+	return buf;
+}
+
+// findProxyForURL evaluates the PAC file script for url with CFNetwork. It
+// returns the proxies chosen, in the format FindProxyForURL returns them,
+// or NULL with *errp set to a description of the error. The caller frees
+// the returned string and *errp.
+static char *findProxyForURL(const char *script, const char *url, char **errp) {
+	char *res = NULL;
+	CFStringRef cfScript = NULL;
+	CFURLRef cfURL = NULL;
+	CFArrayRef proxies = NULL;
+	CFErrorRef cfErr = NULL;
+	CFMutableStringRef out = NULL;
+
+	*errp = NULL;
+	cfScript = CFStringCreateWithCString(NULL, script, kCFStringEncodingUTF8);
+	cfURL = CFURLCreateWithBytes(NULL, (const UInt8 *)url, strlen(url), kCFStringEncodingUTF8, NULL);
+	if (cfScript == NULL || cfURL == NULL) {
+		*errp = strdup("invalid PAC file or URL");
+		goto done;
+	}
+	proxies = CFNetworkCopyProxiesForAutoConfigurationScript(cfScript, cfURL, &cfErr);
+	if (proxies == NULL) {
+		if (cfErr != NULL) {
+			CFStringRef desc = CFErrorCopyDescription(cfErr);
+			*errp = cfStringToC(desc);
+			if (desc != NULL) {
+				CFRelease(desc);
+			}
+			CFRelease(cfErr);
+		}
+		if (*errp == NULL) {
+			*errp = strdup("PAC file evaluation failed");
+		}
+		goto done;
+	}
+
+	out = CFStringCreateMutable(NULL, 0);
+	for (CFIndex i = 0; i < CFArrayGetCount(proxies); i++) {
+		CFDictionaryRef p = CFArrayGetValueAtIndex(proxies, i);
+		CFStringRef typ = CFDictionaryGetValue(p, kCFProxyTypeKey);
+		if (typ == NULL) {
+			continue;
+		}
+		if (CFEqual(typ, kCFProxyTypeNone)) {
+			CFStringAppend(out, CFSTR("DIRECT; "));
+			continue;
+		}
+		const char *kind;
+		if (CFEqual(typ, kCFProxyTypeHTTP) || CFEqual(typ, kCFProxyTypeHTTPS)) {
+			// kCFProxyTypeHTTPS is a proxy for https URLs, still
+			// spoken to in plain HTTP, like PAC's "PROXY".
+			kind = "PROXY";
+		} else if (CFEqual(typ, kCFProxyTypeSOCKS)) {
+			kind = "SOCKS";
+		} else {
+			continue;
+		}
+		CFStringRef host = CFDictionaryGetValue(p, kCFProxyHostNameKey);
+		CFNumberRef portNum = CFDictionaryGetValue(p, kCFProxyPortNumberKey);
+		int port = 0;
+		if (host == NULL || portNum == NULL || !CFNumberGetValue(portNum, kCFNumberIntType, &port)) {
+			continue;
+		}
+		CFStringAppendFormat(out, NULL, CFSTR("%s %@:%d; "), kind, host, port);
+	}
+	res = cfStringToC(out);
+	if (res == NULL) {
+		*errp = strdup("converting PAC result");
+	}
+
+done:
+	if (out != NULL) {
+		CFRelease(out);
+	}
+	if (proxies != NULL) {
+		CFRelease(proxies);
+	}
+	if (cfURL != NULL) {
+		CFRelease(cfURL);
+	}
+	if (cfScript != NULL) {
+		CFRelease(cfScript);
+	}
+	return res;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"unsafe"
+)
+
+func init() {
+	evalPACScript = evalPACScriptCFNetwork
+}
+
+// evalPACScriptCFNetwork evaluates the PAC file src for urlStr with
+// CFNetwork, which takes the host from urlStr.
+//
+// CFNetwork evaluates PAC files synchronously and can't be interrupted, so
+// if ctx is done first, it returns ctx's error and the evaluation finishes
+// in the background.
+func evalPACScriptCFNetwork(ctx context.Context, src, urlStr, host string) (string, error) {
+	type result struct {
+		s   string
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		s, err := cfFindProxyForURL(src, urlStr)
+		c <- result{s, err}
+	}()
+	select {
+	case r := <-c:
+		return r.s, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func cfFindProxyForURL(src, urlStr string) (string, error) {
+	cScript := C.CString(src)
+	defer C.free(unsafe.Pointer(cScript))
+	cURL := C.CString(urlStr)
+	defer C.free(unsafe.Pointer(cURL))
+
+	var cErr *C.char
+	res := C.findProxyForURL(cScript, cURL, &cErr)
+	if res == nil {
+		defer C.free(unsafe.Pointer(cErr))
+		return "", errors.New(C.GoString(cErr))
+	}
+	defer C.free(unsafe.Pointer(res))
+	return C.GoString(res), nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tshttpproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"tailscale.com/tstest"
+	"tailscale.com/util/must"
+)
+
+func TestParsePACResult(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // or "" for direct
+	}{
+		{"DIRECT", ""},
+		{"PROXY p:8080", "http://p:8080"},
+		{"  proxy p:8080 ; DIRECT", "http://p:8080"},
+		{"HTTPS p:443", "https://p:443"},
+		{"SOCKS s:1080; PROXY p:3128", "http://p:3128"},
+		{"SOCKS5 s:1080", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		u, err := parsePACResult(tt.in)
+		if err != nil {
+			t.Errorf("parsePACResult(%q): %v", tt.in, err)
+			continue
+		}
+		var got string
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("parsePACResult(%q) = %q; want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestWPADURLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	os.WriteFile(path, []byte("nameserver 10.0.0.1\nsearch eng.corp.example.com corp.example.com. corp.example.co.uk co.uk\n"), 0600)
+	tstest.Replace(t, &resolvConfPath, path)
+	got := wpadURLs()
+	want := []string{
+		"http://wpad.eng.corp.example.com/wpad.dat",
+		"http://wpad.corp.example.com/wpad.dat",
+		"http://wpad.example.com/wpad.dat",
+		"http://wpad.corp.example.co.uk/wpad.dat",
+		"http://wpad.example.co.uk/wpad.dat",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wpadURLs = %q; want %q", got, want)
+	}
+}
+
+func TestProxyFromPACURL(t *testing.T) {
+	if sysSupportsPAC {
+		t.Skip("PAC files are fetched by the OS")
+	}
+	const testPAC = `function FindProxyForURL(url, host) {
+	return host == "direct.example.com" ? "DIRECT" : "PROXY pac-proxy:3128";
+}`
+	// Stand in for the OS's PAC engine.
+	tstest.Replace(t, &evalPACScript, func(ctx context.Context, src, urlStr, host string) (string, error) {
+		if src != testPAC {
+			t.Errorf("evaluating %q; want the fetched PAC file", src)
+		}
+		if host == "direct.example.com" {
+			return "DIRECT", nil
+		}
+		return "PROXY pac-proxy:3128", nil
+	})
+
+	var fetches atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte(testPAC))
+	}))
+	defer ts.Close()
+	t.Cleanup(func() { SetConfig(t.Logf, nil) })
+	if err := SetConfig(t.Logf, &Config{PACURL: ts.URL + "/proxy.pac"}); err != nil {
+		t.Fatal(err)
+	}
+
+	proxyFor := func(rawURL string) string {
+		t.Helper()
+		req := &http.Request{URL: must.Get(url.Parse(rawURL))}
+		u, err := ProxyFromEnvironment(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u == nil {
+			return ""
+		}
+		return u.String()
+	}
+	if got := proxyFor("https://login.example.com/"); got != "http://pac-proxy:3128" {
+		t.Errorf("proxy = %q; want http://pac-proxy:3128", got)
+	}
+	if got := proxyFor("https://direct.example.com/"); got != "" {
+		t.Errorf("proxy = %q; want direct", got)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("PAC file fetched %d times; want 1", n)
+	}
+	InvalidateCache()
+	proxyFor("https://login.example.com/")
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("PAC file fetched %d times after InvalidateCache; want 2", n)
+	}
+}
+
+func TestPACUnsupported(t *testing.T) {
+	if sysSupportsPAC {
+		t.Skip("PAC files are supported by the OS")
+	}
+	tstest.Replace(t, &evalPACScript, nil)
+	t.Cleanup(func() { SetConfig(t.Logf, nil) })
+	for _, c := range []*Config{
+		{PACURL: "http://wpad/wpad.dat"},
+		{AutoDetect: true},
+	} {
+		if err := SetConfig(t.Logf, c); err == nil {
+			t.Errorf("SetConfig(%+v) succeeded without a PAC engine; want error", c)
+		}
+	}
+}
@@ -7,6 +7,7 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -19,6 +20,9 @@
 	"time"
 
 	"golang.org/x/net/http/httpproxy"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/ptr"
+	"tailscale.com/util/set"
 )
 
 // InvalidateCache invalidates the package-level cache for ProxyFromEnvironment.
@@ -28,6 +32,7 @@ func InvalidateCache() {
 	mu.Lock()
 	defer mu.Unlock()
 	noProxyUntil = time.Time{}
+	invalidatePACCache()
 }
 
 var (
@@ -35,17 +40,215 @@ func InvalidateCache() {
 	noProxyUntil time.Time         // if non-zero, time at which ProxyFromEnvironment should check again
 	config       *httpproxy.Config // used to create proxyFunc
 	proxyFunc    func(*url.URL) (*url.URL, error)
+	override     *Config                  // if non-nil, used instead of the environment; see SetConfig
+	overrideLogf logger.Logf = log.Printf // from SetConfig
+	selfProxies  []string                 // from SetSelfProxy
+	changeFuncs  set.HandleSet[func()]
 )
 
-func getProxyFunc() func(*url.URL) (*url.URL, error) {
-	// Create config/proxyFunc if it's not created
+// Config is an explicit outbound proxy configuration that replaces the one
+// from the environment (HTTP_PROXY, HTTPS_PROXY, NO_PROXY, and on Windows,
+// the system proxy settings).
+type Config struct {
+	// HTTPProxy and HTTPSProxy are the proxies to use for http and https
+	// requests, like the HTTP_PROXY and HTTPS_PROXY environment variables.
+	HTTPProxy  string `json:",omitempty"`
+	HTTPSProxy string `json:",omitempty"`
+
+	// NoProxy is a comma-separated list of hosts that should not be
+	// proxied, like the NO_PROXY environment variable.
+	NoProxy string `json:",omitempty"`
+
+	// PACURL, if non-empty, is the URL of a proxy auto-config (PAC) file
+	// to consult when neither HTTPProxy nor HTTPSProxy apply.
+	// It's evaluated by the OS (WinHTTP on Windows, CFNetwork on macOS),
+	// and only supported where SupportsPAC reports true.
+	PACURL string `json:",omitempty"`
+
+	// AutoDetect is whether to look for a PAC file with WPAD (Web Proxy
+	// Auto-Discovery) when neither HTTPProxy, HTTPSProxy nor PACURL apply.
+	AutoDetect bool `json:",omitempty"`
+
+	// Auth is the authentication scheme to use with proxies: "" for
+	// Basic authentication when credentials are available (and on
+	// Windows, Negotiate with the logged-in user's credentials
+	// otherwise), or "ntlm" for NTLM.
+	Auth string `json:",omitempty"`
+
+	// User, if non-empty, is the credentials to use with proxies whose
+	// URL doesn't include any, such as those chosen by a PAC file, in the
+	// form "user:password". For NTLM, the user may be "DOMAIN\user".
+	User string `json:",omitempty"`
+}
+
+// AuthNTLM is the Config.Auth value for NTLM proxy authentication.
+const AuthNTLM = "ntlm"
+
+// sysSupportsPAC is whether the platform's sysProxyFromEnv supports
+// Config.PACURL and Config.AutoDetect. If not, this package fetches PAC
+// files itself and has them evaluated with evalPACScript, if non-nil.
+var sysSupportsPAC bool
+
+// sysConfigChanged, if non-nil, is called (with mu held) when the proxy
+// configuration changes, to reset any platform-specific caches.
+var sysConfigChanged func()
+
+// SetConfig replaces the proxy configuration from the environment with c,
+// or restores the environment's configuration if c is nil. It takes effect
+// for subsequent requests; callers registered with RegisterChangeCallback
+// are notified so they can re-dial existing connections. Changes, and later
+// errors using c, are logged to logf.
+func SetConfig(logf logger.Logf, c *Config) error {
+	if c != nil {
+		if err := CheckConfig(c); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	if c != nil {
+		c = ptr.To(*c)
+	}
+	override = c
+	overrideLogf = logf
+	config = nil
+	proxyFunc = nil
+	noProxyUntil = time.Time{}
+	invalidatePACCache()
+	if sysConfigChanged != nil {
+		sysConfigChanged()
+	}
+	fns := make([]func(), 0, len(changeFuncs))
+	for _, f := range changeFuncs {
+		fns = append(fns, f)
+	}
+	mu.Unlock()
+
+	if c == nil {
+		logf("tshttpproxy: using proxy settings from environment")
+	} else {
+		logf("tshttpproxy: using configured proxy settings: http=%q https=%q no_proxy=%q pac=%q auto=%v auth=%q", redactURL(c.HTTPProxy), redactURL(c.HTTPSProxy), c.NoProxy, c.PACURL, c.AutoDetect, c.Auth)
+	}
+	for _, f := range fns {
+		f()
+	}
+	return nil
+}
+
+// CheckConfig reports whether c is a valid proxy configuration.
+func CheckConfig(c *Config) error {
+	for _, s := range []string{c.HTTPProxy, c.HTTPSProxy} {
+		if s == "" {
+			continue
+		}
+		if u, err := url.Parse(s); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid proxy URL %q", redactURL(s))
+		}
+	}
+	if c.PACURL != "" {
+		if u, err := url.Parse(c.PACURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid proxy auto-config URL %q", c.PACURL)
+		}
+	}
+	if (c.PACURL != "" || c.AutoDetect) && !SupportsPAC() {
+		return fmt.Errorf("proxy auto-config isn't supported on %s", runtime.GOOS)
+	}
+	switch c.Auth {
+	case "", AuthNTLM:
+	default:
+		return fmt.Errorf("unsupported proxy authentication %q", c.Auth)
+	}
+	if c.User != "" && !strings.Contains(c.User, ":") {
+		return errors.New("proxy user must be in the form user:password")
+	}
+	return nil
+}
+
+// GetConfig returns the proxy configuration set by SetConfig, or nil if
+// the environment's configuration is in use.
+func GetConfig() *Config {
 	mu.Lock()
 	defer mu.Unlock()
-	if config == nil {
+	if override == nil {
+		return nil
+	}
+	return ptr.To(*override)
+}
+
+// RegisterChangeCallback registers f to be called in its own goroutine
+// whenever the proxy configuration is changed with SetConfig.
+// It returns a function to unregister f.
+func RegisterChangeCallback(f func()) (unregister func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	h := changeFuncs.Add(func() { go f() })
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(changeFuncs, h)
+	}
+}
+
+// Redacted returns a copy of c without passwords, for showing to users
+// that may not see them. It returns nil if c is nil.
+func (c *Config) Redacted() *Config {
+	if c == nil {
+		return nil
+	}
+	c2 := *c
+	c2.HTTPProxy = redactURL(c.HTTPProxy)
+	c2.HTTPSProxy = redactURL(c.HTTPSProxy)
+	if user, _, ok := strings.Cut(c.User, ":"); ok {
+		c2.User = user + ":xxxxx"
+	}
+	return &c2
+}
+
+// redactURL returns s with any password in it removed.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// configLocked returns the httpproxy.Config to use, creating it from the
+// override or environment if needed.
+//
+// mu must be held.
+func configLocked() *httpproxy.Config {
+	if config != nil {
+		return config
+	}
+	if override != nil {
+		config = &httpproxy.Config{
+			HTTPProxy:  override.HTTPProxy,
+			HTTPSProxy: override.HTTPSProxy,
+			NoProxy:    override.NoProxy,
+		}
+	} else {
 		config = httpproxy.FromEnvironment()
 	}
+	skipSelfProxiesLocked()
+	return config
+}
+
+// pacURL returns the PAC URL set by SetConfig, if any.
+func pacURL() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if override == nil {
+		return ""
+	}
+	return override.PACURL
+}
+
+func getProxyFunc() func(*url.URL) (*url.URL, error) {
+	// Create config/proxyFunc if it's not created
+	mu.Lock()
+	defer mu.Unlock()
 	if proxyFunc == nil {
-		proxyFunc = config.ProxyFunc()
+		proxyFunc = configLocked().ProxyFunc()
 	}
 	return proxyFunc
 }
@@ -65,11 +268,22 @@ func SetSelfProxy(addrs ...string) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	// Ensure we have a valid config
+	selfProxies = append([]string(nil), addrs...)
 	if config == nil {
-		config = httpproxy.FromEnvironment()
+		configLocked() // applies selfProxies
+	} else {
+		skipSelfProxiesLocked()
 	}
 
+	// Invalidate to cause it to get re-created
+	proxyFunc = nil
+}
+
+// skipSelfProxiesLocked clears any proxy in config that points to one of
+// selfProxies.
+//
+// mu must be held.
+func skipSelfProxiesLocked() {
 	normalizeHostPort := func(s string) string {
 		host, portStr, err := net.SplitHostPort(s)
 		if err != nil {
@@ -94,7 +308,7 @@ func SetSelfProxy(addrs ...string) {
 
 	// If any of our proxy variables point to one of the configured
 	// addresses, ignore them.
-	for _, addr := range addrs {
+	for _, addr := range selfProxies {
 		normAddr := normalizeHostPort(addr)
 		if normHTTP != "" && normHTTP == normAddr {
 			log.Printf("tshttpproxy: skipping HTTP_PROXY pointing to self: %q", addr)
@@ -107,9 +321,6 @@ func SetSelfProxy(addrs ...string) {
 			normHTTPS = ""
 		}
 	}
-
-	// Invalidate to cause it to get re-created
-	proxyFunc = nil
 }
 
 // sysProxyFromEnv, if non-nil, specifies a platform-specific ProxyFromEnvironment
@@ -129,21 +340,62 @@ func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
 
 	mu.Lock()
 	noProxyTime := noProxyUntil
+	// An explicit configuration without a PAC URL or auto-detection
+	// replaces the system's.
+	var pacURL string
+	var autoDetect bool
+	if override != nil {
+		pacURL, autoDetect = override.PACURL, override.AutoDetect
+	}
+	logf := overrideLogf
+	useSys := override == nil || pacURL != "" || autoDetect
 	mu.Unlock()
 	if time.Now().Before(noProxyTime) {
 		return nil, nil
 	}
 
-	if sysProxyFromEnv != nil {
+	if sysProxyFromEnv != nil && useSys {
 		u, err := sysProxyFromEnv(req)
 		if u != nil && err == nil {
 			return u, nil
 		}
 	}
+	if !sysSupportsPAC && (pacURL != "" || autoDetect) {
+		u, err := pacProxyFromConfig(logf, req, pacURL, autoDetect)
+		if err != nil {
+			logf("tshttpproxy: %v; connecting without a proxy", err)
+			return nil, nil
+		}
+		return u, nil
+	}
 
 	return nil, err
 }
 
+// proxyUser returns the user and password to authenticate to proxy u with:
+// those in its URL, if any, or else those from Config.User.
+func proxyUser(u *url.URL) (user, pass string, ok bool) {
+	if u.User != nil {
+		pass, ok = u.User.Password()
+		return u.User.Username(), pass, ok
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if override == nil || override.User == "" {
+		return "", "", false
+	}
+	user, pass, ok = strings.Cut(override.User, ":")
+	return user, pass, ok
+}
+
+// useNTLM reports whether proxies are configured to use NTLM
+// authentication.
+func useNTLM() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return override != nil && override.Auth == AuthNTLM
+}
+
 var sysAuthHeader func(*url.URL) (string, error)
 
 // GetAuthHeader returns the Authorization header value to send to proxy u.
@@ -151,9 +403,9 @@ func GetAuthHeader(u *url.URL) (string, error) {
 	if fake := os.Getenv("TS_DEBUG_FAKE_PROXY_AUTH"); fake != "" {
 		return fake, nil
 	}
-	if user := u.User.Username(); user != "" {
-		pass, ok := u.User.Password()
-		if !ok {
+	if user, pass, ok := proxyUser(u); user != "" {
+		if !ok || useNTLM() {
+			// NTLM needs a handshake, done by Connect.
 			return "", nil
 		}
 
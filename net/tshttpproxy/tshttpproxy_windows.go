@@ -29,6 +29,12 @@
 func init() {
 	sysProxyFromEnv = proxyFromWinHTTPOrCache
 	sysAuthHeader = sysAuthHeaderWindows
+	sysSupportsPAC = true
+	sysConfigChanged = func() {
+		cachedProxy.Lock()
+		defer cachedProxy.Unlock()
+		cachedProxy.val = nil
+	}
 }
 
 var cachedProxy struct {
@@ -161,6 +167,7 @@ func proxyFromWinHTTP(ctx context.Context, urlStr string) (proxy *url.URL, err e
 	winHTTP_ACCESS_TYPE_AUTOMATIC_PROXY = 4
 	winHTTP_AUTOPROXY_ALLOW_AUTOCONFIG  = 0x00000100
 	winHTTP_AUTOPROXY_AUTO_DETECT       = 1
+	winHTTP_AUTOPROXY_CONFIG_URL        = 2
 	winHTTP_AUTO_DETECT_TYPE_DHCP       = 0x00000001
 	winHTTP_AUTO_DETECT_TYPE_DNS_A      = 0x00000002
 )
@@ -244,11 +251,19 @@ func (pi *winHTTPProxyInfo) free() {
 }
 
 func (hi winHTTPInternet) GetProxyForURL(urlStr string) (string, error) {
+	opts := proxyForURLOpts
+	if pac := pacURL(); pac != "" {
+		// Use the PAC file from SetConfig instead of auto-detecting one.
+		opts = &winHTTPAutoProxyOptions{
+			DwFlags:       winHTTP_AUTOPROXY_CONFIG_URL,
+			AutoConfigUrl: windows.StringToUTF16Ptr(pac),
+		}
+	}
 	var out winHTTPProxyInfo
 	err := winHTTPGetProxyForURL(
 		hi,
 		windows.StringToUTF16Ptr(urlStr),
-		proxyForURLOpts,
+		opts,
 		&out,
 	)
 	if err != nil {
@@ -0,0 +1,202 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"net/netip"
+	"slices"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/util/clientmetric"
+)
+
+// QoSClass is a traffic category used to prioritize and DSCP-mark packets
+// read from the TUN device before they're handed to WireGuard.
+type QoSClass uint8
+
+const (
+	// QoSDefault is ordinary best-effort traffic.
+	QoSDefault QoSClass = iota
+	// QoSInteractive is latency-sensitive traffic such as SSH sessions.
+	// It is sent ahead of other traffic read in the same batch.
+	QoSInteractive
+	// QoSBulk is throughput-oriented traffic such as Taildrop transfers.
+	// It is sent after other traffic read in the same batch.
+	QoSBulk
+
+	numQoSClasses = iota
+)
+
+// DSCP returns the DSCP mark used for packets of class c.
+func (c QoSClass) DSCP() packet.DSCP {
+	switch c {
+	case QoSInteractive:
+		return packet.DSCPInteractive
+	case QoSBulk:
+		return packet.DSCPBulk
+	}
+	return packet.DSCPDefault
+}
+
+func (c QoSClass) String() string {
+	switch c {
+	case QoSDefault:
+		return "default"
+	case QoSInteractive:
+		return "interactive"
+	case QoSBulk:
+		return "bulk"
+	}
+	return "unknown"
+}
+
+// DefaultInteractivePorts are the TCP/UDP ports whose traffic is classified
+// as QoSInteractive when QoS is enabled: SSH (including Tailscale SSH),
+// telnet, DNS, RDP and VNC.
+var DefaultInteractivePorts = []uint16{22, 23, 53, 3389, 5900}
+
+// QoSConfig is the traffic prioritization configuration of a Wrapper.
+type QoSConfig struct {
+	// InteractivePorts are the TCP and UDP ports (matched against either
+	// the source or destination port) whose traffic is QoSInteractive.
+	InteractivePorts []uint16
+
+	// BulkDsts are the destinations whose traffic is QoSBulk, such as the
+	// PeerAPI endpoints of peers that Taildrop sends files to.
+	BulkDsts map[netip.AddrPort]bool
+}
+
+// classify returns the QoSClass of p.
+//
+// Packets that already carry a DSCP mark set by the application keep the
+// class matching that mark.
+func (c *QoSConfig) classify(p *packet.Parsed) QoSClass {
+	switch p.DSCP() {
+	case packet.DSCPInteractive:
+		return QoSInteractive
+	case packet.DSCPBulk:
+		return QoSBulk
+	}
+	if p.IPProto != ipproto.TCP && p.IPProto != ipproto.UDP {
+		return QoSDefault
+	}
+	if c.BulkDsts[p.Dst] {
+		return QoSBulk
+	}
+	if slices.Contains(c.InteractivePorts, p.Dst.Port()) || slices.Contains(c.InteractivePorts, p.Src.Port()) {
+		return QoSInteractive
+	}
+	return QoSDefault
+}
+
+// SetQoS sets the traffic prioritization configuration for packets
+// read from the TUN device. A nil config disables prioritization and
+// DSCP marking.
+func (t *Wrapper) SetQoS(c *QoSConfig) {
+	t.qos.Store(c)
+}
+
+// prioritize returns the packets in data reordered so that interactive
+// packets come first and bulk packets last, preserving the relative order
+// of packets within each class.
+//
+// It must only be called from Read, which reuses the returned slice.
+func (t *Wrapper) prioritize(c *QoSConfig, data [][]byte, offset int) [][]byte {
+	p := parsedPacketPool.Get().(*packet.Parsed)
+	defer parsedPacketPool.Put(p)
+
+	var byClass [numQoSClasses]int
+	classes := t.qosClasses[:0]
+	for _, d := range data {
+		p.Decode(d[offset:])
+		cls := c.classify(p)
+		classes = append(classes, cls)
+		byClass[cls]++
+	}
+	t.qosClasses = classes
+	if byClass[QoSInteractive] == 0 && byClass[QoSBulk] == 0 {
+		return data
+	}
+
+	out := t.qosOrder[:0]
+	for _, cls := range [...]QoSClass{QoSInteractive, QoSDefault, QoSBulk} {
+		if byClass[cls] == 0 {
+			continue
+		}
+		for i, d := range data {
+			if classes[i] == cls {
+				out = append(out, d)
+			}
+		}
+	}
+	t.qosOrder = out
+	return out
+}
+
+// mark sets the DSCP mark of p according to its class, which it returns.
+// Packets that the application already marked are left alone.
+func (c *QoSConfig) mark(p *packet.Parsed) QoSClass {
+	cls := c.classify(p)
+	if d := cls.DSCP(); d != packet.DSCPDefault && p.DSCP() == packet.DSCPDefault {
+		p.SetDSCP(d)
+	}
+	switch cls {
+	case QoSInteractive:
+		metricPacketOutQoSInteractive.Add(1)
+	case QoSBulk:
+		metricPacketOutQoSBulk.Add(1)
+	}
+	return cls
+}
+
+// The class of each packet that Read returns is also recorded in the last
+// bytes of the buffer it's read into, as a qosTrailerMagic byte followed by
+// the class. WireGuard encrypts packets in place in the buffers it passes to
+// Read, so the encrypted packets it hands to magicsock to send are slices of
+// the same buffers, and magicsock can find their class with
+// QoSClassOfBuffer and mark the outer UDP packets with its DSCP.
+const (
+	qosTrailerMagic = 0xd5
+	qosTrailerLen   = 2
+
+	// qosTrailerSlack is the room left after a packet for WireGuard's
+	// header, padding and authentication tag, which must not overlap the
+	// trailer.
+	qosTrailerSlack = 64
+)
+
+// putBufferQoSClass records cls as the class of the packet that ends at
+// offset end of buf, if buf has room for it after the packet. Otherwise it
+// clears any trailer left in buf, which is reused, by an earlier packet.
+func putBufferQoSClass(buf []byte, end int, cls QoSClass) {
+	buf = buf[:cap(buf)]
+	if end+qosTrailerSlack > len(buf)-qosTrailerLen {
+		if end <= len(buf)-qosTrailerLen {
+			buf[len(buf)-2] = 0
+		}
+		return
+	}
+	buf[len(buf)-2] = qosTrailerMagic
+	buf[len(buf)-1] = byte(cls)
+}
+
+// QoSClassOfBuffer returns the class of the packet b, which a Wrapper with
+// QoS enabled read into the buffer b is a slice of, even after WireGuard
+// encrypted it in place. It reports false if there's no class recorded,
+// including when b extends into where the trailer would be, as those bytes
+// are then packet data.
+func QoSClassOfBuffer(b []byte) (_ QoSClass, ok bool) {
+	n := len(b)
+	b = b[:cap(b)]
+	if n > len(b)-qosTrailerLen || b[len(b)-2] != qosTrailerMagic || b[len(b)-1] >= numQoSClasses {
+		return QoSDefault, false
+	}
+	return QoSClass(b[len(b)-1]), true
+}
+
+var (
+	metricPacketOutQoSInteractive = clientmetric.NewCounter("tstun_out_to_wg_qos_interactive")
+	metricPacketOutQoSBulk        = clientmetric.NewCounter("tstun_out_to_wg_qos_bulk")
+)
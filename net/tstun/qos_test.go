@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/net/packet"
+)
+
+func TestQoSPrioritize(t *testing.T) {
+	_, w := newFakeTUN(t.Logf, false)
+	defer w.Close()
+
+	qos := &QoSConfig{
+		InteractivePorts: DefaultInteractivePorts,
+		BulkDsts: map[netip.AddrPort]bool{
+			netip.MustParseAddrPort("100.64.0.2:45678"): true,
+		},
+	}
+	bulk1 := tcp4syn("100.64.0.1", "100.64.0.2", 1234, 45678)
+	web := tcp4syn("100.64.0.1", "100.64.0.3", 1235, 443)
+	ssh := tcp4syn("100.64.0.1", "100.64.0.3", 1236, 22)
+	bulk2 := tcp4syn("100.64.0.1", "100.64.0.2", 1237, 45678)
+	dns := udp4("100.64.0.1", "100.100.100.100", 1238, 53)
+
+	got := w.prioritize(qos, [][]byte{bulk1, web, ssh, bulk2, dns}, 0)
+	want := [][]byte{ssh, dns, web, bulk1, bulk2}
+	if len(got) != len(want) {
+		t.Fatalf("got %d packets; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if &got[i][0] != &want[i][0] {
+			var gp, wp packet.Parsed
+			gp.Decode(got[i])
+			wp.Decode(want[i])
+			t.Errorf("packet %d = %v; want %v", i, &gp, &wp)
+		}
+	}
+
+	// With nothing to reorder, the input is returned as-is.
+	in := [][]byte{web}
+	if got := w.prioritize(qos, in, 0); &got[0] != &in[0] {
+		t.Errorf("prioritize reordered a batch with only default traffic")
+	}
+}
+
+func TestQoSMark(t *testing.T) {
+	qos := &QoSConfig{InteractivePorts: DefaultInteractivePorts}
+	tests := []struct {
+		name string
+		pkt  []byte
+		pre  packet.DSCP // DSCP set by the application, if any
+		want packet.DSCP
+	}{
+		{"ssh", tcp4syn("1.2.3.4", "5.6.7.8", 1234, 22), packet.DSCPDefault, packet.DSCPInteractive},
+		{"ssh-reply", tcp4syn("5.6.7.8", "1.2.3.4", 22, 1234), packet.DSCPDefault, packet.DSCPInteractive},
+		{"web", tcp4syn("1.2.3.4", "5.6.7.8", 1234, 443), packet.DSCPDefault, packet.DSCPDefault},
+		{"app-marked", tcp4syn("1.2.3.4", "5.6.7.8", 1234, 22), 34, 34},
+		{"app-bulk", udp4("1.2.3.4", "5.6.7.8", 1234, 9999), packet.DSCPBulk, packet.DSCPBulk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p packet.Parsed
+			p.Decode(tt.pkt)
+			p.SetDSCP(tt.pre)
+			qos.mark(&p)
+			if got := p.DSCP(); got != tt.want {
+				t.Errorf("DSCP = %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQoSClassOfBuffer(t *testing.T) {
+	buf := make([]byte, 2048)
+	if cls, ok := QoSClassOfBuffer(buf[16:100]); ok {
+		t.Fatalf("got class %v in a fresh buffer", cls)
+	}
+	putBufferQoSClass(buf, 100, QoSInteractive)
+	// WireGuard encrypts in place and sends a subslice of the buffer.
+	if cls, ok := QoSClassOfBuffer(buf[0:132]); !ok || cls != QoSInteractive {
+		t.Errorf("QoSClassOfBuffer = %v, %v; want %v, true", cls, ok, QoSInteractive)
+	}
+
+	// A packet that leaves no room after it isn't marked.
+	small := make([]byte, 128)
+	putBufferQoSClass(small, 100, QoSBulk)
+	if cls, ok := QoSClassOfBuffer(small[:100]); ok {
+		t.Errorf("got class %v with no room for a trailer", cls)
+	}
+
+	// Buffers are reused: a later packet without room for a trailer
+	// doesn't get the class of the earlier one.
+	putBufferQoSClass(buf, len(buf)-40, QoSBulk)
+	if cls, ok := QoSClassOfBuffer(buf[:len(buf)-40]); ok {
+		t.Errorf("got stale class %v for a packet with no room for a trailer", cls)
+	}
+	putBufferQoSClass(buf, 100, QoSInteractive)
+	full := buf[:len(buf)]
+	full[len(full)-2], full[len(full)-1] = qosTrailerMagic, byte(QoSBulk) // packet data
+	putBufferQoSClass(buf, len(buf), QoSDefault)
+	if cls, ok := QoSClassOfBuffer(full); ok {
+		t.Errorf("got class %v from the data of a full-size packet", cls)
+	}
+}
@@ -24,7 +24,9 @@
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
 	"tailscale.com/net/connstats"
+	"tailscale.com/net/flowtrack"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/relaystats"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tstun/table"
 	"tailscale.com/syncs"
@@ -189,8 +191,28 @@ type Wrapper struct {
 	stats atomic.Pointer[connstats.Statistics]
 
 	captureHook syncs.AtomicValue[capture.Callback]
+
+	// qos is the traffic prioritization config, or nil if disabled.
+	qos atomic.Pointer[QoSConfig]
+	// qosClasses and qosOrder are scratch space for prioritize,
+	// only used by Read.
+	qosClasses []QoSClass
+	qosOrder   [][]byte
+
+	// relayStats counts traffic forwarded on behalf of peers,
+	// or is nil if not acting as a subnet router or exit node.
+	relayStats atomic.Pointer[relaystats.Tracker]
+
+	// flowLogger, if non-nil, is told of inbound flows from peers.
+	flowLogger syncs.AtomicValue[FlowLogFunc]
 }
 
+// FlowLogFunc is called with the packet filter's verdict on the packets
+// from peers that may start a flow: TCP SYNs, and all packets of other
+// protocols, which have no connection setup. It must deduplicate the
+// latter itself, if needed, and must not block.
+type FlowLogFunc func(flow flowtrack.Tuple, accepted bool)
+
 // tunInjectedRead is an injected packet pretending to be a tun.Read().
 type tunInjectedRead struct {
 	// Only one of packet or data should be set, and are read in that order of
@@ -745,7 +767,12 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 	p := parsedPacketPool.Get().(*packet.Parsed)
 	defer parsedPacketPool.Put(p)
 	captHook := t.captureHook.Load()
-	for _, data := range res.data {
+	qos := t.qos.Load()
+	vec := res.data
+	if qos != nil && len(vec) > 1 {
+		vec = t.prioritize(qos, vec, res.dataOffset)
+	}
+	for _, data := range vec {
 		p.Decode(data[res.dataOffset:])
 
 		t.snatV4(p)
@@ -764,11 +791,21 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 				continue
 			}
 		}
+		cls := QoSDefault
+		if qos != nil {
+			cls = qos.mark(p)
+		}
+		if rs := t.relayStats.Load(); rs != nil {
+			rs.ToPeer(p.Src.Addr(), p.Dst.Addr(), len(p.Buffer()))
+		}
 		n := copy(buffs[buffsPos][offset:], p.Buffer())
 		if n != len(data)-res.dataOffset {
 			panic(fmt.Sprintf("short copy: %d != %d", n, len(data)-res.dataOffset))
 		}
 		sizes[buffsPos] = n
+		if qos != nil {
+			putBufferQoSClass(buffs[buffsPos], offset+n, cls)
+		}
 		if stats := t.stats.Load(); stats != nil {
 			stats.UpdateTxVirtual(p.Buffer())
 		}
@@ -806,6 +843,9 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, buf []byte, offset int) (int
 	defer parsedPacketPool.Put(p)
 	p.Decode(buf[offset : offset+n])
 	t.snatV4(p)
+	if qos := t.qos.Load(); qos != nil {
+		putBufferQoSClass(buf, offset+n, qos.mark(p))
+	}
 
 	if m := t.destIPActivity.Load(); m != nil {
 		if fn := m[p.Dst.Addr()]; fn != nil {
@@ -880,6 +920,10 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook ca
 		}
 	}
 
+	if logFlow := t.flowLogger.Load(); logFlow != nil && mayStartFlow(p) {
+		logFlow(flowtrack.Tuple{Proto: p.IPProto, Src: p.Src, Dst: p.Dst}, outcome == filter.Accept)
+	}
+
 	if outcome != filter.Accept {
 		metricPacketInDropFilter.Add(1)
 
@@ -914,6 +958,11 @@ func (t *Wrapper) filterPacketInboundFromWireGuard(p *packet.Parsed, captHook ca
 		}
 	}
 
+	if rs := t.relayStats.Load(); rs != nil && !rs.FromPeer(p.Src.Addr(), p.Dst.Addr(), len(p.Buffer())) {
+		metricPacketInDropRelayLimit.Add(1)
+		return filter.Drop
+	}
+
 	return filter.Accept
 }
 
@@ -1116,12 +1165,39 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	t.stats.Store(stats)
 }
 
+// SetRelayStats specifies the tracker of traffic forwarded on behalf of
+// peers, which may also drop such traffic from peers that exceed their
+// daily limit. Nil may be specified to disable tracking.
+func (t *Wrapper) SetRelayStats(rs *relaystats.Tracker) {
+	t.relayStats.Store(rs)
+}
+
+// SetFlowLogger specifies the function to tell of inbound flows from
+// peers. Nil may be specified to disable flow logging.
+func (t *Wrapper) SetFlowLogger(fn FlowLogFunc) {
+	t.flowLogger.Store(fn)
+}
+
+// mayStartFlow reports whether p, a packet from a peer, may be the first
+// of a flow, for FlowLogFunc.
+func mayStartFlow(p *packet.Parsed) bool {
+	switch p.IPProto {
+	case ipproto.TCP:
+		return p.TCPFlags&packet.TCPSynAck == packet.TCPSyn
+	case ipproto.TSMP, ipproto.Fragment:
+		return false
+	}
+	return true
+}
+
 var (
 	metricPacketIn              = clientmetric.NewCounter("tstun_in_from_wg")
 	metricPacketInDrop          = clientmetric.NewCounter("tstun_in_from_wg_drop")
 	metricPacketInDropFilter    = clientmetric.NewCounter("tstun_in_from_wg_drop_filter")
 	metricPacketInDropSelfDisco = clientmetric.NewCounter("tstun_in_from_wg_drop_self_disco")
 
+	metricPacketInDropRelayLimit = clientmetric.NewCounter("tstun_in_from_wg_drop_relay_limit")
+
 	metricPacketOut              = clientmetric.NewCounter("tstun_out_to_wg")
 	metricPacketOutDrop          = clientmetric.NewCounter("tstun_out_to_wg_drop")
 	metricPacketOutDropFilter    = clientmetric.NewCounter("tstun_out_to_wg_drop_filter")
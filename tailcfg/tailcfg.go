@@ -111,7 +111,9 @@
 //   - 71: 2023-08-17: added NodeAttrOneCGNATEnable, NodeAttrOneCGNATDisable
 //   - 72: 2023-08-23: TS-2023-006 UPnP issue fixed; UPnP can now be used again
 //   - 73: 2023-09-01: Non-Windows clients expect to receive ClientVersion
-const CurrentCapabilityVersion CapabilityVersion = 73
+//   - 74: 2023-09-12: Client understands MapResponse.SSHHostCerts
+//   - 75: 2023-09-18: Client understands FilterRule.Schedule
+const CurrentCapabilityVersion CapabilityVersion = 75
 
 type StableID string
 
@@ -703,6 +705,13 @@ type NetInfo struct {
 	// It reports true even if there's no NAT involved.
 	HairPinning opt.Bool
 
+	// NATMapping and NATFiltering are how the host's NAT, if any, maps
+	// and filters UDP flows on IPv4, per the terms of RFC 4787:
+	// "endpoint-independent", "address-dependent" or
+	// "address-and-port-dependent". Empty means not determined.
+	NATMapping   string `json:",omitempty"`
+	NATFiltering string `json:",omitempty"`
+
 	// WorkingIPv6 is whether the host has IPv6 internet connectivity.
 	WorkingIPv6 opt.Bool
 
@@ -812,6 +821,8 @@ func (ni *NetInfo) BasicallyEqual(ni2 *NetInfo) bool {
 	}
 	return ni.MappingVariesByDestIP == ni2.MappingVariesByDestIP &&
 		ni.HairPinning == ni2.HairPinning &&
+		ni.NATMapping == ni2.NATMapping &&
+		ni.NATFiltering == ni2.NATFiltering &&
 		ni.WorkingIPv6 == ni2.WorkingIPv6 &&
 		ni.OSHasIPv6 == ni2.OSHasIPv6 &&
 		ni.WorkingUDP == ni2.WorkingUDP &&
@@ -1050,6 +1061,7 @@ type RegisterResponse struct {
 	EndpointSTUN           = EndpointType(2)
 	EndpointPortmapped     = EndpointType(3)
 	EndpointSTUN4LocalPort = EndpointType(4) // hard NAT: STUN'ed IPv4 address + local fixed port
+	EndpointExplicitConf   = EndpointType(5) // explicitly configured, as with the AdvertiseEndpoints pref
 )
 
 func (et EndpointType) String() string {
@@ -1064,6 +1076,8 @@ func (et EndpointType) String() string {
 		return "portmap"
 	case EndpointSTUN4LocalPort:
 		return "stun4localport"
+	case EndpointExplicitConf:
+		return "explicitconf"
 	}
 	return "other"
 }
@@ -1242,6 +1256,10 @@ type CapGrant struct {
 	PeerCapabilityWakeOnLAN PeerCapability = "https://tailscale.com/cap/wake-on-lan"
 	// PeerCapabilityIngress grants the ability for a peer to send ingress traffic.
 	PeerCapabilityIngress PeerCapability = "https://tailscale.com/cap/ingress"
+	// PeerCapabilityFleetStatus grants the ability for a peer to read this
+	// node's version, health warnings, key expiry and pending update, as
+	// for a fleet dashboard in its web client.
+	PeerCapabilityFleetStatus PeerCapability = "https://tailscale.com/cap/fleet-status"
 )
 
 // PeerCapMap is a map of capabilities to their optional values. It is valid for
@@ -1335,6 +1353,41 @@ type FilterRule struct {
 	//
 	// CapGrant and DstPorts are mutually exclusive: at most one can be non-nil.
 	CapGrant []CapGrant `json:",omitempty"`
+
+	// Schedule, if non-nil, limits the rule to recurring time windows:
+	// outside of them, the rule doesn't apply. Clients re-evaluate it as
+	// time passes, without needing a new MapResponse.
+	//
+	// Clients before CapabilityVersion 75 ignore it, so control must not
+	// send them rules with a Schedule.
+	Schedule *FilterSchedule `json:",omitempty"`
+}
+
+// FilterSchedule is the set of recurring time windows in which a FilterRule
+// applies.
+type FilterSchedule struct {
+	// TimeZone is the time zone of the Windows: empty or "UTC" for UTC,
+	// "local" for the node's local time zone, or else an IANA Time Zone
+	// database name such as "America/New_York".
+	TimeZone string `json:",omitempty"`
+
+	// Windows are the time windows in which the rule applies. The rule
+	// applies while any of them is open, and never if there are none.
+	Windows []TimeWindow
+}
+
+// TimeWindow is a recurring time window of a FilterSchedule.
+type TimeWindow struct {
+	// Days are the days of the week on which the window opens.
+	// Empty means every day.
+	Days []time.Weekday `json:",omitempty"`
+
+	// Start and End are the times of day at which the window opens and
+	// closes, as 24-hour "HH:MM" strings. End may be "24:00" for the
+	// end of the day. If End isn't after Start, the window spans
+	// midnight and closes on the next day.
+	Start string
+	End   string
 }
 
 var FilterAllowAll = []FilterRule{
@@ -1658,6 +1711,11 @@ type MapResponse struct {
 	// SSH connections should be handled.
 	SSHPolicy *SSHPolicy `json:",omitempty"`
 
+	// SSHHostCerts, if non-nil, updates the SSH host certificates this
+	// node presents for Tailscale SSH and the certificate authorities it
+	// trusts when connecting to other nodes with "tailscale ssh".
+	SSHHostCerts *SSHHostCerts `json:",omitempty"`
+
 	// ControlTime, if non-zero, is the current timestamp according to the control server.
 	ControlTime *time.Time `json:",omitempty"`
 
@@ -1780,7 +1838,9 @@ func (id NodeID) String() string  { return fmt.Sprintf("nodeid:%x", int64(id)) }
 
 // Equal reports whether n and n2 are equal.
 func (n *Node) Equal(n2 *Node) bool {
-	if n == nil && n2 == nil {
+	if n == n2 {
+		// Including both nil. Successive netmaps share the Nodes of
+		// unchanged peers, so this saves comparing their fields.
 		return true
 	}
 	return n != nil && n2 != nil &&
@@ -2186,6 +2246,24 @@ type SSHRecorderFailureAction struct {
 	NotifyURL string `json:",omitempty"`
 }
 
+// SSHHostCerts are the OpenSSH host certificates issued by control for a
+// node's SSH host keys, and the tailnet CA keys that sign them. They let SSH
+// clients verify Tailscale SSH servers without trust-on-first-use prompts.
+type SSHHostCerts struct {
+	// Certificates are OpenSSH host certificates, in authorized_keys
+	// format, for the SSH host keys the node reported in
+	// Hostinfo.SSH_HostKeys. Their principals should include the node's
+	// MagicDNS names. Certificates for keys the node doesn't have are
+	// ignored.
+	Certificates []string `json:",omitempty"`
+
+	// CAs are the public keys, in authorized_keys format, of the
+	// tailnet's SSH host certificate authorities. Host certificates
+	// signed by one of them are trusted for names under the tailnet's
+	// MagicDNS suffix.
+	CAs []string `json:",omitempty"`
+}
+
 // SSHEventNotifyRequest is the JSON payload sent to the NotifyURL
 // for an SSH event.
 type SSHEventNotifyRequest struct {
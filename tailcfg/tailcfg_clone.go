@@ -176,6 +176,8 @@ func (src *NetInfo) Clone() *NetInfo {
 var _NetInfoCloneNeedsRegeneration = NetInfo(struct {
 	MappingVariesByDestIP opt.Bool
 	HairPinning           opt.Bool
+	NATMapping            string
+	NATFiltering          string
 	WorkingIPv6           opt.Bool
 	OSHasIPv6             opt.Bool
 	WorkingUDP            opt.Bool
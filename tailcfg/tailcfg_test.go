@@ -558,6 +558,8 @@ func TestNetInfoFields(t *testing.T) {
 	handled := []string{
 		"MappingVariesByDestIP",
 		"HairPinning",
+		"NATMapping",
+		"NATFiltering",
 		"WorkingIPv6",
 		"OSHasIPv6",
 		"WorkingUDP",
@@ -391,6 +391,8 @@ func (v *NetInfoView) UnmarshalJSON(b []byte) error {
 
 func (v NetInfoView) MappingVariesByDestIP() opt.Bool { return v.ж.MappingVariesByDestIP }
 func (v NetInfoView) HairPinning() opt.Bool           { return v.ж.HairPinning }
+func (v NetInfoView) NATMapping() string              { return v.ж.NATMapping }
+func (v NetInfoView) NATFiltering() string            { return v.ж.NATFiltering }
 func (v NetInfoView) WorkingIPv6() opt.Bool           { return v.ж.WorkingIPv6 }
 func (v NetInfoView) OSHasIPv6() opt.Bool             { return v.ж.OSHasIPv6 }
 func (v NetInfoView) WorkingUDP() opt.Bool            { return v.ж.WorkingUDP }
@@ -410,6 +412,8 @@ func (v NetInfoView) String() string                          { return v.ж.Stri
 var _NetInfoViewNeedsRegeneration = NetInfo(struct {
 	MappingVariesByDestIP opt.Bool
 	HairPinning           opt.Bool
+	NATMapping            string
+	NATFiltering          string
 	WorkingIPv6           opt.Bool
 	OSHasIPv6             opt.Bool
 	WorkingUDP            opt.Bool
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// NKSSigner is implemented by tailnet lock keys that can sign node-key
+// signatures, such as key.NLPrivate and ExternalSigner.
+type NKSSigner interface {
+	// KeyID returns the ID of the signing key.
+	KeyID() tkatype.KeyID
+	// SignNKS returns the signature of the node-key signature with the
+	// given NKSSigHash.
+	SignNKS(tkatype.NKSSigHash) ([]byte, error)
+}
+
+// ExternalKey is an ed25519 tailnet lock key whose private half is held
+// outside this process, such as in an HSM, a cloud KMS or a remote signing
+// agent.
+type ExternalKey interface {
+	// Public returns the public half of the key.
+	Public() key.NLPublic
+	// SignDigest returns the ed25519 signature of digest, the BLAKE2s hash
+	// of an AUM or a node-key signature.
+	SignDigest(digest []byte) ([]byte, error)
+}
+
+// ExternalSigner signs AUMs and node-key signatures with an ExternalKey.
+// It implements Signer and NKSSigner.
+//
+// Each signature is verified against the key's public half before it's
+// used, so a misconfigured signer that signs with some other key fails
+// here rather than producing updates that peers reject.
+type ExternalSigner struct {
+	Key ExternalKey
+}
+
+// KeyID implements NKSSigner.
+func (s ExternalSigner) KeyID() tkatype.KeyID {
+	return s.Key.Public().KeyID()
+}
+
+func (s ExternalSigner) sign(digest []byte) ([]byte, error) {
+	sig, err := s.Key.SignDigest(digest)
+	if err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("external signer: signature is %d bytes, want %d", len(sig), ed25519.SignatureSize)
+	}
+	if !ed25519.Verify(s.Key.Public().Verifier(), digest, sig) {
+		return nil, errors.New("external signer: signature does not verify with the signing key")
+	}
+	return sig, nil
+}
+
+// SignAUM implements Signer.
+func (s ExternalSigner) SignAUM(sigHash tkatype.AUMSigHash) ([]tkatype.Signature, error) {
+	sig, err := s.sign(sigHash[:])
+	if err != nil {
+		return nil, err
+	}
+	return []tkatype.Signature{{
+		KeyID:     s.KeyID(),
+		Signature: sig,
+	}}, nil
+}
+
+// SignNKS implements NKSSigner.
+func (s ExternalSigner) SignNKS(sigHash tkatype.NKSSigHash) ([]byte, error) {
+	return s.sign(sigHash[:])
+}
+
+// SignNodeKey returns a direct signature by signer of nodeKey. If
+// rotationPublic is non-nil, it must be an ed25519 public key with which
+// the node can later rotate its key.
+func SignNodeKey(nodeKey key.NodePublic, rotationPublic []byte, signer NKSSigner) (*NodeKeySignature, error) {
+	p, err := nodeKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	sig := NodeKeySignature{
+		SigKind:        SigDirect,
+		KeyID:          signer.KeyID(),
+		Pubkey:         p,
+		WrappingPubkey: rotationPublic,
+	}
+	sig.Signature, err = signer.SignNKS(sig.SigHash())
+	if err != nil {
+		return nil, fmt.Errorf("signature failed: %w", err)
+	}
+	return &sig, nil
+}
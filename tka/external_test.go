@@ -0,0 +1,53 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"tailscale.com/types/key"
+)
+
+// testExternalKey is an ExternalKey that signs with priv, optionally
+// claiming the public half of some other key.
+type testExternalKey struct {
+	priv ed25519.PrivateKey
+	pub  key.NLPublic
+}
+
+func (k testExternalKey) Public() key.NLPublic { return k.pub }
+
+func (k testExternalKey) SignDigest(digest []byte) ([]byte, error) {
+	return ed25519.Sign(k.priv, digest), nil
+}
+
+func TestExternalSigner(t *testing.T) {
+	pub, priv := testingKey25519(t, 1)
+	signer := ExternalSigner{Key: testExternalKey{priv: priv, pub: key.NLPublicFromEd25519Unsafe(pub)}}
+	k := Key{Kind: Key25519, Public: pub, Votes: 1}
+
+	a, _, err := Create(&Mem{}, State{
+		Keys:               []Key{k},
+		DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+	}, signer)
+	if err != nil {
+		t.Fatalf("Create() with external signer failed: %v", err)
+	}
+
+	nodeKey := key.NewNode().Public()
+	sig, err := SignNodeKey(nodeKey, nil, signer)
+	if err != nil {
+		t.Fatalf("SignNodeKey() failed: %v", err)
+	}
+	if err := a.NodeKeyAuthorized(nodeKey, sig.Serialize()); err != nil {
+		t.Errorf("NodeKeyAuthorized() = %v", err)
+	}
+
+	_, otherPriv := testingKey25519(t, 2)
+	wrong := ExternalSigner{Key: testExternalKey{priv: otherPriv, pub: key.NLPublicFromEd25519Unsafe(pub)}}
+	if _, err := SignNodeKey(nodeKey, nil, wrong); err == nil {
+		t.Error("SignNodeKey() with mismatched external key succeeded")
+	}
+}
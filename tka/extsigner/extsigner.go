@@ -0,0 +1,189 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package extsigner provides tailnet lock keys whose private halves are held
+// outside tailscaled, for signing with tka.ExternalSigner.
+//
+// Two kinds of signer are supported: an SSH agent holding the key as an
+// ed25519 SSH key (as hardware tokens' agents and most cloud KMS agent
+// bridges do), and a helper command, for HSMs and KMSes with their own
+// tooling.
+package extsigner
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"tailscale.com/tka"
+	"tailscale.com/types/key"
+)
+
+// Key is a tailnet lock key held by an external signer. It must be closed
+// when no longer needed.
+type Key interface {
+	tka.ExternalKey
+	Close() error
+}
+
+// Open returns the key held by the signer described by spec, which is one
+// of:
+//
+//   - "ssh-agent", for the SSH agent at $SSH_AUTH_SOCK
+//   - "ssh-agent:<path>", for the SSH agent listening at path
+//   - "exec:<command> [args...]", for a helper command
+//
+// The key's public half pub selects which of an SSH agent's keys to use,
+// and may be zero if the agent holds exactly one ed25519 key. It must be
+// set for a helper command.
+func Open(spec string, pub key.NLPublic) (Key, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "ssh-agent":
+		path := arg
+		if path == "" {
+			path = os.Getenv("SSH_AUTH_SOCK")
+		}
+		if path == "" {
+			return nil, errors.New("no SSH agent path given and $SSH_AUTH_SOCK not set")
+		}
+		c, err := net.Dial("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to SSH agent: %w", err)
+		}
+		k, err := NewAgentKey(agent.NewClient(c), pub)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		k.closer = c.Close
+		return k, nil
+	case "exec":
+		argv := strings.Fields(arg)
+		if len(argv) == 0 {
+			return nil, errors.New("no command given for exec signer")
+		}
+		if pub.IsZero() {
+			return nil, errors.New("exec signer requires the signing key's public key")
+		}
+		return &commandKey{argv: argv, pub: pub}, nil
+	}
+	return nil, fmt.Errorf("unknown signer %q; want ssh-agent[:<path>] or exec:<command>", spec)
+}
+
+// AgentKey is a tailnet lock key held by an SSH agent as an ed25519 SSH key.
+type AgentKey struct {
+	agent  agent.Agent
+	key    ssh.PublicKey
+	pub    key.NLPublic
+	closer func() error // or nil
+}
+
+// NewAgentKey returns the key held by a with public half pub, or, if pub is
+// zero, the only ed25519 key a holds.
+func NewAgentKey(a agent.Agent, pub key.NLPublic) (*AgentKey, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("listing SSH agent keys: %w", err)
+	}
+	var found []*AgentKey
+	for _, k := range keys {
+		if k.Format != ssh.KeyAlgoED25519 {
+			continue
+		}
+		sk, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		cpk, ok := sk.(ssh.CryptoPublicKey)
+		if !ok {
+			continue
+		}
+		edk, ok := cpk.CryptoPublicKey().(ed25519.PublicKey)
+		if !ok {
+			continue
+		}
+		nlk := key.NLPublicFromEd25519Unsafe(edk)
+		if !pub.IsZero() && !nlk.Equal(pub) {
+			continue
+		}
+		found = append(found, &AgentKey{agent: a, key: sk, pub: nlk})
+	}
+	switch {
+	case len(found) == 0 && pub.IsZero():
+		return nil, errors.New("SSH agent holds no ed25519 keys")
+	case len(found) == 0:
+		return nil, fmt.Errorf("SSH agent does not hold key %s", pub.CLIString())
+	case len(found) > 1:
+		return nil, errors.New("SSH agent holds several ed25519 keys; specify which to use")
+	}
+	return found[0], nil
+}
+
+// Public implements tka.ExternalKey.
+func (k *AgentKey) Public() key.NLPublic { return k.pub }
+
+// SignDigest implements tka.ExternalKey.
+func (k *AgentKey) SignDigest(digest []byte) ([]byte, error) {
+	sig, err := k.agent.Sign(k.key, digest)
+	if err != nil {
+		return nil, fmt.Errorf("SSH agent: %w", err)
+	}
+	if sig.Format != ssh.KeyAlgoED25519 {
+		return nil, fmt.Errorf("SSH agent returned %q signature, want %q", sig.Format, ssh.KeyAlgoED25519)
+	}
+	return sig.Blob, nil
+}
+
+// Close closes the connection to the SSH agent, if Open made it.
+func (k *AgentKey) Close() error {
+	if k.closer == nil {
+		return nil
+	}
+	return k.closer()
+}
+
+// commandTimeout is how long a helper command may take to sign, which
+// includes any time waiting for the user, such as to touch a token.
+const commandTimeout = 2 * time.Minute
+
+// commandKey is a tailnet lock key used by running a helper command. The
+// command is given the hex-encoded digest to sign on a line on its standard
+// input, and must print the hex-encoded ed25519 signature to its standard
+// output.
+type commandKey struct {
+	argv []string
+	pub  key.NLPublic
+}
+
+func (k *commandKey) Public() key.NLPublic { return k.pub }
+
+func (k *commandKey) SignDigest(digest []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, k.argv[0], k.argv[1:]...)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(digest) + "\n")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %w: %s", k.argv[0], err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	sig, err := hex.DecodeString(string(bytes.TrimSpace(out)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature from %s: %w", k.argv[0], err)
+	}
+	return sig, nil
+}
+
+func (k *commandKey) Close() error { return nil }
@@ -0,0 +1,72 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package extsigner
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"golang.org/x/crypto/ssh/agent"
+	"tailscale.com/types/key"
+)
+
+func TestAgentKey(t *testing.T) {
+	kr := agent.NewKeyring()
+	newKey := func() key.NLPublic {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := kr.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+			t.Fatal(err)
+		}
+		return key.NLPublicFromEd25519Unsafe(pub)
+	}
+
+	if _, err := NewAgentKey(kr, key.NLPublic{}); err == nil {
+		t.Error("NewAgentKey with empty agent succeeded")
+	}
+
+	pub1 := newKey()
+	k, err := NewAgentKey(kr, key.NLPublic{})
+	if err != nil {
+		t.Fatalf("NewAgentKey with one key: %v", err)
+	}
+	if !k.Public().Equal(pub1) {
+		t.Errorf("Public() = %v; want %v", k.Public(), pub1)
+	}
+	digest := []byte("0123456789abcdef0123456789abcdef")
+	sig, err := k.SignDigest(digest)
+	if err != nil {
+		t.Fatalf("SignDigest: %v", err)
+	}
+	if !ed25519.Verify(pub1.Verifier(), digest, sig) {
+		t.Error("signature does not verify")
+	}
+
+	pub2 := newKey()
+	if _, err := NewAgentKey(kr, key.NLPublic{}); err == nil {
+		t.Error("NewAgentKey with several keys and none chosen succeeded")
+	}
+	k, err = NewAgentKey(kr, pub2)
+	if err != nil {
+		t.Fatalf("NewAgentKey choosing key: %v", err)
+	}
+	if !k.Public().Equal(pub2) {
+		t.Errorf("Public() = %v; want %v", k.Public(), pub2)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	for _, spec := range []string{"", "pkcs11", "exec:", "ssh-agent:/nonexistent/agent.sock"} {
+		if k, err := Open(spec, key.NLPublic{}); err == nil {
+			k.Close()
+			t.Errorf("Open(%q) succeeded", spec)
+		}
+	}
+	if _, err := Open("exec:/bin/signer", key.NLPublic{}); err == nil {
+		t.Error("Open of exec signer without public key succeeded")
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/blake2s"
+	"tailscale.com/atomicfile"
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// maxSigCacheEntries is the most signatures a SigCache holds. It's
+// emptied when it would exceed it, which should only happen after many
+// node key rotations.
+const maxSigCacheEntries = 1 << 16
+
+// SigCache remembers the node-key signatures that verified against an
+// Authority's trusted keys, so that they needn't be verified again, such as
+// when every peer of a large locked tailnet is checked at startup. It can be
+// saved to a file to persist across restarts.
+//
+// Entries are only valid for the set of trusted keys they were verified
+// with: any change to the trusted keys empties the cache.
+//
+// A nil SigCache caches nothing. Its methods are safe for concurrent use.
+type SigCache struct {
+	path string // file it's saved to, or empty to not persist
+
+	mu      sync.Mutex
+	trust   sigHash // trustDigest of the keys the entries verified with
+	entries map[sigCacheKey]bool
+	dirty   bool // whether entries changed since last saved
+}
+
+// sigHash is a BLAKE2s hash, hex-encoded in JSON.
+type sigHash [blake2s.Size]byte
+
+func (h sigHash) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h[:])), nil
+}
+
+func (h *sigHash) UnmarshalText(b []byte) error {
+	if hex.DecodedLen(len(b)) != len(h) {
+		return errors.New("invalid hash length")
+	}
+	_, err := hex.Decode(h[:], b)
+	return err
+}
+
+// sigCacheKey identifies a signature of a node key.
+type sigCacheKey struct {
+	NodeKey key.NodePublic
+	SigHash sigHash // of the marshaled signature
+}
+
+// sigCacheFile is the JSON file a SigCache is saved in.
+type sigCacheFile struct {
+	Trust   sigHash
+	Entries []sigCacheKey
+}
+
+// NewSigCache returns a SigCache that's saved to the file at path, loading
+// the entries saved there, if any. An unreadable file is ignored. If path
+// is empty, the cache isn't persisted.
+func NewSigCache(path string) *SigCache {
+	c := &SigCache{path: path}
+	if path == "" {
+		return c
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return c
+	}
+	var f sigCacheFile
+	if err := json.Unmarshal(b, &f); err != nil || len(f.Entries) > maxSigCacheEntries {
+		return c
+	}
+	c.trust = f.Trust
+	c.entries = make(map[sigCacheKey]bool, len(f.Entries))
+	for _, k := range f.Entries {
+		c.entries[k] = true
+	}
+	return c
+}
+
+// trustDigest returns a hash of the trusted keys of a, which determine
+// which signatures verify.
+func (a *Authority) trustDigest() sigHash {
+	keys := make([]Key, len(a.state.Keys))
+	copy(keys, a.state.Keys)
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		return bytes.Compare(keys[i].Public, keys[j].Public) < 0
+	})
+	h, _ := blake2s.New256(nil)
+	for _, k := range keys {
+		h.Write([]byte{byte(k.Kind), byte(len(k.Public))})
+		h.Write(k.Public)
+	}
+	var d sigHash
+	h.Sum(d[:0])
+	return d
+}
+
+// NodeKeyAuthorized is like a.NodeKeyAuthorized, but returns nil without
+// verifying nodeKeySignature again if it's known to verify against the
+// current trusted keys of a, and remembers it if it does.
+func (c *SigCache) NodeKeyAuthorized(a *Authority, nodeKey key.NodePublic, nodeKeySignature tkatype.MarshaledSignature) error {
+	if c == nil {
+		return a.NodeKeyAuthorized(nodeKey, nodeKeySignature)
+	}
+	trust := a.trustDigest()
+	k := sigCacheKey{NodeKey: nodeKey, SigHash: blake2s.Sum256(nodeKeySignature)}
+
+	c.mu.Lock()
+	if c.trust != trust {
+		// The trusted keys changed: what verified before may not now.
+		if len(c.entries) > 0 {
+			c.dirty = true
+		}
+		c.trust = trust
+		c.entries = nil
+	}
+	ok := c.entries[k]
+	c.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	if err := a.NodeKeyAuthorized(nodeKey, nodeKeySignature); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.trust == trust {
+		if len(c.entries) >= maxSigCacheEntries {
+			c.entries = nil
+		}
+		if c.entries == nil {
+			c.entries = map[sigCacheKey]bool{}
+		}
+		c.entries[k] = true
+		c.dirty = true
+	}
+	return nil
+}
+
+// Save saves the cache to its file, if it changed since it was loaded or
+// last saved.
+func (c *SigCache) Save() error {
+	if c == nil || c.path == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	f := sigCacheFile{
+		Trust:   c.trust,
+		Entries: make([]sigCacheKey, 0, len(c.entries)),
+	}
+	for k := range c.entries {
+		f.Entries = append(f.Entries, k)
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(c.path, b, 0600); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// The authority's directory was removed, such as when
+			// tailnet lock was disabled.
+			return nil
+		}
+		return err
+	}
+	c.dirty = false
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tka
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2s"
+	"tailscale.com/types/key"
+)
+
+func TestSigCache(t *testing.T) {
+	create := func(seed int64) (*Authority, ExternalSigner) {
+		pub, priv := testingKey25519(t, seed)
+		signer := ExternalSigner{Key: testExternalKey{priv: priv, pub: key.NLPublicFromEd25519Unsafe(pub)}}
+		a, _, err := Create(&Mem{}, State{
+			Keys:               []Key{{Kind: Key25519, Public: pub, Votes: 1}},
+			DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+		}, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return a, signer
+	}
+	a1, signer := create(1)
+	nodeKey := key.NewNode().Public()
+	sig, err := SignNodeKey(nodeKey, nil, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := sigCacheKey{NodeKey: nodeKey, SigHash: blake2s.Sum256(sig.Serialize())}
+
+	var nilCache *SigCache
+	if err := nilCache.NodeKeyAuthorized(a1, nodeKey, sig.Serialize()); err != nil {
+		t.Errorf("nil cache: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "sig-cache.json")
+	c := NewSigCache(path)
+	if err := c.NodeKeyAuthorized(a1, nodeKey, sig.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NodeKeyAuthorized(a1, key.NewNode().Public(), sig.Serialize()); err == nil {
+		t.Error("signature authorized another node key")
+	}
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c = NewSigCache(path)
+	if !c.entries[k] || len(c.entries) != 1 {
+		t.Fatalf("loaded entries = %v; want only %v", c.entries, k)
+	}
+	if err := c.NodeKeyAuthorized(a1, nodeKey, sig.Serialize()); err != nil {
+		t.Fatal(err)
+	}
+
+	// An authority trusting other keys must not use the cached result.
+	a2, _ := create(2)
+	if err := c.NodeKeyAuthorized(a2, nodeKey, sig.Serialize()); err == nil {
+		t.Error("signature authorized after the trusted keys changed")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("entries = %v after the trusted keys changed; want none", c.entries)
+	}
+}
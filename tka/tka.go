@@ -837,3 +837,74 @@ func (a *Authority) MakeRetroactiveRevocation(storage Chonk, removeKeys []tkatyp
 
 	return forkingAUM, forkingAUM.StaticValidate()
 }
+
+// RevocationProgress describes how close a retroactive revocation, as
+// generated by MakeRetroactiveRevocation, is to having enough signatures.
+type RevocationProgress struct {
+	// Revoked are the currently-trusted keys that the revocation revokes.
+	Revoked []Key
+
+	// Signers are the keys that validly signed the revocation and remain
+	// trusted after it.
+	Signers []Key
+
+	// Weight is the sum of the votes of Signers.
+	Weight uint
+
+	// RequiredWeight is the Weight needed for the revocation to be
+	// preferred over the chain it forks from: one more than the sum of
+	// the votes of Revoked.
+	RequiredWeight uint
+}
+
+// Ready reports whether the revocation has enough signatures to be submitted.
+func (p RevocationProgress) Ready() bool {
+	return p.Weight >= p.RequiredWeight
+}
+
+// RevocationProgress reports which keys the retroactive revocation aum
+// revokes, and which trusted keys have signed it so far.
+//
+// An error is returned if aum is not a checkpoint or has an invalid
+// signature from a trusted key.
+func (a *Authority) RevocationProgress(aum *AUM) (RevocationProgress, error) {
+	if aum.MessageKind != AUMCheckpoint || aum.State == nil {
+		return RevocationProgress{}, errors.New("not a revocation: AUM is not a checkpoint")
+	}
+
+	var p RevocationProgress
+	for _, k := range a.state.Keys {
+		keyID, err := k.ID()
+		if err != nil {
+			return RevocationProgress{}, fmt.Errorf("computing keyID: %v", err)
+		}
+		if _, err := aum.State.GetKey(keyID); err == ErrNoSuchKey {
+			p.Revoked = append(p.Revoked, k.Clone())
+			p.RequiredWeight += k.Votes
+		}
+	}
+	p.RequiredWeight++
+
+	sigHash := aum.SigHash()
+	seenKeys := make(set.Set[string], len(aum.Signatures))
+	for i := range aum.Signatures {
+		sig := &aum.Signatures[i]
+		if seenKeys.Contains(string(sig.KeyID)) {
+			continue
+		}
+		k, err := a.state.GetKey(sig.KeyID)
+		if err != nil {
+			continue // not currently trusted
+		}
+		if _, err := aum.State.GetKey(sig.KeyID); err != nil {
+			continue // revoked
+		}
+		if err := signatureVerify(sig, sigHash, k); err != nil {
+			return RevocationProgress{}, fmt.Errorf("signature %d: %v", i, err)
+		}
+		seenKeys.Add(string(sig.KeyID))
+		p.Signers = append(p.Signers, k.Clone())
+		p.Weight += k.Votes
+	}
+	return p, nil
+}
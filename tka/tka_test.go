@@ -652,3 +652,76 @@ func TestMakeRetroactiveRevocation(t *testing.T) {
 		t.Fatalf("MakeRetroactiveRevocation({k1, k2, k3}) returned %v, expected %q", err, wantErr)
 	}
 }
+
+func TestRevocationProgress(t *testing.T) {
+	pub, priv := testingKey25519(t, 1)
+	k1 := Key{Kind: Key25519, Public: pub, Votes: 1}
+	pub2, priv2 := testingKey25519(t, 2)
+	k2 := Key{Kind: Key25519, Public: pub2, Votes: 1}
+	pub3, priv3 := testingKey25519(t, 3)
+	k3 := Key{Kind: Key25519, Public: pub3, Votes: 1}
+
+	c := newTestchain(t, `
+        A -> B
+        A.template = genesis
+    `,
+		optTemplate("genesis", AUM{MessageKind: AUMCheckpoint, State: &State{
+			Keys:               []Key{k1, k2, k3},
+			DisablementSecrets: [][]byte{DisablementKDF([]byte{1, 2, 3})},
+		}}))
+	a, err := Open(c.Chonk())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k1ID, _ := k1.ID()
+	k3ID, _ := k3.ID()
+	aum, err := a.MakeRetroactiveRevocation(c.Chonk(), []tkatype.KeyID{k3ID}, k1ID, c.AUMHashes["A"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(wantSigners []Key, wantReady bool) {
+		t.Helper()
+		p, err := a.RevocationProgress(aum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Revoked) != 1 || !bytes.Equal(p.Revoked[0].Public, k3.Public) {
+			t.Errorf("Revoked = %v; want [k3]", p.Revoked)
+		}
+		if p.RequiredWeight != 2 {
+			t.Errorf("RequiredWeight = %d; want 2", p.RequiredWeight)
+		}
+		if len(p.Signers) != len(wantSigners) {
+			t.Fatalf("got %d signers; want %d", len(p.Signers), len(wantSigners))
+		}
+		for i := range wantSigners {
+			if !bytes.Equal(p.Signers[i].Public, wantSigners[i].Public) {
+				t.Errorf("Signers[%d] = %x; want %x", i, p.Signers[i].Public, wantSigners[i].Public)
+			}
+		}
+		if p.Ready() != wantReady {
+			t.Errorf("Ready = %v; want %v", p.Ready(), wantReady)
+		}
+	}
+
+	check(nil, false)
+	// A signature by the revoked key doesn't count.
+	if err := aum.sign25519(priv3); err != nil {
+		t.Fatal(err)
+	}
+	check(nil, false)
+	if err := aum.sign25519(priv); err != nil {
+		t.Fatal(err)
+	}
+	check([]Key{k1}, false)
+	if err := aum.sign25519(priv2); err != nil {
+		t.Fatal(err)
+	}
+	check([]Key{k1, k2}, true)
+
+	aum.Signatures[1].Signature[0] ^= 1
+	if _, err := a.RevocationProgress(aum); err == nil {
+		t.Error("RevocationProgress with corrupt signature succeeded; want error")
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package verify checks exported tailnet lock (TKA) history independently
+// of a running Tailscale client.
+//
+// A Log is produced by 'tailscale lock export-log'. Verifying it replays
+// every update from the oldest exported checkpoint, checking each update's
+// signatures against the keys trusted at that point, so that an auditor
+// only needs to trust the first AUM of the log.
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"tailscale.com/tka"
+	"tailscale.com/types/tkatype"
+)
+
+// LogVersion is the current version of the Log format.
+const LogVersion = 1
+
+// Log is a portable export of the AUM chain of a tailnet key authority.
+type Log struct {
+	// Version is the version of the format, currently LogVersion.
+	Version int
+
+	// Head is the hash of the last AUM in the chain, as reported by
+	// the exporting client.
+	Head tka.AUMHash
+
+	// AUMs are the serialized AUMs of the chain, oldest first. The first
+	// is the genesis AUM, or the oldest retained checkpoint if older
+	// history was compacted away by the exporting client.
+	AUMs []tkatype.MarshaledAUM
+}
+
+// Update describes one verified AUM of a Log.
+type Update struct {
+	Hash    tka.AUMHash
+	Kind    tka.AUMKind
+	Signers []tkatype.KeyID // key IDs of the signatures on the AUM
+	AUM     tka.AUM
+}
+
+// Result describes a verified Log.
+type Result struct {
+	// Updates are the AUMs of the log, oldest first.
+	Updates []Update
+
+	// IsGenesis reports whether the first AUM is the genesis AUM. If
+	// false, the log starts at a later checkpoint and history before it
+	// was not verified.
+	IsGenesis bool
+
+	// Head is the hash of the last AUM.
+	Head tka.AUMHash
+
+	// Keys are the keys trusted after the last AUM is applied.
+	Keys []tka.Key
+
+	// StateID1 and StateID2 identify the authority.
+	StateID1, StateID2 uint64
+}
+
+// Decode reads a Log in its JSON encoding from r.
+func Decode(r io.Reader) (*Log, error) {
+	var l Log
+	if err := json.NewDecoder(r).Decode(&l); err != nil {
+		return nil, fmt.Errorf("decoding log: %w", err)
+	}
+	if l.Version != LogVersion {
+		return nil, fmt.Errorf("unsupported log version %d; want %d", l.Version, LogVersion)
+	}
+	return &l, nil
+}
+
+// Verify checks that the AUMs in l form a single chain ending at l.Head,
+// and that each AUM is validly signed by keys trusted at the point in the
+// chain where it is applied.
+func (l *Log) Verify() (*Result, error) {
+	if len(l.AUMs) == 0 {
+		return nil, errors.New("log contains no AUMs")
+	}
+	aums := make([]tka.AUM, len(l.AUMs))
+	for i, raw := range l.AUMs {
+		if err := aums[i].Unserialize(raw); err != nil {
+			return nil, fmt.Errorf("AUM %d: decoding: %w", i, err)
+		}
+	}
+
+	storage := &tka.Mem{}
+	authority, err := tka.Bootstrap(storage, aums[0])
+	if err != nil {
+		return nil, fmt.Errorf("AUM 0: %w", err)
+	}
+	_, hasParent := aums[0].Parent()
+	res := &Result{
+		IsGenesis: !hasParent,
+		Updates:   make([]Update, 0, len(aums)),
+	}
+	for i, aum := range aums {
+		hash := aum.Hash()
+		if i > 0 {
+			if parent, _ := aum.Parent(); parent != res.Updates[i-1].Hash {
+				return nil, fmt.Errorf("AUM %d (%v): parent %v is not the previous AUM", i, hash, parent)
+			}
+			if err := authority.Inform(storage, []tka.AUM{aum}); err != nil {
+				return nil, fmt.Errorf("AUM %d (%v): %w", i, hash, err)
+			}
+			if authority.Head() != hash {
+				return nil, fmt.Errorf("AUM %d (%v): not applied to the active chain", i, hash)
+			}
+		}
+		u := Update{
+			Hash: hash,
+			Kind: aum.MessageKind,
+			AUM:  aum,
+		}
+		for _, sig := range aum.Signatures {
+			u.Signers = append(u.Signers, sig.KeyID)
+		}
+		res.Updates = append(res.Updates, u)
+	}
+
+	res.Head = authority.Head()
+	if res.Head != l.Head {
+		return nil, fmt.Errorf("chain ends at %v, but log head is %v", res.Head, l.Head)
+	}
+	res.Keys = authority.Keys()
+	res.StateID1, res.StateID2 = authority.StateIDs()
+	return res, nil
+}
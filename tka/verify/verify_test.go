@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"tailscale.com/tka"
+	"tailscale.com/types/key"
+	"tailscale.com/types/tkatype"
+)
+
+// testLog returns a log with a genesis AUM trusting priv, followed by an
+// update adding another key and an update removing it.
+func testLog(t *testing.T) (*Log, key.NLPrivate) {
+	t.Helper()
+	priv := key.NewNLPrivate()
+	other := key.NewNLPrivate()
+	storage := &tka.Mem{}
+	authority, genesis, err := tka.Create(storage, tka.State{
+		Keys:               []tka.Key{{Kind: tka.Key25519, Public: priv.Public().Verifier(), Votes: 1}},
+		DisablementSecrets: [][]byte{tka.DisablementKDF(bytes.Repeat([]byte{0xa5}, 32))},
+	}, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aums := []tka.AUM{genesis}
+	apply := func(build func(*tka.UpdateBuilder) error) {
+		t.Helper()
+		b := authority.NewUpdater(priv)
+		if err := build(b); err != nil {
+			t.Fatal(err)
+		}
+		updates, err := b.Finalize(storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := authority.Inform(storage, updates); err != nil {
+			t.Fatal(err)
+		}
+		aums = append(aums, updates...)
+	}
+	otherKey := tka.Key{Kind: tka.Key25519, Public: other.Public().Verifier(), Votes: 1}
+	apply(func(b *tka.UpdateBuilder) error { return b.AddKey(otherKey) })
+	apply(func(b *tka.UpdateBuilder) error { return b.RemoveKey(other.KeyID()) })
+
+	l := &Log{Version: LogVersion, Head: authority.Head()}
+	for _, a := range aums {
+		l.AUMs = append(l.AUMs, a.Serialize())
+	}
+	return l, priv
+}
+
+func TestVerify(t *testing.T) {
+	l, priv := testLog(t)
+
+	// Round-trip through the JSON encoding, as an auditor would.
+	j, err := json.Marshal(l)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err = Decode(bytes.NewReader(j))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := l.Verify()
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if !res.IsGenesis {
+		t.Error("IsGenesis = false; want true")
+	}
+	if res.Head != l.Head {
+		t.Errorf("Head = %v; want %v", res.Head, l.Head)
+	}
+	wantKinds := []tka.AUMKind{tka.AUMCheckpoint, tka.AUMAddKey, tka.AUMRemoveKey}
+	if len(res.Updates) != len(wantKinds) {
+		t.Fatalf("got %d updates; want %d", len(res.Updates), len(wantKinds))
+	}
+	for i, u := range res.Updates {
+		if u.Kind != wantKinds[i] {
+			t.Errorf("update %d kind = %v; want %v", i, u.Kind, wantKinds[i])
+		}
+		if len(u.Signers) != 1 || !bytes.Equal(u.Signers[0], priv.KeyID()) {
+			t.Errorf("update %d signers = %x; want [%x]", i, u.Signers, priv.KeyID())
+		}
+	}
+	if len(res.Keys) != 1 || !bytes.Equal(res.Keys[0].Public, priv.Public().Verifier()) {
+		t.Errorf("Keys = %+v; want only the genesis key", res.Keys)
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Log)
+		wantErr string
+	}{
+		{
+			name:    "empty",
+			modify:  func(l *Log) { l.AUMs = nil },
+			wantErr: "no AUMs",
+		},
+		{
+			name:    "wrong-head",
+			modify:  func(l *Log) { l.Head = tka.AUMHash{} },
+			wantErr: "log head",
+		},
+		{
+			name:    "missing-update",
+			modify:  func(l *Log) { l.AUMs = append(l.AUMs[:1], l.AUMs[2:]...) },
+			wantErr: "not the previous AUM",
+		},
+		{
+			name: "bad-signature",
+			modify: func(l *Log) {
+				var aum tka.AUM
+				if err := aum.Unserialize(l.AUMs[1]); err != nil {
+					panic(err)
+				}
+				aum.Signatures[0].Signature[0] ^= 0xff
+				l.AUMs[1] = aum.Serialize()
+			},
+			wantErr: "signature",
+		},
+		{
+			name: "unknown-signer",
+			modify: func(l *Log) {
+				var aum tka.AUM
+				if err := aum.Unserialize(l.AUMs[2]); err != nil {
+					panic(err)
+				}
+				sigs, err := key.NewNLPrivate().SignAUM(aum.SigHash())
+				if err != nil {
+					panic(err)
+				}
+				aum.Signatures = []tkatype.Signature{sigs[0]}
+				l.AUMs[2] = aum.Serialize()
+			},
+			wantErr: "bad keyID",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := testLog(t)
+			tt.modify(l)
+			_, err := l.Verify()
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Verify err = %v; want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecodeVersion(t *testing.T) {
+	if _, err := Decode(strings.NewReader(`{"Version": 99}`)); err == nil {
+		t.Error("Decode of unknown version succeeded")
+	}
+}
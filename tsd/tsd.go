@@ -22,6 +22,7 @@
 	"reflect"
 
 	"tailscale.com/ipn"
+	"tailscale.com/ipn/conffile"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/tsdial"
@@ -42,6 +43,10 @@ type System struct {
 	Router         SubSystem[router.Router]
 	Tun            SubSystem[*tstun.Wrapper]
 	StateStore     SubSystem[ipn.StateStore]
+
+	// InitialConfig is the config file tailscaled was started with, if
+	// any. When non-nil, the node runs in declarative config-file mode.
+	InitialConfig *conffile.Config
 }
 
 // Set is a convenience method to set a subsystem value.
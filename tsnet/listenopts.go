@@ -0,0 +1,198 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ListenOpts are per-peer resource limits of a listener created by
+// ListenWithOpts or ListenTLSWithOpts, to keep a single misbehaving client
+// from exhausting a small device. A peer is identified by the IP address
+// its connections come from. The zero value means no limits.
+type ListenOpts struct {
+	// MaxConns, if non-zero, is the maximum number of concurrent
+	// connections accepted from each peer. Connections beyond it are
+	// closed as soon as they're established.
+	MaxConns int
+
+	// PerPeerRate, if non-zero, is the maximum number of bytes per second
+	// each peer may send and receive, in each direction, shared by all
+	// its connections. Reads and writes beyond it block until the rate
+	// allows them.
+	PerPeerRate int
+}
+
+// rateBurst is how much unused rate a peer's limiter lets it spend at
+// once, so that short idle periods don't slow down the next exchange.
+const rateBurst = 100 * time.Millisecond
+
+// ListenWithOpts is like Listen, but limits the resources each peer may
+// use with the listener, per opts. Only TCP is supported.
+func (s *Server) ListenWithOpts(network, addr string, opts ListenOpts) (net.Listener, error) {
+	if err := opts.check(network, addr); err != nil {
+		return nil, err
+	}
+	return s.listen(network, addr, listenOnTailnet, opts)
+}
+
+// ListenTLSWithOpts is like ListenTLS, but limits the resources each peer
+// may use with the listener, per opts. PerPeerRate applies to the
+// encrypted bytes.
+func (s *Server) ListenTLSWithOpts(network, addr string, opts ListenOpts) (net.Listener, error) {
+	if err := opts.check(network, addr); err != nil {
+		return nil, err
+	}
+	return s.listenTLS(network, addr, opts)
+}
+
+func (o ListenOpts) check(network, addr string) error {
+	switch network {
+	case "", "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("tsnet: ListenOpts for (%q, %q): only tcp is supported", network, addr)
+	}
+	if o.MaxConns < 0 || o.PerPeerRate < 0 {
+		return fmt.Errorf("tsnet: ListenOpts for (%q, %q): negative limit", network, addr)
+	}
+	return nil
+}
+
+// peerLimits is the state of the ListenOpts limits of a peer of a
+// listener.
+type peerLimits struct {
+	conns  int              // guarded by listener.peersMu
+	rx, tx *byteRateLimiter // nil without PerPeerRate
+}
+
+// peerAddr returns the IP address of the peer at the other end of c.
+func peerAddr(c net.Conn) netip.Addr {
+	if ta, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+		return ta.AddrPort().Addr().Unmap()
+	}
+	ap, _ := netip.ParseAddrPort(c.RemoteAddr().String())
+	return ap.Addr().Unmap()
+}
+
+// limitConn returns c subject to the listener's ListenOpts limits for its
+// peer, or nil if the peer is at its MaxConns.
+func (ln *listener) limitConn(c net.Conn) net.Conn {
+	peer := peerAddr(c)
+	ln.peersMu.Lock()
+	defer ln.peersMu.Unlock()
+	pl := ln.peers[peer]
+	if pl == nil {
+		pl = new(peerLimits)
+		if rate := ln.opts.PerPeerRate; rate > 0 {
+			pl.rx = newByteRateLimiter(rate)
+			pl.tx = newByteRateLimiter(rate)
+		}
+		if ln.peers == nil {
+			ln.peers = make(map[netip.Addr]*peerLimits)
+		}
+		ln.peers[peer] = pl
+	}
+	if ln.opts.MaxConns > 0 && pl.conns >= ln.opts.MaxConns {
+		return nil
+	}
+	pl.conns++
+	return &limitedConn{Conn: c, ln: ln, peer: peer, limits: pl}
+}
+
+// releaseConn forgets a connection from peer that was closed.
+func (ln *listener) releaseConn(peer netip.Addr) {
+	ln.peersMu.Lock()
+	defer ln.peersMu.Unlock()
+	pl := ln.peers[peer]
+	if pl == nil {
+		return
+	}
+	pl.conns--
+	if pl.conns <= 0 {
+		delete(ln.peers, peer)
+	}
+}
+
+// limitedConn is a connection accepted by a listener with ListenOpts,
+// counted against its peer's limits.
+type limitedConn struct {
+	net.Conn
+	ln        *listener
+	peer      netip.Addr
+	limits    *peerLimits
+	closeOnce sync.Once
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if c.limits.rx != nil {
+		p = p[:min(len(p), c.limits.rx.chunk)]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.limits.rx != nil {
+		c.limits.rx.wait(n)
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	if c.limits.tx == nil {
+		return c.Conn.Write(p)
+	}
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), c.limits.tx.chunk)]
+		c.limits.tx.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (c *limitedConn) Close() error {
+	c.closeOnce.Do(func() { c.ln.releaseConn(c.peer) })
+	return c.Conn.Close()
+}
+
+// byteRateLimiter limits a flow of bytes to a rate, by making the callers
+// that exceed it wait.
+type byteRateLimiter struct {
+	rate  int64 // bytes per second
+	chunk int   // max bytes to allow at once: the rate's burst
+
+	mu sync.Mutex
+	// next is when all the bytes allowed so far will have been paid
+	// for. It's at most rateBurst in the past, so that a burst of bytes
+	// can be allowed without waiting.
+	next time.Time
+}
+
+func newByteRateLimiter(bytesPerSec int) *byteRateLimiter {
+	return &byteRateLimiter{
+		rate:  int64(bytesPerSec),
+		chunk: max(int(int64(bytesPerSec)*int64(rateBurst)/int64(time.Second)), 1),
+	}
+}
+
+// wait blocks until n more bytes are allowed by the rate.
+func (l *byteRateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if earliest := now.Add(-rateBurst); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	d := l.next.Sub(now)
+	l.mu.Unlock()
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
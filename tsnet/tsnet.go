@@ -10,6 +10,7 @@
 	"context"
 	crand "crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -28,6 +29,7 @@
 	"sync"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"tailscale.com/client/tailscale"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
@@ -41,18 +43,27 @@
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
 	"tailscale.com/logtail/filch"
+	"tailscale.com/net/connstats"
+	"tailscale.com/net/dns/resolver"
 	"tailscale.com/net/memnet"
 	"tailscale.com/net/netmon"
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/smallzstd"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tsd"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
+	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/memprofile"
+	"tailscale.com/util/singleflight"
 	"tailscale.com/util/testenv"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/netstack"
@@ -112,6 +123,33 @@ type Server struct {
 	// field at zero unless you know what you are doing.
 	Port uint16
 
+	// PacketListener optionally specifies how to open the UDP sockets
+	// for WireGuard and peer-to-peer traffic. It's intended for tests
+	// that run nodes in a simulated network; see package
+	// tailscale.com/tstest/natlab/natlabtest.
+	// If nil, the host's network is used.
+	PacketListener nettype.PacketListener
+
+	// MemoryProfile optionally specifies the memory profile to apply when
+	// the Server starts, such as memprofile.Low on devices with little
+	// memory. Memory profiles are process-wide: they set the garbage
+	// collector's GOGC and GOMEMLIMIT, unless those are in the
+	// environment, and apply to all Servers started afterwards. If
+	// empty, the process's current profile is left as is.
+	MemoryProfile memprofile.Profile
+
+	// AdvertiseRoutes optionally specifies subnet routes for the Server
+	// to advertise to the tailnet, making it a subnet router. Traffic
+	// from peers to addresses in the routes is forwarded in userspace,
+	// from the host's network. Routes must still be approved in the
+	// admin console, unless an autoApprovers policy approves them.
+	AdvertiseRoutes []netip.Prefix
+
+	// ExitNode, if true, advertises the Server as an exit node, which
+	// forwards the Internet traffic of peers that use it in userspace,
+	// like AdvertiseRoutes. As with routes, it must be approved.
+	ExitNode bool
+
 	getCertForTesting func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
 	initOnce         sync.Once
@@ -119,7 +157,8 @@ type Server struct {
 	lb               *ipnlocal.LocalBackend
 	netstack         *netstack.Impl
 	netMon           *netmon.Monitor
-	rootPath         string // the state directory
+	resolver         *resolver.Resolver // MagicDNS resolver; see HandleDNS
+	rootPath         string             // the state directory
 	hostname         string
 	shutdownCtx      context.Context
 	shutdownCancel   context.CancelFunc
@@ -137,6 +176,10 @@ type Server struct {
 	listeners map[listenKey]*listener
 	dialer    *tsdial.Dialer
 	closed    bool
+
+	certMu      sync.Mutex
+	certs       map[string]*cachedCert // by domain
+	certFetches singleflight.Group[string, *cachedCert]
 }
 
 // Dial connects to the address on the tailnet.
@@ -218,6 +261,7 @@ func (s *Server) Loopback() (addr string, proxyCred, localAPICred string, err er
 			lah := localapi.NewHandler(s.lb, s.logf, s.netMon, s.logid)
 			lah.PermitWrite = true
 			lah.PermitRead = true
+			lah.PermitAdmin = true
 			lah.RequiredPassword = s.localAPICred
 			h := &localSecHandler{h: lah, cred: s.localAPICred}
 
@@ -421,6 +465,227 @@ func (s *Server) TailscaleIPs() (ip4, ip6 netip.Addr) {
 	return ip4, ip6
 }
 
+// ErrDNSNameNotFound is returned by a DNSHandler for a name that doesn't
+// exist, to answer NXDOMAIN.
+var ErrDNSNameNotFound = errors.New("DNS name not found")
+
+// A DNSHandler answers DNS queries for the names it's registered for with
+// HandleDNS. It returns the answer records for q, whose Name is the
+// queried name; none means the name exists but has no records of q's type.
+// If it returns ErrDNSNameNotFound the answer is NXDOMAIN, and for any
+// other error it's SERVFAIL.
+type DNSHandler func(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error)
+
+// HandleDNS registers h to answer DNS queries for name under this node's
+// MagicDNS name, and the names under it. For example, "myapp" on node
+// "foo.tailnet.ts.net" handles "myapp.foo.tailnet.ts.net" and
+// "*.myapp.foo.tailnet.ts.net". A nil h removes the handler for name.
+//
+// The queries are those made to this node's MagicDNS resolver: by the
+// node itself at 100.100.100.100, and by peers with DNS-over-HTTPS to its
+// peerapi at "/dns-query", which peers may use for these names whether
+// or not the node is an exit node.
+//
+// The server must be up, as by Up, for its MagicDNS name to be known. The
+// handler is registered under the name at the time of the call, so if the
+// node's name changes, call HandleDNS again.
+func (s *Server) HandleDNS(name string, h DNSHandler) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	nm := s.lb.NetMap()
+	if nm == nil || !nm.SelfNode.Valid() || nm.SelfNode.Name() == "" {
+		return errors.New("tsnet: node has no MagicDNS name yet; call Up first")
+	}
+	if name == "" || strings.HasSuffix(name, ".") {
+		return fmt.Errorf("tsnet: invalid DNS name %q; want a name relative to the node's", name)
+	}
+	suffix, err := dnsname.ToFQDN(name + "." + nm.SelfNode.Name())
+	if err != nil {
+		return fmt.Errorf("tsnet: invalid DNS name %q: %w", name, err)
+	}
+	if h == nil {
+		s.resolver.SetHandler(suffix, nil)
+		return nil
+	}
+	s.resolver.SetHandler(suffix, func(ctx context.Context, query []byte, _ netip.AddrPort) ([]byte, error) {
+		return serveDNSQuery(ctx, query, h)
+	})
+	return nil
+}
+
+// serveDNSQuery answers the DNS query packet query with h.
+func serveDNSQuery(ctx context.Context, query []byte, h DNSHandler) ([]byte, error) {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil {
+		return nil, err
+	}
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               req.ID,
+			Response:         true,
+			Authoritative:    true,
+			RecursionDesired: req.RecursionDesired,
+		},
+		Questions: req.Questions,
+	}
+	if len(req.Questions) != 1 {
+		resp.RCode = dnsmessage.RCodeFormatError
+		return resp.Pack()
+	}
+	answers, err := h(ctx, req.Questions[0])
+	switch {
+	case errors.Is(err, ErrDNSNameNotFound):
+		resp.RCode = dnsmessage.RCodeNameError
+	case err != nil:
+		resp.RCode = dnsmessage.RCodeServerFailure
+	default:
+		resp.Answers = answers
+	}
+	return resp.Pack()
+}
+
+// NodeEventType is the type of a NodeEvent.
+type NodeEventType int
+
+const (
+	// SelfChanged means this node's Tailscale IPs or key expiry changed.
+	SelfChanged NodeEventType = iota + 1
+	// PeerAdded means a peer joined the netmap.
+	PeerAdded
+	// PeerRemoved means a peer left the netmap.
+	PeerRemoved
+	// PeerOnlineChanged means a peer's online status changed.
+	PeerOnlineChanged
+)
+
+func (t NodeEventType) String() string {
+	switch t {
+	case SelfChanged:
+		return "SelfChanged"
+	case PeerAdded:
+		return "PeerAdded"
+	case PeerRemoved:
+		return "PeerRemoved"
+	case PeerOnlineChanged:
+		return "PeerOnlineChanged"
+	}
+	return fmt.Sprintf("NodeEventType(%d)", int(t))
+}
+
+// NodeEvent is a change to this node or one of its peers, as delivered by
+// WatchNodes.
+type NodeEvent struct {
+	Type NodeEventType
+
+	// Node is this node, for SelfChanged, or the peer as of the change.
+	// For PeerRemoved, it's the peer as last seen.
+	Node tailcfg.NodeView
+}
+
+// WatchNodes returns a channel of changes to this node and its peers,
+// starting the server if it hasn't been yet.
+//
+// Once the server has a netmap, the channel first receives a SelfChanged
+// event and a PeerAdded event for each existing peer, so the caller can
+// build its initial view of the tailnet from the same events it uses to
+// keep it up to date.
+//
+// The channel is closed when ctx is done or the server is closed. The
+// caller must keep receiving from it until then; events are dropped if it
+// falls too far behind.
+func (s *Server) WatchNodes(ctx context.Context) (<-chan NodeEvent, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.shutdownCtx, cancel)
+	ch := make(chan NodeEvent, 16)
+	go func() {
+		defer close(ch)
+		defer stop()
+		defer cancel()
+		var nd nodeDiffer
+		s.lb.WatchNotifications(ctx, ipn.NotifyInitialNetMap|ipn.NotifyNoPrivateKeys, nil, func(n *ipn.Notify) bool {
+			if n.NetMap == nil {
+				return true
+			}
+			for _, ev := range nd.update(n.NetMap) {
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return false
+				}
+			}
+			return true
+		})
+	}()
+	return ch, nil
+}
+
+// nodeDiffer turns successive netmaps into NodeEvents.
+type nodeDiffer struct {
+	self  tailcfg.NodeView // or invalid before the first netmap
+	peers map[tailcfg.NodeID]tailcfg.NodeView
+}
+
+func (d *nodeDiffer) update(nm *netmap.NetworkMap) []NodeEvent {
+	var evs []NodeEvent
+	if self := nm.SelfNode; self.Valid() {
+		if !d.self.Valid() ||
+			!views.SliceEqual(self.Addresses(), d.self.Addresses()) ||
+			!self.KeyExpiry().Equal(d.self.KeyExpiry()) {
+			evs = append(evs, NodeEvent{Type: SelfChanged, Node: self})
+		}
+		d.self = self
+	}
+
+	peers := make(map[tailcfg.NodeID]tailcfg.NodeView, len(nm.Peers))
+	for _, p := range nm.Peers {
+		peers[p.ID()] = p
+		old, ok := d.peers[p.ID()]
+		switch {
+		case !ok:
+			evs = append(evs, NodeEvent{Type: PeerAdded, Node: p})
+		case !onlineEqual(old.Online(), p.Online()):
+			evs = append(evs, NodeEvent{Type: PeerOnlineChanged, Node: p})
+		}
+	}
+	for id, p := range d.peers {
+		if _, ok := peers[id]; !ok {
+			evs = append(evs, NodeEvent{Type: PeerRemoved, Node: p})
+		}
+	}
+	d.peers = peers
+	return evs
+}
+
+// onlineEqual reports whether two tailcfg.Node.Online values are the same,
+// where nil means unknown.
+func onlineEqual(a, b *bool) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// SubscribeConnStats calls fn with the per-connection statistics of the
+// server's traffic, per opts, until ctx is done or the server is closed,
+// starting the server if it hasn't been yet. It lets embedders account for
+// the traffic of each connection without capturing packets.
+//
+// fn is called from a single goroutine, which it shouldn't block for long.
+func (s *Server) SubscribeConnStats(ctx context.Context, opts connstats.SubscribeOptions, fn func(connstats.Snapshot)) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	unsubscribe := s.lb.SubscribeConnStats(opts, fn)
+	context.AfterFunc(s.shutdownCtx, cancel)
+	context.AfterFunc(ctx, unsubscribe)
+	return nil
+}
+
 func (s *Server) getAuthKey() string {
 	if v := s.AuthKey; v != "" {
 		return v
@@ -450,6 +715,19 @@ func (s *Server) start() (reterr error) {
 	}
 	prog := strings.TrimSuffix(strings.ToLower(filepath.Base(exe)), ".exe")
 
+	if s.MemoryProfile != "" {
+		p, err := memprofile.Parse(string(s.MemoryProfile))
+		if err != nil {
+			return err
+		}
+		memprofile.Apply(p)
+	}
+	for _, r := range s.AdvertiseRoutes {
+		if !r.IsValid() || r != r.Masked() {
+			return fmt.Errorf("invalid AdvertiseRoutes prefix %v", r)
+		}
+	}
+
 	s.hostname = s.Hostname
 	if s.hostname == "" {
 		s.hostname = prog
@@ -497,22 +775,25 @@ func (s *Server) start() (reterr error) {
 	sys := new(tsd.System)
 	s.dialer = &tsdial.Dialer{Logf: logf} // mutated below (before used)
 	eng, err := wgengine.NewUserspaceEngine(logf, wgengine.Config{
-		ListenPort:   s.Port,
-		NetMon:       s.netMon,
-		Dialer:       s.dialer,
-		SetSubsystem: sys.Set,
+		ListenPort:     s.Port,
+		PacketListener: s.PacketListener,
+		NetMon:         s.netMon,
+		Dialer:         s.dialer,
+		SetSubsystem:   sys.Set,
 	})
 	if err != nil {
 		return err
 	}
 	closePool.add(s.dialer)
 	sys.Set(eng)
+	s.resolver = sys.DNSManager.Get().Resolver()
 
 	ns, err := netstack.Create(logf, sys.Tun.Get(), eng, sys.MagicSock.Get(), s.dialer, sys.DNSManager.Get())
 	if err != nil {
 		return fmt.Errorf("netstack.Create: %w", err)
 	}
 	ns.ProcessLocalIPs = true
+	ns.ProcessSubnets = s.routing()
 	ns.GetTCPHandlerForFlow = s.getTCPHandlerForFlow
 	ns.GetUDPHandlerForFlow = s.getUDPHandlerForFlow
 	s.netstack = ns
@@ -523,6 +804,9 @@ func (s *Server) start() (reterr error) {
 	s.dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 		return ns.DialContextTCP(ctx, dst)
 	}
+	s.dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns.DialContextUDP(ctx, dst)
+	}
 
 	if s.Store == nil {
 		stateFile := filepath.Join(s.rootPath, "tailscaled.state")
@@ -557,6 +841,7 @@ func (s *Server) start() (reterr error) {
 	prefs.Hostname = s.hostname
 	prefs.WantRunning = true
 	prefs.ControlURL = s.ControlURL
+	prefs.AdvertiseRoutes = s.advertisedRoutes()
 	authKey := s.getAuthKey()
 	err = lb.Start(ipn.Options{
 		UpdatePrefs: prefs,
@@ -578,6 +863,7 @@ func (s *Server) start() (reterr error) {
 	lah := localapi.NewHandler(lb, logf, s.netMon, s.logid)
 	lah.PermitWrite = true
 	lah.PermitRead = true
+	lah.PermitAdmin = true
 
 	// Create an in-process listener.
 	// nettest.Listen provides a in-memory pipe based implementation for net.Conn.
@@ -752,6 +1038,9 @@ func (s *Server) getTCPHandlerForFunnelFlow(src netip.AddrPort, dstPort uint16)
 }
 
 func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
+	if s.forwards(dst.Addr()) {
+		return nil, false // let netstack forward it
+	}
 	ln, ok := s.listenerForDstAddr("tcp", dst, false)
 	if !ok {
 		return nil, true // don't handle, don't forward to localhost
@@ -760,6 +1049,9 @@ func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net
 }
 
 func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
+	if s.forwards(dst.Addr()) {
+		return nil, false // let netstack forward it
+	}
 	ln, ok := s.listenerForDstAddr("udp", dst, false)
 	if !ok {
 		return nil, true // don't handle, don't forward to localhost
@@ -767,6 +1059,39 @@ func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(net
 	return func(c nettype.ConnPacketConn) { ln.handle(c) }, true
 }
 
+// routing reports whether s is a subnet router or exit node.
+func (s *Server) routing() bool {
+	return len(s.AdvertiseRoutes) > 0 || s.ExitNode
+}
+
+// advertisedRoutes returns the routes s advertises: AdvertiseRoutes,
+// plus the exit routes if ExitNode is set.
+func (s *Server) advertisedRoutes() []netip.Prefix {
+	if !s.routing() {
+		return nil
+	}
+	routes := slices.Clone(s.AdvertiseRoutes)
+	if s.ExitNode {
+		for _, r := range tsaddr.ExitRoutes() {
+			if !slices.Contains(routes, r) {
+				routes = append(routes, r)
+			}
+		}
+	}
+	return routes
+}
+
+// forwards reports whether traffic from peers to dst is forwarded to the
+// host's network, rather than handled by s's listeners: whether s is a
+// subnet router or exit node and dst isn't one of its own Tailscale IPs.
+func (s *Server) forwards(dst netip.Addr) bool {
+	if !s.routing() || tsaddr.IsTailscaleIP(dst) {
+		return false
+	}
+	ip4, ip6 := s.TailscaleIPs()
+	return dst != ip4 && dst != ip6
+}
+
 // getTSNetDir usually just returns filepath.Join(confDir, "tsnet-"+prog)
 // with no error.
 //
@@ -826,16 +1151,33 @@ func (s *Server) APIClient() (*tailscale.Client, error) {
 	return c, nil
 }
 
+// RegisterPeerAPIHandler registers h to serve peerapi requests from other
+// nodes to path, which must be below ipnlocal.PeerAPIHandlerPrefix. See
+// ipnlocal.LocalBackend.RegisterPeerAPIHandler for how capability limits
+// which peers may call h.
+// It will start the server if it has not been started yet.
+func (s *Server) RegisterPeerAPIHandler(path string, capability tailcfg.PeerCapability, h ipnlocal.PeerAPIHandlerFunc) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.lb.RegisterPeerAPIHandler(path, capability, h)
+}
+
 // Listen announces only on the Tailscale network.
 // It will start the server if it has not been started yet.
 func (s *Server) Listen(network, addr string) (net.Listener, error) {
-	return s.listen(network, addr, listenOnTailnet)
+	return s.listen(network, addr, listenOnTailnet, ListenOpts{})
 }
 
 // ListenTLS announces only on the Tailscale network.
-// It returns a TLS listener wrapping the tsnet listener.
+// It returns a TLS listener wrapping the tsnet listener, serving the node's
+// HTTPS certificate, which is obtained and renewed automatically.
 // It will start the server if it has not been started yet.
 func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
+	return s.listenTLS(network, addr, ListenOpts{})
+}
+
+func (s *Server) listenTLS(network, addr string, opts ListenOpts) (net.Listener, error) {
 	if network != "tcp" {
 		return nil, fmt.Errorf("ListenTLS(%q, %q): only tcp is supported", network, addr)
 	}
@@ -848,28 +1190,138 @@ func (s *Server) ListenTLS(network, addr string) (net.Listener, error) {
 		return nil, errors.New("tsnet: you must enable HTTPS in the admin panel to proceed. See https://tailscale.com/s/https")
 	}
 
-	ln, err := s.listen(network, addr, listenOnTailnet)
+	ln, err := s.listen(network, addr, listenOnTailnet, opts)
 	if err != nil {
 		return nil, err
 	}
-	return tls.NewListener(ln, &tls.Config{
+	s.prefetchCert(st.CertDomains[0])
+	return tls.NewListener(ln, s.tlsConfig()), nil
+}
+
+// tlsConfig returns the TLS config of the listeners returned by ListenTLS
+// and ListenFunnel. As with http.Server.ListenAndServeTLS, they offer
+// HTTP/2 with ALPN, so an http.Server serving them speaks HTTP/2 to clients
+// that support it.
+func (s *Server) tlsConfig() *tls.Config {
+	return &tls.Config{
 		GetCertificate: s.getCert,
-	}), nil
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
 }
 
-// getCert is the GetCertificate function used by ListenTLS.
+// certRefreshInterval is how often a cached certificate is fetched again
+// from the LocalBackend, which renews it when it nears expiry.
+const certRefreshInterval = time.Hour
+
+// cachedCert is a certificate cached by getCert.
+type cachedCert struct {
+	cert     *tls.Certificate
+	notAfter time.Time
+	fetched  time.Time
+}
+
+// prefetchCert starts fetching the certificate for domain, if it's not
+// cached, so that the first TLS handshake needn't wait for it to be issued.
+func (s *Server) prefetchCert(domain string) {
+	go func() {
+		if _, err := s.getCert(&tls.ClientHelloInfo{ServerName: domain}); err != nil {
+			s.logf("tsnet: fetching TLS certificate for %s: %v", domain, err)
+		}
+	}()
+}
+
+// getCert is the GetCertificate function used by ListenTLS and
+// ListenFunnel.
 //
-// It calls GetCertificate on the localClient, passing in the ClientHelloInfo.
-// For testing, if s.getCertForTesting is set, it will call that instead.
+// It serves the certificate for the ClientHelloInfo's server name, or for
+// the node's first domain if the client sent none, caching them in memory
+// and refreshing them every certRefreshInterval. If a refresh fails, the
+// cached certificate is served until it expires.
 func (s *Server) getCert(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := s.certDomain(hi.ServerName)
+	if name == "" {
+		return nil, errors.New("tsnet: no TLS certificate domain for connection without SNI")
+	}
+	now := time.Now()
+	s.certMu.Lock()
+	cc := s.certs[name]
+	s.certMu.Unlock()
+	if cc != nil && now.Sub(cc.fetched) < certRefreshInterval && now.Before(cc.notAfter) {
+		return cc.cert, nil
+	}
+	newCC, err, _ := s.certFetches.Do(name, func() (*cachedCert, error) {
+		return s.fetchCert(name)
+	})
+	if err != nil {
+		if cc != nil && now.Before(cc.notAfter) {
+			s.logf("tsnet: refreshing TLS certificate for %s: %v; using cached one", name, err)
+			return cc.cert, nil
+		}
+		return nil, err
+	}
+	s.certMu.Lock()
+	mak.Set(&s.certs, name, newCC)
+	s.certMu.Unlock()
+	return newCC.cert, nil
+}
+
+// certDomain returns the domain of the certificate to serve to TLS clients
+// requesting serverName, expanding a bare MagicDNS name to the node's full
+// one, or the node's first certificate domain if serverName is empty.
+func (s *Server) certDomain(serverName string) string {
+	domains := s.CertDomains()
+	if serverName == "" {
+		if len(domains) == 0 {
+			return ""
+		}
+		return domains[0]
+	}
+	if !strings.Contains(serverName, ".") {
+		for _, d := range domains {
+			if base, _, _ := strings.Cut(d, "."); base == serverName {
+				return d
+			}
+		}
+	}
+	return serverName
+}
+
+// fetchCert fetches the certificate for domain from the LocalBackend,
+// which obtains it from Let's Encrypt via the control server if needed.
+// For testing, if s.getCertForTesting is set, it calls that instead.
+func (s *Server) fetchCert(domain string) (*cachedCert, error) {
+	var cert *tls.Certificate
 	if s.getCertForTesting != nil {
-		return s.getCertForTesting(hi)
+		var err error
+		cert, err = s.getCertForTesting(&tls.ClientHelloInfo{ServerName: domain})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		lc, err := s.LocalClient()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(s.shutdownCtx, time.Minute)
+		defer cancel()
+		certPEM, keyPEM, err := lc.CertPair(ctx, domain)
+		if err != nil {
+			return nil, err
+		}
+		pair, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		cert = &pair
 	}
-	lc, err := s.LocalClient()
+	if len(cert.Certificate) == 0 {
+		return nil, fmt.Errorf("tsnet: empty TLS certificate for %s", domain)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
 	if err != nil {
 		return nil, err
 	}
-	return lc.GetCertificate(hi)
+	return &cachedCert{cert: cert, notAfter: leaf.NotAfter, fetched: time.Now()}, nil
 }
 
 // FunnelOption is an option passed to ListenFunnel to configure the listener.
@@ -956,13 +1408,12 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 			lnOn = listenOnFunnel
 		}
 	}
-	ln, err := s.listen(network, addr, lnOn)
+	ln, err := s.listen(network, addr, lnOn, ListenOpts{})
 	if err != nil {
 		return nil, err
 	}
-	return tls.NewListener(ln, &tls.Config{
-		GetCertificate: s.getCert,
-	}), nil
+	s.prefetchCert(domain)
+	return tls.NewListener(ln, s.tlsConfig()), nil
 }
 
 type listenOn string
@@ -973,7 +1424,7 @@ func (s *Server) ListenFunnel(network, addr string, opts ...FunnelOption) (net.L
 	listenOnBoth    = listenOn("listen-on-both")
 )
 
-func (s *Server) listen(network, addr string, lnOn listenOn) (net.Listener, error) {
+func (s *Server) listen(network, addr string, lnOn listenOn, opts ListenOpts) (net.Listener, error) {
 	switch network {
 	case "", "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
 	default:
@@ -1023,6 +1474,7 @@ func (s *Server) listen(network, addr string, lnOn listenOn) (net.Listener, erro
 		s:    s,
 		keys: keys,
 		addr: addr,
+		opts: opts,
 
 		conn: make(chan net.Conn),
 	}
@@ -1054,8 +1506,12 @@ type listener struct {
 	s      *Server
 	keys   []listenKey
 	addr   string
+	opts   ListenOpts
 	conn   chan net.Conn
 	closed bool // guarded by s.mu
+
+	peersMu sync.Mutex
+	peers   map[netip.Addr]*peerLimits // with non-zero opts
 }
 
 func (ln *listener) Accept() (net.Conn, error) {
@@ -1091,6 +1547,14 @@ func (ln *listener) closeLocked() error {
 }
 
 func (ln *listener) handle(c net.Conn) {
+	if ln.opts != (ListenOpts{}) {
+		lc := ln.limitConn(c)
+		if lc == nil {
+			c.Close()
+			return
+		}
+		c = lc
+	}
 	t := time.NewTimer(time.Second)
 	defer t.Stop()
 	select {
@@ -23,11 +23,14 @@
 	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"golang.org/x/net/proxy"
 	"tailscale.com/cmd/testwrapper/flakytest"
 	"tailscale.com/ipn"
@@ -38,6 +41,8 @@
 	"tailscale.com/tstest/integration"
 	"tailscale.com/tstest/integration/testcontrol"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/must"
 )
 
@@ -281,6 +286,61 @@ func TestConn(t *testing.T) {
 	}
 }
 
+func TestListenTLS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	const domain = "s1.tail-scale.ts.net"
+	for _, name := range []string{"", "s1", domain} {
+		if got := s1.certDomain(name); got != domain {
+			t.Errorf("certDomain(%q) = %q; want %q", name, got, domain)
+		}
+	}
+
+	var fetches atomic.Int32
+	s1.getCertForTesting = func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		fetches.Add(1)
+		return testCertRoot.getCert(hi)
+	}
+	ln := must.Get(s1.ListenTLS("tcp", ":443"))
+	defer ln.Close()
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return s2.Dial(ctx, network, netip.AddrPortFrom(s1ip, 443).String())
+		},
+		TLSClientConfig:   &tls.Config{RootCAs: testCertRoot.Pool()},
+		ForceAttemptHTTP2: true,
+	}
+	defer tr.CloseIdleConnections()
+	c := &http.Client{Transport: tr}
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("https://" + domain + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "HTTP/2.0" {
+			t.Errorf("served over %s; want HTTP/2.0", body)
+		}
+		tr.CloseIdleConnections()
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("certificate fetched %d times; want 1", n)
+	}
+}
+
 func TestLoopbackLocalAPI(t *testing.T) {
 	flakytest.Mark(t, "https://github.com/tailscale/tailscale/issues/8557")
 	tstest.ResourceCheck(t)
@@ -447,6 +507,173 @@ func TestTailscaleIPs(t *testing.T) {
 	}
 }
 
+func TestWatchNodes(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	evs, err := s1.WatchNodes(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() NodeEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-evs:
+			if !ok {
+				t.Fatal("channel closed")
+			}
+			return ev
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		}
+		panic("unreachable")
+	}
+
+	ev := next()
+	if ev.Type != SelfChanged || ev.Node.Addresses().Len() == 0 || ev.Node.Addresses().At(0).Addr() != s1ip {
+		t.Fatalf("first event = %v %v; want SelfChanged with %v", ev.Type, ev.Node.Addresses(), s1ip)
+	}
+
+	_, s2ip := startServer(t, ctx, controlURL, "s2")
+	for {
+		ev := next()
+		if ev.Type == PeerAdded && ev.Node.Addresses().Len() > 0 && ev.Node.Addresses().At(0).Addr() == s2ip {
+			break
+		}
+		t.Logf("skipping event %v for %v", ev.Type, ev.Node.Name())
+	}
+
+	s1.Close()
+	for range evs {
+	}
+}
+
+func TestHandleDNS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, _ := startServer(t, ctx, controlURL, "s1")
+	appIP := netip.MustParseAddr("10.1.2.3")
+	err := s1.HandleDNS("myapp", func(ctx context.Context, q dnsmessage.Question) ([]dnsmessage.Resource, error) {
+		if !strings.HasPrefix(q.Name.String(), "db.") {
+			return nil, ErrDNSNameNotFound
+		}
+		return []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: appIP.As4()},
+		}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(name string) dnsmessage.Message {
+		t.Helper()
+		q := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: 7, RecursionDesired: true},
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName(name),
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			}},
+		}
+		qb, err := q.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s1.resolver.Query(ctx, qb, netip.AddrPort{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m dnsmessage.Message
+		if err := m.Unpack(res); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	self := strings.TrimSuffix(s1.lb.NetMap().SelfNode.Name(), ".") + "."
+	m := query("db.myapp." + self)
+	if m.ID != 7 || m.RCode != dnsmessage.RCodeSuccess || len(m.Answers) != 1 {
+		t.Fatalf("got %+v; want one answer", m)
+	}
+	if a, ok := m.Answers[0].Body.(*dnsmessage.AResource); !ok || netip.AddrFrom4(a.A) != appIP {
+		t.Errorf("answer = %v; want %v", m.Answers[0].Body, appIP)
+	}
+	if m := query("web.myapp." + self); m.RCode != dnsmessage.RCodeNameError {
+		t.Errorf("RCode = %v; want NXDOMAIN", m.RCode)
+	}
+
+	if err := s1.HandleDNS("myapp", nil); err != nil {
+		t.Fatal(err)
+	}
+	if h := s1.resolver.HandlerFor(dnsname.FQDN("db.myapp." + self)); h != nil {
+		t.Error("handler still registered after removal")
+	}
+}
+
+func TestNodeDiffer(t *testing.T) {
+	node := func(id tailcfg.NodeID, online bool) tailcfg.NodeView {
+		return (&tailcfg.Node{ID: id, Online: &online}).View()
+	}
+	self := (&tailcfg.Node{ID: 1, Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")}}).View()
+	self2 := (&tailcfg.Node{ID: 1, Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")}}).View()
+
+	var d nodeDiffer
+	steps := []struct {
+		nm   *netmap.NetworkMap
+		want []NodeEventType
+	}{
+		{&netmap.NetworkMap{SelfNode: self, Peers: []tailcfg.NodeView{node(2, true)}}, []NodeEventType{SelfChanged, PeerAdded}},
+		{&netmap.NetworkMap{SelfNode: self, Peers: []tailcfg.NodeView{node(2, true)}}, nil},
+		{&netmap.NetworkMap{SelfNode: self, Peers: []tailcfg.NodeView{node(2, false), node(3, true)}}, []NodeEventType{PeerOnlineChanged, PeerAdded}},
+		{&netmap.NetworkMap{SelfNode: self2, Peers: []tailcfg.NodeView{node(3, true)}}, []NodeEventType{SelfChanged, PeerRemoved}},
+	}
+	for i, st := range steps {
+		var got []NodeEventType
+		for _, ev := range d.update(st.nm) {
+			got = append(got, ev.Type)
+		}
+		if !reflect.DeepEqual(got, st.want) {
+			t.Errorf("step %d: got %v; want %v", i, got, st.want)
+		}
+	}
+}
+
+func TestAdvertisedRoutes(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	tests := []struct {
+		routes []netip.Prefix
+		exit   bool
+		want   []netip.Prefix
+	}{
+		{nil, false, nil},
+		{[]netip.Prefix{pfx("10.0.0.0/8")}, false, []netip.Prefix{pfx("10.0.0.0/8")}},
+		{nil, true, []netip.Prefix{pfx("0.0.0.0/0"), pfx("::/0")}},
+		{[]netip.Prefix{pfx("::/0"), pfx("10.0.0.0/8")}, true, []netip.Prefix{pfx("::/0"), pfx("10.0.0.0/8"), pfx("0.0.0.0/0")}},
+	}
+	for _, tt := range tests {
+		s := &Server{AdvertiseRoutes: tt.routes, ExitNode: tt.exit}
+		if got := s.advertisedRoutes(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("routes %v, exit %v: got %v; want %v", tt.routes, tt.exit, got, tt.want)
+		}
+		if got, want := s.routing(), tt.want != nil; got != want {
+			t.Errorf("routes %v, exit %v: routing = %v; want %v", tt.routes, tt.exit, got, want)
+		}
+	}
+
+	s := &Server{
+		Dir:             t.TempDir(),
+		AdvertiseRoutes: []netip.Prefix{pfx("10.1.2.3/8")},
+	}
+	if err := s.Start(); err == nil || !strings.Contains(err.Error(), "invalid AdvertiseRoutes") {
+		t.Errorf("Start with unmasked route: err = %v; want invalid AdvertiseRoutes", err)
+	}
+}
+
 // TestListenerCleanup is a regression test to verify that s.Close doesn't
 // deadlock if a listener is still open.
 func TestListenerCleanup(t *testing.T) {
@@ -578,3 +805,78 @@ type bufferedConn struct {
 func (c *bufferedConn) Read(b []byte) (int, error) {
 	return c.reader.Read(b)
 }
+
+// remoteAddrConn is a net.Conn with a fixed remote address.
+type remoteAddrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c remoteAddrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestListenOptsMaxConns(t *testing.T) {
+	ln := &listener{
+		opts: ListenOpts{MaxConns: 2},
+		conn: make(chan net.Conn, 10),
+	}
+	dial := func(peer string) (client net.Conn) {
+		server, client := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		ln.handle(remoteAddrConn{server, net.TCPAddrFromAddrPort(netip.MustParseAddrPort(peer))})
+		return client
+	}
+	accept := func() (conns []net.Conn) {
+		for {
+			select {
+			case c := <-ln.conn:
+				t.Cleanup(func() { c.Close() })
+				conns = append(conns, c)
+			default:
+				return conns
+			}
+		}
+	}
+
+	dial("100.64.0.1:1001")
+	dial("100.64.0.1:1002")
+	rejected := dial("100.64.0.1:1003")
+	dial("100.64.0.2:1001")
+	conns := accept()
+	if len(conns) != 3 {
+		t.Fatalf("accepted %d conns; want 3", len(conns))
+	}
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("read from rejected conn: %v; want EOF", err)
+	}
+
+	// Closing one of the first peer's conns makes room for another.
+	conns[0].Close()
+	dial("100.64.0.1:1004")
+	if got := len(accept()); got != 1 {
+		t.Errorf("after close, accepted %d conns; want 1", got)
+	}
+}
+
+func TestListenOptsPerPeerRate(t *testing.T) {
+	const rate = 10_000
+	ln := &listener{
+		opts: ListenOpts{PerPeerRate: rate},
+		conn: make(chan net.Conn, 1),
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	go io.Copy(io.Discard, client)
+	ln.handle(remoteAddrConn{server, net.TCPAddrFromAddrPort(netip.MustParseAddrPort("100.64.0.1:1001"))})
+	c := <-ln.conn
+	defer c.Close()
+
+	// The first rateBurst worth of bytes goes out at once; the rest at
+	// the rate.
+	start := time.Now()
+	if _, err := c.Write(make([]byte, 3*rate/10)); err != nil {
+		t.Fatal(err)
+	}
+	if d, want := time.Since(start), 200*time.Millisecond; d < want {
+		t.Errorf("wrote %d bytes in %v; want at least %v", 3*rate/10, d, want)
+	}
+}
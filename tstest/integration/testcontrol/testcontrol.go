@@ -543,6 +543,12 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		v6Prefix,
 	}
 
+	name := req.Hostinfo.Hostname
+	if s.MagicDNSDomain != "" {
+		// Like the real control server, name nodes with their
+		// MagicDNS FQDN, so that MagicDNS resolves them.
+		name += "." + s.MagicDNSDomain + "."
+	}
 	s.nodes[nk] = &tailcfg.Node{
 		ID:                tailcfg.NodeID(user.ID),
 		StableID:          tailcfg.StableNodeID(fmt.Sprintf("TESTCTRL%08x", int(user.ID))),
@@ -553,7 +559,7 @@ func (s *Server) serveRegister(w http.ResponseWriter, r *http.Request, mkey key.
 		Addresses:         allowedIPs,
 		AllowedIPs:        allowedIPs,
 		Hostinfo:          req.Hostinfo.View(),
-		Name:              req.Hostinfo.Hostname,
+		Name:              name,
 		Capabilities: []string{
 			tailcfg.CapabilityHTTPS,
 			tailcfg.NodeAttrFunnel,
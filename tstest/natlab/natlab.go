@@ -97,11 +97,18 @@ func NewInternet() *Network {
 	}
 }
 
+// A Network is a virtual network that machines attach to.
 type Network struct {
 	Name    string
 	Prefix4 netip.Prefix
 	Prefix6 netip.Prefix
 
+	// Latency is how long packets take to cross the network.
+	Latency time.Duration
+	// Loss is the fraction of packets the network drops, from 0
+	// (none) to 1 (all).
+	Loss float64
+
 	mu        sync.Mutex
 	machine   map[netip.Addr]*Interface
 	defaultGW *Interface // optional
@@ -200,9 +207,18 @@ func (n *Network) write(p *Packet) (num int, err error) {
 		iface = n.defaultGW
 	}
 
+	if n.Loss > 0 && rand.Float64() < n.Loss {
+		p.Trace("lost")
+		return len(p.Payload), nil
+	}
+
 	// Pretend it went across the network. Make a copy so nobody
 	// can later mess with caller's memory.
 	p.Trace("-> mach=%s if=%s", iface.machine.Name, iface.name)
+	if n.Latency > 0 {
+		time.AfterFunc(n.Latency, func() { iface.machine.deliverIncomingPacket(p, iface) })
+		return len(p.Payload), nil
+	}
 	go iface.machine.deliverIncomingPacket(p, iface)
 	return len(p.Payload), nil
 }
@@ -81,6 +81,50 @@ func TestSendPacket(t *testing.T) {
 	}
 }
 
+func TestLatencyAndLoss(t *testing.T) {
+	internet := NewInternet()
+
+	foo := &Machine{Name: "foo"}
+	bar := &Machine{Name: "bar"}
+	ifFoo := foo.Attach("eth0", internet)
+	ifBar := bar.Attach("eth0", internet)
+
+	ctx := context.Background()
+	fooPC, err := foo.ListenPacket(ctx, "udp4", netip.AddrPortFrom(ifFoo.V4(), 123).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	barAddr := netip.AddrPortFrom(ifBar.V4(), 456)
+	barPC, err := bar.ListenPacket(ctx, "udp4", barAddr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	internet.Loss = 1
+	if _, err := fooPC.WriteTo([]byte("lost"), net.UDPAddrFromAddrPort(barAddr)); err != nil {
+		t.Fatal(err)
+	}
+	internet.Loss = 0
+	const latency = 50 * time.Millisecond
+	internet.Latency = latency
+	start := time.Now()
+	if _, err := fooPC.WriteTo([]byte("delayed"), net.UDPAddrFromAddrPort(barAddr)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	n, _, err := barPC.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "delayed" {
+		t.Errorf("read %q; want %q", got, "delayed")
+	}
+	if d := time.Since(start); d < latency {
+		t.Errorf("packet arrived after %v; want at least %v", d, latency)
+	}
+}
+
 func TestMultiNetwork(t *testing.T) {
 	lan := &Network{
 		Name:    "lan",
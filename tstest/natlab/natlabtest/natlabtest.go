@@ -0,0 +1,239 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package natlabtest runs simulated tailnets of tsnet nodes in tests,
+// without real network access or root.
+//
+// A Lab has a fake coordination server, a DERP server, and a simulated
+// internet (see package natlab) with a STUN server on it. Each node of
+// the lab is a tsnet.Server whose WireGuard and peer-to-peer traffic
+// goes through the simulated internet, optionally from behind a NAT
+// and firewall and across a slow or lossy network. Control and DERP
+// traffic goes over the host's loopback interface.
+package natlabtest
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tailscale.com/derp"
+	"tailscale.com/derp/derphttp"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/net/netns"
+	"tailscale.com/net/stun/stuntest"
+	"tailscale.com/tailcfg"
+	"tailscale.com/tsnet"
+	"tailscale.com/tstest/integration/testcontrol"
+	"tailscale.com/tstest/natlab"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+// Lab is a simulated tailnet. Create one with New and add nodes to it
+// with AddNode.
+type Lab struct {
+	// Internet is the simulated internet that nodes with public IPs
+	// and NAT gateways attach to. Its Latency and Loss may be set to
+	// slow down or drop traffic between all nodes.
+	Internet *natlab.Network
+
+	// Control is the lab's coordination server.
+	Control *testcontrol.Server
+
+	// Logf, if non-nil, is used for the logs of nodes added afterwards.
+	// By default, nodes don't log.
+	Logf logger.Logf
+
+	t       testing.TB
+	derpMap *tailcfg.DERPMap
+
+	mu   sync.Mutex
+	lans int // number of NATed LANs so far, for their prefixes
+}
+
+// New starts a lab's coordination, DERP and STUN servers. They're
+// stopped, and all the lab's nodes closed, when t's test completes.
+func New(t testing.TB) *Lab {
+	t.Helper()
+
+	// Control and DERP traffic goes over loopback, where the socket
+	// options netns sets would need privileges for nothing.
+	netns.SetEnabled(false)
+	t.Cleanup(func() { netns.SetEnabled(true) })
+
+	l := &Lab{
+		Internet: natlab.NewInternet(),
+		t:        t,
+	}
+
+	stunMachine := &natlab.Machine{Name: "stun"}
+	stunIP := stunMachine.Attach("eth0", l.Internet).V4()
+	stunAddr, stunCleanup := stuntest.ServeWithPacketListener(t, stunMachine)
+	t.Cleanup(stunCleanup)
+
+	d := derp.NewServer(key.NewNode(), logger.Discard)
+	derpSrv := httptest.NewUnstartedServer(derphttp.Handler(d))
+	derpSrv.Config.ErrorLog = logger.StdLogger(logger.Discard)
+	derpSrv.Config.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	derpSrv.StartTLS()
+	t.Cleanup(func() {
+		derpSrv.CloseClientConnections()
+		derpSrv.Close()
+		d.Close()
+	})
+
+	l.derpMap = &tailcfg.DERPMap{
+		Regions: map[int]*tailcfg.DERPRegion{
+			1: {
+				RegionID:   1,
+				RegionCode: "lab",
+				Nodes: []*tailcfg.DERPNode{
+					{
+						Name:             "1a",
+						RegionID:         1,
+						HostName:         "127.0.0.1",
+						IPv4:             "127.0.0.1",
+						IPv6:             "none",
+						STUNPort:         stunAddr.Port,
+						DERPPort:         derpSrv.Listener.Addr().(*net.TCPAddr).Port,
+						InsecureForTests: true,
+						STUNTestIP:       stunIP.String(),
+					},
+				},
+			},
+		},
+	}
+
+	l.Control = &testcontrol.Server{
+		DERPMap: l.derpMap,
+		DNSConfig: &tailcfg.DNSConfig{
+			Proxied: true,
+		},
+		MagicDNSDomain: "lab.ts.net",
+	}
+	l.Control.HTTPTestServer = httptest.NewUnstartedServer(l.Control)
+	l.Control.HTTPTestServer.Start()
+	t.Cleanup(l.Control.HTTPTestServer.Close)
+	return l
+}
+
+// DERPMap returns the DERP map of the lab, whose only region has the
+// lab's DERP and STUN servers.
+func (l *Lab) DERPMap() *tailcfg.DERPMap {
+	return l.derpMap
+}
+
+// NodeConfig is the network environment of a node in a Lab.
+type NodeConfig struct {
+	// NAT, if non-nil, places the node on its own LAN behind a NAT
+	// gateway with the given mapping behavior. If nil, the node has a
+	// public IP on the lab's internet.
+	NAT *natlab.NATType
+
+	// Firewall, if non-nil, is the filtering behavior of a stateful
+	// firewall in front of the node, on its NAT gateway if it has one.
+	// If nil, the node accepts all inbound traffic (that its NAT
+	// gateway, if any, has a mapping for).
+	Firewall *natlab.FirewallType
+
+	// Latency and Loss are the latency and the fraction of lost
+	// packets of the node's LAN. They require NAT; set the lab's
+	// Internet fields for nodes with public IPs.
+	Latency time.Duration
+	Loss    float64
+}
+
+// Node is a node of a Lab.
+type Node struct {
+	// Server is the node's tsnet server. It's not started; use its Up
+	// or Start method, or any that starts it implicitly.
+	Server *tsnet.Server
+
+	// Machine is the node's simulated machine.
+	Machine *natlab.Machine
+
+	// IP is the node's IPv4 address on its LAN, or on the lab's
+	// internet if it's not behind a NAT.
+	IP netip.Addr
+
+	// LAN is the node's LAN, or nil if it's not behind a NAT.
+	LAN *natlab.Network
+}
+
+// AddNode adds a node named name to the lab, in the network
+// environment conf describes. The node's tsnet.Server is closed when
+// the lab's test completes.
+func (l *Lab) AddNode(name string, conf NodeConfig) *Node {
+	l.t.Helper()
+	if conf.NAT == nil && (conf.Latency != 0 || conf.Loss != 0) {
+		l.t.Fatalf("natlabtest: node %q has Latency or Loss without NAT", name)
+	}
+
+	n := &Node{Machine: &natlab.Machine{Name: name}}
+	if conf.NAT == nil {
+		if conf.Firewall != nil {
+			n.Machine.PacketHandler = &natlab.Firewall{Type: *conf.Firewall}
+		}
+		n.IP = n.Machine.Attach("eth0", l.Internet).V4()
+	} else {
+		l.mu.Lock()
+		l.lans++
+		lanNum := l.lans
+		l.mu.Unlock()
+		if lanNum > 255 {
+			l.t.Fatalf("natlabtest: too many NATed nodes")
+		}
+
+		n.LAN = &natlab.Network{
+			Name:    name + "-lan",
+			Prefix4: netip.MustParsePrefix(fmt.Sprintf("192.168.%d.0/24", lanNum-1)),
+			Latency: conf.Latency,
+			Loss:    conf.Loss,
+		}
+		gw := &natlab.Machine{Name: name + "-nat"}
+		wan := gw.Attach("wan", l.Internet)
+		lan := gw.Attach("lan", n.LAN)
+		n.LAN.SetDefaultGateway(lan)
+		nat := &natlab.SNAT44{
+			Machine:           gw,
+			ExternalInterface: wan,
+			Type:              *conf.NAT,
+		}
+		if conf.Firewall != nil {
+			nat.Firewall = &natlab.Firewall{
+				Type:             *conf.Firewall,
+				TrustedInterface: lan,
+			}
+		}
+		gw.PacketHandler = nat
+		n.IP = n.Machine.Attach("eth0", n.LAN).V4()
+	}
+
+	dir := filepath.Join(l.t.TempDir(), name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		l.t.Fatal(err)
+	}
+	n.Server = &tsnet.Server{
+		Dir:            dir,
+		Store:          new(mem.Store),
+		Hostname:       name,
+		Ephemeral:      true,
+		ControlURL:     l.Control.BaseURL(),
+		PacketListener: n.Machine,
+		Logf:           logger.Discard,
+	}
+	if l.Logf != nil {
+		n.Server.Logf = logger.WithPrefix(l.Logf, name+": ")
+	}
+	l.t.Cleanup(func() { n.Server.Close() })
+	return n
+}
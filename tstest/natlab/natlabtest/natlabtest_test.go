@@ -0,0 +1,92 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlabtest
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"tailscale.com/tstest/natlab"
+	"tailscale.com/types/ptr"
+)
+
+var verboseNodes = flag.Bool("verbose-nodes", false, "if set, print tsnet.Server logs")
+
+func TestLab(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	lab := New(t)
+	if *verboseNodes {
+		lab.Logf = t.Logf
+	}
+	nodes := map[string]*Node{
+		"public": lab.AddNode("public", NodeConfig{}),
+		"easy": lab.AddNode("easy", NodeConfig{
+			NAT:      ptr.To(natlab.EndpointIndependentNAT),
+			Firewall: ptr.To(natlab.AddressAndPortDependentFirewall),
+		}),
+		"hard": lab.AddNode("hard", NodeConfig{
+			NAT:      ptr.To(natlab.AddressAndPortDependentNAT),
+			Firewall: ptr.To(natlab.AddressAndPortDependentFirewall),
+			Latency:  10 * time.Millisecond,
+			Loss:     0.05,
+		}),
+	}
+	if nodes["easy"].LAN == nil || nodes["public"].LAN != nil {
+		t.Fatalf("LANs = %v, %v", nodes["easy"].LAN, nodes["public"].LAN)
+	}
+
+	for name, n := range nodes {
+		if _, err := n.Server.Up(ctx); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	ln, err := nodes["public"].Server.Listen("tcp", ":8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+
+	st, err := nodes["public"].Server.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := net.JoinHostPort(st.TailscaleIPs[0].String(), "8080")
+	for _, name := range []string{"easy", "hard"} {
+		c, err := nodes[name].Server.Dial(ctx, "tcp", target)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		const msg = "hello from the lab"
+		if _, err := io.WriteString(c, msg); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(c, buf); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(buf) != msg {
+			t.Errorf("%s: got %q; want %q", name, buf, msg)
+		}
+		c.Close()
+	}
+}
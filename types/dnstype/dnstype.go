@@ -16,21 +16,21 @@ type Resolver struct {
 	//  - A plain IP address for a "classic" UDP+TCP DNS resolver.
 	//    This is the common format as sent by the control plane.
 	//  - An IP:port, for tests.
-	//  - "https://resolver.com/path" for DNS over HTTPS; currently
-	//    as of 2022-09-08 only used for certain well-known resolvers
-	//    (see the publicdns package) for which the IP addresses to dial DoH are
-	//    known ahead of time, so bootstrap DNS resolution is not required.
-	//  - [TODO] "tls://resolver.com" for DNS over TCP+TLS
+	//  - "https://resolver.com/path" for DNS over HTTPS. The IP
+	//    addresses to dial are known ahead of time for certain well-known
+	//    resolvers (see the publicdns package); other resolvers need
+	//    BootstrapResolution unless the URL's host is an IP address.
+	//  - "tls://resolver.com" or "tls://resolver.com:port" for DNS over
+	//    TCP+TLS, which has the same bootstrap requirements as DoH.
 	Addr string `json:",omitempty"`
 
-	// BootstrapResolution is an optional suggested resolution for the
-	// DoT/DoH resolver, if the resolver URL does not reference an IP
-	// address directly.
-	// BootstrapResolution may be empty, in which case clients should
-	// look up the DoT/DoH server using their local "classic" DNS
-	// resolver.
+	// BootstrapResolution is the resolution of the DoT/DoH resolver's
+	// hostname, if the resolver address does not reference an IP
+	// address directly and isn't a well-known resolver.
 	//
-	// As of 2022-09-08, BootstrapResolution is not yet used.
+	// Clients don't look up DoT/DoH servers using their local "classic"
+	// DNS resolver, so a resolver whose host can't otherwise be resolved
+	// is unusable without it.
 	BootstrapResolution []netip.Addr `json:",omitempty"`
 }
 
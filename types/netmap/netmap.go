@@ -50,6 +50,10 @@ type NetworkMap struct {
 	PacketFilterRules views.Slice[tailcfg.FilterRule]
 	SSHPolicy         *tailcfg.SSHPolicy // or nil, if not enabled/allowed
 
+	// SSHHostCerts are the SSH host certificates and trusted host CAs
+	// from control, or nil if none were issued.
+	SSHHostCerts *tailcfg.SSHHostCerts
+
 	// CollectServices reports whether this node's Tailnet has
 	// requested that info about services be included in HostInfo.
 	// If set, Hostinfo.ShieldsUp blocks services collection; that
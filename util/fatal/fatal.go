@@ -0,0 +1,135 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package fatal reports why a long-running program couldn't start or keep
+// running, in a form that supervisors and users can act on.
+//
+// Startup code returns errors wrapped with a Cause instead of calling
+// log.Fatal, and the program's main passes the first one to Exit.
+package fatal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"tailscale.com/atomicfile"
+	"tailscale.com/util/systemd"
+	"tailscale.com/version"
+)
+
+// Cause is a machine-readable category of fatal error.
+type Cause string
+
+const (
+	CauseUsage      Cause = "usage"      // invalid flags or arguments
+	CausePermission Cause = "permission" // missing privileges, such as root
+	CauseConfig     Cause = "config"     // invalid or unreadable configuration or state
+	CauseListen     Cause = "listen"     // a socket or port couldn't be listened on or stopped serving
+	CauseNetwork    Cause = "network"    // the network stack, such as the TUN device or netstack, failed
+	CauseInternal   Cause = "internal"   // anything else
+)
+
+// ExitCode returns the process exit code for c: 2 for CauseUsage, as is
+// conventional, and 1 otherwise.
+func (c Cause) ExitCode() int {
+	if c == CauseUsage {
+		return 2
+	}
+	return 1
+}
+
+// Error is an error with a Cause.
+type Error struct {
+	Cause Cause
+	Err   error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// New returns err with the given cause, or nil if err is nil.
+func New(cause Cause, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Cause: cause, Err: err}
+}
+
+// Errorf returns an error with the given cause, formatted as by fmt.Errorf.
+func Errorf(cause Cause, format string, args ...any) error {
+	return &Error{Cause: cause, Err: fmt.Errorf(format, args...)}
+}
+
+// CauseOf returns the Cause of the first Error in err's chain, or
+// CauseInternal if there is none.
+func CauseOf(err error) Cause {
+	var fe *Error
+	if errors.As(err, &fe) {
+		return fe.Cause
+	}
+	return CauseInternal
+}
+
+// Report describes a fatal error, as written to a status file by Exit.
+type Report struct {
+	Program string
+	PID     int
+	Time    time.Time
+	Cause   Cause
+	Error   string
+}
+
+// NewReport returns the Report for err.
+func NewReport(err error) Report {
+	return Report{
+		Program: version.CmdName(),
+		PID:     os.Getpid(),
+		Time:    time.Now().UTC(),
+		Cause:   CauseOf(err),
+		Error:   err.Error(),
+	}
+}
+
+// WriteStatusFile writes r as JSON to the file at path, replacing it.
+func (r Report) WriteStatusFile(path string) error {
+	b, err := json.MarshalIndent(r, "", "\t")
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, append(b, '\n'), 0644)
+}
+
+// ClearStatusFile removes a status file left by a previous Exit, if any,
+// so it doesn't describe a run that has since been superseded. It's a
+// no-op if path is empty.
+func ClearStatusFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// exit is os.Exit, or a fake in tests.
+var exit = os.Exit
+
+// Exit logs err, reports it to systemd (on Linux) and, if statusFile is
+// non-empty, writes its Report there, then exits the process with the
+// exit code of its Cause.
+func Exit(err error, statusFile string) {
+	r := NewReport(err)
+	log.Printf("fatal (%s): %v", r.Cause, err)
+	systemd.Status("fatal (%s): %v", r.Cause, err)
+	if statusFile != "" {
+		if werr := r.WriteStatusFile(statusFile); werr != nil {
+			log.Printf("writing fatal status file: %v", werr)
+		}
+	}
+	exit(r.Cause.ExitCode())
+}
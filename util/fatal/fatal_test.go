@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package fatal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tailscale.com/tstest"
+)
+
+func TestCauseOf(t *testing.T) {
+	base := errors.New("boom")
+	tests := []struct {
+		err  error
+		want Cause
+	}{
+		{base, CauseInternal},
+		{New(CauseListen, base), CauseListen},
+		{fmt.Errorf("starting: %w", Errorf(CauseUsage, "bad flag %q", "x")), CauseUsage},
+	}
+	for _, tt := range tests {
+		if got := CauseOf(tt.err); got != tt.want {
+			t.Errorf("CauseOf(%v) = %q; want %q", tt.err, got, tt.want)
+		}
+	}
+	if err := New(CauseConfig, nil); err != nil {
+		t.Errorf("New(nil) = %v; want nil", err)
+	}
+	if err := New(CauseConfig, base); !errors.Is(err, base) {
+		t.Errorf("New(%v) doesn't wrap it", base)
+	}
+}
+
+func TestExit(t *testing.T) {
+	var code int
+	tstest.Replace(t, &exit, func(c int) { code = c })
+	path := filepath.Join(t.TempDir(), "fatal.json")
+
+	Exit(fmt.Errorf("listening: %w", Errorf(CauseListen, "port 41641 in use")), path)
+	if code != 1 {
+		t.Errorf("exit code = %d; want 1", code)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(b, &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Cause != CauseListen || r.Error != "listening: port 41641 in use" || r.PID != os.Getpid() || r.Time.IsZero() {
+		t.Errorf("report = %+v", r)
+	}
+
+	Exit(Errorf(CauseUsage, "bad flag"), "")
+	if code != 2 {
+		t.Errorf("exit code = %d; want 2", code)
+	}
+
+	if err := ClearStatusFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("status file not removed: %v", err)
+	}
+	if err := ClearStatusFile(path); err != nil {
+		t.Errorf("ClearStatusFile of missing file: %v", err)
+	}
+}
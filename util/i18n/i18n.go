@@ -0,0 +1,235 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package i18n translates the human-readable messages of the CLI and of
+// the LocalAPI, such as health warnings, using message catalogs.
+//
+// A catalog maps message IDs to the text of the messages in one locale,
+// in which {name} is replaced by the message argument of that name. The
+// catalogs are embedded in the binary, and distributions can add or
+// override catalogs without rebuilding by putting <locale>.json files in
+// the directory in the TS_LOCALE_DIR environment variable.
+//
+// locales/en.json has every message, other than the "health.<code>"
+// translations of health warnings, which come with their English text. The
+// other catalogs may lack some messages, which are then in English.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tailscale.com/envknob"
+)
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// DefaultLocale is the locale of messages when no other with a catalog is
+// asked for.
+const DefaultLocale = "en"
+
+var (
+	catalogsOnce sync.Once
+	catalogs     map[string]map[string]string // locale => message ID => text
+)
+
+// loadCatalogs returns the message catalogs, loading them on first use.
+func loadCatalogs() map[string]map[string]string {
+	catalogsOnce.Do(func() {
+		catalogs = map[string]map[string]string{}
+		add := func(name string, data []byte) {
+			var msgs map[string]string
+			if err := json.Unmarshal(data, &msgs); err != nil {
+				return
+			}
+			locale := strings.ToLower(strings.TrimSuffix(name, path.Ext(name)))
+			if catalogs[locale] == nil {
+				catalogs[locale] = map[string]string{}
+			}
+			for id, msg := range msgs {
+				catalogs[locale][id] = msg
+			}
+		}
+		files, _ := localeFS.ReadDir("locales")
+		for _, f := range files {
+			if data, err := localeFS.ReadFile("locales/" + f.Name()); err == nil {
+				add(f.Name(), data)
+			}
+		}
+		if dir := envknob.String("TS_LOCALE_DIR"); dir != "" {
+			files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			for _, f := range files {
+				if data, err := os.ReadFile(f); err == nil {
+					add(filepath.Base(f), data)
+				}
+			}
+		}
+	})
+	return catalogs
+}
+
+// Locales returns the locales with catalogs, sorted.
+func Locales() []string {
+	cats := loadCatalogs()
+	ls := make([]string, 0, len(cats))
+	for l := range cats {
+		ls = append(ls, l)
+	}
+	sort.Strings(ls)
+	return ls
+}
+
+// Match returns the locale with a catalog that best matches the language
+// tags, most preferred first, or DefaultLocale if none does. Tags are BCP
+// 47 language tags such as "de-AT", which match "de-at" or else "de", or
+// POSIX locale names such as "de_AT.UTF-8".
+func Match(tags ...string) string {
+	cats := loadCatalogs()
+	for _, tag := range tags {
+		tag, _, _ = strings.Cut(tag, ".") // POSIX codeset
+		tag, _, _ = strings.Cut(tag, "@") // POSIX modifier
+		tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+		if _, ok := cats[tag]; ok {
+			return tag
+		}
+		base, _, _ := strings.Cut(tag, "-")
+		if _, ok := cats[base]; ok {
+			return base
+		}
+	}
+	return DefaultLocale
+}
+
+// FromEnv returns the locale with a catalog that best matches the
+// environment: TS_LOCALE, or else the POSIX LC_ALL, LC_MESSAGES and LANG
+// variables, in that order.
+func FromEnv() string {
+	if l := envknob.String("TS_LOCALE"); l != "" {
+		return Match(l)
+	}
+	for _, v := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if l := os.Getenv(v); l != "" {
+			return Match(l)
+		}
+	}
+	return DefaultLocale
+}
+
+// ParseAcceptLanguage returns the language tags of an Accept-Language
+// header value, most preferred first, skipping "*" and those with q=0.
+func ParseAcceptLanguage(v string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var ws []weighted
+	for _, part := range strings.Split(v, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if qs, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(qs, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		ws = append(ws, weighted{tag, q})
+	}
+	sort.SliceStable(ws, func(i, j int) bool { return ws[i].q > ws[j].q })
+	tags := make([]string, len(ws))
+	for i, w := range ws {
+		tags[i] = w.tag
+	}
+	return tags
+}
+
+// Printer formats the messages of a locale.
+type Printer struct {
+	locale string
+	msgs   map[string]string // of locale only
+	en     map[string]string
+}
+
+// NewPrinter returns a Printer of the messages of locale, as returned by
+// Match. Messages its catalog lacks are in English.
+func NewPrinter(locale string) *Printer {
+	cats := loadCatalogs()
+	return &Printer{
+		locale: locale,
+		msgs:   cats[locale],
+		en:     cats[DefaultLocale],
+	}
+}
+
+// Locale returns the locale of p's messages.
+func (p *Printer) Locale() string { return p.locale }
+
+// Text returns the message id, with args, which are pairs of argument
+// names and values, in place of its placeholders. It returns id itself if
+// there's no such message.
+func (p *Printer) Text(id string, args ...string) string {
+	msg, ok := p.msgs[id]
+	if !ok {
+		if msg, ok = p.en[id]; !ok {
+			return id
+		}
+	}
+	if len(args) < 2 {
+		return msg
+	}
+	return strings.NewReplacer(placeholders(args)...).Replace(msg)
+}
+
+// Translate returns the translation of message id, with args in place of
+// its placeholders, if p's locale has one. It reports false if it doesn't,
+// or if the translation has placeholders args lacks, for the caller to use
+// the English text it has.
+func (p *Printer) Translate(id string, args map[string]string) (string, bool) {
+	msg, ok := p.msgs[id]
+	if !ok || p.locale == DefaultLocale {
+		return "", false
+	}
+	for rest := msg; ; {
+		i := strings.IndexByte(rest, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(rest[i:], '}')
+		if j < 0 {
+			break
+		}
+		if _, ok := args[rest[i+1:i+j]]; !ok {
+			return "", false
+		}
+		rest = rest[i+j+1:]
+	}
+	var pairs []string
+	for k, v := range args {
+		pairs = append(pairs, k, v)
+	}
+	return strings.NewReplacer(placeholders(pairs)...).Replace(msg), true
+}
+
+// placeholders returns the strings.NewReplacer arguments replacing the
+// placeholders of the argument name and value pairs.
+func placeholders(args []string) []string {
+	ret := make([]string, 0, len(args))
+	for i := 0; i+1 < len(args); i += 2 {
+		ret = append(ret, "{"+args[i]+"}", args[i+1])
+	}
+	return ret
+}
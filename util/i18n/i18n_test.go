@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package i18n
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCatalogs(t *testing.T) {
+	cats := loadCatalogs()
+	en := cats[DefaultLocale]
+	if len(en) == 0 {
+		t.Fatal("no English catalog")
+	}
+	for locale, msgs := range cats {
+		for id := range msgs {
+			if _, ok := en[id]; !ok && !strings.HasPrefix(id, "health.") {
+				t.Errorf("%s: message %q isn't in the English catalog", locale, id)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		tags []string
+		want string
+	}{
+		{nil, "en"},
+		{[]string{"de"}, "de"},
+		{[]string{"de-AT"}, "de"},
+		{[]string{"de_DE.UTF-8"}, "de"},
+		{[]string{"de_DE@euro"}, "de"},
+		{[]string{"C"}, "en"},
+		{[]string{"xx", "de"}, "de"},
+		{[]string{"en-GB", "de"}, "en"},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.tags...); got != tt.want {
+			t.Errorf("Match(%q) = %q; want %q", tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("TS_LOCALE", "")
+	t.Setenv("LC_ALL", "")
+	t.Setenv("LC_MESSAGES", "de_CH.UTF-8")
+	t.Setenv("LANG", "en_US.UTF-8")
+	if got := FromEnv(); got != "de" {
+		t.Errorf("FromEnv with LC_MESSAGES=de_CH = %q; want de", got)
+	}
+	t.Setenv("LC_ALL", "C")
+	if got := FromEnv(); got != "en" {
+		t.Errorf("FromEnv with LC_ALL=C = %q; want en", got)
+	}
+	t.Setenv("TS_LOCALE", "de")
+	if got := FromEnv(); got != "de" {
+		t.Errorf("FromEnv with TS_LOCALE=de = %q; want de", got)
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	got := ParseAcceptLanguage("fr;q=0.5, de-AT, *;q=0.1, en;q=0")
+	if want := []string{"de-AT", "fr"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	en := NewPrinter("en")
+	if got, want := en.Text("status.logInAt", "url", "https://x"), "Log in at: https://x"; got != want {
+		t.Errorf("en: got %q; want %q", got, want)
+	}
+	de := NewPrinter("de")
+	if got, want := de.Text("status.logInAt", "url", "https://x"), "Anmelden unter: https://x"; got != want {
+		t.Errorf("de: got %q; want %q", got, want)
+	}
+	if got, want := de.Text("no.such.message"), "no.such.message"; got != want {
+		t.Errorf("missing message: got %q; want %q", got, want)
+	}
+
+	if _, ok := en.Translate("health.no-derp-home", nil); ok {
+		t.Error("en translated a health warning")
+	}
+	if got, ok := de.Translate("health.derp-home-disconnected", map[string]string{"region": "7"}); !ok || !strings.Contains(got, "7") {
+		t.Errorf("de with region = %q, %v", got, ok)
+	}
+	if got, ok := de.Translate("health.derp-home-disconnected", nil); ok {
+		t.Errorf("de without region = %q; want no translation", got)
+	}
+}
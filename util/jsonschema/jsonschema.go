@@ -0,0 +1,233 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package jsonschema generates JSON Schemas describing the encoding/json
+// encoding of Go types, so that tools outside of Go can validate and
+// generate code for the JSON that Tailscale produces and consumes.
+package jsonschema
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"tailscale.com/types/opt"
+	"tailscale.com/types/views"
+)
+
+// Draft is the JSON Schema dialect of the generated schemas.
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON Schema, limited to the keywords needed to describe the
+// JSON encoding of Go types.
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Ref         string `json:"$ref,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// Type is the JSON type of the value: a string, or a []string of
+	// the types allowed.
+	Type any `json:"type,omitempty"`
+
+	Format               string             `json:"format,omitempty"`
+	ContentEncoding      string             `json:"contentEncoding,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+}
+
+// For returns the schema of the JSON encoding of values of the type of v,
+// as produced by encoding/json. If v is a pointer, such as a nil pointer
+// to the type to describe, the schema is of the type it points to.
+//
+// Named struct types are described in the schema's $defs, by their
+// package-qualified names, and referred to from the rest of the schema.
+// Fields aren't marked required: the same types are often also decoded
+// from JSON that omits fields. Types with custom JSON encodings that aren't
+// known to the package are described with the empty schema, which allows
+// any value.
+func For(v any) *Schema {
+	g := &generator{
+		defs:  map[string]*Schema{},
+		names: map[reflect.Type]string{},
+	}
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	s := g.schema(t)
+	s.Schema = Draft
+	if len(g.defs) > 0 {
+		s.Defs = g.defs
+	}
+	return s
+}
+
+// generator generates a schema, accumulating its $defs.
+type generator struct {
+	defs  map[string]*Schema      // by name
+	names map[reflect.Type]string // name of each type in defs
+}
+
+var (
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	timeType          = reflect.TypeOf(time.Time{})
+	optBoolType       = reflect.TypeOf(opt.Bool(""))
+	viewsPkgPath      = reflect.TypeOf(views.Slice[int]{}).PkgPath()
+)
+
+// implements reports whether t or *t implements iface, as encoding/json
+// uses the methods of either.
+func implements(t, iface reflect.Type) bool {
+	return t.Implements(iface) || t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(iface)
+}
+
+// nullable returns the schema s, also allowing null.
+func nullable(s *Schema) *Schema {
+	switch typ := s.Type.(type) {
+	case string:
+		s.Type = []string{typ, "null"}
+	case nil:
+		if s.Ref != "" {
+			return &Schema{AnyOf: []*Schema{s, {Type: "null"}}}
+		}
+	}
+	return s
+}
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case optBoolType:
+		return &Schema{Type: []string{"boolean", "null"}}
+	}
+	if t.PkgPath() == viewsPkgPath {
+		// Views are encoded as the slice or map they view.
+		for _, meth := range []string{"AsSlice", "AsMap"} {
+			if m, ok := t.MethodByName(meth); ok && m.Type.NumOut() == 1 {
+				return g.schema(m.Type.Out(0))
+			}
+		}
+	}
+	if t.Kind() != reflect.Pointer {
+		if implements(t, jsonMarshalerType) {
+			return &Schema{}
+		}
+		if implements(t, textMarshalerType) {
+			return &Schema{Type: "string"}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !implements(t.Elem(), jsonMarshalerType) && !implements(t.Elem(), textMarshalerType) {
+			return &Schema{Type: []string{"string", "null"}, ContentEncoding: "base64"}
+		}
+		return &Schema{Type: []string{"array", "null"}, Items: g.schema(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: []string{"object", "null"}, AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		return g.structRef(t)
+	}
+	// Interfaces, and types encoding/json can't encode.
+	return &Schema{}
+}
+
+// structRef returns the schema of struct type t: a reference to its
+// definition if it's named, or the definition itself otherwise.
+func (g *generator) structRef(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return g.structSchema(t)
+	}
+	name, ok := g.names[t]
+	if !ok {
+		name = g.defName(t)
+		g.names[t] = name
+		g.defs[name] = nil // placeholder for recursive types
+		g.defs[name] = g.structSchema(t)
+	}
+	return &Schema{Ref: "#/$defs/" + name}
+}
+
+// defName returns a name for t in $defs that's not yet in use.
+func (g *generator) defName(t reflect.Type) string {
+	base := t.String() // package name qualified, e.g. "ipnstate.Status"
+	base = strings.NewReplacer("[", "_", "]", "", "*", "", "/", "_", " ", "").Replace(base)
+	name := base
+	for i := 2; ; i++ {
+		if _, ok := g.defs[name]; !ok {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// structSchema returns the definition of struct type t.
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	depth := map[string]int{} // depth of embedding of each property's field
+	g.addFields(s, depth, t, 0)
+	return s
+}
+
+// addFields adds the properties of the fields of struct type t, embedded at
+// the given depth, to s. As in encoding/json, fields of embedded structs
+// are promoted, and shallower fields hide deeper ones.
+func (g *generator) addFields(s *Schema, depth map[string]int, t reflect.Type, d int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, depth, ft, d+1)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if prev, ok := depth[name]; ok && prev <= d {
+			continue
+		}
+		depth[name] = d
+		var fs *Schema
+		if strings.Contains(","+opts+",", ",string,") {
+			fs = &Schema{Type: "string"}
+		} else {
+			fs = g.schema(f.Type)
+		}
+		s.Properties[name] = fs
+	}
+}
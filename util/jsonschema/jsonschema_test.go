@@ -0,0 +1,61 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package jsonschema
+
+import (
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/types/opt"
+	"tailscale.com/types/views"
+)
+
+type testEmbedded struct {
+	Promoted string
+	Hidden   int
+}
+
+type testNode struct {
+	testEmbedded
+	Hidden   bool   // hides testEmbedded.Hidden
+	Name     string `json:"name,omitempty"`
+	Skipped  string `json:"-"`
+	Count    int64  `json:",string"`
+	When     time.Time
+	Addr     netip.Addr
+	Addrs    views.Slice[netip.Addr]
+	Raw      []byte
+	Ratio    float64
+	Enabled  opt.Bool
+	Children []*testNode
+	Labels   map[string]string
+	Any      any
+	private  int
+}
+
+func TestFor(t *testing.T) {
+	got, err := json.Marshal(For((*testNode)(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"$schema":"https://json-schema.org/draft/2020-12/schema","$ref":"#/$defs/jsonschema.testNode","$defs":{"jsonschema.testNode":{"type":"object","properties":{` +
+		`"Addr":{"type":"string"},` +
+		`"Addrs":{"type":["array","null"],"items":{"type":"string"}},` +
+		`"Any":{},` +
+		`"Children":{"type":["array","null"],"items":{"anyOf":[{"$ref":"#/$defs/jsonschema.testNode"},{"type":"null"}]}},` +
+		`"Count":{"type":"string"},` +
+		`"Enabled":{"type":["boolean","null"]},` +
+		`"Hidden":{"type":"boolean"},` +
+		`"Labels":{"type":["object","null"],"additionalProperties":{"type":"string"}},` +
+		`"Promoted":{"type":"string"},` +
+		`"Ratio":{"type":"number"},` +
+		`"Raw":{"type":["string","null"],"contentEncoding":"base64"},` +
+		`"When":{"type":"string","format":"date-time"},` +
+		`"name":{"type":"string"}}}}}`
+	if string(got) != want {
+		t.Errorf("schema:\n got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,400 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package linuxfw
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"tailscale.com/types/logger"
+)
+
+// Formats of the rulesets returned by ExportRunner.Ruleset.
+const (
+	// RulesetIPTables is the IPv4 rules as input for
+	// "iptables-restore --noflush".
+	RulesetIPTables = "iptables"
+
+	// RulesetIP6Tables is the IPv6 rules as input for
+	// "ip6tables-restore --noflush".
+	RulesetIP6Tables = "ip6tables"
+
+	// RulesetNfTables is the IPv4 and IPv6 rules as a script for
+	// "nft -f".
+	RulesetNfTables = "nftables"
+)
+
+// ExportRunner is a NetfilterRunner that keeps track of the rules Tailscale
+// wants installed without installing any of them, so that whatever manages
+// the host's firewall (firewalld, ufw, a custom nftables ruleset) can
+// install them instead. See Ruleset.
+//
+// It computes the same rules as the runner returned by NewIPTablesRunner:
+// chains ts-input and ts-forward in the filter table and ts-postrouting in
+// the nat table, jumped to from the conventional INPUT, FORWARD and
+// POSTROUTING chains.
+type ExportRunner struct {
+	*iptablesRunner
+	ipt4, ipt6 *exportTables
+}
+
+// NewExportRunner returns a new ExportRunner. Like NewIPTablesRunner, it
+// leaves out IPv6 rules if the system doesn't support IPv6.
+func NewExportRunner(logf logger.Logf) *ExportRunner {
+	supportsV6, supportsV6NAT := false, false
+	if err := checkIPv6(logf); err != nil {
+		logf("disabling tunneled IPv6 due to system IPv6 config: %v", err)
+	} else {
+		supportsV6 = true
+		supportsV6NAT = checkSupportsV6NAT()
+	}
+	ipt4, ipt6 := newExportTables(), newExportTables()
+	return &ExportRunner{
+		iptablesRunner: &iptablesRunner{ipt4, ipt6, supportsV6, supportsV6NAT},
+		ipt4:           ipt4,
+		ipt6:           ipt6,
+	}
+}
+
+// Ruleset returns the rules Tailscale currently wants installed, in
+// format, one of RulesetIPTables, RulesetIP6Tables and RulesetNfTables.
+//
+// Rulesets don't delete rules; to remove Tailscale's rules, flush and delete
+// its chains and the jumps to them.
+func (r *ExportRunner) Ruleset(format string) (string, error) {
+	var sb strings.Builder
+	switch format {
+	case RulesetIPTables:
+		sb.WriteString("# Tailscale rules, for iptables-restore --noflush.\n")
+		r.ipt4.writeIPTables(&sb)
+	case RulesetIP6Tables:
+		sb.WriteString("# Tailscale rules, for ip6tables-restore --noflush.\n")
+		if r.HasIPV6() {
+			r.ipt6.writeIPTables(&sb)
+		}
+	case RulesetNfTables:
+		sb.WriteString("# Tailscale rules, for nft -f.\n")
+		if err := r.ipt4.writeNfTables(&sb, "ip"); err != nil {
+			return "", err
+		}
+		if r.HasIPV6() {
+			if err := r.ipt6.writeNfTables(&sb, "ip6"); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown ruleset format %q; want %s, %s or %s", format, RulesetIPTables, RulesetIP6Tables, RulesetNfTables)
+	}
+	return sb.String(), nil
+}
+
+// exportTables is an in-memory iptablesInterface for one IP family.
+type exportTables struct {
+	mu     sync.Mutex
+	chains map[string][]string // "table/chain" => rules, as space-separated args
+}
+
+// builtinChains are the iptables chains that always exist.
+var builtinChains = []string{
+	"filter/INPUT",
+	"filter/FORWARD",
+	"filter/OUTPUT",
+	"nat/PREROUTING",
+	"nat/OUTPUT",
+	"nat/POSTROUTING",
+}
+
+func newExportTables() *exportTables {
+	t := &exportTables{chains: map[string][]string{}}
+	for _, k := range builtinChains {
+		t.chains[k] = nil
+	}
+	return t
+}
+
+// errNoChain is the error for a nonexistent chain; isErrChainNotExist
+// reports true for it, as it does for the iptables command's.
+var errNoChain = errors.New("exitcode:1")
+
+func (t *exportTables) Insert(table, chain string, pos int, args ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := table + "/" + chain
+	rules, ok := t.chains[k]
+	if !ok {
+		return errNoChain
+	}
+	if pos < 1 || pos > len(rules)+1 {
+		return fmt.Errorf("bad position %d in %s", pos, k)
+	}
+	rules = append(rules, "")
+	copy(rules[pos:], rules[pos-1:])
+	rules[pos-1] = strings.Join(args, " ")
+	t.chains[k] = rules
+	return nil
+}
+
+func (t *exportTables) Append(table, chain string, args ...string) error {
+	t.mu.Lock()
+	n := len(t.chains[table+"/"+chain])
+	t.mu.Unlock()
+	return t.Insert(table, chain, n+1, args...)
+}
+
+func (t *exportTables) Exists(table, chain string, args ...string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rules, ok := t.chains[table+"/"+chain]
+	if !ok {
+		return false, errNoChain
+	}
+	for _, rule := range rules {
+		if rule == strings.Join(args, " ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (t *exportTables) Delete(table, chain string, args ...string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := table + "/" + chain
+	rules, ok := t.chains[k]
+	if !ok {
+		return errNoChain
+	}
+	for i, rule := range rules {
+		if rule == strings.Join(args, " ") {
+			t.chains[k] = append(rules[:i:i], rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no rule %q in %s", strings.Join(args, " "), k)
+}
+
+func (t *exportTables) ClearChain(table, chain string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := table + "/" + chain
+	if _, ok := t.chains[k]; !ok {
+		return errNoChain
+	}
+	t.chains[k] = nil
+	return nil
+}
+
+func (t *exportTables) NewChain(table, chain string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := table + "/" + chain
+	if _, ok := t.chains[k]; ok {
+		return fmt.Errorf("chain %s already exists", k)
+	}
+	t.chains[k] = nil
+	return nil
+}
+
+func (t *exportTables) DeleteChain(table, chain string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := table + "/" + chain
+	rules, ok := t.chains[k]
+	if !ok {
+		return errNoChain
+	}
+	if len(rules) > 0 {
+		return fmt.Errorf("chain %s isn't empty", k)
+	}
+	delete(t.chains, k)
+	return nil
+}
+
+// exportChain is a chain of an exportTables.
+type exportChain struct {
+	table, name string
+	builtin     bool
+	rules       []string
+}
+
+// tables returns t's tables and, for each, its chains that are
+// Tailscale's or have rules. Both are sorted, with builtin chains
+// before Tailscale's.
+func (t *exportTables) tables() (tables []string, chains map[string][]exportChain) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]string, 0, len(t.chains))
+	for k := range t.chains {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // uppercase builtin chains sort first
+	chains = map[string][]exportChain{}
+	for _, k := range keys {
+		table, name, _ := strings.Cut(k, "/")
+		c := exportChain{
+			table:   table,
+			name:    name,
+			builtin: name == strings.ToUpper(name),
+			rules:   t.chains[k],
+		}
+		if c.builtin && len(c.rules) == 0 {
+			continue
+		}
+		if _, ok := chains[table]; !ok {
+			tables = append(tables, table)
+		}
+		chains[table] = append(chains[table], c)
+	}
+	return tables, chains
+}
+
+// writeIPTables writes t in iptables-restore format to sb.
+func (t *exportTables) writeIPTables(sb *strings.Builder) {
+	tables, chains := t.tables()
+	for _, table := range tables {
+		fmt.Fprintf(sb, "*%s\n", table)
+		for _, c := range chains[table] {
+			if !c.builtin {
+				fmt.Fprintf(sb, ":%s - [0:0]\n", c.name)
+			}
+		}
+		for _, c := range chains[table] {
+			for i, rule := range c.rules {
+				if c.builtin {
+					// Keep Tailscale's rules first, as they're installed.
+					fmt.Fprintf(sb, "-I %s %d %s\n", c.name, i+1, rule)
+				} else {
+					fmt.Fprintf(sb, "-A %s %s\n", c.name, rule)
+				}
+			}
+		}
+		sb.WriteString("COMMIT\n")
+	}
+}
+
+// nftBaseChains are the nftables definitions of the builtin iptables
+// chains, as created by iptables-nft.
+var nftBaseChains = map[string]string{
+	"filter/INPUT":    "type filter hook input priority filter; policy accept;",
+	"filter/FORWARD":  "type filter hook forward priority filter; policy accept;",
+	"filter/OUTPUT":   "type filter hook output priority filter; policy accept;",
+	"nat/PREROUTING":  "type nat hook prerouting priority dstnat; policy accept;",
+	"nat/OUTPUT":      "type nat hook output priority -100; policy accept;",
+	"nat/POSTROUTING": "type nat hook postrouting priority srcnat; policy accept;",
+}
+
+// writeNfTables writes t to sb as an nft script for family ("ip" or
+// "ip6"), using the same tables and chains as iptables-nft.
+func (t *exportTables) writeNfTables(sb *strings.Builder, family string) error {
+	tables, chains := t.tables()
+	for _, table := range tables {
+		fmt.Fprintf(sb, "add table %s %s\n", family, table)
+		for _, c := range chains[table] {
+			if c.builtin {
+				fmt.Fprintf(sb, "add chain %s %s %s { %s }\n", family, table, c.name, nftBaseChains[table+"/"+c.name])
+			} else {
+				fmt.Fprintf(sb, "add chain %s %s %s\n", family, table, c.name)
+				fmt.Fprintf(sb, "flush chain %s %s %s\n", family, table, c.name)
+			}
+		}
+		for _, c := range chains[table] {
+			verb := "add"
+			rules := c.rules
+			if c.builtin {
+				// Keep Tailscale's rules first, as they're installed.
+				verb = "insert"
+				rules = make([]string, len(c.rules))
+				for i, r := range c.rules {
+					rules[len(rules)-1-i] = r
+				}
+			}
+			for _, rule := range rules {
+				nr, err := nftRule(family, strings.Fields(rule))
+				if err != nil {
+					return fmt.Errorf("%s/%s rule %q: %w", table, c.name, rule, err)
+				}
+				fmt.Fprintf(sb, "%s rule %s %s %s %s\n", verb, family, table, c.name, nr)
+			}
+		}
+	}
+	return nil
+}
+
+// nftRule translates the iptables rule args, as used by iptablesRunner, to
+// an nftables rule for family.
+func nftRule(family string, args []string) (string, error) {
+	var out []string
+	neg := false
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "!" {
+			neg = true
+			continue
+		}
+		if i+1 == len(args) {
+			return "", fmt.Errorf("missing value for %s", a)
+		}
+		i++
+		v := args[i]
+		op := ""
+		if neg {
+			op = "!= "
+		}
+		neg = false
+		switch a {
+		case "-i":
+			out = append(out, fmt.Sprintf("iifname %s%q", op, v))
+		case "-o":
+			out = append(out, fmt.Sprintf("oifname %s%q", op, v))
+		case "-s":
+			out = append(out, fmt.Sprintf("%s saddr %s%s", family, op, v))
+		case "-d":
+			out = append(out, fmt.Sprintf("%s daddr %s%s", family, op, v))
+		case "-m":
+			if v != "mark" {
+				return "", fmt.Errorf("unsupported match %q", v)
+			}
+		case "--mark":
+			mark, mask, ok := strings.Cut(v, "/")
+			if !ok {
+				mask = "0xffffffff"
+			}
+			if op == "" {
+				op = "== "
+			}
+			out = append(out, fmt.Sprintf("meta mark & %s %s%s", mask, op, mark))
+		case "--set-mark":
+			mark, maskStr, ok := strings.Cut(v, "/")
+			mask := uint64(0xffffffff)
+			if ok {
+				var err error
+				if mask, err = strconv.ParseUint(maskStr, 0, 32); err != nil {
+					return "", fmt.Errorf("bad mark mask %q", maskStr)
+				}
+			}
+			out = append(out, fmt.Sprintf("meta mark set meta mark & %#08x | %s", ^uint32(mask), mark))
+		case "-j":
+			switch v {
+			case "ACCEPT", "DROP", "RETURN", "MASQUERADE":
+				out = append(out, strings.ToLower(v))
+			case "MARK":
+				// Set by --set-mark.
+			default:
+				if !strings.HasPrefix(v, "ts-") {
+					return "", fmt.Errorf("unsupported target %q", v)
+				}
+				out = append(out, "jump "+v)
+			}
+		default:
+			return "", fmt.Errorf("unsupported argument %q", a)
+		}
+	}
+	return strings.Join(out, " "), nil
+}
@@ -0,0 +1,136 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build linux
+
+package linuxfw
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestExportRunner(t *testing.T) {
+	ipt4, ipt6 := newExportTables(), newExportTables()
+	r := &ExportRunner{
+		iptablesRunner: &iptablesRunner{ipt4, ipt6, true, false},
+		ipt4:           ipt4,
+		ipt6:           ipt6,
+	}
+	steps := []func() error{
+		r.AddChains,
+		r.AddHooks,
+		func() error { return r.AddBase("tailscale0") },
+		func() error { return r.AddLoopbackRule(netip.MustParseAddr("100.64.0.1")) },
+		r.AddSNATRule,
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{RulesetIPTables, `
+# Tailscale rules, for iptables-restore --noflush.
+*filter
+:ts-forward - [0:0]
+:ts-input - [0:0]
+-I FORWARD 1 -j ts-forward
+-I INPUT 1 -j ts-input
+-A ts-forward -i tailscale0 -j MARK --set-mark 0x40000/0xff0000
+-A ts-forward -m mark --mark 0x40000/0xff0000 -j ACCEPT
+-A ts-forward -o tailscale0 -s 100.64.0.0/10 -j DROP
+-A ts-forward -o tailscale0 -j ACCEPT
+-A ts-input -i lo -s 100.64.0.1 -j ACCEPT
+-A ts-input ! -i tailscale0 -s 100.115.92.0/23 -j RETURN
+-A ts-input ! -i tailscale0 -s 100.64.0.0/10 -j DROP
+COMMIT
+*nat
+:ts-postrouting - [0:0]
+-I POSTROUTING 1 -j ts-postrouting
+-A ts-postrouting -m mark --mark 0x40000/0xff0000 -j MASQUERADE
+COMMIT
+`},
+		{RulesetIP6Tables, `
+# Tailscale rules, for ip6tables-restore --noflush.
+*filter
+:ts-forward - [0:0]
+:ts-input - [0:0]
+-I FORWARD 1 -j ts-forward
+-I INPUT 1 -j ts-input
+-A ts-forward -i tailscale0 -j MARK --set-mark 0x40000/0xff0000
+-A ts-forward -m mark --mark 0x40000/0xff0000 -j ACCEPT
+-A ts-forward -o tailscale0 -j ACCEPT
+COMMIT
+`},
+		{RulesetNfTables, `
+# Tailscale rules, for nft -f.
+add table ip filter
+add chain ip filter FORWARD { type filter hook forward priority filter; policy accept; }
+add chain ip filter INPUT { type filter hook input priority filter; policy accept; }
+add chain ip filter ts-forward
+flush chain ip filter ts-forward
+add chain ip filter ts-input
+flush chain ip filter ts-input
+insert rule ip filter FORWARD jump ts-forward
+insert rule ip filter INPUT jump ts-input
+add rule ip filter ts-forward iifname "tailscale0" meta mark set meta mark & 0xff00ffff | 0x40000
+add rule ip filter ts-forward meta mark & 0xff0000 == 0x40000 accept
+add rule ip filter ts-forward oifname "tailscale0" ip saddr 100.64.0.0/10 drop
+add rule ip filter ts-forward oifname "tailscale0" accept
+add rule ip filter ts-input iifname "lo" ip saddr 100.64.0.1 accept
+add rule ip filter ts-input iifname != "tailscale0" ip saddr 100.115.92.0/23 return
+add rule ip filter ts-input iifname != "tailscale0" ip saddr 100.64.0.0/10 drop
+add table ip nat
+add chain ip nat POSTROUTING { type nat hook postrouting priority srcnat; policy accept; }
+add chain ip nat ts-postrouting
+flush chain ip nat ts-postrouting
+insert rule ip nat POSTROUTING jump ts-postrouting
+add rule ip nat ts-postrouting meta mark & 0xff0000 == 0x40000 masquerade
+add table ip6 filter
+add chain ip6 filter FORWARD { type filter hook forward priority filter; policy accept; }
+add chain ip6 filter INPUT { type filter hook input priority filter; policy accept; }
+add chain ip6 filter ts-forward
+flush chain ip6 filter ts-forward
+add chain ip6 filter ts-input
+flush chain ip6 filter ts-input
+insert rule ip6 filter FORWARD jump ts-forward
+insert rule ip6 filter INPUT jump ts-input
+add rule ip6 filter ts-forward iifname "tailscale0" meta mark set meta mark & 0xff00ffff | 0x40000
+add rule ip6 filter ts-forward meta mark & 0xff0000 == 0x40000 accept
+add rule ip6 filter ts-forward oifname "tailscale0" accept
+`},
+	}
+	for _, tt := range tests {
+		got, err := r.Ruleset(tt.format)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.format, err)
+		}
+		if want := strings.TrimPrefix(tt.want, "\n"); got != want {
+			t.Errorf("%s ruleset:\n%s\nwant:\n%s", tt.format, got, want)
+		}
+	}
+
+	// Tearing it all down leaves no rules.
+	if err := r.DelHooks(t.Logf); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DelChains(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Ruleset(RulesetNfTables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Tailscale rules, for nft -f.\n"; got != want {
+		t.Errorf("after teardown got:\n%s\nwant:\n%s", got, want)
+	}
+	if _, err := r.Ruleset("pf"); err == nil {
+		t.Error("unknown format: got success; want error")
+	}
+}
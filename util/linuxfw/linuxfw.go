@@ -52,6 +52,10 @@ func (e FWModeNotSupportedError) Unwrap() error {
 const (
 	FirewallModeIPTables FirewallMode = "iptables"
 	FirewallModeNfTables FirewallMode = "nftables"
+
+	// FirewallModeExternal computes the rules without installing them,
+	// leaving that to the host's firewall management. See ExportRunner.
+	FirewallModeExternal FirewallMode = "external"
 )
 
 // The following bits are added to packet marks for Tailscale use.
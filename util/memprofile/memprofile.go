@@ -0,0 +1,124 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package memprofile defines memory profiles, which trade off how much
+// memory Tailscale uses against its throughput, making it possible to run
+// on devices such as routers with only 64–128MB of RAM.
+//
+// A profile is process-wide. It's selected with tailscaled's
+// --memory-profile flag or tsnet.Server.MemoryProfile, and must be applied
+// before the engine is created, as the buffer and connection limits it
+// sets are read then.
+package memprofile
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime/debug"
+	"sync/atomic"
+
+	"tailscale.com/util/cmpx"
+)
+
+// Profile is a memory profile.
+type Profile string
+
+const (
+	// Default uses the Go runtime's and the network stacks' default
+	// settings.
+	Default Profile = "default"
+
+	// Low minimizes memory use, at the cost of throughput: the garbage
+	// collector runs more often and works to keep the heap under a soft
+	// limit, and fewer packets and connections are buffered.
+	Low Profile = "low"
+
+	// Throughput maximizes throughput, at the cost of memory: the garbage
+	// collector runs less often, as a memory ballast would make it.
+	Throughput Profile = "throughput"
+)
+
+// Parse parses the name of a Profile. The empty string is Default.
+func Parse(s string) (Profile, error) {
+	switch p := Profile(s); p {
+	case "":
+		return Default, nil
+	case Default, Low, Throughput:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown memory profile %q; want %q, %q or %q", s, Low, Default, Throughput)
+}
+
+// Settings are the settings of a Profile. A zero field means the default
+// of the component it configures.
+type Settings struct {
+	// GCPercent is the garbage collection target percentage, as GOGC.
+	GCPercent int
+
+	// MemoryLimit is the soft limit of the Go runtime's memory use in
+	// bytes, as GOMEMLIMIT.
+	MemoryLimit int64
+
+	// BatchSize is the maximum number of UDP packets magicsock and
+	// WireGuard read or write at once, each of which has a buffer.
+	BatchSize int
+
+	// TCPBufferSize and TCPMaxBufferSize are the initial and maximum
+	// sizes of the send and receive buffers of each netstack TCP
+	// connection.
+	TCPBufferSize    int
+	TCPMaxBufferSize int
+
+	// MaxInFlightTCP is the maximum number of TCP connections to netstack
+	// being set up at once.
+	MaxInFlightTCP int
+}
+
+// Settings returns the settings of p. Unknown profiles have the
+// settings of Default.
+func (p Profile) Settings() Settings {
+	switch p {
+	case Low:
+		return Settings{
+			GCPercent:        50,
+			MemoryLimit:      48 << 20,
+			BatchSize:        8,
+			TCPBufferSize:    32 << 10,
+			TCPMaxBufferSize: 256 << 10,
+			MaxInFlightTCP:   64,
+		}
+	case Throughput:
+		return Settings{
+			GCPercent:        200,
+			TCPMaxBufferSize: 8 << 20,
+		}
+	}
+	return Settings{}
+}
+
+var current atomic.Value // of Profile
+
+// Current returns the profile last applied by Apply, or Default.
+func Current() Profile {
+	p, _ := current.Load().(Profile)
+	if p == "" {
+		return Default
+	}
+	return p
+}
+
+// Apply makes p the process's current profile and applies its garbage
+// collector settings, except those overridden by the GOGC and GOMEMLIMIT
+// environment variables. Applying Default restores the Go runtime's
+// defaults.
+func Apply(p Profile) {
+	current.Store(p)
+	s := p.Settings()
+	if os.Getenv("GOGC") == "" {
+		debug.SetGCPercent(cmpx.Or(s.GCPercent, 100))
+	}
+	if os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(cmpx.Or(s.MemoryLimit, math.MaxInt64))
+	}
+}
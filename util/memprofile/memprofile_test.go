@@ -0,0 +1,52 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package memprofile
+
+import (
+	"math"
+	"runtime/debug"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for in, want := range map[string]Profile{
+		"":           Default,
+		"default":    Default,
+		"low":        Low,
+		"throughput": Throughput,
+	} {
+		got, err := Parse(in)
+		if err != nil || got != want {
+			t.Errorf("Parse(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := Parse("tiny"); err == nil {
+		t.Error("Parse of unknown profile succeeded")
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Setenv("GOGC", "")
+	t.Setenv("GOMEMLIMIT", "")
+	defer Apply(Default)
+
+	Apply(Low)
+	if got := Current(); got != Low {
+		t.Errorf("Current = %q; want %q", got, Low)
+	}
+	if got := debug.SetGCPercent(-1); got != Low.Settings().GCPercent {
+		t.Errorf("GC percent = %d; want %d", got, Low.Settings().GCPercent)
+	}
+	if got := debug.SetMemoryLimit(-1); got != Low.Settings().MemoryLimit {
+		t.Errorf("memory limit = %d; want %d", got, Low.Settings().MemoryLimit)
+	}
+
+	Apply(Default)
+	if got := debug.SetGCPercent(-1); got != 100 {
+		t.Errorf("after Default, GC percent = %d; want 100", got)
+	}
+	if got := debug.SetMemoryLimit(-1); got != math.MaxInt64 {
+		t.Errorf("after Default, memory limit = %d; want none", got)
+	}
+}
@@ -3,7 +3,8 @@
 
 /*
 Package systemd contains a minimal wrapper around systemd-notify to enable
-applications to signal readiness and status to systemd.
+applications to signal readiness and status to systemd and to ping its
+watchdog, and support for systemd socket activation.
 
 This package will only have effect on Linux systems running Tailscale in a
 systemd unit with the Type=notify flag set. On other operating systems (or
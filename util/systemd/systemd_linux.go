@@ -7,9 +7,14 @@
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/mdlayher/sdnotify"
 )
@@ -30,8 +35,9 @@ func (l *logOnce) logf(format string, args ...any) {
 }
 
 var (
-	readyOnce  = &logOnce{}
-	statusOnce = &logOnce{}
+	readyOnce    = &logOnce{}
+	statusOnce   = &logOnce{}
+	watchdogOnce = &logOnce{}
 )
 
 func notifier() *sdnotify.Notifier {
@@ -75,3 +81,98 @@ func Status(format string, args ...any) {
 		statusOnce.logf("systemd: error notifying: %v", err)
 	}
 }
+
+// Stopping tells systemd that the service is beginning its shutdown.
+func Stopping() {
+	err := notifier().Notify(sdnotify.Stopping)
+	if err != nil {
+		readyOnce.logf("systemd: error notifying: %v", err)
+	}
+}
+
+// WatchdogInterval returns how often systemd expects Watchdog to be called,
+// or zero if the unit doesn't have WatchdogSec set. Watchdog should be called
+// at least twice as often, to leave room for scheduling delays.
+func WatchdogInterval() time.Duration {
+	return watchdogInterval(os.Getenv, os.Getpid())
+}
+
+func watchdogInterval(getenv func(string) string, pid int) time.Duration {
+	if p := getenv("WATCHDOG_PID"); p != "" && p != strconv.Itoa(pid) {
+		// Meant for a different process, such as our parent.
+		return 0
+	}
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watchdog tells systemd that the service is still alive, resetting its
+// watchdog timer. If the unit has WatchdogSec set and Watchdog isn't called
+// within it, systemd considers the service hung and, depending on its
+// Restart setting, restarts it.
+func Watchdog() {
+	err := notifier().Notify("WATCHDOG=1")
+	if err != nil {
+		watchdogOnce.logf("systemd: error notifying: %v", err)
+	}
+}
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation. See sd_listen_fds(3).
+const listenFDsStart = 3
+
+// Listener returns the listening socket passed to the process by systemd
+// socket activation, if any. It returns a nil Listener and no error if the
+// process wasn't socket activated. With more than one socket passed, the
+// one named name (by FileDescriptorName in the .socket unit) is returned.
+//
+// The socket activation environment variables are unset, so that child
+// processes don't also try to use the sockets.
+func Listener(name string) (net.Listener, error) {
+	n, names := listenFDs(os.Getenv, os.Getpid())
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n == 0 {
+		return nil, nil
+	}
+	i := 0
+	if n > 1 {
+		i = -1
+		for j, nm := range names {
+			if nm == name {
+				i = j
+				break
+			}
+		}
+		if i == -1 {
+			return nil, fmt.Errorf("systemd passed %d sockets, none named %q", n, name)
+		}
+	}
+	f := os.NewFile(uintptr(listenFDsStart+i), name)
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket %d: %w", listenFDsStart+i, err)
+	}
+	return ln, nil
+}
+
+// listenFDs returns the number of sockets passed by systemd socket activation
+// to the process pid and their names, if known.
+func listenFDs(getenv func(string) string, pid int) (n int, names []string) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return 0, nil
+	}
+	if v := getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+	return n, names
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package systemd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want time.Duration
+	}{
+		{"unset", nil, 0},
+		{"set", map[string]string{"WATCHDOG_USEC": "30000000"}, 30 * time.Second},
+		{"our_pid", map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "123"}, 30 * time.Second},
+		{"other_pid", map[string]string{"WATCHDOG_USEC": "30000000", "WATCHDOG_PID": "1"}, 0},
+		{"bogus", map[string]string{"WATCHDOG_USEC": "soon"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := watchdogInterval(func(k string) string { return tt.env[k] }, 123)
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenFDs(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		wantN     int
+		wantNames []string
+	}{
+		{"unset", nil, 0, nil},
+		{"one", map[string]string{"LISTEN_PID": "123", "LISTEN_FDS": "1"}, 1, nil},
+		{"named", map[string]string{"LISTEN_PID": "123", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "a:tailscaled.sock"}, 2, []string{"a", "tailscaled.sock"}},
+		{"other_pid", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, 0, nil},
+		{"bogus", map[string]string{"LISTEN_PID": "123", "LISTEN_FDS": "x"}, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, names := listenFDs(func(k string) string { return tt.env[k] }, 123)
+			if n != tt.wantN || !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("got %v, %q; want %v, %q", n, names, tt.wantN, tt.wantNames)
+			}
+		})
+	}
+}
@@ -5,5 +5,14 @@
 
 package systemd
 
-func Ready()                {}
-func Status(string, ...any) {}
+import (
+	"net"
+	"time"
+)
+
+func Ready()                                {}
+func Status(string, ...any)                 {}
+func Stopping()                             {}
+func WatchdogInterval() time.Duration       { return 0 }
+func Watchdog()                             {}
+func Listener(string) (net.Listener, error) { return nil, nil }
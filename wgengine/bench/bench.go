@@ -1,24 +1,38 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-// Create two wgengine instances and pass data through them, measuring
-// throughput, latency, and packet loss.
+// The bench program measures the performance of the data plane: the
+// throughput, latency under load and CPU use of passing packets through two
+// wgengine instances, or through simpler transports for comparison.
+//
+// With flags, it runs one benchmark and reports the results, as JSON with
+// --json for tracking performance regressions in CI:
+//
+//	go run tailscale.com/wgengine/bench --test=wireguard --json
+//
+// Given a test number instead, it runs that test until interrupted, logging
+// its results every second and serving pprof on port 8999.
 package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"net/netip"
-	"os"
 	"strconv"
 	"sync"
 	"time"
 
 	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/bench/benchreport"
 )
 
 const PayloadSize = 1000
@@ -27,63 +41,84 @@
 var Addr1 = netip.MustParsePrefix("100.64.1.1/32")
 var Addr2 = netip.MustParsePrefix("100.64.1.2/32")
 
+var (
+	testFlag     = flag.String("test", "wireguard", "name of the benchmark to run")
+	durationFlag = flag.Duration("duration", 10*time.Second, "how long to measure for")
+	sizeFlag     = flag.Int("size", 0, "packet size in bytes; 0 means the default")
+	jsonFlag     = flag.Bool("json", false, "output in JSON format")
+	verboseFlag  = flag.Bool("verbose", false, "log the progress of the benchmark")
+)
+
+// testNumbers are the names of the tests selected by number, as in
+// "bench 101".
+var testNumbers = map[int]string{
+	1:   "trivial-noalloc",
+	2:   "trivial",
+	11:  "blocking-channel",
+	12:  "nonblocking-channel",
+	13:  "double-channel",
+	21:  "udp",
+	31:  "batch-tcp",
+	101: "wireguard",
+}
+
 func main() {
-	var logf logger.Logf = log.Printf
 	log.SetFlags(0)
+	flag.Parse()
 
-	debugMux := newDebugMux()
-	go runDebugServer(debugMux, "0.0.0.0:8999")
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		n, err := strconv.Atoi(flag.Arg(0))
+		if err != nil {
+			log.Fatalf("%q: %v", flag.Arg(0), err)
+		}
+		test, ok := testNumbers[n]
+		if !ok {
+			log.Fatalf("provide a valid test number (0..n)")
+		}
+		runForever(log.Printf, test)
+	default:
+		log.Fatalf("usage: bench [flags] [<test-number>]")
+	}
 
-	mode, err := strconv.Atoi(os.Args[1])
+	logf := logger.Discard
+	if *verboseFlag {
+		logf = log.Printf
+	}
+	rep, err := Run(context.Background(), Options{
+		Test:       *testFlag,
+		Duration:   *durationFlag,
+		PacketSize: *sizeFlag,
+		Logf:       logf,
+	})
 	if err != nil {
-		log.Fatalf("%q: %v", os.Args[1], err)
+		log.Fatal(err)
 	}
+	if *jsonFlag {
+		j, _ := json.MarshalIndent(rep, "", "\t")
+		fmt.Printf("%s\n", j)
+		return
+	}
+	fmt.Printf("%s, %d-byte packets, for %v: tx=%d rx=%d lost=%d\n", rep.Test, rep.PacketSize, rep.Duration.Round(time.Millisecond), rep.TxPackets, rep.RxPackets, rep.LostPackets)
+	fmt.Printf("throughput: %.1f Mbit/s\n", rep.Mbps)
+	if rep.LatencyP50 > 0 {
+		fmt.Printf("latency under load: p50 %v, p99 %v\n", rep.LatencyP50, rep.LatencyP99)
+	}
+	if rep.CPUPerGbps > 0 {
+		fmt.Printf("CPU: %.2f cores per Gbit/s\n", rep.CPUPerGbps)
+	}
+}
 
-	traf := NewTrafficGen(nil)
-
-	// Sample test results below are using GOMAXPROCS=2 (for some
-	// tests, including wireguard-go, higher GOMAXPROCS goes slower)
-	// on apenwarr's old Linux box:
-	//   Intel(R) Core(TM) i7-4785T CPU @ 2.20GHz
-	// My 2019 Mac Mini is about 20% faster on most tests.
-
-	switch mode {
-	// tx=8786325 rx=8786326 (0 = 0.00% loss) (70768.7 Mbits/sec)
-	case 1:
-		setupTrivialNoAllocTest(logf, traf)
-
-	// tx=6476293 rx=6476293 (0 = 0.00% loss) (52249.7 Mbits/sec)
-	case 2:
-		setupTrivialTest(logf, traf)
-
-	// tx=1957974 rx=1958379 (0 = 0.00% loss) (15939.8 Mbits/sec)
-	case 11:
-		setupBlockingChannelTest(logf, traf)
-
-	// tx=728621 rx=701825 (26620 = 3.65% loss) (5525.2 Mbits/sec)
-	// (much faster on macOS??)
-	case 12:
-		setupNonblockingChannelTest(logf, traf)
-
-	// tx=1024260 rx=941098 (83334 = 8.14% loss) (7516.6 Mbits/sec)
-	// (much faster on macOS??)
-	case 13:
-		setupDoubleChannelTest(logf, traf)
-
-	// tx=265468 rx=263189 (2279 = 0.86% loss) (2162.0 Mbits/sec)
-	case 21:
-		setupUDPTest(logf, traf)
-
-	// tx=1493580 rx=1493580 (0 = 0.00% loss) (12210.4 Mbits/sec)
-	case 31:
-		setupBatchTCPTest(logf, traf)
-
-	// tx=134236 rx=133166 (1070 = 0.80% loss) (1088.9 Mbits/sec)
-	case 101:
-		setupWGTest(nil, logf, traf, Addr1, Addr2)
+// runForever runs test, logging its results every second, until the
+// program is interrupted.
+func runForever(logf logger.Logf, test string) {
+	debugMux := newDebugMux()
+	go runDebugServer(debugMux, "0.0.0.0:8999")
 
-	default:
-		log.Fatalf("provide a valid test number (0..n)")
+	traf := NewTrafficGen(nil)
+	if _, err := Tests[test](logf, traf); err != nil {
+		log.Fatal(err)
 	}
 
 	logf("initialized ok.")
@@ -132,9 +167,167 @@ func runDebugServer(mux *http.ServeMux, addr string) {
 	}
 }
 
+// A SetupFunc connects the packets a TrafficGen generates back to it,
+// through what's being benchmarked. The returned cleanup func releases
+// its resources once the TrafficGen is stopped.
+type SetupFunc func(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error)
+
+// Tests are the local benchmarks, by name.
+//
+// Sample results (tx/rx packets per 1s interval, and throughput) using
+// GOMAXPROCS=2 (for some tests, including wireguard-go, higher GOMAXPROCS
+// goes slower) on apenwarr's old Linux box:
+//
+//	Intel(R) Core(TM) i7-4785T CPU @ 2.20GHz
+//
+// My 2019 Mac Mini is about 20% faster on most tests.
+var Tests = map[string]SetupFunc{
+	// tx=8786325 rx=8786326 (0 = 0.00% loss) (70768.7 Mbits/sec)
+	"trivial-noalloc": setupTrivialNoAllocTest,
+
+	// tx=6476293 rx=6476293 (0 = 0.00% loss) (52249.7 Mbits/sec)
+	"trivial": setupTrivialTest,
+
+	// tx=1957974 rx=1958379 (0 = 0.00% loss) (15939.8 Mbits/sec)
+	"blocking-channel": setupBlockingChannelTest,
+
+	// tx=728621 rx=701825 (26620 = 3.65% loss) (5525.2 Mbits/sec)
+	// (much faster on macOS??)
+	"nonblocking-channel": setupNonblockingChannelTest,
+
+	// tx=1024260 rx=941098 (83334 = 8.14% loss) (7516.6 Mbits/sec)
+	// (much faster on macOS??)
+	"double-channel": setupDoubleChannelTest,
+
+	// tx=265468 rx=263189 (2279 = 0.86% loss) (2162.0 Mbits/sec)
+	"udp": setupUDPTest,
+
+	// tx=1493580 rx=1493580 (0 = 0.00% loss) (12210.4 Mbits/sec)
+	"batch-tcp": setupBatchTCPTest,
+
+	// tx=134236 rx=133166 (1070 = 0.80% loss) (1088.9 Mbits/sec)
+	"wireguard": func(logf logger.Logf, traf *TrafficGen) (func(), error) {
+		return setupWGTest(logf, traf, Addr1, Addr2)
+	},
+}
+
+// Options configure a local benchmark.
+type Options struct {
+	// Test is the name of the benchmark to run, one of Tests. If empty,
+	// it's "wireguard", which measures TUN-to-TUN performance.
+	Test string
+
+	// Duration is how long to measure for, after a warm-up that lasts
+	// until packets get through (for wireguard, until the handshake
+	// completes) and then up to two seconds more. If zero, it's 10
+	// seconds.
+	Duration time.Duration
+
+	// PacketSize is the size of the packets sent, in bytes. It must be
+	// at least ICMPMinSize+8. If zero, it's ICMPMinSize+PayloadSize.
+	PacketSize int
+
+	// Logf, if non-nil, logs the progress of the benchmark.
+	Logf logger.Logf
+}
+
+// warmUpTimeout is how long Run waits for the first packet to get through.
+const warmUpTimeout = 30 * time.Second
+
+// Run runs the local benchmark opts.Test.
+func Run(ctx context.Context, opts Options) (*benchreport.Report, error) {
+	test := opts.Test
+	if test == "" {
+		test = "wireguard"
+	}
+	setup, ok := Tests[test]
+	if !ok {
+		return nil, fmt.Errorf("unknown benchmark %q", test)
+	}
+	size := opts.PacketSize
+	if size == 0 {
+		size = ICMPMinSize + PayloadSize
+	}
+	if size < ICMPMinSize+8 || size > 1500 {
+		return nil, fmt.Errorf("packet size %d not between %d and 1500", size, ICMPMinSize+8)
+	}
+	dur := opts.Duration
+	if dur <= 0 {
+		dur = 10 * time.Second
+	}
+	logf := opts.Logf
+	if logf == nil {
+		logf = logger.Discard
+	}
+
+	traf := NewTrafficGen(nil)
+	cleanup, err := setup(logf, traf)
+	if err != nil {
+		traf.Start(Addr1.Addr(), Addr2.Addr(), size, 0)
+		traf.Stop()
+		return nil, err
+	}
+	defer cleanup()
+	traf.Start(Addr1.Addr(), Addr2.Addr(), size, 0)
+	defer traf.Stop()
+
+	// adjust adjusts the transmit rate until d has passed and done, if
+	// non-nil, reports true.
+	adjust := func(ctx context.Context, d time.Duration, done func() bool) error {
+		t := time.NewTicker(10 * time.Millisecond)
+		defer t.Stop()
+		deadline := time.Now().Add(d)
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case now := <-t.C:
+				traf.Adjust()
+				if !now.Before(deadline) && (done == nil || done()) {
+					return nil
+				}
+			}
+		}
+	}
+
+	// Skip any handshakes, and let the transmit rate settle.
+	warmCtx, cancel := context.WithTimeout(ctx, warmUpTimeout)
+	defer cancel()
+	err = adjust(warmCtx, 0, func() bool { return traf.Snap().TotalBytesRx > 0 })
+	if err == nil {
+		err = adjust(ctx, min(dur/5, 2*time.Second), nil)
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			return nil, errors.New("no packets received")
+		}
+		return nil, err
+	}
+	logf("warmed up; measuring for %v", dur)
+
+	start, cpu0, lat0 := traf.Snap(), cpuTime(), len(traf.Latencies())
+	if err := adjust(ctx, dur, nil); err != nil {
+		return nil, err
+	}
+	end, cpu1 := traf.Snap(), cpuTime()
+	d := end.Sub(start)
+	logf("%v", d)
+	if d.RxPackets <= 0 {
+		return nil, errors.New("no packets received")
+	}
+
+	rep := benchreport.New(test)
+	rep.PacketSize = size
+	rep.TxPackets = d.TxPackets
+	rep.RxPackets = d.RxPackets
+	rep.LostPackets = d.LostPackets
+	rep.Fill(d.Bytes, time.Duration(d.DurationNsec), cpu1-cpu0, traf.Latencies()[lat0:])
+	return rep, nil
+}
+
 // The absolute minimal test of the traffic generator: have it fill
 // a packet buffer, then absorb it again. Zero packet loss.
-func setupTrivialNoAllocTest(logf logger.Logf, traf *TrafficGen) {
+func setupTrivialNoAllocTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	go func() {
 		b := make([]byte, 1600)
 		for {
@@ -145,11 +338,12 @@ func setupTrivialNoAllocTest(logf logger.Logf, traf *TrafficGen) {
 			traf.GotPacket(b[0:n+16], 16)
 		}
 	}()
+	return func() {}, nil
 }
 
 // Almost the same, but this time allocate a fresh buffer each time
 // through the loop. Still zero packet loss. Runs about 2/3 as fast for me.
-func setupTrivialTest(logf logger.Logf, traf *TrafficGen) {
+func setupTrivialTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	go func() {
 		for {
 			b := make([]byte, 1600)
@@ -160,12 +354,13 @@ func setupTrivialTest(logf logger.Logf, traf *TrafficGen) {
 			traf.GotPacket(b[0:n+16], 16)
 		}
 	}()
+	return func() {}, nil
 }
 
 // Pass packets through a blocking channel between sender and receiver.
 // Still zero packet loss since the sender stops when the channel is full.
 // Max speed depends on channel length (I'm not sure why).
-func setupBlockingChannelTest(logf logger.Logf, traf *TrafficGen) {
+func setupBlockingChannelTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	ch := make(chan []byte, 1000)
 
 	go func() {
@@ -187,12 +382,13 @@ func setupBlockingChannelTest(logf logger.Logf, traf *TrafficGen) {
 			traf.GotPacket(b, 16)
 		}
 	}()
+	return func() {}, nil
 }
 
 // Same as setupBlockingChannelTest, but now we drop packets whenever the
 // channel is full. Max speed is about the same as the above test, but
 // now with nonzero packet loss.
-func setupNonblockingChannelTest(logf logger.Logf, traf *TrafficGen) {
+func setupNonblockingChannelTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	ch := make(chan []byte, 1000)
 
 	go func() {
@@ -217,12 +413,13 @@ func setupNonblockingChannelTest(logf logger.Logf, traf *TrafficGen) {
 			traf.GotPacket(b, 16)
 		}
 	}()
+	return func() {}, nil
 }
 
 // Same as above, but at an intermediate blocking channel and goroutine
 // to make things a little more like wireguard-go. Roughly 20% slower than
 // the single-channel version.
-func setupDoubleChannelTest(logf logger.Logf, traf *TrafficGen) {
+func setupDoubleChannelTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	ch := make(chan []byte, 1000)
 	ch2 := make(chan []byte, 1000)
 
@@ -256,22 +453,24 @@ func setupDoubleChannelTest(logf logger.Logf, traf *TrafficGen) {
 			traf.GotPacket(b, 16)
 		}
 	}()
+	return func() {}, nil
 }
 
 // Instead of a channel, pass packets through a UDP socket.
-func setupUDPTest(logf logger.Logf, traf *TrafficGen) {
+func setupUDPTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
 	la, err := net.ResolveUDPAddr("udp", ":0")
 	if err != nil {
-		log.Fatalf("resolve: %v", err)
+		return nil, fmt.Errorf("resolve: %w", err)
 	}
 
 	s1, err := net.ListenUDP("udp", la)
 	if err != nil {
-		log.Fatalf("listen1: %v", err)
+		return nil, fmt.Errorf("listen1: %w", err)
 	}
 	s2, err := net.ListenUDP("udp", la)
 	if err != nil {
-		log.Fatalf("listen2: %v", err)
+		s1.Close()
+		return nil, fmt.Errorf("listen2: %w", err)
 	}
 
 	a2 := s2.LocalAddr()
@@ -305,11 +504,18 @@ func setupUDPTest(logf logger.Logf, traf *TrafficGen) {
 			// going to actually look at the address.
 			n, _, err := s2.ReadFrom(b)
 			if err != nil {
-				log.Fatalf("s2.Read: %v", err)
+				if traf.Running() {
+					logf("s2.Read: %v", err)
+				}
+				return
 			}
 			traf.GotPacket(b[:n], 0)
 		}
 	}()
+	return func() {
+		s1.Close()
+		s2.Close()
+	}, nil
 }
 
 // Instead of a channel, pass packets through a TCP socket.
@@ -317,10 +523,10 @@ func setupUDPTest(logf logger.Logf, traf *TrafficGen) {
 // multiple packets. 10x amortization seems to make it go ~10x faster,
 // as expected, getting us close to the speed of the channel tests above.
 // There's also zero packet loss.
-func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) {
-	sl, err := net.Listen("tcp", ":0")
+func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) (cleanup func(), err error) {
+	sl, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		log.Fatalf("listen: %v", err)
+		return nil, fmt.Errorf("listen: %w", err)
 	}
 
 	var slCloseOnce sync.Once
@@ -332,12 +538,15 @@ func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) {
 
 	s1, err := net.Dial("tcp", sl.Addr().String())
 	if err != nil {
-		log.Fatalf("dial: %v", err)
+		slClose()
+		return nil, fmt.Errorf("dial: %w", err)
 	}
 
 	s2, err := sl.Accept()
 	if err != nil {
-		log.Fatalf("accept: %v", err)
+		slClose()
+		s1.Close()
+		return nil, fmt.Errorf("accept: %w", err)
 	}
 
 	s1.(*net.TCPConn).SetWriteBuffer(1024 * 1024)
@@ -349,6 +558,7 @@ func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) {
 		// transmitter
 		defer slClose()
 		defer s1.Close()
+		defer close(ch)
 
 		bs1 := bufio.NewWriterSize(s1, 1024*1024)
 
@@ -391,7 +601,10 @@ func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) {
 
 		// Find out the packet size (we happen to know they're
 		// all the same size)
-		packetSize := <-ch
+		packetSize, ok := <-ch
+		if !ok {
+			return
+		}
 
 		b := make([]byte, packetSize)
 		for traf.Running() {
@@ -401,9 +614,16 @@ func setupBatchTCPTest(logf logger.Logf, traf *TrafficGen) {
 			// this test does not.)
 			n, err := io.ReadFull(bs2, b)
 			if err != nil {
-				log.Fatalf("s2.Read: %v", err)
+				if traf.Running() {
+					logf("s2.Read: %v", err)
+				}
+				return
 			}
 			traf.GotPacket(b[:n], 0)
 		}
 	}()
+	return func() {
+		s1.Close()
+		s2.Close()
+	}, nil
 }
@@ -1,11 +1,10 @@
 // Copyright (c) Tailscale Inc & AUTHORS
 // SPDX-License-Identifier: BSD-3-Clause
 
-// Create two wgengine instances and pass data through them, measuring
-// throughput, latency, and packet loss.
 package main
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
@@ -42,13 +41,9 @@ func BenchmarkBatchTCP(b *testing.B) {
 
 func BenchmarkWireGuardTest(b *testing.B) {
 	b.Skip("https://github.com/tailscale/tailscale/issues/2716")
-	run(b, func(logf logger.Logf, traf *TrafficGen) {
-		setupWGTest(b, logf, traf, Addr1, Addr2)
-	})
+	run(b, Tests["wireguard"])
 }
 
-type SetupFunc func(logger.Logf, *TrafficGen)
-
 func run(b *testing.B, setup SetupFunc) {
 	sizes := []int{
 		ICMPMinSize + 8,
@@ -73,7 +68,12 @@ func runOnce(b *testing.B, setup SetupFunc, payload int) {
 	}
 
 	traf := NewTrafficGen(b.StartTimer)
-	setup(logf, traf)
+	cleanup, err := setup(logf, traf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+	defer traf.Stop()
 
 	logf("initialized. (n=%v)", b.N)
 	b.SetBytes(int64(payload))
@@ -106,3 +106,30 @@ func runOnce(b *testing.B, setup SetupFunc, payload int) {
 
 	b.ReportMetric(loss*100, "%lost")
 }
+
+func TestRun(t *testing.T) {
+	rep, err := Run(context.Background(), Options{
+		Test:     "trivial",
+		Duration: 500 * time.Millisecond,
+		Logf:     t.Logf,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Test != "trivial" || rep.PacketSize != ICMPMinSize+PayloadSize {
+		t.Errorf("Test, PacketSize = %q, %d", rep.Test, rep.PacketSize)
+	}
+	if rep.RxPackets <= 0 || rep.Bytes <= 0 || rep.Mbps <= 0 {
+		t.Errorf("RxPackets, Bytes, Mbps = %d, %d, %v; want positive", rep.RxPackets, rep.Bytes, rep.Mbps)
+	}
+	if rep.LatencyP50 <= 0 || rep.LatencyP99 < rep.LatencyP50 {
+		t.Errorf("LatencyP50, LatencyP99 = %v, %v", rep.LatencyP50, rep.LatencyP99)
+	}
+
+	if _, err := Run(context.Background(), Options{Test: "nope"}); err == nil {
+		t.Error("unknown test: got nil error")
+	}
+	if _, err := Run(context.Background(), Options{Test: "trivial", PacketSize: 10}); err == nil {
+		t.Error("small PacketSize: got nil error")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package benchreport contains the report of a data plane benchmark, as
+// output as JSON by the tailscale.com/wgengine/bench program and by
+// "tailscale debug bench", for tracking performance regressions.
+package benchreport
+
+import (
+	"runtime"
+	"slices"
+	"time"
+
+	"tailscale.com/version"
+)
+
+// Report is the result of a data plane benchmark.
+type Report struct {
+	// Test is the benchmark run: one of the local benchmarks of the
+	// tailscale.com/wgengine/bench program, such as "wireguard", which
+	// pass packets between two engines, or "peer", which sends data to
+	// Peer over the tailnet.
+	Test string
+	Peer string `json:",omitempty"` // Tailscale IP, for the "peer" test
+
+	// The machine and software the benchmark ran on.
+	GOOS    string
+	GOARCH  string
+	NumCPU  int
+	Version string
+
+	PacketSize int `json:",omitempty"` // bytes per packet, for local tests
+
+	Duration    time.Duration // of the measurement, after warm-up
+	Bytes       int64         // received
+	TxPackets   int64         `json:",omitempty"`
+	RxPackets   int64         `json:",omitempty"`
+	LostPackets int64         `json:",omitempty"`
+
+	Mbps float64 // throughput, in Mbit/s
+
+	// LatencyP50 and LatencyP99 are percentiles of latency under load:
+	// of sampled packets, for local tests, or of pings sent while
+	// loading the link, for the "peer" test.
+	LatencyP50 time.Duration `json:",omitempty"`
+	LatencyP99 time.Duration `json:",omitempty"`
+
+	// CPUPerGbps is the CPU time used per second of the benchmark, in
+	// cores, for each Gbit/s of throughput. It's zero where CPU time
+	// can't be measured, including for the "peer" test, whose work is
+	// done by tailscaled.
+	CPUPerGbps float64 `json:",omitempty"`
+}
+
+// New returns a Report of the benchmark test on this machine.
+func New(test string) *Report {
+	return &Report{
+		Test:    test,
+		GOOS:    runtime.GOOS,
+		GOARCH:  runtime.GOARCH,
+		NumCPU:  runtime.NumCPU(),
+		Version: version.Long(),
+	}
+}
+
+// Fill sets the measurements of r from the number of bytes received in
+// the elapsed time, the CPU time used meanwhile (zero if unknown) and the
+// latencies measured, which it sorts.
+func (r *Report) Fill(bytes int64, elapsed, cpu time.Duration, latencies []time.Duration) {
+	r.Duration = elapsed
+	r.Bytes = bytes
+	if elapsed > 0 {
+		r.Mbps = float64(bytes) * 8 / elapsed.Seconds() / 1e6
+	}
+	if cpu > 0 && r.Mbps > 0 {
+		r.CPUPerGbps = cpu.Seconds() / elapsed.Seconds() / (r.Mbps / 1000)
+	}
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		r.LatencyP50 = latencies[len(latencies)/2]
+		r.LatencyP99 = latencies[len(latencies)*99/100]
+	}
+}
@@ -0,0 +1,13 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build windows || wasm || plan9 || tamago
+
+package main
+
+import "time"
+
+// cpuTime returns zero, as the process's CPU time isn't measured here.
+func cpuTime() time.Duration {
+	return 0
+}
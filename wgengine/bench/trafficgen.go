@@ -6,7 +6,6 @@
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
 	"net/netip"
 	"sync"
 	"time"
@@ -76,6 +75,23 @@ type TrafficGen struct {
 	// ppsHistory is the observed packets-per-second from recent
 	// samples.
 	ppsHistory [5]int64
+
+	// sent are the send times of the most recent packets sampled for
+	// latency, every latencySampleEvery packets, indexed by their
+	// sequence number modulo len(sent).
+	sent      [64]sentPacket
+	latencies []time.Duration // of sampled packets, up to maxLatencies
+}
+
+// latencySampleEvery is how often packets are sampled for latency.
+const latencySampleEvery = 256
+
+// maxLatencies is the number of latency samples a TrafficGen keeps.
+const maxLatencies = 1 << 16
+
+type sentPacket struct {
+	seq  int64
+	when int64 // UnixNano
 }
 
 // NewTrafficGen creates a new, initially locked, TrafficGen.
@@ -107,7 +123,7 @@ func (t *TrafficGen) Start(src, dst netip.Addr, bytesPerPacket int, maxPackets i
 
 	// ensure there's room for ICMP header plus sequence number
 	if bytesPerPacket < ICMPMinSize+8 {
-		log.Fatalf("bytesPerPacket must be > 24+8")
+		panic("bytesPerPacket must be >= 24+8")
 	}
 
 	t.maxPackets = maxPackets
@@ -133,6 +149,22 @@ func (t *TrafficGen) Running() bool {
 	return !t.done
 }
 
+// Stop ends the test, if it's still running.
+func (t *TrafficGen) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.done = true
+}
+
+// Latencies returns the latencies of the packets sampled so far.
+func (t *TrafficGen) Latencies() []time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]time.Duration(nil), t.latencies...)
+}
+
 // Generate produces the next packet in the sequence. It sleeps if
 // it's too soon for the next packet to be sent.
 //
@@ -165,6 +197,9 @@ func (t *TrafficGen) Generate(b []byte, ofs int) int {
 	t.cur.LastSeqTx += 1
 	t.cur.WhenNsec = now
 	seq := t.cur.LastSeqTx
+	if seq%latencySampleEvery == 0 {
+		t.sent[(seq/latencySampleEvery)%int64(len(t.sent))] = sentPacket{seq, now}
+	}
 
 	t.mu.Unlock()
 
@@ -194,6 +229,11 @@ func (t *TrafficGen) GotPacket(b []byte, ofs int) {
 	} else {
 		s.TotalOOO += 1
 	}
+	if seq%latencySampleEvery == 0 && len(t.latencies) < maxLatencies {
+		if sp := t.sent[(seq/latencySampleEvery)%int64(len(t.sent))]; sp.seq == seq {
+			t.latencies = append(t.latencies, time.Duration(time.Now().UnixNano()-sp.when))
+		}
+	}
 
 	// +1 packet since we only start counting after the first one
 	if t.maxPackets > 0 && s.LastSeqRx >= t.maxPackets+1 {
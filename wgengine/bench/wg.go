@@ -5,12 +5,11 @@
 
 import (
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"net/netip"
 	"os"
 	"sync"
-	"testing"
 
 	"github.com/tailscale/wireguard-go/tun"
 
@@ -25,7 +24,9 @@
 	"tailscale.com/wgengine/wgcfg"
 )
 
-func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.Prefix) {
+// setupWGTest passes packets between two userspace engines, with
+// addresses a1 and a2, over UDP on the local machine.
+func setupWGTest(logf logger.Logf, traf *TrafficGen, a1, a2 netip.Prefix) (cleanup func(), err error) {
 	l1 := logger.WithPrefix(logf, "e1: ")
 	k1 := key.NewNode()
 
@@ -45,10 +46,7 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 		Tun:        t1,
 	})
 	if err != nil {
-		log.Fatalf("e1 init: %v", err)
-	}
-	if b != nil {
-		b.Cleanup(e1.Close)
+		return nil, fmt.Errorf("e1 init: %w", err)
 	}
 
 	l2 := logger.WithPrefix(logf, "e2: ")
@@ -59,8 +57,9 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 		Addresses:  []netip.Prefix{a2},
 	}
 	t2 := &sinkTun{
-		logf: logger.WithPrefix(logf, "tun2: "),
-		traf: traf,
+		logf:   logger.WithPrefix(logf, "tun2: "),
+		traf:   traf,
+		closed: make(chan struct{}),
 	}
 	e2, err := wgengine.NewUserspaceEngine(l2, wgengine.Config{
 		Router:     router.NewFake(l2),
@@ -69,10 +68,12 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 		Tun:        t2,
 	})
 	if err != nil {
-		log.Fatalf("e2 init: %v", err)
+		e1.Close()
+		return nil, fmt.Errorf("e2 init: %w", err)
 	}
-	if b != nil {
-		b.Cleanup(e2.Close)
+	cleanup = func() {
+		e1.Close()
+		e2.Close()
 	}
 
 	e1.SetFilter(filter.NewAllowAllForTest(l1))
@@ -87,7 +88,9 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 			return
 		}
 		if err != nil {
-			log.Fatalf("e1 status err: %v", err)
+			logf("e1 status err: %v", err)
+			e1waitDoneOnce.Do(wait.Done)
+			return
 		}
 		logf("e1 status: %v", *st)
 
@@ -99,6 +102,8 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 		n := &tailcfg.Node{
 			ID:         tailcfg.NodeID(0),
 			Name:       "n1",
+			Key:        k1.Public(),
+			DiscoKey:   e1.DiscoPublicKey(),
 			Addresses:  []netip.Prefix{a1},
 			AllowedIPs: []netip.Prefix{a1},
 			Endpoints:  eps,
@@ -124,7 +129,9 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 			return
 		}
 		if err != nil {
-			log.Fatalf("e2 status err: %v", err)
+			logf("e2 status err: %v", err)
+			e2waitDoneOnce.Do(wait.Done)
+			return
 		}
 		logf("e2 status: %v", *st)
 
@@ -136,6 +143,8 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 		n := &tailcfg.Node{
 			ID:         tailcfg.NodeID(0),
 			Name:       "n2",
+			Key:        k2.Public(),
+			DiscoKey:   e2.DiscoPublicKey(),
 			Addresses:  []netip.Prefix{a2},
 			AllowedIPs: []netip.Prefix{a2},
 			Endpoints:  eps,
@@ -160,6 +169,7 @@ func setupWGTest(b *testing.B, logf logger.Logf, traf *TrafficGen, a1, a2 netip.
 	e2.SetDERPMap(&tailcfg.DERPMap{})
 
 	wait.Wait()
+	return cleanup, nil
 }
 
 type sourceTun struct {
@@ -195,11 +205,17 @@ func (t *sourceTun) Read(b [][]byte, sizes []int, ofs int) (int, error) {
 }
 
 type sinkTun struct {
-	logf logger.Logf
-	traf *TrafficGen
+	logf      logger.Logf
+	traf      *TrafficGen
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *sinkTun) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
 }
 
-func (t *sinkTun) Close() error             { return nil }
 func (t *sinkTun) Events() <-chan tun.Event { return nil }
 func (t *sinkTun) File() *os.File           { return nil }
 func (t *sinkTun) Flush() error             { return nil }
@@ -207,8 +223,9 @@ func (t *sinkTun) MTU() (int, error)        { return 1500, nil }
 func (t *sinkTun) Name() (string, error)    { return "sink", nil }
 
 func (t *sinkTun) Read(b [][]byte, sizes []int, ofs int) (int, error) {
-	// Never returns
-	select {}
+	// Never returns a packet
+	<-t.closed
+	return 0, io.EOF
 }
 
 func (t *sinkTun) Write(b [][]byte, ofs int) (int, error) {
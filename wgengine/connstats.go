@@ -0,0 +1,67 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"context"
+
+	"tailscale.com/net/connstats"
+	"tailscale.com/util/set"
+)
+
+// connStatsDevices is the netlog.Device given to the network logger in
+// place of the TUN wrapper and magicsock, so that the network logger's
+// Statistics can be combined with those of the SubscribeConnStats
+// subscribers.
+type connStatsDevices struct {
+	e *userspaceEngine
+}
+
+func (d connStatsDevices) SetStatistics(stats *connstats.Statistics) {
+	e := d.e
+	e.connStatsMu.Lock()
+	defer e.connStatsMu.Unlock()
+	e.netlogStats = stats
+	e.installConnStatsLocked()
+}
+
+// installConnStatsLocked sets the Statistics of the TUN wrapper and
+// magicsock to count connections for the network logger and all
+// subscribers.
+//
+// e.connStatsMu must be held.
+func (e *userspaceEngine) installConnStatsLocked() {
+	all := []*connstats.Statistics{e.netlogStats}
+	for _, s := range e.connStatsSubs {
+		all = append(all, s)
+	}
+	stats := connstats.Tee(all...)
+	e.tundev.SetStatistics(stats)
+	e.magicConn.SetStatistics(stats)
+}
+
+func (e *userspaceEngine) SubscribeConnStats(opts connstats.SubscribeOptions, fn func(connstats.Snapshot)) (unsubscribe func()) {
+	stats := connstats.NewSubscriber(opts, fn)
+
+	e.connStatsMu.Lock()
+	if e.connStatsSubs == nil {
+		e.connStatsSubs = make(set.HandleSet[*connstats.Statistics])
+	}
+	h := e.connStatsSubs.Add(stats)
+	e.installConnStatsLocked()
+	e.connStatsMu.Unlock()
+
+	return func() {
+		e.connStatsMu.Lock()
+		_, ok := e.connStatsSubs[h]
+		delete(e.connStatsSubs, h)
+		if ok {
+			e.installConnStatsLocked()
+		}
+		e.connStatsMu.Unlock()
+		if ok {
+			stats.Shutdown(context.Background())
+		}
+	}
+}
@@ -6,6 +6,7 @@
 
 import (
 	"fmt"
+	"math/rand"
 	"net/netip"
 	"slices"
 	"sync"
@@ -49,6 +50,12 @@ type Filter struct {
 	// capability grants, partitioned by source IP address family.
 	cap4, cap6 matches
 
+	// srcs4, srcs6, capSrcs4 and capSrcs6 index the sources of
+	// matches4, matches6, cap4 and cap6 respectively, for rules with
+	// many of them.
+	srcs4, srcs6       srcIndex
+	capSrcs4, capSrcs6 srcIndex
+
 	// state is the connection tracking state attached to this
 	// filter. It is used to allow incoming traffic that is a response
 	// to an outbound connection that this node made, even if those
@@ -191,6 +198,14 @@ func New(matches []Match, localNets *netipx.IPSet, logIPs *netipx.IPSet, shareSt
 		logIPs:   logIPs,
 		state:    state,
 	}
+	prev := shareStateWith
+	if prev == nil {
+		prev = new(Filter)
+	}
+	f.srcs4 = newSrcIndex(f.matches4, prev.matches4, prev.srcs4)
+	f.srcs6 = newSrcIndex(f.matches6, prev.matches6, prev.srcs6)
+	f.capSrcs4 = newSrcIndex(f.cap4, prev.cap4, prev.capSrcs4)
+	f.capSrcs6 = newSrcIndex(f.cap6, prev.cap6, prev.capSrcs6)
 	return f
 }
 
@@ -329,15 +344,16 @@ func (f *Filter) CheckTCP(srcIP, dstIP netip.Addr, dstPort uint16) Response {
 // to dstIP.
 func (f *Filter) CapsWithValues(srcIP, dstIP netip.Addr) tailcfg.PeerCapMap {
 	var mm matches
+	var srcs srcIndex
 	switch {
 	case srcIP.Is4():
-		mm = f.cap4
+		mm, srcs = f.cap4, f.capSrcs4
 	case srcIP.Is6():
-		mm = f.cap6
+		mm, srcs = f.cap6, f.capSrcs6
 	}
 	var out tailcfg.PeerCapMap
-	for _, m := range mm {
-		if !ipInList(srcIP, m.Srcs) {
+	for i, m := range mm {
+		if !srcs.contains(i, m.Srcs, srcIP) {
 			continue
 		}
 		for _, cm := range m.Caps {
@@ -358,6 +374,61 @@ func (f *Filter) CapsWithValues(srcIP, dstIP netip.Addr) tailcfg.PeerCapMap {
 // incoming) filter.
 func (f *Filter) ShieldsUp() bool { return f.shieldsUp }
 
+// RuleStats reports the number of rules in f, summed across address
+// families, the total number of source prefixes in them, and how many of
+// those rules have enough sources to be looked up in an ART table.
+func (f *Filter) RuleStats() (rules, srcPrefixes, indexedRules int) {
+	for _, ms := range []matches{f.matches4, f.matches6} {
+		rules += len(ms)
+		for _, m := range ms {
+			srcPrefixes += len(m.Srcs)
+		}
+	}
+	for _, idx := range []srcIndex{f.srcs4, f.srcs6} {
+		for _, t := range idx {
+			if t != nil {
+				indexedRules++
+			}
+		}
+	}
+	return rules, srcPrefixes, indexedRules
+}
+
+// LookupLatencies times n rule lookups in f, of the kind RunIn does for
+// new connections, for debugging how f performs with large rule sets.
+// Each lookup is of a source and destination taken from a randomly chosen
+// rule. It returns how long each lookup took, sorted in increasing order,
+// or nil if f has no rules.
+func (f *Filter) LookupLatencies(n int) []time.Duration {
+	if len(f.matches4)+len(f.matches6) == 0 {
+		return nil
+	}
+	ret := make([]time.Duration, 0, n)
+	var q packet.Parsed
+	for i := 0; i < n; i++ {
+		ms, srcs := f.matches4, f.srcs4
+		q.IPVersion = 4
+		if j := rand.Intn(len(f.matches4) + len(f.matches6)); j >= len(f.matches4) {
+			ms, srcs = f.matches6, f.srcs6
+			q.IPVersion = 6
+		}
+		m := ms[rand.Intn(len(ms))]
+		dst := m.Dsts[rand.Intn(len(m.Dsts))]
+		q.IPProto = ipproto.TCP
+		if len(m.IPProto) > 0 {
+			q.IPProto = m.IPProto[rand.Intn(len(m.IPProto))]
+		}
+		q.Src = netip.AddrPortFrom(m.Srcs[rand.Intn(len(m.Srcs))].Addr(), 0)
+		q.Dst = netip.AddrPortFrom(dst.Net.Addr(), dst.Ports.First)
+
+		start := time.Now()
+		ms.match(&q, srcs)
+		ret = append(ret, time.Since(start))
+	}
+	slices.Sort(ret)
+	return ret
+}
+
 // RunIn determines whether this node is allowed to receive q from a
 // Tailscale peer.
 func (f *Filter) RunIn(q *packet.Parsed, rf RunFlags) Response {
@@ -423,7 +494,7 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches4.matchIPsOnly(q) {
+		} else if f.matches4.matchIPsOnly(q, f.srcs4) {
 			// If any port is open to an IP, allow ICMP to it.
 			return Accept, "icmp ok"
 		}
@@ -439,7 +510,7 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches4.match(q) {
+		if f.matches4.match(q, f.srcs4) {
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -452,13 +523,13 @@ func (f *Filter) runIn4(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches4.match(q) {
+		if f.matches4.match(q, f.srcs4) {
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if f.matches4.matchProtoAndIPsOnlyIfAllPorts(q, f.srcs4) {
 			return Accept, "other-portless ok"
 		}
 		return Drop, unknownProtoString(q.IPProto)
@@ -483,7 +554,7 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 			//  related to an existing ICMP-Echo, TCP, or UDP
 			//  session.
 			return Accept, "icmp response ok"
-		} else if f.matches6.matchIPsOnly(q) {
+		} else if f.matches6.matchIPsOnly(q, f.srcs6) {
 			// If any port is open to an IP, allow ICMP to it.
 			return Accept, "icmp ok"
 		}
@@ -499,7 +570,7 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if q.IPProto == ipproto.TCP && !q.IsTCPSyn() {
 			return Accept, "tcp non-syn"
 		}
-		if f.matches6.match(q) {
+		if f.matches6.match(q, f.srcs6) {
 			return Accept, "tcp ok"
 		}
 	case ipproto.UDP, ipproto.SCTP:
@@ -512,13 +583,13 @@ func (f *Filter) runIn6(q *packet.Parsed) (r Response, why string) {
 		if ok {
 			return Accept, "cached"
 		}
-		if f.matches6.match(q) {
+		if f.matches6.match(q, f.srcs6) {
 			return Accept, "ok"
 		}
 	case ipproto.TSMP:
 		return Accept, "tsmp ok"
 	default:
-		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q) {
+		if f.matches6.matchProtoAndIPsOnlyIfAllPorts(q, f.srcs6) {
 			return Accept, "other-portless ok"
 		}
 		return Drop, unknownProtoString(q.IPProto)
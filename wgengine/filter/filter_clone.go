@@ -28,15 +28,17 @@ func (src *Match) Clone() *Match {
 	for i := range dst.Caps {
 		dst.Caps[i] = *src.Caps[i].Clone()
 	}
+	dst.Schedule = src.Schedule.Clone()
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _MatchCloneNeedsRegeneration = Match(struct {
-	IPProto []ipproto.Proto
-	Srcs    []netip.Prefix
-	Dsts    []NetPortRange
-	Caps    []CapMatch
+	IPProto  []ipproto.Proto
+	Srcs     []netip.Prefix
+	Dsts     []NetPortRange
+	Caps     []CapMatch
+	Schedule *Schedule
 }{})
 
 // Clone makes a deep copy of CapMatch.
@@ -11,6 +11,7 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"go4.org/netipx"
@@ -327,6 +328,93 @@ func BenchmarkFilter(b *testing.B) {
 	}
 }
 
+// largeSrcsMatches returns rules allowing SSH to 1.2.3.4 from n /24s
+// within 10.0.0.0/8, such as an app connector or VPC router might have.
+func largeSrcsMatches(n int) []Match {
+	srcs := make([]netip.Prefix, n)
+	for i := range srcs {
+		srcs[i] = netip.PrefixFrom(netip.AddrFrom4([4]byte{10, byte(i >> 8), byte(i), 0}), 24)
+	}
+	return []Match{m(srcs, netports("1.2.3.4:22"))}
+}
+
+func ipSet(strs ...string) *netipx.IPSet {
+	var b netipx.IPSetBuilder
+	for _, n := range nets(strs...) {
+		b.AddPrefix(n)
+	}
+	s, _ := b.IPSet()
+	return s
+}
+
+func TestLargeSrcs(t *testing.T) {
+	local := ipSet("1.2.3.4")
+	f := New(largeSrcsMatches(1000), local, local, nil, t.Logf)
+	if rules, srcs, indexed := f.RuleStats(); rules != 1 || srcs != 1000 || indexed != 1 {
+		t.Fatalf("RuleStats = %v, %v, %v; want 1, 1000, 1", rules, srcs, indexed)
+	}
+
+	tests := []struct {
+		src  string
+		want Response
+	}{
+		{"10.0.0.1", Accept},
+		{"10.3.231.255", Accept}, // last of the 1000 /24s
+		{"10.3.232.1", Drop},
+		{"11.0.0.1", Drop},
+	}
+	for _, tt := range tests {
+		if got := f.CheckTCP(netip.MustParseAddr(tt.src), netip.MustParseAddr("1.2.3.4"), 22); got != tt.want {
+			t.Errorf("CheckTCP from %v = %v; want %v", tt.src, got, tt.want)
+		}
+	}
+
+	tcpPacket := raw4(ipproto.TCP, "10.1.2.3", "1.2.3.4", 999, 22, 0)
+	if err := tstest.MinAllocsPerRun(t, 0, func() {
+		q := &packet.Parsed{}
+		q.Decode(tcpPacket)
+		f.RunIn(q, 0)
+	}); err != nil {
+		t.Error(err)
+	}
+
+	// Updating the filter without changing the sources reuses their
+	// table rather than building a new one.
+	f2 := New(largeSrcsMatches(1000), local, local, f, t.Logf)
+	if f2.srcs4[0] != f.srcs4[0] {
+		t.Error("unchanged sources were indexed again")
+	}
+	f3 := New(largeSrcsMatches(999), local, local, f2, t.Logf)
+	if f3.srcs4[0] == f2.srcs4[0] {
+		t.Error("changed sources weren't indexed again")
+	}
+	if got := f3.CheckTCP(netip.MustParseAddr("10.3.231.1"), netip.MustParseAddr("1.2.3.4"), 22); got != Drop {
+		t.Errorf("CheckTCP from removed source = %v; want Drop", got)
+	}
+
+	lat := f.LookupLatencies(100)
+	if len(lat) != 100 || !slices.IsSorted(lat) {
+		t.Errorf("LookupLatencies returned %d durations, sorted=%v; want 100, sorted", len(lat), slices.IsSorted(lat))
+	}
+}
+
+func BenchmarkFilterLargeSrcs(b *testing.B) {
+	tcpPacket := raw4(ipproto.TCP, "10.3.231.1", "1.2.3.4", 999, 22, 0)
+	local := ipSet("1.2.3.4")
+	for _, n := range []int{10, 100, 1000, 10000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			acl := New(largeSrcsMatches(n), local, local, nil, b.Logf)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q := &packet.Parsed{}
+				q.Decode(tcpPacket)
+				acl.RunIn(q, 0)
+			}
+		})
+	}
+}
+
 func TestPreFilter(t *testing.T) {
 	packets := []struct {
 		desc string
@@ -865,7 +953,7 @@ func TestMatchesMatchProtoAndIPsOnlyIfAllPorts(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			matches := matches{tt.m}
-			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p)
+			got := matches.matchProtoAndIPsOnlyIfAllPorts(&tt.p, nil)
 			if got != tt.want {
 				t.Errorf("got = %v; want %v", got, tt.want)
 			}
@@ -954,3 +1042,100 @@ func TestCaps(t *testing.T) {
 		})
 	}
 }
+
+func TestSchedule(t *testing.T) {
+	// 2023-09-18 is a Monday.
+	at := func(day int, hhmm string) time.Time {
+		h, _ := strconv.Atoi(hhmm[:2])
+		m, _ := strconv.Atoi(hhmm[3:])
+		return time.Date(2023, 9, 18+day, h, m, 0, 0, time.UTC)
+	}
+	ms, err := MatchesFromFilterRules([]tailcfg.FilterRule{
+		{
+			SrcIPs: []string{"100.64.1.1"},
+			Schedule: &tailcfg.FilterSchedule{Windows: []tailcfg.TimeWindow{
+				{Days: []time.Weekday{time.Monday, time.Tuesday}, Start: "09:00", End: "17:00"},
+				{Days: []time.Weekday{time.Friday}, Start: "22:00", End: "02:00"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := ms[0].Schedule
+	tests := []struct {
+		t      time.Time
+		active bool
+		next   time.Time
+	}{
+		{at(0, "08:59"), false, at(0, "09:00")},
+		{at(0, "09:00"), true, at(0, "17:00")},
+		{at(0, "16:59"), true, at(0, "17:00")},
+		{at(0, "17:00"), false, at(1, "09:00")},
+		{at(1, "18:00"), false, at(4, "22:00")},
+		{at(4, "23:00"), true, at(5, "02:00")},
+		{at(5, "01:59"), true, at(5, "02:00")},
+		{at(5, "02:00"), false, at(7, "09:00")},
+	}
+	for _, tt := range tests {
+		if got := s.Active(tt.t); got != tt.active {
+			t.Errorf("Active(%v) = %v; want %v", tt.t, got, tt.active)
+		}
+		if got := s.NextChange(tt.t); !got.Equal(tt.next) {
+			t.Errorf("NextChange(%v) = %v; want %v", tt.t, got, tt.next)
+		}
+	}
+
+	for _, w := range []tailcfg.TimeWindow{
+		{Start: "9:00", End: "17:00"},
+		{Start: "09:00", End: "24:01"},
+		{Start: "24:00", End: "01:00"},
+		{Days: []time.Weekday{7}, Start: "09:00", End: "17:00"},
+	} {
+		ms, err := MatchesFromFilterRules([]tailcfg.FilterRule{{
+			SrcIPs:   []string{"100.64.1.1"},
+			Schedule: &tailcfg.FilterSchedule{Windows: []tailcfg.TimeWindow{w}},
+		}})
+		if err == nil || len(ms) != 0 {
+			t.Errorf("window %+v: got %d matches, err %v; want rule dropped with error", w, len(ms), err)
+		}
+	}
+}
+
+func TestActiveMatches(t *testing.T) {
+	ms, err := MatchesFromFilterRules([]tailcfg.FilterRule{
+		{SrcIPs: []string{"100.64.1.1"}},
+		{
+			SrcIPs: []string{"100.64.1.2"},
+			Schedule: &tailcfg.FilterSchedule{
+				TimeZone: "America/New_York",
+				Windows:  []tailcfg.TimeWindow{{Start: "09:00", End: "17:00"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, st := ActiveMatches(ms[:1], time.Now()); &got[0] != &ms[0] || st != (ScheduleStatus{}) {
+		t.Errorf("unscheduled matches: got %v, %+v; want the same matches, no schedule", got, st)
+	}
+
+	// 14:00 UTC is 10:00 in New York, in September.
+	now := time.Date(2023, 9, 18, 14, 0, 0, 0, time.UTC)
+	got, st := ActiveMatches(ms, now)
+	if len(got) != 2 || got[1].Schedule != nil || ms[1].Schedule == nil {
+		t.Errorf("in window: got %v; want both matches, without schedules", got)
+	}
+	if want := time.Date(2023, 9, 18, 21, 0, 0, 0, time.UTC); st.Active != 1 || st.Inactive != 0 || !st.Next.Equal(want) {
+		t.Errorf("in window: status %+v; want 1 active, next at %v", st, want)
+	}
+
+	got, st = ActiveMatches(ms, now.Add(8*time.Hour))
+	if len(got) != 1 || got[0].Srcs[0] != netip.MustParsePrefix("100.64.1.1/32") {
+		t.Errorf("out of window: got %v; want only the unscheduled match", got)
+	}
+	if st.Active != 0 || st.Inactive != 1 {
+		t.Errorf("out of window: status %+v; want 1 inactive", st)
+	}
+}
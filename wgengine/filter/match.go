@@ -7,8 +7,10 @@
 	"encoding/json"
 	"fmt"
 	"net/netip"
+	"slices"
 	"strings"
 
+	"tailscale.com/net/art"
 	"tailscale.com/net/packet"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/ipproto"
@@ -70,6 +72,10 @@ type Match struct {
 	Srcs    []netip.Prefix
 	Dsts    []NetPortRange // optional, if Srcs match
 	Caps    []CapMatch     // optional, if Srcs match
+
+	// Schedule, if non-nil, limits the Match to time windows. The filter
+	// itself ignores it: see ActiveMatches.
+	Schedule *Schedule
 }
 
 func (m Match) String() string {
@@ -99,12 +105,12 @@ func (m Match) String() string {
 
 type matches []Match
 
-func (ms matches) match(q *packet.Parsed) bool {
-	for _, m := range ms {
+func (ms matches) match(q *packet.Parsed, srcs srcIndex) bool {
+	for i, m := range ms {
 		if !protoInList(q.IPProto, m.IPProto) {
 			continue
 		}
-		if !ipInList(q.Src.Addr(), m.Srcs) {
+		if !srcs.contains(i, m.Srcs, q.Src.Addr()) {
 			continue
 		}
 		for _, dst := range m.Dsts {
@@ -120,9 +126,9 @@ func (ms matches) match(q *packet.Parsed) bool {
 	return false
 }
 
-func (ms matches) matchIPsOnly(q *packet.Parsed) bool {
-	for _, m := range ms {
-		if !ipInList(q.Src.Addr(), m.Srcs) {
+func (ms matches) matchIPsOnly(q *packet.Parsed, srcs srcIndex) bool {
+	for i, m := range ms {
+		if !srcs.contains(i, m.Srcs, q.Src.Addr()) {
 			continue
 		}
 		for _, dst := range m.Dsts {
@@ -137,12 +143,12 @@ func (ms matches) matchIPsOnly(q *packet.Parsed) bool {
 // matchProtoAndIPsOnlyIfAllPorts reports q matches any Match in ms where the
 // Match if for the right IP Protocol and IP address, but ports are
 // ignored, as long as the match is for the entire uint16 port range.
-func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
-	for _, m := range ms {
+func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed, srcs srcIndex) bool {
+	for i, m := range ms {
 		if !protoInList(q.IPProto, m.IPProto) {
 			continue
 		}
-		if !ipInList(q.Src.Addr(), m.Srcs) {
+		if !srcs.contains(i, m.Srcs, q.Src.Addr()) {
 			continue
 		}
 		for _, dst := range m.Dsts {
@@ -157,6 +163,55 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 	return false
 }
 
+// largeSrcs is the number of source prefixes at which a Match's Srcs are
+// looked up in an ART table rather than scanned linearly. Nodes that import
+// lots of subnet routes, such as app connectors and cloud VPC routers, can
+// have rules with thousands of them.
+const largeSrcs = 64
+
+// srcIndex holds, for each Match in a matches, an ART table of its Srcs
+// if it has at least largeSrcs of them, and nil otherwise. A nil srcIndex
+// means all Srcs are scanned linearly.
+type srcIndex []*art.Table[struct{}]
+
+// newSrcIndex returns the srcIndex for ms. prevMS and prev are the
+// previous version of ms and its srcIndex, if any: tables are reused for
+// Matches whose Srcs are unchanged, so that filter updates don't rebuild
+// them all each time.
+func newSrcIndex(ms, prevMS matches, prev srcIndex) srcIndex {
+	var idx srcIndex
+	for i, m := range ms {
+		if len(m.Srcs) < largeSrcs {
+			continue
+		}
+		if idx == nil {
+			idx = make(srcIndex, len(ms))
+		}
+		if i < len(prev) && prev[i] != nil && slices.Equal(prevMS[i].Srcs, m.Srcs) {
+			idx[i] = prev[i]
+			continue
+		}
+		routes := make([]art.Route[struct{}], len(m.Srcs))
+		for j, src := range m.Srcs {
+			routes[j].Prefix = src
+		}
+		t := new(art.Table[struct{}])
+		t.InsertBulk(routes)
+		idx[i] = t
+	}
+	return idx
+}
+
+// contains reports whether ip is in srcs, the Srcs of the i'th Match of
+// the matches that idx indexes.
+func (idx srcIndex) contains(i int, srcs []netip.Prefix, ip netip.Addr) bool {
+	if i < len(idx) && idx[i] != nil {
+		_, ok := idx[i].Get(ip)
+		return ok
+	}
+	return ipInList(ip, srcs)
+}
+
 func ipInList(ip netip.Addr, netlist []netip.Prefix) bool {
 	for _, net := range netlist {
 		if net.Contains(ip) {
@@ -0,0 +1,198 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// Schedule is the parsed form of a tailcfg.FilterSchedule: the recurring
+// time windows in which a Match applies.
+//
+// A Schedule is immutable once parsed.
+type Schedule struct {
+	loc     *time.Location
+	windows []window
+}
+
+// Clone returns s, as Schedules are immutable and can be shared. It exists
+// for the generated Match.Clone.
+func (s *Schedule) Clone() *Schedule {
+	return s
+}
+
+// window is a parsed tailcfg.TimeWindow.
+type window struct {
+	days       uint8 // bitmask of 1<<time.Weekday; 0 means every day
+	start, end int   // minutes since midnight; end may be up to 24*60
+}
+
+// onDay reports whether w opens on day d.
+func (w window) onDay(d time.Weekday) bool {
+	return w.days == 0 || w.days&(1<<d) != 0
+}
+
+// spansMidnight reports whether w closes on the day after it opens.
+func (w window) spansMidnight() bool {
+	return w.end <= w.start
+}
+
+// parseSchedule parses fs.
+func parseSchedule(fs *tailcfg.FilterSchedule) (*Schedule, error) {
+	s := &Schedule{loc: time.UTC}
+	switch fs.TimeZone {
+	case "", "UTC":
+	case "local":
+		s.loc = time.Local
+	default:
+		loc, err := time.LoadLocation(fs.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("schedule time zone: %w", err)
+		}
+		s.loc = loc
+	}
+	for _, tw := range fs.Windows {
+		var w window
+		for _, d := range tw.Days {
+			if d < time.Sunday || d > time.Saturday {
+				return nil, fmt.Errorf("invalid schedule day %d", d)
+			}
+			w.days |= 1 << d
+		}
+		var err error
+		if w.start, err = parseTimeOfDay(tw.Start); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseTimeOfDay(tw.End); err != nil {
+			return nil, err
+		}
+		if w.start == 24*60 {
+			return nil, errors.New("schedule window can't start at 24:00")
+		}
+		s.windows = append(s.windows, w)
+	}
+	return s, nil
+}
+
+// parseTimeOfDay parses a "HH:MM" time of day from "00:00" to "24:00" into
+// minutes since midnight.
+func parseTimeOfDay(v string) (int, error) {
+	hs, ms, ok := strings.Cut(v, ":")
+	if ok && len(hs) == 2 && len(ms) == 2 {
+		h, herr := strconv.Atoi(hs)
+		m, merr := strconv.Atoi(ms)
+		if herr == nil && merr == nil && h >= 0 && m >= 0 && m < 60 && (h < 24 || h == 24 && m == 0) {
+			return h*60 + m, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid schedule time of day %q", v)
+}
+
+// at returns the time of day minutes past midnight on the day of t,
+// offset by days.
+func (s *Schedule) at(t time.Time, days, minutes int) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+days, 0, minutes, 0, 0, s.loc)
+}
+
+// Active reports whether one of s's windows is open at t.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.loc)
+	tod := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	for _, w := range s.windows {
+		if w.spansMidnight() {
+			if w.onDay(day) && tod >= w.start || w.onDay(yesterday) && tod < w.end {
+				return true
+			}
+		} else if w.onDay(day) && tod >= w.start && tod < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the first time after t at which one of s's windows
+// opens or closes, or the zero time if they never do.
+//
+// Active may report the same at NextChange as at t, when a window closes
+// as another opens.
+func (s *Schedule) NextChange(t time.Time) time.Time {
+	t = t.In(s.loc)
+	var next time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+	// Windows open at most a day before they close, so yesterday's
+	// windows may close today and next week's windows are the furthest
+	// away.
+	for days := -1; days <= 7; days++ {
+		day := s.at(t, days, 0).Weekday()
+		for _, w := range s.windows {
+			if !w.onDay(day) {
+				continue
+			}
+			consider(s.at(t, days, w.start))
+			if w.spansMidnight() {
+				consider(s.at(t, days+1, w.end))
+			} else {
+				consider(s.at(t, days, w.end))
+			}
+		}
+	}
+	return next
+}
+
+// ScheduleStatus is the state of the Schedules of a set of Matches at a
+// point in time, as returned by ActiveMatches.
+type ScheduleStatus struct {
+	Active   int       // number of Matches with a Schedule that's active
+	Inactive int       // number of Matches with a Schedule that isn't
+	Next     time.Time // when a Schedule next changes; zero if never
+}
+
+// ActiveMatches returns the Matches of ms that apply at now: those without
+// a Schedule, and those whose Schedule is active. Their Schedules are
+// cleared, as the filter doesn't evaluate them.
+//
+// If no Match of ms has a Schedule, ms itself is returned.
+func ActiveMatches(ms []Match, now time.Time) ([]Match, ScheduleStatus) {
+	var st ScheduleStatus
+	var active []Match
+	for i, m := range ms {
+		if m.Schedule == nil {
+			if active != nil {
+				active = append(active, m)
+			}
+			continue
+		}
+		if active == nil {
+			active = make([]Match, i, len(ms))
+			copy(active, ms)
+		}
+		if next := m.Schedule.NextChange(now); !next.IsZero() && (st.Next.IsZero() || next.Before(st.Next)) {
+			st.Next = next
+		}
+		if !m.Schedule.Active(now) {
+			st.Inactive++
+			continue
+		}
+		st.Active++
+		m.Schedule = nil
+		active = append(active, m)
+	}
+	if active == nil {
+		return ms, st
+	}
+	return active, st
+}
@@ -96,6 +96,19 @@ func MatchesFromFilterRules(pf []tailcfg.FilterRule) ([]Match, error) {
 			}
 		}
 
+		if r.Schedule != nil {
+			sched, err := parseSchedule(r.Schedule)
+			if err != nil {
+				// Fail closed: a rule whose schedule can't be
+				// understood never applies.
+				if erracc == nil {
+					erracc = err
+				}
+				continue
+			}
+			m.Schedule = sched
+		}
+
 		mm = append(mm, m)
 	}
 	return mm, erracc
@@ -13,6 +13,7 @@
 
 	"golang.org/x/net/ipv6"
 	"tailscale.com/net/neterror"
+	"tailscale.com/net/packet"
 	"tailscale.com/types/nettype"
 )
 
@@ -37,10 +38,12 @@ type xnetBatchWriter interface {
 type batchingUDPConn struct {
 	pc                    nettype.PacketConn
 	xpc                   xnetBatchReaderWriter
-	rxOffload             bool                                  // supports UDP GRO or similar
-	txOffload             atomic.Bool                           // supports UDP GSO or similar
-	setGSOSizeInControl   func(control *[]byte, gsoSize uint16) // typically setGSOSizeInControl(); swappable for testing
-	getGSOSizeFromControl func(control []byte) (int, error)     // typically getGSOSizeFromControl(); swappable for testing
+	rxOffload             bool                                             // supports UDP GRO or similar
+	txOffload             atomic.Bool                                      // supports UDP GSO or similar
+	setGSOSizeInControl   func(control *[]byte, gsoSize uint16)            // typically setGSOSizeInControl(); swappable for testing
+	getGSOSizeFromControl func(control []byte) (int, error)                // typically getGSOSizeFromControl(); swappable for testing
+	addDSCPToControl      func(control *[]byte, dscp packet.DSCP, v6 bool) // typically addDSCPToControl(); swappable for testing
+	dscpFailed            atomic.Bool                                      // sending with a DSCP control message failed; don't try again
 	sendBatchPool         sync.Pool
 }
 
@@ -84,17 +87,30 @@ func (c *batchingUDPConn) SetWriteDeadline(t time.Time) error {
 
 // coalesceMessages iterates msgs, coalescing them where possible while
 // maintaining datagram order. All msgs have their Addr field set to addr.
-func (c *batchingUDPConn) coalesceMessages(addr *net.UDPAddr, buffs [][]byte, msgs []ipv6.Message) int {
+// If dscps is non-nil, it holds the DSCP mark of each of buffs; datagrams
+// with different marks aren't coalesced, and each msg is sent with its mark.
+func (c *batchingUDPConn) coalesceMessages(addr *net.UDPAddr, buffs [][]byte, dscps []packet.DSCP, msgs []ipv6.Message) int {
 	var (
 		base     = -1 // index of msg we are currently coalescing into
 		gsoSize  int  // segmentation size of msgs[base]
 		dgramCnt int  // number of dgrams coalesced into msgs[base]
 		endBatch bool // tracking flag to start a new batch on next iteration of buffs
+		dscp     packet.DSCP
 	)
 	maxPayloadLen := maxIPv4PayloadLen
 	if addr.IP.To4() == nil {
 		maxPayloadLen = maxIPv6PayloadLen
 	}
+	// setControl sets the control messages of msgs[base] once it's
+	// complete. setGSOSizeInControl resets them, so it goes first.
+	setControl := func() {
+		if dgramCnt > 1 {
+			c.setGSOSizeInControl(&msgs[base].OOB, uint16(gsoSize))
+		}
+		if dscps != nil {
+			c.addDSCPToControl(&msgs[base].OOB, dscp, maxPayloadLen == maxIPv6PayloadLen)
+		}
+	}
 	for i, buff := range buffs {
 		if i > 0 {
 			msgLen := len(buff)
@@ -104,12 +120,10 @@ func (c *batchingUDPConn) coalesceMessages(addr *net.UDPAddr, buffs [][]byte, ms
 				msgLen <= gsoSize &&
 				msgLen <= freeBaseCap &&
 				dgramCnt < udpSegmentMaxDatagrams &&
+				(dscps == nil || dscps[i] == dscp) &&
 				!endBatch {
 				msgs[base].Buffers[0] = append(msgs[base].Buffers[0], make([]byte, msgLen)...)
 				copy(msgs[base].Buffers[0][baseLenBefore:], buff)
-				if i == len(buffs)-1 {
-					c.setGSOSizeInControl(&msgs[base].OOB, uint16(gsoSize))
-				}
 				dgramCnt++
 				if msgLen < gsoSize {
 					// A smaller than gsoSize packet on the tail is legal, but
@@ -119,8 +133,8 @@ func (c *batchingUDPConn) coalesceMessages(addr *net.UDPAddr, buffs [][]byte, ms
 				continue
 			}
 		}
-		if dgramCnt > 1 {
-			c.setGSOSizeInControl(&msgs[base].OOB, uint16(gsoSize))
+		if base >= 0 {
+			setControl()
 		}
 		// Reset prior to incrementing base since we are preparing to start a
 		// new potential batch.
@@ -131,13 +145,20 @@ func (c *batchingUDPConn) coalesceMessages(addr *net.UDPAddr, buffs [][]byte, ms
 		msgs[base].Buffers[0] = buff
 		msgs[base].Addr = addr
 		dgramCnt = 1
+		if dscps != nil {
+			dscp = dscps[i]
+		}
+	}
+	if base >= 0 {
+		setControl()
 	}
 	return base + 1
 }
 
 type sendBatch struct {
-	msgs []ipv6.Message
-	ua   *net.UDPAddr
+	msgs  []ipv6.Message
+	ua    *net.UDPAddr
+	dscps []packet.DSCP
 }
 
 func (c *batchingUDPConn) getSendBatch() *sendBatch {
@@ -152,7 +173,9 @@ func (c *batchingUDPConn) putSendBatch(batch *sendBatch) {
 	c.sendBatchPool.Put(batch)
 }
 
-func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) error {
+// WriteBatchTo writes buffs to addr. If dscpOf is non-nil, each datagram
+// is sent with the DSCP mark it returns, where the platform supports it.
+func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort, dscpOf func([]byte) packet.DSCP) error {
 	batch := c.getSendBatch()
 	defer c.putSendBatch(batch)
 	if addr.Addr().Is6() {
@@ -165,18 +188,29 @@ func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) erro
 		batch.ua.IP = batch.ua.IP[:4]
 	}
 	batch.ua.Port = int(addr.Port())
+	var dscps []packet.DSCP
+	if dscpOf != nil && c.addDSCPToControl != nil && !c.dscpFailed.Load() {
+		dscps = batch.dscps[:0]
+		for _, b := range buffs {
+			dscps = append(dscps, dscpOf(b))
+		}
+		batch.dscps = dscps
+	}
 	var (
 		n       int
 		retried bool
 	)
 retry:
 	if c.txOffload.Load() {
-		n = c.coalesceMessages(batch.ua, buffs, batch.msgs)
+		n = c.coalesceMessages(batch.ua, buffs, dscps, batch.msgs)
 	} else {
 		for i := range buffs {
 			batch.msgs[i].Buffers[0] = buffs[i]
 			batch.msgs[i].Addr = batch.ua
 			batch.msgs[i].OOB = batch.msgs[i].OOB[:0]
+			if dscps != nil {
+				c.addDSCPToControl(&batch.msgs[i].OOB, dscps[i], addr.Addr().Is6())
+			}
 		}
 		n = len(buffs)
 	}
@@ -187,6 +221,14 @@ func (c *batchingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) erro
 		retried = true
 		goto retry
 	}
+	if err != nil && dscps != nil {
+		// The kernel may reject the IP_TOS or IPV6_TCLASS control
+		// message; send unmarked from now on rather than not at all.
+		c.dscpFailed.Store(true)
+		metricSendUDPDSCPFailed.Add(1)
+		dscps = nil
+		goto retry
+	}
 	if retried {
 		return neterror.ErrUDPGSODisabled{OnLaddr: c.pc.LocalAddr().String(), RetryErr: err}
 	}
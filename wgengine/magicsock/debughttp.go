@@ -69,6 +69,27 @@ type D struct {
 	}
 	fmt.Fprintf(w, "</ul>\n")
 
+	fmt.Fprintf(w, "<h2 id=netcheck><a href=#netcheck>#</a> netcheck schedule</h2><ul>")
+	{
+		sched := c.netChecker.FullReportSchedule()
+		fmt.Fprintf(w, "<li>full report interval: %v</li>\n", sched.Interval)
+		if !sched.LastFull.IsZero() {
+			fmt.Fprintf(w, "<li>last full report: %v ago</li>\n", now.Sub(sched.LastFull).Round(time.Second))
+		}
+		if sched.NextFull.IsZero() {
+			fmt.Fprintf(w, "<li>next full report: next netcheck</li>\n")
+		} else {
+			fmt.Fprintf(w, "<li>next full report: in %v</li>\n", sched.NextFull.Sub(now).Round(time.Second))
+		}
+		fmt.Fprintf(w, "<li>scheduled while idle: %v</li>\n", c.fullNetcheckTimer != nil)
+		for i := len(sched.Decisions) - 1; i >= 0; i-- {
+			d := sched.Decisions[i]
+			fmt.Fprintf(w, "<li>%v ago: %s, interval now %v</li>\n",
+				now.Sub(d.At).Round(time.Second), html.EscapeString(d.Reason), d.Interval)
+		}
+	}
+	fmt.Fprintf(w, "</ul>\n")
+
 	fmt.Fprintf(w, "<h2 id=ipport><a href=#ipport>#</a> ip:port to endpoint</h2><ul>")
 	{
 		type kv struct {
@@ -49,6 +49,17 @@
 	debugRingBufferMaxSizeBytes = envknob.RegisterInt("TS_DEBUG_MAGICSOCK_RING_BUFFER_MAX_SIZE_BYTES")
 	// debugPMTUD enables path MTU discovery. Currently only sets the Don't Fragment sockopt.
 	debugPMTUD = envknob.RegisterBool("TS_DEBUG_ENABLE_PMTUD")
+	// debugDisableDERPPoll disables falling back to DERP over HTTPS
+	// long-polling when UDP is blocked and DERP connections fail.
+	debugDisableDERPPoll = envknob.RegisterBool("TS_DEBUG_DISABLE_DERP_POLL")
+	// debugDisableFastMigration disables immediately re-pinging active
+	// peers when the local address changes, leaving it to heartbeats and
+	// full discovery to find a direct path again.
+	debugDisableFastMigration = envknob.RegisterBool("TS_DEBUG_DISABLE_FAST_MIGRATION")
+	// debugDisableSilentPeerDetection disables revalidating the paths to
+	// peers that stop replying mid-flow, leaving it to the expiry of their
+	// trusted UDP address.
+	debugDisableSilentPeerDetection = envknob.RegisterBool("TS_DEBUG_DISABLE_SILENT_PEER_DETECTION")
 	// Hey you! Adding a new debugknob? Make sure to stub it out in the debugknob_stubs.go
 	// file too.
 )
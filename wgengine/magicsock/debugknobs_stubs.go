@@ -11,18 +11,21 @@
 //
 // They're inlinable and the linker can deadcode that's guarded by them to make
 // smaller binaries.
-func debugBindSocket() bool            { return false }
-func debugDisco() bool                 { return false }
-func debugOmitLocalAddresses() bool    { return false }
-func logDerpVerbose() bool             { return false }
-func debugReSTUNStopOnIdle() bool      { return false }
-func debugAlwaysDERP() bool            { return false }
-func debugUseDERPHTTP() bool           { return false }
-func debugEnableSilentDisco() bool     { return false }
-func debugSendCallMeUnknownPeer() bool { return false }
-func debugPMTUD() bool                 { return false }
-func debugUseDERPAddr() string         { return "" }
-func debugUseDerpRouteEnv() string     { return "" }
-func debugUseDerpRoute() opt.Bool      { return "" }
-func debugRingBufferMaxSizeBytes() int { return 0 }
-func inTest() bool                     { return false }
+func debugBindSocket() bool                 { return false }
+func debugDisco() bool                      { return false }
+func debugOmitLocalAddresses() bool         { return false }
+func logDerpVerbose() bool                  { return false }
+func debugReSTUNStopOnIdle() bool           { return false }
+func debugAlwaysDERP() bool                 { return false }
+func debugUseDERPHTTP() bool                { return false }
+func debugEnableSilentDisco() bool          { return false }
+func debugSendCallMeUnknownPeer() bool      { return false }
+func debugPMTUD() bool                      { return false }
+func debugDisableDERPPoll() bool            { return false }
+func debugDisableFastMigration() bool       { return false }
+func debugDisableSilentPeerDetection() bool { return false }
+func debugUseDERPAddr() string              { return "" }
+func debugUseDerpRouteEnv() string          { return "" }
+func debugUseDerpRoute() opt.Bool           { return "" }
+func debugRingBufferMaxSizeBytes() int      { return 0 }
+func inTest() bool                          { return false }
@@ -78,6 +78,9 @@ type activeDerp struct {
 	c       *derphttp.Client
 	cancel  context.CancelFunc
 	writeCh chan<- derpWriteRequest
+	// prioWriteCh is like writeCh, for packets that are sent ahead of
+	// those queued in writeCh when QoS is enabled (see Conn.SetQoS).
+	prioWriteCh chan<- derpWriteRequest
 	// lastWrite is the time of the last request for its write
 	// channel (currently even if there was no write).
 	// It is always non-nil and initialized to a non-zero Time.
@@ -191,7 +194,7 @@ func (c *Conn) goDerpConnect(node int) {
 	if node == 0 {
 		return
 	}
-	go c.derpWriteChanOfAddr(netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(node)), key.NodePublic{})
+	go c.derpWriteChanOfAddr(netip.AddrPortFrom(tailcfg.DerpMagicIPAddr, uint16(node)), key.NodePublic{}, false)
 }
 
 var (
@@ -253,8 +256,9 @@ func bufferedDerpWritesBeforeDrop() int {
 // addresses, it returns nil.
 //
 // If peer is non-zero, it can be used to find an active reverse
-// path, without using addr.
-func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) chan<- derpWriteRequest {
+// path, without using addr. If prio is true, it returns the channel of
+// packets sent ahead of the others.
+func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic, prio bool) chan<- derpWriteRequest {
 	if addr.Addr() != tailcfg.DerpMagicIPAddr {
 		return nil
 	}
@@ -285,7 +289,7 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	if ok {
 		*ad.lastWrite = time.Now()
 		c.setPeerLastDerpLocked(peer, regionID, regionID)
-		return ad.writeCh
+		return ad.writeChan(prio)
 	}
 
 	// If we don't have an open connection to the peer's home DERP
@@ -299,7 +303,7 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 			if ad, ok := c.activeDerp[r.derpID]; ok && ad.c == r.dc {
 				c.setPeerLastDerpLocked(peer, r.derpID, regionID)
 				*ad.lastWrite = time.Now()
-				return ad.writeCh
+				return ad.writeChan(prio)
 			}
 		}
 	}
@@ -341,12 +345,15 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	dc.NotePreferred(c.myDerp == regionID)
 	dc.SetAddressFamilySelector(derpAddrFamSelector{c})
 	dc.DNSCache = dnscache.Get()
+	dc.SetPollFallbackFunc(c.derpPollFallbackOK)
 
 	ctx, cancel := context.WithCancel(c.connCtx)
 	ch := make(chan derpWriteRequest, bufferedDerpWritesBeforeDrop())
+	prioCh := make(chan derpWriteRequest, bufferedDerpWritesBeforeDrop())
 
 	ad.c = dc
 	ad.writeCh = ch
+	ad.prioWriteCh = prioCh
 	ad.cancel = cancel
 	ad.lastWrite = new(time.Time)
 	*ad.lastWrite = time.Now()
@@ -379,9 +386,17 @@ func (c *Conn) derpWriteChanOfAddr(addr netip.AddrPort, peer key.NodePublic) cha
 	}
 
 	go c.runDerpReader(ctx, addr, dc, wg, startGate)
-	go c.runDerpWriter(ctx, dc, ch, wg, startGate)
+	go c.runDerpWriter(ctx, dc, ch, prioCh, wg, startGate)
 	go c.derpActiveFunc()
 
+	return ad.writeChan(prio)
+}
+
+// writeChan returns ad's prioWriteCh if prio, else its writeCh.
+func (ad activeDerp) writeChan(prio bool) chan<- derpWriteRequest {
+	if prio {
+		return ad.prioWriteCh
+	}
 	return ad.writeCh
 }
 
@@ -441,6 +456,19 @@ type derpReadResult struct {
 
 // runDerpReader runs in a goroutine for the life of a DERP
 // connection, handling received packets.
+// derpPollFallbackOK reports whether DERP connections may fall back to
+// HTTPS long-polling, which is only worth it when the latest netcheck found
+// UDP blocked too.
+//
+// It must not acquire c.mu; see derphttp.Client.SetPollFallbackFunc.
+func (c *Conn) derpPollFallbackOK() bool {
+	if debugDisableDERPPoll() {
+		return false
+	}
+	report := c.lastNetCheckReport.Load()
+	return report != nil && !report.UDP
+}
+
 func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, dc *derphttp.Client, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
 	defer wg.Decr()
 	defer dc.Close()
@@ -467,7 +495,7 @@ func (c *Conn) runDerpReader(ctx context.Context, derpFakeAddr netip.AddrPort, d
 	// peerPresent is the set of senders we know are present on this
 	// connection, based on messages we've received from the server.
 	peerPresent := map[key.NodePublic]bool{}
-	bo := backoff.NewBackoff(fmt.Sprintf("derp-%d", regionID), c.logf, 5*time.Second)
+	bo := backoff.NewComponentBackoff(backoff.DERP, fmt.Sprintf("derp-%d", regionID), c.logf, 5*time.Second)
 	var lastPacketTime time.Time
 	var lastPacketSrc key.NodePublic
 
@@ -590,8 +618,9 @@ type derpWriteRequest struct {
 }
 
 // runDerpWriter runs in a goroutine for the life of a DERP
-// connection, handling received packets.
-func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan derpWriteRequest, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
+// connection, sending the packets written to ch and prioCh. Packets in
+// prioCh are sent first.
+func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch, prioCh <-chan derpWriteRequest, wg *syncs.WaitGroupChan, startGate <-chan struct{}) {
 	defer wg.Decr()
 	select {
 	case <-startGate:
@@ -600,18 +629,26 @@ func (c *Conn) runDerpWriter(ctx context.Context, dc *derphttp.Client, ch <-chan
 	}
 
 	for {
+		var wr derpWriteRequest
 		select {
 		case <-ctx.Done():
 			return
-		case wr := <-ch:
-			err := dc.Send(wr.pubKey, wr.b)
-			if err != nil {
-				c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
-				metricSendDERPError.Add(1)
-			} else {
-				metricSendDERP.Add(1)
+		case wr = <-prioCh:
+		default:
+			select {
+			case <-ctx.Done():
+				return
+			case wr = <-prioCh:
+			case wr = <-ch:
 			}
 		}
+		err := dc.Send(wr.pubKey, wr.b)
+		if err != nil {
+			c.logf("magicsock: derp.Send(%v): %v", wr.addr, err)
+			metricSendDERPError.Add(1)
+		} else {
+			metricSendDERP.Add(1)
+		}
 	}
 }
 
@@ -758,6 +795,19 @@ func (c *Conn) DebugBreakDERPConns() error {
 	return nil
 }
 
+// ReconnectDERPs closes all DERP connections and reconnects to the home
+// DERP region, so that new connections pick up changes such as a new
+// outbound proxy configuration. The why string is for logging only.
+func (c *Conn) ReconnectDERPs(why string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.activeDerp) == 0 {
+		return
+	}
+	c.closeAllDerpLocked(why)
+	c.startDerpHomeConnectLocked()
+}
+
 // maybeCloseDERPsOnRebind, in response to a rebind, closes all
 // DERP connections that don't have a local address in okayLocalIPs
 // and pings all those that do.
@@ -15,6 +15,7 @@
 	"net/netip"
 	"reflect"
 	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -56,6 +57,10 @@ type endpoint struct {
 
 	disco atomic.Pointer[endpointDisco] // if the peer supports disco, the key and short string
 
+	// lastNode is the node ep was last updated from by
+	// Conn.SetNetworkMap. It's guarded by Conn.mu.
+	lastNode tailcfg.NodeView
+
 	// mu protects all following fields.
 	mu sync.Mutex // Lock ordering: Conn.mu, then endpoint.mu
 
@@ -221,8 +226,9 @@ func (de *endpoint) initFakeUDPAddr() {
 	de.fakeWGAddr = netip.AddrPortFrom(netip.AddrFrom16(addr).Unmap(), 12345)
 }
 
-// noteRecvActivity records receive activity on de, and invokes
-// Conn.noteRecvActivity no more than once every 10s.
+// noteRecvActivity records receive activity on de, no more than once every
+// recvActivityInterval, and invokes Conn.noteRecvActivity no more than once
+// every 10s.
 func (de *endpoint) noteRecvActivity(ipp netip.AddrPort) {
 	now := mono.Now()
 
@@ -236,11 +242,13 @@ func (de *endpoint) noteRecvActivity(ipp netip.AddrPort) {
 		de.mu.Unlock()
 	}
 
-	elapsed := now.Sub(de.lastRecv.LoadAtomic())
-	if elapsed > 10*time.Second {
+	last := de.lastRecv.LoadAtomic()
+	if now.Sub(last) > recvActivityInterval {
 		de.lastRecv.StoreAtomic(now)
 
-		if de.c.noteRecvActivity == nil {
+		// lastRecv moving into a new 10s window is as good as 10s passing.
+		const noteEvery = int64(10 * time.Second)
+		if de.c.noteRecvActivity == nil || int64(now)/noteEvery == int64(last)/noteEvery {
 			return
 		}
 		de.c.noteRecvActivity(de.publicKey)
@@ -413,6 +421,50 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 	return false
 }
 
+// silentLocked reports whether the peer seems to have gone silent on its
+// direct path mid-flow: we're sending to it over a trusted UDP address, but
+// for silentPeerTimeout we've received nothing from it, and the heartbeat
+// ping sent to that address since it was last confirmed is unanswered.
+// That's a couple of seconds, where WireGuard itself would take several
+// more to give up on the handshake.
+//
+// de.mu must be held.
+func (de *endpoint) silentLocked(now mono.Time) bool {
+	if de.isWireguardOnly || !de.bestAddr.IsValid() || now.After(de.trustBestAddrUntil) {
+		return false
+	}
+	if now.Sub(de.lastRecv.LoadAtomic()) < silentPeerTimeout {
+		return false
+	}
+	for _, sp := range de.sentPing {
+		if sp.to == de.bestAddr.AddrPort && sp.at.After(de.bestAddrAt) && now.Sub(sp.at) >= silentPeerTimeout {
+			return true
+		}
+	}
+	return false
+}
+
+// noteSilentLocked is called when silentLocked reports that the peer went
+// silent. It stops trusting bestAddr, so that packets go over DERP as well
+// while all of the peer's endpoints are pinged again, the peer is asked to
+// ping back, and a pong confirms a direct path. It also tells the
+// Conn's peer silent callback, if any.
+//
+// de.mu must be held.
+func (de *endpoint) noteSilentLocked() {
+	de.c.logf("magicsock: disco: node %v %v went silent on %v; revalidating paths", de.publicKey.ShortString(), de.discoShort(), de.bestAddr.AddrPort)
+	de.debugUpdates.Add(EndpointChange{
+		When: time.Now(),
+		What: "noteSilentLocked-revalidate",
+		From: de.bestAddr,
+	})
+	metricSilentPeerRevalidations.Add(1)
+	de.trustBestAddrUntil = 0
+	if fn := de.c.peerSilentFunc.Load(); fn != nil {
+		go fn(de.publicKey)
+	}
+}
+
 func (de *endpoint) noteActiveLocked() {
 	de.lastSend = mono.Now()
 	if de.heartBeatTimer == nil && !de.heartbeatDisabled {
@@ -465,6 +517,9 @@ func (de *endpoint) send(buffs [][]byte) error {
 	}
 
 	now := mono.Now()
+	if de.silentLocked(now) && !debugDisableSilentPeerDetection() {
+		de.noteSilentLocked()
+	}
 	udpAddr, derpAddr, startWGPing := de.addrForSendLocked(now)
 
 	if de.isWireguardOnly {
@@ -926,6 +981,34 @@ func (de *endpoint) noteConnectivityChange() {
 	}
 }
 
+// noteLocalAddrChange is called when our end of the paths to peers changed,
+// as when a DHCP renewal or Wi-Fi roam gives us a new address, but the
+// peer's endpoints likely still work. Like noteConnectivityChange, it
+// forgets which paths work. But if the peer had an active session over a
+// direct path, it also pings all of the peer's endpoints from our new
+// address right away and asks the peer over DERP to ping back, so a direct
+// path is validated again within a round trip instead of after the next
+// heartbeat or full discovery.
+//
+// It reports whether pings were sent.
+func (de *endpoint) noteLocalAddrChange(now mono.Time) bool {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	hadDirect := de.bestAddr.AddrPort.IsValid()
+	de.clearBestAddrLocked()
+	for k := range de.endpointState {
+		de.endpointState[k].clear()
+	}
+
+	if !hadDirect || de.expired || de.isWireguardOnly || now.Sub(de.lastSend) > sessionActiveTimeout {
+		return false
+	}
+	de.c.dlogf("[v1] magicsock: disco: local address changed, revalidating paths to %v (%v)", de.publicKey.ShortString(), de.discoShort())
+	de.sendDiscoPingsLocked(now, true)
+	return true
+}
+
 // handlePongConnLocked handles a Pong message (a reply to an earlier ping).
 // It should be called with the Conn.mu held.
 //
@@ -1043,31 +1126,8 @@ func betterAddr(a, b addrLatency) bool {
 		aPoints = int(100 - ((a.latency * 100) / b.latency))
 	}
 
-	// Prefer private IPs over public IPs as long as the latencies are
-	// roughly equivalent, since it's less likely that a user will have to
-	// pay for the bandwidth in a cloud environment.
-	//
-	// Additionally, prefer any loopback address strongly over non-loopback
-	// addresses.
-	if a.Addr().IsLoopback() {
-		aPoints += 50
-	} else if a.Addr().IsPrivate() {
-		aPoints += 20
-	}
-	if b.Addr().IsLoopback() {
-		bPoints += 50
-	} else if b.Addr().IsPrivate() {
-		bPoints += 20
-	}
-
-	// Prefer IPv6 for being a bit more robust, as long as
-	// the latencies are roughly equivalent.
-	if a.Addr().Is6() {
-		aPoints += 10
-	}
-	if b.Addr().Is6() {
-		bPoints += 10
-	}
+	aPoints += addrBonusPoints(a.Addr())
+	bPoints += addrBonusPoints(b.Addr())
 
 	// Don't change anything if the latency improvement is less than 1%; we
 	// want a bit of "stickiness" (a.k.a. hysteresis) to avoid flapping if
@@ -1084,6 +1144,80 @@ func betterAddr(a, b addrLatency) bool {
 	return aPoints > bPoints
 }
 
+// addrBonusPoints returns the points betterAddr adds to those of addr for
+// its kind of address.
+func addrBonusPoints(addr netip.Addr) (points int) {
+	// Prefer private IPs over public IPs as long as the latencies are
+	// roughly equivalent, since it's less likely that a user will have to
+	// pay for the bandwidth in a cloud environment.
+	//
+	// Additionally, prefer any loopback address strongly over non-loopback
+	// addresses.
+	if addr.IsLoopback() {
+		points += 50
+	} else if addr.IsPrivate() {
+		points += 20
+	}
+
+	// Prefer IPv6 for being a bit more robust, as long as
+	// the latencies are roughly equivalent.
+	if addr.Is6() {
+		points += 10
+	}
+	return points
+}
+
+// candidates returns the peer's endpoints and the state of path discovery
+// for each, best first, for debugging. See ipnstate.EndpointCandidate.
+func (de *endpoint) candidates() []ipnstate.EndpointCandidate {
+	de.mu.Lock()
+	defer de.mu.Unlock()
+
+	var slowest time.Duration
+	for _, st := range de.endpointState {
+		if lat, ok := st.latencyLocked(); ok {
+			slowest = max(slowest, lat)
+		}
+	}
+	ret := make([]ipnstate.EndpointCandidate, 0, len(de.endpointState))
+	for ep, st := range de.endpointState {
+		c := ipnstate.EndpointCandidate{
+			Addr:   ep,
+			Source: "netmap",
+			Pongs:  len(st.recentPongs),
+		}
+		if !st.callMeMaybeTime.IsZero() {
+			c.Source = "call-me-maybe"
+		} else if !st.lastGotPing.IsZero() {
+			c.Source = "ping"
+		}
+		if !st.lastPing.IsZero() {
+			c.LastPing = st.lastPing.WallTime()
+		}
+		if lat, ok := st.latencyLocked(); ok {
+			c.LastPong = st.recentPongs[st.recentPong].pongAt.WallTime()
+			c.Latency = lat
+			c.Score = addrBonusPoints(ep.Addr())
+			if slowest > 0 {
+				// As in betterAddr.
+				c.Score += int(100 - (lat*100)/slowest)
+			}
+		}
+		if ep == de.bestAddr.AddrPort {
+			c.Best = true
+			c.TrustedUntil = de.trustBestAddrUntil.WallTime()
+		}
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Score != ret[j].Score {
+			return ret[i].Score > ret[j].Score
+		}
+		return ipPortLess(ret[i].Addr, ret[j].Addr)
+	})
+	return ret
+}
+
 // handleCallMeMaybe handles a CallMeMaybe discovery message via
 // DERP. The contract for use of this message is that the peer has
 // already sent to us via UDP, so their stateful firewall should be
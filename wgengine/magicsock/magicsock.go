@@ -41,6 +41,7 @@
 	"tailscale.com/net/portmapper"
 	"tailscale.com/net/sockstats"
 	"tailscale.com/net/stun"
+	"tailscale.com/net/tstun"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstime"
@@ -53,6 +54,7 @@
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/memprofile"
 	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
 	"tailscale.com/util/uniq"
@@ -148,6 +150,9 @@ type Conn struct {
 	// Whether debugging logging is enabled.
 	debugLogging atomic.Bool
 
+	// qos is whether packets are prioritized by class; see SetQoS.
+	qos atomic.Bool
+
 	// havePrivateKey is whether privateKey is non-zero.
 	havePrivateKey  atomic.Bool
 	publicKeyAtomic syncs.AtomicValue[key.NodePublic] // or NodeKey zero value if !havePrivateKey
@@ -168,6 +173,10 @@ type Conn struct {
 	// captureHook, if non-nil, is the pcap logging callback when capturing.
 	captureHook syncs.AtomicValue[capture.Callback]
 
+	// peerSilentFunc, if non-nil, is called when a peer goes silent.
+	// See SetPeerSilentCallback.
+	peerSilentFunc syncs.AtomicValue[func(key.NodePublic)]
+
 	// discoPrivate is the private naclbox key used for active
 	// discovery traffic. It is always present, and immutable.
 	discoPrivate key.DiscoPrivate
@@ -200,6 +209,12 @@ type Conn struct {
 	// that will call Conn.doPeriodicSTUN.
 	periodicReSTUNTimer *time.Timer
 
+	// fullNetcheckTimer, when non-nil, is an AfterFunc timer that
+	// will call Conn.doScheduledFullNetcheck. It's only armed while
+	// periodic STUN is idle; otherwise the periodic STUNs do the
+	// netcheck's scheduled full reports as they come due.
+	fullNetcheckTimer *time.Timer
+
 	// endpointsUpdateActive indicates that updateEndpoints is
 	// currently running. It's used to deduplicate concurrent endpoint
 	// update requests.
@@ -226,6 +241,12 @@ type Conn struct {
 	// for a period of time before withdrawing them.
 	endpointTracker endpointTracker
 
+	// staticEndpoints are the manually configured public endpoints set
+	// by SetStaticEndpoints, and staticEndpointTimer, when non-nil, is
+	// the AfterFunc timer that calls probeStaticEndpoints.
+	staticEndpoints     []*staticEndpoint
+	staticEndpointTimer *time.Timer
+
 	// peerSet is the set of peers that are currently configured in
 	// WireGuard. These are not used to filter inbound or outbound
 	// traffic at all, but only to track what state can be cleaned up
@@ -468,6 +489,44 @@ func (c *Conn) stopPeriodicReSTUNTimerLocked() {
 	}
 }
 
+// doScheduledFullNetcheck is called (in a new goroutine) by
+// fullNetcheckTimer when the netcheck's next full report is due.
+func (c *Conn) doScheduledFullNetcheck() { c.ReSTUN("full-netcheck") }
+
+func (c *Conn) stopFullNetcheckTimerLocked() {
+	if t := c.fullNetcheckTimer; t != nil {
+		t.Stop()
+		c.fullNetcheckTimer = nil
+	}
+}
+
+// scheduleFullNetcheckLocked arms fullNetcheckTimer for when the
+// netcheck's next full report is due, so that the network is rechecked
+// now and then even while periodic STUN is idle. How often depends on how
+// stable the network has been; see netcheck.FullReportSchedule.
+//
+// c.mu must be held.
+func (c *Conn) scheduleFullNetcheckLocked() {
+	c.stopFullNetcheckTimerLocked()
+	if c.closed || c.periodicReSTUNTimer != nil || c.networkDown() ||
+		len(c.peerSet) == 0 || c.privateKey.IsZero() {
+		return
+	}
+	next := c.netChecker.FullReportSchedule().NextFull
+	if next.IsZero() {
+		return
+	}
+	d := max(next.Sub(time.Now()), time.Second)
+	c.fullNetcheckTimer = time.AfterFunc(d, c.doScheduledFullNetcheck)
+}
+
+// NoteLinkChange tells c that the machine's network link changed, so the
+// network is checked more often until it's stable again. If major, the
+// next netcheck is a full one.
+func (c *Conn) NoteLinkChange(major bool) {
+	c.netChecker.NoteLinkChange(major)
+}
+
 // c.mu must NOT be held.
 func (c *Conn) updateEndpoints(why string) {
 	metricUpdateEndpoints.Add(1)
@@ -504,6 +563,7 @@ func (c *Conn) updateEndpoints(why string) {
 				}
 				c.stopPeriodicReSTUNTimerLocked()
 			}
+			c.scheduleFullNetcheckLocked()
 		}
 		c.endpointsUpdateActive = false
 		c.muCond.Broadcast()
@@ -590,6 +650,12 @@ func (c *Conn) setNetInfoHavePortMap() {
 	c.callNetInfoCallbackLocked(ni)
 }
 
+// LastNetcheckReport returns the most recent netcheck report, or nil if
+// none has completed yet. It must not be modified.
+func (c *Conn) LastNetcheckReport() *netcheck.Report {
+	return c.lastNetCheckReport.Load()
+}
+
 func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	c.mu.Lock()
 	dm := c.derpMap
@@ -616,6 +682,8 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 		DERPLatency:           map[string]float64{},
 		MappingVariesByDestIP: report.MappingVariesByDestIP,
 		HairPinning:           report.HairPinning,
+		NATMapping:            report.NATMapping,
+		NATFiltering:          report.NATFiltering,
 		UPnP:                  report.UPnP,
 		PMP:                   report.PMP,
 		PCP:                   report.PCP,
@@ -681,6 +749,13 @@ func (c *Conn) addValidDiscoPathForTest(nodeKey key.NodePublic, addr netip.AddrP
 	c.peerMap.setNodeKeyForIPPort(addr, nodeKey)
 }
 
+// SetPeerSilentCallback sets fn to be called, in a new goroutine, with the
+// node key of a peer that went silent on its direct path mid-flow, when its
+// paths start being revalidated. A nil fn removes the callback.
+func (c *Conn) SetPeerSilentCallback(fn func(key.NodePublic)) {
+	c.peerSilentFunc.Store(fn)
+}
+
 func (c *Conn) SetNetInfoCallback(fn func(*tailcfg.NetInfo)) {
 	if fn == nil {
 		panic("nil NetInfoCallback")
@@ -696,7 +771,7 @@ func (c *Conn) SetNetInfoCallback(fn func(*tailcfg.NetInfo)) {
 }
 
 // LastRecvActivityOfNodeKey describes the time we last got traffic from
-// this endpoint (updated every ~10 seconds).
+// this endpoint (updated every ~second).
 func (c *Conn) LastRecvActivityOfNodeKey(nk key.NodePublic) string {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -770,6 +845,31 @@ func (c *Conn) GetEndpointChanges(peer tailcfg.NodeView) ([]EndpointChange, erro
 	return ep.debugUpdates.GetAll(), nil
 }
 
+// EndpointCandidates returns this node's endpoints and those of peer, with
+// the state of path discovery for each of the latter, for debugging.
+func (c *Conn) EndpointCandidates(peer tailcfg.NodeView) (*ipnstate.EndpointCandidates, error) {
+	c.mu.Lock()
+	if c.privateKey.IsZero() {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("tailscaled stopped")
+	}
+	ep, ok := c.peerMap.endpointForNodeKey(peer.Key())
+	ret := &ipnstate.EndpointCandidates{}
+	for _, e := range c.lastEndpoints {
+		ret.Self = append(ret.Self, ipnstate.EndpointCandidate{
+			Addr:   e.Addr,
+			Source: e.Type.String(),
+		})
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown peer")
+	}
+	ret.Peer = ep.candidates()
+	return ret, nil
+}
+
 // DiscoPublicKey returns the discovery public key.
 func (c *Conn) DiscoPublicKey() key.DiscoPublic {
 	return c.discoPublic
@@ -864,6 +964,12 @@ func (c *Conn) determineEndpoints(ctx context.Context) ([]tailcfg.Endpoint, erro
 	// re-run.
 	eps = c.endpointTracker.update(time.Now(), eps)
 
+	// Static endpoints aren't cached either, so that they're withdrawn as
+	// soon as they're found to be unreachable.
+	for _, ap := range c.reachableStaticEndpoints() {
+		addAddr(ap, tailcfg.EndpointExplicitConf)
+	}
+
 	if localAddr := c.pconn4.LocalAddr(); localAddr.IP.IsUnspecified() {
 		ips, loopback, err := interfaces.LocalAddresses()
 		if err != nil {
@@ -981,10 +1087,14 @@ func (c *Conn) sendUDPBatch(addr netip.AddrPort, buffs [][]byte) (sent bool, err
 	default:
 		panic("bogus sendUDPBatch addr type")
 	}
+	var dscpOf func([]byte) packet.DSCP
+	if c.qos.Load() {
+		dscpOf = qosDSCPOf
+	}
 	if isIPv6 {
-		err = c.pconn6.WriteBatchTo(buffs, addr)
+		err = c.pconn6.WriteBatchTo(buffs, addr, dscpOf)
 	} else {
-		err = c.pconn4.WriteBatchTo(buffs, addr)
+		err = c.pconn4.WriteBatchTo(buffs, addr, dscpOf)
 	}
 	if err != nil {
 		var errGSO neterror.ErrUDPGSODisabled
@@ -1048,7 +1158,8 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 		return c.sendUDP(addr, b)
 	}
 
-	ch := c.derpWriteChanOfAddr(addr, pubKey)
+	prio := c.qos.Load() && qosClassOf(b) == tstun.QoSInteractive
+	ch := c.derpWriteChanOfAddr(addr, pubKey, prio)
 	if ch == nil {
 		metricSendDERPErrorChan.Add(1)
 		return false, nil
@@ -1068,6 +1179,9 @@ func (c *Conn) sendAddr(addr netip.AddrPort, pubKey key.NodePublic, b []byte) (s
 		return false, errConnClosed
 	case ch <- derpWriteRequest{addr, pubKey, pkt}:
 		metricSendDERPQueued.Add(1)
+		if prio {
+			metricSendDERPPrio.Add(1)
+		}
 		return true, nil
 	default:
 		metricSendDERPErrorQueue.Add(1)
@@ -1163,7 +1277,9 @@ func (c *Conn) mkReceiveFunc(ruc *RebindingUDPConn, healthItem *health.ReceiveFu
 // caller).
 func (c *Conn) receiveIP(b []byte, ipp netip.AddrPort, cache *ippEndpointCache) (ep *endpoint, ok bool) {
 	if stun.Is(b) {
-		c.netChecker.ReceiveSTUNPacket(b, ipp)
+		if !c.handleStaticEndpointProbe(b) {
+			c.netChecker.ReceiveSTUNPacket(b, ipp)
+		}
 		return nil, false
 	}
 	if c.handleDiscoMessage(b, ipp, key.NodePublic{}, discoRXPathUDP) {
@@ -1684,6 +1800,7 @@ func (c *Conn) SetPrivateKey(privateKey key.NodePrivate) error {
 		c.logf("magicsock: SetPrivateKey called (zeroed)")
 		c.closeAllDerpLocked("zero-private-key")
 		c.stopPeriodicReSTUNTimerLocked()
+		c.stopFullNetcheckTimerLocked()
 		c.onEndpointRefreshed = nil
 	} else {
 		c.logf("magicsock: SetPrivateKey called (changed)")
@@ -1802,6 +1919,12 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 	// handle full set updates.
 	for _, n := range nm.Peers {
 		if ep, ok := c.peerMap.endpointForNodeKey(n.Key()); ok {
+			if ep.lastNode == n {
+				// Unchanged since the last netmap, which shares the
+				// views of unchanged peers. In large tailnets, most
+				// peers are unchanged by each map update.
+				continue
+			}
 			if n.DiscoKey().IsZero() && !n.IsWireGuardOnly() {
 				// Discokey transitioned from non-zero to zero? This should not
 				// happen in the wild, however it could mean:
@@ -1818,6 +1941,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 				oldDiscoKey = epDisco.key
 			}
 			ep.updateFromNode(n, heartbeatDisabled)
+			ep.lastNode = n
 			c.peerMap.upsertEndpoint(ep, oldDiscoKey) // maybe update discokey mappings in peerMap
 			continue
 		}
@@ -1877,6 +2001,7 @@ func (c *Conn) SetNetworkMap(nm *netmap.NetworkMap) {
 			}
 		}
 		ep.updateFromNode(n, heartbeatDisabled)
+		ep.lastNode = n
 		c.peerMap.upsertEndpoint(ep, key.DiscoPublic{})
 	}
 
@@ -1946,6 +2071,9 @@ func (c *connBind) BatchSize() int {
 	// TODO(raggi): determine by properties rather than hardcoding platform behavior
 	switch runtime.GOOS {
 	case "linux":
+		if n := memprofile.Current().Settings().BatchSize; n > 0 {
+			return min(n, conn.IdealBatchSize)
+		}
 		return conn.IdealBatchSize
 	default:
 		return 1
@@ -2028,6 +2156,10 @@ func (c *Conn) Close() error {
 		c.derpCleanupTimer.Stop()
 	}
 	c.stopPeriodicReSTUNTimerLocked()
+	c.stopFullNetcheckTimerLocked()
+	if c.staticEndpointTimer != nil {
+		c.staticEndpointTimer.Stop()
+	}
 	c.portMapper.Close()
 
 	c.peerMap.forEachEndpoint(func(ep *endpoint) {
@@ -2107,6 +2239,21 @@ func (c *Conn) shouldDoPeriodicReSTUNLocked() bool {
 
 func (c *Conn) onPortMapChanged() { c.ReSTUN("portmap-changed") }
 
+// PortMapLease returns the current NAT-PMP or PCP port mapping lease, if
+// any, so it can be persisted across restarts.
+func (c *Conn) PortMapLease() (portmapper.Lease, bool) {
+	return c.portMapper.Lease()
+}
+
+// RestorePortMapLease restores a port mapping lease previously returned by
+// PortMapLease and, if it's still usable, triggers an endpoint update so
+// the mapped endpoint is announced without waiting for rediscovery.
+func (c *Conn) RestorePortMapLease(l portmapper.Lease) {
+	if c.portMapper.RestoreLease(l) {
+		c.ReSTUN("portmap-restored")
+	}
+}
+
 // ReSTUN triggers an address discovery.
 // The provided why string is for debug logging only.
 func (c *Conn) ReSTUN(why string) {
@@ -2291,7 +2438,7 @@ func (c *Conn) Rebind() {
 	}
 
 	c.maybeCloseDERPsOnRebind(ifIPs)
-	c.resetEndpointStates()
+	c.migrateEndpoints()
 }
 
 // resetEndpointStates resets the preferred address for all peers.
@@ -2305,6 +2452,27 @@ func (c *Conn) resetEndpointStates() {
 	})
 }
 
+// migrateEndpoints is like resetEndpointStates, but for when only our end
+// of the paths to peers changed, as on a link change. Peers with active
+// sessions over direct paths are pinged on all their endpoints at once, so
+// interactive sessions move to the new path in about a round trip.
+func (c *Conn) migrateEndpoints() {
+	if debugDisableFastMigration() {
+		c.resetEndpointStates()
+		return
+	}
+	now := mono.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var n int64
+	c.peerMap.forEachEndpoint(func(ep *endpoint) {
+		if ep.noteLocalAddrChange(now) {
+			n++
+		}
+	})
+	metricFastMigrations.Add(n)
+}
+
 // packIPPort packs an IPPort into the form wanted by WireGuard.
 func packIPPort(ua netip.AddrPort) []byte {
 	ip := ua.Addr().Unmap()
@@ -2460,6 +2628,7 @@ func tryUpgradeToBatchingUDPConn(pconn nettype.PacketConn, network string, batch
 		pc:                    pconn,
 		getGSOSizeFromControl: getGSOSizeFromControl,
 		setGSOSizeInControl:   setGSOSizeInControl,
+		addDSCPToControl:      addDSCPToControl,
 		sendBatchPool: sync.Pool{
 			New: func() any {
 				ua := &net.UDPAddr{
@@ -2469,11 +2638,12 @@ func tryUpgradeToBatchingUDPConn(pconn nettype.PacketConn, network string, batch
 				for i := range msgs {
 					msgs[i].Buffers = make([][]byte, 1)
 					msgs[i].Addr = ua
-					msgs[i].OOB = make([]byte, controlMessageSize)
+					msgs[i].OOB = make([]byte, controlMessageSize+dscpControlMessageSize)
 				}
 				return &sendBatch{
-					ua:   ua,
-					msgs: msgs,
+					ua:    ua,
+					msgs:  msgs,
+					dscps: make([]packet.DSCP, 0, batchSize),
 				}
 			},
 		},
@@ -2569,6 +2739,16 @@ func (c *Conn) SetStatistics(stats *connstats.Statistics) {
 	// path (without using DERP) without having heard a Pong reply.
 	trustUDPAddrDuration = 6500 * time.Millisecond
 
+	// silentPeerTimeout is how long a peer we're sending to can go without
+	// replying, to either WireGuard packets or a heartbeat ping, before we
+	// stop trusting its UDP address and revalidate its paths.
+	silentPeerTimeout = 2 * time.Second
+
+	// recvActivityInterval is how often endpoint.lastRecv is updated
+	// while a peer is sending to us. It's coarse to keep the receive path
+	// cheap, but fine enough for silentPeerTimeout.
+	recvActivityInterval = time.Second
+
 	// goodEnoughLatency is the latency at or under which we don't
 	// try to upgrade to a better path.
 	goodEnoughLatency = 5 * time.Millisecond
@@ -2670,9 +2850,11 @@ type discoInfo struct {
 	metricNumPeers     = clientmetric.NewGauge("magicsock_netmap_num_peers")
 	metricNumDERPConns = clientmetric.NewGauge("magicsock_num_derp_conns")
 
-	metricRebindCalls     = clientmetric.NewCounter("magicsock_rebind_calls")
-	metricReSTUNCalls     = clientmetric.NewCounter("magicsock_restun_calls")
-	metricUpdateEndpoints = clientmetric.NewCounter("magicsock_update_endpoints")
+	metricRebindCalls             = clientmetric.NewCounter("magicsock_rebind_calls")
+	metricFastMigrations          = clientmetric.NewCounter("magicsock_fast_migrations")
+	metricSilentPeerRevalidations = clientmetric.NewCounter("magicsock_silent_peer_revalidations")
+	metricReSTUNCalls             = clientmetric.NewCounter("magicsock_restun_calls")
+	metricUpdateEndpoints         = clientmetric.NewCounter("magicsock_update_endpoints")
 
 	// Sends (data or disco)
 	metricSendDERPQueued      = clientmetric.NewCounter("magicsock_send_derp_queued")
@@ -9,6 +9,7 @@
 	"errors"
 	"io"
 
+	"tailscale.com/net/packet"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
 )
@@ -31,6 +32,9 @@ func getGSOSizeFromControl(control []byte) (int, error) {
 
 func setGSOSizeInControl(control *[]byte, gso uint16) {}
 
+func addDSCPToControl(control *[]byte, dscp packet.DSCP, v6 bool) {}
+
 const (
-	controlMessageSize = 0
+	controlMessageSize     = 0
+	dscpControlMessageSize = 0
 )
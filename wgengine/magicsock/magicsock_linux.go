@@ -19,6 +19,7 @@
 	"golang.org/x/sys/unix"
 	"tailscale.com/envknob"
 	"tailscale.com/net/netns"
+	"tailscale.com/net/packet"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/nettype"
@@ -397,10 +398,39 @@ func setGSOSizeInControl(control *[]byte, gsoSize uint16) {
 	*control = (*control)[:unix.CmsgSpace(2)]
 }
 
-var controlMessageSize = -1 // bomb if used for allocation before init
+// addDSCPToControl appends to control an IP_TOS (or, if v6, IPV6_TCLASS)
+// socket control message marking the datagram with dscp. If control lacks
+// the capacity, it's left as is.
+func addDSCPToControl(control *[]byte, dscp packet.DSCP, v6 bool) {
+	n := len(*control)
+	if cap(*control)-n < dscpControlMessageSize {
+		return
+	}
+	*control = (*control)[:n+dscpControlMessageSize]
+	hdr := (*unix.Cmsghdr)(unsafe.Pointer(&(*control)[n]))
+	if v6 {
+		hdr.Level = unix.IPPROTO_IPV6
+		hdr.Type = unix.IPV6_TCLASS
+	} else {
+		hdr.Level = unix.IPPROTO_IP
+		hdr.Type = unix.IP_TOS
+	}
+	hdr.SetLen(unix.CmsgLen(4))
+	tos := int32(dscp) << 2
+	// TODO(jwhited): replace with encoding/binary.NativeEndian when it's available
+	copy((*control)[n+unix.SizeofCmsghdr:], unsafe.Slice((*byte)(unsafe.Pointer(&tos)), 4))
+}
+
+var (
+	controlMessageSize     = -1 // bomb if used for allocation before init
+	dscpControlMessageSize = -1
+)
 
 func init() {
 	// controlMessageSize is set to hold a UDP_GRO or UDP_SEGMENT control
 	// message. These contain a single uint16 of data.
 	controlMessageSize = unix.CmsgSpace(2)
+	// dscpControlMessageSize is set to hold an IP_TOS or IPV6_TCLASS
+	// control message, which contains an int.
+	dscpControlMessageSize = unix.CmsgSpace(4)
 }
@@ -18,7 +18,9 @@
 	"net/http/httptest"
 	"net/netip"
 	"os"
+	"reflect"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -44,6 +46,7 @@
 	"tailscale.com/net/netaddr"
 	"tailscale.com/net/packet"
 	"tailscale.com/net/ping"
+	"tailscale.com/net/stun"
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/net/tstun"
 	"tailscale.com/tailcfg"
@@ -58,6 +61,7 @@
 	"tailscale.com/types/ptr"
 	"tailscale.com/util/cibuild"
 	"tailscale.com/util/racebuild"
+	"tailscale.com/util/ringbuffer"
 	"tailscale.com/util/set"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/wgcfg"
@@ -1744,6 +1748,55 @@ func TestBetterAddr(t *testing.T) {
 
 }
 
+func TestEndpointCandidates(t *testing.T) {
+	now := mono.Now()
+	public := netip.MustParseAddrPort("1.2.3.4:1")
+	private := netip.MustParseAddrPort("10.0.0.1:1")
+	cmm := netip.MustParseAddrPort("5.6.7.8:1")
+	de := &endpoint{
+		bestAddr:           addrLatency{AddrPort: private, latency: 10 * time.Millisecond},
+		trustBestAddrUntil: now.Add(time.Minute),
+		endpointState: map[netip.AddrPort]*endpointState{
+			public: {
+				lastPing:    now,
+				recentPongs: []pongReply{{latency: 20 * time.Millisecond, pongAt: now}},
+			},
+			private: {
+				lastPing:    now,
+				lastGotPing: time.Now(),
+				recentPongs: []pongReply{
+					{latency: 30 * time.Millisecond, pongAt: now.Add(-time.Second)},
+					{latency: 10 * time.Millisecond, pongAt: now},
+				},
+				recentPong: 1,
+			},
+			cmm: {callMeMaybeTime: time.Now()},
+		},
+	}
+	type want struct {
+		addr   netip.AddrPort
+		source string
+		pongs  int
+		score  int
+		best   bool
+	}
+	var got []want
+	for _, c := range de.candidates() {
+		got = append(got, want{c.Addr, c.Source, c.Pongs, c.Score, c.Best})
+		if c.Best && c.TrustedUntil.IsZero() {
+			t.Errorf("%v: best but TrustedUntil is zero", c.Addr)
+		}
+	}
+	wants := []want{
+		{private, "ping", 2, 70, true}, // private, and twice as fast as public
+		{public, "netmap", 1, 0, false},
+		{cmm, "call-me-maybe", 0, 0, false},
+	}
+	if !reflect.DeepEqual(got, wants) {
+		t.Errorf("got %+v; want %+v", got, wants)
+	}
+}
+
 func epStrings(eps []tailcfg.Endpoint) (ret []string) {
 	for _, ep := range eps {
 		ret = append(ret, ep.Addr.String())
@@ -2092,13 +2145,18 @@ func Test_batchingUDPConn_coalesceMessages(t *testing.T) {
 	c := &batchingUDPConn{
 		setGSOSizeInControl:   setGSOSize,
 		getGSOSizeFromControl: getGSOSize,
+		addDSCPToControl: func(control *[]byte, dscp packet.DSCP, v6 bool) {
+			*control = append(*control, byte(dscp))
+		},
 	}
 
 	cases := []struct {
 		name     string
 		buffs    [][]byte
+		dscps    []packet.DSCP
 		wantLens []int
 		wantGSO  []int
+		wantDSCP []packet.DSCP
 	}{
 		{
 			name: "one message no coalesce",
@@ -2146,6 +2204,18 @@ func Test_batchingUDPConn_coalesceMessages(t *testing.T) {
 			wantLens: []int{4, 2},
 			wantGSO:  []int{2, 0},
 		},
+		{
+			name: "three messages dscp change ends coalesce",
+			buffs: [][]byte{
+				make([]byte, 1, 3),
+				make([]byte, 1, 3),
+				make([]byte, 1, 1),
+			},
+			dscps:    []packet.DSCP{packet.DSCPDefault, packet.DSCPInteractive, packet.DSCPInteractive},
+			wantLens: []int{1, 2},
+			wantGSO:  []int{0, 1},
+			wantDSCP: []packet.DSCP{packet.DSCPDefault, packet.DSCPInteractive},
+		},
 	}
 
 	for _, tt := range cases {
@@ -2159,7 +2229,7 @@ func Test_batchingUDPConn_coalesceMessages(t *testing.T) {
 				msgs[i].Buffers = make([][]byte, 1)
 				msgs[i].OOB = make([]byte, 0, 2)
 			}
-			got := c.coalesceMessages(addr, tt.buffs, msgs)
+			got := c.coalesceMessages(addr, tt.buffs, tt.dscps, msgs)
 			if got != len(tt.wantLens) {
 				t.Fatalf("got len %d want: %d", got, len(tt.wantLens))
 			}
@@ -2178,6 +2248,12 @@ func Test_batchingUDPConn_coalesceMessages(t *testing.T) {
 				if gotGSO != tt.wantGSO[i] {
 					t.Errorf("msgs[%d] gsoSize %d != %d", i, gotGSO, tt.wantGSO[i])
 				}
+				if tt.wantDSCP != nil {
+					oob := msgs[i].OOB
+					if len(oob) == 0 || packet.DSCP(oob[len(oob)-1]) != tt.wantDSCP[i] {
+						t.Errorf("msgs[%d] OOB %v; want DSCP %d last", i, oob, tt.wantDSCP[i])
+					}
+				}
 			}
 		})
 	}
@@ -2851,3 +2927,198 @@ type endpointDetails struct {
 		})
 	}
 }
+
+func TestNoteLocalAddrChange(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c.pconn4.setConnLocked(pc.(nettype.PacketConn), "udp4", 1)
+	ipp := netip.MustParseAddrPort("127.0.0.1:9")
+	newEndpoint := func(lastSend mono.Time) *endpoint {
+		de := &endpoint{
+			c:             c,
+			publicKey:     key.NewNode().Public(),
+			lastSend:      lastSend,
+			bestAddr:      addrLatency{AddrPort: ipp, latency: time.Millisecond},
+			sentPing:      map[stun.TxID]sentPing{},
+			endpointState: map[netip.AddrPort]*endpointState{ipp: {}},
+		}
+		dk := key.NewDisco().Public()
+		de.disco.Store(&endpointDisco{key: dk, short: dk.ShortString()})
+		return de
+	}
+	now := mono.Now()
+
+	t.Run("active", func(t *testing.T) {
+		de := newEndpoint(now.Add(-time.Second))
+		if !de.noteLocalAddrChange(now) {
+			t.Fatal("no pings sent for active peer")
+		}
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		if de.bestAddr.AddrPort.IsValid() {
+			t.Error("bestAddr not cleared")
+		}
+		if de.endpointState[ipp].lastPing != now {
+			t.Error("endpoint not pinged")
+		}
+	})
+	t.Run("idle", func(t *testing.T) {
+		de := newEndpoint(now.Add(-2 * sessionActiveTimeout))
+		if de.noteLocalAddrChange(now) {
+			t.Fatal("pings sent for idle peer")
+		}
+		de.mu.Lock()
+		defer de.mu.Unlock()
+		if de.bestAddr.AddrPort.IsValid() {
+			t.Error("bestAddr not cleared")
+		}
+		if !de.endpointState[ipp].lastPing.IsZero() {
+			t.Error("idle endpoint pinged")
+		}
+	})
+}
+
+func TestHandleStaticEndpointProbe(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	good := &staticEndpoint{addr: netip.MustParseAddrPort("203.0.113.1:41641"), failures: 1}
+	bad := &staticEndpoint{addr: netip.MustParseAddrPort("203.0.113.2:41641"), failures: staticEndpointMaxFailures}
+	c.staticEndpoints = []*staticEndpoint{good, bad}
+	if got, want := c.reachableStaticEndpoints(), []netip.AddrPort{good.addr}; !slices.Equal(got, want) {
+		t.Fatalf("reachable = %v; want %v", got, want)
+	}
+
+	good.txID = stun.NewTxID()
+	good.probing = true
+	if c.handleStaticEndpointProbe(stun.Request(stun.NewTxID())) {
+		t.Error("probe with unknown txid handled")
+	}
+	if c.handleStaticEndpointProbe([]byte("not stun")) {
+		t.Error("non-STUN packet handled")
+	}
+	if !c.handleStaticEndpointProbe(stun.Request(good.txID)) {
+		t.Fatal("probe not handled")
+	}
+	if good.probing || good.failures != 0 {
+		t.Errorf("after probe: probing=%v, failures=%d; want false, 0", good.probing, good.failures)
+	}
+	if c.handleStaticEndpointProbe(stun.Request(good.txID)) {
+		t.Error("duplicate probe handled")
+	}
+}
+
+func TestSilentPeerRevalidation(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	c.pconn4.setConnLocked(pc.(nettype.PacketConn), "udp4", 1)
+	best := netip.MustParseAddrPort("127.0.0.1:9")
+	other := netip.MustParseAddrPort("127.0.0.1:10")
+	now := mono.Now()
+	newEndpoint := func(lastRecv, pingAt mono.Time) *endpoint {
+		de := &endpoint{
+			c:                  c,
+			publicKey:          key.NewNode().Public(),
+			debugUpdates:       ringbuffer.New[EndpointChange](10),
+			bestAddr:           addrLatency{AddrPort: best, latency: time.Millisecond},
+			bestAddrAt:         now.Add(-4 * time.Second),
+			trustBestAddrUntil: now.Add(time.Hour),
+			sentPing: map[stun.TxID]sentPing{
+				stun.NewTxID(): {to: best, at: pingAt, timer: time.NewTimer(time.Hour)},
+			},
+			endpointState: map[netip.AddrPort]*endpointState{
+				best:  {lastPing: pingAt},
+				other: {},
+			},
+		}
+		de.lastRecv.StoreAtomic(lastRecv)
+		dk := key.NewDisco().Public()
+		de.disco.Store(&endpointDisco{key: dk, short: dk.ShortString()})
+		return de
+	}
+
+	silentc := make(chan key.NodePublic, 1)
+	c.SetPeerSilentCallback(func(k key.NodePublic) { silentc <- k })
+
+	tests := []struct {
+		name       string
+		lastRecv   mono.Time
+		pingAt     mono.Time
+		wantSilent bool
+	}{
+		{"silent", now.Add(-3 * time.Second), now.Add(-3 * time.Second), true},
+		{"recent_recv", now.Add(-time.Second), now.Add(-3 * time.Second), false},
+		{"recent_ping", now.Add(-3 * time.Second), now.Add(-time.Second), false},
+		{"ping_before_confirmed", now.Add(-5 * time.Second), now.Add(-5 * time.Second), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			de := newEndpoint(tt.lastRecv, tt.pingAt)
+			de.mu.Lock()
+			got := de.silentLocked(now)
+			de.mu.Unlock()
+			if got != tt.wantSilent {
+				t.Fatalf("silentLocked = %v; want %v", got, tt.wantSilent)
+			}
+			if err := de.send([][]byte{[]byte("x")}); err != nil {
+				t.Fatal(err)
+			}
+			de.mu.Lock()
+			defer de.mu.Unlock()
+			if revalidated := de.trustBestAddrUntil.IsZero(); revalidated != tt.wantSilent {
+				t.Errorf("revalidated = %v; want %v", revalidated, tt.wantSilent)
+			}
+			if pinged := !de.endpointState[other].lastPing.IsZero(); pinged != tt.wantSilent {
+				t.Errorf("other endpoint pinged = %v; want %v", pinged, tt.wantSilent)
+			}
+			if tt.wantSilent {
+				select {
+				case k := <-silentc:
+					if k != de.publicKey {
+						t.Errorf("peer silent callback got %v; want %v", k.ShortString(), de.publicKey.ShortString())
+					}
+				case <-time.After(5 * time.Second):
+					t.Errorf("peer silent callback not called")
+				}
+			}
+		})
+	}
+}
+
+func TestQoSClassOf(t *testing.T) {
+	msg := func(typ uint32, n int) []byte {
+		b := make([]byte, n, 2048)
+		binary.LittleEndian.PutUint32(b, typ)
+		return b
+	}
+	data := msg(device.MessageTransportType, 200)
+	bulk := msg(device.MessageTransportType, 200)
+	bb := bulk[:cap(bulk)]
+	bb[len(bb)-2], bb[len(bb)-1] = 0xd5, byte(tstun.QoSBulk) // as tstun records it
+
+	tests := []struct {
+		name string
+		b    []byte
+		want tstun.QoSClass
+	}{
+		{"handshake", msg(device.MessageInitiationType, device.MessageInitiationSize), tstun.QoSInteractive},
+		{"keepalive", msg(device.MessageTransportType, device.MessageKeepaliveSize), tstun.QoSInteractive},
+		{"disco", append([]byte(disco.Magic), make([]byte, 60)...), tstun.QoSInteractive},
+		{"data", data, tstun.QoSDefault},
+		{"data-bulk", bulk, tstun.QoSBulk},
+	}
+	for _, tt := range tests {
+		if got := qosClassOf(tt.b); got != tt.want {
+			t.Errorf("%s: qosClassOf = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
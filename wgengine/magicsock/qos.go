@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"encoding/binary"
+
+	"github.com/tailscale/wireguard-go/device"
+	"tailscale.com/disco"
+	"tailscale.com/net/packet"
+	"tailscale.com/net/tstun"
+	"tailscale.com/util/clientmetric"
+)
+
+// SetQoS sets whether c prioritizes latency-sensitive packets. When
+// enabled, UDP packets are sent (on Linux) with the DSCP mark of their
+// tstun.QoSClass, so that routers on a constrained uplink can prioritize
+// them. Interactive packets, which include WireGuard handshakes and
+// keepalives and disco messages, also skip ahead of other packets queued
+// to be sent over DERP.
+func (c *Conn) SetQoS(enabled bool) {
+	c.qos.Store(enabled)
+}
+
+// qosClassOf returns the traffic class of the WireGuard or disco packet b
+// about to be sent. Handshakes, keepalives and disco messages are small
+// and keep connections alive, so they're interactive. The class of
+// transport data packets is the one tstun recorded for them.
+func qosClassOf(b []byte) tstun.QoSClass {
+	if disco.LooksLikeDiscoWrapper(b) {
+		return tstun.QoSInteractive
+	}
+	if len(b) < 4 {
+		return tstun.QoSDefault
+	}
+	switch binary.LittleEndian.Uint32(b) {
+	case device.MessageInitiationType, device.MessageResponseType, device.MessageCookieReplyType:
+		return tstun.QoSInteractive
+	case device.MessageTransportType:
+		if len(b) == device.MessageKeepaliveSize {
+			return tstun.QoSInteractive
+		}
+		cls, _ := tstun.QoSClassOfBuffer(b)
+		return cls
+	}
+	return tstun.QoSDefault
+}
+
+// qosDSCPOf returns the DSCP mark to send the packet b with.
+func qosDSCPOf(b []byte) packet.DSCP {
+	return qosClassOf(b).DSCP()
+}
+
+var (
+	metricSendDERPPrio      = clientmetric.NewCounter("magicsock_send_derp_prio")
+	metricSendUDPDSCPFailed = clientmetric.NewCounter("magicsock_send_udp_dscp_failed")
+)
@@ -12,6 +12,7 @@
 
 	"golang.org/x/net/ipv6"
 	"tailscale.com/net/netaddr"
+	"tailscale.com/net/packet"
 	"tailscale.com/types/nettype"
 )
 
@@ -69,8 +70,10 @@ func (c *RebindingUDPConn) ReadFromUDPAddrPort(b []byte) (int, netip.AddrPort, e
 	return c.readFromWithInitPconn(*c.pconnAtomic.Load(), b)
 }
 
-// WriteBatchTo writes buffs to addr.
-func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) error {
+// WriteBatchTo writes buffs to addr. If dscpOf is non-nil, it returns the
+// DSCP mark to send each datagram with; the marks are ignored if the
+// underlying conn doesn't support batching.
+func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort, dscpOf func([]byte) packet.DSCP) error {
 	for {
 		pconn := *c.pconnAtomic.Load()
 		b, ok := pconn.(*batchingUDPConn)
@@ -83,7 +86,7 @@ func (c *RebindingUDPConn) WriteBatchTo(buffs [][]byte, addr netip.AddrPort) err
 			}
 			return nil
 		}
-		err := b.WriteBatchTo(buffs, addr)
+		err := b.WriteBatchTo(buffs, addr, dscpOf)
 		if err != nil {
 			if pconn != c.currentConn() {
 				continue
@@ -0,0 +1,195 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/net/stun"
+)
+
+const (
+	// staticEndpointCheckInterval is how often the reachability of static
+	// endpoints is checked.
+	staticEndpointCheckInterval = 30 * time.Second
+
+	// staticEndpointProbeTimeout is how long to wait for a probe of a
+	// static endpoint to arrive back.
+	staticEndpointProbeTimeout = 3 * time.Second
+
+	// staticEndpointMaxFailures is how many probes of a static endpoint in
+	// a row may fail before it's no longer advertised.
+	staticEndpointMaxFailures = 3
+)
+
+var warnStaticEndpoints = health.NewWarnable(health.WithCode("static-endpoints-unreachable"), health.WithSeverity(health.SeverityMedium), health.WithSubsystem(health.SysMagicsock))
+
+// staticEndpoint is a manually configured public endpoint of this node, such
+// as a port forwarded to magicsock's port.
+type staticEndpoint struct {
+	addr     netip.AddrPort
+	txID     stun.TxID // of the outstanding probe, if probing
+	probing  bool
+	failures int // probes in a row that didn't arrive back
+}
+
+// reachable reports whether ep is believed reachable and so is advertised.
+func (ep *staticEndpoint) reachable() bool {
+	return ep.failures < staticEndpointMaxFailures
+}
+
+// SetStaticEndpoints sets the manually configured public endpoints of this
+// node, such as ports forwarded to magicsock's port on a router, to
+// advertise to peers along with the discovered ones.
+//
+// Each endpoint's reachability is checked periodically by sending a STUN
+// request to it from magicsock's socket and waiting for it to arrive back
+// through the NAT, which thus needs to support hairpinning. An endpoint is
+// no longer advertised after several checks in a row fail, and advertised
+// again as soon as one succeeds.
+func (c *Conn) SetStaticEndpoints(addrs []netip.AddrPort) {
+	c.mu.Lock()
+	old := c.staticEndpoints
+	if slices.EqualFunc(old, addrs, func(ep *staticEndpoint, a netip.AddrPort) bool { return ep.addr == a }) {
+		c.mu.Unlock()
+		return
+	}
+	c.staticEndpoints = nil
+	for _, a := range addrs {
+		ep := &staticEndpoint{addr: a}
+		for _, o := range old {
+			if o.addr == a {
+				ep = o
+			}
+		}
+		c.staticEndpoints = append(c.staticEndpoints, ep)
+	}
+	if c.staticEndpointTimer != nil {
+		c.staticEndpointTimer.Stop()
+		c.staticEndpointTimer = nil
+	}
+	if len(addrs) > 0 && !c.closed {
+		c.staticEndpointTimer = time.AfterFunc(0, c.probeStaticEndpoints)
+	}
+	c.updateStaticEndpointsHealthLocked()
+	c.mu.Unlock()
+	c.ReSTUN("static-endpoints-changed")
+}
+
+// reachableStaticEndpoints returns the static endpoints to advertise.
+func (c *Conn) reachableStaticEndpoints() []netip.AddrPort {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var addrs []netip.AddrPort
+	for _, ep := range c.staticEndpoints {
+		if ep.reachable() {
+			addrs = append(addrs, ep.addr)
+		}
+	}
+	return addrs
+}
+
+// probeStaticEndpoints sends a probe to each static endpoint, waits for
+// them to arrive back, and arms the timer for the next round.
+func (c *Conn) probeStaticEndpoints() {
+	type probe struct {
+		addr netip.AddrPort
+		txID stun.TxID
+	}
+	var probes []probe
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	for _, ep := range c.staticEndpoints {
+		ep.txID = stun.NewTxID()
+		ep.probing = true
+		probes = append(probes, probe{ep.addr, ep.txID})
+	}
+	c.mu.Unlock()
+
+	for _, p := range probes {
+		if _, err := c.sendUDP(p.addr, stun.Request(p.txID)); err != nil {
+			c.dlogf("[v1] magicsock: static endpoint probe of %v: %v", p.addr, err)
+		}
+	}
+	time.Sleep(staticEndpointProbeTimeout)
+
+	c.mu.Lock()
+	var changed bool
+	for _, ep := range c.staticEndpoints {
+		if !ep.probing {
+			continue
+		}
+		ep.probing = false
+		ep.failures++
+		if ep.failures == staticEndpointMaxFailures {
+			c.logf("magicsock: static endpoint %v unreachable; no longer advertising it", ep.addr)
+			changed = true
+		}
+	}
+	c.updateStaticEndpointsHealthLocked()
+	if len(c.staticEndpoints) > 0 && !c.closed && c.staticEndpointTimer != nil {
+		c.staticEndpointTimer.Reset(staticEndpointCheckInterval)
+	}
+	c.mu.Unlock()
+	if changed {
+		c.ReSTUN("static-endpoint-unreachable")
+	}
+}
+
+// handleStaticEndpointProbe reports whether pkt, a STUN packet, is a probe
+// of a static endpoint that arrived back, and if so notes the endpoint as
+// reachable.
+func (c *Conn) handleStaticEndpointProbe(pkt []byte) bool {
+	txID, err := stun.ParseBindingRequest(pkt)
+	if err != nil {
+		return false
+	}
+	c.mu.Lock()
+	var found, changed bool
+	for _, ep := range c.staticEndpoints {
+		if !ep.probing || ep.txID != txID {
+			continue
+		}
+		found = true
+		ep.probing = false
+		if !ep.reachable() {
+			c.logf("magicsock: static endpoint %v reachable again", ep.addr)
+			changed = true
+		}
+		ep.failures = 0
+	}
+	if changed {
+		c.updateStaticEndpointsHealthLocked()
+	}
+	c.mu.Unlock()
+	if changed {
+		go c.ReSTUN("static-endpoint-reachable")
+	}
+	return found
+}
+
+// updateStaticEndpointsHealthLocked sets the health warning for static
+// endpoints that aren't reachable.
+//
+// c.mu must be held.
+func (c *Conn) updateStaticEndpointsHealthLocked() {
+	var bad []netip.AddrPort
+	for _, ep := range c.staticEndpoints {
+		if !ep.reachable() {
+			bad = append(bad, ep.addr)
+		}
+	}
+	if len(bad) == 0 {
+		warnStaticEndpoints.Set(nil)
+		return
+	}
+	warnStaticEndpoints.Set(fmt.Errorf("static endpoints %v are not reachable; check their port forwarding", bad))
+}
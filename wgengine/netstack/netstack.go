@@ -48,6 +48,8 @@
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/util/cmpx"
+	"tailscale.com/util/memprofile"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/filter"
@@ -178,6 +180,9 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 	if tcpipErr != nil {
 		return nil, fmt.Errorf("could not enable TCP SACK: %v", tcpipErr)
 	}
+	if err := setTCPBufferSizes(ipstack, memprofile.Current().Settings()); err != nil {
+		return nil, err
+	}
 	linkEP := channel.New(512, tstun.DefaultMTU(), "")
 	if tcpipProblem := ipstack.CreateNIC(nicID, linkEP); tcpipProblem != nil {
 		return nil, fmt.Errorf("could not create netstack NIC: %v", tcpipProblem)
@@ -220,6 +225,37 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 	return ns, nil
 }
 
+// setTCPBufferSizes sets the initial and maximum sizes of the send and
+// receive buffers of ipstack's TCP connections per s, leaving those it
+// doesn't set at gVisor's defaults.
+func setTCPBufferSizes(ipstack *stack.Stack, s memprofile.Settings) error {
+	if s.TCPBufferSize == 0 && s.TCPMaxBufferSize == 0 {
+		return nil
+	}
+	resize := func(def, max *int) {
+		*def = cmpx.Or(s.TCPBufferSize, *def)
+		*max = cmpx.Or(s.TCPMaxBufferSize, *max)
+		*def = min(*def, *max)
+	}
+	var rcv tcpip.TCPReceiveBufferSizeRangeOption
+	if err := ipstack.TransportProtocolOption(tcp.ProtocolNumber, &rcv); err != nil {
+		return fmt.Errorf("could not get TCP receive buffer sizes: %v", err)
+	}
+	resize(&rcv.Default, &rcv.Max)
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &rcv); err != nil {
+		return fmt.Errorf("could not set TCP receive buffer sizes: %v", err)
+	}
+	var snd tcpip.TCPSendBufferSizeRangeOption
+	if err := ipstack.TransportProtocolOption(tcp.ProtocolNumber, &snd); err != nil {
+		return fmt.Errorf("could not get TCP send buffer sizes: %v", err)
+	}
+	resize(&snd.Default, &snd.Max)
+	if err := ipstack.SetTransportProtocolOption(tcp.ProtocolNumber, &snd); err != nil {
+		return fmt.Errorf("could not set TCP send buffer sizes: %v", err)
+	}
+	return nil
+}
+
 func (ns *Impl) Close() error {
 	ns.ctxCancel()
 	ns.ipstack.Close()
@@ -256,7 +292,7 @@ func (ns *Impl) Start(lb *ipnlocal.LocalBackend) error {
 	ns.e.AddNetworkMapCallback(ns.updateIPs)
 	// size = 0 means use default buffer size
 	const tcpReceiveBufferSize = 0
-	const maxInFlightConnectionAttempts = 1024
+	maxInFlightConnectionAttempts := cmpx.Or(memprofile.Current().Settings().MaxInFlightTCP, 1024)
 	tcpFwd := tcp.NewForwarder(ns.ipstack, tcpReceiveBufferSize, maxInFlightConnectionAttempts, ns.acceptTCP)
 	udpFwd := udp.NewForwarder(ns.ipstack, ns.acceptUDP)
 	ns.ipstack.SetTransportProtocolHandler(tcp.ProtocolNumber, ns.wrapProtoHandler(tcpFwd.HandlePacket))
@@ -547,7 +583,22 @@ func (ns *Impl) peerAPIPortAtomic(ip netip.Addr) *atomic.Uint32 {
 	}
 }
 
-var viaRange = tsaddr.TailscaleViaRange()
+var (
+	viaRange   = tsaddr.TailscaleViaRange()
+	nat64Range = tsaddr.NAT64Range()
+)
+
+// unmap4in6 returns the IPv4 address embedded in ip and true if ip is a
+// 4via6 or NAT64 address, which netstack forwards to as IPv4.
+func unmap4in6(ip netip.Addr) (netip.Addr, bool) {
+	switch {
+	case viaRange.Contains(ip):
+		return tsaddr.UnmapVia(ip), true
+	case nat64Range.Contains(ip):
+		return tsaddr.UnmapNAT64(ip), true
+	}
+	return ip, false
+}
 
 // shouldProcessInbound reports whether an inbound packet (a packet from a
 // WireGuard peer) should be handled by netstack.
@@ -577,7 +628,7 @@ func (ns *Impl) shouldProcessInbound(p *packet.Parsed, t *tstun.Wrapper) bool {
 			return true
 		}
 	}
-	if p.IPVersion == 6 && !isLocal && viaRange.Contains(dstIP) {
+	if p.IPVersion == 6 && !isLocal && (viaRange.Contains(dstIP) || nat64Range.Contains(dstIP)) {
 		return ns.lb != nil && ns.lb.ShouldHandleViaIP(dstIP)
 	}
 	if ns.ProcessLocalIPs && isLocal {
@@ -757,10 +808,10 @@ func (ns *Impl) shouldHandlePing(p *packet.Parsed) (_ netip.Addr, ok bool) {
 	// shouldProcessInbound returns 'true' to say that we should process
 	// all IPv6 packets with a destination address in the 'via' range, so
 	// check before we check the "ProcessSubnets" boolean below.
-	if viaRange.Contains(destIP) {
-		// The input echo request was to a 4via6 address, which we cannot
-		// simply ping as-is from this process. Translate the destination to an
-		// IPv4 address, so that our relayed ping (in userPing) is pinging the
+	if ip4, ok := unmap4in6(destIP); ok {
+		// The input echo request was to a 4via6 or NAT64 address, which we
+		// cannot simply ping as-is from this process. Translate the
+		// destination to an IPv4 address, so that our relayed ping (in userPing) is pinging the
 		// underlying destination IP.
 		//
 		// ICMPv4 and ICMPv6 are different protocols with different on-the-wire
@@ -768,7 +819,7 @@ func (ns *Impl) shouldHandlePing(p *packet.Parsed) (_ netip.Addr, ok bool) {
 		// IPv4 and expect to get a useful result. However, in this specific
 		// case things are safe because the 'userPing' function doesn't make
 		// use of the input packet.
-		return tsaddr.UnmapVia(destIP), true
+		return ip4, true
 	}
 
 	// If we get here, we don't do anything unless this netstack instance
@@ -819,9 +870,9 @@ func (ns *Impl) acceptTCP(r *tcp.ForwarderRequest) {
 
 	dstAddrPort := netip.AddrPortFrom(dialIP, reqDetails.LocalPort)
 
-	if viaRange.Contains(dialIP) {
+	if ip4, ok := unmap4in6(dialIP); ok {
 		isTailscaleIP = false
-		dialIP = tsaddr.UnmapVia(dialIP)
+		dialIP = ip4
 	}
 
 	defer func() {
@@ -1096,8 +1147,8 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, clientAddr, dstAddr netip.Addr
 		backendRemoteAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: int(port)}
 		backendListenAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: int(srcPort)}
 	} else {
-		if dstIP := dstAddr.Addr(); viaRange.Contains(dstIP) {
-			dstAddr = netip.AddrPortFrom(tsaddr.UnmapVia(dstIP), dstAddr.Port())
+		if ip4, ok := unmap4in6(dstAddr.Addr()); ok {
+			dstAddr = netip.AddrPortFrom(ip4, dstAddr.Port())
 		}
 		backendRemoteAddr = net.UDPAddrFromAddrPort(dstAddr)
 		if dstAddr.Addr().Is4() {
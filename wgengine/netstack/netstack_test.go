@@ -322,6 +322,49 @@ func TestShouldProcessInbound(t *testing.T) {
 			},
 			want: false,
 		},
+		{
+			name: "ipv6-nat64",
+			pkt: &packet.Parsed{
+				IPVersion: 6,
+				IPProto:   ipproto.TCP,
+				Src:       netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:1234"),
+				Dst:       netip.MustParseAddrPort("[64:ff9b::10.1.1.9]:5678"),
+				TCPFlags:  packet.TCPSyn,
+			},
+			afterStart: func(i *Impl) {
+				prefs := ipn.NewPrefs()
+				prefs.AdvertiseRoutes = []netip.Prefix{netip.MustParsePrefix("10.1.1.0/24")}
+				prefs.NAT64 = ipn.NAT64Prefs{Enabled: true, ViaSiteID: 7}
+				i.lb.Start(ipn.Options{
+					LegacyMigrationPrefs: prefs,
+				})
+				i.atomicIsLocalIPFunc.Store(looksLikeATailscaleSelfAddress)
+			},
+			beforeStart: func(i *Impl) {
+				i.ProcessLocalIPs = false
+				i.ProcessSubnets = false
+			},
+			want: true,
+		},
+		{
+			name: "ipv6-nat64-not-advertised",
+			pkt: &packet.Parsed{
+				IPVersion: 6,
+				IPProto:   ipproto.TCP,
+				Src:       netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:1234"),
+				Dst:       netip.MustParseAddrPort("[64:ff9b::10.1.2.9]:5678"),
+				TCPFlags:  packet.TCPSyn,
+			},
+			afterStart: func(i *Impl) {
+				prefs := ipn.NewPrefs()
+				prefs.AdvertiseRoutes = []netip.Prefix{netip.MustParsePrefix("10.1.1.0/24")}
+				prefs.NAT64 = ipn.NAT64Prefs{Enabled: true}
+				i.lb.Start(ipn.Options{
+					LegacyMigrationPrefs: prefs,
+				})
+			},
+			want: false,
+		},
 		{
 			name: "tailscale-ssh-enabled",
 			pkt: &packet.Parsed{
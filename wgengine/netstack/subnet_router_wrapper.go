@@ -26,3 +26,12 @@ func (r *subnetRouter) Set(c *router.Config) error {
 	}
 	return r.Router.Set(c)
 }
+
+// FirewallRuleset implements router.FirewallExporter by passing through to
+// the underlying Router.
+func (r *subnetRouter) FirewallRuleset(format string) (string, error) {
+	if fe, ok := r.Router.(router.FirewallExporter); ok {
+		return fe.FirewallRuleset(format)
+	}
+	return "", router.ErrNoFirewallRuleset
+}
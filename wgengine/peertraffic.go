@@ -0,0 +1,140 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+	"tailscale.com/util/set"
+)
+
+const (
+	// peerTrafficInterval is how often the peers' traffic counters are
+	// sampled, and thus the length of each bucket of PeerTraffic.
+	peerTrafficInterval = time.Minute
+
+	// peerTrafficBuckets is how many buckets of traffic history are kept
+	// per peer.
+	peerTrafficBuckets = 60
+)
+
+// peerTrafficHistory records the traffic exchanged with each peer in time
+// buckets, from periodic samples of the peers' cumulative WireGuard byte
+// counters.
+type peerTrafficHistory struct {
+	mu         sync.Mutex
+	lastSample time.Time // zero until the first sample
+	peers      map[key.NodePublic]*peerTraffic
+}
+
+type peerTraffic struct {
+	lastRx, lastTx int64                    // cumulative counters as of the last sample
+	buckets        []ipnstate.TrafficBucket // oldest first
+}
+
+// add records a sample, taken at now, of the cumulative counters of the
+// peers in sample. Peers in known but not in sample (such as those trimmed
+// from the WireGuard config while idle) get an empty bucket. Peers not in
+// known are forgotten.
+func (h *peerTrafficHistory) add(now time.Time, known []key.NodePublic, sample []ipnstate.PeerStatusLite) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	start := h.lastSample
+	h.lastSample = now
+	if h.peers == nil {
+		h.peers = make(map[key.NodePublic]*peerTraffic)
+	}
+
+	sampled := make(map[key.NodePublic]ipnstate.PeerStatusLite, len(sample))
+	for _, st := range sample {
+		sampled[st.NodeKey] = st
+	}
+	knownSet := make(set.Set[key.NodePublic], len(known))
+	for _, k := range known {
+		knownSet.Add(k)
+	}
+	for k := range h.peers {
+		if !knownSet.Contains(k) {
+			delete(h.peers, k)
+		}
+	}
+	for _, k := range known {
+		pt, ok := h.peers[k]
+		if !ok {
+			pt = new(peerTraffic)
+			h.peers[k] = pt
+		}
+		st := sampled[k] // zero if not in sample
+		rx, tx := st.RxBytes-pt.lastRx, st.TxBytes-pt.lastTx
+		if rx < 0 || tx < 0 {
+			// The peer was removed from and re-added to the WireGuard
+			// config since the last sample, restarting its counters.
+			rx, tx = st.RxBytes, st.TxBytes
+		}
+		pt.lastRx, pt.lastTx = st.RxBytes, st.TxBytes
+		if start.IsZero() || !ok {
+			// No interval to count yet; this sample is the baseline.
+			continue
+		}
+		pt.buckets = append(pt.buckets, ipnstate.TrafficBucket{
+			Start:   start,
+			RxBytes: rx,
+			TxBytes: tx,
+		})
+		if n := len(pt.buckets); n > peerTrafficBuckets {
+			pt.buckets = append(pt.buckets[:0], pt.buckets[n-peerTrafficBuckets:]...)
+		}
+	}
+}
+
+// get returns the traffic history of the known peers.
+func (h *peerTrafficHistory) get() []ipnstate.PeerTraffic {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ret := make([]ipnstate.PeerTraffic, 0, len(h.peers))
+	for k, pt := range h.peers {
+		ret = append(ret, ipnstate.PeerTraffic{
+			NodeKey: k,
+			Buckets: append([]ipnstate.TrafficBucket(nil), pt.buckets...),
+		})
+	}
+	return ret
+}
+
+// samplePeerTraffic records the traffic counters of all peers into
+// e.peerTraffic every peerTrafficInterval until e is closed.
+func (e *userspaceEngine) samplePeerTraffic() {
+	t := time.NewTicker(peerTrafficInterval)
+	defer t.Stop()
+	e.recordPeerTraffic()
+	for {
+		select {
+		case <-e.waitCh:
+			return
+		case <-t.C:
+			e.recordPeerTraffic()
+		}
+	}
+}
+
+func (e *userspaceEngine) recordPeerTraffic() {
+	e.mu.Lock()
+	peers := append([]key.NodePublic(nil), e.peerSequence...)
+	e.mu.Unlock()
+
+	sample := make([]ipnstate.PeerStatusLite, 0, len(peers))
+	for _, k := range peers {
+		if st, ok := e.getPeerStatusLite(k); ok {
+			sample = append(sample, st)
+		}
+	}
+	e.peerTraffic.add(time.Now(), peers, sample)
+}
+
+func (e *userspaceEngine) PeerTraffic() []ipnstate.PeerTraffic {
+	return e.peerTraffic.get()
+}
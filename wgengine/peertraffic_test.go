@@ -0,0 +1,66 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package wgengine
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestPeerTrafficHistory(t *testing.T) {
+	k1 := key.NewNode().Public()
+	k2 := key.NewNode().Public()
+	st := func(k key.NodePublic, rx, tx int64) ipnstate.PeerStatusLite {
+		return ipnstate.PeerStatusLite{NodeKey: k, RxBytes: rx, TxBytes: tx}
+	}
+	t0 := time.Unix(1700000000, 0)
+	at := func(n int) time.Time { return t0.Add(time.Duration(n) * peerTrafficInterval) }
+
+	var h peerTrafficHistory
+	both := []key.NodePublic{k1, k2}
+	h.add(at(0), both, []ipnstate.PeerStatusLite{st(k1, 100, 10)})
+	h.add(at(1), both, []ipnstate.PeerStatusLite{st(k1, 150, 30), st(k2, 5, 6)})
+	h.add(at(2), both, []ipnstate.PeerStatusLite{st(k1, 20, 2)}) // k1 re-added; k2 trimmed
+
+	byKey := func() map[key.NodePublic][]ipnstate.TrafficBucket {
+		m := map[key.NodePublic][]ipnstate.TrafficBucket{}
+		for _, pt := range h.get() {
+			m[pt.NodeKey] = pt.Buckets
+		}
+		return m
+	}
+	want := map[key.NodePublic][]ipnstate.TrafficBucket{
+		k1: {
+			{Start: at(0), RxBytes: 50, TxBytes: 20},
+			{Start: at(1), RxBytes: 20, TxBytes: 2},
+		},
+		k2: {
+			{Start: at(0), RxBytes: 5, TxBytes: 6},
+			{Start: at(1)},
+		},
+	}
+	if got := byKey(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	// Peers that leave the config are forgotten, and at most
+	// peerTrafficBuckets buckets are kept.
+	for i := 3; i < 3+peerTrafficBuckets+5; i++ {
+		h.add(at(i), []key.NodePublic{k1}, []ipnstate.PeerStatusLite{st(k1, 20, 2)})
+	}
+	got := byKey()
+	if _, ok := got[k2]; ok {
+		t.Error("k2 still has history after leaving the config")
+	}
+	if n := len(got[k1]); n != peerTrafficBuckets {
+		t.Errorf("k1 has %d buckets; want %d", n, peerTrafficBuckets)
+	}
+	if last := got[k1][len(got[k1])-1]; last.Start != at(3+peerTrafficBuckets+3) {
+		t.Errorf("last bucket starts at %v; want %v", last.Start, at(3+peerTrafficBuckets+3))
+	}
+}
@@ -6,6 +6,7 @@
 package router
 
 import (
+	"errors"
 	"net/netip"
 	"reflect"
 
@@ -31,6 +32,20 @@ type Router interface {
 	Close() error
 }
 
+// FirewallExporter is implemented by Routers that can export the firewall
+// rules they want installed, for the host's firewall management to install
+// instead of them. On Linux, that's when TS_DEBUG_FIREWALL_MODE=external.
+type FirewallExporter interface {
+	// FirewallRuleset returns the firewall rules in format, such as
+	// "nftables" or "iptables". It returns ErrNoFirewallRuleset if the
+	// Router installs its own rules.
+	FirewallRuleset(format string) (string, error)
+}
+
+// ErrNoFirewallRuleset is returned by FirewallExporter.FirewallRuleset when
+// the Router installs its firewall rules itself.
+var ErrNoFirewallRuleset = errors.New("firewall rules not exported; tailscaled isn't in external firewall mode (TS_DEBUG_FIREWALL_MODE=external)")
+
 // New returns a new Router for the current platform, using the
 // provided tun device.
 //
@@ -76,6 +91,7 @@ type Config struct {
 	SubnetRoutes     []netip.Prefix         // subnets being advertised to other Tailscale nodes
 	SNATSubnetRoutes bool                   // SNAT traffic to local subnets
 	NetfilterMode    preftype.NetfilterMode // how much to manage netfilter rules
+	BypassUIDs       []uint32               // local users whose traffic bypasses the exit node
 }
 
 func (a *Config) Equal(b *Config) bool {
@@ -4,12 +4,14 @@
 package router
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/netip"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -19,6 +21,7 @@
 	"github.com/tailscale/netlink"
 	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/netipx"
+	"golang.org/x/exp/maps"
 	"golang.org/x/sys/unix"
 	"golang.org/x/time/rate"
 	"tailscale.com/envknob"
@@ -142,6 +145,10 @@ func newNetfilterRunner(logf logger.Logf) (netfilterRunner, error) {
 		logf("envknob TS_DEBUG_FIREWALL_MODE=nftables set")
 		hostinfo.SetFirewallMode("nft-forced")
 		mode = linuxfw.FirewallModeNfTables
+	case externalFirewall():
+		logf("envknob TS_DEBUG_FIREWALL_MODE=external set")
+		hostinfo.SetFirewallMode("external")
+		mode = linuxfw.FirewallModeExternal
 	case envknob.String("TS_DEBUG_FIREWALL_MODE") == "auto":
 		mode = chooseFireWallMode(logf, tableDetector)
 	case envknob.String("TS_DEBUG_FIREWALL_MODE") == "iptables":
@@ -169,6 +176,9 @@ func newNetfilterRunner(logf logger.Logf) (netfilterRunner, error) {
 		if err != nil {
 			return nil, err
 		}
+	case linuxfw.FirewallModeExternal:
+		logf("computing firewall rules without installing them")
+		nfr = linuxfw.NewExportRunner(logf)
 	default:
 		return nil, fmt.Errorf("unknown firewall mode: %v", mode)
 	}
@@ -176,6 +186,13 @@ func newNetfilterRunner(logf logger.Logf) (netfilterRunner, error) {
 	return nfr, nil
 }
 
+// externalFirewall reports whether the host's firewall is managed by
+// something other than tailscaled, which only exports the rules it wants
+// installed. See linuxfw.ExportRunner.
+func externalFirewall() bool {
+	return envknob.String("TS_DEBUG_FIREWALL_MODE") == "external"
+}
+
 type linuxRouter struct {
 	closed           atomic.Bool
 	logf             func(fmt string, args ...any)
@@ -185,6 +202,7 @@ type linuxRouter struct {
 	addrs            map[netip.Prefix]bool
 	routes           map[netip.Prefix]bool
 	localRoutes      map[netip.Prefix]bool
+	bypassUIDs       []uint32
 	snatSubnetRoutes bool
 	netfilterMode    preftype.NetfilterMode
 
@@ -202,6 +220,15 @@ type linuxRouter struct {
 
 	nfr netfilterRunner
 	cmd commandRunner
+
+	// In external firewall mode, firewallHook is the program run with
+	// the exported rules, in firewallHookFormat, on its stdin whenever
+	// they change. See exportFirewallRules.
+	firewallHook       string
+	firewallHookFormat string
+	lastHookRules      string        // last rules sent to hookc
+	hookc              chan string   // rules for the hook to run with; latest only
+	hookDone           chan struct{} // closed when the hook goroutine exits
 }
 
 func newUserspaceRouter(logf logger.Logf, tunDev tun.Device, netMon *netmon.Monitor) (Router, error) {
@@ -286,6 +313,19 @@ func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, netMon *netmon
 
 	r.fixupWSLMTU()
 
+	if _, ok := nfr.(*linuxfw.ExportRunner); ok {
+		if hook := envknob.String("TS_FIREWALL_HOOK"); hook != "" {
+			r.firewallHook = hook
+			r.firewallHookFormat = envknob.String("TS_FIREWALL_HOOK_FORMAT")
+			if r.firewallHookFormat == "" {
+				r.firewallHookFormat = linuxfw.RulesetNfTables
+			}
+			r.hookc = make(chan string, 1)
+			r.hookDone = make(chan struct{})
+			go r.runFirewallHook()
+		}
+	}
+
 	return r, nil
 }
 
@@ -455,6 +495,9 @@ func (r *linuxRouter) Close() error {
 	if err := r.downInterface(); err != nil {
 		return err
 	}
+	if err := r.delBypassUIDRules(r.bypassUIDs); err != nil {
+		return err
+	}
 	if err := r.delIPRules(); err != nil {
 		return err
 	}
@@ -468,6 +511,13 @@ func (r *linuxRouter) Close() error {
 	r.addrs = nil
 	r.routes = nil
 	r.localRoutes = nil
+	r.bypassUIDs = nil
+
+	if r.hookc != nil {
+		r.exportFirewallRules()
+		close(r.hookc)
+		<-r.hookDone
+	}
 
 	return nil
 }
@@ -479,49 +529,187 @@ func (r *linuxRouter) Set(cfg *Config) error {
 		cfg = &shutdownConfig
 	}
 
-	if err := r.setNetfilterMode(cfg.NetfilterMode); err != nil {
+	prev := r.currentState()
+	if err := r.applyState(routerState{
+		netfilterMode:    cfg.NetfilterMode,
+		localRoutes:      cfg.LocalRoutes,
+		routes:           cfg.Routes,
+		addrs:            cfg.LocalAddrs,
+		bypassUIDs:       cfg.BypassUIDs,
+		snatSubnetRoutes: cfg.SNATSubnetRoutes,
+	}); err != nil {
+		// Rather than leave some of the new config applied on top of
+		// some of the old, which can break connectivity in ways that
+		// are hard to diagnose, go back to the old config. When
+		// shutting down, removing as much as possible is best. And
+		// with no old config to go back to, such as on the first Set,
+		// keeping what did apply is better than removing the node's
+		// addresses because one of its routes failed.
+		switch {
+		case cfg == &shutdownConfig:
+		case len(prev.addrs) == 0:
+			r.logf("router config partially failed, with no previous config to roll back to: %v", err)
+		default:
+			r.logf("router config failed, rolling back: %v", err)
+			if rerr := r.applyState(prev); rerr != nil {
+				err = fmt.Errorf("%w; rolling back to the previous config also failed: %v", err, rerr)
+			} else {
+				err = fmt.Errorf("%w; rolled back to the previous config", err)
+			}
+		}
+		errs = append(errs, err)
+	}
+
+	r.exportFirewallRules()
+
+	return multierr.New(errs...)
+}
+
+// routerState is the part of the state of a linuxRouter that Set rolls back
+// when it fails to apply a Config. The netfilter rules r adds follow from
+// it: the loopback rules for addrs and the SNAT rule, in the netfilter
+// mode's chains, and the bypass UID rules.
+type routerState struct {
+	netfilterMode    preftype.NetfilterMode
+	localRoutes      []netip.Prefix
+	routes           []netip.Prefix
+	addrs            []netip.Prefix
+	bypassUIDs       []uint32
+	snatSubnetRoutes bool
+}
+
+// currentState returns the state r has applied.
+func (r *linuxRouter) currentState() routerState {
+	return routerState{
+		netfilterMode:    r.netfilterMode,
+		localRoutes:      maps.Keys(r.localRoutes),
+		routes:           maps.Keys(r.routes),
+		addrs:            maps.Keys(r.addrs),
+		bypassUIDs:       slices.Clone(r.bypassUIDs),
+		snatSubnetRoutes: r.snatSubnetRoutes,
+	}
+}
+
+// applyState makes the state of r st, as far as possible, returning the
+// first errors it encounters.
+func (r *linuxRouter) applyState(st routerState) error {
+	var errs []error
+
+	if err := r.setNetfilterMode(st.netfilterMode); err != nil {
 		errs = append(errs, err)
 	}
 
-	newLocalRoutes, err := cidrDiff("localRoute", r.localRoutes, cfg.LocalRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
+	newLocalRoutes, err := cidrDiff("localRoute", r.localRoutes, st.localRoutes, r.addThrowRoute, r.delThrowRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
 	}
 	r.localRoutes = newLocalRoutes
 
-	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
+	newRoutes, err := cidrDiff("route", r.routes, st.routes, r.addRoute, r.delRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
 	}
 	r.routes = newRoutes
 
-	newAddrs, err := cidrDiff("addr", r.addrs, cfg.LocalAddrs, r.addAddress, r.delAddress, r.logf)
+	newAddrs, err := cidrDiff("addr", r.addrs, st.addrs, r.addAddress, r.delAddress, r.logf)
 	if err != nil {
 		errs = append(errs, err)
 	}
 	r.addrs = newAddrs
 
+	if !slices.Equal(st.bypassUIDs, r.bypassUIDs) {
+		if err := r.delBypassUIDRules(r.bypassUIDs); err != nil {
+			errs = append(errs, err)
+		}
+		r.bypassUIDs = nil
+		if err := r.addBypassUIDRules(st.bypassUIDs); err != nil {
+			errs = append(errs, err)
+		} else {
+			r.bypassUIDs = slices.Clone(st.bypassUIDs)
+		}
+	}
+
 	switch {
-	case cfg.SNATSubnetRoutes == r.snatSubnetRoutes:
+	case st.snatSubnetRoutes == r.snatSubnetRoutes:
 		// state already correct, nothing to do.
-	case cfg.SNATSubnetRoutes:
+	case st.snatSubnetRoutes:
 		if err := r.addSNATRule(); err != nil {
 			errs = append(errs, err)
+		} else {
+			r.snatSubnetRoutes = true
 		}
 	default:
 		if err := r.delSNATRule(); err != nil {
 			errs = append(errs, err)
+		} else {
+			r.snatSubnetRoutes = false
 		}
 	}
-	r.snatSubnetRoutes = cfg.SNATSubnetRoutes
 
 	return multierr.New(errs...)
 }
 
+// FirewallRuleset implements FirewallExporter. It returns
+// ErrNoFirewallRuleset unless the router is in external firewall mode.
+func (r *linuxRouter) FirewallRuleset(format string) (string, error) {
+	er, ok := r.nfr.(*linuxfw.ExportRunner)
+	if !ok {
+		return "", ErrNoFirewallRuleset
+	}
+	return er.Ruleset(format)
+}
+
+// exportFirewallRules passes the exported firewall rules to the hook
+// goroutine if they've changed since it was last called. It's a no-op
+// without a firewall hook.
+func (r *linuxRouter) exportFirewallRules() {
+	if r.hookc == nil {
+		return
+	}
+	rules, err := r.FirewallRuleset(r.firewallHookFormat)
+	if err != nil {
+		r.logf("exporting firewall rules: %v", err)
+		return
+	}
+	if rules == r.lastHookRules {
+		return
+	}
+	r.lastHookRules = rules
+	select {
+	case <-r.hookc: // superseded
+	default:
+	}
+	r.hookc <- rules
+}
+
+// firewallHookTimeout is how long the firewall hook may run.
+const firewallHookTimeout = time.Minute
+
+// runFirewallHook runs the firewall hook with each ruleset received on
+// r.hookc, until it's closed.
+func (r *linuxRouter) runFirewallHook() {
+	defer close(r.hookDone)
+	for rules := range r.hookc {
+		ctx, cancel := context.WithTimeout(context.Background(), firewallHookTimeout)
+		cmd := exec.CommandContext(ctx, r.firewallHook)
+		cmd.Stdin = strings.NewReader(rules)
+		cmd.Env = append(os.Environ(), "TS_FIREWALL_FORMAT="+r.firewallHookFormat)
+		out, err := cmd.CombinedOutput()
+		cancel()
+		if err != nil {
+			r.logf("firewall hook %s: %v; output: %s", r.firewallHook, err, out)
+		}
+	}
+}
+
 // setNetfilterMode switches the router to the given netfilter
 // mode. Netfilter state is created or deleted appropriately to
 // reflect the new mode, and r.snatSubnetRoutes is updated to reflect
 // the current state of subnet SNATing.
+//
+// If switching fails, it removes whatever Tailscale netfilter state the
+// switch left behind, leaving the router in netfilterOff mode, from which
+// it can be switched again.
 func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 	if distro.Get() == distro.Synology {
 		mode = netfilterOff
@@ -529,7 +717,32 @@ func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
 	if r.netfilterMode == mode {
 		return nil
 	}
+	if err := r.switchNetfilterMode(mode); err != nil {
+		r.resetNetfilter()
+		return err
+	}
+	return nil
+}
+
+// resetNetfilter removes all of the Tailscale netfilter chains and rules,
+// as far as possible, and records that r is in netfilterOff mode.
+func (r *linuxRouter) resetNetfilter() {
+	if err := r.nfr.DelHooks(r.logf); err != nil {
+		r.logf("note: resetting netfilter: %v", err)
+	}
+	if err := r.nfr.DelBase(); err != nil {
+		r.logf("note: resetting netfilter: %v", err)
+	}
+	if err := r.nfr.DelChains(); err != nil {
+		r.logf("note: resetting netfilter: %v", err)
+	}
+	r.netfilterMode = netfilterOff
+	r.snatSubnetRoutes = false
+}
 
+// switchNetfilterMode does the work of setNetfilterMode, switching from
+// r.netfilterMode to a different mode.
+func (r *linuxRouter) switchNetfilterMode(mode preftype.NetfilterMode) error {
 	// Depending on the netfilter mode we switch from and to, we may
 	// have created the Tailscale netfilter chains. If so, we have to
 	// go back through existing router state, and add the netfilter
@@ -1211,6 +1424,68 @@ func (r *linuxRouter) addIPRulesWithIPCommand() error {
 	return rg.ErrAcc
 }
 
+// bypassUIDRules returns the "ip rule" arguments, after the family and
+// "add" or "del", of the policy routing rules that let uid's traffic bypass
+// the exit node. They're evaluated just before the catch-all rule for the
+// Tailscale route table (pref 70): the first looks uid's packets up in that
+// table but ignores its default routes, so they still reach the tailnet, and
+// the second sends them on to the main table instead.
+//
+// These are always added with the ip command, as the netlink package we use
+// doesn't support uid ranges.
+func (r *linuxRouter) bypassUIDRules(uid uint32) [][]string {
+	uidRange := fmt.Sprintf("%d-%d", uid, uid)
+	return [][]string{
+		{
+			"pref", strconv.Itoa(r.ipPolicyPrefBase + 60),
+			"uidrange", uidRange,
+			"table", tailscaleRouteTable.ipCmdArg(),
+			"suppress_prefixlength", "0",
+		},
+		{
+			"pref", strconv.Itoa(r.ipPolicyPrefBase + 65),
+			"uidrange", uidRange,
+			"table", mainRouteTable.ipCmdArg(),
+		},
+	}
+}
+
+// addBypassUIDRules adds the policy routing rules that let the traffic of
+// the given local users bypass the exit node.
+func (r *linuxRouter) addBypassUIDRules(uids []uint32) error {
+	if len(uids) == 0 {
+		return nil
+	}
+	if !r.ipRuleAvailable {
+		return errors.New("can't bypass the exit node for users: kernel lacks policy routing")
+	}
+	rg := newRunGroup(nil, r.cmd)
+	for _, family := range r.addrFamilies() {
+		for _, uid := range uids {
+			for _, rule := range r.bypassUIDRules(uid) {
+				rg.Run(append([]string{"ip", family.dashArg(), "rule", "add"}, rule...)...)
+			}
+		}
+	}
+	return rg.ErrAcc
+}
+
+// delBypassUIDRules removes the rules added by addBypassUIDRules.
+func (r *linuxRouter) delBypassUIDRules(uids []uint32) error {
+	if len(uids) == 0 || !r.ipRuleAvailable {
+		return nil
+	}
+	rg := newRunGroup([]int{2, 254}, r.cmd)
+	for _, family := range r.addrFamilies() {
+		for _, uid := range uids {
+			for _, rule := range r.bypassUIDRules(uid) {
+				rg.Run(append([]string{"ip", family.dashArg(), "rule", "del"}, rule...)...)
+			}
+		}
+	}
+	return rg.ErrAcc
+}
+
 // delRoutes removes any local routes that we added that would not be
 // cleaned up on interface down.
 func (r *linuxRouter) delRoutes() error {
@@ -1394,7 +1669,7 @@ func normalizeCIDR(cidr netip.Prefix) string {
 // The function calls cleanup for both iptables and nftables since which ever
 // netfilter runner is used, the cleanup function for the other one doesn't do anything.
 func cleanup(logf logger.Logf, interfaceName string) {
-	if interfaceName != "userspace-networking" {
+	if interfaceName != "userspace-networking" && !externalFirewall() {
 		linuxfw.IPTablesCleanup(logf)
 		linuxfw.NfTablesCleanUp(logf)
 	}
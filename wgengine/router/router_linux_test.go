@@ -321,6 +321,37 @@ func TestRouterStates(t *testing.T) {
 ip route add throw 10.0.0.0/8 table 52
 ip route add throw 192.168.0.0/24 table 52` + basic,
 		},
+		{
+			name: "addr, routes, and users bypassing the exit node",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "0.0.0.0/0"),
+				BypassUIDs:    []uint32{105, 1001},
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 table 52
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip rule add -4 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -4 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -4 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -4 pref 5260 uidrange 1001-1001 table 52 suppress_prefixlength 0
+ip rule add -4 pref 5260 uidrange 105-105 table 52 suppress_prefixlength 0
+ip rule add -4 pref 5265 uidrange 1001-1001 table main
+ip rule add -4 pref 5265 uidrange 105-105 table main
+ip rule add -4 pref 5270 table 52
+ip rule add -6 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -6 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -6 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -6 pref 5260 uidrange 1001-1001 table 52 suppress_prefixlength 0
+ip rule add -6 pref 5260 uidrange 105-105 table 52 suppress_prefixlength 0
+ip rule add -6 pref 5265 uidrange 1001-1001 table main
+ip rule add -6 pref 5265 uidrange 105-105 table main
+ip rule add -6 pref 5270 table 52
+`,
+		},
 	}
 
 	mon, err := netmon.New(logger.Discard)
@@ -369,6 +400,8 @@ type fakeIPTablesRunner struct {
 	t    *testing.T
 	ipt4 map[string][]string
 	ipt6 map[string][]string
+	// failAddBase, if set, makes AddBase fail.
+	failAddBase bool
 	//we always assume ipv6 and ipv6 nat are enabled when testing
 }
 
@@ -454,6 +487,9 @@ func (n *fakeIPTablesRunner) AddLoopbackRule(addr netip.Addr) error {
 }
 
 func (n *fakeIPTablesRunner) AddBase(tunname string) error {
+	if n.failAddBase {
+		return errExec
+	}
 	if err := n.addBase4(tunname); err != nil {
 		return err
 	}
@@ -601,6 +637,9 @@ type fakeOS struct {
 	ips    []string
 	routes []string
 	rules  []string
+	// failAdd, if non-empty, makes adding any address, route or rule
+	// containing it fail.
+	failAdd string
 	//This test tests on the router level, so we will not bother
 	//with using iptables or nftables, chose the simpler one.
 	nfr netfilterRunner
@@ -709,6 +748,9 @@ func (o *fakeOS) run(args ...string) error {
 
 	switch args[2] {
 	case "add":
+		if o.failAdd != "" && strings.Contains(rest, o.failAdd) {
+			return errExec
+		}
 		for _, el := range *l {
 			if el == rest {
 				o.t.Errorf("can't add %q, already present", rest)
@@ -767,6 +809,122 @@ func (o *fakeOS) output(args ...string) ([]byte, error) {
 
 var tunTestNum int64
 
+func TestRouterSetRollback(t *testing.T) {
+	mon, err := netmon.New(logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.Start()
+	defer mon.Close()
+
+	fake := NewFakeOS(t)
+	router, err := newUserspaceRouterAdvanced(t.Logf, "tailscale0", mon, fake.nfr, fake)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	if err := router.Up(); err != nil {
+		t.Fatalf("failed to up router: %v", err)
+	}
+	if err := router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24"),
+		NetfilterMode: netfilterOff,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := fake.String()
+
+	fake.failAdd = "10.0.0.0/8"
+	err = router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "10.0.0.0/8", "192.168.17.0/24"),
+		NetfilterMode: netfilterOff,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Set = %v; want a rolled back error", err)
+	}
+	if got := fake.String(); got != want {
+		t.Errorf("after rollback, OS state:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Once the failure clears, the config applies.
+	fake.failAdd = ""
+	if err := router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "10.0.0.0/8"),
+		NetfilterMode: netfilterOff,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.String(); !strings.Contains(got, "10.0.0.0/8") || strings.Contains(got, "192.168.16.0/24") {
+		t.Errorf("OS state after clean Set:\n%s", got)
+	}
+	want = fake.String()
+
+	// The netfilter mode and rules are rolled back too.
+	fake.failAdd = "192.168.18.0/24"
+	err = router.Set(&Config{
+		LocalAddrs:       mustCIDRs("100.101.102.104/10"),
+		Routes:           mustCIDRs("100.100.100.100/32", "192.168.18.0/24"),
+		SNATSubnetRoutes: true,
+		NetfilterMode:    netfilterOn,
+	})
+	if err == nil || !strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Set = %v; want a rolled back error", err)
+	}
+	if got := fake.String(); got != want {
+		t.Errorf("after netfilter rollback, OS state:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A netfilter mode that fails to apply part way is cleaned up.
+	fake.failAdd = ""
+	fake.nfr.(*fakeIPTablesRunner).failAddBase = true
+	err = router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "10.0.0.0/8"),
+		NetfilterMode: netfilterOn,
+	})
+	if err == nil {
+		t.Fatal("Set succeeded; want an error from AddBase")
+	}
+	if got := fake.String(); got != want {
+		t.Errorf("after failed netfilter mode switch, OS state:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRouterFirstSetNoRollback(t *testing.T) {
+	mon, err := netmon.New(logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.Start()
+	defer mon.Close()
+
+	fake := NewFakeOS(t)
+	router, err := newUserspaceRouterAdvanced(t.Logf, "tailscale0", mon, fake.nfr, fake)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	if err := router.Up(); err != nil {
+		t.Fatalf("failed to up router: %v", err)
+	}
+
+	// With no previous config, a failed route doesn't take the node's
+	// addresses with it.
+	fake.failAdd = "10.0.0.0/8"
+	err = router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "10.0.0.0/8"),
+		NetfilterMode: netfilterOff,
+	})
+	if err == nil || strings.Contains(err.Error(), "rolled back") {
+		t.Fatalf("Set = %v; want an error without a rollback", err)
+	}
+	if got := fake.String(); !strings.Contains(got, "ip addr add 100.101.102.103/10") || !strings.Contains(got, "100.100.100.100/32") {
+		t.Errorf("OS state after first Set:\n%s", got)
+	}
+}
+
 func createTestTUN(t *testing.T) tun.Device {
 	const minimalMTU = 1280
 	tunName := fmt.Sprintf("tuntest%d", atomic.AddInt64(&tunTestNum, 1))
@@ -22,7 +22,7 @@ func mustCIDRs(ss ...string) []netip.Prefix {
 func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
 		"LocalAddrs", "Routes", "LocalRoutes", "NewMTU",
-		"SubnetRoutes", "SNATSubnetRoutes", "NetfilterMode",
+		"SubnetRoutes", "SNATSubnetRoutes", "NetfilterMode", "BypassUIDs",
 	}
 	configType := reflect.TypeOf(Config{})
 	configFields := []string{}
@@ -134,6 +134,16 @@ func TestConfigEqual(t *testing.T) {
 			&Config{NetfilterMode: preftype.NetfilterNoDivert},
 			true,
 		},
+		{
+			&Config{BypassUIDs: []uint32{1001}},
+			&Config{BypassUIDs: []uint32{1002}},
+			false,
+		},
+		{
+			&Config{BypassUIDs: []uint32{1001}},
+			&Config{BypassUIDs: []uint32{1001}},
+			true,
+		},
 		{
 			&Config{NewMTU: 0},
 			&Config{NewMTU: 0},
@@ -23,6 +23,7 @@
 	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/flowtrack"
 	"tailscale.com/net/netmon"
@@ -40,6 +41,7 @@
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netmap"
+	"tailscale.com/types/nettype"
 	"tailscale.com/types/views"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/deephash"
@@ -146,6 +148,15 @@ type userspaceEngine struct {
 	// networkLogger logs statistics about network connections.
 	networkLogger netlog.Logger
 
+	// peerTraffic is the recent traffic history of each peer.
+	peerTraffic peerTrafficHistory
+
+	// connStatsMu guards the connection statistics installed in tundev
+	// and magicConn; see connstats.go.
+	connStatsMu   sync.Mutex
+	netlogStats   *connstats.Statistics                // of networkLogger, or nil
+	connStatsSubs set.HandleSet[*connstats.Statistics] // of SubscribeConnStats
+
 	// Lock ordering: magicsock.Conn.mu, wgLock, then mu.
 }
 
@@ -187,6 +198,12 @@ type Config struct {
 	// If zero, a port is automatically selected.
 	ListenPort uint16
 
+	// PacketListener optionally specifies how to open the UDP sockets
+	// for WireGuard and peer-to-peer traffic, such as through a
+	// simulated network in tests.
+	// If nil, the OS network stack is used.
+	PacketListener nettype.PacketListener
+
 	// RespondToPing determines whether this engine should internally
 	// reply to ICMP pings, without involving the OS.
 	// Used in "fake" mode for development.
@@ -326,6 +343,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		IdleFunc:         e.tundev.IdleDuration,
 		NoteRecvActivity: e.noteRecvActivity,
 		NetMon:           e.netMon,
+
+		TestOnlyPacketListener: conf.PacketListener,
 	}
 
 	var err error
@@ -433,6 +452,8 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 		conf.SetSubsystem(e.netMon)
 	}
 
+	go e.samplePeerTraffic()
+
 	e.logf("Engine created.")
 	return e, nil
 }
@@ -890,7 +911,7 @@ func (e *userspaceEngine) Reconfig(cfg *wgcfg.Config, routerCfg *router.Config,
 		nid := cfg.NetworkLogging.NodeID
 		tid := cfg.NetworkLogging.DomainID
 		e.logf("wgengine: Reconfig: starting up network logger (node:%s tailnet:%s)", nid.Public(), tid.Public())
-		if err := e.networkLogger.Startup(cfg.NodeID, nid, tid, e.tundev, e.magicConn, e.netMon); err != nil {
+		if err := e.networkLogger.Startup(cfg.NodeID, nid, tid, connStatsDevices{e}, connStatsDevices{e}, e.netMon); err != nil {
 			e.logf("wgengine: Reconfig: error starting up network logger: %v", err)
 		}
 		e.networkLogger.ReconfigRoutes(routerCfg)
@@ -1147,6 +1168,7 @@ func (e *userspaceEngine) linkChange(delta *netmon.ChangeDelta) {
 	} else {
 		metricNumMinorChanges.Add(1)
 	}
+	e.magicConn.NoteLinkChange(changed)
 	e.magicConn.ReSTUN(why)
 }
 
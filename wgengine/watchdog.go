@@ -16,6 +16,7 @@
 
 	"tailscale.com/envknob"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/dns"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
@@ -134,6 +135,10 @@ func (e *watchdogEngine) SetStatusCallback(cb StatusCallback) {
 func (e *watchdogEngine) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	e.watchdog("UpdateStatus", func() { e.wrap.UpdateStatus(sb) })
 }
+func (e *watchdogEngine) PeerTraffic() (ret []ipnstate.PeerTraffic) {
+	e.watchdog("PeerTraffic", func() { ret = e.wrap.PeerTraffic() })
+	return ret
+}
 func (e *watchdogEngine) SetNetInfoCallback(cb NetInfoCallback) {
 	e.watchdog("SetNetInfoCallback", func() { e.wrap.SetNetInfoCallback(cb) })
 }
@@ -186,3 +191,8 @@ func (e *watchdogEngine) Wait() {
 func (e *watchdogEngine) InstallCaptureHook(cb capture.Callback) {
 	e.wrap.InstallCaptureHook(cb)
 }
+
+func (e *watchdogEngine) SubscribeConnStats(opts connstats.SubscribeOptions, fn func(connstats.Snapshot)) (unsubscribe func()) {
+	e.watchdog("SubscribeConnStats", func() { unsubscribe = e.wrap.SubscribeConnStats(opts, fn) })
+	return unsubscribe
+}
@@ -10,12 +10,14 @@
 	"net/netip"
 	"strings"
 
+	"tailscale.com/appc"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/logid"
 	"tailscale.com/types/netmap"
 	"tailscale.com/types/views"
+	"tailscale.com/util/mak"
 	"tailscale.com/wgengine/wgcfg"
 )
 
@@ -51,6 +53,28 @@ func cidrIsSubnet(node tailcfg.NodeView, cidr netip.Prefix) bool {
 	return true
 }
 
+// sharedRouteOwners returns, for each subnet route that is offered by more
+// than one peer (as happens with multiple app connectors serving the same
+// domains), the peer that should carry traffic for it.
+func sharedRouteOwners(nm *netmap.NetworkMap) map[netip.Prefix]tailcfg.StableNodeID {
+	var offeredBy map[netip.Prefix][]tailcfg.NodeView
+	for _, peer := range nm.Peers {
+		for i := range peer.AllowedIPs().LenIter() {
+			allowedIP := peer.AllowedIPs().At(i)
+			if cidrIsSubnet(peer, allowedIP) {
+				mak.Set(&offeredBy, allowedIP, append(offeredBy[allowedIP], peer))
+			}
+		}
+	}
+	var owners map[netip.Prefix]tailcfg.StableNodeID
+	for pfx, peers := range offeredBy {
+		if len(peers) > 1 {
+			mak.Set(&owners, pfx, peers[appc.PickConnector(pfx, peers)].StableID())
+		}
+	}
+	return owners
+}
+
 // WGCfg returns the NetworkMaps's WireGuard configuration.
 func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags, exitNode tailcfg.StableNodeID) (*wgcfg.Config, error) {
 	cfg := &wgcfg.Config{
@@ -84,7 +108,9 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 	skippedUnselected := new(bytes.Buffer)
 	skippedIPs := new(bytes.Buffer)
 	skippedSubnets := new(bytes.Buffer)
+	var numShared int
 
+	routeOwners := sharedRouteOwners(nm)
 	for _, peer := range nm.Peers {
 		if peer.DiscoKey().IsZero() && peer.DERP() == "" && !peer.IsWireGuardOnly() {
 			// Peer predates both DERP and active discovery, we cannot
@@ -127,6 +153,14 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 					fmt.Fprintf(skippedSubnets, "%v from %q (%v)", allowedIP, nodeDebugName(peer), peer.Key().ShortString())
 					continue
 				}
+				if owner, ok := routeOwners[allowedIP]; ok {
+					if owner != peer.StableID() {
+						// Another peer offering the same route was picked
+						// to carry traffic for it.
+						continue
+					}
+					numShared++
+				}
 			}
 			cpeer.AllowedIPs = append(cpeer.AllowedIPs, allowedIP)
 		}
@@ -141,6 +175,9 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 	if skippedSubnets.Len() > 0 {
 		logf("[v1] wgcfg: did not accept subnet routes: %s", skippedSubnets)
 	}
+	if numShared > 0 {
+		logf("[v1] wgcfg: load-shared %d routes offered by multiple peers", numShared)
+	}
 
 	return cfg, nil
 }
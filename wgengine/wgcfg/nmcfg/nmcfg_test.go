@@ -0,0 +1,58 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nmcfg
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func TestWGCfgSharedRoutes(t *testing.T) {
+	shared := netip.MustParsePrefix("192.0.2.1/32")
+	only := netip.MustParsePrefix("198.51.100.0/24")
+
+	var nm netmap.NetworkMap
+	for i := 1; i <= 3; i++ {
+		n := &tailcfg.Node{
+			ID:        tailcfg.NodeID(i),
+			StableID:  tailcfg.StableNodeID(fmt.Sprintf("n%d", i)),
+			Key:       key.NewNode().Public(),
+			DiscoKey:  key.NewDisco().Public(),
+			Addresses: []netip.Prefix{netip.PrefixFrom(netip.AddrFrom4([4]byte{100, 64, 0, byte(i)}), 32)},
+		}
+		n.AllowedIPs = append(n.AllowedIPs, n.Addresses...)
+		n.AllowedIPs = append(n.AllowedIPs, shared)
+		if i == 1 {
+			n.AllowedIPs = append(n.AllowedIPs, only)
+		}
+		nm.Peers = append(nm.Peers, n.View())
+	}
+
+	cfg, err := WGCfg(&nm, t.Logf, netmap.AllowSingleHosts|netmap.AllowSubnetRoutes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sharedCount, onlyCount int
+	for _, p := range cfg.Peers {
+		for _, pfx := range p.AllowedIPs {
+			switch pfx {
+			case shared:
+				sharedCount++
+			case only:
+				onlyCount++
+			}
+		}
+	}
+	if sharedCount != 1 {
+		t.Errorf("shared route assigned to %d peers; want 1", sharedCount)
+	}
+	if onlyCount != 1 {
+		t.Errorf("unshared route assigned to %d peers; want 1", onlyCount)
+	}
+}
@@ -9,6 +9,7 @@
 	"time"
 
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/dns"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
@@ -148,6 +149,10 @@ type Engine interface {
 	// status builder.
 	UpdateStatus(*ipnstate.StatusBuilder)
 
+	// PeerTraffic returns the traffic exchanged with each peer over the
+	// last hour, in one-minute buckets.
+	PeerTraffic() []ipnstate.PeerTraffic
+
 	// Ping is a request to start a ping of the given message size to the peer
 	// handling the given IP, then call cb with its ping latency & method.
 	//
@@ -173,4 +178,8 @@ type Engine interface {
 	// packets traversing the data path. The hook can be uninstalled by
 	// calling this function with a nil value.
 	InstallCaptureHook(capture.Callback)
+
+	// SubscribeConnStats registers fn to be called with the per-connection
+	// statistics of the data path, per opts, until unsubscribe is called.
+	SubscribeConnStats(opts connstats.SubscribeOptions, fn func(connstats.Snapshot)) (unsubscribe func())
 }